@@ -0,0 +1,46 @@
+// Package accountgrant records the grants an account gets only once, such as the newcomer boosts. The sign
+// server shows when they run out and the channel servers hand them out, so both read them from here.
+package accountgrant
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+	"github.com/jmoiron/sqlx"
+)
+
+// NewcomerKey is the grant key recorded when the newcomer boosts are handed out.
+// Admin grants of the starter pack should record the same key so it is never given twice.
+const NewcomerKey = "newcomer"
+
+// Claim records a one-time grant for an account.
+// It returns false if the grant had already been claimed.
+func Claim(db sqlx.Execer, userID uint32, grantKey string) (bool, error) {
+	res, err := db.Exec(`
+		INSERT INTO account_grants (user_id, grant_key)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, userID, grantKey)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// NewcomerBoostExpiry returns when the account's newcomer boosts run out,
+// or the zero time if they were never granted.
+func NewcomerBoostExpiry(db sqlx.Queryer, cfg config.Newcomer, userID uint32) (time.Time, error) {
+	var grantedAt time.Time
+	err := db.QueryRowx("SELECT granted_at FROM account_grants WHERE user_id = $1 AND grant_key = $2", userID, NewcomerKey).Scan(&grantedAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return grantedAt.Add(time.Duration(cfg.BoostDays) * 24 * time.Hour), nil
+}
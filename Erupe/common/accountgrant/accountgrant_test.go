@@ -0,0 +1,39 @@
+package accountgrant
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/common/testdb"
+	"github.com/Solenataris/Erupe/config"
+)
+
+// TestClaim runs against the database in ERUPE_TEST_DB.
+func TestClaim(t *testing.T) {
+	db := testdb.Open(t)
+	var userID uint32
+	if err := db.QueryRow("INSERT INTO users (username, password) VALUES ('account_grant_test', '') RETURNING id").Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+	defer db.Exec("DELETE FROM account_grants WHERE user_id = $1", userID)
+	cfg := config.Newcomer{BoostDays: 7}
+
+	if expiry, err := NewcomerBoostExpiry(db, cfg, userID); err != nil || !expiry.IsZero() {
+		t.Errorf("an account without the grant expires at %v, %v", expiry, err)
+	}
+	if claimed, err := Claim(db, userID, NewcomerKey); err != nil || !claimed {
+		t.Fatalf("the first claim got %v, %v", claimed, err)
+	}
+	if claimed, err := Claim(db, userID, NewcomerKey); err != nil || claimed {
+		t.Errorf("the second claim got %v, %v", claimed, err)
+	}
+	if claimed, err := Claim(db, userID, "starter-weapons"); err != nil || !claimed {
+		t.Errorf("another grant's claim got %v, %v", claimed, err)
+	}
+
+	expiry, err := NewcomerBoostExpiry(db, cfg, userID)
+	if want := time.Now().Add(7 * 24 * time.Hour); err != nil || expiry.Before(want.Add(-time.Minute)) || expiry.After(want.Add(time.Minute)) {
+		t.Errorf("boosts granted now expire at %v, %v, want about %v", expiry, err, want)
+	}
+}
@@ -1,8 +1,131 @@
 package bfutil
 
-import "bytes"
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/common/stringsupport"
+)
+
+// ErrShortRead is reading past the end of a frame, such as a malformed packet claiming more data than it has.
+var ErrShortRead = errors.New("read past the end of the frame")
 
 // UpToNull returns the given byte slice's data, up to (not including) the first null byte.
 func UpToNull(data []byte) []byte {
 	return bytes.SplitN(data, []byte{0x00}, 2)[0]
 }
+
+// Remaining returns how many bytes are left to read in the frame.
+func Remaining(bf *byteframe.ByteFrame) int {
+	// The frame's own reads may go a byte past its end, which DataFromCurrent would panic on.
+	index, _ := bf.Seek(0, io.SeekCurrent)
+	if left := len(bf.Data()) - int(index); left > 0 {
+		return left
+	}
+	return 0
+}
+
+// The TryRead functions read as their ByteFrame methods do, but return ErrShortRead and read nothing
+// when the frame has fewer bytes left than the value needs, where the methods panic or read garbage.
+
+func TryReadUint8(bf *byteframe.ByteFrame) (uint8, error) {
+	if Remaining(bf) < 1 {
+		return 0, ErrShortRead
+	}
+	return bf.ReadUint8(), nil
+}
+
+func TryReadBool(bf *byteframe.ByteFrame) (bool, error) {
+	if Remaining(bf) < 1 {
+		return false, ErrShortRead
+	}
+	return bf.ReadBool(), nil
+}
+
+func TryReadUint16(bf *byteframe.ByteFrame) (uint16, error) {
+	if Remaining(bf) < 2 {
+		return 0, ErrShortRead
+	}
+	return bf.ReadUint16(), nil
+}
+
+func TryReadUint32(bf *byteframe.ByteFrame) (uint32, error) {
+	if Remaining(bf) < 4 {
+		return 0, ErrShortRead
+	}
+	return bf.ReadUint32(), nil
+}
+
+func TryReadUint64(bf *byteframe.ByteFrame) (uint64, error) {
+	if Remaining(bf) < 8 {
+		return 0, ErrShortRead
+	}
+	return bf.ReadUint64(), nil
+}
+
+func TryReadInt32(bf *byteframe.ByteFrame) (int32, error) {
+	if Remaining(bf) < 4 {
+		return 0, ErrShortRead
+	}
+	return bf.ReadInt32(), nil
+}
+
+func TryReadFloat32(bf *byteframe.ByteFrame) (float32, error) {
+	if Remaining(bf) < 4 {
+		return 0, ErrShortRead
+	}
+	return bf.ReadFloat32(), nil
+}
+
+// TryReadBytes reads size bytes, such as a length the client sent, checking the frame has them.
+func TryReadBytes(bf *byteframe.ByteFrame, size uint) ([]byte, error) {
+	if uint(Remaining(bf)) < size {
+		return nil, ErrShortRead
+	}
+	return bf.ReadBytes(size), nil
+}
+
+// TryReadNullTerminatedBytes reads bytes up to a null terminator, which the frame must have.
+func TryReadNullTerminatedBytes(bf *byteframe.ByteFrame) ([]byte, error) {
+	if Remaining(bf) == 0 || bytes.IndexByte(bf.DataFromCurrent(), 0x00) < 0 {
+		return nil, ErrShortRead
+	}
+	return bf.ReadNullTerminatedBytes(), nil
+}
+
+// ErrStringTooLong is a string read from the frame longer than the field it's for allows.
+var ErrStringTooLong = errors.New("string longer than its field allows")
+
+// ReadShiftJISString reads a Shift-JIS string in a field of size bytes, such as a length the client sent,
+// up to its null terminator if it has one. The string must fit in maxBytes.
+func ReadShiftJISString(bf *byteframe.ByteFrame, size uint, maxBytes int) (string, error) {
+	data, err := TryReadBytes(bf, size)
+	if err != nil {
+		return "", err
+	}
+	if len(UpToNull(data)) > maxBytes {
+		return "", ErrStringTooLong
+	}
+	return stringsupport.DecodeShiftJIS(data), nil
+}
+
+// ReadNullTerminatedShiftJISString reads a Shift-JIS string up to its null terminator, which must come
+// within maxBytes.
+func ReadNullTerminatedShiftJISString(bf *byteframe.ByteFrame, maxBytes int) (string, error) {
+	data, err := TryReadNullTerminatedBytes(bf)
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxBytes {
+		return "", ErrStringTooLong
+	}
+	return stringsupport.DecodeShiftJIS(data), nil
+}
+
+// WriteShiftJISString writes the text null terminated in Shift-JIS, cut to maxBytes before the terminator
+// as stringsupport.EncodeShiftJIS does.
+func WriteShiftJISString(bf *byteframe.ByteFrame, text string, maxBytes int) {
+	bf.WriteNullTerminatedBytes(stringsupport.EncodeShiftJIS(text, maxBytes))
+}
@@ -0,0 +1,95 @@
+package bfutil
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Andoryuuta/byteframe"
+)
+
+func TestTryRead(t *testing.T) {
+	bf := byteframe.NewByteFrameFromBytes([]byte{0x01, 0x00, 0x02, 0x00, 0x00, 0x00, 0x03, 'a', 'b', 0x00, 0xFF})
+	if v, err := TryReadUint8(bf); v != 1 || err != nil {
+		t.Errorf("TryReadUint8 = %d, %v", v, err)
+	}
+	if v, err := TryReadUint16(bf); v != 2 || err != nil {
+		t.Errorf("TryReadUint16 = %d, %v", v, err)
+	}
+	if v, err := TryReadUint32(bf); v != 3 || err != nil {
+		t.Errorf("TryReadUint32 = %d, %v", v, err)
+	}
+	if v, err := TryReadNullTerminatedBytes(bf); !bytes.Equal(v, []byte("ab")) || err != nil {
+		t.Errorf("TryReadNullTerminatedBytes = %q, %v", v, err)
+	}
+	if n := Remaining(bf); n != 1 {
+		t.Fatalf("%d bytes remaining, want 1", n)
+	}
+
+	// A read the frame hasn't got the bytes for fails and leaves them to read.
+	if _, err := TryReadUint16(bf); err != ErrShortRead {
+		t.Errorf("TryReadUint16 past the end got %v, want %v", err, ErrShortRead)
+	}
+	if _, err := TryReadBytes(bf, 2); err != ErrShortRead {
+		t.Errorf("TryReadBytes past the end got %v, want %v", err, ErrShortRead)
+	}
+	if _, err := TryReadNullTerminatedBytes(bf); err != ErrShortRead {
+		t.Errorf("TryReadNullTerminatedBytes without a terminator got %v, want %v", err, ErrShortRead)
+	}
+	if v, err := TryReadBytes(bf, 1); !bytes.Equal(v, []byte{0xFF}) || err != nil {
+		t.Errorf("TryReadBytes = %v, %v", v, err)
+	}
+	if n := Remaining(bf); n != 0 {
+		t.Errorf("%d bytes remaining, want none", n)
+	}
+	if _, err := TryReadUint8(bf); err != ErrShortRead {
+		t.Errorf("TryReadUint8 at the end got %v, want %v", err, ErrShortRead)
+	}
+}
+
+func TestRemainingPastEnd(t *testing.T) {
+	// The frame's own ReadUint16 lets a read end a byte past the data.
+	bf := byteframe.NewByteFrame()
+	bf.WriteUint8(0x01)
+	bf.Seek(0, 0)
+	bf.ReadUint16()
+	if n := Remaining(bf); n != 0 {
+		t.Errorf("%d bytes remaining, want none", n)
+	}
+	if _, err := TryReadNullTerminatedBytes(bf); err != ErrShortRead {
+		t.Errorf("got %v, want %v", err, ErrShortRead)
+	}
+}
+
+func TestShiftJISStrings(t *testing.T) {
+	bf := byteframe.NewByteFrame()
+	WriteShiftJISString(bf, "ハンター", 64)
+	WriteShiftJISString(bf, "ｱｲｳｴｵ", 3)
+	bf.WriteBytes([]byte{'a', 'b', 0x00, 0x00})
+	bf.Seek(0, 0)
+
+	if v, err := ReadNullTerminatedShiftJISString(bf, 8); v != "ハンター" || err != nil {
+		t.Errorf("ReadNullTerminatedShiftJISString = %q, %v", v, err)
+	}
+	if v, err := ReadNullTerminatedShiftJISString(bf, 3); v != "ｱｲｳ" || err != nil {
+		t.Errorf("ReadNullTerminatedShiftJISString of the cut text = %q, %v", v, err)
+	}
+	// A fixed size field, read up to the terminator within it.
+	if v, err := ReadShiftJISString(bf, 4, 2); v != "ab" || err != nil {
+		t.Errorf("ReadShiftJISString = %q, %v", v, err)
+	}
+}
+
+func TestShiftJISStringTooLong(t *testing.T) {
+	bf := byteframe.NewByteFrameFromBytes([]byte{0x83, 0x6E, 0x83, 0x93, 0x00})
+	if _, err := ReadNullTerminatedShiftJISString(bf, 3); err != ErrStringTooLong {
+		t.Errorf("got %v, want %v", err, ErrStringTooLong)
+	}
+	bf = byteframe.NewByteFrameFromBytes([]byte{'a', 'b', 'c'})
+	if _, err := ReadShiftJISString(bf, 3, 2); err != ErrStringTooLong {
+		t.Errorf("got %v, want %v", err, ErrStringTooLong)
+	}
+	bf = byteframe.NewByteFrameFromBytes([]byte{'a', 'b'})
+	if _, err := ReadShiftJISString(bf, 3, 8); err != ErrShortRead {
+		t.Errorf("got %v, want %v", err, ErrShortRead)
+	}
+}
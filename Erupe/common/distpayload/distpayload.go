@@ -0,0 +1,142 @@
+// Package distpayload builds and checks the item payloads stored in the distribution table's data column,
+// which the client reads back as is when a distribution is claimed.
+package distpayload
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Andoryuuta/byteframe"
+)
+
+// TypeItem is the item type of plain items, the only type known well enough to check.
+const TypeItem uint8 = 7
+
+// Each item is its type, flags, ID and quantity.
+const itemSize = 1 + 4 + 2 + 2
+
+// MaxItems is the most items a payload can hold, its count is a single byte.
+const MaxItems = 255
+
+// idRange is the IDs known to be valid for an item type.
+type idRange struct {
+	min uint16
+	max uint16
+}
+
+// knownIDs bounds the IDs of each type. They're generous upper bounds that catch typos and byte-swapped
+// IDs before a payload reaches a client, not a list of every item that exists.
+var knownIDs = map[uint8]idRange{
+	TypeItem: {1, 0x3FFF},
+}
+
+// Item is a single entry in a payload.
+type Item struct {
+	Type     uint8
+	Flags    uint32 // Unknown, always 0 in the payloads the server sends.
+	ID       uint16
+	Quantity uint16
+}
+
+func (i Item) String() string {
+	return fmt.Sprintf("%d:%dx%d", i.Type, i.ID, i.Quantity)
+}
+
+// Validate reports the first item a client would choke on: an unknown type, an ID outside the
+// known range or no quantity. Payloads with no items or more than MaxItems are refused too.
+func Validate(items []Item) error {
+	if len(items) == 0 {
+		return errors.New("a payload needs at least one item")
+	}
+	if len(items) > MaxItems {
+		return fmt.Errorf("a payload holds at most %d items, got %d", MaxItems, len(items))
+	}
+	for n, item := range items {
+		ids, ok := knownIDs[item.Type]
+		if !ok {
+			return fmt.Errorf("item %d (%s): unknown item type %d", n+1, item, item.Type)
+		}
+		if item.ID < ids.min || item.ID > ids.max {
+			return fmt.Errorf("item %d (%s): ID %d is outside %d-%d for type %d", n+1, item, item.ID, ids.min, ids.max, item.Type)
+		}
+		if item.Quantity == 0 {
+			return fmt.Errorf("item %d (%s): quantity must be at least 1", n+1, item)
+		}
+	}
+	return nil
+}
+
+// Build validates the items and serializes them in the format the client expects.
+func Build(items []Item) ([]byte, error) {
+	if err := Validate(items); err != nil {
+		return nil, err
+	}
+	return Encode(items), nil
+}
+
+// Encode serializes the items without validating them, for payloads the server builds from its own config.
+// Entries past MaxItems are dropped.
+func Encode(items []Item) []byte {
+	if len(items) > MaxItems {
+		items = items[:MaxItems]
+	}
+	bf := byteframe.NewByteFrame()
+	bf.WriteUint8(uint8(len(items)))
+	for _, item := range items {
+		bf.WriteUint8(item.Type)
+		bf.WriteUint32(item.Flags)
+		bf.WriteUint16(item.ID)
+		bf.WriteUint16(item.Quantity)
+	}
+	return bf.Data()
+}
+
+// Parse reads a payload back into its items. The payload must be exactly as long as its count says.
+func Parse(data []byte) ([]Item, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty payload")
+	}
+	count := int(data[0])
+	if want := 1 + count*itemSize; len(data) != want {
+		return nil, fmt.Errorf("payload of %d items should be %d bytes, got %d", count, want, len(data))
+	}
+	bf := byteframe.NewByteFrameFromBytes(data[1:])
+	items := make([]Item, count)
+	for n := range items {
+		items[n].Type = bf.ReadUint8()
+		items[n].Flags = bf.ReadUint32()
+		items[n].ID = bf.ReadUint16()
+		items[n].Quantity = bf.ReadUint16()
+	}
+	return items, nil
+}
+
+// ParseItem reads an item written as "ID x quantity", such as "1234x5", optionally prefixed
+// with its type as in "7:1234x5". Without a type it's TypeItem.
+func ParseItem(s string) (Item, error) {
+	item := Item{Type: TypeItem}
+	if colon := strings.IndexByte(s, ':'); colon >= 0 {
+		itemType, err := strconv.ParseUint(s[:colon], 10, 8)
+		if err != nil {
+			return Item{}, fmt.Errorf("invalid item type in %q", s)
+		}
+		item.Type = uint8(itemType)
+		s = s[colon+1:]
+	}
+	parts := strings.Split(strings.ToLower(s), "x")
+	if len(parts) != 2 {
+		return Item{}, fmt.Errorf("expected ID x quantity, such as 1234x5, got %q", s)
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return Item{}, fmt.Errorf("invalid item ID in %q", s)
+	}
+	quantity, err := strconv.ParseUint(parts[1], 10, 16)
+	if err != nil {
+		return Item{}, fmt.Errorf("invalid quantity in %q", s)
+	}
+	item.ID, item.Quantity = uint16(id), uint16(quantity)
+	return item, nil
+}
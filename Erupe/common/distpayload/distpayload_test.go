@@ -0,0 +1,115 @@
+package distpayload
+
+import (
+	"bytes"
+	"encoding/hex"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Solenataris/Erupe/common/fixtures"
+)
+
+// capturedDir holds payloads captured from a client's MsgMhfApplyDistItem responses, one fixture each,
+// cut from the ack data of a capture imported with "erupe fixtures import".
+const capturedDir = "distpayload"
+
+func TestCapturedPayloadsRoundTrip(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join(fixtures.Dir(), capturedDir, "*"+fixtures.Extension))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Skipf("no captured payloads in fixtures/%s", capturedDir)
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), fixtures.Extension)
+		data, err := fixtures.Load(capturedDir + "/" + name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		items, err := Parse(data)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if err := Validate(items); err != nil {
+			t.Errorf("%s: a captured payload doesn't validate: %v", name, err)
+		}
+		built, err := Build(items)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(built, data) {
+			t.Errorf("%s: rebuilt %x, want %x", name, built, data)
+		}
+	}
+}
+
+func TestBuildParse(t *testing.T) {
+	items := []Item{{Type: TypeItem, ID: 0x0672, Quantity: 10}, {Type: TypeItem, Flags: 1, ID: 999, Quantity: 1}}
+	built, err := Build(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(built) != 1+len(items)*itemSize || built[0] != byte(len(items)) {
+		t.Fatalf("built %x", built)
+	}
+	parsed, err := Parse(built)
+	if err != nil || !reflect.DeepEqual(parsed, items) {
+		t.Errorf("parsed %v, %v, want %v", parsed, err, items)
+	}
+}
+
+func TestParseRejectsMalformed(t *testing.T) {
+	for _, payload := range []string{"", "01", "0107000000000004d2", "01070000000004d20005ff", "02070000000004d20005"} {
+		data, _ := hex.DecodeString(payload)
+		if _, err := Parse(data); err == nil {
+			t.Errorf("%q parsed without an error", payload)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []Item
+		ok    bool
+	}{
+		{"valid", []Item{{Type: TypeItem, ID: 1, Quantity: 1}}, true},
+		{"empty", nil, false},
+		{"unknown type", []Item{{Type: 99, ID: 1, Quantity: 1}}, false},
+		{"no ID", []Item{{Type: TypeItem, ID: 0, Quantity: 1}}, false},
+		{"byte-swapped ID", []Item{{Type: TypeItem, ID: 0xD204, Quantity: 1}}, false},
+		{"no quantity", []Item{{Type: TypeItem, ID: 1}}, false},
+		{"too many", make([]Item, MaxItems+1), false},
+	}
+	for _, tt := range tests {
+		if err := Validate(tt.items); (err == nil) != tt.ok {
+			t.Errorf("%s: got %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestParseItem(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Item
+		ok   bool
+	}{
+		{"1234x5", Item{Type: TypeItem, ID: 1234, Quantity: 5}, true},
+		{"999X1", Item{Type: TypeItem, ID: 999, Quantity: 1}, true},
+		{"7:12x3", Item{Type: TypeItem, ID: 12, Quantity: 3}, true},
+		{"1234", Item{}, false},
+		{"1234x", Item{}, false},
+		{"70000x1", Item{}, false},
+		{"a:1x1", Item{}, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseItem(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("%q: got %v, %v, want %v, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
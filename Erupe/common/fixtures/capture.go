@@ -0,0 +1,127 @@
+package fixtures
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/Solenataris/Erupe/common/stringsupport"
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/crypto"
+)
+
+// Server kinds, which decide how responses are named and what's scrubbed from them.
+const (
+	KindChannel  = "channel"
+	KindSign     = "sign"
+	KindEntrance = "entrance"
+)
+
+// The key rotation both ends of an encrypted connection start from, as in network.NewCryptConn.
+const initialKeyRot = 995117
+
+// Response is a packet the server sent, decrypted.
+type Response struct {
+	Kind string
+	Flow int // Index of the connection in the capture.
+	N    int // Index of the packet on its connection.
+	Data []byte
+}
+
+// Name is where the response is stored below the fixtures directory, in the given subdirectory.
+// Channel responses are named after the opcode they start with.
+func (r Response) Name(dir string) string {
+	name := fmt.Sprintf("%s/%s/%03d_%04d", dir, r.Kind, r.Flow, r.N)
+	if r.Kind == KindChannel && len(r.Data) >= 2 {
+		name += "_" + network.PacketID(uint16(r.Data[0])<<8|uint16(r.Data[1])).String()
+	}
+	return name
+}
+
+// DecryptStream splits the bytes a server sent over an encrypted connection into its packets and decrypts them.
+// Whatever decrypted before an error is returned along with it.
+func DecryptStream(data []byte) ([][]byte, error) {
+	var packets [][]byte
+	keyRot := uint32(initialKeyRot)
+	for len(data) > 0 {
+		if len(data) < network.CryptPacketHeaderLength {
+			return packets, errors.New("stream ends inside a packet header")
+		}
+		header, err := network.NewCryptPacketHeader(data[:network.CryptPacketHeaderLength])
+		if err != nil {
+			return packets, err
+		}
+		data = data[network.CryptPacketHeaderLength:]
+		if len(data) < int(header.DataSize) {
+			return packets, errors.New("stream ends inside a packet")
+		}
+		if header.KeyRotDelta != 0 {
+			keyRot = uint32(header.KeyRotDelta) * (keyRot + 1)
+		}
+		out, _, check0, check1, check2 := crypto.Decrypt(data[:header.DataSize], keyRot, nil)
+		if check0 != header.Check0 || check1 != header.Check1 || check2 != header.Check2 {
+			return packets, fmt.Errorf("packet %d doesn't decrypt, the capture may have started mid-connection", len(packets))
+		}
+		packets = append(packets, out)
+		data = data[header.DataSize:]
+	}
+	return packets, nil
+}
+
+// Extract decrypts the responses sent from the given server ports. Connections the capture
+// didn't see start from the first byte can't be decrypted and are reported in the errors.
+func Extract(flows []*Flow, ports map[int]string) ([]Response, []error) {
+	var responses []Response
+	var errs []error
+	for i, flow := range flows {
+		kind, ok := ports[flow.Src.Port]
+		if !ok || len(flow.Data) == 0 {
+			continue
+		}
+		packets, err := DecryptStream(flow.Data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("connection %d from %s: %w", i, flow.Src.String(), err))
+		}
+		for n, packet := range packets {
+			responses = append(responses, Response{kind, i, n, packet})
+		}
+	}
+	return responses, errs
+}
+
+// Sanitize blanks what a response shouldn't carry into the repository: the sign in token of sign
+// responses, and every given string, such as character and account names, in UTF-8 and Shift-JIS.
+// The response keeps its length so its layout is unchanged.
+func Sanitize(r Response, redact []string) []byte {
+	data := append([]byte(nil), r.Data...)
+	// A successful sign in starts with its result, three counts, the token number and the 16 byte token.
+	if r.Kind == KindSign && len(data) >= 24 && data[0] == 1 {
+		blank(data[4:24])
+	}
+	for _, s := range redact {
+		if s == "" {
+			continue
+		}
+		forms := [][]byte{[]byte(s)}
+		if sjis, err := stringsupport.ConvertUTF8ToShiftJIS(s); err == nil && !bytes.Equal(sjis, forms[0]) {
+			forms = append(forms, sjis)
+		}
+		for _, form := range forms {
+			for i := 0; ; {
+				j := bytes.Index(data[i:], form)
+				if j < 0 {
+					break
+				}
+				blank(data[i+j : i+j+len(form)])
+				i += j + len(form)
+			}
+		}
+	}
+	return data
+}
+
+func blank(b []byte) {
+	for i := range b {
+		b[i] = '*'
+	}
+}
@@ -0,0 +1,154 @@
+// Package fixtures keeps the server responses the serialization tests compare the response builders
+// against, and imports responses captured from real clients alongside them.
+//
+// Most fixtures are snapshots the tests wrote themselves with UpdateEnv set: they catch a layout
+// changing by accident, not a layout that was wrong to begin with. Only responses imported from a
+// capture show what the client was actually sent.
+//
+// Fixtures are hex files in the fixtures directory at the root of the module, 32 bytes to a line.
+// Lines starting with # describe the fixture and are ignored when it's read.
+package fixtures
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// UpdateEnv is the environment variable that makes Compare write the fixtures instead of checking them.
+// Run the tests with it set to 1 after a deliberate layout change and review the fixture diff.
+const UpdateEnv = "ERUPE_UPDATE_FIXTURES"
+
+// Extension of fixture files.
+const Extension = ".hex"
+
+// Bytes on each line of a fixture file.
+const lineBytes = 32
+
+// Dir returns the fixtures directory of the module.
+func Dir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "fixtures")
+}
+
+// Encode writes data as a fixture file, headed by the comment lines.
+func Encode(data []byte, comments ...string) []byte {
+	var b bytes.Buffer
+	for _, comment := range comments {
+		for _, line := range strings.Split(comment, "\n") {
+			fmt.Fprintf(&b, "# %s\n", line)
+		}
+	}
+	for i := 0; i < len(data); i += lineBytes {
+		end := i + lineBytes
+		if end > len(data) {
+			end = len(data)
+		}
+		fmt.Fprintf(&b, "%s\n", hex.EncodeToString(data[i:end]))
+	}
+	return b.Bytes()
+}
+
+// Decode reads the data back from a fixture file.
+func Decode(file []byte) ([]byte, error) {
+	var data []byte
+	scanner := bufio.NewScanner(bytes.NewReader(file))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		b, err := hex.DecodeString(strings.Join(strings.Fields(line), ""))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		data = append(data, b...)
+	}
+	return data, scanner.Err()
+}
+
+// Load reads the named fixture, a slash separated path in the fixtures directory without its extension.
+func Load(name string) ([]byte, error) {
+	file, err := os.ReadFile(path(name))
+	if err != nil {
+		return nil, err
+	}
+	return Decode(file)
+}
+
+// Write stores data as the named fixture.
+func Write(name string, data []byte, comments ...string) error {
+	p := path(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, Encode(data, comments...), 0644)
+}
+
+func path(name string) string {
+	return filepath.Join(Dir(), filepath.FromSlash(name)+Extension)
+}
+
+// Compare fails the test unless got is byte for byte the named fixture. With UpdateEnv set, the fixture
+// is written from got instead, described by the comment lines.
+func Compare(t testing.TB, name string, got []byte, comments ...string) {
+	t.Helper()
+	if os.Getenv(UpdateEnv) == "1" {
+		if err := Write(name, got, comments...); err != nil {
+			t.Fatalf("writing fixture %s: %v", name, err)
+		}
+		return
+	}
+	want, err := Load(name)
+	if err != nil {
+		t.Fatalf("reading fixture %s: %v (set %s=1 to create it)", name, err, UpdateEnv)
+	}
+	if bytes.Equal(got, want) {
+		return
+	}
+	offset := firstDifference(got, want)
+	t.Errorf("%s: got %d bytes, want %d, first difference at offset %#x\ngot:\n%swant:\n%s",
+		name, len(got), len(want), offset, dumpAround(got, offset), dumpAround(want, offset))
+}
+
+func firstDifference(a, b []byte) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	if len(a) < len(b) {
+		return len(a)
+	}
+	return len(b)
+}
+
+// dumpAround dumps the lines of data around the offset, aligned to 16 bytes.
+func dumpAround(data []byte, offset int) string {
+	start := offset&^15 - 32
+	if start < 0 {
+		start = 0
+	}
+	end := start + 80
+	if end > len(data) {
+		end = len(data)
+	}
+	if start >= end {
+		return "(end of data)\n"
+	}
+	var b strings.Builder
+	for i := start; i < end; i += 16 {
+		lineEnd := i + 16
+		if lineEnd > end {
+			lineEnd = end
+		}
+		fmt.Fprintf(&b, "%08x  % x\n", i, data[i:lineEnd])
+	}
+	return b.String()
+}
@@ -0,0 +1,133 @@
+package fixtures
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/Solenataris/Erupe/network"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	data := make([]byte, 70)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	file := Encode(data, "first line\nsecond line")
+	if !bytes.HasPrefix(file, []byte("# first line\n# second line\n")) {
+		t.Errorf("comments weren't written first:\n%s", file)
+	}
+	got, err := Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("got %x, want %x", got, data)
+	}
+}
+
+// encryptedStream is what a server sends writing the packets to a fresh encrypted connection.
+func encryptedStream(t *testing.T, packets ...[]byte) []byte {
+	serverConn, clientConn := net.Pipe()
+	go func() {
+		cc := network.NewCryptConn(serverConn)
+		for _, packet := range packets {
+			cc.SendPacket(packet)
+		}
+		serverConn.Close()
+	}()
+	data, err := io.ReadAll(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// pcapFile writes the TCP segments as a capture of Ethernet frames.
+func pcapFile(segments []segment) []byte {
+	var b bytes.Buffer
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header, 0xa1b2c3d4)
+	binary.LittleEndian.PutUint32(header[20:], linkTypeEthernet)
+	b.Write(header)
+	for _, s := range segments {
+		tcp := make([]byte, 20)
+		binary.BigEndian.PutUint16(tcp[0:], s.srcPort)
+		binary.BigEndian.PutUint16(tcp[2:], s.dstPort)
+		binary.BigEndian.PutUint32(tcp[4:], s.seq)
+		tcp[12] = 5 << 4
+		if s.syn {
+			tcp[13] = 0x02
+		}
+		ip := make([]byte, 20)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcp)+len(s.payload)))
+		ip[9] = 6
+		copy(ip[12:], net.IPv4(127, 0, 0, 1).To4())
+		copy(ip[16:], net.IPv4(127, 0, 0, 2).To4())
+		frame := append(make([]byte, 12), 0x08, 0x00)
+		frame = append(append(append(frame, ip...), tcp...), s.payload...)
+
+		record := make([]byte, 16)
+		binary.LittleEndian.PutUint32(record[8:], uint32(len(frame)))
+		binary.LittleEndian.PutUint32(record[12:], uint32(len(frame)))
+		b.Write(record)
+		b.Write(frame)
+	}
+	return b.Bytes()
+}
+
+type segment struct {
+	srcPort, dstPort uint16
+	seq              uint32
+	syn              bool
+	payload          []byte
+}
+
+func TestImportCapture(t *testing.T) {
+	signIn := append([]byte{1, 0, 4, 1, 0, 0, 0, 9}, []byte("secrettokenabcde")...)
+	signIn = append(signIn, []byte("Hunter\x00")...)
+	stream := encryptedStream(t, signIn, []byte{0x00, 0x12, 0xAA, 0xBB})
+
+	// The server's stream arrives split in three, the last part first, the middle part twice.
+	a, b, c := stream[:10], stream[10:30], stream[30:]
+	capture := pcapFile([]segment{
+		{53312, 40000, 999, true, nil},
+		{40000, 53312, 5000, false, make([]byte, 8)}, // The client's 8 NULL bytes.
+		{53312, 40000, 1000 + 30, false, c},
+		{53312, 40000, 1000, false, a},
+		{53312, 40000, 1000 + 10, false, b},
+		{53312, 40000, 1000 + 10, false, b},
+	})
+
+	flows, err := ReadPcap(bytes.NewReader(capture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	responses, errs := Extract(flows, map[int]string{53312: KindSign})
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if len(responses) != 2 || !bytes.Equal(responses[0].Data, signIn) {
+		t.Fatalf("got %d responses, want the sign in and one more", len(responses))
+	}
+	if name := responses[1].Name("captured"); name != "captured/sign/000_0001" {
+		t.Errorf("got name %q", name)
+	}
+
+	sanitized := Sanitize(responses[0], []string{"Hunter"})
+	if bytes.Contains(sanitized, []byte("secrettoken")) || bytes.Contains(sanitized, []byte("Hunter")) {
+		t.Errorf("sanitized response still has its secrets: %q", sanitized)
+	}
+	if len(sanitized) != len(signIn) || !bytes.Equal(sanitized[:4], signIn[:4]) {
+		t.Errorf("sanitizing changed the layout: %x", sanitized)
+	}
+}
+
+func TestImportRefusesPcapng(t *testing.T) {
+	if _, err := ReadPcap(bytes.NewReader([]byte{0x0a, 0x0d, 0x0d, 0x0a, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})); err == nil {
+		t.Error("read a pcapng file as a pcap")
+	}
+}
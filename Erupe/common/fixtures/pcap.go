@@ -0,0 +1,167 @@
+package fixtures
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+)
+
+// Link types of the captures ReadPcap understands.
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeLinuxSLL = 113
+)
+
+// Flow is the payload sent one way over a TCP connection, in sequence order.
+type Flow struct {
+	Src     net.TCPAddr
+	Dst     net.TCPAddr
+	Data    []byte
+	nextSeq uint32
+	started bool
+	pending map[uint32][]byte // Segments that arrived ahead of the gap before them.
+}
+
+// ReadPcap reads the TCP over IPv4 flows of a classic libpcap capture, in the order they started.
+// Retransmitted bytes are dropped and out of order segments are put back in place.
+func ReadPcap(r io.Reader) ([]*Flow, error) {
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("reading pcap header: %w", err)
+	}
+	var order binary.ByteOrder
+	switch binary.LittleEndian.Uint32(header) {
+	case 0xa1b2c3d4, 0xa1b23c4d:
+		order = binary.LittleEndian
+	case 0xd4c3b2a1, 0x4d3cb2a1:
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("not a pcap file, pcapng captures have to be converted first")
+	}
+	linkType := order.Uint32(header[20:])
+
+	var flows []*Flow
+	byKey := make(map[string]*Flow)
+	record := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(r, record); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("reading pcap record: %w", err)
+		}
+		packet := make([]byte, order.Uint32(record[8:]))
+		if _, err := io.ReadFull(r, packet); err != nil {
+			return nil, fmt.Errorf("reading pcap record: %w", err)
+		}
+		src, dst, seq, syn, payload, ok := parseTCP(linkType, packet)
+		if !ok {
+			continue
+		}
+		key := src.String() + ">" + dst.String()
+		flow, exists := byKey[key]
+		if !exists {
+			flow = &Flow{Src: src, Dst: dst, pending: make(map[uint32][]byte)}
+			byKey[key] = flow
+			flows = append(flows, flow)
+		}
+		if syn {
+			seq++
+		}
+		flow.add(seq, payload)
+	}
+	for _, flow := range flows {
+		flow.flushPending()
+	}
+	return flows, nil
+}
+
+// add puts the segment's payload in the flow, the first segment seen setting where it starts.
+func (f *Flow) add(seq uint32, payload []byte) {
+	if !f.started {
+		f.started = true
+		f.nextSeq = seq
+	}
+	if len(payload) == 0 {
+		return
+	}
+	ahead := int32(seq - f.nextSeq)
+	if ahead > 0 {
+		f.pending[seq] = payload
+		return
+	}
+	if -ahead >= int32(len(payload)) {
+		return // Retransmitted.
+	}
+	f.Data = append(f.Data, payload[-ahead:]...)
+	f.nextSeq += uint32(len(payload)) + uint32(ahead)
+	for {
+		next, ok := f.pending[f.nextSeq]
+		if !ok {
+			break
+		}
+		delete(f.pending, f.nextSeq)
+		f.Data = append(f.Data, next...)
+		f.nextSeq += uint32(len(next))
+	}
+}
+
+// flushPending appends the segments still waiting for a gap that never got filled, the capture
+// having missed them, so what follows the gap isn't lost.
+func (f *Flow) flushPending() {
+	seqs := make([]uint32, 0, len(f.pending))
+	for seq := range f.pending {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return int32(seqs[i]-f.nextSeq) < int32(seqs[j]-f.nextSeq) })
+	for _, seq := range seqs {
+		f.Data = append(f.Data, f.pending[seq]...)
+	}
+	f.pending = nil
+}
+
+// parseTCP unwraps a captured frame down to its TCP segment.
+func parseTCP(linkType uint32, packet []byte) (src, dst net.TCPAddr, seq uint32, syn bool, payload []byte, ok bool) {
+	switch linkType {
+	case linkTypeEthernet:
+		if len(packet) < 14 || binary.BigEndian.Uint16(packet[12:]) != 0x0800 {
+			return
+		}
+		packet = packet[14:]
+	case linkTypeLinuxSLL:
+		if len(packet) < 16 || binary.BigEndian.Uint16(packet[14:]) != 0x0800 {
+			return
+		}
+		packet = packet[16:]
+	case linkTypeNull:
+		if len(packet) < 4 {
+			return
+		}
+		packet = packet[4:]
+	case linkTypeRaw:
+	default:
+		return
+	}
+
+	if len(packet) < 20 || packet[0]>>4 != 4 || packet[9] != 6 {
+		return
+	}
+	ipHeader := int(packet[0]&0x0F) * 4
+	total := int(binary.BigEndian.Uint16(packet[2:]))
+	if total > len(packet) || ipHeader < 20 || total < ipHeader+20 {
+		return
+	}
+	srcIP, dstIP := net.IP(append([]byte(nil), packet[12:16]...)), net.IP(append([]byte(nil), packet[16:20]...))
+	segment := packet[ipHeader:total]
+	tcpHeader := int(segment[12]>>4) * 4
+	if tcpHeader < 20 || tcpHeader > len(segment) {
+		return
+	}
+	src = net.TCPAddr{IP: srcIP, Port: int(binary.BigEndian.Uint16(segment[0:]))}
+	dst = net.TCPAddr{IP: dstIP, Port: int(binary.BigEndian.Uint16(segment[2:]))}
+	return src, dst, binary.BigEndian.Uint32(segment[4:]), segment[13]&0x02 != 0, segment[tcpHeader:], true
+}
@@ -0,0 +1,126 @@
+// Package itemtable loads the list of items known to exist, so item IDs typed by admins can be checked
+// before they're handed to a client, and the item box updates of clients checked before they're stored.
+package itemtable
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Table maps the ID of every known item to what's known of it.
+type Table struct {
+	items map[uint16]item
+}
+
+type item struct {
+	name       string
+	maxStack   uint16
+	pouchStack uint16
+	rare       bool
+}
+
+// Load reads a table from a CSV file of "id,name,maxStack,pouchStack,rare" rows, only the ID is needed.
+// maxStack is the most of the item a stack of the item box holds and pouchStack the most a slot of the
+// pouch holds, 0 or empty for no limit, and a rare column of "rare" flags the item, see Rare. Lines starting with # are comments and a header row whose ID isn't a number is skipped.
+func Load(path string) (*Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse reads a table in the format Load expects.
+func Parse(r io.Reader) (*Table, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	t := &Table{items: make(map[uint16]item)}
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := strings.TrimSpace(record[0])
+		id, err := strconv.ParseUint(field, 10, 16)
+		if err != nil {
+			if row == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("row %d: invalid item ID %q", row, field)
+		}
+		if id == 0 {
+			return nil, fmt.Errorf("row %d: item ID 0 isn't an item", row)
+		}
+		var entry item
+		if len(record) > 1 {
+			entry.name = strings.TrimSpace(record[1])
+		}
+		for i, stack := range []*uint16{&entry.maxStack, &entry.pouchStack} {
+			if len(record) <= 2+i {
+				break
+			}
+			if field := strings.TrimSpace(record[2+i]); field != "" {
+				n, err := strconv.ParseUint(field, 10, 16)
+				if err != nil {
+					return nil, fmt.Errorf("row %d: invalid stack size %q", row, field)
+				}
+				*stack = uint16(n)
+			}
+		}
+		if len(record) > 4 {
+			switch field := strings.TrimSpace(record[4]); field {
+			case "rare":
+				entry.rare = true
+			case "":
+			default:
+				return nil, fmt.Errorf("row %d: unknown flag %q", row, field)
+			}
+		}
+		t.items[uint16(id)] = entry
+	}
+	return t, nil
+}
+
+// Has reports whether the item is in the table.
+func (t *Table) Has(id uint16) bool {
+	_, ok := t.items[id]
+	return ok
+}
+
+// Name returns the item's name, or its ID if the table has no name for it.
+func (t *Table) Name(id uint16) string {
+	if name := t.items[id].name; name != "" {
+		return name
+	}
+	return fmt.Sprintf("item %d", id)
+}
+
+// MaxStack returns the most of the item a stack of the item box holds, 0 for no limit or an item not in the table.
+func (t *Table) MaxStack(id uint16) uint16 {
+	return t.items[id].maxStack
+}
+
+// PouchStack returns the most of the item a slot of the pouch holds, 0 for no limit or an item not in the table.
+func (t *Table) PouchStack(id uint16) uint16 {
+	return t.items[id].pouchStack
+}
+
+// Rare reports whether the item is flagged rare, one whose stack in the item box can't grow by more
+// than a slot of the pouch brings without being taken for duplication.
+func (t *Table) Rare(id uint16) bool {
+	return t.items[id].rare
+}
+
+// Len returns the number of items in the table.
+func (t *Table) Len() int {
+	return len(t.items)
+}
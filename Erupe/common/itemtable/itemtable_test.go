@@ -0,0 +1,51 @@
+package itemtable
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	table, err := Parse(strings.NewReader("id,name\n# Consumables\n1,Potion\n2, Mega Potion \n1234\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.Len() != 3 || !table.Has(1) || !table.Has(1234) || table.Has(3) {
+		t.Errorf("got %d items, want 1, 2 and 1234", table.Len())
+	}
+	if name := table.Name(2); name != "Mega Potion" {
+		t.Errorf("got name %q, want %q", name, "Mega Potion")
+	}
+	if name := table.Name(1234); name != "item 1234" {
+		t.Errorf("got name %q for an unnamed item, want %q", name, "item 1234")
+	}
+}
+
+func TestParseStacksAndFlags(t *testing.T) {
+	table, err := Parse(strings.NewReader("id,name,maxStack,pouchStack,rare\n1,Potion,100,10\n2,Mega Potion,,\n3,Gem,99,1,rare\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.MaxStack(1) != 100 || table.MaxStack(2) != 0 || table.MaxStack(4) != 0 {
+		t.Errorf("got max stacks %d, %d and %d, want 100, 0 and 0", table.MaxStack(1), table.MaxStack(2), table.MaxStack(4))
+	}
+	if table.PouchStack(1) != 10 || table.PouchStack(3) != 1 || table.PouchStack(2) != 0 {
+		t.Errorf("got pouch stacks %d, %d and %d, want 10, 1 and 0", table.PouchStack(1), table.PouchStack(3), table.PouchStack(2))
+	}
+	if !table.Rare(3) || table.Rare(1) {
+		t.Error("only item 3 should be rare")
+	}
+	for _, data := range []string{"1,Potion,lots\n", "1,Potion,10,many\n", "1,Potion,10,10,shiny\n"} {
+		if _, err := Parse(strings.NewReader(data)); err == nil {
+			t.Errorf("%q: parsed a table with a bad column", data)
+		}
+	}
+}
+
+func TestParseRejectsBadIDs(t *testing.T) {
+	for _, data := range []string{"1,Potion\nPotion,2\n", "0,Nothing\n", "1,Potion\n70000,Too big\n"} {
+		if _, err := Parse(strings.NewReader(data)); err == nil {
+			t.Errorf("%q: parsed a table with a bad item ID", data)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Package logfile writes logs to a file that's rotated once it grows past a size, keeping a few of
+// the rotated files around.
+package logfile
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Writer appends to a log file, rotating it before a write would take it past its size. The rotated
+// files are named after it, as "erupe.log.1" for the newest one.
+type Writer struct {
+	sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// Open opens the file for appending. maxSize is the size in bytes it's rotated at, 0 never rotates it,
+// and maxBackups the rotated files kept.
+func Open(path string, maxSize int64, maxBackups int) (*Writer, error) {
+	w := &Writer{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+// Write appends p to the file. An entry is never split between two files, one larger than the
+// rotation size gets a file of its own.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		// A rotation that fails keeps appending to the file rather than losing the entry.
+		if err := w.rotate(); err != nil && w.file == nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// rotate moves the file to the newest backup, shifting the older ones along and dropping the oldest,
+// then opens a new file.
+func (w *Writer) rotate() error {
+	w.file.Close()
+	w.file = nil
+	err := w.shiftBackups()
+	if openErr := w.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+func (w *Writer) shiftBackups() error {
+	if w.maxBackups <= 0 {
+		return os.Remove(w.path)
+	}
+	os.Remove(w.backup(w.maxBackups))
+	for i := w.maxBackups - 1; i > 0; i-- {
+		if err := os.Rename(w.backup(i), w.backup(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(w.path, w.backup(1))
+}
+
+func (w *Writer) backup(n int) string {
+	return fmt.Sprintf("%s.%d", w.path, n)
+}
+
+// Sync flushes the file to disk.
+func (w *Writer) Sync() error {
+	w.Lock()
+	defer w.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	return w.file.Sync()
+}
+
+// Close closes the file, later writes fail.
+func (w *Writer) Close() error {
+	w.Lock()
+	defer w.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
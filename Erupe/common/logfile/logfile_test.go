@@ -0,0 +1,59 @@
+package logfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "erupe.log")
+	w, err := Open(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for _, entry := range []string{"first\n", "second\n", "third\n", "a much longer fourth entry\n", "fifth\n"} {
+		if _, err := w.Write([]byte(entry)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[string]string{
+		path:        "fifth\n",
+		path + ".1": "a much longer fourth entry\n",
+		path + ".2": "third\n",
+	}
+	for file, content := range want {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("%s holds %q, want %q", filepath.Base(file), data, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("kept more backups than asked for")
+	}
+}
+
+func TestAppendsToExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "erupe.log")
+	os.WriteFile(path, []byte("earlier\n"), 0644)
+	w, err := Open(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// The file's existing size counts towards the rotation.
+	w.Write([]byte("later\n"))
+	if data, _ := os.ReadFile(path + ".1"); string(data) != "earlier\n" {
+		t.Errorf("backup holds %q, want the earlier log", data)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "later\n" {
+		t.Errorf("file holds %q, want the new entry", data)
+	}
+}
@@ -0,0 +1,156 @@
+// Package names checks the names players give their characters and guilds against the server's rules:
+// their length in Shift-JIS bytes, the classes of characters they may use, the reserved words they
+// mustn't contain and, optionally, that no other name is the same but for case or width.
+//
+// Names are compared by their key, the name with full-width letters and digits folded to half-width,
+// half-width kana folded to full-width and letters lowercased, so "ＡＤＭＩＮ" and "admin" or "ｱｲｳ" and
+// "アイウ" are the same name.
+package names
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+
+	"github.com/Solenataris/Erupe/common/stringsupport"
+	"github.com/Solenataris/Erupe/config"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/width"
+)
+
+var (
+	ErrTooShort  = errors.New("name is too short")
+	ErrTooLong   = errors.New("name is too long")
+	ErrCharacter = errors.New("name has characters that aren't allowed")
+	ErrReserved  = errors.New("name contains a reserved word")
+	ErrFiltered  = errors.New("name contains a filtered word")
+	ErrTaken     = errors.New("name is already taken")
+)
+
+// Key returns the form names are compared by, the same for names that differ only in case or width.
+func Key(name string) string {
+	// Folding splits a half-width voiced kana in two, composing them again makes "ｶﾞ" the same as "ガ".
+	return strings.ToLower(norm.NFC.String(width.Fold.String(name)))
+}
+
+// class returns which of config.NameClasses a rune of a name's key belongs to.
+func class(r rune) string {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		return "latin"
+	case r >= '0' && r <= '9':
+		return "digit"
+	case r >= 0x3040 && r <= 0x30FF:
+		return "kana"
+	case unicode.Is(unicode.Han, r):
+		return "kanji"
+	case r == ' ':
+		return "space"
+	}
+	return "symbol"
+}
+
+// Check returns why the name breaks the rules or contains a reserved word, or nil if it doesn't.
+// Whatever the rules, a name must be encodable for the client, have no control characters and not
+// start or end with a space. Uniqueness is checked by Taken.
+func Check(name string, rules config.NameRules, reserved []string) error {
+	if name == "" {
+		return ErrTooShort
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) || r == unicode.ReplacementChar {
+			return ErrCharacter
+		}
+	}
+	encoded, err := stringsupport.ConvertUTF8ToShiftJIS(name)
+	if err != nil {
+		return ErrCharacter
+	}
+	if len(encoded) < rules.MinLength {
+		return ErrTooShort
+	}
+	if len(encoded) > rules.MaxLength {
+		return ErrTooLong
+	}
+
+	key := Key(name)
+	if strings.HasPrefix(key, " ") || strings.HasSuffix(key, " ") {
+		return ErrCharacter
+	}
+	if len(rules.Classes) > 0 {
+		for _, r := range key {
+			allowed := false
+			for _, c := range rules.Classes {
+				allowed = allowed || class(r) == c
+			}
+			if !allowed {
+				return ErrCharacter
+			}
+		}
+	}
+
+	// A reserved word is matched against whole words of the name, so "Staffan" isn't refused for "staff".
+	// Spaces don't get it through, nor does its full-width spelling.
+	words := tokens(name)
+	for _, word := range reserved {
+		if word := strings.Join(tokens(word), ""); word != "" && spells(words, word) {
+			return ErrReserved
+		}
+	}
+	return nil
+}
+
+// tokens splits a name into its words, folded like its key. A word ends at a space or symbol, where letters
+// give way to digits or another script, and where a lowercase letter is followed by an uppercase one, as in
+// "GameMaster".
+func tokens(name string) []string {
+	var words []string
+	var word []rune
+	var prev rune
+	for _, r := range norm.NFC.String(width.Fold.String(name)) {
+		kind := class(r)
+		if len(word) > 0 && (kind != class(prev) || unicode.IsLower(prev) && unicode.IsUpper(r)) {
+			words = append(words, strings.ToLower(string(word)))
+			word = word[:0]
+		}
+		if kind != "space" && kind != "symbol" {
+			word = append(word, r)
+		}
+		prev = r
+	}
+	if len(word) > 0 {
+		words = append(words, strings.ToLower(string(word)))
+	}
+	return words
+}
+
+// spells reports whether consecutive words of the name spell the word, such as "Game" and "Master" or
+// "gamemaster" alone spelling "gamemaster".
+func spells(words []string, word string) bool {
+	for i := range words {
+		joined := ""
+		for _, next := range words[i:] {
+			joined += next
+			if joined == word {
+				return true
+			}
+			if !strings.HasPrefix(word, joined) {
+				break
+			}
+		}
+	}
+	return false
+}
+
+// Taken reports whether a character, or a guild if guild is set, other than the one with the given ID
+// already has a name with the same key. Deleted characters keep theirs while they can be restored.
+func Taken(db sqlx.Queryer, guild bool, name string, id uint32) (bool, error) {
+	query := "SELECT EXISTS (SELECT 1 FROM characters WHERE name_key = $1 AND id != $2)"
+	if guild {
+		query = "SELECT EXISTS (SELECT 1 FROM guilds WHERE name_key = $1 AND id != $2)"
+	}
+	var taken bool
+	err := db.QueryRowx(query, Key(name), id).Scan(&taken)
+	return taken, err
+}
@@ -0,0 +1,92 @@
+package names
+
+import (
+	"testing"
+
+	"github.com/Solenataris/Erupe/config"
+)
+
+func TestKeyFoldsCaseAndWidth(t *testing.T) {
+	for _, tc := range []struct{ a, b string }{
+		{"ＡＤＭＩＮ", "admin"},
+		{"Admin", "aDMIN"},
+		{"ｱｲｳ", "アイウ"},
+		{"ｶﾞﾝﾅｰ", "ガンナー"},
+		{"Ｈｕｎｔｅｒ１", "hunter1"},
+		{"猫　猫", "猫 猫"},
+	} {
+		if Key(tc.a) != Key(tc.b) {
+			t.Errorf("Key(%q) = %q and Key(%q) = %q, want the same", tc.a, Key(tc.a), tc.b, Key(tc.b))
+		}
+	}
+	if Key("アイウ") == Key("あいう") {
+		t.Error("katakana and hiragana share a key")
+	}
+}
+
+func TestCheckReserved(t *testing.T) {
+	rules := config.NameRules{MinLength: 1, MaxLength: 24}
+	reserved := []string{"admin", "Game Master", "staff"}
+	for _, name := range []string{"admin", "ＡＤＭＩＮ", "xAdmin", "Admin01", "ad min", "ｇａｍｅｍａｓｔｅｒ", "GameMaster", "Staff-Kai"} {
+		if err := Check(name, rules, reserved); err != ErrReserved {
+			t.Errorf("Check(%q) = %v, want %v", name, err, ErrReserved)
+		}
+	}
+	// A reserved word inside a longer word isn't one.
+	for _, name := range []string{"Adamin", "Staffan", "STAFFAN", "Badminton"} {
+		if err := Check(name, rules, reserved); err != nil {
+			t.Errorf("Check(%q) = %v, want nil", name, err)
+		}
+	}
+}
+
+func TestCheckLength(t *testing.T) {
+	rules := config.NameRules{MinLength: 3, MaxLength: 6}
+	for name, want := range map[string]error{
+		"ab":      ErrTooShort,
+		"abc":     nil,
+		"猫":       ErrTooShort,
+		"猫猫猫":     nil,
+		"猫猫猫a":    ErrTooLong,
+		"ｱｲｳｴｵｶ":  nil, // Half-width kana take a byte each.
+		"abcdefg": ErrTooLong,
+		"":        ErrTooShort,
+	} {
+		if err := Check(name, rules, nil); err != want {
+			t.Errorf("Check(%q) = %v, want %v", name, err, want)
+		}
+	}
+}
+
+func TestCheckClasses(t *testing.T) {
+	rules := config.NameRules{MinLength: 1, MaxLength: 24, Classes: []string{"latin", "kana", "space"}}
+	for name, want := range map[string]error{
+		"Hunter":    nil,
+		"ハンター":      nil,
+		"ｈｕｎｔｅｒ":    nil,
+		"Hunter ハン": nil,
+		"Hunter1":   ErrCharacter,
+		"猫":         ErrCharacter,
+		"Hunter!":   ErrCharacter,
+		" Hunter":   ErrCharacter,
+		"Hunter　":   ErrCharacter,
+		"Hun\tter":  ErrCharacter,
+		"Hunter❤":   ErrCharacter, // No Shift-JIS encoding.
+	} {
+		if err := Check(name, rules, nil); err != want {
+			t.Errorf("Check(%q) = %v, want %v", name, err, want)
+		}
+	}
+}
+
+func TestClassesAreConfigurable(t *testing.T) {
+	known := map[string]bool{}
+	for _, c := range config.NameClasses {
+		known[c] = true
+	}
+	for _, r := range "aZ09あア漢 !" {
+		if !known[class(r)] {
+			t.Errorf("%q is of class %q, not one of %v", r, class(r), config.NameClasses)
+		}
+	}
+}
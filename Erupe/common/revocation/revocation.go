@@ -0,0 +1,70 @@
+// Package revocation publishes the accounts signed out everywhere, after a ban or a password change,
+// and the accounts whose chat is muted. Any process sharing the DB can revoke or mute an account, the
+// channel servers listen for it and disconnect or mute the account's sessions.
+package revocation
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// NotifyChannel is the Postgres NOTIFY channel account revocations are published on.
+const NotifyChannel = "erupe_account_revoked"
+
+// MuteChannel is the Postgres NOTIFY channel account mutes are published on.
+const MuteChannel = "erupe_account_muted"
+
+// Reason is why an account was signed out everywhere, told to its players as they're disconnected.
+type Reason string
+
+// Revoke reasons.
+const (
+	Banned      Reason = "banned"
+	Credentials Reason = "credentials"
+	Kicked      Reason = "kicked" // Signed out by staff, such as to restore its savedata.
+)
+
+// Notification is the payload of a revocation notification.
+type Notification struct {
+	UserID uint32 `json:"userID"`
+	Reason Reason `json:"reason"`
+}
+
+// MuteNotification is the payload of a mute notification.
+type MuteNotification struct {
+	UserID uint32    `json:"userID"`
+	Until  time.Time `json:"until"`
+}
+
+// Execer is a DB connection or transaction statements run on.
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Account deletes the account's sign in tokens and tells every channel sharing the DB to disconnect
+// its sessions. Run in the transaction that bans the account or changes its password: Postgres only
+// delivers the notification once it commits, so the tokens are gone before any session is kicked and
+// the client's automatic sign in with them fails.
+func Account(tx Execer, userID uint32, reason Reason) error {
+	if _, err := tx.Exec("DELETE FROM sign_sessions WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	data, err := json.Marshal(Notification{userID, reason})
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("SELECT pg_notify($1, $2)", NotifyChannel, string(data))
+	return err
+}
+
+// Mute tells every channel sharing the DB to mute the chat of the account's sessions until the given time.
+// Run in the transaction recording the mute, the notification is delivered once it commits.
+func Mute(tx Execer, userID uint32, until time.Time) error {
+	data, err := json.Marshal(MuteNotification{userID, until})
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("SELECT pg_notify($1, $2)", MuteChannel, string(data))
+	return err
+}
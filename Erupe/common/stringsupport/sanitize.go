@@ -0,0 +1,32 @@
+package stringsupport
+
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+// SanitizeSJIS drops the control characters other than line breaks and the characters Shift-JIS
+// can't encode, such as the replacement character left by decoding invalid bytes, then cuts the
+// text to the whole characters that fit in maxBytes once encoded.
+func SanitizeSJIS(text string, maxBytes int) string {
+	encoder := japanese.ShiftJIS.NewEncoder()
+	clean := make([]rune, 0, len(text))
+	size := 0
+	for _, r := range text {
+		if r == utf8.RuneError || unicode.IsControl(r) && r != '\n' {
+			continue
+		}
+		encoded, err := encoder.String(string(r))
+		if err != nil {
+			continue
+		}
+		if size+len(encoded) > maxBytes {
+			break
+		}
+		size += len(encoded)
+		clean = append(clean, r)
+	}
+	return string(clean)
+}
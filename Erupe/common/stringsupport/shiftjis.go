@@ -0,0 +1,39 @@
+package stringsupport
+
+import (
+	"bytes"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+// DecodeShiftJIS decodes Shift-JIS text, such as a name the client sent, up to its first null byte.
+// Invalid sequences become U+FFFD instead of failing the whole text.
+func DecodeShiftJIS(data []byte) string {
+	if i := bytes.IndexByte(data, 0x00); i >= 0 {
+		data = data[:i]
+	}
+	// The decoder replaces what it can't decode rather than failing on it.
+	decoded, _ := japanese.ShiftJIS.NewDecoder().Bytes(data)
+	return string(decoded)
+}
+
+// EncodeShiftJIS encodes text for the client. Characters Shift-JIS has no encoding for, such as emoji,
+// become "?", and the text is cut to the whole characters that fit in maxBytes.
+func EncodeShiftJIS(text string, maxBytes int) []byte {
+	encoder := japanese.ShiftJIS.NewEncoder()
+	encoded := make([]byte, 0, len(text))
+	buf := make([]byte, utf8.UTFMax)
+	for _, r := range text {
+		n := utf8.EncodeRune(buf, r)
+		char, err := encoder.Bytes(buf[:n])
+		if err != nil || r == 0 {
+			char = []byte{'?'}
+		}
+		if len(encoded)+len(char) > maxBytes {
+			break
+		}
+		encoded = append(encoded, char...)
+	}
+	return encoded
+}
@@ -0,0 +1,50 @@
+package stringsupport
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestShiftJISRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		encoded []byte
+	}{
+		{"ascii", "Hunter", []byte("Hunter")},
+		{"japanese", "ハンター猫", []byte{0x83, 0x6E, 0x83, 0x93, 0x83, 0x5E, 0x81, 0x5B, 0x94, 0x4C}},
+		{"half-width kana", "ｱｲｳ", []byte{0xB1, 0xB2, 0xB3}},
+		{"mixed", "aｱあ", []byte{'a', 0xB1, 0x82, 0xA0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := EncodeShiftJIS(tt.text, 64)
+			if !bytes.Equal(encoded, tt.encoded) {
+				t.Errorf("EncodeShiftJIS(%q) = % X, want % X", tt.text, encoded, tt.encoded)
+			}
+			if decoded := DecodeShiftJIS(append(encoded, 0x00, 'x')); decoded != tt.text {
+				t.Errorf("DecodeShiftJIS(% X) = %q, want %q", encoded, decoded, tt.text)
+			}
+		})
+	}
+}
+
+func TestDecodeShiftJISInvalid(t *testing.T) {
+	// A lone 0xFF and a lead byte cut off by the end of the text.
+	if decoded := DecodeShiftJIS([]byte{0x82, 0xA0, 0xFF, 'A', 0x82}); decoded != "あ�A�" {
+		t.Errorf("got %q", decoded)
+	}
+}
+
+func TestEncodeShiftJISReplacesAndCuts(t *testing.T) {
+	if encoded := EncodeShiftJIS("a😀\x00b", 64); !bytes.Equal(encoded, []byte("a??b")) {
+		t.Errorf("unencodable runes encoded as %q, want %q", encoded, "a??b")
+	}
+	// The cut falls before a character that would only half fit.
+	if encoded := EncodeShiftJIS("aあい", 4); !bytes.Equal(encoded, []byte{'a', 0x82, 0xA0}) {
+		t.Errorf("cut to % X", encoded)
+	}
+	if encoded := EncodeShiftJIS("あ", 0); len(encoded) != 0 {
+		t.Errorf("cut to nothing gave % X", encoded)
+	}
+}
@@ -0,0 +1,41 @@
+package targeting
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Count returns how many characters the filter picks.
+func Count(db sqlx.Queryer, f *Filter, now time.Time) (int, error) {
+	query, args := Query(f, now)
+	var count int
+	err := db.QueryRowx("SELECT COUNT(*) FROM ("+query+") picked", args...).Scan(&count)
+	return count, err
+}
+
+// CharacterIDs returns the IDs of the characters the filter picks.
+func CharacterIDs(db sqlx.Queryer, f *Filter, now time.Time) ([]uint32, error) {
+	query, args := Query(f, now)
+	var ids []uint32
+	err := sqlx.Select(db, &ids, query+" ORDER BY c.id", args...)
+	return ids, err
+}
+
+// Among returns which of the given characters the filter picks, such as the ones online on a channel.
+func Among(db sqlx.Queryer, f *Filter, now time.Time, charIDs []uint32) ([]uint32, error) {
+	if len(charIDs) == 0 {
+		return nil, nil
+	}
+	query, args := Query(f, now)
+	ids := make([]int64, len(charIDs))
+	for i, id := range charIDs {
+		ids[i] = int64(id)
+	}
+	args = append(args, pq.Array(ids))
+	var picked []uint32
+	err := sqlx.Select(db, &picked, query+" AND c.id = ANY($"+strconv.Itoa(len(args))+")", args...)
+	return picked, err
+}
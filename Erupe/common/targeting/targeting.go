@@ -0,0 +1,415 @@
+// Package targeting parses filter expressions picking characters, such as "gr >= 100 and last_login < 7d",
+// and compiles them to parameterized SQL.
+//
+// A filter compares fields with numbers or durations and combines comparisons with and, or, not and
+// parentheses. Fields name a fixed SQL expression and every value is passed as a query argument, so
+// nothing typed into a filter is ever written into the query itself.
+//
+//	hr, gr, guild_id    = != < <= > >= with a number, guild_id 0 is no guild.
+//	last_login          < <= > >= with a duration ago, such as 7d for a week, 12h or 2w.
+//	account_age         < <= > >= with a duration, how long ago the account was created.
+//	courses has N       the account holds course N, as in the rights bit field.
+package targeting
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Limits on what's parsed, so a filter can't make the parser or the database do unbounded work.
+const (
+	MaxLength      = 512
+	maxDepth       = 16
+	maxComparisons = 32
+)
+
+// Filter is a parsed filter expression.
+type Filter struct {
+	source string
+	root   node
+}
+
+// String returns the expression the filter was parsed from.
+func (f *Filter) String() string {
+	return f.source
+}
+
+// fieldKind is what a field is compared with.
+type fieldKind int
+
+const (
+	kindNumber  fieldKind = iota // Whole numbers.
+	kindAge                      // Durations back from now, compared with a time column.
+	kindCourses                  // Course numbers tested with "has".
+)
+
+// field is a name filters can use and the SQL it stands for.
+type field struct {
+	kind   fieldKind
+	column string
+	unix   bool // The age column holds Unix seconds rather than a timestamp.
+}
+
+// fields is the whitelist of names a filter can use. The columns come from the query in Query.
+var fields = map[string]field{
+	"hr":          {kind: kindNumber, column: "c.hrp"},
+	"gr":          {kind: kindNumber, column: "c.gr"},
+	"guild_id":    {kind: kindNumber, column: "COALESCE(gc.guild_id, 0)"},
+	"last_login":  {kind: kindAge, column: "c.last_login", unix: true},
+	"account_age": {kind: kindAge, column: "u.created_at"},
+	"courses":     {kind: kindCourses, column: "u.rights"},
+}
+
+// operators maps the comparisons filters can use to SQL. Ages compare times, so their comparison turns
+// around: logged in less than 7d ago is a last login after 7d before now.
+var operators = map[string]struct{ sql, age string }{
+	"=":  {"=", ""},
+	"==": {"=", ""},
+	"!=": {"<>", ""},
+	"<":  {"<", ">"},
+	"<=": {"<=", ">="},
+	">":  {">", "<"},
+	">=": {">=", "<="},
+}
+
+type node interface {
+	sql(b *builder)
+}
+
+type andNode struct{ left, right node }
+type orNode struct{ left, right node }
+type notNode struct{ operand node }
+
+type comparison struct {
+	field    field
+	operator string
+	number   int64
+	age      time.Duration
+}
+
+// builder collects the SQL of a filter and its arguments.
+type builder struct {
+	sb   strings.Builder
+	args []interface{}
+	next int
+	now  time.Time
+}
+
+func (b *builder) arg(v interface{}) string {
+	b.args = append(b.args, v)
+	b.next++
+	return "$" + strconv.Itoa(b.next-1)
+}
+
+func (n andNode) sql(b *builder) {
+	b.sb.WriteString("(")
+	n.left.sql(b)
+	b.sb.WriteString(" AND ")
+	n.right.sql(b)
+	b.sb.WriteString(")")
+}
+
+func (n orNode) sql(b *builder) {
+	b.sb.WriteString("(")
+	n.left.sql(b)
+	b.sb.WriteString(" OR ")
+	n.right.sql(b)
+	b.sb.WriteString(")")
+}
+
+func (n notNode) sql(b *builder) {
+	b.sb.WriteString("(NOT ")
+	n.operand.sql(b)
+	b.sb.WriteString(")")
+}
+
+func (n comparison) sql(b *builder) {
+	switch n.field.kind {
+	case kindNumber:
+		fmt.Fprintf(&b.sb, "%s %s %s", n.field.column, operators[n.operator].sql, b.arg(n.number))
+	case kindAge:
+		at := b.now.Add(-n.age)
+		var value interface{} = at
+		if n.field.unix {
+			value = at.Unix()
+		}
+		fmt.Fprintf(&b.sb, "%s %s %s", n.field.column, operators[n.operator].age, b.arg(value))
+	case kindCourses:
+		fmt.Fprintf(&b.sb, "(%s & %s) <> 0", n.field.column, b.arg(int64(1)<<n.number))
+	}
+}
+
+// SQL compiles the filter to a condition on the tables of Query, with placeholders numbered from firstArg.
+// Ages are counted back from now.
+func (f *Filter) SQL(now time.Time, firstArg int) (string, []interface{}) {
+	b := &builder{next: firstArg, now: now}
+	f.root.sql(b)
+	return b.sb.String(), b.args
+}
+
+// Query returns a query of the IDs of the characters the filter picks, deleted characters left out.
+// A nil filter picks every character.
+func Query(f *Filter, now time.Time) (string, []interface{}) {
+	query := `SELECT c.id FROM characters c
+		JOIN users u ON u.id = c.user_id
+		LEFT JOIN guild_characters gc ON gc.character_id = c.id
+		WHERE c.deleted_at IS NULL`
+	if f == nil {
+		return query, nil
+	}
+	condition, args := f.SQL(now, 1)
+	return query + " AND " + condition, args
+}
+
+// token is a word, number, duration, operator or parenthesis of a filter.
+type token struct {
+	text string
+	pos  int
+}
+
+// SyntaxError is a filter that doesn't parse, with the byte offset of the problem.
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("filter error at %d: %s", e.Pos, e.Msg)
+}
+
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expr); {
+		c := rune(expr[i])
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, token{string(c), i})
+			i++
+		case strings.ContainsRune("=!<>", c):
+			start := i
+			i++
+			if i < len(expr) && expr[i] == '=' {
+				i++
+			}
+			text := expr[start:i]
+			if _, ok := operators[text]; !ok {
+				return nil, &SyntaxError{start, fmt.Sprintf("unknown operator %q", text)}
+			}
+			tokens = append(tokens, token{text, start})
+		case c < unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_'):
+			start := i
+			for i < len(expr) && expr[i] < unicode.MaxASCII && (unicode.IsLetter(rune(expr[i])) || unicode.IsDigit(rune(expr[i])) || expr[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{strings.ToLower(expr[start:i]), start})
+		default:
+			return nil, &SyntaxError{i, fmt.Sprintf("unexpected character %q", expr[i])}
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens      []token
+	pos         int
+	end         int
+	depth       int
+	comparisons int
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{pos: p.end}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	t, _ := p.peek()
+	return &SyntaxError{t.pos, fmt.Sprintf(format, args...)}
+}
+
+// Parse parses a filter expression: comparisons joined with "and", which binds tighter, and "or",
+// negated with "not" and grouped with parentheses.
+func Parse(expr string) (*Filter, error) {
+	if len(expr) > MaxLength {
+		return nil, &SyntaxError{MaxLength, fmt.Sprintf("filter is longer than %d bytes", MaxLength)}
+	}
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, &SyntaxError{0, "empty filter"}
+	}
+	p := &parser{tokens: tokens, end: len(expr)}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := p.peek(); ok {
+		return nil, &SyntaxError{t.pos, fmt.Sprintf("unexpected %q", t.text)}
+	}
+	return &Filter{source: expr, root: root}, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if t, ok := p.peek(); !ok || t.text != "or" {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if t, ok := p.peek(); !ok || t.text != "and" {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+}
+
+func (p *parser) parseNot() (node, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxDepth {
+		return nil, p.errorf("filter is nested deeper than %d", maxDepth)
+	}
+
+	t, ok := p.peek()
+	if !ok {
+		return nil, p.errorf("filter ends early")
+	}
+	switch t.text {
+	case "not":
+		p.pos++
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	case "(":
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := p.peek(); !ok || t.text != ")" {
+			return nil, p.errorf("missing )")
+		}
+		p.pos++
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	p.comparisons++
+	if p.comparisons > maxComparisons {
+		return nil, p.errorf("filter has more than %d comparisons", maxComparisons)
+	}
+	name, _ := p.peek()
+	f, ok := fields[name.text]
+	if !ok {
+		return nil, p.errorf("unknown field %q", name.text)
+	}
+	p.pos++
+
+	op, ok := p.peek()
+	if !ok {
+		return nil, p.errorf("%s needs a comparison", name.text)
+	}
+	switch f.kind {
+	case kindCourses:
+		if op.text != "has" {
+			return nil, p.errorf("%s only supports has", name.text)
+		}
+	case kindAge:
+		if operators[op.text].age == "" {
+			return nil, p.errorf("%s only supports < <= > >=", name.text)
+		}
+	default:
+		if _, ok := operators[op.text]; !ok {
+			return nil, p.errorf("%s needs = != < <= > or >=", name.text)
+		}
+	}
+	p.pos++
+
+	value, ok := p.peek()
+	if !ok {
+		return nil, p.errorf("%s %s needs a value", name.text, op.text)
+	}
+	c := comparison{field: f, operator: op.text}
+	var err error
+	switch f.kind {
+	case kindAge:
+		c.age, err = parseAge(value.text)
+	case kindCourses:
+		c.number, err = strconv.ParseInt(value.text, 10, 64)
+		if err == nil && (c.number < 0 || c.number > 31) {
+			err = fmt.Errorf("course %d is out of range 0-31", c.number)
+		}
+	default:
+		c.number, err = strconv.ParseInt(value.text, 10, 32)
+		if err == nil && c.number < 0 {
+			err = fmt.Errorf("negative value")
+		}
+	}
+	if err != nil {
+		return nil, p.errorf("invalid value %q for %s: %v", value.text, name.text, err)
+	}
+	p.pos++
+	return c, nil
+}
+
+// ageUnits are the units a duration in a filter can be written in.
+var ageUnits = map[byte]time.Duration{
+	'h': time.Hour,
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+}
+
+// parseAge parses a duration such as "12h", "7d" or "2w", up to ten years.
+func parseAge(text string) (time.Duration, error) {
+	if len(text) < 2 {
+		return 0, fmt.Errorf("want a number of h, d or w")
+	}
+	unit, ok := ageUnits[text[len(text)-1]]
+	if !ok {
+		return 0, fmt.Errorf("want a number of h, d or w")
+	}
+	n, err := strconv.ParseInt(text[:len(text)-1], 10, 32)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("want a number of h, d or w")
+	}
+	age := time.Duration(n) * unit
+	if age > 10*365*24*time.Hour {
+		return 0, fmt.Errorf("longer than ten years")
+	}
+	return age, nil
+}
@@ -0,0 +1,193 @@
+package targeting
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2022, 6, 8, 12, 0, 0, 0, time.UTC)
+
+func TestParseSQL(t *testing.T) {
+	week := testNow.Add(-7 * 24 * time.Hour)
+	tests := []struct {
+		expr     string
+		wantSQL  string
+		wantArgs []interface{}
+	}{
+		{"gr >= 100", "c.gr >= $1", []interface{}{int64(100)}},
+		{"HR = 999", "c.hrp = $1", []interface{}{int64(999)}},
+		{"guild_id != 0", "COALESCE(gc.guild_id, 0) <> $1", []interface{}{int64(0)}},
+		{"last_login < 7d", "c.last_login > $1", []interface{}{week.Unix()}},
+		{"last_login >= 2w", "c.last_login <= $1", []interface{}{testNow.Add(-14 * 24 * time.Hour).Unix()}},
+		{"account_age > 12h", "u.created_at < $1", []interface{}{testNow.Add(-12 * time.Hour)}},
+		{"courses has 6", "(u.rights & $1) <> 0", []interface{}{int64(64)}},
+		{
+			"gr > 100 and last_login < 7d",
+			"(c.gr > $1 AND c.last_login > $2)",
+			[]interface{}{int64(100), week.Unix()},
+		},
+		{
+			"hr > 1 or gr > 2 and guild_id = 3",
+			"(c.hrp > $1 OR (c.gr > $2 AND COALESCE(gc.guild_id, 0) = $3))",
+			[]interface{}{int64(1), int64(2), int64(3)},
+		},
+		{
+			"(hr > 1 or gr > 2) and not courses has 30",
+			"((c.hrp > $1 OR c.gr > $2) AND (NOT (u.rights & $3) <> 0))",
+			[]interface{}{int64(1), int64(2), int64(1 << 30)},
+		},
+		{"not not gr=1", "(NOT (NOT c.gr = $1))", []interface{}{int64(1)}},
+	}
+	for _, tt := range tests {
+		f, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("%q: %v", tt.expr, err)
+			continue
+		}
+		sql, args := f.SQL(testNow, 1)
+		if sql != tt.wantSQL || !reflect.DeepEqual(args, tt.wantArgs) {
+			t.Errorf("%q: got %s %v, want %s %v", tt.expr, sql, args, tt.wantSQL, tt.wantArgs)
+		}
+		if f.String() != tt.expr {
+			t.Errorf("%q: String got %q", tt.expr, f.String())
+		}
+	}
+}
+
+func TestSQLPlaceholdersStartAtFirstArg(t *testing.T) {
+	f, err := Parse("hr > 1 and gr > 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql, _ := f.SQL(testNow, 4); sql != "(c.hrp > $4 AND c.gr > $5)" {
+		t.Errorf("got %s", sql)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	query, args := Query(nil, testNow)
+	if !strings.HasSuffix(query, "WHERE c.deleted_at IS NULL") || args != nil {
+		t.Errorf("nil filter got %s %v", query, args)
+	}
+	f, _ := Parse("gr > 100")
+	query, args = Query(f, testNow)
+	if !strings.HasSuffix(query, "WHERE c.deleted_at IS NULL AND c.gr > $1") || !reflect.DeepEqual(args, []interface{}{int64(100)}) {
+		t.Errorf("got %s %v", query, args)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		pos  int
+	}{
+		{"", 0},
+		{"   ", 0},
+		{"level > 5", 0},
+		{"gr", 2},
+		{"gr >", 4},
+		{"gr > abc", 5},
+		{"gr > -1", 5},
+		{"gr > 99999999999", 5},
+		{"gr => 5", 4},
+		{"gr <> 5", 4},
+		{"gr has 5", 3},
+		{"courses = 5", 8},
+		{"courses has 32", 12},
+		{"last_login = 7d", 11},
+		{"last_login < 7", 13},
+		{"last_login < 7y", 13},
+		{"last_login < 9999w", 13},
+		{"gr > 1 and", 10},
+		{"gr > 1 or or gr > 2", 10},
+		{"(gr > 1", 7},
+		{"gr > 1)", 6},
+		{"gr > 1 gr > 2", 7},
+		{"not", 3},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.expr)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%q: got %v, want a syntax error", tt.expr, err)
+			continue
+		}
+		if syntaxErr.Pos != tt.pos {
+			t.Errorf("%q: got error %q, want it at %d", tt.expr, err, tt.pos)
+		}
+	}
+}
+
+// Malicious filters are rejected before any SQL is built, and nothing from the input reaches the SQL
+// other than through the arguments.
+func TestParseRejectsInjection(t *testing.T) {
+	malicious := []string{
+		"gr > 1; DROP TABLE characters",
+		"gr > 1 -- comment",
+		"gr > 1 /* x */",
+		"gr > '1'",
+		"gr > \"1\"",
+		"gr > 1 or 1=1",
+		"gr > 1 union select password from users",
+		"password = 1",
+		"u.rights > 0",
+		"c.id = 1",
+		"(select 1) > 0",
+		"gr > 0x10",
+		"gr > 1e5",
+		"gr > 1.5",
+		"gr > $1",
+		"gr > 1\x00",
+		"gr ＞ 1",
+		"ｇｒ > 1",
+		"deleted_at = 0",
+		"guild_id = 1 or users = 1",
+		"gr\u00a0> 1",
+	}
+	for _, expr := range malicious {
+		if f, err := Parse(expr); err == nil {
+			sql, args := f.SQL(testNow, 1)
+			t.Errorf("%q parsed to %s %v", expr, sql, args)
+		}
+	}
+
+	f, err := Parse("gr > 1 or hr > 2 and not (guild_id = 3 or courses has 4) and last_login < 5d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql, _ := f.SQL(testNow, 1)
+	allowed := strings.NewReplacer(
+		"c.gr", "", "c.hrp", "", "COALESCE(gc.guild_id, 0)", "", "c.last_login", "", "u.rights", "",
+		"AND", "", "OR", "", "NOT", "", "<>", "", "<", "", ">", "", "=", "", "&", "", "(", "", ")", "", " ", "",
+	).Replace(sql)
+	if strings.Trim(allowed, "$0123456789") != "" {
+		t.Errorf("SQL %s holds more than fields, operators and placeholders: %q", sql, allowed)
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	if _, err := Parse(strings.Repeat(" ", MaxLength) + "gr > 1"); err == nil {
+		t.Error("overlong filter parsed")
+	}
+	if _, err := Parse(strings.Repeat("(", maxDepth+1) + "gr > 1" + strings.Repeat(")", maxDepth+1)); err == nil {
+		t.Error("deeply nested filter parsed")
+	}
+	if _, err := Parse(strings.Repeat("not ", maxDepth+1) + "gr > 1"); err == nil {
+		t.Error("deeply negated filter parsed")
+	}
+	if _, err := Parse(strings.Repeat("(", maxDepth-1) + "gr > 1" + strings.Repeat(")", maxDepth-1)); err != nil {
+		t.Errorf("filter nested within the limit: %v", err)
+	}
+
+	many := strings.TrimSuffix(strings.Repeat("gr > 1 or ", maxComparisons+1), " or ")
+	if _, err := Parse(many); err == nil {
+		t.Error("filter with too many comparisons parsed")
+	}
+	within := strings.TrimSuffix(strings.Repeat("gr > 1 or ", maxComparisons), " or ")
+	if _, err := Parse(within); err != nil {
+		t.Errorf("filter with %d comparisons: %v", maxComparisons, err)
+	}
+}
@@ -0,0 +1,30 @@
+// Package testdb opens the Postgres database the database tests run against.
+package testdb
+
+import (
+	"os"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+// Env is the environment variable holding the test database's connection string, e.g.
+// "host=localhost user=postgres password=admin dbname=erupe_test sslmode=disable".
+const Env = "ERUPE_TEST_DB"
+
+// Open connects to the database in Env, or skips the test when it isn't set.
+// The connection is closed when the test ends.
+func Open(t testing.TB) *sqlx.DB {
+	t.Helper()
+	dsn := os.Getenv(Env)
+	if dsn == "" {
+		t.Skip(Env + " not set")
+	}
+	db, err := sqlx.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
@@ -0,0 +1,383 @@
+// Package tracing records spans along a player's login through the sign, entrance and channel servers
+// and exports them to an OpenTelemetry collector over OTLP/HTTP, in its JSON encoding. Every method is a
+// no-op on a nil exporter, tracer or span, which is what a disabled config gives, so the servers call
+// them whether tracing is on or not.
+package tracing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TraceID is the ID every span of a trace shares.
+type TraceID [16]byte
+
+// SpanID is the ID of a span within its trace.
+type SpanID [8]byte
+
+// Spans an export carries at most, and finished spans queued for export before further ones are dropped.
+const (
+	maxBatch  = 512
+	queueSize = 4096
+)
+
+// Instrumentation scope of the spans, and the OTLP span kinds used: a server span for the request a
+// server took and an internal one for a step of it.
+const (
+	scopeName    = "github.com/Solenataris/Erupe"
+	kindInternal = 1
+	kindServer   = 2
+)
+
+// Exporter sends the finished spans of its tracers to the collector, in a batch every flush interval.
+type Exporter struct {
+	url      string
+	interval time.Duration
+	client   *http.Client
+	logger   *zap.Logger
+	queue    chan *Span
+	dropped  uint64 // Spans dropped on a full queue. Accessed atomically.
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewExporter starts an exporter posting to the OTLP/HTTP receiver at endpoint, such as
+// "http://localhost:4318", every interval.
+func NewExporter(endpoint string, interval time.Duration, logger *zap.Logger) *Exporter {
+	e := &Exporter{
+		url:      strings.TrimRight(endpoint, "/") + "/v1/traces",
+		interval: interval,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		logger:   logger,
+		queue:    make(chan *Span, queueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Shutdown exports the spans still queued and stops the exporter.
+func (e *Exporter) Shutdown() {
+	if e == nil {
+		return
+	}
+	e.stopOnce.Do(func() { close(e.stop) })
+	<-e.done
+}
+
+func (e *Exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	var batch []*Span
+	for {
+		select {
+		case span := <-e.queue:
+			if batch = append(batch, span); len(batch) >= maxBatch {
+				e.export(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			e.export(batch)
+			batch = nil
+		case <-e.stop:
+			for {
+				select {
+				case span := <-e.queue:
+					batch = append(batch, span)
+				default:
+					e.export(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *Exporter) enqueue(span *Span) {
+	select {
+	case e.queue <- span:
+	default:
+		if atomic.AddUint64(&e.dropped, 1)%1000 == 1 {
+			e.logger.Warn("Dropped spans on a full export queue", zap.Uint64("dropped", atomic.LoadUint64(&e.dropped)))
+		}
+	}
+}
+
+func (e *Exporter) export(batch []*Span) {
+	if len(batch) == 0 {
+		return
+	}
+	body, err := json.Marshal(encodeSpans(batch))
+	if err != nil {
+		e.logger.Error("Failed to encode spans", zap.Error(err))
+		return
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		e.logger.Warn("Failed to export spans", zap.Int("spans", len(batch)), zap.Error(err))
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		e.logger.Warn("Collector refused spans", zap.Int("spans", len(batch)), zap.Int("status", resp.StatusCode))
+	}
+}
+
+// Tracer returns the tracer of a service, such as "erupe-sign", whose spans the exporter sends.
+func (e *Exporter) Tracer(service string) *Tracer {
+	if e == nil {
+		return nil
+	}
+	return &Tracer{service: service, exporter: e}
+}
+
+// Tracer starts the spans of a service.
+type Tracer struct {
+	service  string
+	exporter *Exporter
+}
+
+// Start starts a span in a new trace.
+func (t *Tracer) Start(name string) *Span {
+	if t == nil {
+		return nil
+	}
+	var trace TraceID
+	rand.Read(trace[:])
+	return t.newSpan(trace, SpanID{}, name, kindServer, time.Now())
+}
+
+// Continue starts a span under the remote parent in a W3C traceparent, such as the one carried in a sign in
+// token, or in a new trace when there's no valid traceparent.
+func (t *Tracer) Continue(traceparent string, name string) *Span {
+	return t.ContinueSince(traceparent, name, time.Now())
+}
+
+// ContinueSince is Continue for a span that started at start, for a request whose traceparent was only
+// known partway through handling it.
+func (t *Tracer) ContinueSince(traceparent string, name string, start time.Time) *Span {
+	if t == nil {
+		return nil
+	}
+	trace, parent, ok := ParseTraceParent(traceparent)
+	if !ok {
+		rand.Read(trace[:])
+		parent = SpanID{}
+	}
+	return t.newSpan(trace, parent, name, kindServer, start)
+}
+
+func (t *Tracer) newSpan(trace TraceID, parent SpanID, name string, kind int, start time.Time) *Span {
+	span := &Span{tracer: t, trace: trace, parent: parent, name: name, kind: kind, start: start}
+	rand.Read(span.id[:])
+	return span
+}
+
+// Span is a timed step of a trace.
+type Span struct {
+	tracer *Tracer
+	trace  TraceID
+	id     SpanID
+	parent SpanID
+	name   string
+	kind   int
+	start  time.Time
+
+	sync.Mutex
+	end   time.Time
+	attrs [][2]string
+	err   string
+	ended bool
+}
+
+// Child starts a span under this one.
+func (s *Span) Child(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	return s.tracer.newSpan(s.trace, s.id, name, kindInternal, time.Now())
+}
+
+// Record adds a finished span under this one, for a step that ran before the span existed.
+func (s *Span) Record(name string, start time.Time, end time.Time, err error) {
+	if s == nil {
+		return
+	}
+	child := s.tracer.newSpan(s.trace, s.id, name, kindInternal, start)
+	child.SetError(err)
+	child.ended = true
+	child.end = end
+	s.tracer.exporter.enqueue(child)
+}
+
+// SetAttr attaches an attribute to the span, formatted with fmt.Sprint.
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.attrs = append(s.attrs, [2]string{key, fmt.Sprint(value)})
+}
+
+// SetError marks the span as failed with err, if it isn't nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.err = err.Error()
+}
+
+// End finishes the span and queues it for export. Only the first call counts.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	if s.ended {
+		s.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.Unlock()
+	s.tracer.exporter.enqueue(s)
+}
+
+// TraceID returns the hex ID of the span's trace for log fields, "" for a nil span.
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.trace[:])
+}
+
+// TraceParent returns the span as a W3C traceparent, for a span in another server to continue the trace,
+// "" for a nil span.
+func (s *Span) TraceParent() string {
+	if s == nil {
+		return ""
+	}
+	return "00-" + hex.EncodeToString(s.trace[:]) + "-" + hex.EncodeToString(s.id[:]) + "-01"
+}
+
+// ParseTraceParent reads the trace and parent span IDs out of a W3C traceparent.
+func ParseTraceParent(traceparent string) (TraceID, SpanID, bool) {
+	var trace TraceID
+	var parent SpanID
+	parts := strings.Split(traceparent, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return trace, parent, false
+	}
+	if _, err := hex.Decode(trace[:], []byte(parts[1])); err != nil || trace == (TraceID{}) {
+		return trace, parent, false
+	}
+	if _, err := hex.Decode(parent[:], []byte(parts[2])); err != nil || parent == (SpanID{}) {
+		return trace, parent, false
+	}
+	return trace, parent, true
+}
+
+// The OTLP/JSON encoding of an export request, with only the fields the spans fill.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttr `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope struct {
+			Name string `json:"name"`
+		} `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpSpan struct {
+		TraceID      string     `json:"traceId"`
+		SpanID       string     `json:"spanId"`
+		ParentSpanID string     `json:"parentSpanId,omitempty"`
+		Name         string     `json:"name"`
+		Kind         int        `json:"kind"`
+		Start        string     `json:"startTimeUnixNano"`
+		End          string     `json:"endTimeUnixNano"`
+		Attributes   []otlpAttr `json:"attributes,omitempty"`
+		Status       otlpStatus `json:"status"`
+	}
+	otlpAttr struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+		} `json:"value"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+func otlpAttribute(key string, value string) otlpAttr {
+	attr := otlpAttr{Key: key}
+	attr.Value.StringValue = value
+	return attr
+}
+
+// encodeSpans groups the spans by the service of their tracer into an export request.
+func encodeSpans(spans []*Span) otlpRequest {
+	var req otlpRequest
+	services := make(map[string]int)
+	for _, span := range spans {
+		i, ok := services[span.tracer.service]
+		if !ok {
+			i = len(req.ResourceSpans)
+			services[span.tracer.service] = i
+			resource := otlpResourceSpans{
+				Resource:   otlpResource{Attributes: []otlpAttr{otlpAttribute("service.name", span.tracer.service)}},
+				ScopeSpans: []otlpScopeSpans{{}},
+			}
+			resource.ScopeSpans[0].Scope.Name = scopeName
+			req.ResourceSpans = append(req.ResourceSpans, resource)
+		}
+		span.Lock()
+		encoded := otlpSpan{
+			TraceID: hex.EncodeToString(span.trace[:]),
+			SpanID:  hex.EncodeToString(span.id[:]),
+			Name:    span.name,
+			Kind:    span.kind,
+			Start:   strconv.FormatInt(span.start.UnixNano(), 10),
+			End:     strconv.FormatInt(span.end.UnixNano(), 10),
+		}
+		if span.parent != (SpanID{}) {
+			encoded.ParentSpanID = hex.EncodeToString(span.parent[:])
+		}
+		for _, attr := range span.attrs {
+			encoded.Attributes = append(encoded.Attributes, otlpAttribute(attr[0], attr[1]))
+		}
+		if span.err != "" {
+			encoded.Status = otlpStatus{Code: 2, Message: span.err}
+		}
+		span.Unlock()
+		scope := &req.ResourceSpans[i].ScopeSpans[0]
+		scope.Spans = append(scope.Spans, encoded)
+	}
+	return req
+}
@@ -0,0 +1,123 @@
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestDisabledIsNoOp(t *testing.T) {
+	var exporter *Exporter
+	tracer := exporter.Tracer("erupe-sign")
+	span := tracer.Start("sign in")
+	child := span.Child("authenticate")
+	child.SetAttr("user", "hunter")
+	child.SetError(errors.New("failed"))
+	child.End()
+	span.End()
+	if tracer != nil || span != nil || span.TraceParent() != "" || span.TraceID() != "" {
+		t.Error("a disabled exporter started spans")
+	}
+	if tracer.Continue("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", "login") != nil {
+		t.Error("a disabled tracer continued a trace")
+	}
+	exporter.Shutdown()
+}
+
+func TestTraceParent(t *testing.T) {
+	exporter := &Exporter{}
+	span := exporter.Tracer("erupe-sign").Start("sign in")
+	trace, parent, ok := ParseTraceParent(span.TraceParent())
+	if !ok || trace != span.trace || parent != span.id {
+		t.Fatalf("traceparent %q didn't round trip", span.TraceParent())
+	}
+	continued := exporter.Tracer("erupe-channel").Continue(span.TraceParent(), "login")
+	if continued.trace != span.trace || continued.parent != span.id {
+		t.Error("a continued span isn't under its remote parent")
+	}
+
+	for _, invalid := range []string{
+		"",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331",
+		"01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01",
+		"00-0af7651916cd43dd8448eb211c80319z-b7ad6b7169203331-01",
+	} {
+		if _, _, ok := ParseTraceParent(invalid); ok {
+			t.Errorf("invalid traceparent %q parsed", invalid)
+		}
+	}
+	if root := exporter.Tracer("erupe-channel").Continue("garbage", "login"); root.parent != (SpanID{}) || root.trace == (TraceID{}) {
+		t.Error("an invalid traceparent didn't start a new trace")
+	}
+}
+
+func TestExport(t *testing.T) {
+	requests := make(chan otlpRequest, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got an export to %s as %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		requests <- req
+	}))
+	defer collector.Close()
+
+	exporter := NewExporter(collector.URL+"/", time.Hour, zap.NewNop())
+	sign := exporter.Tracer("erupe-sign").Start("sign in")
+	auth := sign.Child("authenticate")
+	auth.SetError(errors.New("wrong password"))
+	auth.End()
+	sign.SetAttr("uid", 7)
+	sign.End()
+	sign.End()
+	start := time.Now().Add(-time.Second)
+	channel := exporter.Tracer("erupe-channel").ContinueSince(sign.TraceParent(), "login", start)
+	channel.Record("db: check sign token", start, start.Add(time.Millisecond), errors.New("expired"))
+	channel.End()
+	exporter.Shutdown()
+
+	req := <-requests
+	if len(req.ResourceSpans) != 2 {
+		t.Fatalf("got %d services, want 2", len(req.ResourceSpans))
+	}
+	signSpans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if service := req.ResourceSpans[0].Resource.Attributes[0].Value.StringValue; service != "erupe-sign" || len(signSpans) != 2 {
+		t.Fatalf("got %d spans of %q, want the 2 of erupe-sign", len(signSpans), service)
+	}
+	if signSpans[0].Name != "authenticate" || signSpans[0].ParentSpanID != signSpans[1].SpanID || signSpans[0].Status.Code != 2 {
+		t.Errorf("got child span %+v", signSpans[0])
+	}
+	if signSpans[1].ParentSpanID != "" || signSpans[1].Kind != kindServer || len(signSpans[1].Attributes) != 1 || signSpans[1].Attributes[0].Value.StringValue != "7" {
+		t.Errorf("got root span %+v", signSpans[1])
+	}
+	channelSpans := req.ResourceSpans[1].ScopeSpans[0].Spans
+	query, login := channelSpans[0], channelSpans[1]
+	if login.TraceID != signSpans[1].TraceID || login.ParentSpanID != signSpans[1].SpanID || login.Start != strconv.FormatInt(start.UnixNano(), 10) {
+		t.Errorf("the channel login isn't in the sign in's trace from its start: %+v", login)
+	}
+	if query.ParentSpanID != login.SpanID || query.Start != login.Start || query.Status.Code != 2 {
+		t.Errorf("got recorded span %+v", query)
+	}
+}
+
+func BenchmarkDisabledSpan(b *testing.B) {
+	var tracer *Tracer
+	for i := 0; i < b.N; i++ {
+		span := tracer.Start("sign in")
+		child := span.Child("authenticate")
+		child.SetAttr("uid", i)
+		child.End()
+		span.End()
+	}
+}
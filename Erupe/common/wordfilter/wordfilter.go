@@ -0,0 +1,303 @@
+// Package wordfilter finds and masks the words of a list in text players write, such as chat, ignoring
+// case and width so "ＢＡＤ", "Bad" and "bad" or "ｶﾞ" and "ガ" are the same word.
+//
+// The words are compiled into an Aho-Corasick automaton, so checking a message takes one pass over it
+// however many words there are.
+package wordfilter
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/width"
+)
+
+// node is a state of the automaton, the words' prefixes ending in it.
+type node struct {
+	next []edge // Sorted by rune, most states have one or two.
+	fail int32  // State of the longest proper suffix that's also a prefix of a word.
+	out  int    // Folded length of the longest word ending in this state, 0 if none.
+	word int    // Folded length of the word this state spells, 0 if it isn't one.
+}
+
+// edge is the transition of a state on a rune.
+type edge struct {
+	r  rune
+	to int32
+}
+
+// Filter matches the words it was built with. A nil Filter matches nothing.
+type Filter struct {
+	nodes []node
+	// Transitions of the start state on ASCII, taken on most runes of most messages.
+	rootASCII [utf8.RuneSelf]int32
+}
+
+// transition returns the state the state moves to on the rune, if it has one.
+func (n *node) transition(r rune) (int32, bool) {
+	lo, hi := 0, len(n.next)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		switch {
+		case n.next[mid].r == r:
+			return n.next[mid].to, true
+		case n.next[mid].r < r:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return 0, false
+}
+
+// foldedRune is a rune of folded text and the index of the rune of the original text it comes from.
+type foldedRune struct {
+	r     rune
+	index int
+}
+
+// fold appends the runes r folds to: its half-width form for full-width letters and digits, its
+// full-width form for half-width kana, lowercased and decomposed, so a voiced kana is the same whether
+// written as one character or with a separate voicing mark.
+func fold(dst []foldedRune, r rune, index int) []foldedRune {
+	if r < utf8.RuneSelf {
+		if r >= 'A' && r <= 'Z' {
+			r += 'a' - 'A'
+		}
+		return append(dst, foldedRune{r, index})
+	}
+	if folded := width.LookupRune(r).Folded(); folded != 0 {
+		r = folded
+	}
+	var buf [utf8.UTFMax]byte
+	n := utf8.EncodeRune(buf[:], r)
+	decomposed := norm.NFD.Properties(buf[:n]).Decomposition()
+	if decomposed == nil {
+		return append(dst, foldedRune{unicode.ToLower(r), index})
+	}
+	for len(decomposed) > 0 {
+		d, size := utf8.DecodeRune(decomposed)
+		dst = append(dst, foldedRune{unicode.ToLower(d), index})
+		decomposed = decomposed[size:]
+	}
+	return dst
+}
+
+// foldText folds every rune of text, indexing them by rune.
+func foldText(text string) []foldedRune {
+	folded := make([]foldedRune, 0, len(text))
+	index := 0
+	for _, r := range text {
+		folded = fold(folded, r, index)
+		index++
+	}
+	return folded
+}
+
+// New compiles the words into a filter. Blank words are skipped.
+func New(words []string) *Filter {
+	f := &Filter{nodes: []node{{}}}
+	for _, word := range words {
+		folded := foldText(strings.TrimSpace(word))
+		if len(folded) == 0 {
+			continue
+		}
+		state := int32(0)
+		for _, fr := range folded {
+			next, ok := f.nodes[state].transition(fr.r)
+			if !ok {
+				next = int32(len(f.nodes))
+				f.nodes = append(f.nodes, node{})
+				edges := append(f.nodes[state].next, edge{fr.r, next})
+				sort.Slice(edges, func(i, j int) bool { return edges[i].r < edges[j].r })
+				f.nodes[state].next = edges
+			}
+			state = next
+		}
+		f.nodes[state].out = len(folded)
+		f.nodes[state].word = len(folded)
+	}
+	for _, e := range f.nodes[0].next {
+		if e.r < utf8.RuneSelf {
+			f.rootASCII[e.r] = e.to
+		}
+	}
+
+	// Failure links in breadth first order, each from its parent's.
+	queue := make([]int32, 0, len(f.nodes))
+	for _, e := range f.nodes[0].next {
+		queue = append(queue, e.to)
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for _, e := range f.nodes[state].next {
+			r, child := e.r, e.to
+			fail := f.nodes[state].fail
+			for {
+				if next, ok := f.nodes[fail].transition(r); ok && next != child {
+					f.nodes[child].fail = next
+					break
+				}
+				if fail == 0 {
+					break
+				}
+				fail = f.nodes[fail].fail
+			}
+			if out := f.nodes[f.nodes[child].fail].out; out > f.nodes[child].out {
+				f.nodes[child].out = out
+			}
+			queue = append(queue, child)
+		}
+	}
+	return f
+}
+
+// step moves the automaton from the state on the rune.
+func (f *Filter) step(state int32, r rune) int32 {
+	for {
+		if state == 0 && r < utf8.RuneSelf {
+			return f.rootASCII[r]
+		}
+		if next, ok := f.nodes[state].transition(r); ok {
+			return next
+		}
+		if state == 0 {
+			return 0
+		}
+		state = f.nodes[state].fail
+	}
+}
+
+// Contains reports whether the text holds any of the words.
+func (f *Filter) Contains(text string) bool {
+	if f == nil || len(f.nodes) == 1 {
+		return false
+	}
+	var buf [4]foldedRune
+	state := int32(0)
+	for _, r := range text {
+		for _, fr := range fold(buf[:0], r, 0) {
+			state = f.step(state, fr.r)
+			if f.nodes[state].out > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ContainsWord reports whether the text holds any of the words as a whole word rather than inside a longer
+// one, so the name "Staffan" doesn't hold "staff". See wordBoundary for where words start and end.
+func (f *Filter) ContainsWord(text string) bool {
+	if f == nil || len(f.nodes) == 1 {
+		return false
+	}
+	runes := []rune(text)
+	folded := foldText(text)
+	state := int32(0)
+	for i, fr := range folded {
+		state = f.step(state, fr.r)
+		// Every word ending here, not only the longest, following the failure links.
+		for match := state; f.nodes[match].out > 0; match = f.nodes[match].fail {
+			n := f.nodes[match].word
+			if n == 0 {
+				continue
+			}
+			start := i - n + 1
+			// A word starting or ending inside a rune folded to several, such as a voiced kana, isn't whole.
+			first := start == 0 || folded[start-1].index != folded[start].index
+			last := i == len(folded)-1 || folded[i+1].index != fr.index
+			if first && last && wordBoundary(runes, folded[start].index) && wordBoundary(runes, fr.index+1) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Kinds of runes, words end where the kind changes.
+const (
+	runeOther = iota
+	runeLetter
+	runeDigit
+	runeKana
+	runeKanji
+)
+
+func runeKind(r rune) int {
+	switch {
+	case r >= 0x3040 && r <= 0x30FF, r >= 0xFF66 && r <= 0xFF9F:
+		return runeKana
+	case unicode.Is(unicode.Han, r):
+		return runeKanji
+	case unicode.IsLetter(r):
+		return runeLetter
+	case unicode.IsDigit(r):
+		return runeDigit
+	}
+	return runeOther
+}
+
+// wordBoundary reports whether a word may start or end between the runes at i-1 and i: at either end of the
+// text, next to a space or symbol, between letters, digits, kana and kanji, or where a lowercase letter is
+// followed by an uppercase one, as in "GameMaster".
+func wordBoundary(runes []rune, i int) bool {
+	if i == 0 || i == len(runes) {
+		return true
+	}
+	prev, next := runes[i-1], runes[i]
+	kind := runeKind(prev)
+	return kind == runeOther || kind != runeKind(next) || unicode.IsLower(prev) && unicode.IsUpper(next)
+}
+
+// Mask replaces every character of the words in the text with an asterisk.
+func (f *Filter) Mask(text string) string {
+	if !f.Contains(text) {
+		return text
+	}
+	runes := []rune(text)
+	folded := foldText(text)
+	state := int32(0)
+	for i, fr := range folded {
+		state = f.step(state, fr.r)
+		if out := f.nodes[state].out; out > 0 {
+			for j := folded[i-out+1].index; j <= fr.index; j++ {
+				runes[j] = '*'
+			}
+		}
+	}
+	return string(runes)
+}
+
+// LoadWords reads a word list file, one word per line, in UTF-8 or else Shift-JIS. Blank lines and
+// lines starting with "#" are skipped.
+func LoadWords(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+	if !utf8.Valid(data) {
+		if data, err = japanese.ShiftJIS.NewDecoder().Bytes(data); err != nil {
+			return nil, err
+		}
+	}
+	var words []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	return words, scanner.Err()
+}
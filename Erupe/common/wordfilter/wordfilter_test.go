@@ -0,0 +1,105 @@
+package wordfilter
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+func TestContainsAcrossWidths(t *testing.T) {
+	f := New([]string{"noob", "バカ", "ガンナー"})
+	for _, text := range []string{"noob", "NOOB", "ｎｏｏｂ", "ＮｏＯｂ", "a noob!", "ﾊﾞｶ", "ばか バカ", "ｶﾞﾝﾅｰ", "ガンナー"} {
+		if !f.Contains(text) {
+			t.Errorf("Contains(%q) = false, want true", text)
+		}
+	}
+	for _, text := range []string{"", "nob", "ばか", "カンナー", "no ob"} {
+		if f.Contains(text) {
+			t.Errorf("Contains(%q) = true, want false", text)
+		}
+	}
+	var none *Filter
+	if none.Contains("noob") || none.Mask("noob") != "noob" {
+		t.Error("a nil filter matched")
+	}
+}
+
+func TestContainsWord(t *testing.T) {
+	f := New([]string{"staff", "noob", "バカ", "カ"})
+	for _, text := range []string{"staff", "ＳＴＡＦＦ", "Staff01", "xStaff", "the noob", "noob!", "バカ者", "ﾊﾞｶ"} {
+		if !f.ContainsWord(text) {
+			t.Errorf("ContainsWord(%q) = false, want true", text)
+		}
+	}
+	// The words inside longer ones, and a kana inside a voiced one, aren't whole.
+	for _, text := range []string{"Staffan", "STAFFAN", "noobs", "abcnoob", "ガ", "バカラ"} {
+		if f.ContainsWord(text) {
+			t.Errorf("ContainsWord(%q) = true, want false", text)
+		}
+	}
+	var none *Filter
+	if none.ContainsWord("noob") {
+		t.Error("a nil filter matched")
+	}
+}
+
+func TestMask(t *testing.T) {
+	f := New([]string{"leech", "he", "ガンナー", " "})
+	for text, want := range map[string]string{
+		"Carried by LEECHes":  "Carried by ******s",
+		"ｌｅｅｃｈ and she":       "***** and s**",
+		"ｶﾞﾝﾅｰ募集":             "*****募集",
+		"nothing to see here": "nothing to see **re",
+		"ガンナー":                "****",
+	} {
+		if got := f.Mask(text); got != want {
+			t.Errorf("Mask(%q) = %q, want %q", text, got, want)
+		}
+	}
+
+	// Words sharing a suffix with the prefix of another still match.
+	f = New([]string{"abcd", "bc"})
+	if got := f.Mask("abcx abcd"); got != "a**x ****" {
+		t.Errorf("Mask = %q, want %q", got, "a**x ****")
+	}
+}
+
+func TestLoadWords(t *testing.T) {
+	dir := t.TempDir()
+	utf8Path := filepath.Join(dir, "utf8.txt")
+	if err := ioutil.WriteFile(utf8Path, []byte("\xEF\xBB\xBF# Comment\nnoob\n\n  バカ  \n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sjis, err := japanese.ShiftJIS.NewEncoder().Bytes([]byte("noob\r\nバカ\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sjisPath := filepath.Join(dir, "sjis.txt")
+	if err := ioutil.WriteFile(sjisPath, sjis, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{utf8Path, sjisPath} {
+		words, err := LoadWords(path)
+		if err != nil || !reflect.DeepEqual(words, []string{"noob", "バカ"}) {
+			t.Errorf("LoadWords(%s) = %q, %v, want noob and バカ", path, words, err)
+		}
+	}
+}
+
+func BenchmarkContains(b *testing.B) {
+	words := make([]string, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		words = append(words, string(rune('a'+i%26))+string(rune('a'+i/26%26))+"xq"+string(rune('a'+i%7)))
+	}
+	f := New(append(words, "noob", "バカ"))
+	message := "Anyone up for a Rathalos run? ＨＲ５０＋ please, 募集中"
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if f.Contains(message) {
+			b.Fatal("matched a clean message")
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package config
 
 import (
+	"fmt"
 	"log"
 	"net"
+	"sync/atomic"
 
 	"github.com/spf13/viper"
 )
@@ -14,12 +16,53 @@ type Config struct {
 	DevMode bool
 
 	DevModeOptions DevModeOptions
+	Log            Log
 	Discord        Discord
 	Database       Database
 	Launcher       Launcher
 	Sign           Sign
 	Channel        Channel
 	Entrance       Entrance
+	Metrics        Metrics
+	Tracing        Tracing
+	Admin          Admin
+	IPBans         IPBans
+	Newcomer       Newcomer
+	Festa          Festa
+	SeasonPass     SeasonPass
+	RavienteCycle  RavienteCycle
+	TreasureHunt   TreasureHunt
+	Names          Names
+	CharacterSlots CharacterSlots
+	Matchmaking    Matchmaking
+	Maintenance    Maintenance
+	Restarts       Restarts
+	Lockdown       Lockdown
+	Moderation     Moderation
+	ItemGrants     ItemGrants
+	PointShop      PointShop
+	Announcements  []Announcement
+	LoginMessages  []LoginMessage
+	ContentGates   []ContentGate
+	QuestScaling   []QuestScaling
+	WordFilter     []string // Words masked in text players write for others, such as guild officer notes.
+	WordFilterFile string   // File of further filtered words, one per line in UTF-8 or Shift-JIS, "#" starting a comment.
+	ChatFilter     string   // What's done with chat holding a filtered word: "mask" masks it, "block" drops the message, off if empty.
+
+	GuildAnnouncements GuildAnnouncements
+
+	// The latest snapshot of the config, shared by every snapshot of it. See Current.
+	current *atomic.Value
+}
+
+// Current returns the latest snapshot of the config, with the settings reloaded since start up. The config
+// the servers hold is never changed once loaded, settings that can be reloaded are read from here each time
+// they're needed. A config that wasn't loaded from a file, such as one a test builds, is its own snapshot.
+func (c *Config) Current() *Config {
+	if c.current == nil {
+		return c
+	}
+	return c.current.Load().(*Config)
 }
 
 // DevModeOptions holds various debug/temporary options for use while developing Erupe.
@@ -31,7 +74,10 @@ type DevModeOptions struct {
 	LogOutboundMessages bool   // Log all messages sent to the clients
 	Event               int    // Changes the current event
 	OpcodeMessages      bool   // Get all message for Opcodes
+	CrashOnPanic        bool   // Let handler panics crash the server instead of dropping the session
+	LogRNGDraws         bool   // Log every RNG draw with its stream and sequence number
 	SaveDumps           SaveDumpOptions
+	PacketCapture       PacketCaptureOptions
 }
 
 type SaveDumpOptions struct {
@@ -39,6 +85,24 @@ type SaveDumpOptions struct {
 	OutputDir string
 }
 
+// PacketCaptureOptions configures writing the packets of every channel session to a file of its own,
+// see the packetcapture package.
+type PacketCaptureOptions struct {
+	Enabled   bool
+	OutputDir string
+	MaxSize   int // Kilobytes a session's capture grows to, later packets are dropped.
+	MaxAge    int // Hours captures are kept before a new session cleans them up, 0 keeps them.
+}
+
+// Log holds the logging config.
+type Log struct {
+	Level       string // Lowest level logged: "debug", "info", "warn" or "error".
+	PacketLevel string // Lowest level logged for the packet dumps of DevModeOptions, which are logged at debug.
+	File        string // Also writes JSON logs to this file when set.
+	MaxSize     int    // Megabytes the file grows to before it's rotated, 0 never rotates it.
+	MaxBackups  int    // Rotated files kept, as File.1 for the newest one.
+}
+
 // Discord holds the discord integration config.
 type Discord struct {
 	Enabled   		  bool
@@ -62,25 +126,484 @@ type Database struct {
 type Launcher struct {
 	Port                     int
 	UseOriginalLauncherFiles bool
+	PublicStats              bool // Serve the unauthenticated /stats page and /stats.json.
+
+	PatchServer bool   // Serve the patch manifest and PatchDir (see launcherserver/patch.go), off when patches come from a CDN.
+	PatchDir    string // Directory of the files the patch manifest lists.
 }
 
 // Sign holds the sign server config.
 type Sign struct {
 	Port int
+
+	IPBanMaintenance bool // Tell banned IP addresses the server is under maintenance instead of closing the connection.
+
+	PasswordHash string // Hash for new and upgraded passwords, "argon2id" or "bcrypt".
+	BcryptCost   int    // Cost of bcrypt hashes, weaker ones are rehashed at the next login.
+
+	LoginFailures       int  // Failed sign ins before an IP address, or an account to IP addresses that also failed, is locked out. 0 disables the limit.
+	LoginFailureWindow  int  // Seconds a failed sign in counts towards the limit.
+	SharedLoginFailures bool // Count failures in the login_failures table, so every sign server sharing the DB enforces them.
+
+	TokenTTL int // Seconds a sign in token can be used to log in to a channel, 0 keeps tokens valid until their session logs out.
+
+	RequireInviteCode   bool   // New accounts are only created with an invite code from the invite_codes table.
+	InviteCodeDelimiter string // Separates the invite code at the end of the password field, as in "password#CODE".
+
+	DeletedCharacterRetention int // Days a deleted character can be restored before it's purged for good.
 }
 
 // Channel holds the channel server config.
 type Channel struct {
+	// Deprecated: set the port of the world's channel in Entrance.Entries instead. Port1 to Port4 still move
+	// the first channel of the first four worlds, the channels they started before, see LegacyChannelPorts.
 	Port1 int
 	Port2 int
 	Port3 int
 	Port4 int
+
+	ShutdownCountdown   int // Seconds players are warned for before the channels shut down.
+	ShutdownGracePeriod int // Seconds allowed after the countdown for in-flight saves to finish.
+
+	IdleTimeout int // Seconds without a packet before the client is pinged, 0 disables the idle timeout.
+	PingTimeout int // Seconds to wait for any packet after the ping before the client is disconnected.
+
+	SendQueueSize    int // Packets queued per session before broadcasts are dropped and other sends wait for room.
+	SendStallTimeout int // Seconds a session's send queue may stay full before it is disconnected.
+
+	PacketRate       int // Packets a second a client may send on average, 0 disables the limit.
+	PacketBurst      int // Packets a client may send at once past the rate before it's disconnected.
+	LoginPacketBurst int // Burst allowed until the character's first stage entry, covering the login and savedata download.
+	ChatRate         int // Chat messages a minute a player may send on average, 0 disables the limit.
+	ChatBurst        int // Chat messages a player may send at once before further ones are dropped.
+
+	// Requests of these opcodes, such as "MSG_MHF_ACQUIRE_EXCHANGE_SHOP", sent again unchanged within the
+	// window are answered with the first one's ack instead of being handled twice, for the client builds
+	// resending a request answered slowly.
+	RetransmitWindow  int // Seconds, 0 disables the check.
+	RetransmitOpcodes []string
+
+	// Workers running the heavy work of requests, such as the festa standings, off the sessions' packet
+	// handling, and the tasks queued for them before further ones run inline.
+	DeferredWorkers   int
+	DeferredQueueSize int
+
+	SemaphoreTTL int // Seconds a semaphore with no connected holder is kept before it's reclaimed, 0 disables reclaiming.
+
+	QuestStuckAge int // Seconds after which a quest instance still open counts as stuck in the metrics.
+	QuestMaxAge   int // Seconds after which a quest instance is ended and its members disconnected, 0 never ends them.
+
+	BlocklistEnforcement bool // Keep blacklisted players out of the host's password-less rooms and quests. Off by default.
+
+	BanPollInterval int // Seconds between checks for new bans to disconnect, 0 disables live enforcement.
+
+	ReadyCheckTimeout int  // Seconds the host's departure waits for the reserved members to ready up, 0 (the default) departs straight away.
+	ReadyCheckKick    bool // Drop the members who didn't ready up in time from the quest rather than taking them along.
+
+	PartyInviteTimeout int // Seconds an invite into a quest party holds a slot for the invited character's answer.
+
+	SavedataBackups int // Savedatas replaced by a save kept per character in savedata_backups, 0 keeps none.
+	SavedataDiffs   int // Saves stored as diffs onto the last whole savedata before one is written whole again, 0 writes every save whole.
+	SavedataQuota   SavedataQuota
+
+	// Relays guild, alliance and world chat to the channels of other processes sharing the DB
+	// through Postgres LISTEN/NOTIFY. The channels of one process always relay to each other.
+	PostgresChatRelay bool
+
+	// Registers the channels in the server_channels table, so entrance servers sharing the DB list them
+	// without a config change, for channel processes started and stopped as load changes.
+	Register          bool
+	AdvertiseIP       string // Address registered for clients to connect to, HostIP if empty.
+	HeartbeatInterval int    // Seconds between updates of a registered channel's row, which also pick up drain requests.
+}
+
+// SavedataQuota bounds the size of a character's savedata uploads, decompressed. An upload past the
+// sizes is refused, one whose size changed too much from the character's previous savedata is flagged
+// in save_anomalies.
+type SavedataQuota struct {
+	MinSize       int     // Smallest savedata accepted in bytes, never below what the fields read out of it need.
+	MaxSize       int     // Largest savedata accepted in bytes.
+	ChangePercent float64 // Change in size from the previous savedata flagged as an anomaly, 0 flags none.
+	KeepPrevious  bool    // Refuse a save flagged as an anomaly, keeping the previous savedata.
+}
+
+// Metrics holds the metrics HTTP server config.
+type Metrics struct {
+	Enabled bool
+	Port    int
+}
+
+// Tracing holds the config of the login traces exported to an OpenTelemetry collector.
+type Tracing struct {
+	Enabled       bool
+	Endpoint      string // Base URL of the collector's OTLP/HTTP receiver, such as "http://localhost:4318".
+	FlushInterval int    // Seconds between exports of the finished spans.
+}
+
+// Admin holds the admin API config.
+type Admin struct {
+	Enabled bool
+	Address string // Address to listen on, keep it on localhost unless it's behind a proxy.
+	Token   string // Bearer token every request must send.
+
+	PacketTraceDir  string // Directory the per-character packet traces are written to.
+	MaxTraceMinutes int    // Longest a packet trace can run before it stops on its own.
+
+	// Savedata offsets of the zenny (a uint32) and of the equipment box the inventory endpoints edit, 0 leaves
+	// the endpoints disabled. No capture or client disassembly confirms where the client keeps them, set them
+	// once checked against a savedata of the client version in use.
+	ZennyOffset       int
+	EquipBoxOffset    int
+	EquipBoxSlots     int // Slots of the equipment box.
+	EquipBoxEntrySize int // Bytes of a slot, all zeros when it's empty.
+}
+
+// IPBans holds the IP ban list config.
+type IPBans struct {
+	RefreshInterval int // Seconds between reloads of the ip_bans table.
+}
+
+// Newcomer holds the new player protection and starter boost config.
+type Newcomer struct {
+	Enabled           bool
+	MaxAccountAgeDays int     // Accounts younger than this are newcomers, 0 disables the check.
+	MaxHRP            uint16  // Characters below this HRP are newcomers, 0 disables the check.
+	BoostDays         int     // How long the boosts last once granted.
+	RPMultiplier      float64 // Multiplier applied to RP earned from playtime while boosted.
+	Courses           []uint8 // Course IDs granted for free while boosted, see handleMsgSysLogin.
+	StarterItems      []StarterItem
+	Notice            string // Shown in the login notices while the boosts are active.
+}
+
+// StarterItem is an item handed out in the newcomer starter distribution.
+type StarterItem struct {
+	ItemType uint8
+	ItemID   uint16
+	Quantity uint16
+}
+
+// Festa holds the hunter festival team config.
+type Festa struct {
+	Enabled         bool
+	ID              int    // Identifies the festa so team assignments from a previous one aren't reused.
+	RegistrationEnd string // RFC 3339 time registration closes and the guild teams are assigned.
+	End             string // RFC 3339 time the festa ends and the team flags are removed.
+	GuildChangeMode string // "keep" lets characters who change guild keep their original team, "exclude" drops them from the festa.
+	// Offset of the team flag in the character display binary (user binary type 3), 0 leaves the binary as the
+	// client sent it. No capture or client disassembly confirms where the client reads it, set it once checked.
+	TeamFlagOffset int
+	// Souls credited for a return from each festa quest. The server doesn't see the souls a quest rewards, so
+	// the client can only charge souls earned from these quests.
+	SoulQuests []FestaSoulQuest
+}
+
+// FestaSoulQuest is a quest file that earns festa souls, e.g. "23045d0".
+type FestaSoulQuest struct {
+	Quest string
+	Souls int
+}
+
+// CharacterSlots holds the character slot config of accounts.
+type CharacterSlots struct {
+	Base      int    // Characters every account can have.
+	Max       int    // Most characters an account can reach by buying slots, at least Base.
+	SlotPrice uint32 // Gacha coins a slot costs through "!slot buy", 0 disables buying slots.
+}
+
+// Limit returns how many characters an account with the given purchased slots can have.
+func (c CharacterSlots) Limit(extra int) int {
+	limit := c.Base + extra
+	if limit > c.Max && c.Max >= c.Base {
+		limit = c.Max
+	}
+	return limit
+}
+
+// Matchmaking holds the config of the road matchmaking queue.
+type Matchmaking struct {
+	PartySize     int    // Players a match is formed for as soon as that many compatible ones are queued.
+	MinPartySize  int    // Fewest players a match is formed for once its oldest member has waited MaxWait.
+	MaxWait       int    // Seconds a player waits for a full party before a smaller one is formed.
+	AcceptTimeout int    // Seconds every member has to accept a match before it's called off.
+	StagePrefix   string // Stage ID the reserved stages are named from, followed by a sequence number.
+}
+
+// SeasonPass holds the event point reward track config.
+type SeasonPass struct {
+	Enabled          bool
+	Start            string   // RFC 3339 time the first season starts.
+	SeasonDays       int      // Length of each season, points and claims start over with the next one.
+	PremiumCourse    uint8    // Course ID that unlocks the premium lane, see handleMsgSysLogin.
+	DailyPoints      int      // Points for the first login of each day.
+	FestaSoulPoints  int      // Points per festa soul charged.
+	EventQuestPoints int      // Points per return from one of the event quests.
+	EventQuests      []string // Quest file names that earn EventQuestPoints, e.g. "23045d0".
+	Tiers            []SeasonPassTier
+}
+
+// RavienteCycle holds the config for carrying the Raviente's progress between the windows it's open in.
+type RavienteCycle struct {
+	Enabled         bool
+	Start           string        // RFC 3339 time the first cycle starts.
+	CycleDays       int           // Length of each cycle, progress the Raviente didn't die with is finalized as it ends.
+	MinContribution uint32        // Damage a character must have dealt in the cycle to be rewarded.
+	Rewards         []StarterItem // Sent to every contributor once the Raviente dies or the cycle ends.
+}
+
+// TreasureHunt holds the guild treasure quest config. Standings run for a week from Start, after which
+// they're archived and the runs that weren't claimed are dropped.
+type TreasureHunt struct {
+	Enabled     bool
+	Start       string           // RFC 3339 time the first week starts.
+	RankingSize int              // Guilds listed in the treasure ranking.
+	Shares      []int            // Weight of each party slot in the split of a run's payout, the quest host's first.
+	Rewards     []TreasureReward // Payout of a run, from the highest tier its score reaches.
+	// Score of a run of each treasure quest. The server doesn't see the score the client shows, so runs are
+	// only recorded for these quests, when their hunters return from them.
+	Quests []TreasureQuest
+}
+
+// TreasureQuest is a quest file that records a treasure run, e.g. "23045d0".
+type TreasureQuest struct {
+	Quest string
+	Score uint32
+}
+
+// GuildAnnouncements holds the config of the announcements guild officers send to every member.
+type GuildAnnouncements struct {
+	Cooldown int // Minutes a guild waits between two announcements.
+	History  int // Announcements kept for each guild and listed on its news board.
+}
+
+// NameRules are what new character or guild names may be, see common/names.
+type NameRules struct {
+	MinLength int      // Shortest name in Shift-JIS bytes, as the client counts them.
+	MaxLength int      // Longest name in Shift-JIS bytes.
+	Classes   []string // Kinds of characters names may use: "latin", "digit", "kana", "kanji", "symbol", "space". All if empty.
+	Unique    bool     // Refuse a name differing from an existing one only in case or width.
+}
+
+// NameClasses are the kinds of characters NameRules.Classes may list.
+var NameClasses = []string{"latin", "digit", "kana", "kanji", "symbol", "space"}
+
+// Names holds the rules new character and guild names are checked against.
+type Names struct {
+	Character NameRules
+	Guild     NameRules
+	Reserved  []string // Words no name may contain as a whole word, ignoring case, width and spaces, such as staff titles.
+}
+
+// EscalationActions are what an EscalationStep may do.
+var EscalationActions = []string{"warn", "mute", "ban"}
+
+// EscalationStep is a moderation action of an escalation ladder.
+type EscalationStep struct {
+	Action   string // "warn" mails the character, "mute" mutes the account's chat, "ban" bans the account.
+	Duration string // Go duration of a mute or ban, such as "24h", empty for a permanent one.
+}
+
+// EscalationLadder is the moderation action taken for each repeat of an offense, counted over the account's earlier actions of the category.
+type EscalationLadder struct {
+	Category string           // Offense category such as "chat-abuse", recorded on every action so the history adds up.
+	Steps    []EscalationStep // In order, an offense past the last step repeats it.
+}
+
+// Moderation holds the escalation ladders the admin API suggests the next action for an offense from.
+type Moderation struct {
+	AutoApply   bool // Apply the suggested action straight away rather than only suggesting it.
+	HistoryDays int  // Days an action counts towards the next step, 0 counts every earlier action.
+	Ladders     []EscalationLadder
+}
+
+// TreasureReward is the payout of treasure runs scoring at least MinScore, split between the run's hunters.
+type TreasureReward struct {
+	MinScore uint32
+	Items    []StarterItem
+}
+
+// SeasonPassTier is a step on the reward track, claimable once the season's points reach it.
+type SeasonPassTier struct {
+	Points  int
+	Free    []StarterItem
+	Premium []StarterItem // Only for characters with the premium course.
+}
+
+// QuestScaling scales values in an event quest's binary, such as monster HP, by the party size at departure.
+type QuestScaling struct {
+	Quest  string // Quest file name as requested by the client, e.g. "23045d0".
+	Fields []QuestScalingField
+}
+
+// QuestScalingField is a little-endian value in the quest binary and its scale for each party size.
+type QuestScalingField struct {
+	Offset  int
+	Size    int    // Width of the value in bytes, 1, 2 or 4.
+	Percent [4]int // Percentage of the original value for parties of 1 to 4 members.
+}
+
+// Restarts holds the scheduled restart config. Restarts can also be scheduled and called off through the admin API.
+type Restarts struct {
+	Times    []string // Times of day, "15:04" server time, the server restarts at every day.
+	Warnings []int    // Minutes before a restart players are warned in chat.
+	ExitCode int      // Exit code after a scheduled restart, for a supervisor to tell it from a stop or a crash.
+}
+
+// Lockdown holds the config of maintenance mode, which closes sign in and counts down to taking players off.
+// It's started and ended through the admin API.
+type Lockdown struct {
+	Countdown []int // Seconds before the end of the countdown players are warned in chat.
+}
+
+// Announcement is a chat message every channel of its worlds sends on a schedule.
+type Announcement struct {
+	Enabled  bool
+	Message  string   // "{online}" is replaced with the number of players online.
+	Cron     string   // "minute hour day month weekday" in event clock time (JST), such as "0 20 * * *", or
+	Interval int      // minutes between two sends, counted from the channel start.
+	Worlds   []string // Names of the worlds it's sent on, every world if empty.
+	Target   string   // Filter picking the characters it's sent to, such as "gr >= 100", see package targeting.
+}
+
+// LoginMessage is a chat message sent once after login, when the character first enters a stage. The first
+// one matching the world and the account is sent.
+//
+// In the templates "%name%" is the character name, "%lastlogin%" when it last logged in, in event clock
+// time (JST), "%courseexpiry%" when the courses of the account that run out do so, such as the newcomer
+// boosts, and "%%" a %.
+type LoginMessage struct {
+	Worlds    []string // Names of the worlds it's sent on, every world if empty.
+	Courses   []int    // Courses of the rights bit field the account must hold one of, every account if empty.
+	Moderator bool     // Only sent to moderators and admins.
+	Message   string
+	FirstTime string // Sent instead to characters logging in for the first time, Message if empty.
+}
+
+// ContentGate holds content back until a date, for a server opening its content in stages. Gates are
+// checked as the content is asked for, so players online when one opens get the content without logging
+// in again. Until then the login notice tells players when it opens. The admin API can open a gate early.
+type ContentGate struct {
+	Name       string         // Shown to players, such as "G rank", and names the gate in the admin API.
+	Opens      string         // RFC 3339 time the gate opens.
+	Quests     []QuestIDRange // Quests refused while closed, by the number starting the quest file name.
+	QuestLists []uint16       // Quest lists enumerated as empty while closed.
+	HRPCap     uint16         // Highest HRP a save can rise to while closed, 0 for no cap.
+	GRPCap     uint32         // Highest G rank points a save can rise to while closed, 0 for no cap.
+	NoGRank    bool           // Keeps characters out of G rank while closed.
+	Features   []string       // Features off while closed, see ContentGateFeatures.
+}
+
+// QuestIDRange is a range of quest IDs, both ends included.
+type QuestIDRange struct {
+	First int
+	Last  int
+}
+
+// ContentGateFeatures are the features a content gate can hold back.
+var ContentGateFeatures = []string{"festa", "pointshop"}
+
+// PointShop holds the config of the festival point shop, whose stock changes every rotation.
+type PointShop struct {
+	Enabled         bool
+	ShopType        uint8 // Shop type and ID the client enumerates the point shop with, see MsgMhfEnumerateShop.
+	ShopID          uint32
+	Start           string // RFC 3339 time the first rotation starts.
+	RotationDays    int    // Length of each rotation, purchase limits start over with the next one.
+	Seed            uint64 // Picks each rotation's stock, channels with the same seed sell the same items.
+	StockSize       int    // Items on sale each rotation, the whole catalog if 0.
+	FestaSoulPoints int    // Festa points per festa soul charged.
+	Items           []PointShopItem
+}
+
+// PointShopItem is an item the point shop can stock.
+type PointShopItem struct {
+	ItemID   uint16
+	Quantity uint16 // Items per purchase.
+	Price    uint16
+	Currency string // "festa" for festa points, "frontier" for frontier points.
+	Limit    uint16 // Purchases per character each rotation, 0 for no limit.
+}
+
+// ItemGrants holds the config of items given by admins through "!give" and the admin API.
+type ItemGrants struct {
+	Table       string // CSV file of the known items, see package itemtable, that grants and item box updates are checked against. Empty allows any plausible item ID.
+	MaxQuantity int    // Most of an item a single grant can give.
+}
+
+// Maintenance holds the scheduled database maintenance config.
+type Maintenance struct {
+	Enabled     bool
+	WindowStart int // Hour of the day, server time, the maintenance window opens.
+	WindowEnd   int // Hour of the day the window closes, may be earlier than WindowStart to span midnight.
+	Tasks       []MaintenanceTask
+	RankResets  []RankReset
+
+	SavedataSnapshots SavedataSnapshots
+}
+
+// SavedataSnapshots is the daily snapshot of each character's savedata, taken inside the maintenance
+// window to savedata_backups when it changed since the last one. A character's newest snapshot is always kept.
+type SavedataSnapshots struct {
+	Enabled bool
+	Daily   int // Days back the newest snapshot of each day is kept.
+	Weekly  int // Weeks back the newest snapshot of each week is kept.
+}
+
+// MaintenanceTask is a SQL statement run at most once per interval inside the maintenance window.
+type MaintenanceTask struct {
+	Enabled    bool
+	Name       string
+	SQL        string // With a batch size, $1 is the batch size and the statement is repeated until it affects fewer rows.
+	BatchSize  int    // 0 runs the statement once.
+	EveryHours int    // Hours between runs.
+}
+
+// RankReset is a seasonal soft reset of hunter ranks, run once inside the maintenance window.
+// Every rank changed is snapshotted first, so the reset can be reverted within its grace window.
+type RankReset struct {
+	Name   string  // Identifies the reset in the snapshot tables, never reuse one.
+	At     string  // RFC 3339 time the reset runs after, in the next maintenance window.
+	DryRun bool    // Only log what the reset would change.
+	Revert bool    // Restore the snapshotted ranks instead, while within the grace window.
+	Floor  uint16  // HRP at or below this is left alone.
+	Keep   float64 // Fraction of the HRP, and of the G rank points, above their floor kept, 0 resets to the floor.
+
+	GRPFloor uint32 // G rank points at or below this are left alone, 0 leaves G rank alone.
+
+	ReturningDays  int     // Characters away this many days before the reset get the catch-up boosts, 0 gives them to every character reset.
+	CatchUpDays    int     // Days after the reset the catch-up boosts last.
+	CatchUpCourses []uint8 // Course IDs granted for free during the catch-up.
+	GraceDays      int     // Days after the reset it can be reverted.
 }
 
 // Entrance holds the entrance server config.
 type Entrance struct {
 	Port    uint16
 	Entries []EntranceServerInfo
+
+	// Percentages of a world's capacity at which its activity colour steps up, e.g. [50, 80]. The activity is
+	// written in place of the world's season byte, so setting them hides the season. Empty, the default, shows
+	// the season from the servers table.
+	ActivityThresholds []int
+
+	RegistrationTTL int // Seconds without a heartbeat before a registered channel is left out of the list.
+}
+
+// LegacyChannelPorts moves the first channel of each of the first four worlds to the deprecated Channel.Port1
+// to Port4 that are set, and returns a warning for each of them to log.
+func (c *Config) LegacyChannelPorts() []string {
+	var warnings []string
+	for i, port := range []int{c.Channel.Port1, c.Channel.Port2, c.Channel.Port3, c.Channel.Port4} {
+		if port == 0 {
+			continue
+		}
+		if i >= len(c.Entrance.Entries) || len(c.Entrance.Entries[i].Channels) == 0 {
+			warnings = append(warnings, fmt.Sprintf("Channel.Port%d is deprecated and ignored, there's no world %d in Entrance.Entries", i+1, i+1))
+			continue
+		}
+		c.Entrance.Entries[i].Channels[0].Port = uint16(port)
+		warnings = append(warnings, fmt.Sprintf("Channel.Port%d is deprecated, set the port of the first channel of %q in Entrance.Entries instead", i+1, c.Entrance.Entries[i].Name))
+	}
+	return warnings
 }
 
 // EntranceServerInfo represents an entry in the serverlist.
@@ -96,6 +619,9 @@ type EntranceServerInfo struct {
 	// THIS ONLY EXISTS IF Binary8Header.type == "SV2", NOT "SVR"!
 	AllowedClientFlags uint32
 
+	// Stage the world's channels send a player to when the stage they go back to can't be known, Mezeporta if empty.
+	DefaultStage string
+
 	Channels []EntranceChannelInfo
 }
 
@@ -114,6 +640,16 @@ type EntranceChannelInfo struct {
 	Unk11          uint16
 	Unk12          uint16
 	Unk13          uint16
+
+	DefaultStage string // Replaces the world's DefaultStage on this land.
+}
+
+// DefaultStageFor returns the default stage of the world's channel at the index.
+func (e EntranceServerInfo) DefaultStageFor(channel int) string {
+	if channel < len(e.Channels) && e.Channels[channel].DefaultStage != "" {
+		return e.Channels[channel].DefaultStage
+	}
+	return e.DefaultStage
 }
 
 // getOutboundIP4 gets the preferred outbound ip4 of this machine
@@ -132,21 +668,106 @@ func getOutboundIP4() net.IP {
 
 // LoadConfig loads the given config toml file.
 func LoadConfig() (*Config, error) {
-	viper.SetConfigName("config")
-	viper.AddConfigPath(".")
+	c, err := load()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	c.current = new(atomic.Value)
+	c.current.Store(c)
+	return c, nil
+}
 
-	viper.SetDefault("DevModeOptions.SaveDumps", SaveDumpOptions{
+// load reads the config file from the working directory, with the defaults for what it leaves out.
+func load() (*Config, error) {
+	v := viper.New()
+	v.SetConfigName("config")
+	v.AddConfigPath(".")
+
+	v.SetDefault("DevModeOptions.SaveDumps", SaveDumpOptions{
 		Enabled:   false,
 		OutputDir: "savedata",
 	})
+	v.SetDefault("DevModeOptions.PacketCapture", PacketCaptureOptions{
+		OutputDir: "packet_captures",
+		MaxSize:   10240,
+		MaxAge:    72,
+	})
+	v.SetDefault("Log.Level", "debug")
+	v.SetDefault("Log.PacketLevel", "debug")
+	v.SetDefault("Log.MaxSize", 100)
+	v.SetDefault("Log.MaxBackups", 5)
+	v.SetDefault("Channel.IdleTimeout", 120)
+	v.SetDefault("Channel.PingTimeout", 30)
+	v.SetDefault("Channel.PacketRate", 200)
+	v.SetDefault("Channel.PacketBurst", 400)
+	v.SetDefault("Channel.LoginPacketBurst", 2000)
+	v.SetDefault("Channel.ChatRate", 30)
+	v.SetDefault("Channel.ChatBurst", 5)
+	v.SetDefault("Channel.SemaphoreTTL", 300)
+	v.SetDefault("Channel.QuestStuckAge", 7200)
+	v.SetDefault("Channel.QuestMaxAge", 14400)
+	v.SetDefault("Channel.BanPollInterval", 10)
+	v.SetDefault("Channel.RetransmitWindow", 10)
+	v.SetDefault("Channel.PartyInviteTimeout", 60)
+	v.SetDefault("Channel.SavedataBackups", 5)
+	v.SetDefault("Channel.SavedataDiffs", 0)
+	v.SetDefault("Channel.SavedataQuota.MaxSize", 0x40000)
+	v.SetDefault("Channel.SavedataQuota.ChangePercent", 25)
+	v.SetDefault("Channel.HeartbeatInterval", 10)
+	v.SetDefault("Entrance.RegistrationTTL", 30)
+	v.SetDefault("Tracing.Endpoint", "http://localhost:4318")
+	v.SetDefault("Tracing.FlushInterval", 5)
+	v.SetDefault("Admin.Address", "127.0.0.1:8091")
+	v.SetDefault("Launcher.PatchDir", "patch")
+	v.SetDefault("Admin.PacketTraceDir", "packet_traces")
+	v.SetDefault("Admin.MaxTraceMinutes", 60)
+	v.SetDefault("IPBans.RefreshInterval", 60)
+	v.SetDefault("Sign.PasswordHash", "argon2id")
+	v.SetDefault("Sign.BcryptCost", 12)
+	v.SetDefault("Sign.LoginFailures", 10)
+	v.SetDefault("Sign.LoginFailureWindow", 300)
+	v.SetDefault("Sign.TokenTTL", 300)
+	v.SetDefault("Sign.InviteCodeDelimiter", "#")
+	v.SetDefault("Sign.DeletedCharacterRetention", 30)
+	v.SetDefault("SeasonPass.SeasonDays", 28)
+	v.SetDefault("RavienteCycle.CycleDays", 7)
+	v.SetDefault("TreasureHunt.RankingSize", 100)
+	v.SetDefault("TreasureHunt.Shares", []int{40, 20, 20, 20})
+	v.SetDefault("GuildAnnouncements.Cooldown", 30)
+	v.SetDefault("GuildAnnouncements.History", 10)
+	v.SetDefault("Names.Character.MinLength", 1)
+	v.SetDefault("Names.Character.MaxLength", 12)
+	v.SetDefault("Names.Guild.MinLength", 1)
+	v.SetDefault("Names.Guild.MaxLength", 24)
+	v.SetDefault("CharacterSlots.Base", 8)
+	v.SetDefault("CharacterSlots.Max", 16)
+	v.SetDefault("Matchmaking.PartySize", 4)
+	v.SetDefault("Matchmaking.MinPartySize", 2)
+	v.SetDefault("Matchmaking.MaxWait", 60)
+	v.SetDefault("Matchmaking.AcceptTimeout", 30)
+	v.SetDefault("Matchmaking.StagePrefix", "sl1Qs900p0a0u")
+	v.SetDefault("Maintenance.WindowStart", 4)
+	v.SetDefault("Maintenance.WindowEnd", 6)
+	v.SetDefault("Maintenance.SavedataSnapshots.Daily", 7)
+	v.SetDefault("Maintenance.SavedataSnapshots.Weekly", 4)
+	v.SetDefault("Restarts.Warnings", []int{30, 15, 5, 1})
+	v.SetDefault("Restarts.ExitCode", 75)
+	v.SetDefault("Lockdown.Countdown", []int{1800, 900, 600, 300, 180, 60, 30, 10, 5, 4, 3, 2, 1})
+	v.SetDefault("ItemGrants.MaxQuantity", 99)
+	v.SetDefault("PointShop.ShopType", 10)
+	v.SetDefault("PointShop.ShopID", 9)
+	v.SetDefault("PointShop.RotationDays", 7)
 
-	err := viper.ReadInConfig()
+	err := v.ReadInConfig()
 	if err != nil {
 		return nil, err
 	}
 
 	c := &Config{}
-	err = viper.Unmarshal(c)
+	err = v.Unmarshal(c)
 	if err != nil {
 		return nil, err
 	}
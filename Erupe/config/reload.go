@@ -0,0 +1,254 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Solenataris/Erupe/network"
+)
+
+// Serializes reloads, which can come from SIGHUP and the admin API at once.
+var reloadMu sync.Mutex
+
+// Reload reads the config file again and makes the settings that are safe to change at runtime the current
+// ones of c, the config every server holds. It returns the settings that changed in the file but need a
+// restart, such as listener ports and the database, which are left as they are. If the file doesn't load
+// or validate, the current settings are left unchanged.
+//
+// The reloaded settings go into a new snapshot that replaces the current one, see Current, so a handler
+// never sees one half changed. Servers working off a setting beyond reading it when needed, such as the
+// announcement schedules, are told to pick it up again by the caller.
+func Reload(c *Config) ([]string, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	next, err := load()
+	if err != nil {
+		return nil, err
+	}
+	if err := next.Validate(); err != nil {
+		return nil, err
+	}
+	if c.current == nil {
+		return nil, errors.New("the config wasn't loaded from a file")
+	}
+	reloaded := *c.Current()
+	applyReloadable(&reloaded, next)
+	restartNeeded := changedFields("", reflect.ValueOf(reloaded), reflect.ValueOf(*next))
+	c.current.Store(&reloaded)
+	return restartNeeded, nil
+}
+
+// applyReloadable copies the settings that can change at runtime from next into c.
+func applyReloadable(c *Config, next *Config) {
+	c.DevModeOptions.Event = next.DevModeOptions.Event
+	c.Sign.LoginFailures = next.Sign.LoginFailures
+	c.Newcomer = next.Newcomer
+	c.Restarts.Warnings = next.Restarts.Warnings
+	c.Lockdown = next.Lockdown
+	c.Announcements = next.Announcements
+	c.LoginMessages = next.LoginMessages
+	c.ContentGates = next.ContentGates
+	c.QuestScaling = next.QuestScaling
+	c.WordFilter = next.WordFilter
+	c.WordFilterFile = next.WordFilterFile
+	c.ChatFilter = next.ChatFilter
+	c.GuildAnnouncements = next.GuildAnnouncements
+	c.Names = next.Names
+	c.Moderation = next.Moderation
+	c.Channel.PacketRate = next.Channel.PacketRate
+	c.Channel.PacketBurst = next.Channel.PacketBurst
+	c.Channel.LoginPacketBurst = next.Channel.LoginPacketBurst
+	c.Channel.ChatRate = next.Channel.ChatRate
+	c.Channel.ChatBurst = next.Channel.ChatBurst
+	c.Channel.RetransmitWindow = next.Channel.RetransmitWindow
+	c.Channel.RetransmitOpcodes = next.Channel.RetransmitOpcodes
+	c.Channel.SavedataBackups = next.Channel.SavedataBackups
+	c.Channel.SavedataDiffs = next.Channel.SavedataDiffs
+	c.Channel.SavedataQuota = next.Channel.SavedataQuota
+}
+
+// changedFields lists the paths, such as "Sign.Port", of the fields that differ between two values of a struct.
+func changedFields(prefix string, a reflect.Value, b reflect.Value) []string {
+	var changed []string
+	for i := 0; i < a.NumField(); i++ {
+		if a.Type().Field(i).PkgPath != "" {
+			continue
+		}
+		name := prefix + a.Type().Field(i).Name
+		fa, fb := a.Field(i), b.Field(i)
+		if fa.Kind() == reflect.Struct {
+			changed = append(changed, changedFields(name+".", fa, fb)...)
+		} else if !reflect.DeepEqual(fa.Interface(), fb.Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// Validate checks the settings a typo in could break the server while it runs, so a bad edit is turned
+// away at start up or on reload instead.
+func (c *Config) Validate() error {
+	if c.Sign.PasswordHash != "argon2id" && c.Sign.PasswordHash != "bcrypt" {
+		return fmt.Errorf("sign password hash %q isn't argon2id or bcrypt", c.Sign.PasswordHash)
+	}
+	if c.Sign.LoginFailures < 0 {
+		return errors.New("sign login failures is negative")
+	}
+	if c.Newcomer.RPMultiplier < 0 {
+		return errors.New("newcomer RP multiplier is negative")
+	}
+	if c.Matchmaking.MinPartySize < 1 || c.Matchmaking.MinPartySize > c.Matchmaking.PartySize {
+		return fmt.Errorf("matchmaking min party size %d isn't between 1 and the party size", c.Matchmaking.MinPartySize)
+	}
+	times := map[string]string{
+		"festa registration end": c.Festa.RegistrationEnd,
+		"festa end":              c.Festa.End,
+		"season pass start":      c.SeasonPass.Start,
+		"raviente cycle start":   c.RavienteCycle.Start,
+		"treasure hunt start":    c.TreasureHunt.Start,
+		"point shop start":       c.PointShop.Start,
+	}
+	for name, value := range times {
+		if value == "" {
+			continue
+		}
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	gates := make(map[string]bool)
+	for _, gate := range c.ContentGates {
+		if gate.Name == "" || gates[gate.Name] {
+			return fmt.Errorf("content gate %q has no name or a taken one", gate.Name)
+		}
+		gates[gate.Name] = true
+		if _, err := time.Parse(time.RFC3339, gate.Opens); err != nil {
+			return fmt.Errorf("content gate %q opens: %w", gate.Name, err)
+		}
+		for _, quests := range gate.Quests {
+			if quests.First > quests.Last {
+				return fmt.Errorf("content gate %q quest range %d-%d is backwards", gate.Name, quests.First, quests.Last)
+			}
+		}
+		for _, feature := range gate.Features {
+			known := false
+			for _, name := range ContentGateFeatures {
+				known = known || feature == name
+			}
+			if !known {
+				return fmt.Errorf("content gate %q feature %q isn't one of %v", gate.Name, feature, ContentGateFeatures)
+			}
+		}
+	}
+	for i, announcement := range c.Announcements {
+		if announcement.Enabled && announcement.Message == "" {
+			return fmt.Errorf("announcement %d has no message", i)
+		}
+	}
+	shares := 0
+	for _, share := range c.TreasureHunt.Shares {
+		if share < 0 {
+			return errors.New("treasure hunt share is negative")
+		}
+		shares += share
+	}
+	if c.TreasureHunt.Enabled && shares == 0 {
+		return errors.New("treasure hunt shares add up to nothing")
+	}
+	if c.ChatFilter != "" && c.ChatFilter != "mask" && c.ChatFilter != "block" {
+		return fmt.Errorf("chat filter %q isn't mask or block", c.ChatFilter)
+	}
+	if c.Channel.PacketRate > 0 && (c.Channel.PacketBurst < 1 || c.Channel.LoginPacketBurst < c.Channel.PacketBurst) {
+		return fmt.Errorf("channel packet burst %d isn't between 1 and the login burst %d", c.Channel.PacketBurst, c.Channel.LoginPacketBurst)
+	}
+	if c.Channel.ChatRate > 0 && c.Channel.ChatBurst < 1 {
+		return errors.New("channel chat burst doesn't let a message through")
+	}
+	categories := make(map[string]bool)
+	for _, ladder := range c.Moderation.Ladders {
+		if ladder.Category == "" || categories[ladder.Category] || len(ladder.Steps) == 0 {
+			return fmt.Errorf("escalation ladder %q has no category, a taken one or no steps", ladder.Category)
+		}
+		categories[ladder.Category] = true
+		for _, step := range ladder.Steps {
+			known := false
+			for _, action := range EscalationActions {
+				known = known || step.Action == action
+			}
+			if !known {
+				return fmt.Errorf("escalation ladder %q action %q isn't one of %v", ladder.Category, step.Action, EscalationActions)
+			}
+			if step.Duration != "" {
+				if duration, err := time.ParseDuration(step.Duration); err != nil || duration <= 0 {
+					return fmt.Errorf("escalation ladder %q duration %q isn't a positive duration", ladder.Category, step.Duration)
+				}
+			}
+		}
+	}
+	if c.Tracing.Enabled && (c.Tracing.Endpoint == "" || c.Tracing.FlushInterval < 1) {
+		return errors.New("tracing needs an endpoint and a flush interval")
+	}
+	if c.Channel.RetransmitWindow < 0 {
+		return errors.New("channel retransmit window is negative")
+	}
+	for _, opcode := range c.Channel.RetransmitOpcodes {
+		if !knownOpcode(opcode) {
+			return fmt.Errorf("channel retransmit opcode %q isn't an opcode name", opcode)
+		}
+	}
+	if c.Channel.SavedataBackups < 0 {
+		return errors.New("channel savedata backups is negative")
+	}
+	if c.Channel.SavedataDiffs < 0 {
+		return errors.New("channel savedata diffs is negative")
+	}
+	if quota := c.Channel.SavedataQuota; quota.MinSize < 0 || quota.MaxSize <= 0 || quota.MinSize > quota.MaxSize {
+		return errors.New("channel savedata quota needs a positive max size of at least its min size")
+	}
+	if c.Channel.SavedataQuota.ChangePercent < 0 {
+		return errors.New("channel savedata quota change percent is negative")
+	}
+	if c.Maintenance.SavedataSnapshots.Daily < 0 || c.Maintenance.SavedataSnapshots.Weekly < 0 {
+		return errors.New("savedata snapshot retention is negative")
+	}
+	if c.GuildAnnouncements.Cooldown < 0 || c.GuildAnnouncements.History < 0 {
+		return errors.New("guild announcements cooldown or history is negative")
+	}
+	for kind, rules := range map[string]NameRules{"character": c.Names.Character, "guild": c.Names.Guild} {
+		if rules.MinLength < 1 || rules.MaxLength < rules.MinLength {
+			return fmt.Errorf("%s name lengths %d to %d aren't a range starting at 1 or more", kind, rules.MinLength, rules.MaxLength)
+		}
+		for _, class := range rules.Classes {
+			known := false
+			for _, name := range NameClasses {
+				known = known || class == name
+			}
+			if !known {
+				return fmt.Errorf("%s name class %q isn't one of %v", kind, class, NameClasses)
+			}
+		}
+	}
+	for _, scaling := range c.QuestScaling {
+		for _, field := range scaling.Fields {
+			if field.Offset < 0 || (field.Size != 1 && field.Size != 2 && field.Size != 4) {
+				return fmt.Errorf("quest scaling of %s has a field of %d bytes at %d", scaling.Quest, field.Size, field.Offset)
+			}
+		}
+	}
+	return nil
+}
+
+// knownOpcode reports whether name is the name of a packet opcode, such as "MSG_SYS_PING".
+func knownOpcode(name string) bool {
+	for id := network.PacketID(0); !strings.HasPrefix(id.String(), "PacketID("); id++ {
+		if id.String() == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// inConfigDir writes the config file into a new working directory for the test.
+func inConfigDir(t *testing.T, contents string) string {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	writeConfig(t, dir, contents)
+	return dir
+}
+
+func writeConfig(t *testing.T, dir string, contents string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := inConfigDir(t, `{"host_ip": "127.0.0.1", "sign": {"port": 53312}, "newcomer": {"rpMultiplier": 2}}`)
+	c, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeConfig(t, dir, `{"host_ip": "127.0.0.1", "sign": {"port": 53313, "loginFailures": 3}, "newcomer": {"rpMultiplier": 3}, "wordFilter": ["gank"]}`)
+	restartRequired, err := Reload(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := c.Current()
+	if current.Newcomer.RPMultiplier != 3 || current.Sign.LoginFailures != 3 || !reflect.DeepEqual(current.WordFilter, []string{"gank"}) {
+		t.Errorf("reloadable settings weren't applied: %+v %+v %v", current.Newcomer, current.Sign, current.WordFilter)
+	}
+	if current.Sign.Port != 53312 {
+		t.Errorf("sign port changed to %d while running", current.Sign.Port)
+	}
+	if c.Newcomer.RPMultiplier != 2 {
+		t.Errorf("the loaded config was changed in place, its multiplier is %v", c.Newcomer.RPMultiplier)
+	}
+	if !reflect.DeepEqual(restartRequired, []string{"Sign.Port"}) {
+		t.Errorf("got %v needing a restart, want the sign port", restartRequired)
+	}
+}
+
+func TestReloadInvalidKeepsConfig(t *testing.T) {
+	dir := inConfigDir(t, `{"host_ip": "127.0.0.1", "newcomer": {"rpMultiplier": 2}}`)
+	c, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := *c.Current()
+
+	for _, contents := range []string{
+		`{"host_ip": "127.0.0.1", "newcomer": {"rpMultiplier": -1}}`,
+		`{"host_ip": "127.0.0.1", "newcomer": {"rpMultiplier": 3}, "festa": {"end": "next week"}}`,
+		`{"host_ip": "127.0.0.1", "newcomer": {"rpMultiplier": 3}, "announcements": [{"enabled": true}]}`,
+		`{"host_ip": "127.0.0.1", "newcomer": {"rpMultiplier": 3},`,
+	} {
+		writeConfig(t, dir, contents)
+		if _, err := Reload(c); err == nil {
+			t.Errorf("%s: reloaded", contents)
+		}
+		if !reflect.DeepEqual(*c.Current(), before) {
+			t.Errorf("%s: config changed by a failed reload", contents)
+		}
+	}
+}
+
+// TestReloadWhileRead reads the reloadable settings while they're reloaded, for the race detector.
+func TestReloadWhileRead(t *testing.T) {
+	dir := inConfigDir(t, `{"host_ip": "127.0.0.1", "newcomer": {"rpMultiplier": 2}}`)
+	c, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeConfig(t, dir, `{"host_ip": "127.0.0.1", "newcomer": {"rpMultiplier": 3}, "wordFilter": ["gank"]}`)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			if _, err := Reload(c); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		current := c.Current()
+		if m := current.Newcomer.RPMultiplier; m != 2 && m != 3 {
+			t.Fatalf("read a multiplier of %v", m)
+		}
+		_ = len(current.WordFilter)
+	}
+}
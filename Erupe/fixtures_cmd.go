@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Solenataris/Erupe/common/fixtures"
+)
+
+// stringFlags collects repeated string flags.
+type stringFlags []string
+
+func (f *stringFlags) String() string { return strings.Join(*f, ",") }
+
+func (f *stringFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// runFixturesCommand extracts the responses of a packet capture into the fixtures directory,
+// so the tests can compare the response builders against what a real server sent.
+//
+// Usage:
+//
+//	erupe fixtures import [--out captured] [--channel-ports 54001-54008] [--redact Name]... capture.pcap
+func runFixturesCommand(args []string) int {
+	if len(args) == 0 || args[0] != "import" {
+		fmt.Fprintln(os.Stderr, "Usage: erupe fixtures import [flags] capture.pcap")
+		return 2
+	}
+	flags := flag.NewFlagSet("fixtures import", flag.ContinueOnError)
+	out := flags.String("out", "captured", "subdirectory of the fixtures directory to write the responses to")
+	signPort := flags.Int("sign-port", 53312, "port of the sign server in the capture")
+	entrancePort := flags.Int("entrance-port", 53310, "port of the entrance server in the capture")
+	channelPorts := flags.String("channel-ports", "54001-54008", "ports of the channel servers in the capture, as a range or a comma separated list")
+	var redact stringFlags
+	flags.Var(&redact, "redact", "text to blank out of every response, such as a character name, repeat for more")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: erupe fixtures import [flags] capture.pcap")
+		return 2
+	}
+
+	ports := map[int]string{*signPort: fixtures.KindSign, *entrancePort: fixtures.KindEntrance}
+	channels, err := parsePorts(*channelPorts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid --channel-ports:", err)
+		return 2
+	}
+	for _, port := range channels {
+		ports[port] = fixtures.KindChannel
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to open capture:", err)
+		return 1
+	}
+	defer f.Close()
+	flows, err := fixtures.ReadPcap(f)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to read capture:", err)
+		return 1
+	}
+
+	responses, errs := fixtures.Extract(flows, ports)
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, "Skipped the rest of", err)
+	}
+	for _, response := range responses {
+		name := response.Name(*out)
+		comment := fmt.Sprintf("%s response %d of connection %d in %s, sanitized", response.Kind, response.N, response.Flow, flags.Arg(0))
+		if err := fixtures.Write(name, fixtures.Sanitize(response, redact), comment); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to write fixture:", err)
+			return 1
+		}
+	}
+	fmt.Printf("Wrote %d responses to %s/%s\n", len(responses), fixtures.Dir(), *out)
+	return 0
+}
+
+// parsePorts reads a port range such as "54001-54008" or a list such as "54001,54002".
+func parsePorts(s string) ([]int, error) {
+	var ports []int
+	for _, part := range strings.Split(s, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(part), "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, err
+			}
+		}
+		for port := first; port <= last; port++ {
+			ports = append(ports, port)
+		}
+	}
+	return ports, nil
+}
@@ -1,21 +1,33 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/Solenataris/Erupe/common/itemtable"
+	"github.com/Solenataris/Erupe/common/logfile"
+	"github.com/Solenataris/Erupe/common/tracing"
 	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/server/adminserver"
 	"github.com/Solenataris/Erupe/server/channelserver"
 	"github.com/Solenataris/Erupe/server/discordbot"
 	"github.com/Solenataris/Erupe/server/entranceserver"
+	"github.com/Solenataris/Erupe/server/ipban"
 	"github.com/Solenataris/Erupe/server/launcherserver"
+	"github.com/Solenataris/Erupe/server/lockdownserver"
+	"github.com/Solenataris/Erupe/server/maintenanceserver"
+	"github.com/Solenataris/Erupe/server/metricsserver"
+	"github.com/Solenataris/Erupe/server/restartserver"
 	"github.com/Solenataris/Erupe/server/signserver"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 // Temporary DB auto clean on startup for quick development & testing.
@@ -27,19 +39,99 @@ func cleanDB(db *sqlx.DB) {
 	_ = db.MustExec("DELETE FROM users")
 }
 
-func main() {
-	zapLogger, _ := zap.NewDevelopment()
-	defer zapLogger.Sync()
-	logger := zapLogger.Named("main")
+// dbConnectString builds the postgres connection string from the config.
+func dbConnectString(erupeConfig *config.Config) string {
+	return fmt.Sprintf(
+		"host=%s port=%d user=%s password=%s dbname= %s sslmode=disable",
+		erupeConfig.Database.Host,
+		erupeConfig.Database.Port,
+		erupeConfig.Database.User,
+		erupeConfig.Database.Password,
+		erupeConfig.Database.Database,
+	)
+}
 
-	logger.Info("Starting Erupe")
+// openDB opens the postgres DB pool and checks the connection.
+func openDB(erupeConfig *config.Config) (*sqlx.DB, error) {
+	db, err := sqlx.Open("postgres", dbConnectString(erupeConfig))
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// newLoggers builds the logger of the servers, which prints to the console and to the log file when one
+// is set, and the logger of the packet dumps, which goes to the same places at a level of its own.
+// The returned func closes the log file.
+func newLoggers(c config.Log) (*zap.Logger, *zap.Logger, func(), error) {
+	var level, packetLevel zapcore.Level
+	if err := level.UnmarshalText([]byte(c.Level)); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := packetLevel.UnmarshalText([]byte(c.PacketLevel)); err != nil {
+		return nil, nil, nil, err
+	}
+	var file *logfile.Writer
+	if c.File != "" {
+		var err error
+		file, err = logfile.Open(c.File, int64(c.MaxSize)<<20, c.MaxBackups)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
 
-	// Load the configuration.
+	console := zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+	stderr := zapcore.Lock(os.Stderr)
+	build := func(level zapcore.Level) *zap.Logger {
+		core := zapcore.NewCore(console, stderr, level)
+		if file != nil {
+			core = zapcore.NewTee(core, zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), file, level))
+		}
+		return zap.New(core, zap.Development(), zap.AddCaller(), zap.AddStacktrace(zapcore.WarnLevel))
+	}
+	closeFile := func() {
+		if file != nil {
+			file.Close()
+		}
+	}
+	return build(level), build(packetLevel).Named("packets"), closeFile, nil
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "payload" {
+		os.Exit(runPayloadCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "fixtures" {
+		os.Exit(runFixturesCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "selftest" {
+		os.Exit(runSelftestCommand(os.Args[2:]))
+	}
+
+	// Load the configuration, which says where to log.
 	erupeConfig, err := config.LoadConfig()
 	if err != nil {
-		logger.Fatal("Failed to load config", zap.Error(err))
+		zapLogger, _ := zap.NewDevelopment()
+		zapLogger.Named("main").Fatal("Failed to load config", zap.Error(err))
+	}
+	zapLogger, packetLogger, closeLog, err := newLoggers(erupeConfig.Log)
+	if err != nil {
+		fallback, _ := zap.NewDevelopment()
+		fallback.Named("main").Fatal("Failed to set up logging", zap.Error(err))
+	}
+	defer closeLog()
+	defer zapLogger.Sync()
+	logger := zapLogger.Named("main")
+	for _, warning := range erupeConfig.LegacyChannelPorts() {
+		logger.Warn(warning)
 	}
 
+	logger.Info("Starting Erupe")
+
 	// Discord bot
 	var discordBot *discordbot.DiscordBot = nil
 
@@ -66,24 +158,9 @@ func main() {
 	}
 
 	// Create the postgres DB pool.
-	connectString := fmt.Sprintf(
-		"host=%s port=%d user=%s password=%s dbname= %s sslmode=disable",
-		erupeConfig.Database.Host,
-		erupeConfig.Database.Port,
-		erupeConfig.Database.User,
-		erupeConfig.Database.Password,
-		erupeConfig.Database.Database,
-	)
-
-	db, err := sqlx.Open("postgres", connectString)
+	db, err := openDB(erupeConfig)
 	if err != nil {
-		logger.Fatal("Failed to open sql database", zap.Error(err))
-	}
-
-	// Test the DB connection.
-	err = db.Ping()
-	if err != nil {
-		logger.Fatal("Failed to ping database", zap.Error(err))
+		logger.Fatal("Failed to connect to database", zap.Error(err))
 	}
 	logger.Info("Connected to database")
 
@@ -94,6 +171,30 @@ func main() {
 		logger.Info("Done cleaning DB")
 	}
 
+	// IP ban list shared by the entrance and sign servers.
+	ipBans := ipban.NewList(logger.Named("ipban"), db)
+	err = ipBans.Start(time.Duration(erupeConfig.IPBans.RefreshInterval) * time.Second)
+	if err != nil {
+		logger.Fatal("Failed to load IP ban list", zap.Error(err))
+	}
+
+	// Item table admins' item grants and the clients' item box updates are checked against.
+	var items *itemtable.Table
+	if erupeConfig.ItemGrants.Table != "" {
+		items, err = itemtable.Load(erupeConfig.ItemGrants.Table)
+		if err != nil {
+			logger.Fatal("Failed to load item table", zap.Error(err))
+		}
+		logger.Info("Loaded item table", zap.Int("items", items.Len()))
+	}
+
+	// Exporter of the login traces, nil with tracing off, which makes every span a no-op.
+	var traceExporter *tracing.Exporter
+	if erupeConfig.Tracing.Enabled {
+		traceExporter = tracing.NewExporter(erupeConfig.Tracing.Endpoint, time.Duration(erupeConfig.Tracing.FlushInterval)*time.Second, logger.Named("tracing"))
+		logger.Info("Exporting traces", zap.String("endpoint", erupeConfig.Tracing.Endpoint))
+	}
+
 	// Now start our server(s).
 
 	// Launcher HTTP server.
@@ -116,6 +217,8 @@ func main() {
 			Logger:      logger.Named("entrance"),
 			ErupeConfig: erupeConfig,
 			DB:          db,
+			IPBans:      ipBans,
+			Tracer:      traceExporter.Tracer("erupe-entrance"),
 		})
 	err = entranceServer.Start()
 	if err != nil {
@@ -129,6 +232,8 @@ func main() {
 			Logger:      logger.Named("sign"),
 			ErupeConfig: erupeConfig,
 			DB:          db,
+			IPBans:      ipBans,
+			Tracer:      traceExporter.Tracer("erupe-sign"),
 		})
 	err = signServer.Start()
 	if err != nil {
@@ -136,80 +241,285 @@ func main() {
 	}
 	logger.Info("Started sign server.")
 
-	// Channel Server
-	channelServer1 := channelserver.NewServer(
-		&channelserver.Config{
-			Logger:      logger.Named("channel"),
-			ErupeConfig: erupeConfig,
-			DB:          db,
-			Name:        erupeConfig.Entrance.Entries[0].Name,
-			Enable:      erupeConfig.Entrance.Entries[0].Channels[0].MaxPlayers > 0,
-			//DiscordBot:  discordBot,
-		})
-
-	err = channelServer1.Start(erupeConfig.Channel.Port1)
+	// Channel servers, one per channel of every world in the entrance list.
+	// They share the DB pool and the registry, so a character can be reached on any of them.
+	registry := channelserver.NewChannelRegistry()
+	var chatBus channelserver.ChatBus = channelserver.NewLocalChatBus()
+	var postgresChatBus *channelserver.PostgresChatBus
+	if erupeConfig.Channel.PostgresChatRelay {
+		postgresChatBus, err = channelserver.NewPostgresChatBus(logger.Named("chat_relay"), db, dbConnectString(erupeConfig))
+		if err != nil {
+			logger.Fatal("Failed to listen for relayed chat", zap.Error(err))
+		}
+		chatBus = postgresChatBus
+	}
+	started, err := startChannelServers(erupeConfig, channelserver.Config{
+		Logger:       logger.Named("channel"),
+		PacketLogger: packetLogger,
+		ErupeConfig:  erupeConfig,
+		DB:           db,
+		Registry:     registry,
+		ChatBus:      chatBus,
+		Items:        items,
+		Tracer:       traceExporter.Tracer("erupe-channel"),
+	}, discordBot)
 	if err != nil {
-		logger.Fatal("Failed to start channel server1", zap.Error(err))
+		logger.Fatal("Failed to start channel server", zap.Error(err))
 	}
-	logger.Info("Started channel server.")
-	// Channel Server
-	channelServer2 := channelserver.NewServer(
-		&channelserver.Config{
-			Logger:      logger.Named("channel"),
-			ErupeConfig: erupeConfig,
-			DB:          db,
-			Name:        erupeConfig.Entrance.Entries[1].Name,
-			Enable:      erupeConfig.Entrance.Entries[1].Channels[0].MaxPlayers > 0,
-			DiscordBot:  discordBot,
-		})
+	var channelServers []*channelserver.Server
+	for _, channel := range started {
+		if erupeConfig.Channel.Register {
+			address := erupeConfig.Channel.AdvertiseIP
+			if address == "" {
+				address = erupeConfig.HostIP
+			}
+			if err := channel.server.Register(address, channel.channel.Port, channel.channel.MaxPlayers); err != nil {
+				logger.Fatal("Failed to register channel server", zap.String("world", channel.world), zap.Uint16("port", channel.channel.Port), zap.Error(err))
+			}
+		}
+		launcherServer.AddPopulationSource(channel.server)
+		entranceServer.AddPopulationSource(channel.channel.Port, channel.server)
+		channelServers = append(channelServers, channel.server)
+	}
+	logger.Info("Started channel servers.", zap.Int("count", len(channelServers)))
 
-	err = channelServer2.Start(erupeConfig.Channel.Port2)
+	// Disconnects the sessions of accounts banned or given a new password, by this process or another.
+	revocationListener, err := channelserver.NewRevocationListener(logger.Named("revocation"), dbConnectString(erupeConfig), registry)
 	if err != nil {
-		logger.Fatal("Failed to start channel server2", zap.Error(err))
+		logger.Fatal("Failed to listen for revoked accounts", zap.Error(err))
+	}
+
+	var metricsSources []metricsserver.Source
+	var registries []adminserver.SessionRegistry
+	var broadcasters []restartserver.Broadcaster
+	var lockdownChannels []lockdownserver.Channel
+	for _, channelServer := range channelServers {
+		metricsSources = append(metricsSources, channelServer)
+		registries = append(registries, channelServer)
+		broadcasters = append(broadcasters, channelServer)
+		lockdownChannels = append(lockdownChannels, channelServer)
 	}
-	// Channel Server
-	channelServer3 := channelserver.NewServer(
-		&channelserver.Config{
-			Logger:      logger.Named("channel"),
+	metricsSources = append(metricsSources, launcherServer)
+
+	// Scheduled restarts, from the configured times and the admin API.
+	restartServer := restartserver.NewServer(
+		&restartserver.Config{
+			Logger:      logger.Named("restart"),
 			ErupeConfig: erupeConfig,
-			DB:          db,
-			Name:        erupeConfig.Entrance.Entries[2].Name,
-			Enable:      erupeConfig.Entrance.Entries[2].Channels[0].MaxPlayers > 0,
-			//DiscordBot:  discordBot,
+			Channels:    broadcasters,
 		})
-
-	err = channelServer3.Start(erupeConfig.Channel.Port3)
+	err = restartServer.Start()
 	if err != nil {
-		logger.Fatal("Failed to start channel server3", zap.Error(err))
+		logger.Fatal("Failed to start restart scheduler", zap.Error(err))
 	}
-	// Channel Server
-	channelServer4 := channelserver.NewServer(
-		&channelserver.Config{
-			Logger:      logger.Named("channel"),
-			ErupeConfig: erupeConfig,
+	metricsSources = append(metricsSources, restartServer)
+
+	// Maintenance mode, started and ended through the admin API.
+	lockdownServer := lockdownserver.NewServer(
+		&lockdownserver.Config{
+			Logger:      logger.Named("lockdown"),
 			DB:          db,
-			Name:        erupeConfig.Entrance.Entries[3].Name,
-			Enable:      erupeConfig.Entrance.Entries[3].Channels[0].MaxPlayers > 0,
-			//DiscordBot:  discordBot,
+			ErupeConfig: erupeConfig,
+			Channels:    lockdownChannels,
 		})
-
-	err = channelServer4.Start(erupeConfig.Channel.Port4)
+	err = lockdownServer.Start()
 	if err != nil {
-		logger.Fatal("Failed to start channel server4", zap.Error(err))
+		logger.Fatal("Failed to start maintenance mode", zap.Error(err))
+	}
+	metricsSources = append(metricsSources, lockdownServer)
+
+	// Scheduled DB maintenance.
+	var maintenanceServer *maintenanceserver.Server
+	if erupeConfig.Maintenance.Enabled {
+		maintenanceServer = maintenanceserver.NewServer(
+			&maintenanceserver.Config{
+				Logger:      logger.Named("maintenance"),
+				DB:          db,
+				ErupeConfig: erupeConfig,
+			})
+		err = maintenanceServer.Start()
+		if err != nil {
+			logger.Fatal("Failed to start maintenance scheduler", zap.Error(err))
+		}
+		metricsSources = append(metricsSources, maintenanceServer)
+		logger.Info("Started maintenance scheduler.")
+	}
+
+	// Metrics HTTP server.
+	var metricsServer *metricsserver.Server
+	if erupeConfig.Metrics.Enabled {
+		metricsServer = metricsserver.NewServer(
+			&metricsserver.Config{
+				Logger:      logger.Named("metrics"),
+				ErupeConfig: erupeConfig,
+				Sources:     metricsSources,
+				Ready:       restartServer.Ready,
+			})
+		err = metricsServer.Start()
+		if err != nil {
+			logger.Fatal("Failed to start metrics server", zap.Error(err))
+		}
+		logger.Info("Started metrics server.")
 	}
-	// Wait for exit or interrupt with ctrl+C.
+
+	// Reloads config.json into the running config, on SIGHUP and through the admin API.
+	reloadConfig := func() ([]string, error) {
+		restartRequired, err := config.Reload(erupeConfig)
+		if err != nil {
+			logger.Warn("Kept the running config, failed to reload it", zap.Error(err))
+			return nil, err
+		}
+		signServer.ConfigReloaded()
+		for _, channelServer := range channelServers {
+			channelServer.ConfigReloaded()
+		}
+		if len(restartRequired) > 0 {
+			logger.Warn("Reloaded config changes settings that need a restart, they're left as they were", zap.Strings("settings", restartRequired))
+		}
+		logger.Info("Reloaded config.")
+		return restartRequired, nil
+	}
+
+	// Admin HTTP API.
+	var adminServer *adminserver.Server
+	if erupeConfig.Admin.Enabled {
+		adminServer = adminserver.NewServer(
+			&adminserver.Config{
+				Logger:      logger.Named("admin"),
+				DB:          db,
+				ErupeConfig: erupeConfig,
+				Registries:  registries,
+				IPBans:      ipBans,
+				Restarts:    restartServer,
+				Maintenance: lockdownServer,
+				Tasks:       maintenanceServer,
+				Items:       items,
+
+				ReloadConfig: reloadConfig,
+			})
+		err = adminServer.Start()
+		if err != nil {
+			logger.Fatal("Failed to start admin server", zap.Error(err))
+		}
+		logger.Info("Started admin server.")
+	}
+
+	// A process of registered channels stops once every one was drained through the admin API.
+	drained := make(chan struct{})
+	if erupeConfig.Channel.Register {
+		go func() {
+			for _, channelServer := range channelServers {
+				<-channelServer.Drained()
+			}
+			close(drained)
+		}()
+	}
+
+	// Wait for exit or interrupt with ctrl+C, for a scheduled restart or for the channels to drain,
+	// reloading the config on SIGHUP.
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	<-c
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	restarting := false
+wait:
+	for {
+		select {
+		case <-hup:
+			reloadConfig()
+		case <-c:
+			logger.Info("Trying to shutdown gracefully.")
+			break wait
+		case <-restartServer.Drain():
+			restarting = true
+			logger.Info("Draining players for a scheduled restart.")
+			break wait
+		case <-drained:
+			logger.Info("Every channel was drained, shutting down.")
+			break wait
+		}
+	}
+	signal.Stop(hup)
 
-	logger.Info("Trying to shutdown gracefully.")
-	channelServer4.Shutdown()
-	channelServer3.Shutdown()
-	channelServer2.Shutdown()
-	channelServer1.Shutdown()
+	restartServer.Shutdown()
+	lockdownServer.Shutdown()
+	if metricsServer != nil {
+		metricsServer.Shutdown()
+	}
+	if adminServer != nil {
+		adminServer.Shutdown()
+	}
+	if maintenanceServer != nil {
+		maintenanceServer.Shutdown()
+	}
+	// Shut the channels down together so players only sit through one countdown.
+	grace := time.Duration(erupeConfig.Channel.ShutdownCountdown+erupeConfig.Channel.ShutdownGracePeriod) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	var wg sync.WaitGroup
+	for _, channelServer := range channelServers {
+		wg.Add(1)
+		go func(channelServer *channelserver.Server) {
+			defer wg.Done()
+			channelServer.Shutdown(ctx)
+		}(channelServer)
+	}
+	wg.Wait()
+	cancel()
+	if postgresChatBus != nil {
+		postgresChatBus.Close()
+	}
+	revocationListener.Close()
 	signServer.Shutdown()
 	entranceServer.Shutdown()
+	ipBans.Shutdown()
 	launcherServer.Shutdown()
+	traceExporter.Shutdown()
 
 	time.Sleep(1 * time.Second)
+	if restarting {
+		// Distinct from a stop, so a supervisor only starts the server again after a scheduled restart.
+		// os.Exit skips the deferred calls, so the log is flushed here.
+		zapLogger.Sync()
+		os.Exit(erupeConfig.Restarts.ExitCode)
+	}
+}
+
+// worldChannel is a channel server started for a channel of a world in the entrance list.
+type worldChannel struct {
+	server        *channelserver.Server
+	world         string
+	channel       config.EntranceChannelInfo
+	relaysDiscord bool
+}
+
+// startChannelServers starts one channel server per channel of every world in the entrance list, configured
+// as base with the world's name and the channel's capacity and default stage. A single channel relays chat to
+// Discord, otherwise every message would be posted once per channel: the second world's first channel, which
+// had the bot when the four channels were started one by one, or the first world's with a single world.
+func startChannelServers(erupeConfig *config.Config, base channelserver.Config, discordBot *discordbot.DiscordBot) ([]worldChannel, error) {
+	discordWorld := 1
+	if len(erupeConfig.Entrance.Entries) < 2 {
+		discordWorld = 0
+	}
+	var started []worldChannel
+	for w, world := range erupeConfig.Entrance.Entries {
+		for i, channel := range world.Channels {
+			channelConfig := base
+			relaysDiscord := w == discordWorld && i == 0
+			if relaysDiscord {
+				channelConfig.DiscordBot = discordBot
+			}
+			channelConfig.Name = world.Name
+			channelConfig.World = world.Name
+			channelConfig.Index = i
+			channelConfig.Enable = channel.MaxPlayers > 0
+			channelConfig.DefaultStage = world.DefaultStageFor(i)
+			server := channelserver.NewServer(&channelConfig)
+			if err := server.Start(int(channel.Port)); err != nil {
+				return started, fmt.Errorf("%s port %d: %w", world.Name, channel.Port, err)
+			}
+			started = append(started, worldChannel{server, world.Name, channel, relaysDiscord})
+		}
+	}
+	return started, nil
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/server/channelserver"
+	"github.com/Solenataris/Erupe/server/discordbot"
+	"go.uber.org/zap"
+)
+
+func TestStartChannelServers(t *testing.T) {
+	erupeConfig := &config.Config{}
+	for _, name := range []string{"World #1", "World #2"} {
+		world := config.EntranceServerInfo{Name: name}
+		for i := 0; i < 2; i++ {
+			port, err := freePort()
+			if err != nil {
+				t.Fatal(err)
+			}
+			world.Channels = append(world.Channels, config.EntranceChannelInfo{Port: port, MaxPlayers: 100})
+		}
+		erupeConfig.Entrance.Entries = append(erupeConfig.Entrance.Entries, world)
+	}
+
+	registry := channelserver.NewChannelRegistry()
+	started, err := startChannelServers(erupeConfig, channelserver.Config{
+		Logger:      zap.NewNop(),
+		ErupeConfig: erupeConfig,
+		Registry:    registry,
+	}, &discordbot.DiscordBot{})
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		for _, channel := range started {
+			channel.server.Shutdown(ctx)
+		}
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(started) != 4 || len(registry.Servers()) != 4 {
+		t.Fatalf("started %d channels, %d registered, want 4", len(started), len(registry.Servers()))
+	}
+
+	for i, channel := range started {
+		world := erupeConfig.Entrance.Entries[i/2]
+		if channel.world != world.Name || channel.channel.Port != world.Channels[i%2].Port {
+			t.Errorf("channel %d is %s port %d, want %s port %d", i, channel.world, channel.channel.Port, world.Name, world.Channels[i%2].Port)
+		}
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", channel.channel.Port))
+		if err != nil {
+			t.Errorf("channel %d doesn't accept connections: %v", i, err)
+			continue
+		}
+		conn.Close()
+		// Only the second world's first channel relays chat to Discord.
+		if want := i == 2; channel.relaysDiscord != want {
+			t.Errorf("channel %d relays to Discord %v, want %v", i, channel.relaysDiscord, want)
+		}
+	}
+}
+
+func TestLegacyChannelPorts(t *testing.T) {
+	erupeConfig := &config.Config{}
+	erupeConfig.Channel.Port1 = 54001
+	erupeConfig.Channel.Port3 = 54003
+	erupeConfig.Entrance.Entries = []config.EntranceServerInfo{
+		{Name: "World #1", Channels: []config.EntranceChannelInfo{{Port: 1}, {Port: 2}}},
+		{Name: "World #2", Channels: []config.EntranceChannelInfo{{Port: 3}}},
+	}
+	warnings := erupeConfig.LegacyChannelPorts()
+	if len(warnings) != 2 {
+		t.Errorf("got warnings %q, want one per deprecated key set", warnings)
+	}
+	ports := []uint16{erupeConfig.Entrance.Entries[0].Channels[0].Port, erupeConfig.Entrance.Entries[0].Channels[1].Port, erupeConfig.Entrance.Entries[1].Channels[0].Port}
+	if ports[0] != 54001 || ports[1] != 2 || ports[2] != 3 {
+		t.Errorf("got ports %v, want only the first world's first channel moved", ports)
+	}
+}
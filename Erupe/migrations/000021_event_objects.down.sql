@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE public.event_object_claims;
+END;
@@ -0,0 +1,11 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.event_object_claims
+(
+    object_key text NOT NULL,
+    character_id integer NOT NULL,
+    claimed_at timestamp without time zone NOT NULL DEFAULT now(),
+    CONSTRAINT event_object_claims_pkey PRIMARY KEY (object_key, character_id)
+);
+
+END;
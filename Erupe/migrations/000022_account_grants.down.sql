@@ -0,0 +1,4 @@
+BEGIN;
+DROP TABLE public.account_grants;
+ALTER TABLE IF EXISTS public.users DROP COLUMN IF EXISTS created_at;
+END;
@@ -0,0 +1,16 @@
+BEGIN;
+
+ALTER TABLE IF EXISTS public.users
+    ADD COLUMN IF NOT EXISTS created_at timestamp without time zone;
+ALTER TABLE IF EXISTS public.users
+    ALTER COLUMN created_at SET DEFAULT now();
+
+CREATE TABLE IF NOT EXISTS public.account_grants
+(
+    user_id integer NOT NULL,
+    grant_key text NOT NULL,
+    granted_at timestamp without time zone NOT NULL DEFAULT now(),
+    CONSTRAINT account_grants_pkey PRIMARY KEY (user_id, grant_key)
+);
+
+END;
@@ -0,0 +1,4 @@
+BEGIN;
+DROP TABLE public.festa_character_teams;
+DROP TABLE public.festa_guild_teams;
+END;
@@ -0,0 +1,21 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.festa_guild_teams
+(
+    festa_id integer NOT NULL,
+    guild_id integer NOT NULL,
+    team smallint NOT NULL,
+    CONSTRAINT festa_guild_teams_pkey PRIMARY KEY (festa_id, guild_id)
+);
+
+-- Guild membership at the time the teams were assigned, used when characters change guild mid-festa.
+CREATE TABLE IF NOT EXISTS public.festa_character_teams
+(
+    festa_id integer NOT NULL,
+    character_id integer NOT NULL,
+    guild_id integer NOT NULL,
+    team smallint NOT NULL,
+    CONSTRAINT festa_character_teams_pkey PRIMARY KEY (festa_id, character_id)
+);
+
+END;
@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE public.rental_items;
+END;
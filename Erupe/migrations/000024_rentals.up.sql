@@ -0,0 +1,15 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.rental_items
+(
+    id serial NOT NULL PRIMARY KEY,
+    character_id integer NOT NULL,
+    item_type smallint NOT NULL,
+    item_id integer NOT NULL,
+    granted_at timestamp without time zone NOT NULL DEFAULT now(),
+    expires_at timestamp without time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS rental_items_character_id_index ON public.rental_items (character_id);
+
+END;
@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE public.rng_streams;
+END;
@@ -0,0 +1,12 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.rng_streams
+(
+    server_name text NOT NULL,
+    stream text NOT NULL,
+    seed bigint NOT NULL,
+    draws bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (server_name, stream)
+);
+
+END;
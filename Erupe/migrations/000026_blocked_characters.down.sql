@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE public.blocked_characters;
+END;
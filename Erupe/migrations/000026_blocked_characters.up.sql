@@ -0,0 +1,10 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.blocked_characters
+(
+    character_id integer NOT NULL,
+    blocked_id integer NOT NULL,
+    PRIMARY KEY (character_id, blocked_id)
+);
+
+END;
@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE public.maintenance_runs;
+END;
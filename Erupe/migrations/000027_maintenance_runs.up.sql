@@ -0,0 +1,12 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.maintenance_runs
+(
+    task text NOT NULL PRIMARY KEY,
+    started_at timestamp without time zone NOT NULL,
+    completed_at timestamp without time zone,
+    rows_affected bigint NOT NULL DEFAULT 0,
+    status text NOT NULL
+);
+
+END;
@@ -0,0 +1,14 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.bans
+(
+    id serial NOT NULL PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users (id) ON DELETE CASCADE,
+    reason text NOT NULL DEFAULT '',
+    created_at timestamp without time zone NOT NULL DEFAULT now(),
+    expires_at timestamp without time zone
+);
+
+CREATE INDEX IF NOT EXISTS bans_user_id_index ON public.bans (user_id);
+
+END;
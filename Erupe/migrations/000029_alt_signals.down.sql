@@ -0,0 +1,5 @@
+BEGIN;
+DROP TABLE public.alt_signal_progress;
+DROP TABLE public.alt_signals;
+DROP TABLE public.login_history;
+END;
@@ -0,0 +1,36 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.login_history
+(
+    id serial NOT NULL PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users (id) ON DELETE CASCADE,
+    ip text NOT NULL,
+    fingerprint text NOT NULL DEFAULT '',
+    logged_in_at timestamp without time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS login_history_ip_index ON public.login_history (ip, logged_in_at);
+CREATE INDEX IF NOT EXISTS login_history_fingerprint_index ON public.login_history (fingerprint);
+
+CREATE TABLE IF NOT EXISTS public.alt_signals
+(
+    user_id integer NOT NULL,
+    other_user_id integer NOT NULL,
+    signal text NOT NULL,
+    detail text NOT NULL,
+    count integer NOT NULL DEFAULT 1,
+    first_seen timestamp without time zone NOT NULL,
+    last_seen timestamp without time zone NOT NULL,
+    PRIMARY KEY (user_id, other_user_id, signal, detail)
+);
+
+CREATE INDEX IF NOT EXISTS alt_signals_other_user_id_index ON public.alt_signals (other_user_id);
+
+CREATE TABLE IF NOT EXISTS public.alt_signal_progress
+(
+    last_login_id integer NOT NULL
+);
+
+INSERT INTO public.alt_signal_progress (last_login_id) SELECT 0 WHERE NOT EXISTS (SELECT 1 FROM public.alt_signal_progress);
+
+END;
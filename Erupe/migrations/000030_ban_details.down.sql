@@ -0,0 +1,4 @@
+BEGIN;
+ALTER TABLE public.bans DROP COLUMN character_id;
+ALTER TABLE public.bans DROP COLUMN issued_by;
+END;
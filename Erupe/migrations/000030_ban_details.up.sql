@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE public.bans ADD COLUMN IF NOT EXISTS character_id integer REFERENCES public.characters (id) ON DELETE CASCADE;
+ALTER TABLE public.bans ADD COLUMN IF NOT EXISTS issued_by text NOT NULL DEFAULT '';
+
+END;
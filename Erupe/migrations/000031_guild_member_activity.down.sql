@@ -0,0 +1,4 @@
+BEGIN;
+DROP TABLE public.guild_member_notes;
+DROP TABLE public.guild_member_activity;
+END;
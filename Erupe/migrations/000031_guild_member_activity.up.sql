@@ -0,0 +1,22 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.guild_member_activity
+(
+    character_id integer NOT NULL,
+    day date NOT NULL,
+    guild_quests integer NOT NULL DEFAULT 0,
+    gcp_earned integer NOT NULL DEFAULT 0,
+    PRIMARY KEY (character_id, day)
+);
+
+CREATE TABLE IF NOT EXISTS public.guild_member_notes
+(
+    guild_id integer NOT NULL,
+    character_id integer NOT NULL,
+    note text NOT NULL,
+    author_id integer NOT NULL,
+    updated_at timestamp without time zone NOT NULL DEFAULT now(),
+    PRIMARY KEY (guild_id, character_id)
+);
+
+END;
@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE public.ip_bans;
+END;
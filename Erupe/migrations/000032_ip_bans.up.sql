@@ -0,0 +1,12 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.ip_bans
+(
+    id serial NOT NULL PRIMARY KEY,
+    range cidr NOT NULL UNIQUE,
+    reason text NOT NULL DEFAULT '',
+    issued_by text NOT NULL DEFAULT '',
+    created_at timestamp without time zone NOT NULL DEFAULT now()
+);
+
+END;
@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE public.admin_audit_log;
+END;
@@ -0,0 +1,15 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.admin_audit_log
+(
+    id serial NOT NULL PRIMARY KEY,
+    character_id integer REFERENCES public.characters (id) ON DELETE SET NULL,
+    action text NOT NULL,
+    detail text NOT NULL DEFAULT '',
+    issued_by text NOT NULL DEFAULT '',
+    created_at timestamp without time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS admin_audit_log_character_id_index ON public.admin_audit_log (character_id);
+
+END;
@@ -0,0 +1,3 @@
+BEGIN;
+ALTER TABLE public.users DROP COLUMN password_version;
+END;
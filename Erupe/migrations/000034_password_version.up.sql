@@ -0,0 +1,7 @@
+BEGIN;
+
+-- 1 is bcrypt, which every existing password uses, 2 is argon2id.
+ALTER TABLE public.users
+    ADD COLUMN IF NOT EXISTS password_version integer NOT NULL DEFAULT 1;
+
+END;
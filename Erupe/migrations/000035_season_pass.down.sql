@@ -0,0 +1,4 @@
+BEGIN;
+DROP TABLE public.season_pass_claims;
+DROP TABLE public.season_pass_points;
+END;
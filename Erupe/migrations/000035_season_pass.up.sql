@@ -0,0 +1,22 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.season_pass_points
+(
+    character_id integer NOT NULL,
+    season integer NOT NULL,
+    points integer NOT NULL DEFAULT 0,
+    daily_claimed date,
+    PRIMARY KEY (character_id, season)
+);
+
+CREATE TABLE IF NOT EXISTS public.season_pass_claims
+(
+    character_id integer NOT NULL,
+    season integer NOT NULL,
+    tier integer NOT NULL,
+    premium boolean NOT NULL,
+    claimed_at timestamp without time zone NOT NULL DEFAULT now(),
+    PRIMARY KEY (character_id, season, tier, premium)
+);
+
+END;
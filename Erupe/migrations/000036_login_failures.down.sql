@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE public.login_failures;
+END;
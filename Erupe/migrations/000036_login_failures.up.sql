@@ -0,0 +1,11 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.login_failures
+(
+    key text NOT NULL,
+    failed_at timestamp without time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS login_failures_key_failed_at_index ON public.login_failures (key, failed_at);
+
+END;
@@ -0,0 +1,4 @@
+BEGIN;
+DROP INDEX IF EXISTS public.sign_sessions_issued_at_index;
+ALTER TABLE public.sign_sessions DROP COLUMN issued_at;
+END;
@@ -0,0 +1,9 @@
+BEGIN;
+
+-- Sign in tokens expire a while after they're issued and are deleted once used.
+ALTER TABLE public.sign_sessions
+    ADD COLUMN IF NOT EXISTS issued_at timestamptz NOT NULL DEFAULT now();
+
+CREATE INDEX IF NOT EXISTS sign_sessions_issued_at_index ON public.sign_sessions (issued_at);
+
+END;
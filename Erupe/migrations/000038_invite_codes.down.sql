@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE public.invite_codes;
+END;
@@ -0,0 +1,12 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.invite_codes
+(
+    code text NOT NULL PRIMARY KEY,
+    max_uses integer NOT NULL DEFAULT 1,
+    uses integer NOT NULL DEFAULT 0,
+    expires_at timestamptz,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+END;
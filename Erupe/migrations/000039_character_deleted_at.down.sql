@@ -0,0 +1,3 @@
+BEGIN;
+ALTER TABLE public.characters DROP COLUMN deleted_at;
+END;
@@ -0,0 +1,7 @@
+BEGIN;
+
+-- Deleted characters are kept for a while so they can be restored, then purged.
+ALTER TABLE public.characters
+    ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+
+END;
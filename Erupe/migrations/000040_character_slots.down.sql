@@ -0,0 +1,3 @@
+BEGIN;
+ALTER TABLE public.users DROP COLUMN extra_slots;
+END;
@@ -0,0 +1,7 @@
+BEGIN;
+
+-- Character slots bought on top of CharacterSlots.Base.
+ALTER TABLE public.users
+    ADD COLUMN IF NOT EXISTS extra_slots integer NOT NULL DEFAULT 0;
+
+END;
@@ -0,0 +1,4 @@
+BEGIN;
+DROP TABLE public.raviente_contributions;
+DROP TABLE public.raviente_cycles;
+END;
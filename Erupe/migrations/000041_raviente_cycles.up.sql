@@ -0,0 +1,23 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.raviente_cycles
+(
+    channel text NOT NULL,
+    cycle integer NOT NULL,
+    register bytea NOT NULL,
+    state bytea NOT NULL,
+    support bytea NOT NULL,
+    saved_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (channel, cycle)
+);
+
+CREATE TABLE IF NOT EXISTS public.raviente_contributions
+(
+    channel text NOT NULL,
+    cycle integer NOT NULL,
+    character_id integer NOT NULL,
+    damage bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (channel, cycle, character_id)
+);
+
+END;
@@ -0,0 +1,4 @@
+BEGIN;
+DROP TABLE public.rank_reset_snapshots;
+DROP TABLE public.rank_resets;
+END;
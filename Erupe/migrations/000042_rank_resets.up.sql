@@ -0,0 +1,22 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.rank_resets
+(
+    name text NOT NULL PRIMARY KEY,
+    applied_at timestamptz NOT NULL DEFAULT now(),
+    reverted_at timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS public.rank_reset_snapshots
+(
+    reset text NOT NULL REFERENCES public.rank_resets (name) ON DELETE CASCADE,
+    character_id integer NOT NULL,
+    hrp integer NOT NULL,
+    new_hrp integer NOT NULL,
+    catch_up boolean NOT NULL DEFAULT false,
+    PRIMARY KEY (reset, character_id)
+);
+
+CREATE INDEX IF NOT EXISTS rank_reset_snapshots_character_id_index ON public.rank_reset_snapshots (character_id);
+
+END;
@@ -0,0 +1,4 @@
+BEGIN;
+-- The cleaned comments are kept, putting the corrupting ones back would break the guild lists again.
+DROP TABLE public.guild_comment_cleanups;
+END;
@@ -0,0 +1,48 @@
+BEGIN;
+
+-- Comments changed by the cleanup, the row count is how many guilds were affected.
+CREATE TABLE IF NOT EXISTS public.guild_comment_cleanups
+(
+    guild_id integer NOT NULL,
+    old_comment text NOT NULL,
+    new_comment text NOT NULL,
+    cleaned_at timestamptz NOT NULL DEFAULT now()
+);
+
+-- The rules of stringsupport.SanitizeSJIS with the 255 byte limit of guild comments.
+CREATE FUNCTION pg_temp.sanitize_guild_comment(comment text) RETURNS text AS $$
+DECLARE
+    result text := '';
+    ch text;
+BEGIN
+    FOREACH ch IN ARRAY regexp_split_to_array(comment, '') LOOP
+        CONTINUE WHEN ch ~ '[\x01-\x09\x0B-\x1F\x7F-\x9F]' OR ch = U&'\FFFD';
+        BEGIN
+            PERFORM convert_to(ch, 'SJIS');
+        EXCEPTION WHEN untranslatable_character THEN
+            CONTINUE;
+        END;
+        EXIT WHEN octet_length(convert_to(result || ch, 'SJIS')) > 255;
+        result := result || ch;
+    END LOOP;
+    RETURN result;
+END;
+$$ LANGUAGE plpgsql;
+
+INSERT INTO guild_comment_cleanups (guild_id, old_comment, new_comment)
+SELECT id, comment, cleaned FROM (
+    SELECT id, comment, pg_temp.sanitize_guild_comment(comment) AS cleaned FROM guilds
+) AS checked
+WHERE cleaned <> comment;
+
+UPDATE guilds SET comment = c.new_comment
+FROM guild_comment_cleanups c
+WHERE guilds.id = c.guild_id;
+
+DO $$
+BEGIN
+    RAISE NOTICE 'Cleaned the comments of % guilds, listed in guild_comment_cleanups',
+        (SELECT COUNT(*) FROM guild_comment_cleanups);
+END $$;
+
+END;
@@ -0,0 +1,3 @@
+BEGIN;
+ALTER TABLE public.users DROP COLUMN IF EXISTS moderator;
+END;
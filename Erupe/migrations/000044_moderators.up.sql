@@ -0,0 +1,4 @@
+BEGIN;
+-- Accounts allowed to run the moderator chat commands, such as "!tele <player>" and "!summon <player>".
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS moderator boolean NOT NULL DEFAULT false;
+END;
@@ -0,0 +1,4 @@
+BEGIN;
+-- Accounts allowed to run the admin chat commands, such as "!give <player> <itemID> <qty>".
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS admin boolean NOT NULL DEFAULT false;
+END;
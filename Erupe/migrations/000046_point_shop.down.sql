@@ -0,0 +1,4 @@
+BEGIN;
+DROP TABLE IF EXISTS public.point_shop_purchases;
+ALTER TABLE public.characters DROP COLUMN IF EXISTS festa_points;
+END;
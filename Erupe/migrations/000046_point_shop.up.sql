@@ -0,0 +1,14 @@
+BEGIN;
+-- Festa points are earned by charging festa souls and spent in the point shop.
+ALTER TABLE public.characters ADD COLUMN IF NOT EXISTS festa_points integer NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS public.point_shop_purchases
+(
+    character_id integer NOT NULL,
+    rotation integer NOT NULL,
+    item integer NOT NULL, -- Index of the item in the point shop catalog.
+    bought integer NOT NULL DEFAULT 0,
+    PRIMARY KEY (character_id, rotation, item)
+);
+
+END;
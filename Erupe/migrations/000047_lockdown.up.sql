@@ -0,0 +1,14 @@
+BEGIN;
+-- Maintenance mode, a single row kept across restarts so sign in stays closed.
+CREATE TABLE IF NOT EXISTS public.lockdown
+(
+    id integer PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+    active boolean NOT NULL DEFAULT false,
+    ends_at timestamp with time zone, -- End of the countdown, null for none.
+    kick boolean NOT NULL DEFAULT false, -- Disconnect everyone once the countdown ends.
+    kicked boolean NOT NULL DEFAULT false,
+    reason text NOT NULL DEFAULT '',
+    started_by text NOT NULL DEFAULT ''
+);
+
+END;
@@ -0,0 +1,16 @@
+BEGIN;
+-- Channels that registered themselves for the entrance servers to list, one row per running channel.
+CREATE TABLE IF NOT EXISTS public.server_channels
+(
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    world text NOT NULL, -- Name of the world in the entrance list the channel belongs to.
+    address text NOT NULL, -- IP address clients connect to.
+    port integer NOT NULL,
+    max_players integer NOT NULL,
+    current_players integer NOT NULL DEFAULT 0,
+    draining boolean NOT NULL DEFAULT false, -- Asked to empty, it's listed no longer and takes no new players.
+    heartbeat_at timestamp with time zone NOT NULL DEFAULT now(),
+    UNIQUE (address, port)
+);
+
+END;
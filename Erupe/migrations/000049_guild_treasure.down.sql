@@ -0,0 +1,8 @@
+BEGIN;
+
+DROP TABLE IF EXISTS public.guild_treasure_archive;
+DROP TABLE IF EXISTS public.guild_treasure_standings;
+DROP TABLE IF EXISTS public.guild_treasure_hunters;
+DROP TABLE IF EXISTS public.guild_treasure_runs;
+
+END;
@@ -0,0 +1,42 @@
+BEGIN;
+
+-- Treasure quest runs reported this week, dropped along with their unclaimed rewards once the week ends.
+CREATE TABLE IF NOT EXISTS public.guild_treasure_runs
+(
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    guild_id integer NOT NULL,
+    week integer NOT NULL,
+    score integer NOT NULL,
+    reported_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+-- The guild members who went on a run, by party slot, and whether they claimed their share.
+CREATE TABLE IF NOT EXISTS public.guild_treasure_hunters
+(
+    run_id integer NOT NULL REFERENCES public.guild_treasure_runs (id) ON DELETE CASCADE,
+    character_id integer NOT NULL,
+    slot integer NOT NULL,
+    claimed boolean NOT NULL DEFAULT false,
+    PRIMARY KEY (run_id, character_id)
+);
+
+-- Best score of each guild in the running week.
+CREATE TABLE IF NOT EXISTS public.guild_treasure_standings
+(
+    guild_id integer NOT NULL,
+    week integer NOT NULL,
+    best_score integer NOT NULL,
+    PRIMARY KEY (guild_id, week)
+);
+
+-- Final standings of the weeks that ended.
+CREATE TABLE IF NOT EXISTS public.guild_treasure_archive
+(
+    week integer NOT NULL,
+    guild_id integer NOT NULL,
+    rank integer NOT NULL,
+    best_score integer NOT NULL,
+    PRIMARY KEY (week, guild_id)
+);
+
+END;
@@ -0,0 +1,15 @@
+BEGIN;
+
+-- The latest announcements guild officers sent to every member, also when the guild may send the next.
+CREATE TABLE IF NOT EXISTS public.guild_announcements
+(
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    guild_id integer NOT NULL,
+    author_id integer NOT NULL,
+    message text NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS guild_announcements_guild_id_idx ON public.guild_announcements (guild_id, created_at);
+
+END;
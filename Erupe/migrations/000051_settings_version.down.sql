@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE public.guild_alliances DROP COLUMN IF EXISTS settings_version;
+ALTER TABLE public.guilds DROP COLUMN IF EXISTS settings_version;
+
+END;
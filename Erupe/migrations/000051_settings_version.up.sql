@@ -0,0 +1,7 @@
+BEGIN;
+
+-- Moved on by every write of a guild's or alliance's settings, a write based on an older version is refused.
+ALTER TABLE public.guilds ADD COLUMN IF NOT EXISTS settings_version integer NOT NULL DEFAULT 0;
+ALTER TABLE public.guild_alliances ADD COLUMN IF NOT EXISTS settings_version integer NOT NULL DEFAULT 0;
+
+END;
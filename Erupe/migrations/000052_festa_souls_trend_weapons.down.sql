@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP TABLE IF EXISTS public.trend_weapons;
+DROP TABLE IF EXISTS public.festa_souls;
+
+END;
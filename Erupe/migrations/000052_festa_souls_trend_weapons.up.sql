@@ -0,0 +1,22 @@
+BEGIN;
+
+-- Souls each character charged for the guild it was in during a festa, the guild standings add them up.
+CREATE TABLE IF NOT EXISTS public.festa_souls
+(
+    festa_id integer NOT NULL,
+    guild_id integer NOT NULL,
+    character_id integer NOT NULL,
+    souls integer NOT NULL DEFAULT 0,
+    PRIMARY KEY (festa_id, guild_id, character_id)
+);
+
+-- Times each weapon was reported in use, the trend weapons are the most used of each weapon type.
+CREATE TABLE IF NOT EXISTS public.trend_weapons
+(
+    weapon_type smallint NOT NULL,
+    weapon_id integer NOT NULL,
+    uses integer NOT NULL DEFAULT 0,
+    PRIMARY KEY (weapon_type, weapon_id)
+);
+
+END;
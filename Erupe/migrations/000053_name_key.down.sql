@@ -0,0 +1,8 @@
+BEGIN;
+
+DROP INDEX IF EXISTS public.guilds_name_key_index;
+ALTER TABLE public.guilds DROP COLUMN IF EXISTS name_key;
+DROP INDEX IF EXISTS public.characters_name_key_index;
+ALTER TABLE public.characters DROP COLUMN IF EXISTS name_key;
+
+END;
@@ -0,0 +1,19 @@
+BEGIN;
+
+-- Names folded for case and width, so a name that only differs so from one taken can be turned away.
+-- Existing names are only lowercased here, the server writes the full key whenever it sets a name.
+ALTER TABLE public.characters
+    ADD COLUMN IF NOT EXISTS name_key text;
+
+UPDATE public.characters SET name_key = lower(name) WHERE name_key IS NULL;
+
+CREATE INDEX IF NOT EXISTS characters_name_key_index ON public.characters (name_key);
+
+ALTER TABLE public.guilds
+    ADD COLUMN IF NOT EXISTS name_key text;
+
+UPDATE public.guilds SET name_key = lower(name) WHERE name_key IS NULL;
+
+CREATE INDEX IF NOT EXISTS guilds_name_key_index ON public.guilds (name_key);
+
+END;
@@ -0,0 +1,13 @@
+BEGIN;
+
+-- The savedatas a character's saves replaced, the most recent ones kept per character.
+CREATE TABLE IF NOT EXISTS public.savedata_backups (
+    id serial PRIMARY KEY,
+    char_id integer NOT NULL,
+    savedata bytea NOT NULL,
+    replaced_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS savedata_backups_char_id_index ON public.savedata_backups (char_id, id);
+
+END;
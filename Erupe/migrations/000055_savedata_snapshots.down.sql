@@ -0,0 +1,9 @@
+BEGIN;
+
+DELETE FROM public.savedata_backups WHERE kind <> 'save';
+DROP INDEX IF EXISTS public.savedata_backups_kind_index;
+ALTER TABLE public.savedata_backups DROP COLUMN IF EXISTS hash;
+ALTER TABLE public.savedata_backups DROP COLUMN IF EXISTS kind;
+ALTER TABLE public.savedata_backups RENAME COLUMN taken_at TO replaced_at;
+
+END;
@@ -0,0 +1,13 @@
+BEGIN;
+
+-- Besides the savedatas replaced by saves, the backups hold the daily snapshots of each character's
+-- savedata, only taken when it changed since the last one, and the savedatas replaced by restores.
+ALTER TABLE public.savedata_backups RENAME COLUMN replaced_at TO taken_at;
+
+ALTER TABLE public.savedata_backups
+    ADD COLUMN IF NOT EXISTS kind text NOT NULL DEFAULT 'save',
+    ADD COLUMN IF NOT EXISTS hash bytea;
+
+CREATE INDEX IF NOT EXISTS savedata_backups_kind_index ON public.savedata_backups (char_id, kind, id);
+
+END;
@@ -0,0 +1,9 @@
+BEGIN;
+
+DROP INDEX IF EXISTS public.admin_audit_log_category_index;
+ALTER TABLE public.admin_audit_log DROP COLUMN IF EXISTS rule;
+ALTER TABLE public.admin_audit_log DROP COLUMN IF EXISTS category;
+ALTER TABLE public.bans DROP COLUMN IF EXISTS category;
+DROP TABLE IF EXISTS public.mutes;
+
+END;
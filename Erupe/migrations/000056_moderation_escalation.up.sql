@@ -0,0 +1,25 @@
+BEGIN;
+
+-- Chat mutes, on the whole account like bans. A mute without expires_at is permanent.
+CREATE TABLE IF NOT EXISTS public.mutes
+(
+    id serial NOT NULL PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users (id) ON DELETE CASCADE,
+    reason text NOT NULL DEFAULT '',
+    category text NOT NULL DEFAULT '',
+    issued_by text NOT NULL DEFAULT '',
+    created_at timestamp without time zone NOT NULL DEFAULT now(),
+    expires_at timestamp without time zone
+);
+
+CREATE INDEX IF NOT EXISTS mutes_user_id_index ON public.mutes (user_id);
+
+-- The offense category of a moderation action, which its escalation ladder counts the account's
+-- earlier ones by, and the ladder step that decided it.
+ALTER TABLE public.bans ADD COLUMN IF NOT EXISTS category text NOT NULL DEFAULT '';
+ALTER TABLE public.admin_audit_log ADD COLUMN IF NOT EXISTS category text NOT NULL DEFAULT '';
+ALTER TABLE public.admin_audit_log ADD COLUMN IF NOT EXISTS rule text NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS admin_audit_log_category_index ON public.admin_audit_log (category) WHERE category <> '';
+
+END;
@@ -0,0 +1,14 @@
+BEGIN;
+
+-- The diffs of a character's saves since its savedata was last written whole, applied in id order
+-- onto characters.savedata. Channel.SavedataDiffs bounds how many a character has.
+CREATE TABLE IF NOT EXISTS public.savedata_diffs (
+    id serial PRIMARY KEY,
+    char_id integer NOT NULL,
+    diff bytea NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS savedata_diffs_char_id_index ON public.savedata_diffs (char_id, id);
+
+END;
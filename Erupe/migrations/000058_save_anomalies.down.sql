@@ -0,0 +1,5 @@
+BEGIN;
+
+DROP TABLE IF EXISTS public.save_anomalies;
+
+END;
@@ -0,0 +1,16 @@
+BEGIN;
+
+-- The saves whose savedata changed in size past Channel.SavedataQuota.ChangePercent from the
+-- character's previous one. kept_previous is set when the save was refused for it.
+CREATE TABLE IF NOT EXISTS public.save_anomalies (
+    id serial PRIMARY KEY,
+    char_id integer NOT NULL,
+    previous_size integer NOT NULL,
+    size integer NOT NULL,
+    kept_previous boolean NOT NULL DEFAULT false,
+    created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS save_anomalies_char_id_index ON public.save_anomalies (char_id, id);
+
+END;
@@ -0,0 +1,10 @@
+BEGIN;
+
+-- The content gates of the config opened before their date through the admin API, by gate name.
+CREATE TABLE IF NOT EXISTS public.content_gate_overrides (
+    name text PRIMARY KEY,
+    opened_by text NOT NULL,
+    opened_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+END;
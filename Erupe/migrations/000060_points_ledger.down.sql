@@ -0,0 +1,5 @@
+BEGIN;
+
+DROP TABLE IF EXISTS public.points_ledger;
+
+END;
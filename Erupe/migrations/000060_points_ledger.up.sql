@@ -0,0 +1,17 @@
+BEGIN;
+
+-- Every change of a character's point balances made through channelserver.AddPoints, with what
+-- changed it, for looking into disputes. delta is what was applied after the cap.
+CREATE TABLE IF NOT EXISTS public.points_ledger (
+    id serial PRIMARY KEY,
+    char_id integer NOT NULL,
+    currency text NOT NULL,
+    delta bigint NOT NULL,
+    balance bigint NOT NULL,
+    source text NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS points_ledger_char_id_index ON public.points_ledger (char_id, id);
+
+END;
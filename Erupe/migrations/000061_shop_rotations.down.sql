@@ -0,0 +1,17 @@
+BEGIN;
+
+DELETE FROM public.normal_shop_items WHERE (shoptype = 7 AND shopid = 0) OR (shoptype = 8 AND shopid = 5);
+
+ALTER TABLE public.shop_item_state RENAME COLUMN period TO week;
+UPDATE public.shop_item_state SET week = week % 100;
+
+DROP TABLE IF EXISTS public.shop_rotations;
+
+ALTER TABLE public.normal_shop_items
+    DROP COLUMN IF EXISTS limit_period,
+    DROP COLUMN IF EXISTS rotation_group,
+    DROP COLUMN IF EXISTS rotation_slot,
+    DROP COLUMN IF EXISTS active_from,
+    DROP COLUMN IF EXISTS active_until;
+
+END;
@@ -0,0 +1,73 @@
+BEGIN;
+
+-- Shop items can be held to a window and to a slot of a rotation, and their purchase limits can reset.
+-- limit_period is '' for a limit that never resets, 'daily', 'weekly' or 'rotation'. enable_weeks and
+-- week came from road-shop-rotation.sql, they're added here for databases that never ran it.
+ALTER TABLE public.normal_shop_items
+    ADD COLUMN IF NOT EXISTS enable_weeks character varying(8),
+    ADD COLUMN IF NOT EXISTS limit_period text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS rotation_group text,
+    ADD COLUMN IF NOT EXISTS rotation_slot integer NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS active_from timestamp with time zone,
+    ADD COLUMN IF NOT EXISTS active_until timestamp with time zone;
+
+-- A rotation steps to its next slot every period_days from starts_at, back to slot 0 after the last one.
+CREATE TABLE IF NOT EXISTS public.shop_rotations (
+    rotation_group text PRIMARY KEY,
+    starts_at timestamp with time zone NOT NULL,
+    period_days integer NOT NULL CHECK (period_days > 0),
+    slots integer NOT NULL CHECK (slots > 0)
+);
+
+-- week becomes the period a purchase was counted in, 0 for the limits that never reset.
+ALTER TABLE public.shop_item_state ADD COLUMN IF NOT EXISTS week int;
+ALTER TABLE public.shop_item_state RENAME COLUMN week TO period;
+
+-- Shop 7 was the one reset weekly, by ISO week, now counted as the ISO year * 100 + the week.
+UPDATE public.normal_shop_items SET limit_period = 'weekly' WHERE shopid = 7;
+UPDATE public.shop_item_state SET period = EXTRACT(isoyear FROM now())::int * 100 + period
+    WHERE period IS NOT NULL AND itemhash IN (SELECT itemhash FROM public.normal_shop_items WHERE shopid = 7);
+UPDATE public.shop_item_state SET period = 0
+    WHERE itemhash NOT IN (SELECT itemhash FROM public.normal_shop_items WHERE shopid = 7);
+
+-- The GCP exchange (7, 0) and the Diva Defense skill store (8, 5) the server used to send as fixed lists.
+INSERT INTO public.normal_shop_items (shoptype, shopid, itemhash, itemid, points, tradequantity, rankreqlow,
+    rankreqhigh, rankreqg, storelevelreq, maximumquantity, boughtquantity, roadfloorsrequired, weeklyfataliskills)
+VALUES
+    (7, 0, 982615803, 13190, 10, 1, 0, 0, 0, 0, 0, 0, 0, 0),
+    (7, 0, 159374108, 1662, 10, 1, 0, 0, 0, 0, 0, 0, 0, 0),
+    (7, 0, 326425385, 10179, 100, 1, 0, 0, 0, 0, 0, 0, 0, 0),
+    (8, 5, 747857345, 1, 30, 10, 0, 0, 0, 0, 10, 0, 0, 0),
+    (8, 5, 427422146, 2, 60, 10, 0, 0, 0, 0, 10, 0, 0, 0),
+    (8, 5, 1045534687, 3, 60, 10, 0, 0, 0, 0, 10, 0, 0, 0),
+    (8, 5, 563296192, 4, 30, 10, 0, 0, 0, 0, 10, 0, 0, 0),
+    (8, 5, 162679965, 20, 30, 10, 0, 0, 0, 0, 10, 0, 0, 0),
+    (8, 5, 522013038, 21, 30, 10, 0, 0, 0, 0, 10, 0, 0, 0),
+    (8, 5, 999395275, 22, 60, 10, 0, 0, 0, 0, 10, 0, 0, 0),
+    (8, 5, 192927772, 23, 60, 10, 0, 0, 0, 0, 10, 0, 0, 0),
+    (8, 5, 326431289, 24, 60, 10, 0, 0, 0, 0, 10, 0, 0, 0),
+    (8, 5, 647302330, 28, 60, 10, 0, 0, 0, 0, 10, 0, 0, 0),
+    (8, 5, 932702951, 29, 60, 10, 299, 0, 0, 0, 10, 0, 0, 0),
+    (8, 5, 360024792, 5, 60, 0, 0, 0, 0, 0, 10, 0, 0, 0),
+    (8, 5, 1013986565, 6, 80, 10, 0, 0, 0, 1, 10, 0, 0, 0),
+    (8, 5, 619951446, 7, 80, 10, 0, 0, 0, 1, 10, 0, 0, 0),
+    (8, 5, 11927603, 8, 80, 10, 0, 0, 0, 1, 10, 0, 0, 0),
+    (8, 5, 788385412, 25, 80, 10, 0, 0, 0, 1, 10, 0, 0, 0),
+    (8, 5, 764634609, 26, 80, 10, 0, 0, 0, 1, 10, 0, 0, 0),
+    (8, 5, 427422530, 31, 80, 10, 299, 0, 0, 1, 10, 0, 0, 0),
+    (8, 5, 1062442959, 32, 80, 10, 299, 0, 0, 1, 10, 0, 0, 0),
+    (8, 5, 831731648, 33, 80, 10, 299, 0, 0, 1, 10, 0, 0, 0),
+    (8, 5, 145771709, 9, 100, 10, 0, 0, 0, 2, 10, 0, 0, 0),
+    (8, 5, 186469230, 10, 100, 10, 0, 0, 0, 2, 10, 0, 0, 0),
+    (8, 5, 999395307, 11, 100, 10, 0, 0, 0, 2, 10, 0, 0, 0),
+    (8, 5, 461363228, 12, 100, 10, 0, 0, 0, 2, 10, 0, 0, 0),
+    (8, 5, 309785129, 13, 100, 10, 0, 0, 0, 2, 10, 0, 0, 0),
+    (8, 5, 580193466, 14, 200, 10, 0, 0, 0, 2, 10, 0, 0, 0),
+    (8, 5, 915925719, 15, 500, 10, 0, 0, 0, 3, 10, 0, 0, 0),
+    (8, 5, 91589208, 16, 1000, 10, 0, 0, 0, 3, 10, 0, 0, 0),
+    (8, 5, 1013986597, 27, 500, 10, 0, 0, 1, 3, 10, 0, 0, 0),
+    (8, 5, 888387030, 30, 100, 10, 299, 0, 0, 3, 10, 0, 0, 0),
+    (8, 5, 11927555, 34, 100, 10, 0, 0, 1, 3, 10, 0, 0, 0)
+ON CONFLICT (itemhash) DO NOTHING;
+
+END;
@@ -0,0 +1,3 @@
+BEGIN;
+ALTER TABLE public.sign_sessions DROP COLUMN trace_parent;
+END;
@@ -0,0 +1,7 @@
+BEGIN;
+
+-- The W3C traceparent of the sign in a token was issued by, so the channel login continues its trace.
+ALTER TABLE public.sign_sessions
+    ADD COLUMN IF NOT EXISTS trace_parent text;
+
+END;
@@ -0,0 +1,5 @@
+BEGIN;
+DROP TABLE IF EXISTS public.gacha_history;
+DROP TABLE IF EXISTS public.gacha_pity;
+ALTER TABLE public.gacha_shop DROP COLUMN IF EXISTS pity_rolls, DROP COLUMN IF EXISTS pity_rarity;
+END;
@@ -0,0 +1,32 @@
+BEGIN;
+
+-- A banner guarantees an entry of at least pity_rarity on the roll that makes pity_rolls in a row
+-- without one, 0 pity_rolls for no guarantee.
+ALTER TABLE public.gacha_shop
+    ADD COLUMN IF NOT EXISTS pity_rolls int NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS pity_rarity int NOT NULL DEFAULT 0;
+
+-- Rolls a character made of a banner since it last drew an entry of the pity rarity.
+CREATE TABLE IF NOT EXISTS public.gacha_pity (
+    char_id integer NOT NULL,
+    shophash integer NOT NULL,
+    rolls integer NOT NULL DEFAULT 0,
+    PRIMARY KEY (char_id, shophash)
+);
+
+-- Every entry drawn, what the rates a banner publishes are checked against.
+CREATE TABLE IF NOT EXISTS public.gacha_history (
+    id serial PRIMARY KEY,
+    char_id integer NOT NULL,
+    shophash integer NOT NULL,
+    entry_type integer NOT NULL,
+    itemhash integer NOT NULL,
+    rarity integer NOT NULL,
+    pity boolean NOT NULL DEFAULT false,
+    rolled_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS gacha_history_shophash_index ON public.gacha_history (shophash, itemhash);
+CREATE INDEX IF NOT EXISTS gacha_history_char_id_index ON public.gacha_history (char_id, id);
+
+END;
@@ -0,0 +1,4 @@
+BEGIN;
+DROP INDEX IF EXISTS public.distributions_accepted_distribution_id_index;
+ALTER TABLE public.distribution DROP COLUMN IF EXISTS scope, DROP COLUMN IF EXISTS max_claims, DROP COLUMN IF EXISTS claims;
+END;
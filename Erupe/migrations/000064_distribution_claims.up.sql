@@ -0,0 +1,39 @@
+BEGIN;
+
+-- The distribution tables came from distitem.sql, a server without them gets them here.
+CREATE TABLE IF NOT EXISTS public.distribution
+(
+    id serial NOT NULL PRIMARY KEY,
+    character_id int,
+    type int NOT NULL,
+    deadline timestamp without time zone,
+    event_name text NOT NULL DEFAULT 'GM Gift!',
+    description text NOT NULL DEFAULT '~C05You received a gift!',
+    times_acceptable int NOT NULL DEFAULT 1,
+    min_hr int NOT NULL DEFAULT 65535,
+    max_hr int NOT NULL DEFAULT 65535,
+    min_sr int NOT NULL DEFAULT 65535,
+    max_sr int NOT NULL DEFAULT 65535,
+    min_gr int NOT NULL DEFAULT 65535,
+    max_gr int NOT NULL DEFAULT 65535,
+    data bytea NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public.distributions_accepted
+(
+    distribution_id int,
+    character_id int
+);
+
+-- times_acceptable counts the claims of each character, or of each account with the account scope.
+-- max_claims caps the claims of everyone together, NULL for no cap, and claims counts them.
+ALTER TABLE public.distribution
+    ADD COLUMN IF NOT EXISTS scope text NOT NULL DEFAULT 'character' CHECK (scope IN ('character', 'account')),
+    ADD COLUMN IF NOT EXISTS max_claims int,
+    ADD COLUMN IF NOT EXISTS claims int NOT NULL DEFAULT 0;
+
+UPDATE public.distribution d SET claims = (SELECT count(*) FROM public.distributions_accepted da WHERE da.distribution_id = d.id);
+
+CREATE INDEX IF NOT EXISTS distributions_accepted_distribution_id_index ON public.distributions_accepted (distribution_id, character_id);
+
+END;
@@ -0,0 +1,4 @@
+BEGIN;
+DROP TABLE IF EXISTS public.campaign_redemptions;
+DROP TABLE IF EXISTS public.campaign_codes;
+END;
@@ -0,0 +1,29 @@
+BEGIN;
+
+-- Codes typed in the campaign menu, each redeemable for its bundle of items, a list of
+-- {"itemID", "quantity"}. max_uses caps the redemptions of everyone together, NULL for no cap, and
+-- account_limit those of each account. The code can be redeemed from starts_at until ends_at, NULL
+-- leaving the window open on that side.
+CREATE TABLE IF NOT EXISTS public.campaign_codes
+(
+    code text NOT NULL PRIMARY KEY,
+    items jsonb NOT NULL,
+    max_uses integer,
+    uses integer NOT NULL DEFAULT 0,
+    account_limit integer NOT NULL DEFAULT 1,
+    starts_at timestamptz,
+    ends_at timestamptz,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS public.campaign_redemptions
+(
+    code text NOT NULL REFERENCES public.campaign_codes (code) ON DELETE CASCADE,
+    user_id integer NOT NULL,
+    character_id integer NOT NULL,
+    redeemed_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS campaign_redemptions_code_index ON public.campaign_redemptions (code, user_id);
+
+END;
@@ -0,0 +1,12 @@
+BEGIN;
+
+-- Titles handed out by the server, such as event object rewards, with when they were acquired.
+CREATE TABLE IF NOT EXISTS public.character_titles
+(
+    character_id integer NOT NULL,
+    title_id integer NOT NULL,
+    acquired_at timestamp without time zone NOT NULL DEFAULT now(),
+    CONSTRAINT character_titles_pkey PRIMARY KEY (character_id, title_id)
+);
+
+END;
@@ -0,0 +1,4 @@
+BEGIN;
+DROP TABLE IF EXISTS public.login_addresses;
+DROP TABLE IF EXISTS public.login_fingerprints;
+END;
@@ -0,0 +1,28 @@
+BEGIN;
+
+-- The fingerprints recorded so far hashed an unidentified sign in field, they're rebuilt from identified ones.
+UPDATE public.login_history SET fingerprint = '';
+
+-- Every account seen with a fingerprint or an address, so a new login is matched against the accounts
+-- through the primary key instead of against every earlier login.
+CREATE TABLE IF NOT EXISTS public.login_fingerprints
+(
+    fingerprint text NOT NULL,
+    user_id integer NOT NULL REFERENCES public.users (id) ON DELETE CASCADE,
+    last_seen timestamp without time zone NOT NULL,
+    PRIMARY KEY (fingerprint, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS public.login_addresses
+(
+    ip text NOT NULL,
+    user_id integer NOT NULL REFERENCES public.users (id) ON DELETE CASCADE,
+    last_seen timestamp without time zone NOT NULL,
+    PRIMARY KEY (ip, user_id)
+);
+
+INSERT INTO public.login_addresses (ip, user_id, last_seen)
+SELECT ip, user_id, MAX(logged_in_at) FROM public.login_history GROUP BY ip, user_id
+ON CONFLICT DO NOTHING;
+
+END;
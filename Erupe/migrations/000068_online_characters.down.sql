@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE IF EXISTS public.online_characters;
+END;
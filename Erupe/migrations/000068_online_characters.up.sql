@@ -0,0 +1,17 @@
+BEGIN;
+
+-- The characters logged in to a channel of any process, so the jobs editing what a session holds can
+-- tell. A row is kept fresh by its channel's heartbeat and counts only while seen_at is recent, a
+-- process that died leaves stale rows behind.
+CREATE TABLE IF NOT EXISTS public.online_characters
+(
+    character_id integer NOT NULL REFERENCES public.characters (id) ON DELETE CASCADE,
+    instance text NOT NULL, -- Channel holding the session, which refreshes seen_at.
+    session text NOT NULL,
+    seen_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT online_characters_pkey PRIMARY KEY (character_id)
+);
+
+CREATE INDEX IF NOT EXISTS online_characters_instance_index ON public.online_characters (instance);
+
+END;
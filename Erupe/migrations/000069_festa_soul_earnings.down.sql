@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE IF EXISTS public.festa_soul_earnings;
+END;
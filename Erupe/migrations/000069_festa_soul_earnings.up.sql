@@ -0,0 +1,14 @@
+BEGIN;
+
+-- Souls the server credited each character for the festa quests it returned from, and how many of them the
+-- character charged since. The client's count of its souls isn't trusted, a charge only takes what's left.
+CREATE TABLE IF NOT EXISTS public.festa_soul_earnings
+(
+    festa_id integer NOT NULL,
+    character_id integer NOT NULL,
+    earned integer NOT NULL DEFAULT 0,
+    charged integer NOT NULL DEFAULT 0,
+    PRIMARY KEY (festa_id, character_id)
+);
+
+END;
@@ -0,0 +1,3 @@
+BEGIN;
+ALTER TABLE public.sign_sessions DROP COLUMN IF EXISTS session;
+END;
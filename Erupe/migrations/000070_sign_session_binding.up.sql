@@ -0,0 +1,8 @@
+BEGIN;
+
+-- A sign in token stays valid until it expires or the session that logged in with it last logs out, the
+-- session that holds it. A channel change presents the token again and the new session takes it over.
+ALTER TABLE public.sign_sessions
+    ADD COLUMN IF NOT EXISTS session text;
+
+END;
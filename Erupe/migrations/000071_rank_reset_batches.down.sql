@@ -0,0 +1,11 @@
+BEGIN;
+
+ALTER TABLE public.rank_reset_snapshots
+    DROP COLUMN IF EXISTS grp,
+    DROP COLUMN IF EXISTS new_grp,
+    DROP COLUMN IF EXISTS reverted;
+
+ALTER TABLE public.rank_resets
+    DROP COLUMN IF EXISTS completed_at;
+
+END;
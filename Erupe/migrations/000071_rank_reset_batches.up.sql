@@ -0,0 +1,15 @@
+BEGIN;
+
+-- A rank reset runs in batches of characters, each in its own transaction. completed_at is set once the
+-- last batch commits, a run stopped before that picks up after the characters already snapshotted.
+ALTER TABLE public.rank_resets
+    ADD COLUMN IF NOT EXISTS completed_at timestamptz;
+
+UPDATE public.rank_resets SET completed_at = applied_at WHERE completed_at IS NULL;
+
+ALTER TABLE public.rank_reset_snapshots
+    ADD COLUMN IF NOT EXISTS grp integer NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS new_grp integer NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS reverted boolean NOT NULL DEFAULT false;
+
+END;
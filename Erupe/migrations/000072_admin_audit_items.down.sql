@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE public.admin_audit_log DROP COLUMN IF EXISTS item_id;
+ALTER TABLE public.admin_audit_log DROP COLUMN IF EXISTS quantity;
+
+END;
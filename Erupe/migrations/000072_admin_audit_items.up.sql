@@ -0,0 +1,7 @@
+BEGIN;
+
+-- The item and quantity of an item grant, kept out of the free text detail so grants can be queried.
+ALTER TABLE public.admin_audit_log ADD COLUMN IF NOT EXISTS item_id integer;
+ALTER TABLE public.admin_audit_log ADD COLUMN IF NOT EXISTS quantity integer;
+
+END;
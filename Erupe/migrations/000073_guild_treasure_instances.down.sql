@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP INDEX IF EXISTS public.guild_treasure_runs_instance_index;
+ALTER TABLE public.guild_treasure_runs DROP COLUMN IF EXISTS instance;
+
+END;
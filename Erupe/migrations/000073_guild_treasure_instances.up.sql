@@ -0,0 +1,7 @@
+BEGIN;
+
+-- The quest instance a run was recorded for, so the hunters returning from it record it once.
+ALTER TABLE public.guild_treasure_runs ADD COLUMN IF NOT EXISTS instance text;
+CREATE UNIQUE INDEX IF NOT EXISTS guild_treasure_runs_instance_index ON public.guild_treasure_runs (instance);
+
+END;
@@ -0,0 +1,5 @@
+BEGIN;
+
+ALTER TABLE public.characters DROP COLUMN IF EXISTS force_rename;
+
+END;
@@ -0,0 +1,6 @@
+BEGIN;
+
+-- Set when a save gave the character a name the name rules refuse. The save is kept, the name has to change.
+ALTER TABLE public.characters ADD COLUMN IF NOT EXISTS force_rename boolean NOT NULL DEFAULT false;
+
+END;
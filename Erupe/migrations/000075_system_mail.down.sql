@@ -0,0 +1,6 @@
+BEGIN;
+
+DELETE FROM public.mail WHERE sender_id IS NULL;
+ALTER TABLE public.mail ALTER COLUMN sender_id SET NOT NULL;
+
+END;
@@ -0,0 +1,6 @@
+BEGIN;
+
+-- Mail the server sends, such as moderator warnings, has no sending character.
+ALTER TABLE public.mail ALTER COLUMN sender_id DROP NOT NULL;
+
+END;
@@ -1,6 +1,10 @@
 package binpacket
 
 import (
+	"errors"
+
+	"github.com/Solenataris/Erupe/common/bfutil"
+	"github.com/Solenataris/Erupe/common/stringsupport"
 	"github.com/Solenataris/Erupe/network"
 	"github.com/Andoryuuta/byteframe"
 )
@@ -17,7 +21,14 @@ const (
 	ChatTypeWhisper           = 5
 )
 
-// MsgBinChat is a binpacket for chat messages.
+// Most bytes of a chat message and of its sender's name in Shift-JIS. The client's own are far shorter,
+// the bounds leave room for the server's messages, sent in the name of a channel or a guild.
+const (
+	chatMessageMaxLength = 1024
+	chatNameMaxLength    = 64
+)
+
+// MsgBinChat is a binpacket for chat messages, its strings decoded from Shift-JIS.
 type MsgBinChat struct {
 	Unk0       uint8
 	Type       ChatType
@@ -32,29 +43,57 @@ func (m *MsgBinChat) Opcode() network.PacketID {
 }
 
 // Parse parses the packet from binary
+// The sizes are the client's, a malformed message fails with bfutil.ErrShortRead, bfutil.ErrStringTooLong
+// or errEmptyChatString.
 func (m *MsgBinChat) Parse(bf *byteframe.ByteFrame) error {
-	m.Unk0 = bf.ReadUint8()
-	m.Type = ChatType(bf.ReadUint8())
-	m.Flags = bf.ReadUint16()
-	senderNameSize := bf.ReadUint16()
-	messageSize := bf.ReadUint16()
+	var err error
+	if m.Unk0, err = bfutil.TryReadUint8(bf); err != nil {
+		return err
+	}
+	chatType, err := bfutil.TryReadUint8(bf)
+	if err != nil {
+		return err
+	}
+	m.Type = ChatType(chatType)
+	if m.Flags, err = bfutil.TryReadUint16(bf); err != nil {
+		return err
+	}
+	senderNameSize, err := bfutil.TryReadUint16(bf)
+	if err != nil {
+		return err
+	}
+	messageSize, err := bfutil.TryReadUint16(bf)
+	if err != nil {
+		return err
+	}
+	// Both strings count their null terminator.
+	if senderNameSize == 0 || messageSize == 0 {
+		return errEmptyChatString
+	}
 
-	// TODO(Andoryuuta): Need proper shift-jis and null termination.
-	m.Message = string(bf.ReadBytes(uint(messageSize))[:messageSize-1])
-	m.SenderName = string(bf.ReadBytes(uint(senderNameSize))[:senderNameSize-1])
+	if m.Message, err = bfutil.ReadShiftJISString(bf, uint(messageSize), chatMessageMaxLength); err != nil {
+		return err
+	}
+	if m.SenderName, err = bfutil.ReadShiftJISString(bf, uint(senderNameSize), chatNameMaxLength); err != nil {
+		return err
+	}
 
 	return nil
 }
 
-// Build builds a binary packet from the current data.
+var errEmptyChatString = errors.New("chat string without a null terminator")
+
+// Build builds a binary packet from the current data, encoding the strings in Shift-JIS.
 func (m *MsgBinChat) Build(bf *byteframe.ByteFrame) error {
+	message := stringsupport.EncodeShiftJIS(m.Message, chatMessageMaxLength)
+	senderName := stringsupport.EncodeShiftJIS(m.SenderName, chatNameMaxLength)
 	bf.WriteUint8(m.Unk0)
 	bf.WriteUint8(uint8(m.Type))
 	bf.WriteUint16(m.Flags)
-	bf.WriteUint16(uint16(len(m.SenderName) + 1))
-	bf.WriteUint16(uint16(len(m.Message) + 1))
-	bf.WriteNullTerminatedBytes([]byte(m.Message))
-	bf.WriteNullTerminatedBytes([]byte(m.SenderName))
+	bf.WriteUint16(uint16(len(senderName) + 1))
+	bf.WriteUint16(uint16(len(message) + 1))
+	bf.WriteNullTerminatedBytes(message)
+	bf.WriteNullTerminatedBytes(senderName)
 
 	return nil
 }
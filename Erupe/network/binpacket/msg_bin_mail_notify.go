@@ -6,6 +6,9 @@ import (
 	"github.com/Andoryuuta/byteframe"
 )
 
+// Most bytes of the sender's name, the rest of its 21 are padding.
+const mailNotifyNameMaxLength = 20
+
 type MsgBinMailNotify struct {
 	SenderName string
 }
@@ -16,7 +19,7 @@ func (m MsgBinMailNotify) Parse(bf *byteframe.ByteFrame) error {
 
 func (m MsgBinMailNotify) Build(bf *byteframe.ByteFrame) error {
 	bf.WriteUint8(0x01) // Unk
-	byteName, _ := stringsupport.ConvertUTF8ToShiftJIS(m.SenderName)
+	byteName := stringsupport.EncodeShiftJIS(m.SenderName, mailNotifyNameMaxLength)
 
 	bf.WriteBytes(byteName)
 	bf.WriteBytes(make([]byte, 21-len(byteName)))
@@ -1,6 +1,7 @@
 package binpacket
 
 import (
+	"github.com/Solenataris/Erupe/common/bfutil"
 	"github.com/Solenataris/Erupe/network"
 	"github.com/Andoryuuta/byteframe"
 )
@@ -21,7 +22,14 @@ func (m *MsgBinTargeted) Opcode() network.PacketID {
 
 // Parse parses the packet from binary
 func (m *MsgBinTargeted) Parse(bf *byteframe.ByteFrame) error {
-	m.TargetCount = bf.ReadUint16()
+	var err error
+	if m.TargetCount, err = bfutil.TryReadUint16(bf); err != nil {
+		return err
+	}
+	// Checked up front, the count is the client's.
+	if bfutil.Remaining(bf) < int(m.TargetCount)*4 {
+		return bfutil.ErrShortRead
+	}
 
 	m.TargetCharIDs = make([]uint32, m.TargetCount)
 	for i := uint16(0); i < m.TargetCount; i++ {
@@ -10,6 +10,9 @@ import (
 	"github.com/Solenataris/Erupe/network/crypto"
 )
 
+// ErrChecksumMismatch is reading a packet that doesn't decrypt with any key, the connection is out of sync.
+var ErrChecksumMismatch = errors.New("decrypted data checksum doesn't match header")
+
 // CryptConn represents a MHF encrypted two-way connection,
 // it automatically handles encryption, decryption, and key rotation via it's methods.
 type CryptConn struct {
@@ -82,7 +85,7 @@ func (cc *CryptConn) ReadPacket() ([]byte, error) {
 			}
 		}
 
-		return nil, errors.New("decrypted data checksum doesn't match header")
+		return nil, ErrChecksumMismatch
 	}
 
 	cc.prevRecvPacketCombinedCheck = combinedCheck
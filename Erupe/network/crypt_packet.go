@@ -9,6 +9,11 @@ const (
 	// CryptPacketHeaderLength represents the byte-length of
 	// an encrypted packet header.
 	CryptPacketHeaderLength = 14
+
+	// CryptPacketMaxDataSize is the largest body a header can describe:
+	// the 16-bit DataSize plus the 4 bits above it that SendPacket puts
+	// in the high nibble of Pf0.
+	CryptPacketMaxDataSize = 0xFFFFF
 )
 
 // CryptPacketHeader represents the parsed information of an encrypted packet header.
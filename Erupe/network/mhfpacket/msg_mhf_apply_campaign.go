@@ -1,17 +1,24 @@
 package mhfpacket
 
 import (
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/common/bfutil"
+	"github.com/Solenataris/Erupe/common/stringsupport"
 	"github.com/Solenataris/Erupe/network"
 	"github.com/Solenataris/Erupe/network/clientctx"
-	"github.com/Andoryuuta/byteframe"
 )
 
+// campaignCodeSize is the field the code typed in the campaign menu is sent in, null padded, after the
+// fields known before the codes were.
+const campaignCodeSize = 16
+
 // MsgMhfApplyCampaign represents the MSG_MHF_APPLY_CAMPAIGN
 type MsgMhfApplyCampaign struct {
 	AckHandle uint32
 	Unk0      uint8
 	Unk1      uint8
 	Unk2      uint16
+	Code      string
 }
 
 // Opcode returns the ID associated with this packet type.
@@ -21,11 +28,16 @@ func (m *MsgMhfApplyCampaign) Opcode() network.PacketID {
 
 // Parse parses the packet from binary
 func (m *MsgMhfApplyCampaign) Parse(bf *byteframe.ByteFrame, ctx *clientctx.ClientContext) error {
+	if bfutil.Remaining(bf) < 8 {
+		return bfutil.ErrShortRead
+	}
 	m.AckHandle = bf.ReadUint32()
 	m.Unk0 = bf.ReadUint8()
 	m.Unk1 = bf.ReadUint8()
 	m.Unk2 = bf.ReadUint16()
-	return nil
+	var err error
+	m.Code, err = bfutil.ReadShiftJISString(bf, campaignCodeSize, campaignCodeSize)
+	return err
 }
 
 // Build builds a binary packet from the current data.
@@ -34,5 +46,8 @@ func (m *MsgMhfApplyCampaign) Build(bf *byteframe.ByteFrame, ctx *clientctx.Clie
 	bf.WriteUint8(m.Unk0)
 	bf.WriteUint8(m.Unk1)
 	bf.WriteUint16(m.Unk2)
+	code := make([]byte, campaignCodeSize)
+	copy(code, stringsupport.EncodeShiftJIS(m.Code, campaignCodeSize-1))
+	bf.WriteBytes(code)
 	return nil
 }
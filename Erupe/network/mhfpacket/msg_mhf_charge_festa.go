@@ -9,7 +9,12 @@ import (
 )
 
 // MsgMhfChargeFesta represents the MSG_MHF_CHARGE_FESTA
-type MsgMhfChargeFesta struct{}
+type MsgMhfChargeFesta struct {
+	AckHandle uint32
+	FestaID   uint32
+	GuildID   uint32
+	Souls     uint16
+}
 
 // Opcode returns the ID associated with this packet type.
 func (m *MsgMhfChargeFesta) Opcode() network.PacketID {
@@ -18,7 +23,11 @@ func (m *MsgMhfChargeFesta) Opcode() network.PacketID {
 
 // Parse parses the packet from binary
 func (m *MsgMhfChargeFesta) Parse(bf *byteframe.ByteFrame, ctx *clientctx.ClientContext) error {
-	return errors.New("NOT IMPLEMENTED")
+	m.AckHandle = bf.ReadUint32()
+	m.FestaID = bf.ReadUint32()
+	m.GuildID = bf.ReadUint32()
+	m.Souls = bf.ReadUint16()
+	return nil
 }
 
 // Build builds a binary packet from the current data.
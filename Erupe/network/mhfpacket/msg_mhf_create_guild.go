@@ -9,6 +9,9 @@ import (
 	"github.com/Solenataris/Erupe/network/clientctx"
 )
 
+// Most bytes of a guild or alliance name in Shift-JIS, the 24 characters their tables hold at two bytes each.
+const guildNameMaxLength = 48
+
 // MsgMhfCreateGuild represents the MSG_MHF_CREATE_GUILD
 type MsgMhfCreateGuild struct {
 	AckHandle uint32
@@ -28,10 +31,9 @@ func (m *MsgMhfCreateGuild) Parse(bf *byteframe.ByteFrame, ctx *clientctx.Client
 	m.Unk0 = bf.ReadUint8()
 	m.Unk1 = bf.ReadUint8()
 	nameLength := bf.ReadUint16()
-	nameBytes := bfutil.UpToNull(bf.ReadBytes(uint(nameLength)))
-	m.Name = ctx.StrConv.MustDecode(nameBytes)
-
-	return nil
+	var err error
+	m.Name, err = bfutil.ReadShiftJISString(bf, uint(nameLength), guildNameMaxLength)
+	return err
 }
 
 // Build builds a binary packet from the current data.
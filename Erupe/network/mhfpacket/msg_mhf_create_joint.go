@@ -26,9 +26,9 @@ func (m *MsgMhfCreateJoint) Parse(bf *byteframe.ByteFrame, ctx *clientctx.Client
   m.AckHandle = bf.ReadUint32()
   m.GuildID = bf.ReadUint32()
   nameLength := bf.ReadUint32()
-  nameBytes := bfutil.UpToNull(bf.ReadBytes(uint(nameLength)))
-  m.Name = ctx.StrConv.MustDecode(nameBytes)
-  return nil
+  var err error
+  m.Name, err = bfutil.ReadShiftJISString(bf, uint(nameLength), guildNameMaxLength)
+  return err
 }
 
 // Build builds a binary packet from the current data.
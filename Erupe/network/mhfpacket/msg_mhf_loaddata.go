@@ -1,9 +1,7 @@
 package mhfpacket
 
-import ( 
- "errors" 
-
- 	"github.com/Solenataris/Erupe/network/clientctx"
+import (
+	"github.com/Solenataris/Erupe/network/clientctx"
 	"github.com/Solenataris/Erupe/network"
 	"github.com/Andoryuuta/byteframe"
 )
@@ -26,5 +24,6 @@ func (m *MsgMhfLoaddata) Parse(bf *byteframe.ByteFrame, ctx *clientctx.ClientCon
 
 // Build builds a binary packet from the current data.
 func (m *MsgMhfLoaddata) Build(bf *byteframe.ByteFrame, ctx *clientctx.ClientContext) error {
-	return errors.New("NOT IMPLEMENTED")
+	bf.WriteUint32(m.AckHandle)
+	return nil
 }
@@ -9,7 +9,14 @@ import (
 )
 
 // MsgMhfOprMember represents the MSG_MHF_OPR_MEMBER
-type MsgMhfOprMember struct{}
+// Sent when adding or removing characters from the friend list or the blacklist.
+type MsgMhfOprMember struct {
+	AckHandle uint32
+	Blacklist bool // Set for the blacklist, unset for the friend list.
+	Operation bool // Set when removing.
+	Unk0      uint8
+	CharIDs   []uint32
+}
 
 // Opcode returns the ID associated with this packet type.
 func (m *MsgMhfOprMember) Opcode() network.PacketID {
@@ -18,7 +25,16 @@ func (m *MsgMhfOprMember) Opcode() network.PacketID {
 
 // Parse parses the packet from binary
 func (m *MsgMhfOprMember) Parse(bf *byteframe.ByteFrame, ctx *clientctx.ClientContext) error {
-	return errors.New("NOT IMPLEMENTED")
+	m.AckHandle = bf.ReadUint32()
+	m.Blacklist = bf.ReadBool()
+	m.Operation = bf.ReadBool()
+	m.Unk0 = bf.ReadUint8()
+	count := bf.ReadUint8()
+	m.CharIDs = make([]uint32, count)
+	for i := range m.CharIDs {
+		m.CharIDs[i] = bf.ReadUint32()
+	}
+	return nil
 }
 
 // Build builds a binary packet from the current data.
@@ -1,9 +1,8 @@
 package mhfpacket
 
-import ( 
- "errors" 
-
- 	"github.com/Solenataris/Erupe/network/clientctx"
+import (
+	"github.com/Solenataris/Erupe/common/bfutil"
+	"github.com/Solenataris/Erupe/network/clientctx"
 	"github.com/Solenataris/Erupe/network"
 	"github.com/Andoryuuta/byteframe"
 )
@@ -25,20 +24,30 @@ func (m *MsgMhfSavedata) Opcode() network.PacketID {
 
 // Parse parses the packet from binary
 func (m *MsgMhfSavedata) Parse(bf *byteframe.ByteFrame, ctx *clientctx.ClientContext) error {
+	if bfutil.Remaining(bf) < 17 {
+		return bfutil.ErrShortRead
+	}
 	m.AckHandle = bf.ReadUint32()
 	m.AllocMemSize = bf.ReadUint32()
 	m.SaveType = bf.ReadUint8()
 	m.Unk1 = bf.ReadUint32()
 	m.DataSize = bf.ReadUint32()
+	var err error
 	if m.DataSize == 0 { // seems to be used when DataSize = 0 rather than on savetype?
-		m.RawDataPayload = bf.ReadBytes(uint(m.AllocMemSize))
+		m.RawDataPayload, err = bfutil.TryReadBytes(bf, uint(m.AllocMemSize))
 	} else {
-		m.RawDataPayload = bf.ReadBytes(uint(m.DataSize))
+		m.RawDataPayload, err = bfutil.TryReadBytes(bf, uint(m.DataSize))
 	}
-	return nil
+	return err
 }
 
 // Build builds a binary packet from the current data.
 func (m *MsgMhfSavedata) Build(bf *byteframe.ByteFrame, ctx *clientctx.ClientContext) error {
-	return errors.New("NOT IMPLEMENTED")
+	bf.WriteUint32(m.AckHandle)
+	bf.WriteUint32(m.AllocMemSize)
+	bf.WriteUint8(m.SaveType)
+	bf.WriteUint32(m.Unk1)
+	bf.WriteUint32(m.DataSize)
+	bf.WriteBytes(m.RawDataPayload)
+	return nil
 }
@@ -3,6 +3,7 @@ package mhfpacket
 import (
  "errors"
 
+ 	"github.com/Solenataris/Erupe/common/bfutil"
  	"github.com/Solenataris/Erupe/network/clientctx"
 	"github.com/Solenataris/Erupe/network"
 	"github.com/Andoryuuta/byteframe"
@@ -16,10 +17,17 @@ type MsgMhfSendMail struct {
   BodyLength    uint16
   Quantity      uint32
   ItemID        uint16
-  Subject       []byte
-  Body          []byte
+  Subject       string
+  Body          string
 }
 
+// Most bytes of a mail subject and body in Shift-JIS. The mail list sends the subject with a length byte
+// counting its terminator.
+const (
+  mailSubjectMaxLength = 254
+  mailBodyMaxLength    = 0xFFFF
+)
+
 // Opcode returns the ID associated with this packet type.
 func (m *MsgMhfSendMail) Opcode() network.PacketID {
 	return network.MSG_MHF_SEND_MAIL
@@ -33,9 +41,12 @@ func (m *MsgMhfSendMail) Parse(bf *byteframe.ByteFrame, ctx *clientctx.ClientCon
   m.BodyLength = bf.ReadUint16()
   m.Quantity = bf.ReadUint32()
   m.ItemID = bf.ReadUint16()
-  m.Subject = bf.ReadNullTerminatedBytes()
-  m.Body = bf.ReadNullTerminatedBytes()
-  return nil
+  var err error
+  if m.Subject, err = bfutil.ReadNullTerminatedShiftJISString(bf, mailSubjectMaxLength); err != nil {
+    return err
+  }
+  m.Body, err = bfutil.ReadNullTerminatedShiftJISString(bf, mailBodyMaxLength)
+  return err
 }
 
 // Build builds a binary packet from the current data.
@@ -3,7 +3,8 @@ package mhfpacket
 import (
  "errors"
 
- 	"github.com/Solenataris/Erupe/network/clientctx"
+	"github.com/Solenataris/Erupe/common/bfutil"
+	"github.com/Solenataris/Erupe/network/clientctx"
 	"github.com/Solenataris/Erupe/network"
 	"github.com/Andoryuuta/byteframe"
 )
@@ -22,6 +23,9 @@ func (m *MsgMhfUpdateGuildMessageBoard) Opcode() network.PacketID {
 
 // Parse parses the packet from binary
 func (m *MsgMhfUpdateGuildMessageBoard) Parse(bf *byteframe.ByteFrame, ctx *clientctx.ClientContext) error {
+  if bfutil.Remaining(bf) < 8 {
+    return bfutil.ErrShortRead
+  }
   m.AckHandle = bf.ReadUint32()
   m.MessageOp = bf.ReadUint32()
   if m.MessageOp != 5 {
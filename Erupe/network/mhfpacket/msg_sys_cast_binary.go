@@ -3,7 +3,8 @@ package mhfpacket
 import ( 
  "errors" 
 
- 	"github.com/Solenataris/Erupe/network/clientctx"
+	"github.com/Solenataris/Erupe/common/bfutil"
+	"github.com/Solenataris/Erupe/network/clientctx"
 	"github.com/Solenataris/Erupe/network"
 	"github.com/Andoryuuta/byteframe"
 )
@@ -24,13 +25,17 @@ func (m *MsgSysCastBinary) Opcode() network.PacketID {
 
 // Parse parses the packet from binary
 func (m *MsgSysCastBinary) Parse(bf *byteframe.ByteFrame, ctx *clientctx.ClientContext) error {
+	if bfutil.Remaining(bf) < 8 {
+		return bfutil.ErrShortRead
+	}
 	m.Unk0 = bf.ReadUint16()
 	m.Unk1 = bf.ReadUint16()
 	m.BroadcastType = bf.ReadUint8()
 	m.MessageType = bf.ReadUint8()
 	dataSize := bf.ReadUint16()
-	m.RawDataPayload = bf.ReadBytes(uint(dataSize))
-	return nil
+	var err error
+	m.RawDataPayload, err = bfutil.TryReadBytes(bf, uint(dataSize))
+	return err
 }
 
 // Build builds a binary packet from the current data.
@@ -0,0 +1,50 @@
+package mhfpacket
+
+import (
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/clientctx"
+)
+
+// Matchmaking queue actions of MsgSysEnqueueMatch.
+const (
+	MatchActionJoin    = 0
+	MatchActionLeave   = 1
+	MatchActionAccept  = 2
+	MatchActionDecline = 3
+)
+
+// MsgSysEnqueueMatch represents the MSG_SYS_ENQUEUE_MATCH, sent by modded clients and tools
+// to join or leave the road matchmaking queue and to answer a match found for them.
+type MsgSysEnqueueMatch struct {
+	AckHandle uint32
+	Action    uint8
+	MinFloor  uint16
+	MaxFloor  uint16
+	Language  uint8 // 0 matches any language.
+}
+
+// Opcode returns the ID associated with this packet type.
+func (m *MsgSysEnqueueMatch) Opcode() network.PacketID {
+	return network.MSG_SYS_ENQUEUE_MATCH
+}
+
+// Parse parses the packet from binary
+func (m *MsgSysEnqueueMatch) Parse(bf *byteframe.ByteFrame, ctx *clientctx.ClientContext) error {
+	m.AckHandle = bf.ReadUint32()
+	m.Action = bf.ReadUint8()
+	m.MinFloor = bf.ReadUint16()
+	m.MaxFloor = bf.ReadUint16()
+	m.Language = bf.ReadUint8()
+	return nil
+}
+
+// Build builds a binary packet from the current data.
+func (m *MsgSysEnqueueMatch) Build(bf *byteframe.ByteFrame, ctx *clientctx.ClientContext) error {
+	bf.WriteUint32(m.AckHandle)
+	bf.WriteUint8(m.Action)
+	bf.WriteUint16(m.MinFloor)
+	bf.WriteUint16(m.MaxFloor)
+	bf.WriteUint8(m.Language)
+	return nil
+}
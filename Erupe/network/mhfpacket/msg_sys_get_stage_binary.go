@@ -23,12 +23,19 @@ func (m *MsgSysGetStageBinary) Opcode() network.PacketID {
 
 // Parse parses the packet from binary
 func (m *MsgSysGetStageBinary) Parse(bf *byteframe.ByteFrame, ctx *clientctx.ClientContext) error {
+	if bfutil.Remaining(bf) < 11 {
+		return bfutil.ErrShortRead
+	}
 	m.AckHandle = bf.ReadUint32()
 	m.BinaryType0 = bf.ReadUint8()
 	m.BinaryType1 = bf.ReadUint8()
 	m.Unk0 = bf.ReadUint32()
 	stageIDLength := bf.ReadUint8()
-	m.StageID = string(bfutil.UpToNull(bf.ReadBytes(uint(stageIDLength))))
+	stageID, err := bfutil.TryReadBytes(bf, uint(stageIDLength))
+	if err != nil {
+		return err
+	}
+	m.StageID = string(bfutil.UpToNull(stageID))
 	return nil
 }
 
@@ -3,7 +3,8 @@ package mhfpacket
 import ( 
  "errors" 
 
- 	"github.com/Solenataris/Erupe/network/clientctx"
+ 	"github.com/Solenataris/Erupe/common/bfutil"
+	"github.com/Solenataris/Erupe/network/clientctx"
 	"github.com/Solenataris/Erupe/network"
 	"github.com/Andoryuuta/byteframe"
 )
@@ -22,10 +23,15 @@ func (m *MsgSysIssueLogkey) Opcode() network.PacketID {
 
 // Parse parses the packet from binary
 func (m *MsgSysIssueLogkey) Parse(bf *byteframe.ByteFrame, ctx *clientctx.ClientContext) error {
-	m.AckHandle = bf.ReadUint32()
-	m.Unk0 = bf.ReadUint16()
-	m.Unk1 = bf.ReadUint16()
-	return nil
+	var err error
+	if m.AckHandle, err = bfutil.TryReadUint32(bf); err != nil {
+		return err
+	}
+	if m.Unk0, err = bfutil.TryReadUint16(bf); err != nil {
+		return err
+	}
+	m.Unk1, err = bfutil.TryReadUint16(bf)
+	return err
 }
 
 // Build builds a binary packet from the current data.
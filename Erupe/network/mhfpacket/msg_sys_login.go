@@ -1,9 +1,8 @@
 package mhfpacket
 
-import ( 
- "errors" 
-
- 	"github.com/Solenataris/Erupe/network/clientctx"
+import (
+	"github.com/Solenataris/Erupe/common/bfutil"
+	"github.com/Solenataris/Erupe/network/clientctx"
 	"github.com/Solenataris/Erupe/network"
 	"github.com/Andoryuuta/byteframe"
 )
@@ -28,20 +27,52 @@ func (m *MsgSysLogin) Opcode() network.PacketID {
 
 // Parse parses the packet from binary
 func (m *MsgSysLogin) Parse(bf *byteframe.ByteFrame, ctx *clientctx.ClientContext) error {
-	m.AckHandle = bf.ReadUint32()
-	m.CharID0 = bf.ReadUint32()
-	m.LoginTokenNumber = bf.ReadUint32()
-	m.HardcodedZero0 = bf.ReadUint16()
-	m.RequestVersion = bf.ReadUint16()
-	m.CharID1 = bf.ReadUint32()
-	m.HardcodedZero1 = bf.ReadUint16()
-	m.LoginTokenStringLength = bf.ReadUint16()
-	m.LoginTokenString = string(bf.ReadBytes(17)) // TODO(Andoryuuta): What encoding is this string?
+	var err error
+	if m.AckHandle, err = bfutil.TryReadUint32(bf); err != nil {
+		return err
+	}
+	if m.CharID0, err = bfutil.TryReadUint32(bf); err != nil {
+		return err
+	}
+	if m.LoginTokenNumber, err = bfutil.TryReadUint32(bf); err != nil {
+		return err
+	}
+	if m.HardcodedZero0, err = bfutil.TryReadUint16(bf); err != nil {
+		return err
+	}
+	if m.RequestVersion, err = bfutil.TryReadUint16(bf); err != nil {
+		return err
+	}
+	if m.CharID1, err = bfutil.TryReadUint32(bf); err != nil {
+		return err
+	}
+	if m.HardcodedZero1, err = bfutil.TryReadUint16(bf); err != nil {
+		return err
+	}
+	if m.LoginTokenStringLength, err = bfutil.TryReadUint16(bf); err != nil {
+		return err
+	}
+	token, err := bfutil.TryReadBytes(bf, 17)
+	if err != nil {
+		return err
+	}
+	m.LoginTokenString = string(token) // TODO(Andoryuuta): What encoding is this string?
 
 	return nil
 }
 
 // Build builds a binary packet from the current data.
 func (m *MsgSysLogin) Build(bf *byteframe.ByteFrame, ctx *clientctx.ClientContext) error {
-	return errors.New("NOT IMPLEMENTED")
+	bf.WriteUint32(m.AckHandle)
+	bf.WriteUint32(m.CharID0)
+	bf.WriteUint32(m.LoginTokenNumber)
+	bf.WriteUint16(m.HardcodedZero0)
+	bf.WriteUint16(m.RequestVersion)
+	bf.WriteUint32(m.CharID1)
+	bf.WriteUint16(m.HardcodedZero1)
+	bf.WriteUint16(m.LoginTokenStringLength)
+	token := make([]byte, 17)
+	copy(token, m.LoginTokenString)
+	bf.WriteBytes(token)
+	return nil
 }
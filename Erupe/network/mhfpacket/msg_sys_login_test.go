@@ -0,0 +1,41 @@
+package mhfpacket
+
+import (
+	"testing"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/common/bfutil"
+	"github.com/Solenataris/Erupe/network/clientctx"
+)
+
+// TestPreLoginTruncatedFrames parses the packets a client can send before it has logged in,
+// first whole and then cut short at every byte, which must fail rather than panic.
+func TestPreLoginTruncatedFrames(t *testing.T) {
+	tests := []struct {
+		name string
+		pkt  MHFPacket
+		size int
+	}{
+		{"login", &MsgSysLogin{}, 41},
+		{"ping", &MsgSysPing{}, 4},
+		{"time", &MsgSysTime{}, 5},
+		{"logout", &MsgSysLogout{}, 1},
+		{"issue logkey", &MsgSysIssueLogkey{}, 8},
+		{"terminal log", &MsgSysTerminalLog{}, 48},
+	}
+	for _, tt := range tests {
+		data := make([]byte, tt.size)
+		if _, ok := tt.pkt.(*MsgSysTerminalLog); ok {
+			data[9] = 1 // One entry.
+		}
+		if err := tt.pkt.Parse(byteframe.NewByteFrameFromBytes(data), &clientctx.ClientContext{}); err != nil {
+			t.Errorf("%s: parsing %d bytes got %v", tt.name, len(data), err)
+		}
+		for n := 0; n < len(data); n++ {
+			bf := byteframe.NewByteFrameFromBytes(data[:n])
+			if err := tt.pkt.Parse(bf, &clientctx.ClientContext{}); err != bfutil.ErrShortRead {
+				t.Errorf("%s: parsing %d of %d bytes got %v, want %v", tt.name, n, len(data), err, bfutil.ErrShortRead)
+			}
+		}
+	}
+}
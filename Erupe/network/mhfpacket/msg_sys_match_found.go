@@ -0,0 +1,62 @@
+package mhfpacket
+
+import (
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/common/bfutil"
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/clientctx"
+)
+
+// Statuses of MsgSysMatchFound.
+const (
+	MatchStatusFound     = 0 // Waiting on every member to accept.
+	MatchStatusReady     = 1 // Everyone accepted, the stage is reserved for them.
+	MatchStatusCancelled = 2 // A member declined or timed out, the rest are queued again.
+)
+
+// MsgSysMatchFound represents the MSG_SYS_MATCH_FOUND, telling a client that enqueued
+// through MsgSysEnqueueMatch about its match.
+type MsgSysMatchFound struct {
+	Status        uint8
+	StageID       string
+	MinFloor      uint16
+	MaxFloor      uint16
+	AcceptSeconds uint16
+	CharIDs       []uint32
+}
+
+// Opcode returns the ID associated with this packet type.
+func (m *MsgSysMatchFound) Opcode() network.PacketID {
+	return network.MSG_SYS_MATCH_FOUND
+}
+
+// Parse parses the packet from binary
+func (m *MsgSysMatchFound) Parse(bf *byteframe.ByteFrame, ctx *clientctx.ClientContext) error {
+	m.Status = bf.ReadUint8()
+	stageIDLength := bf.ReadUint8()
+	m.StageID = string(bfutil.UpToNull(bf.ReadBytes(uint(stageIDLength))))
+	m.MinFloor = bf.ReadUint16()
+	m.MaxFloor = bf.ReadUint16()
+	m.AcceptSeconds = bf.ReadUint16()
+	count := bf.ReadUint8()
+	m.CharIDs = make([]uint32, count)
+	for i := range m.CharIDs {
+		m.CharIDs[i] = bf.ReadUint32()
+	}
+	return nil
+}
+
+// Build builds a binary packet from the current data.
+func (m *MsgSysMatchFound) Build(bf *byteframe.ByteFrame, ctx *clientctx.ClientContext) error {
+	bf.WriteUint8(m.Status)
+	bf.WriteUint8(uint8(len(m.StageID) + 1))
+	bf.WriteNullTerminatedBytes([]byte(m.StageID))
+	bf.WriteUint16(m.MinFloor)
+	bf.WriteUint16(m.MaxFloor)
+	bf.WriteUint16(m.AcceptSeconds)
+	bf.WriteUint8(uint8(len(m.CharIDs)))
+	for _, charID := range m.CharIDs {
+		bf.WriteUint32(charID)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package mhfpacket
 
 import (
+	"github.com/Solenataris/Erupe/common/bfutil"
 	"github.com/Solenataris/Erupe/network"
 	"github.com/Solenataris/Erupe/network/clientctx"
 	"github.com/Andoryuuta/byteframe"
@@ -18,8 +19,9 @@ func (m *MsgSysPing) Opcode() network.PacketID {
 
 // Parse parses the packet from binary
 func (m *MsgSysPing) Parse(bf *byteframe.ByteFrame, ctx *clientctx.ClientContext) error {
-	m.AckHandle = bf.ReadUint32()
-	return nil
+	var err error
+	m.AckHandle, err = bfutil.TryReadUint32(bf)
+	return err
 }
 
 // Build builds a binary packet from the current data.
@@ -22,13 +22,20 @@ func (m *MsgSysSetStageBinary) Opcode() network.PacketID {
 
 // Parse parses the packet from binary
 func (m *MsgSysSetStageBinary) Parse(bf *byteframe.ByteFrame, ctx *clientctx.ClientContext) error {
+	if bfutil.Remaining(bf) < 5 {
+		return bfutil.ErrShortRead
+	}
 	m.BinaryType0 = bf.ReadUint8()
 	m.BinaryType1 = bf.ReadUint8()
 	stageIDLength := bf.ReadUint8() // <= 0x20
 	dataSize := bf.ReadUint16()     // <= 0x400
-	m.StageID = string(bfutil.UpToNull(bf.ReadBytes(uint(stageIDLength))))
-	m.RawDataPayload = bf.ReadBytes(uint(dataSize))
-	return nil
+	stageID, err := bfutil.TryReadBytes(bf, uint(stageIDLength))
+	if err != nil {
+		return err
+	}
+	m.StageID = string(bfutil.UpToNull(stageID))
+	m.RawDataPayload, err = bfutil.TryReadBytes(bf, uint(dataSize))
+	return err
 }
 
 // Build builds a binary packet from the current data.
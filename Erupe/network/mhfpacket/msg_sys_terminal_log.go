@@ -3,7 +3,8 @@ package mhfpacket
 import ( 
  "errors" 
 
- 	"github.com/Solenataris/Erupe/network/clientctx"
+ 	"github.com/Solenataris/Erupe/common/bfutil"
+	"github.com/Solenataris/Erupe/network/clientctx"
 	"github.com/Solenataris/Erupe/network"
 	"github.com/Andoryuuta/byteframe"
 )
@@ -30,22 +31,27 @@ func (m *MsgSysTerminalLog) Opcode() network.PacketID {
 
 // Parse parses the packet from binary
 func (m *MsgSysTerminalLog) Parse(bf *byteframe.ByteFrame, ctx *clientctx.ClientContext) error {
-	m.AckHandle = bf.ReadUint32()
-	m.LogID = bf.ReadUint32()
-	m.EntryCount = bf.ReadUint16()
-	m.Unk0 = bf.ReadUint16()
+	var err error
+	if m.AckHandle, err = bfutil.TryReadUint32(bf); err != nil {
+		return err
+	}
+	if m.LogID, err = bfutil.TryReadUint32(bf); err != nil {
+		return err
+	}
+	if m.EntryCount, err = bfutil.TryReadUint16(bf); err != nil {
+		return err
+	}
+	if m.Unk0, err = bfutil.TryReadUint16(bf); err != nil {
+		return err
+	}
 
 	for i := 0; i < int(m.EntryCount); i++ {
 		e := &TerminalLogEntry{}
-		e.U0 = bf.ReadUint32()
-		e.U1 = bf.ReadUint32()
-		e.U2 = bf.ReadUint32()
-		e.U3 = bf.ReadUint32()
-		e.U4 = bf.ReadUint32()
-		e.U5 = bf.ReadUint32()
-		e.U6 = bf.ReadUint32()
-		e.U7 = bf.ReadUint32()
-		e.U8 = bf.ReadUint32()
+		for _, field := range []*uint32{&e.U0, &e.U1, &e.U2, &e.U3, &e.U4, &e.U5, &e.U6, &e.U7, &e.U8} {
+			if *field, err = bfutil.TryReadUint32(bf); err != nil {
+				return err
+			}
+		}
 		m.Entries = append(m.Entries, e)
 	}
 
@@ -1,6 +1,7 @@
 package mhfpacket
 
 import (
+	"github.com/Solenataris/Erupe/common/bfutil"
 	"github.com/Solenataris/Erupe/network"
 	"github.com/Solenataris/Erupe/network/clientctx"
 	"github.com/Andoryuuta/byteframe"
@@ -19,9 +20,12 @@ func (m *MsgSysTime) Opcode() network.PacketID {
 
 // Parse parses the packet from binary
 func (m *MsgSysTime) Parse(bf *byteframe.ByteFrame, ctx *clientctx.ClientContext) error {
-	m.GetRemoteTime = bf.ReadBool()
-	m.Timestamp = bf.ReadUint32()
-	return nil
+	var err error
+	if m.GetRemoteTime, err = bfutil.TryReadBool(bf); err != nil {
+		return err
+	}
+	m.Timestamp, err = bfutil.TryReadUint32(bf)
+	return err
 }
 
 // Build builds a binary packet from the current data.
@@ -869,6 +869,10 @@ func FromOpcode(opcode network.PacketID) MHFPacket {
 		return &MsgSysReserve20E{}
 	case network.MSG_SYS_reserve20F:
 		return &MsgSysReserve20F{}
+	case network.MSG_SYS_ENQUEUE_MATCH:
+		return &MsgSysEnqueueMatch{}
+	case network.MSG_SYS_MATCH_FOUND:
+		return &MsgSysMatchFound{}
 	}
 	return nil
 }
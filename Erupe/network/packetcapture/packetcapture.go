@@ -0,0 +1,190 @@
+// Package packetcapture writes the decrypted packets of a connection to a capture file and reads them back.
+//
+// A capture starts with the magic "ERPC" and a uint16 format version, followed by one record per packet:
+//
+//	int64  time, in nanoseconds since the Unix epoch
+//	uint8  direction, 0 from the client and 1 to it
+//	uint16 opcode the payload starts with, 0 if it's shorter than that
+//	uint32 payload length
+//	       payload
+//
+// All integers are big endian, as in the protocol.
+package packetcapture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Solenataris/Erupe/network"
+)
+
+const (
+	magic         = "ERPC"
+	version       = 1
+	headerLength  = len(magic) + 2
+	recordLength  = 8 + 1 + 2 + 4
+	maxPayloadLen = 1 << 24 // Sanity check on reading, far past the largest packet.
+)
+
+// Extension is the file extension of captures.
+const Extension = ".cap"
+
+// ErrFull is returned for packets written after the capture reached its size limit, they're dropped.
+var ErrFull = errors.New("packet capture is full")
+
+// Direction is which way a packet went.
+type Direction uint8
+
+// Packet directions.
+const (
+	Inbound  Direction = 0 // From the client.
+	Outbound Direction = 1 // To the client.
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Inbound:
+		return "recv"
+	case Outbound:
+		return "send"
+	}
+	return fmt.Sprintf("Direction(%d)", uint8(d))
+}
+
+// Packet is a captured packet.
+type Packet struct {
+	Time      time.Time
+	Direction Direction
+	Opcode    network.PacketID
+	Data      []byte
+}
+
+// Writer appends packets to a capture file. It's safe for concurrent use, such as from the send and
+// receive loops of a session.
+type Writer struct {
+	sync.Mutex
+	file    *os.File
+	size    int64
+	maxSize int64
+}
+
+// Create creates the capture file. Packets that would take it past maxSize bytes are dropped, 0 doesn't limit it.
+func Create(path string, maxSize int64) (*Writer, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	header := append([]byte(magic), 0, version)
+	if _, err := file.Write(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &Writer{file: file, size: int64(len(header)), maxSize: maxSize}, nil
+}
+
+// Write appends a packet, starting at its opcode, sent or received at the given time.
+func (w *Writer) Write(direction Direction, at time.Time, data []byte) error {
+	record := make([]byte, recordLength, recordLength+len(data))
+	binary.BigEndian.PutUint64(record, uint64(at.UnixNano()))
+	record[8] = byte(direction)
+	if len(data) >= 2 {
+		copy(record[9:11], data[:2])
+	}
+	binary.BigEndian.PutUint32(record[11:], uint32(len(data)))
+	record = append(record, data...)
+
+	w.Lock()
+	defer w.Unlock()
+	if w.file == nil {
+		return os.ErrClosed
+	}
+	if w.maxSize > 0 && w.size+int64(len(record)) > w.maxSize {
+		return ErrFull
+	}
+	n, err := w.file.Write(record)
+	w.size += int64(n)
+	return err
+}
+
+// Close closes the file, later writes fail.
+func (w *Writer) Close() error {
+	w.Lock()
+	defer w.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// Reader reads the packets of a capture in the order they were written.
+type Reader struct {
+	r io.Reader
+}
+
+// NewReader checks the capture's header and returns a reader of its packets.
+func NewReader(r io.Reader) (*Reader, error) {
+	header := make([]byte, headerLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("reading capture header: %w", err)
+	}
+	if !bytes.Equal(header[:len(magic)], []byte(magic)) {
+		return nil, errors.New("not a packet capture")
+	}
+	if v := binary.BigEndian.Uint16(header[len(magic):]); v != version {
+		return nil, fmt.Errorf("unsupported packet capture version %d", v)
+	}
+	return &Reader{r}, nil
+}
+
+// Next returns the next packet, or io.EOF after the last one. A capture cut short inside a packet,
+// such as by a crash, returns io.ErrUnexpectedEOF.
+func (r *Reader) Next() (Packet, error) {
+	record := make([]byte, recordLength)
+	if _, err := io.ReadFull(r.r, record); err != nil {
+		return Packet{}, err
+	}
+	length := binary.BigEndian.Uint32(record[11:])
+	if length > maxPayloadLen {
+		return Packet{}, fmt.Errorf("packet of %d bytes in capture", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Packet{}, err
+	}
+	return Packet{
+		Time:      time.Unix(0, int64(binary.BigEndian.Uint64(record))),
+		Direction: Direction(record[8]),
+		Opcode:    network.PacketID(binary.BigEndian.Uint16(record[9:11])),
+		Data:      data,
+	}, nil
+}
+
+// RemoveOlder deletes the captures in the directory last written to before the cutoff.
+func RemoveOlder(dir string, cutoff time.Time) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+Extension))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package packetcapture
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/network"
+)
+
+// packetData is a packet of the opcode followed by the rest.
+func packetData(opcode network.PacketID, rest ...byte) []byte {
+	return append([]byte{byte(opcode >> 8), byte(opcode)}, rest...)
+}
+
+func TestRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session"+Extension)
+	w, err := Create(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Unix(1650000000, 123456789)
+	want := []Packet{
+		{at, Inbound, network.MSG_SYS_PING, packetData(network.MSG_SYS_PING, 0x01, 0x02)},
+		{at.Add(time.Millisecond), Outbound, network.MSG_SYS_ACK, packetData(network.MSG_SYS_ACK, 0xAA, 0x00, 0x10)},
+		{at.Add(2 * time.Millisecond), Inbound, 0, []byte{0x05}}, // Too short to have an opcode.
+	}
+	for _, packet := range want {
+		if err := w.Write(packet.Direction, packet.Time, packet.Data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	r, err := NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []Packet
+	for {
+		packet, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, packet)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("read %d packets, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !got[i].Time.Equal(want[i].Time) || got[i].Direction != want[i].Direction || got[i].Opcode != want[i].Opcode || !reflect.DeepEqual(got[i].Data, want[i].Data) {
+			t.Errorf("packet %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestSizeLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session"+Extension)
+	// The header and a single 10 byte packet fit.
+	w, err := Create(path, int64(headerLength+recordLength+10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := w.Write(Inbound, time.Now(), make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(Outbound, time.Now(), make([]byte, 1)); err != ErrFull {
+		t.Errorf("write past the limit got %v, want %v", err, ErrFull)
+	}
+	if info, _ := os.Stat(path); info.Size() != int64(headerLength+recordLength+10) {
+		t.Errorf("capture is %d bytes, want it to stop at the limit", info.Size())
+	}
+}
+
+func TestTruncatedCapture(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString(magic)
+	buf.Write([]byte{0, version})
+	buf.Write(make([]byte, recordLength-4))
+	buf.Write([]byte{0, 0, 0, 8, 1, 2}) // Says 8 bytes, has 2.
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	if _, err := NewReader(bytes.NewReader([]byte("not a capture"))); err == nil {
+		t.Error("read a file that isn't a capture")
+	}
+}
+
+func TestRemoveOlder(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "old"+Extension)
+	recent := filepath.Join(dir, "recent"+Extension)
+	other := filepath.Join(dir, "notes.txt")
+	for _, path := range []string{old, recent, other} {
+		os.WriteFile(path, nil, 0600)
+	}
+	past := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(old, past, past)
+	os.Chtimes(other, past, past)
+
+	if err := RemoveOlder(dir, time.Now().Add(-24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	for path, kept := range map[string]bool{old: false, recent: true, other: true} {
+		if _, err := os.Stat(path); (err == nil) != kept {
+			t.Errorf("%s kept: %v, want %v", filepath.Base(path), err == nil, kept)
+		}
+	}
+}
@@ -439,4 +439,11 @@ const (
 	MSG_SYS_reserve20F
 )
 
+// Opcodes of the road matchmaking queue, past the last opcode of the client so they can't collide
+// with any it sends. Only modded clients and tools speak them.
+const (
+	MSG_SYS_ENQUEUE_MATCH PacketID = MSG_SYS_reserve20F + 1 + iota
+	MSG_SYS_MATCH_FOUND
+)
+
 //revive:enable
@@ -0,0 +1,380 @@
+// Package testclient speaks the client's side of the sign, entrance and channel protocols, as much as it
+// takes to sign in, find a channel and send it requests, so a server can be checked end to end.
+package testclient
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/common/stringsupport"
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/clientctx"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"github.com/Solenataris/Erupe/server/channelserver/compression/nullcomp"
+	"github.com/Solenataris/Erupe/server/entranceserver"
+	"golang.org/x/text/encoding/japanese"
+)
+
+// Timeout bounds every exchange with a server.
+var Timeout = 10 * time.Second
+
+// Character is a character listed in a sign in response.
+type Character struct {
+	ID   uint32
+	Name string
+	New  bool // Never played, the client shows its name as "?????".
+}
+
+// SignIn is a successful sign in: the token the channel takes and where the entrance server is.
+type SignIn struct {
+	TokenID    uint32
+	Token      string
+	Entrance   string // Address of the entrance server, "host:port".
+	Characters []Character
+}
+
+// SignInError is a sign in the server refused, with the code it answered.
+type SignInError struct {
+	Code uint8
+}
+
+func (e *SignInError) Error() string {
+	return fmt.Sprintf("sign in refused with code %d", e.Code)
+}
+
+// dial connects to the server and sends the 8 NULL bytes the client starts the sign and entrance exchanges with.
+func dial(addr string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", addr, Timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(Timeout))
+	if _, err := conn.Write(make([]byte, 8)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Sign signs in to the sign server. A "+" ending the username asks for a new character as well.
+func Sign(addr string, username string, password string) (*SignIn, error) {
+	conn, err := dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	cc := network.NewCryptConn(conn)
+
+	bf := byteframe.NewByteFrame()
+	bf.WriteNullTerminatedBytes([]byte("DSGN:100"))
+	bf.WriteNullTerminatedBytes([]byte(username))
+	bf.WriteNullTerminatedBytes([]byte(password))
+	bf.WriteNullTerminatedBytes([]byte("testclient"))
+	if err := cc.SendPacket(bf.Data()); err != nil {
+		return nil, err
+	}
+	resp, err := cc.ReadPacket()
+	if err != nil {
+		return nil, err
+	}
+	return parseSignIn(resp)
+}
+
+// errShortResponse is a response that ended before everything it announced.
+var errShortResponse = errors.New("response is cut short")
+
+// parseSignIn reads a sign in response, as signserver's buildSignInResp writes it.
+func parseSignIn(resp []byte) (sign *SignIn, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			sign, err = nil, errShortResponse
+		}
+	}()
+	if len(resp) == 0 {
+		return nil, errShortResponse
+	}
+	if resp[0] != 1 {
+		return nil, &SignInError{Code: resp[0]}
+	}
+	bf := byteframe.NewByteFrameFromBytes(resp[1:])
+	bf.ReadUint8() // Patch server count.
+	servers := int(bf.ReadUint8())
+	characters := int(bf.ReadUint8())
+	sign = &SignIn{TokenID: bf.ReadUint32()}
+	sign.Token = strings.TrimRight(string(bf.ReadBytes(16)), "\x00")
+	bf.ReadUint32()
+	for i := 0; i < servers; i++ {
+		address := strings.TrimRight(string(bf.ReadBytes(uint(bf.ReadUint8()))), "\x00")
+		if i == 0 {
+			sign.Entrance = address
+		}
+	}
+	for i := 0; i < characters; i++ {
+		var char Character
+		char.ID = bf.ReadUint32()
+		bf.ReadUint16() // HR.
+		bf.ReadUint16() // Weapon.
+		bf.ReadUint32() // Last login.
+		bf.ReadUint8()  // Sex.
+		char.New = bf.ReadBool()
+		bf.ReadUint8() // Old GR.
+		bf.ReadUint8()
+		char.Name = stringsupport.DecodeShiftJIS(bf.ReadBytes(16))
+		bf.ReadBytes(32)
+		bf.ReadUint16() // GR.
+		bf.ReadUint16()
+		sign.Characters = append(sign.Characters, char)
+	}
+	return sign, nil
+}
+
+// World is a world listed by the entrance server.
+type World struct {
+	IP       string
+	Name     string
+	Channels []Channel
+}
+
+// Channel is a channel of a world, with the players on it when the list was made.
+type Channel struct {
+	Port       uint16
+	MaxPlayers uint16
+	Players    uint16
+}
+
+// Worlds asks the entrance server for its world list.
+func Worlds(addr string) ([]World, error) {
+	conn, err := dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	cc := network.NewCryptConn(conn)
+	if err := cc.SendPacket([]byte("ALL+\x00")); err != nil {
+		return nil, err
+	}
+	resp, err := cc.ReadPacket()
+	if err != nil {
+		return nil, err
+	}
+	return parseWorlds(resp)
+}
+
+// parseWorlds reads the SV2 section of an entrance response, checking its checksum.
+func parseWorlds(resp []byte) (worlds []World, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			worlds, err = nil, errShortResponse
+		}
+	}()
+	if len(resp) < 12 {
+		return nil, errShortResponse
+	}
+	section := entranceserver.DecryptBin8(resp[1:], resp[0])
+	if string(section[:3]) != "SV2" {
+		return nil, fmt.Errorf("entrance response starts with %q, not SV2", section[:3])
+	}
+	bf := byteframe.NewByteFrameFromBytes(section[3:])
+	count := int(bf.ReadUint16())
+	size := int(bf.ReadUint16())
+	if size == 0 {
+		return nil, nil
+	}
+	sum := bf.ReadUint32()
+	if len(section) < 11+size {
+		return nil, errShortResponse
+	}
+	data := section[11 : 11+size]
+	if entranceserver.CalcSum32(data) != sum {
+		return nil, errors.New("entrance world list checksum doesn't match")
+	}
+
+	bf = byteframe.NewByteFrameFromBytes(data)
+	for i := 0; i < count; i++ {
+		ip := make([]byte, 4)
+		binary.LittleEndian.PutUint32(ip, bf.ReadUint32())
+		world := World{IP: net.IP(ip).String()}
+		bf.ReadUint16() // Index.
+		bf.ReadUint16()
+		channels := int(bf.ReadUint16())
+		bf.ReadBytes(3) // Type, activity and an unknown byte.
+		world.Name = stringsupport.DecodeShiftJIS(bf.ReadBytes(66))
+		bf.ReadUint32() // Allowed client flags.
+		for j := 0; j < channels; j++ {
+			channel := Channel{Port: bf.ReadUint16()}
+			bf.ReadUint16() // Index.
+			channel.MaxPlayers = bf.ReadUint16()
+			channel.Players = bf.ReadUint16()
+			bf.ReadBytes(20)
+			world.Channels = append(world.Channels, channel)
+		}
+		worlds = append(worlds, world)
+	}
+	return worlds, nil
+}
+
+// ChannelConn is a connection to a channel server.
+type ChannelConn struct {
+	conn       net.Conn
+	cc         *network.CryptConn
+	ctx        *clientctx.ClientContext
+	ackHandle  uint32
+	unanswered []byte // Start of an ack the frames read so far held only part of.
+}
+
+// DialChannel connects to a channel server. Unlike the sign and entrance servers it takes no NULL bytes.
+func DialChannel(addr string) (*ChannelConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &ChannelConn{
+		conn: conn,
+		cc:   network.NewCryptConn(conn),
+		ctx:  &clientctx.ClientContext{StrConv: &stringsupport.StringConverter{Encoding: japanese.ShiftJIS}},
+	}, nil
+}
+
+// Close closes the connection, which logs the character out.
+func (c *ChannelConn) Close() error {
+	return c.conn.Close()
+}
+
+// NextAckHandle returns a new ack handle for a request.
+func (c *ChannelConn) NextAckHandle() uint32 {
+	c.ackHandle++
+	return c.ackHandle
+}
+
+// Send sends a packet, followed by the MSG_SYS_END the client ends its packet groups with.
+func (c *ChannelConn) Send(pkt mhfpacket.MHFPacket) error {
+	bf := byteframe.NewByteFrame()
+	bf.WriteUint16(uint16(pkt.Opcode()))
+	if err := pkt.Build(bf, c.ctx); err != nil {
+		return err
+	}
+	bf.WriteUint16(uint16(network.MSG_SYS_END))
+	c.conn.SetDeadline(time.Now().Add(Timeout))
+	return c.cc.SendPacket(bf.Data())
+}
+
+// Ack reads what the server sends until the ack with the handle, skipping the other packets, which the
+// client has no length for. An ack spread over several frames is put back together.
+func (c *ChannelConn) Ack(handle uint32) (*mhfpacket.MsgSysAck, error) {
+	for {
+		c.conn.SetDeadline(time.Now().Add(Timeout))
+		frame, err := c.cc.ReadPacket()
+		if err != nil {
+			return nil, err
+		}
+		data := append(c.unanswered, frame...)
+		c.unanswered = nil
+		if len(data) < 2 || network.PacketID(binary.BigEndian.Uint16(data)) != network.MSG_SYS_ACK {
+			continue
+		}
+		if len(data) < ackSize(data) {
+			c.unanswered = data
+			continue
+		}
+		ack := &mhfpacket.MsgSysAck{}
+		if err := ack.Parse(byteframe.NewByteFrameFromBytes(data[2:]), c.ctx); err != nil {
+			return nil, err
+		}
+		if ack.AckHandle == handle {
+			return ack, nil
+		}
+	}
+}
+
+// ackSize returns the length of the ack at the start of data, or more than it holds if it doesn't hold its
+// header yet.
+func ackSize(data []byte) int {
+	const header = 2 + 4 + 1 + 1 + 2
+	if len(data) < header {
+		return header
+	}
+	if data[6] == 0 {
+		return header + 4
+	}
+	size := int(binary.BigEndian.Uint16(data[8:]))
+	if size == 0xFFFF {
+		if len(data) < header+4 {
+			return header + 4
+		}
+		return header + 4 + int(binary.BigEndian.Uint32(data[10:]))
+	}
+	return header + size
+}
+
+// Request sends a packet built around a new ack handle and returns the server's ack to it.
+func (c *ChannelConn) Request(build func(ackHandle uint32) mhfpacket.MHFPacket) (*mhfpacket.MsgSysAck, error) {
+	handle := c.NextAckHandle()
+	if err := c.Send(build(handle)); err != nil {
+		return nil, err
+	}
+	return c.Ack(handle)
+}
+
+// Login logs the character in with the token of a sign in.
+func (c *ChannelConn) Login(charID uint32, sign *SignIn) error {
+	ack, err := c.Request(func(ackHandle uint32) mhfpacket.MHFPacket {
+		return &mhfpacket.MsgSysLogin{
+			AckHandle:              ackHandle,
+			CharID0:                charID,
+			LoginTokenNumber:       sign.TokenID,
+			CharID1:                charID,
+			LoginTokenStringLength: 0x11,
+			LoginTokenString:       sign.Token,
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if ack.ErrorCode != 0 {
+		return errors.New("channel refused the login")
+	}
+	return nil
+}
+
+// Savedata saves the character's savedata as a whole, compressed the way the client sends it.
+func (c *ChannelConn) Savedata(data []byte) error {
+	compressed, err := nullcomp.Compress(data)
+	if err != nil {
+		return err
+	}
+	ack, err := c.Request(func(ackHandle uint32) mhfpacket.MHFPacket {
+		return &mhfpacket.MsgMhfSavedata{
+			AckHandle:      ackHandle,
+			AllocMemSize:   uint32(len(compressed)),
+			SaveType:       2,
+			DataSize:       uint32(len(compressed)),
+			RawDataPayload: compressed,
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if ack.ErrorCode != 0 {
+		return errors.New("channel refused the savedata")
+	}
+	return nil
+}
+
+// Loaddata returns the character's savedata, decompressed.
+func (c *ChannelConn) Loaddata() ([]byte, error) {
+	ack, err := c.Request(func(ackHandle uint32) mhfpacket.MHFPacket {
+		return &mhfpacket.MsgMhfLoaddata{AckHandle: ackHandle}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ack.ErrorCode != 0 {
+		return nil, errors.New("channel failed to load the savedata")
+	}
+	return nullcomp.Decompress(ack.AckData)
+}
@@ -0,0 +1,62 @@
+package testclient
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/common/stringsupport"
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/clientctx"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"golang.org/x/text/encoding/japanese"
+)
+
+// serverPacket is a packet the way the channel server queues it, followed by MSG_SYS_END.
+func serverPacket(t *testing.T, pkt mhfpacket.MHFPacket) []byte {
+	bf := byteframe.NewByteFrame()
+	bf.WriteUint16(uint16(pkt.Opcode()))
+	if err := pkt.Build(bf, &clientctx.ClientContext{}); err != nil {
+		t.Fatal(err)
+	}
+	bf.WriteUint16(uint16(network.MSG_SYS_END))
+	return bf.Data()
+}
+
+func TestAckReassemblesFrames(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	c := &ChannelConn{
+		conn: client,
+		cc:   network.NewCryptConn(client),
+		ctx:  &clientctx.ClientContext{StrConv: &stringsupport.StringConverter{Encoding: japanese.ShiftJIS}},
+	}
+
+	payload := bytes.Repeat([]byte{0xAB}, 0x12000) // Past 0xFFFF, so the ack has the extended size field.
+	big := serverPacket(t, &mhfpacket.MsgSysAck{AckHandle: 7, IsBufferResponse: true, AckData: payload})
+	frames := [][]byte{
+		serverPacket(t, &mhfpacket.MsgSysPing{AckHandle: 1}),
+		serverPacket(t, &mhfpacket.MsgSysAck{AckHandle: 6, AckData: []byte{1, 2, 3, 4}}),
+		big[:8], // Cut before the size field.
+		big[8:0x8000],
+		big[0x8000:],
+	}
+	go func() {
+		cc := network.NewCryptConn(server)
+		for _, frame := range frames {
+			if err := cc.SendPacket(frame); err != nil {
+				return
+			}
+		}
+	}()
+
+	ack, err := c.Ack(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ack.AckData, payload) {
+		t.Errorf("got %d bytes of ack data, want the %d sent", len(ack.AckData), len(payload))
+	}
+}
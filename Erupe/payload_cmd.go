@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Solenataris/Erupe/common/distpayload"
+	"github.com/Solenataris/Erupe/config"
+)
+
+// itemFlags collects repeated --item flags.
+type itemFlags []distpayload.Item
+
+func (f *itemFlags) String() string {
+	names := make([]string, len(*f))
+	for i, item := range *f {
+		names[i] = item.String()
+	}
+	return strings.Join(names, ",")
+}
+
+func (f *itemFlags) Set(value string) error {
+	item, err := distpayload.ParseItem(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, item)
+	return nil
+}
+
+// runPayloadCommand builds a distribution payload and prints it as hex, optionally inserting it as a distribution.
+//
+// Usage:
+//
+//	erupe payload --item 1234x5 --item 999x1 [--insert --name "Launch gift" --char 12 --deadline "2026-12-31 23:59"]
+//	erupe payload --decode 01070000000004d20005
+func runPayloadCommand(args []string) int {
+	flags := flag.NewFlagSet("payload", flag.ContinueOnError)
+	var items itemFlags
+	flags.Var(&items, "item", "item as ID x quantity, e.g. 1234x5, repeat for more items")
+	decode := flags.String("decode", "", "check a hex payload and print its items instead")
+	insert := flags.Bool("insert", false, "insert the payload as a distribution into the database in config.json")
+	name := flags.String("name", "", "event name of the inserted distribution")
+	description := flags.String("description", "", "description of the inserted distribution")
+	charID := flags.Uint("char", 0, "character who can claim the distribution, 0 for everyone")
+	distType := flags.Int("type", 0, "distribution type the client lists it under")
+	times := flags.Int("times", 1, "times each character, or each account with --scope account, can claim the distribution")
+	scope := flags.String("scope", "character", "who --times counts the claims of, character or account")
+	maxClaims := flags.Int("max-claims", 0, "claims of everyone together the distribution allows, 0 for no cap")
+	deadline := flags.String("deadline", "", "time the distribution can no longer be claimed, \"2006-01-02 15:04\" server time")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *decode != "" {
+		data, err := hex.DecodeString(strings.TrimSpace(*decode))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid hex:", err)
+			return 1
+		}
+		decoded, err := distpayload.Parse(data)
+		if err == nil {
+			err = distpayload.Validate(decoded)
+		}
+		for _, item := range decoded {
+			fmt.Printf("type %d, ID %d, quantity %d, flags %#x\n", item.Type, item.ID, item.Quantity, item.Flags)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid payload:", err)
+			return 1
+		}
+		return 0
+	}
+
+	data, err := distpayload.Build(items)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid payload:", err)
+		return 1
+	}
+	fmt.Println(hex.EncodeToString(data))
+	if !*insert {
+		return 0
+	}
+
+	if *name == "" {
+		fmt.Fprintln(os.Stderr, "An inserted distribution needs a --name")
+		return 2
+	}
+	if *scope != "character" && *scope != "account" {
+		fmt.Fprintln(os.Stderr, "--scope must be character or account")
+		return 2
+	}
+	var until, claimCap interface{}
+	if *deadline != "" {
+		t, err := time.ParseInLocation("2006-01-02 15:04", *deadline, time.Local)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid --deadline:", err)
+			return 2
+		}
+		until = t
+	}
+	if *maxClaims > 0 {
+		claimCap = *maxClaims
+	}
+	erupeConfig, err := config.LoadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to load config:", err)
+		return 1
+	}
+	db, err := openDB(erupeConfig)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to connect to the database:", err)
+		return 1
+	}
+	defer db.Close()
+
+	var recipient interface{}
+	if *charID != 0 {
+		recipient = *charID
+	}
+	var id int
+	err = db.QueryRow(`
+		INSERT INTO distribution (character_id, type, event_name, description, times_acceptable, scope, max_claims, deadline, data)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id
+	`, recipient, *distType, *name, *description, *times, *scope, claimCap, until, data).Scan(&id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to insert distribution:", err)
+		return 1
+	}
+	fmt.Printf("Inserted distribution %d\n", id)
+	return 0
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/Solenataris/Erupe/common/itemtable"
+	"github.com/Solenataris/Erupe/common/wordfilter"
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/network/testclient"
+	"github.com/Solenataris/Erupe/server/channelserver"
+	"github.com/Solenataris/Erupe/server/entranceserver"
+	"github.com/Solenataris/Erupe/server/ipban"
+	"github.com/Solenataris/Erupe/server/signserver"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// selftest runs the checks of runSelftestCommand, printing a line per subsystem.
+type selftest struct {
+	failed bool
+}
+
+// check prints how the subsystem did, with the hint on what to do about it when it failed, and reports
+// whether it passed.
+func (t *selftest) check(subsystem string, err error, hint string) bool {
+	if err == nil {
+		fmt.Printf("PASS %s\n", subsystem)
+		return true
+	}
+	t.failed = true
+	fmt.Printf("FAIL %s: %v\n", subsystem, err)
+	if hint != "" {
+		fmt.Printf("     %s\n", hint)
+	}
+	return false
+}
+
+// skip prints a subsystem that couldn't be checked because one it depends on failed.
+func (t *selftest) skip(subsystem string, reason string) {
+	fmt.Printf("SKIP %s: %s\n", subsystem, reason)
+}
+
+// runSelftestCommand starts the servers the way a deployment runs them, against the configured database
+// and files, and signs a temporary account in through the sign, entrance and channel servers, saving
+// and loading back a savedata for its character. It prints a line per subsystem and fails if any did,
+// so a deployment can check its environment before the first player finds what's wrong with it.
+// The servers listen on free ports of their own, so it runs next to a live server too.
+//
+// Usage:
+//
+//	erupe selftest [--timeout 10]
+func runSelftestCommand(args []string) int {
+	flags := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	timeout := flags.Int("timeout", 10, "seconds to wait for each server's answer")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	testclient.Timeout = time.Duration(*timeout) * time.Second
+
+	t := &selftest{}
+	t.run()
+	if t.failed {
+		fmt.Println("Self-test failed.")
+		return 1
+	}
+	fmt.Println("Self-test passed.")
+	return 0
+}
+
+func (t *selftest) run() {
+	erupeConfig, err := config.LoadConfig()
+	if !t.check("config", err, "Fix config.json in the working directory, the error names the setting.") {
+		return
+	}
+
+	// Only warnings go to the console, so the report stays readable. A handler giving up with Fatal ends
+	// its own goroutine rather than the self-test, which then reports what didn't answer.
+	logger, packetLogger, closeLog, err := newLoggers(erupeConfig.Log)
+	if !t.check("logging", err, "Check the log level names and that the log file's directory is writable.") {
+		return
+	}
+	defer closeLog()
+	quiet := []zap.Option{zap.IncreaseLevel(zapcore.WarnLevel), zap.OnFatal(zapcore.WriteThenGoexit)}
+	logger = logger.WithOptions(quiet...)
+	packetLogger = packetLogger.WithOptions(quiet...)
+	defer logger.Sync()
+
+	t.checkFiles(erupeConfig)
+
+	db, err := openDB(erupeConfig)
+	if !t.check("database", err, databaseHint(err, erupeConfig)) {
+		t.skip("servers", "the database is needed")
+		return
+	}
+	defer db.Close()
+
+	ipBans := ipban.NewList(logger.Named("ipban"), db)
+	err = ipBans.Start(time.Duration(erupeConfig.IPBans.RefreshInterval) * time.Second)
+	if !t.check("ip bans", err, databaseHint(err, erupeConfig)) {
+		return
+	}
+	defer ipBans.Shutdown()
+
+	t.checkLogin(erupeConfig, db, ipBans, logger, packetLogger)
+}
+
+// checkFiles checks the files the channels read as players play: the quests and the lists the config
+// points at.
+func (t *selftest) checkFiles(erupeConfig *config.Config) {
+	questDir := filepath.Join(erupeConfig.BinPath, "quests")
+	quests, err := ioutil.ReadDir(questDir)
+	if err == nil && len(quests) == 0 {
+		err = fmt.Errorf("%s holds no quests", questDir)
+	}
+	t.check("quest files", err, fmt.Sprintf("Set bin_path in config.json to the directory holding the quests and scenarios folders, now %q.", erupeConfig.BinPath))
+
+	if erupeConfig.ItemGrants.Table != "" {
+		_, err := itemtable.Load(erupeConfig.ItemGrants.Table)
+		t.check("item table", err, "Fix or remove ItemGrants.Table in config.json.")
+	}
+	if erupeConfig.WordFilterFile != "" {
+		_, err := wordfilter.LoadWords(erupeConfig.WordFilterFile)
+		t.check("word filter", err, "Fix or remove WordFilterFile in config.json.")
+	}
+}
+
+// databaseHint says what to look at for a database error.
+func databaseHint(err error, erupeConfig *config.Config) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Name() {
+		case "insufficient_privilege":
+			return fmt.Sprintf("Grant the database user %q the privileges on the tables of %q, as in \"GRANT ALL ON ALL TABLES IN SCHEMA public TO %s\" and the same on its sequences.",
+				erupeConfig.Database.User, erupeConfig.Database.Database, erupeConfig.Database.User)
+		case "undefined_table", "undefined_column", "undefined_function":
+			return "The schema is older than this server, run the migrations in the migrations folder."
+		case "invalid_password", "invalid_authorization_specification":
+			return "Check the database user and password in config.json."
+		case "invalid_catalog_name":
+			return fmt.Sprintf("Create the database %q or fix its name in config.json.", erupeConfig.Database.Database)
+		}
+		return ""
+	}
+	return fmt.Sprintf("Check the database settings in config.json and that postgres listens on %s:%d.", erupeConfig.Database.Host, erupeConfig.Database.Port)
+}
+
+// freePort returns a port nothing listens on.
+func freePort() (uint16, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return uint16(l.Addr().(*net.TCPAddr).Port), nil
+}
+
+// selftestConfig is a copy of the config for servers of the self-test, on the ports given and on the
+// loopback address, with a single channel it doesn't register or relay chat from.
+func selftestConfig(erupeConfig *config.Config, signPort uint16, entrancePort uint16, channelPort uint16) *config.Config {
+	c := *erupeConfig
+	c.HostIP = "127.0.0.1"
+	c.Sign.Port = int(signPort)
+	c.Entrance.Port = entrancePort
+	c.Entrance.Entries = []config.EntranceServerInfo{{
+		IP:       "127.0.0.1",
+		Type:     1,
+		Name:     "Self-test",
+		Channels: []config.EntranceChannelInfo{{Port: channelPort, MaxPlayers: 1}},
+	}}
+	c.Channel.Register = false
+	c.Channel.PostgresChatRelay = false
+	c.Channel.ShutdownCountdown = 0
+	c.Discord.Enabled = false
+	c.DevModeOptions.ServerName = ""
+	return &c
+}
+
+// checkLogin starts a sign, an entrance and a channel server and goes through a player's login on them
+// with a temporary account, which is deleted again.
+func (t *selftest) checkLogin(erupeConfig *config.Config, db *sqlx.DB, ipBans *ipban.List, logger *zap.Logger, packetLogger *zap.Logger) {
+	var ports [3]uint16
+	for i := range ports {
+		port, err := freePort()
+		if err != nil {
+			t.check("servers", err, "The self-test listens on free ports of 127.0.0.1.")
+			return
+		}
+		ports[i] = port
+	}
+	c := selftestConfig(erupeConfig, ports[0], ports[1], ports[2])
+
+	signServer := signserver.NewServer(&signserver.Config{Logger: logger.Named("sign"), ErupeConfig: c, DB: db, IPBans: ipBans})
+	entranceServer := entranceserver.NewServer(&entranceserver.Config{Logger: logger.Named("entrance"), ErupeConfig: c, DB: db, IPBans: ipBans})
+	channelServer := channelserver.NewServer(&channelserver.Config{
+		Logger:       logger.Named("channel"),
+		PacketLogger: packetLogger,
+		ErupeConfig:  c,
+		DB:           db,
+		Name:         "Self-test",
+		World:        "Self-test",
+		Enable:       true,
+		Registry:     channelserver.NewChannelRegistry(),
+		ChatBus:      channelserver.NewLocalChatBus(),
+	})
+	err := signServer.Start()
+	if err == nil {
+		defer signServer.Shutdown()
+		err = entranceServer.Start()
+	}
+	if err == nil {
+		defer entranceServer.Shutdown()
+		err = channelServer.Start(int(ports[2]))
+	}
+	if !t.check("servers", err, "") {
+		return
+	}
+	defer channelServer.Shutdown(context.Background())
+
+	username, password := selftestCredentials()
+	userID, err := signserver.CreateAccount(db, c, username, password)
+	if !t.check("account", err, databaseHint(err, c)) {
+		return
+	}
+	defer func() {
+		t.check("cleanup", signserver.DeleteAccount(db, userID), fmt.Sprintf("Delete the account %q by hand.", username))
+	}()
+
+	// The "+" asks the sign server for a new character, as the client's character creation does.
+	sign, err := testclient.Sign(fmt.Sprintf("127.0.0.1:%d", ports[0]), username+"+", password)
+	if err == nil && len(sign.Characters) != 1 {
+		err = fmt.Errorf("got %d characters for a new account, want 1", len(sign.Characters))
+	}
+	if !t.check("sign", err, signHint(err)) {
+		return
+	}
+	char := sign.Characters[0]
+
+	// The entrance server's address is the configured host, the self-test's servers are on the loopback.
+	_, port, err := net.SplitHostPort(sign.Entrance)
+	var worlds []testclient.World
+	if err == nil {
+		worlds, err = testclient.Worlds(net.JoinHostPort("127.0.0.1", port))
+	}
+	if err == nil && !listsChannel(worlds, ports[2]) {
+		err = errors.New("the world list doesn't hold the channel")
+	}
+	if !t.check("entrance", err, "") {
+		return
+	}
+
+	channel, err := testclient.DialChannel(fmt.Sprintf("127.0.0.1:%d", ports[2]))
+	if err == nil {
+		defer channel.Close()
+		err = channel.Login(char.ID, sign)
+	}
+	if !t.check("channel login", err, "The channel's log above says why the login failed, the sign in token is checked against sign_sessions.") {
+		return
+	}
+
+	save := make([]byte, channelserver.MinSaveDataSize)
+	if _, err := rand.Read(save[1024:2048]); err != nil {
+		t.check("savedata", err, "")
+		return
+	}
+	err = channel.Savedata(save)
+	var loaded []byte
+	if err == nil {
+		loaded, err = channel.Loaddata()
+	}
+	if err == nil && !bytes.Equal(loaded, save) {
+		err = errors.New("the savedata loaded back differs from the one saved")
+	}
+	t.check("savedata", err, "The channel's log above says why, the savedata is stored in the characters table.")
+}
+
+// selftestCredentials returns a random username and password for the temporary account.
+func selftestCredentials() (string, string) {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "selftest" + hex.EncodeToString(b[:4]), hex.EncodeToString(b[4:])
+}
+
+// signHint says what to look at for a refused sign in.
+func signHint(err error) string {
+	var refused *testclient.SignInError
+	if !errors.As(err, &refused) {
+		return ""
+	}
+	switch signserver.RespID(refused.Code) {
+	case signserver.SIGN_EMAINTE:
+		return "Maintenance mode is on, the self-test can't sign in until it ends."
+	case signserver.SIGN_ECLOSE_EX:
+		return "The loopback address is locked out after failed sign ins, check Sign.LoginFailures."
+	case signserver.SIGN_ERIGHT:
+		return "The new account got no character slot, check CharacterSlots.Base."
+	}
+	return "The sign server's log above says why the sign in was refused."
+}
+
+// listsChannel reports whether a world of the list has a channel on the port.
+func listsChannel(worlds []testclient.World, port uint16) bool {
+	for _, world := range worlds {
+		for _, channel := range world.Channels {
+			if channel.Port == port {
+				return true
+			}
+		}
+	}
+	return false
+}
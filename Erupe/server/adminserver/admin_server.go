@@ -0,0 +1,399 @@
+package adminserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Solenataris/Erupe/common/itemtable"
+	"github.com/Solenataris/Erupe/common/targeting"
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/server/channelserver"
+	"github.com/Solenataris/Erupe/server/ipban"
+	"github.com/Solenataris/Erupe/server/lockdownserver"
+	"github.com/Solenataris/Erupe/server/maintenanceserver"
+	"github.com/Solenataris/Erupe/server/restartserver"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// SessionRegistry is anything holding live sessions the admin API can act on, such as a channel server.
+type SessionRegistry interface {
+	AdminSessions() []channelserver.AdminSession
+	Kick(charID uint32) bool
+	RevokeSessions(userID uint32, reason channelserver.RevokeReason) int
+	MuteSessions(userID uint32, until time.Time) int
+	BroadcastChatMessage(message string)
+	Announce(message string, worlds []string, target *targeting.Filter) bool
+	TracePackets(charID uint32, duration time.Duration) (channelserver.PacketTraceInfo, error)
+	StopTracingPackets(charID uint32) bool
+	PacketTraces() []channelserver.PacketTraceInfo
+	MatchmakingQueue() channelserver.MatchmakingStatus
+}
+
+// banStore records bans, which the sign and channel servers enforce, returning the account of the character.
+type banStore interface {
+	Ban(charID uint32, expiresAt *time.Time, req banRequest) (uint32, error)
+}
+
+var errCharacterNotFound = errors.New("character not found")
+
+// dbBanStore writes bans to the bans table, against the account owning the character.
+// An account ban revokes the account in the same transaction, see channelserver.RevokeAccount.
+type dbBanStore struct {
+	db *sqlx.DB
+}
+
+func (b dbBanStore) Ban(charID uint32, expiresAt *time.Time, req banRequest) (uint32, error) {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var userID uint32
+	err = tx.QueryRow(`
+		INSERT INTO bans (user_id, character_id, reason, expires_at, issued_by, category)
+		SELECT user_id, CASE WHEN $2 THEN id END, $3, $4, $5, $6 FROM characters WHERE id = $1
+		RETURNING user_id
+	`, charID, req.CharacterOnly, req.Reason, expiresAt, req.IssuedBy, req.Category).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, errCharacterNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	// Journaled with its category, so a ban taken by hand counts towards the category's next step.
+	_, err = tx.Exec("INSERT INTO admin_audit_log (character_id, action, detail, issued_by, category) VALUES ($1, 'ban', $2, $3, $4)",
+		charID, req.Reason, req.IssuedBy, req.Category)
+	if err != nil {
+		return 0, err
+	}
+	if !req.CharacterOnly {
+		if err = channelserver.RevokeAccount(tx, userID, channelserver.RevokeBanned); err != nil {
+			return 0, err
+		}
+	}
+	return userID, tx.Commit()
+}
+
+// Config struct allows configuring the server.
+type Config struct {
+	Logger      *zap.Logger
+	DB          *sqlx.DB
+	ErupeConfig *config.Config
+	Registries  []SessionRegistry
+	IPBans      *ipban.List
+	Restarts    *restartserver.Server
+	Maintenance *lockdownserver.Server
+	Tasks       *maintenanceserver.Server // Scheduled DB maintenance, nil when it's disabled.
+	Items       *itemtable.Table          // Items that can be given, nil allows any plausible item ID.
+
+	// Reloads the config file, returning the changed settings that need a restart, see config.Reload.
+	ReloadConfig func() ([]string, error)
+}
+
+// Server is the token authenticated JSON admin API.
+type Server struct {
+	sync.Mutex
+	logger       *zap.Logger
+	erupeConfig  *config.Config
+	db           *sqlx.DB
+	registries   []SessionRegistry
+	bans         banStore
+	passwords    passwordStore
+	alts         altStore
+	ipBans       ipBanList
+	inventory    inventoryStore
+	savedata     savedataStore
+	escalation   escalationStore
+	invites      inviteStore
+	characters   characterStore
+	channels     channelStore
+	restarts     restartScheduler
+	maintenance  maintenanceMode
+	tasks        maintenanceTasks
+	grants       grantStore
+	targets      targetStore
+	contentGates contentGateStore
+	points       pointsStore
+	gacha        gachaStore
+	campaigns    campaignStore
+	items        *itemtable.Table
+	httpServer   *http.Server
+
+	reloadConfig func() ([]string, error)
+
+	stopAltDetection chan struct{}
+}
+
+// NewServer creates a new Server type.
+func NewServer(config *Config) *Server {
+	s := &Server{
+		logger:       config.Logger,
+		erupeConfig:  config.ErupeConfig,
+		db:           config.DB,
+		registries:   config.Registries,
+		bans:         dbBanStore{config.DB},
+		passwords:    dbPasswordStore{config.DB, config.ErupeConfig},
+		alts:         dbAltStore{config.DB},
+		ipBans:       config.IPBans,
+		inventory:    dbInventoryStore{config.DB},
+		savedata:     dbSavedataStore{config.DB},
+		escalation:   dbEscalationStore{config.DB},
+		invites:      dbInviteStore{config.DB},
+		characters:   dbCharacterStore{config.DB, time.Duration(config.ErupeConfig.Sign.DeletedCharacterRetention) * 24 * time.Hour},
+		channels:     dbChannelStore{config.DB},
+		restarts:     config.Restarts,
+		maintenance:  config.Maintenance,
+		grants:       dbGrantStore{config.DB},
+		targets:      dbTargetStore{config.DB},
+		contentGates: dbContentGateStore{config.DB},
+		points:       dbPointsStore{config.DB},
+		gacha:        dbGachaStore{config.DB},
+		campaigns:    dbCampaignStore{config.DB},
+		items:        config.Items,
+		httpServer:   &http.Server{},
+
+		reloadConfig: config.ReloadConfig,
+
+		stopAltDetection: make(chan struct{}),
+	}
+	if config.Tasks != nil {
+		s.tasks = config.Tasks
+	}
+	return s
+}
+
+// Start starts the server in a new goroutine.
+func (s *Server) Start() error {
+	if s.erupeConfig.Admin.Token == "" {
+		return errors.New("the admin API needs a token")
+	}
+
+	s.httpServer.Addr = s.erupeConfig.Admin.Address
+	s.httpServer.Handler = s.router()
+
+	serveError := make(chan error, 1)
+	go func() {
+		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			// Send error if any.
+			serveError <- err
+		}
+	}()
+
+	// Get the error from calling ListenAndServe, otherwise assume it's good after 250 milliseconds.
+	select {
+	case err := <-serveError:
+		return err
+	case <-time.After(250 * time.Millisecond):
+		go s.runAltDetection(s.db)
+		return nil
+	}
+}
+
+// Shutdown exits the server gracefully.
+func (s *Server) Shutdown() {
+	s.logger.Debug("Shutting down")
+	close(s.stopAltDetection)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		// Just warn because we are shutting down the server anyway.
+		s.logger.Warn("Got error on httpServer shutdown", zap.Error(err))
+	}
+}
+
+func (s *Server) router() http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/sessions", s.serveSessions).Methods(http.MethodGet)
+	r.HandleFunc("/kick/{charID:[0-9]+}", s.serveKick).Methods(http.MethodPost)
+	r.HandleFunc("/ban/{charID:[0-9]+}", s.serveBan).Methods(http.MethodPost)
+	r.HandleFunc("/characters/{charID:[0-9]+}/offenses", s.serveOffense).Methods(http.MethodPost)
+	r.HandleFunc("/broadcast", s.serveBroadcast).Methods(http.MethodPost)
+	r.HandleFunc("/announce", s.serveAnnounce).Methods(http.MethodPost)
+	r.HandleFunc("/alts/{charID:[0-9]+}", s.serveAlts).Methods(http.MethodGet)
+	r.HandleFunc("/ipbans", s.serveAddIPBan).Methods(http.MethodPost)
+	r.HandleFunc("/ipbans", s.serveRemoveIPBan).Methods(http.MethodDelete)
+	r.HandleFunc("/characters/{charID:[0-9]+}/itembox", s.serveItemBox).Methods(http.MethodGet)
+	r.HandleFunc("/characters/{charID:[0-9]+}/itembox/add", s.serveAddItem).Methods(http.MethodPost)
+	r.HandleFunc("/characters/{charID:[0-9]+}/itembox/remove", s.serveRemoveItem).Methods(http.MethodPost)
+	r.HandleFunc("/characters/{charID:[0-9]+}/zenny", s.serveZenny).Methods(http.MethodGet)
+	r.HandleFunc("/characters/{charID:[0-9]+}/zenny", s.serveAdjustZenny).Methods(http.MethodPost)
+	r.HandleFunc("/characters/{charID:[0-9]+}/equipbox", s.serveEquipBox).Methods(http.MethodGet)
+	r.HandleFunc("/characters/{charID:[0-9]+}/equipbox/add", s.serveAddEquipment).Methods(http.MethodPost)
+	r.HandleFunc("/characters/{charID:[0-9]+}/equipbox/remove", s.serveRemoveEquipment).Methods(http.MethodPost)
+	r.HandleFunc("/invites", s.serveGenerateInvite).Methods(http.MethodPost)
+	r.HandleFunc("/campaign-codes", s.serveCreateCampaignCodes).Methods(http.MethodPost)
+	r.HandleFunc("/characters/{charID:[0-9]+}/password", s.serveSetPassword).Methods(http.MethodPost)
+	r.HandleFunc("/characters/{charID:[0-9]+}/restore", s.serveRestoreCharacter).Methods(http.MethodPost)
+	r.HandleFunc("/characters/{charID:[0-9]+}/savedata/backups", s.serveSavedataBackups).Methods(http.MethodGet)
+	r.HandleFunc("/characters/{charID:[0-9]+}/savedata/restore", s.serveRestoreSavedata).Methods(http.MethodPost)
+	r.HandleFunc("/characters/{charID:[0-9]+}/savedata/anomalies", s.serveSaveAnomalies).Methods(http.MethodGet)
+	r.HandleFunc("/savedata/anomalies", s.serveSaveAnomalies).Methods(http.MethodGet)
+	r.HandleFunc("/characters/{charID:[0-9]+}/points/ledger", s.servePointsLedger).Methods(http.MethodGet)
+	r.HandleFunc("/gacha/rates", s.serveGachaRates).Methods(http.MethodGet)
+	r.HandleFunc("/characters/{charID:[0-9]+}/grant", s.serveGrantItem).Methods(http.MethodPost)
+	r.HandleFunc("/grants", s.serveGrantTargeted).Methods(http.MethodPost)
+	r.HandleFunc("/targets/preview", s.servePreviewTarget).Methods(http.MethodPost)
+	r.HandleFunc("/content-gates", s.serveContentGates).Methods(http.MethodGet)
+	r.HandleFunc("/content-gates/open", s.serveOpenContentGate).Methods(http.MethodPost)
+	r.HandleFunc("/characters/{charID:[0-9]+}/trace", s.serveStartTrace).Methods(http.MethodPost)
+	r.HandleFunc("/characters/{charID:[0-9]+}/trace", s.serveStopTrace).Methods(http.MethodDelete)
+	r.HandleFunc("/traces", s.serveTraces).Methods(http.MethodGet)
+	r.HandleFunc("/matchmaking", s.serveMatchmaking).Methods(http.MethodGet)
+	r.HandleFunc("/restart", s.serveRestart).Methods(http.MethodGet)
+	r.HandleFunc("/restart", s.serveScheduleRestart).Methods(http.MethodPost)
+	r.HandleFunc("/restart", s.serveCancelRestart).Methods(http.MethodDelete)
+	r.HandleFunc("/maintenance", s.serveMaintenance).Methods(http.MethodGet)
+	r.HandleFunc("/maintenance", s.serveBeginMaintenance).Methods(http.MethodPost)
+	r.HandleFunc("/maintenance", s.serveEndMaintenance).Methods(http.MethodDelete)
+	r.HandleFunc("/maintenance/tasks", s.serveMaintenanceTasks).Methods(http.MethodGet)
+	r.HandleFunc("/config/reload", s.serveReloadConfig).Methods(http.MethodPost)
+	r.HandleFunc("/channels", s.serveChannels).Methods(http.MethodGet)
+	r.HandleFunc("/channels/{id:[0-9]+}/drain", s.serveDrainChannel).Methods(http.MethodPost)
+	return s.authenticate(r)
+}
+
+// authenticate rejects requests without the configured bearer token.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	want := []byte("Bearer " + s.erupeConfig.Admin.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logger.Warn("Failed to write admin response", zap.Error(err))
+	}
+}
+
+// kick disconnects the character from whichever registry has it.
+func (s *Server) kick(charID uint32) bool {
+	kicked := false
+	for _, registry := range s.registries {
+		if registry.Kick(charID) {
+			kicked = true
+		}
+	}
+	return kicked
+}
+
+// revoke disconnects the account's sessions from every registry, telling the players why.
+// Other processes sharing the DB disconnect theirs on the notification of channelserver.RevokeAccount.
+func (s *Server) revoke(userID uint32, reason channelserver.RevokeReason) bool {
+	revoked := 0
+	for _, registry := range s.registries {
+		revoked += registry.RevokeSessions(userID, reason)
+	}
+	return revoked > 0
+}
+
+func parseCharID(r *http.Request) uint32 {
+	// The route only matches digits, so this only fails on overflow.
+	charID, _ := strconv.ParseUint(mux.Vars(r)["charID"], 10, 32)
+	return uint32(charID)
+}
+
+func (s *Server) serveSessions(w http.ResponseWriter, r *http.Request) {
+	sessions := []channelserver.AdminSession{}
+	for _, registry := range s.registries {
+		sessions = append(sessions, registry.AdminSessions()...)
+	}
+	s.writeJSON(w, sessions)
+}
+
+func (s *Server) serveKick(w http.ResponseWriter, r *http.Request) {
+	charID := parseCharID(r)
+	if !s.kick(charID) {
+		http.Error(w, "character isn't online", http.StatusNotFound)
+		return
+	}
+	s.logger.Info("Kicked character", zap.Uint32("charID", charID))
+	s.writeJSON(w, map[string]bool{"kicked": true})
+}
+
+type banRequest struct {
+	Duration      string `json:"duration"` // Go duration such as "72h", empty for a permanent ban.
+	Reason        string `json:"reason"`
+	CharacterOnly bool   `json:"characterOnly"` // Ban the character alone rather than its whole account.
+	Category      string `json:"category"`      // Offense category, counted by its escalation ladder, see escalation.go.
+	IssuedBy      string `json:"issuedBy"`
+}
+
+func (s *Server) serveBan(w http.ResponseWriter, r *http.Request) {
+	charID := parseCharID(r)
+	var req banRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid ban request", http.StatusBadRequest)
+		return
+	}
+	var expiresAt *time.Time
+	if req.Duration != "" {
+		duration, err := time.ParseDuration(req.Duration)
+		if err != nil || duration <= 0 {
+			http.Error(w, "invalid ban duration", http.StatusBadRequest)
+			return
+		}
+		expires := time.Now().Add(duration)
+		expiresAt = &expires
+	}
+	if req.IssuedBy == "" {
+		req.IssuedBy = "admin API"
+	}
+
+	userID, err := s.bans.Ban(charID, expiresAt, req)
+	if err == errCharacterNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to ban character", zap.Error(err), zap.Uint32("charID", charID))
+		http.Error(w, "failed to store ban", http.StatusInternalServerError)
+		return
+	}
+	s.logger.Info("Banned character",
+		zap.Uint32("charID", charID),
+		zap.Bool("characterOnly", req.CharacterOnly),
+		zap.String("duration", req.Duration),
+		zap.String("reason", req.Reason),
+		zap.String("issuedBy", req.IssuedBy),
+	)
+	// A character ban leaves the account signed in for its other characters, the channel refuses this one.
+	kicked := false
+	if req.CharacterOnly {
+		kicked = s.kick(charID)
+	} else {
+		kicked = s.revoke(userID, channelserver.RevokeBanned)
+	}
+	s.writeJSON(w, map[string]bool{"kicked": kicked})
+}
+
+type broadcastRequest struct {
+	Message string `json:"message"`
+}
+
+func (s *Server) serveBroadcast(w http.ResponseWriter, r *http.Request) {
+	var req broadcastRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Message == "" {
+		http.Error(w, "invalid broadcast request", http.StatusBadRequest)
+		return
+	}
+	for _, registry := range s.registries {
+		registry.BroadcastChatMessage(req.Message)
+	}
+	s.writeJSON(w, map[string]bool{"sent": true})
+}
@@ -0,0 +1,227 @@
+package adminserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/common/targeting"
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/server/channelserver"
+	"go.uber.org/zap"
+)
+
+const testToken = "secret"
+
+type fakeRegistry struct {
+	sessions  []channelserver.AdminSession
+	kicked    []uint32
+	revoked   []channelserver.RevokeReason
+	muted     []time.Time
+	broadcast []string
+	traces    map[uint32]time.Duration
+	queue     channelserver.MatchmakingStatus
+	world     string
+}
+
+func (f *fakeRegistry) AdminSessions() []channelserver.AdminSession { return f.sessions }
+
+func (f *fakeRegistry) Kick(charID uint32) bool {
+	for _, session := range f.sessions {
+		if session.CharID == charID {
+			f.kicked = append(f.kicked, charID)
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeRegistry) RevokeSessions(userID uint32, reason channelserver.RevokeReason) int {
+	// The fake's accounts each have the character with the same ID.
+	if f.Kick(userID) {
+		f.revoked = append(f.revoked, reason)
+		return 1
+	}
+	return 0
+}
+
+func (f *fakeRegistry) MuteSessions(userID uint32, until time.Time) int {
+	// The fake's accounts each have the character with the same ID.
+	for _, session := range f.sessions {
+		if session.CharID == userID {
+			f.muted = append(f.muted, until)
+			return 1
+		}
+	}
+	return 0
+}
+
+func (f *fakeRegistry) BroadcastChatMessage(message string) {
+	f.broadcast = append(f.broadcast, message)
+}
+
+func (f *fakeRegistry) Announce(message string, worlds []string, target *targeting.Filter) bool {
+	if len(worlds) > 0 && worlds[0] != f.world {
+		return false
+	}
+	f.broadcast = append(f.broadcast, message)
+	return true
+}
+
+func (f *fakeRegistry) TracePackets(charID uint32, duration time.Duration) (channelserver.PacketTraceInfo, error) {
+	if f.traces == nil {
+		f.traces = make(map[uint32]time.Duration)
+	}
+	f.traces[charID] = duration
+	return channelserver.PacketTraceInfo{CharID: charID, Path: "trace.log"}, nil
+}
+
+func (f *fakeRegistry) StopTracingPackets(charID uint32) bool {
+	_, ok := f.traces[charID]
+	delete(f.traces, charID)
+	return ok
+}
+
+func (f *fakeRegistry) PacketTraces() []channelserver.PacketTraceInfo {
+	var list []channelserver.PacketTraceInfo
+	for charID := range f.traces {
+		list = append(list, channelserver.PacketTraceInfo{CharID: charID, Path: "trace.log"})
+	}
+	return list
+}
+
+func (f *fakeRegistry) MatchmakingQueue() channelserver.MatchmakingStatus { return f.queue }
+
+type fakeBan struct {
+	charID    uint32
+	expiresAt *time.Time
+	banRequest
+}
+
+type fakeBanStore struct {
+	bans []fakeBan
+}
+
+func (f *fakeBanStore) Ban(charID uint32, expiresAt *time.Time, req banRequest) (uint32, error) {
+	if charID == 404 {
+		return 0, errCharacterNotFound
+	}
+	f.bans = append(f.bans, fakeBan{charID, expiresAt, req})
+	return charID, nil
+}
+
+func newTestServer() (*Server, *fakeRegistry, *fakeBanStore) {
+	registry := &fakeRegistry{sessions: []channelserver.AdminSession{
+		{CharID: 1, Name: "Alpha", Stage: "sl1Ns200p0a0u0", UptimeSeconds: 60},
+		{CharID: 2, Name: "Beta", Stage: "sl1Ns211p0a0u0", UptimeSeconds: 5},
+	}}
+	bans := &fakeBanStore{}
+	s := &Server{
+		logger:      zap.NewNop(),
+		erupeConfig: &config.Config{Admin: config.Admin{Token: testToken}},
+		registries:  []SessionRegistry{registry},
+		bans:        bans,
+	}
+	return s, registry, bans
+}
+
+func doRequest(s *Server, method string, path string, body string, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.router().ServeHTTP(w, req)
+	return w
+}
+
+func TestRejectsMissingToken(t *testing.T) {
+	s, registry, _ := newTestServer()
+	for _, token := range []string{"", "wrong"} {
+		if w := doRequest(s, http.MethodPost, "/kick/1", "", token); w.Code != http.StatusUnauthorized {
+			t.Errorf("token %q: got status %d, want %d", token, w.Code, http.StatusUnauthorized)
+		}
+	}
+	if len(registry.kicked) != 0 {
+		t.Error("unauthenticated request kicked a character")
+	}
+}
+
+func TestListSessions(t *testing.T) {
+	s, _, _ := newTestServer()
+	w := doRequest(s, http.MethodGet, "/sessions", "", testToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	var sessions []channelserver.AdminSession
+	if err := json.NewDecoder(w.Body).Decode(&sessions); err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[0].Name != "Alpha" || sessions[1].Stage != "sl1Ns211p0a0u0" {
+		t.Errorf("got sessions %+v", sessions)
+	}
+}
+
+func TestKick(t *testing.T) {
+	s, registry, _ := newTestServer()
+	if w := doRequest(s, http.MethodPost, "/kick/2", "", testToken); w.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if len(registry.kicked) != 1 || registry.kicked[0] != 2 {
+		t.Errorf("got kicked %v, want [2]", registry.kicked)
+	}
+	if w := doRequest(s, http.MethodPost, "/kick/3", "", testToken); w.Code != http.StatusNotFound {
+		t.Errorf("kicking an offline character got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestBan(t *testing.T) {
+	s, registry, bans := newTestServer()
+	w := doRequest(s, http.MethodPost, "/ban/1", `{"duration": "72h", "reason": "botting"}`, testToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if len(bans.bans) != 1 || bans.bans[0].charID != 1 || bans.bans[0].Reason != "botting" || bans.bans[0].IssuedBy != "admin API" {
+		t.Fatalf("got bans %+v", bans.bans)
+	}
+	if until := time.Until(*bans.bans[0].expiresAt); until < 71*time.Hour || until > 72*time.Hour {
+		t.Errorf("ban expires in %v, want 72h", until)
+	}
+	if len(registry.kicked) != 1 || len(registry.revoked) != 1 || registry.revoked[0] != channelserver.RevokeBanned {
+		t.Errorf("banned account wasn't revoked, got kicks %v for %v", registry.kicked, registry.revoked)
+	}
+
+	doRequest(s, http.MethodPost, "/ban/2", `{"reason": "cheating", "characterOnly": true, "issuedBy": "GM Kyo"}`, testToken)
+	if len(bans.bans) != 2 || bans.bans[1].expiresAt != nil {
+		t.Fatal("ban without a duration isn't permanent")
+	}
+	if !bans.bans[1].CharacterOnly || bans.bans[1].IssuedBy != "GM Kyo" {
+		t.Errorf("got ban %+v, want a character ban issued by GM Kyo", bans.bans[1])
+	}
+	if len(registry.kicked) != 2 || len(registry.revoked) != 1 {
+		t.Error("character ban wasn't a kick of the character alone")
+	}
+
+	if w := doRequest(s, http.MethodPost, "/ban/1", `{"duration": "soon"}`, testToken); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid duration got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := doRequest(s, http.MethodPost, "/ban/404", `{}`, testToken); w.Code != http.StatusNotFound {
+		t.Errorf("unknown character got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	s, registry, _ := newTestServer()
+	if w := doRequest(s, http.MethodPost, "/broadcast", `{"message": "Restart in 5 minutes"}`, testToken); w.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if len(registry.broadcast) != 1 || registry.broadcast[0] != "Restart in 5 minutes" {
+		t.Errorf("got broadcasts %v", registry.broadcast)
+	}
+	if w := doRequest(s, http.MethodPost, "/broadcast", `{}`, testToken); w.Code != http.StatusBadRequest {
+		t.Errorf("empty message got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,214 @@
+package adminserver
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// How often new logins are folded into the duplicate account signals.
+const altDetectionInterval = 10 * time.Minute
+
+// Most logins processed per aggregation transaction.
+const altDetectionBatch = 1000
+
+// Duplicate account signals.
+const (
+	altSignalFingerprint  = "shared-fingerprint"
+	altSignalIP           = "shared-ip"
+	altSignalSimultaneous = "near-simultaneous-login"
+	altSignalName         = "same-character-name"
+)
+
+// Shown with every report, the signals are never acted on automatically.
+const altReportNotice = "Advisory only: these signals are for moderation review, they never block or ban anyone automatically."
+
+// AltSignal is a link between two accounts found in their login history or characters.
+type AltSignal struct {
+	UserID      int       `db:"user_id" json:"-"`
+	OtherUserID int       `db:"other_user_id" json:"-"`
+	Signal      string    `db:"signal" json:"signal"`
+	Detail      string    `db:"detail" json:"detail"`
+	Count       int       `db:"count" json:"count"`
+	FirstSeen   time.Time `db:"first_seen" json:"firstSeen"`
+	LastSeen    time.Time `db:"last_seen" json:"lastSeen"`
+}
+
+type possibleAlt struct {
+	UserID  int         `json:"userID"`
+	Signals []AltSignal `json:"signals"`
+}
+
+type altReport struct {
+	Advisory     bool          `json:"advisory"`
+	Notice       string        `json:"notice"`
+	UserID       int           `json:"userID"`
+	PossibleAlts []possibleAlt `json:"possibleAlts"`
+}
+
+// altStore reads the aggregated signals of the account owning a character.
+type altStore interface {
+	AltSignals(charID uint32) (userID int, signals []AltSignal, err error)
+}
+
+type dbAltStore struct {
+	db *sqlx.DB
+}
+
+func (a dbAltStore) AltSignals(charID uint32) (int, []AltSignal, error) {
+	var userID int
+	if err := a.db.QueryRow("SELECT user_id FROM characters WHERE id = $1", charID).Scan(&userID); err != nil {
+		return 0, nil, errCharacterNotFound
+	}
+	var signals []AltSignal
+	err := a.db.Select(&signals, "SELECT user_id, other_user_id, signal, detail, count, first_seen, last_seen FROM alt_signals WHERE user_id = $1 OR other_user_id = $1", userID)
+	return userID, signals, err
+}
+
+// buildAltReport groups the signals by the other account, the accounts with the most kinds of signal first.
+func buildAltReport(userID int, signals []AltSignal) altReport {
+	byUser := make(map[int][]AltSignal)
+	for _, signal := range signals {
+		other := signal.OtherUserID
+		if other == userID {
+			other = signal.UserID
+		}
+		byUser[other] = append(byUser[other], signal)
+	}
+
+	report := altReport{Advisory: true, Notice: altReportNotice, UserID: userID, PossibleAlts: []possibleAlt{}}
+	for other, signals := range byUser {
+		sort.Slice(signals, func(i, j int) bool { return signals[i].LastSeen.After(signals[j].LastSeen) })
+		report.PossibleAlts = append(report.PossibleAlts, possibleAlt{UserID: other, Signals: signals})
+	}
+	sort.Slice(report.PossibleAlts, func(i, j int) bool {
+		a, b := report.PossibleAlts[i], report.PossibleAlts[j]
+		if kinds, otherKinds := signalKinds(a.Signals), signalKinds(b.Signals); kinds != otherKinds {
+			return kinds > otherKinds
+		}
+		return a.UserID < b.UserID
+	})
+	return report
+}
+
+func signalKinds(signals []AltSignal) int {
+	kinds := make(map[string]bool)
+	for _, signal := range signals {
+		kinds[signal.Signal] = true
+	}
+	return len(kinds)
+}
+
+func (s *Server) serveAlts(w http.ResponseWriter, r *http.Request) {
+	userID, signals, err := s.alts.AltSignals(parseCharID(r))
+	if err == errCharacterNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to get duplicate account signals", zap.Error(err))
+		http.Error(w, "failed to get signals", http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, buildAltReport(userID, signals))
+}
+
+// Each statement folds the logins with IDs in ($1, $2] into alt_signals. The first two record the
+// batch's accounts in the fingerprint and address lookups, which the signals are then matched against
+// through their primary keys, so a batch costs the accounts sharing its fingerprints and addresses
+// rather than every earlier login.
+var altAggregationQueries = []string{
+	`INSERT INTO login_fingerprints (fingerprint, user_id, last_seen)
+	SELECT fingerprint, user_id, MAX(logged_in_at) FROM login_history WHERE id > $1 AND id <= $2 AND fingerprint <> '' GROUP BY fingerprint, user_id
+	ON CONFLICT (fingerprint, user_id) DO UPDATE SET last_seen = GREATEST(login_fingerprints.last_seen, EXCLUDED.last_seen)`,
+	`INSERT INTO login_addresses (ip, user_id, last_seen)
+	SELECT ip, user_id, MAX(logged_in_at) FROM login_history WHERE id > $1 AND id <= $2 GROUP BY ip, user_id
+	ON CONFLICT (ip, user_id) DO UPDATE SET last_seen = GREATEST(login_addresses.last_seen, EXCLUDED.last_seen)`,
+	// Logins with the same fingerprint as another account, see signserver.loginFingerprint.
+	`INSERT INTO alt_signals (user_id, other_user_id, signal, detail, count, first_seen, last_seen)
+	SELECT n.user_id, o.user_id, '` + altSignalFingerprint + `', n.fingerprint, COUNT(*), MIN(n.logged_in_at), MAX(n.logged_in_at)
+	FROM login_history n JOIN login_fingerprints o ON o.fingerprint = n.fingerprint AND o.user_id <> n.user_id
+	WHERE n.id > $1 AND n.id <= $2 AND n.fingerprint <> ''
+	GROUP BY n.user_id, o.user_id, n.fingerprint
+	ON CONFLICT (user_id, other_user_id, signal, detail) DO UPDATE SET count = alt_signals.count + EXCLUDED.count, last_seen = GREATEST(alt_signals.last_seen, EXCLUDED.last_seen)`,
+	// Logins from an address another account used in the previous 30 days.
+	`INSERT INTO alt_signals (user_id, other_user_id, signal, detail, count, first_seen, last_seen)
+	SELECT n.user_id, o.user_id, '` + altSignalIP + `', n.ip, COUNT(*), MIN(n.logged_in_at), MAX(n.logged_in_at)
+	FROM login_history n JOIN login_addresses o ON o.ip = n.ip AND o.user_id <> n.user_id
+		AND o.last_seen >= n.logged_in_at - interval '30 days'
+	WHERE n.id > $1 AND n.id <= $2
+	GROUP BY n.user_id, o.user_id, n.ip
+	ON CONFLICT (user_id, other_user_id, signal, detail) DO UPDATE SET count = alt_signals.count + EXCLUDED.count, last_seen = GREATEST(alt_signals.last_seen, EXCLUDED.last_seen)`,
+	// Logins within 5 minutes of another account's login from the same address, a range of the (ip, logged_in_at) index.
+	`INSERT INTO alt_signals (user_id, other_user_id, signal, detail, count, first_seen, last_seen)
+	SELECT n.user_id, o.user_id, '` + altSignalSimultaneous + `', n.ip, COUNT(DISTINCT n.id), MIN(n.logged_in_at), MAX(n.logged_in_at)
+	FROM login_history n JOIN login_history o ON o.ip = n.ip AND o.user_id <> n.user_id
+		AND o.logged_in_at BETWEEN n.logged_in_at - interval '5 minutes' AND n.logged_in_at + interval '5 minutes'
+	WHERE n.id > $1 AND n.id <= $2
+	GROUP BY n.user_id, o.user_id, n.ip
+	ON CONFLICT (user_id, other_user_id, signal, detail) DO UPDATE SET count = alt_signals.count + EXCLUDED.count, last_seen = GREATEST(alt_signals.last_seen, EXCLUDED.last_seen)`,
+	// Characters of the accounts that logged in sharing a name with another account's character.
+	`INSERT INTO alt_signals (user_id, other_user_id, signal, detail, count, first_seen, last_seen)
+	SELECT DISTINCT c.user_id, o.user_id, '` + altSignalName + `', c.name, 1, now(), now()
+	FROM characters c JOIN characters o ON o.name = c.name AND o.user_id <> c.user_id
+	WHERE c.user_id IN (SELECT user_id FROM login_history WHERE id > $1 AND id <= $2) AND c.name <> '' AND NOT c.is_new_character
+	ON CONFLICT (user_id, other_user_id, signal, detail) DO UPDATE SET last_seen = EXCLUDED.last_seen`,
+}
+
+// aggregateAltSignals folds the logins since the last run into alt_signals, a batch per transaction,
+// and returns the number of batches. The progress row is locked so nodes sharing the DB never fold
+// the same logins twice.
+func aggregateAltSignals(db *sqlx.DB) (int, error) {
+	batches := 0
+	for {
+		tx, err := db.Beginx()
+		if err != nil {
+			return batches, err
+		}
+		var from, to int
+		err = tx.QueryRow("SELECT last_login_id FROM alt_signal_progress FOR UPDATE").Scan(&from)
+		if err == nil {
+			err = tx.QueryRow("SELECT COALESCE(MAX(id), $1) FROM (SELECT id FROM login_history WHERE id > $1 ORDER BY id LIMIT $2) b", from, altDetectionBatch).Scan(&to)
+		}
+		if err != nil || to == from {
+			tx.Rollback()
+			return batches, err
+		}
+		for _, query := range altAggregationQueries {
+			if _, err = tx.Exec(query, from, to); err != nil {
+				tx.Rollback()
+				return batches, err
+			}
+		}
+		if _, err = tx.Exec("UPDATE alt_signal_progress SET last_login_id = $1", to); err != nil {
+			tx.Rollback()
+			return batches, err
+		}
+		if err = tx.Commit(); err != nil {
+			return batches, err
+		}
+		batches++
+	}
+}
+
+// runAltDetection aggregates the signals every altDetectionInterval until the server shuts down.
+func (s *Server) runAltDetection(db *sqlx.DB) {
+	ticker := time.NewTicker(altDetectionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopAltDetection:
+			return
+		case <-ticker.C:
+			batches, err := aggregateAltSignals(db)
+			if err != nil {
+				s.logger.Error("Failed to aggregate duplicate account signals", zap.Error(err))
+			} else if batches > 0 {
+				s.logger.Debug("Aggregated duplicate account signals", zap.Int("batches", batches))
+			}
+		}
+	}
+}
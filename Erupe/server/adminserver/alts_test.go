@@ -0,0 +1,66 @@
+package adminserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type fakeAltStore struct {
+	userID  int
+	signals []AltSignal
+}
+
+func (f fakeAltStore) AltSignals(charID uint32) (int, []AltSignal, error) {
+	if charID == 404 {
+		return 0, nil, errCharacterNotFound
+	}
+	return f.userID, f.signals, nil
+}
+
+func TestBuildAltReportGroupsByOtherAccount(t *testing.T) {
+	seen := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	signals := []AltSignal{
+		{UserID: 1, OtherUserID: 2, Signal: altSignalIP, Detail: "10.0.0.1", Count: 3, LastSeen: seen},
+		// Found when account 3 logged in, so the account asked about is the other side.
+		{UserID: 3, OtherUserID: 1, Signal: altSignalIP, Detail: "10.0.0.2", Count: 1, LastSeen: seen},
+		{UserID: 3, OtherUserID: 1, Signal: altSignalFingerprint, Detail: "ab12", Count: 1, LastSeen: seen.Add(time.Hour)},
+	}
+
+	report := buildAltReport(1, signals)
+	if !report.Advisory || report.Notice == "" {
+		t.Error("report isn't labelled as advisory")
+	}
+	if len(report.PossibleAlts) != 2 {
+		t.Fatalf("got %d possible alts, want 2", len(report.PossibleAlts))
+	}
+	// Account 3 shares two kinds of signal, so it's listed first.
+	if first := report.PossibleAlts[0]; first.UserID != 3 || len(first.Signals) != 2 || first.Signals[0].Signal != altSignalFingerprint {
+		t.Errorf("got first possible alt %+v, want account 3 with its latest signal first", first)
+	}
+	if report.PossibleAlts[1].UserID != 2 {
+		t.Errorf("got second possible alt %d, want 2", report.PossibleAlts[1].UserID)
+	}
+}
+
+func TestServeAlts(t *testing.T) {
+	s, _, _ := newTestServer()
+	s.alts = fakeAltStore{userID: 1, signals: []AltSignal{{UserID: 1, OtherUserID: 2, Signal: altSignalName, Detail: "Hunter", Count: 1}}}
+
+	w := doRequest(s, http.MethodGet, "/alts/10", "", testToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	var report altReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if !report.Advisory || report.UserID != 1 || len(report.PossibleAlts) != 1 {
+		t.Errorf("got report %+v", report)
+	}
+
+	if w := doRequest(s, http.MethodGet, "/alts/404", "", testToken); w.Code != http.StatusNotFound {
+		t.Errorf("unknown character got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,39 @@
+package adminserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+type announceRequest struct {
+	Message string   `json:"message"` // "{online}" is replaced with the number of players online.
+	Worlds  []string `json:"worlds"`  // Names of the worlds to send it on, every world if empty.
+	Target  string   `json:"target"`  // Filter picking the characters to send it to, see package targeting.
+}
+
+// serveAnnounce sends a one-off announcement on the channels of the requested worlds.
+func (s *Server) serveAnnounce(w http.ResponseWriter, r *http.Request) {
+	var req announceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Message == "" {
+		http.Error(w, "invalid announcement", http.StatusBadRequest)
+		return
+	}
+	target, ok := parseTarget(w, req.Target)
+	if !ok {
+		return
+	}
+	channels := 0
+	for _, registry := range s.registries {
+		if registry.Announce(req.Message, req.Worlds, target) {
+			channels++
+		}
+	}
+	if channels == 0 {
+		http.Error(w, "no channel of those worlds is running", http.StatusNotFound)
+		return
+	}
+	s.logger.Info("Sent announcement through the admin API", zap.String("message", req.Message), zap.Strings("worlds", req.Worlds), zap.String("target", req.Target))
+	s.writeJSON(w, map[string]int{"channels": channels})
+}
@@ -0,0 +1,41 @@
+package adminserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestAnnounce(t *testing.T) {
+	s, registry, _ := newTestServer()
+	registry.world = "Newbie"
+	other := &fakeRegistry{world: "Normal"}
+	s.registries = append(s.registries, other)
+
+	w := doRequest(s, http.MethodPost, "/announce", `{"message": "{online} hunters online", "worlds": ["Newbie"]}`, testToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	var resp map[string]int
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp["channels"] != 1 {
+		t.Errorf("got response %v, %v, want one channel", resp, err)
+	}
+	if len(registry.broadcast) != 1 || registry.broadcast[0] != "{online} hunters online" || len(other.broadcast) != 0 {
+		t.Errorf("got announcements %v and %v, want it on the Newbie world only", registry.broadcast, other.broadcast)
+	}
+
+	doRequest(s, http.MethodPost, "/announce", `{"message": "Everyone"}`, testToken)
+	if len(registry.broadcast) != 2 || len(other.broadcast) != 1 {
+		t.Errorf("announcement without worlds wasn't sent on every channel")
+	}
+
+	if w := doRequest(s, http.MethodPost, "/announce", `{"message": "Hi", "worlds": ["Event"]}`, testToken); w.Code != http.StatusNotFound {
+		t.Errorf("unknown world got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := doRequest(s, http.MethodPost, "/announce", `{"message": "Hi", "target": "gr > 1; DROP TABLE users"}`, testToken); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid target got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := doRequest(s, http.MethodPost, "/announce", `{"worlds": ["Newbie"]}`, testToken); w.Code != http.StatusBadRequest {
+		t.Errorf("empty message got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
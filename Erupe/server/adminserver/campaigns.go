@@ -0,0 +1,73 @@
+package adminserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Solenataris/Erupe/server/channelserver"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// campaignStore creates the campaign codes players redeem in the campaign menu.
+type campaignStore interface {
+	CreateCampaignCode(code channelserver.CampaignCode) (string, error)
+}
+
+type dbCampaignStore struct {
+	db *sqlx.DB
+}
+
+func (c dbCampaignStore) CreateCampaignCode(code channelserver.CampaignCode) (string, error) {
+	return channelserver.CreateCampaignCode(c.db, code)
+}
+
+// Most codes a single request generates.
+const maxCampaignCodes = 1000
+
+type campaignRequest struct {
+	channelserver.CampaignCode
+	Count int `json:"count"` // Codes to generate with the same bundle, 1 if left out. Only without a code.
+}
+
+type campaignResponse struct {
+	Codes []string `json:"codes"`
+	channelserver.CampaignCode
+}
+
+// serveCreateCampaignCodes creates a code named in the request, such as "SUMMER2024", or generates count of them.
+func (s *Server) serveCreateCampaignCodes(w http.ResponseWriter, r *http.Request) {
+	var req campaignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Count < 0 || req.Count > maxCampaignCodes {
+		http.Error(w, "invalid campaign code request", http.StatusBadRequest)
+		return
+	}
+	if req.Count == 0 {
+		req.Count = 1
+	}
+	if req.Code != "" && req.Count > 1 {
+		http.Error(w, "a named code can't be generated more than once", http.StatusBadRequest)
+		return
+	}
+	if err := channelserver.ValidateCampaignCode(s.items, s.erupeConfig.ItemGrants.MaxQuantity, req.CampaignCode); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var codes []string
+	for i := 0; i < req.Count; i++ {
+		code, err := s.campaigns.CreateCampaignCode(req.CampaignCode)
+		if err == channelserver.ErrCampaignCodeExists {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			s.logger.Error("Failed to create campaign code", zap.Error(err), zap.Int("created", len(codes)))
+			http.Error(w, "failed to store campaign code", http.StatusInternalServerError)
+			return
+		}
+		codes = append(codes, code)
+	}
+	s.logger.Info("Created campaign codes", zap.Int("codes", len(codes)), zap.Int("items", len(req.Items)), zap.Int("maxUses", req.MaxUses))
+	s.writeJSON(w, campaignResponse{codes, req.CampaignCode})
+}
@@ -0,0 +1,70 @@
+package adminserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/server/channelserver"
+)
+
+type fakeCampaignStore struct {
+	created []channelserver.CampaignCode
+}
+
+func (f *fakeCampaignStore) CreateCampaignCode(code channelserver.CampaignCode) (string, error) {
+	f.created = append(f.created, code)
+	if code.Code == "TAKEN" {
+		return "", channelserver.ErrCampaignCodeExists
+	}
+	if code.Code != "" {
+		return code.Code, nil
+	}
+	return fmt.Sprintf("GENERATED%d", len(f.created)), nil
+}
+
+func TestServeCreateCampaignCodes(t *testing.T) {
+	s, _, _ := newTestServer()
+	s.erupeConfig.ItemGrants = config.ItemGrants{MaxQuantity: 99}
+	store := &fakeCampaignStore{}
+	s.campaigns = store
+
+	w := doRequest(s, http.MethodPost, "/campaign-codes", `{"code": "SUMMER2024", "items": [{"itemID": 1, "quantity": 5}], "maxUses": 500}`, testToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var resp campaignResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Codes) != 1 || resp.Codes[0] != "SUMMER2024" || store.created[0].MaxUses != 500 {
+		t.Errorf("got %+v stored as %+v", resp, store.created)
+	}
+
+	store.created = nil
+	w = doRequest(s, http.MethodPost, "/campaign-codes", `{"items": [{"itemID": 1, "quantity": 1}], "count": 3}`, testToken)
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || w.Code != http.StatusOK || len(resp.Codes) != 3 || len(store.created) != 3 {
+		t.Errorf("generating 3 codes got status %d and %+v", w.Code, resp)
+	}
+
+	store.created = nil
+	for _, body := range []string{
+		`{"code": "SUMMER 2024", "items": [{"itemID": 1, "quantity": 1}]}`,
+		`{"code": "SUMMER2024", "items": [{"itemID": 1, "quantity": 1}], "count": 2}`,
+		`{"items": []}`,
+		`{"items": [{"itemID": 1, "quantity": 100}]}`,
+		`{"items": [{"itemID": 1, "quantity": 1}], "startsAt": "2026-08-01T00:00:00Z", "endsAt": "2026-07-01T00:00:00Z"}`,
+	} {
+		if w := doRequest(s, http.MethodPost, "/campaign-codes", body, testToken); w.Code != http.StatusBadRequest {
+			t.Errorf("%s got status %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+	}
+	if len(store.created) != 0 {
+		t.Errorf("invalid requests stored %+v", store.created)
+	}
+	if w := doRequest(s, http.MethodPost, "/campaign-codes", `{"code": "TAKEN", "items": [{"itemID": 1, "quantity": 1}]}`, testToken); w.Code != http.StatusConflict {
+		t.Errorf("a taken code got status %d, want %d", w.Code, http.StatusConflict)
+	}
+}
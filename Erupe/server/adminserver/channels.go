@@ -0,0 +1,63 @@
+package adminserver
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Solenataris/Erupe/server/channelserver"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// channelStore lists and drains the channels registered in the server_channels table.
+type channelStore interface {
+	Channels() ([]channelserver.RegisteredChannel, error)
+	Drain(id int) (bool, error)
+}
+
+type dbChannelStore struct {
+	db *sqlx.DB
+}
+
+func (c dbChannelStore) Channels() ([]channelserver.RegisteredChannel, error) {
+	return channelserver.RegisteredChannels(c.db, 0)
+}
+
+func (c dbChannelStore) Drain(id int) (bool, error) {
+	return channelserver.DrainChannel(c.db, id)
+}
+
+// serveChannels lists the registered channels, with their last heartbeat so stale ones can be told apart.
+func (s *Server) serveChannels(w http.ResponseWriter, r *http.Request) {
+	channels, err := s.channels.Channels()
+	if err != nil {
+		s.logger.Error("Failed to list registered channels", zap.Error(err))
+		http.Error(w, "failed to list registered channels", http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, channels)
+}
+
+// serveDrainChannel asks a registered channel to empty. It's taken off the world list at once, then turns
+// new logins away and asks its players to move until it's empty and deregisters.
+func (s *Server) serveDrainChannel(w http.ResponseWriter, r *http.Request) {
+	// The route only matches digits, so this only fails on overflow.
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid channel ID", http.StatusBadRequest)
+		return
+	}
+	drained, err := s.channels.Drain(id)
+	if err != nil {
+		s.logger.Error("Failed to drain channel", zap.Int("id", id), zap.Error(err))
+		http.Error(w, "failed to drain channel", http.StatusInternalServerError)
+		return
+	}
+	if !drained {
+		http.Error(w, "channel not registered", http.StatusNotFound)
+		return
+	}
+	s.logger.Info("Draining channel through the admin API", zap.Int("id", id))
+	s.writeJSON(w, map[string]bool{"draining": true})
+}
@@ -0,0 +1,57 @@
+package adminserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/Solenataris/Erupe/server/channelserver"
+)
+
+type fakeChannelStore struct {
+	channels []channelserver.RegisteredChannel
+}
+
+func (f *fakeChannelStore) Channels() ([]channelserver.RegisteredChannel, error) {
+	return f.channels, nil
+}
+
+func (f *fakeChannelStore) Drain(id int) (bool, error) {
+	for i := range f.channels {
+		if f.channels[i].ID == id {
+			f.channels[i].Draining = true
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestServeChannels(t *testing.T) {
+	s, _, _ := newTestServer()
+	store := &fakeChannelStore{channels: []channelserver.RegisteredChannel{
+		{ID: 3, World: "Newbie", Address: "10.0.0.5", Port: 54001, MaxPlayers: 100, Players: 12},
+	}}
+	s.channels = store
+
+	w := doRequest(s, http.MethodGet, "/channels", "", testToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	var channels []channelserver.RegisteredChannel
+	if err := json.Unmarshal(w.Body.Bytes(), &channels); err != nil {
+		t.Fatal(err)
+	}
+	if len(channels) != 1 || channels[0].Address != "10.0.0.5" || channels[0].Players != 12 {
+		t.Errorf("got %+v", channels)
+	}
+
+	if w := doRequest(s, http.MethodPost, "/channels/3/drain", "", testToken); w.Code != http.StatusOK {
+		t.Fatalf("drain got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if !store.channels[0].Draining {
+		t.Error("channel wasn't asked to drain")
+	}
+	if w := doRequest(s, http.MethodPost, "/channels/4/drain", "", testToken); w.Code != http.StatusNotFound {
+		t.Errorf("draining an unknown channel got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
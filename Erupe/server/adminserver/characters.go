@@ -0,0 +1,40 @@
+package adminserver
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Solenataris/Erupe/server/signserver"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// characterStore restores deleted characters still within the recovery window.
+type characterStore interface {
+	Restore(charID uint32) (bool, error)
+}
+
+type dbCharacterStore struct {
+	db        *sqlx.DB
+	retention time.Duration
+}
+
+func (c dbCharacterStore) Restore(charID uint32) (bool, error) {
+	return signserver.RestoreCharacter(c.db, charID, c.retention)
+}
+
+func (s *Server) serveRestoreCharacter(w http.ResponseWriter, r *http.Request) {
+	charID := parseCharID(r)
+	restored, err := s.characters.Restore(charID)
+	if err != nil {
+		s.logger.Error("Failed to restore character", zap.Error(err), zap.Uint32("charID", charID))
+		http.Error(w, "failed to restore character", http.StatusInternalServerError)
+		return
+	}
+	if !restored {
+		http.Error(w, "character isn't deleted or is past the recovery window", http.StatusNotFound)
+		return
+	}
+	s.logger.Info("Restored deleted character", zap.Uint32("charID", charID))
+	s.writeJSON(w, map[string]bool{"restored": true})
+}
@@ -0,0 +1,28 @@
+package adminserver
+
+import (
+	"net/http"
+	"testing"
+)
+
+type fakeCharacterStore struct {
+	deleted map[uint32]bool
+}
+
+func (f *fakeCharacterStore) Restore(charID uint32) (bool, error) {
+	restored := f.deleted[charID]
+	delete(f.deleted, charID)
+	return restored, nil
+}
+
+func TestServeRestoreCharacter(t *testing.T) {
+	s, _, _ := newTestServer()
+	s.characters = &fakeCharacterStore{deleted: map[uint32]bool{7: true}}
+
+	if w := doRequest(s, http.MethodPost, "/characters/7/restore", "", testToken); w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := doRequest(s, http.MethodPost, "/characters/7/restore", "", testToken); w.Code != http.StatusNotFound {
+		t.Errorf("restoring twice got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
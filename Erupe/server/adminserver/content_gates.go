@@ -0,0 +1,96 @@
+package adminserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Solenataris/Erupe/server/channelserver"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// contentGateStore lists and takes the content gates opened early, see channelserver.OpenContentGate.
+type contentGateStore interface {
+	Overrides() ([]channelserver.ContentGateOverride, error)
+	Open(name string, openedBy string) error
+}
+
+type dbContentGateStore struct {
+	db *sqlx.DB
+}
+
+func (c dbContentGateStore) Overrides() ([]channelserver.ContentGateOverride, error) {
+	return channelserver.ContentGateOverrides(c.db)
+}
+
+func (c dbContentGateStore) Open(name string, openedBy string) error {
+	return channelserver.OpenContentGate(c.db, name, openedBy)
+}
+
+type contentGateStatus struct {
+	Name        string                             `json:"name"`
+	Opens       string                             `json:"opens"`
+	Open        bool                               `json:"open"`
+	OpenedEarly *channelserver.ContentGateOverride `json:"openedEarly,omitempty"`
+}
+
+// serveContentGates lists the content gates of the config, whether they're open and who opened them early.
+func (s *Server) serveContentGates(w http.ResponseWriter, r *http.Request) {
+	overrides, err := s.contentGates.Overrides()
+	if err != nil {
+		s.logger.Error("Failed to get content gate overrides", zap.Error(err))
+		http.Error(w, "failed to get content gate overrides", http.StatusInternalServerError)
+		return
+	}
+	opened := make(map[string]bool)
+	for _, override := range overrides {
+		opened[override.Name] = true
+	}
+	now := time.Now()
+	gates := []contentGateStatus{}
+	for _, gate := range s.erupeConfig.Current().ContentGates {
+		status := contentGateStatus{Name: gate.Name, Opens: gate.Opens, Open: channelserver.ContentGateOpen(gate, now, opened)}
+		for i := range overrides {
+			if overrides[i].Name == gate.Name {
+				status.OpenedEarly = &overrides[i]
+			}
+		}
+		gates = append(gates, status)
+	}
+	s.writeJSON(w, gates)
+}
+
+type openContentGateRequest struct {
+	Name     string `json:"name"`
+	IssuedBy string `json:"issuedBy"`
+}
+
+// serveOpenContentGate opens a content gate of the config before its date. The channels pick it up
+// within a minute and tell their players.
+func (s *Server) serveOpenContentGate(w http.ResponseWriter, r *http.Request) {
+	var req openContentGateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid content gate request", http.StatusBadRequest)
+		return
+	}
+	if req.IssuedBy == "" {
+		req.IssuedBy = "admin API"
+	}
+	known := false
+	for _, gate := range s.erupeConfig.Current().ContentGates {
+		known = known || gate.Name == req.Name
+	}
+	if !known {
+		http.Error(w, fmt.Sprintf("content gate %q isn't configured", req.Name), http.StatusNotFound)
+		return
+	}
+	if err := s.contentGates.Open(req.Name, req.IssuedBy); err != nil {
+		s.logger.Error("Failed to open content gate", zap.String("gate", req.Name), zap.Error(err))
+		http.Error(w, "failed to open content gate", http.StatusInternalServerError)
+		return
+	}
+	s.logger.Info("Opened content gate through the admin API", zap.String("gate", req.Name), zap.String("issuedBy", req.IssuedBy))
+	s.writeJSON(w, map[string]bool{"opened": true})
+}
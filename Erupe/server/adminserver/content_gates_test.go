@@ -0,0 +1,57 @@
+package adminserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/server/channelserver"
+)
+
+type fakeContentGateStore struct {
+	overrides []channelserver.ContentGateOverride
+}
+
+func (f *fakeContentGateStore) Overrides() ([]channelserver.ContentGateOverride, error) {
+	return f.overrides, nil
+}
+
+func (f *fakeContentGateStore) Open(name string, openedBy string) error {
+	f.overrides = append(f.overrides, channelserver.ContentGateOverride{Name: name, OpenedBy: openedBy, OpenedAt: time.Now()})
+	return nil
+}
+
+func TestServeContentGates(t *testing.T) {
+	s, _, _ := newTestServer()
+	store := &fakeContentGateStore{}
+	s.contentGates = store
+	s.erupeConfig.ContentGates = []config.ContentGate{
+		{Name: "G rank", Opens: time.Now().Add(24 * time.Hour).Format(time.RFC3339)},
+		{Name: "Festa", Opens: time.Now().Add(-time.Hour).Format(time.RFC3339)},
+	}
+
+	var gates []contentGateStatus
+	w := doRequest(s, http.MethodGet, "/content-gates", "", testToken)
+	if err := json.NewDecoder(w.Body).Decode(&gates); err != nil {
+		t.Fatal(err)
+	}
+	if len(gates) != 2 || gates[0].Open || !gates[1].Open {
+		t.Fatalf("got gates %+v", gates)
+	}
+
+	if w := doRequest(s, http.MethodPost, "/content-gates/open", `{"name": "Raviente"}`, testToken); w.Code != http.StatusNotFound {
+		t.Errorf("opening an unknown gate got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := doRequest(s, http.MethodPost, "/content-gates/open", `{"name": "G rank", "issuedBy": "gm"}`, testToken); w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	w = doRequest(s, http.MethodGet, "/content-gates", "", testToken)
+	if err := json.NewDecoder(w.Body).Decode(&gates); err != nil {
+		t.Fatal(err)
+	}
+	if !gates[0].Open || gates[0].OpenedEarly == nil || gates[0].OpenedEarly.OpenedBy != "gm" {
+		t.Errorf("got gate %+v after opening it early", gates[0])
+	}
+}
@@ -0,0 +1,248 @@
+package adminserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/server/channelserver"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// The audit log action of a decision only suggested, which doesn't count towards the next step.
+const actionEscalationSuggested = "escalation suggested"
+
+// escalationStore counts an account's earlier moderation actions of a category and takes new ones.
+type escalationStore interface {
+	PriorActions(charID uint32, category string, since time.Time) (int, error)
+	Suggest(entry auditEntry) error
+	Apply(charID uint32, step config.EscalationStep, expiresAt *time.Time, reason string, entry auditEntry) (uint32, error)
+}
+
+type dbEscalationStore struct {
+	db *sqlx.DB
+}
+
+// PriorActions counts the warnings, mutes and bans of the category taken since then against any
+// character of the account owning the character.
+func (e dbEscalationStore) PriorActions(charID uint32, category string, since time.Time) (int, error) {
+	var userID uint32
+	if err := e.db.QueryRow("SELECT user_id FROM characters WHERE id = $1", charID).Scan(&userID); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, errCharacterNotFound
+		}
+		return 0, err
+	}
+	var count int
+	err := e.db.QueryRow(`
+		SELECT COUNT(*) FROM admin_audit_log a JOIN characters c ON c.id = a.character_id
+		WHERE c.user_id = $1 AND a.category = $2 AND a.action IN ('warn', 'mute', 'ban') AND a.created_at >= $3
+	`, userID, category, since).Scan(&count)
+	return count, err
+}
+
+func (e dbEscalationStore) Suggest(entry auditEntry) error {
+	_, err := e.db.Exec("INSERT INTO admin_audit_log (character_id, action, detail, issued_by, category, rule) VALUES ($1, $2, $3, $4, $5, $6)",
+		entry.CharID, entry.Action, entry.Detail, entry.IssuedBy, entry.Category, entry.Rule)
+	return err
+}
+
+// Apply takes the step against the account owning the character and journals it, in one transaction,
+// returning the account. A warning is mailed to the character from the server, a mute reaches the
+// account's sessions everywhere, see channelserver.NotifyMute, and a ban revokes them like any other, see
+// channelserver.RevokeAccount.
+func (e dbEscalationStore) Apply(charID uint32, step config.EscalationStep, expiresAt *time.Time, reason string, entry auditEntry) (uint32, error) {
+	tx, err := e.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var userID uint32
+	if err := tx.QueryRow("SELECT user_id FROM characters WHERE id = $1", charID).Scan(&userID); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, errCharacterNotFound
+		}
+		return 0, err
+	}
+	switch step.Action {
+	case "warn":
+		err = channelserver.SendSystemMail(tx, charID, "Moderator warning", "You were warned by a moderator: "+reason+"\nFurther offenses are punished more heavily.")
+	case "mute":
+		_, err = tx.Exec("INSERT INTO mutes (user_id, reason, category, issued_by, expires_at) VALUES ($1, $2, $3, $4, $5)",
+			userID, reason, entry.Category, entry.IssuedBy, expiresAt)
+		if err == nil {
+			until := channelserver.MutedForever
+			if expiresAt != nil {
+				until = *expiresAt
+			}
+			err = channelserver.NotifyMute(tx, userID, until)
+		}
+	case "ban":
+		_, err = tx.Exec("INSERT INTO bans (user_id, reason, expires_at, issued_by, category) VALUES ($1, $2, $3, $4, $5)",
+			userID, reason, expiresAt, entry.IssuedBy, entry.Category)
+		if err == nil {
+			err = channelserver.RevokeAccount(tx, userID, channelserver.RevokeBanned)
+		}
+	default:
+		err = fmt.Errorf("unknown moderation action %q", step.Action)
+	}
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec("INSERT INTO admin_audit_log (character_id, action, detail, issued_by, category, rule) VALUES ($1, $2, $3, $4, $5, $6)",
+		entry.CharID, entry.Action, entry.Detail, entry.IssuedBy, entry.Category, entry.Rule)
+	if err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
+
+// describeStep names the step as the rules and decisions show it, such as "mute 24h" or "ban permanently".
+func describeStep(step config.EscalationStep) string {
+	switch {
+	case step.Action == "warn":
+		return "warn"
+	case step.Duration == "":
+		return step.Action + " permanently"
+	}
+	return step.Action + " " + step.Duration
+}
+
+// escalate returns the step of the ladder for an offense after prior earlier actions of its category, the
+// last step repeating once the ladder is climbed, and the rule that picked it.
+func escalate(ladder config.EscalationLadder, prior int) (config.EscalationStep, string) {
+	i := prior
+	if i >= len(ladder.Steps) {
+		i = len(ladder.Steps) - 1
+	}
+	step := ladder.Steps[i]
+	return step, fmt.Sprintf("%s step %d of %d after %d earlier: %s", ladder.Category, i+1, len(ladder.Steps), prior, describeStep(step))
+}
+
+type offenseRequest struct {
+	Category string `json:"category"`
+	Reason   string `json:"reason"`
+	Apply    bool   `json:"apply"` // Apply the suggested action, as Moderation.AutoApply does for every offense.
+	IssuedBy string `json:"issuedBy"`
+}
+
+type escalationDecision struct {
+	Category     string `json:"category"`
+	PriorActions int    `json:"priorActions"`
+	Action       string `json:"action"`
+	Duration     string `json:"duration"` // Empty for a warning or a permanent mute or ban.
+	Rule         string `json:"rule"`
+	Applied      bool   `json:"applied"`
+	Kicked       bool   `json:"kicked"`
+}
+
+// serveOffense decides the action for an offense of the character from the category's escalation
+// ladder and the account's earlier actions of it. The decision is only suggested, for a moderator to
+// apply by sending it again with "apply", unless Moderation.AutoApply is set. Both land in the audit log
+// with the rule that produced them.
+func (s *Server) serveOffense(w http.ResponseWriter, r *http.Request) {
+	charID := parseCharID(r)
+	var req offenseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid offense request", http.StatusBadRequest)
+		return
+	}
+	if req.IssuedBy == "" {
+		req.IssuedBy = "admin API"
+	}
+	cfg := s.erupeConfig.Current().Moderation
+	var ladder *config.EscalationLadder
+	for i := range cfg.Ladders {
+		if cfg.Ladders[i].Category == req.Category {
+			ladder = &cfg.Ladders[i]
+		}
+	}
+	if ladder == nil {
+		http.Error(w, fmt.Sprintf("offense category %q has no escalation ladder", req.Category), http.StatusBadRequest)
+		return
+	}
+
+	var since time.Time
+	if cfg.HistoryDays > 0 {
+		since = time.Now().AddDate(0, 0, -cfg.HistoryDays)
+	}
+	prior, err := s.escalation.PriorActions(charID, req.Category, since)
+	if err == errCharacterNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to get moderation history", zap.Error(err), zap.Uint32("charID", charID))
+		http.Error(w, "failed to get moderation history", http.StatusInternalServerError)
+		return
+	}
+	step, rule := escalate(*ladder, prior)
+	decision := escalationDecision{
+		Category:     req.Category,
+		PriorActions: prior,
+		Action:       step.Action,
+		Duration:     step.Duration,
+		Rule:         rule,
+		Applied:      req.Apply || cfg.AutoApply,
+	}
+	entry := auditEntry{
+		CharID:   charID,
+		Action:   step.Action,
+		Detail:   describeStep(step) + ": " + req.Reason,
+		IssuedBy: req.IssuedBy,
+		Category: req.Category,
+		Rule:     rule,
+	}
+
+	if !decision.Applied {
+		entry.Action = actionEscalationSuggested
+		if err := s.escalation.Suggest(entry); err != nil {
+			s.logger.Error("Failed to journal moderation suggestion", zap.Error(err), zap.Uint32("charID", charID))
+			http.Error(w, "failed to journal suggestion", http.StatusInternalServerError)
+			return
+		}
+		s.writeJSON(w, decision)
+		return
+	}
+
+	var expiresAt *time.Time
+	if step.Duration != "" {
+		// Validated with the config.
+		duration, _ := time.ParseDuration(step.Duration)
+		expires := time.Now().Add(duration)
+		expiresAt = &expires
+	}
+	userID, err := s.escalation.Apply(charID, step, expiresAt, req.Reason, entry)
+	if err == errCharacterNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to apply moderation action", zap.Error(err), zap.Uint32("charID", charID))
+		http.Error(w, "failed to apply moderation action", http.StatusInternalServerError)
+		return
+	}
+	s.logger.Info("Applied moderation action",
+		zap.Uint32("charID", charID),
+		zap.String("rule", rule),
+		zap.String("reason", req.Reason),
+		zap.String("issuedBy", req.IssuedBy),
+	)
+	switch step.Action {
+	case "mute":
+		until := channelserver.MutedForever
+		if expiresAt != nil {
+			until = *expiresAt
+		}
+		for _, registry := range s.registries {
+			registry.MuteSessions(userID, until)
+		}
+	case "ban":
+		decision.Kicked = s.revoke(userID, channelserver.RevokeBanned)
+	}
+	s.writeJSON(w, decision)
+}
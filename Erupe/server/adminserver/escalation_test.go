@@ -0,0 +1,138 @@
+package adminserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+)
+
+var chatAbuse = config.EscalationLadder{Category: "chat-abuse", Steps: []config.EscalationStep{
+	{Action: "warn"},
+	{Action: "mute", Duration: "24h"},
+	{Action: "ban", Duration: "72h"},
+	{Action: "ban"},
+}}
+
+func TestEscalate(t *testing.T) {
+	want := []string{"warn", "mute 24h", "ban 72h", "ban permanently", "ban permanently"}
+	for prior, describe := range want {
+		step, rule := escalate(chatAbuse, prior)
+		if got := describeStep(step); got != describe {
+			t.Errorf("after %d earlier got %q, want %q", prior, got, describe)
+		}
+		if rule == "" {
+			t.Errorf("after %d earlier the decision has no rule", prior)
+		}
+	}
+	if _, rule := escalate(chatAbuse, 1); rule != "chat-abuse step 2 of 4 after 1 earlier: mute 24h" {
+		t.Errorf("got rule %q", rule)
+	}
+}
+
+// fakeEscalationStore journals the decisions, counting the applied ones as the account's history.
+type fakeEscalationStore struct {
+	journal []auditEntry
+	applied []config.EscalationStep
+	expires []*time.Time
+}
+
+func (f *fakeEscalationStore) PriorActions(charID uint32, category string, since time.Time) (int, error) {
+	if charID == 404 {
+		return 0, errCharacterNotFound
+	}
+	count := 0
+	for _, entry := range f.journal {
+		if entry.CharID == charID && entry.Category == category && entry.Action != actionEscalationSuggested {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (f *fakeEscalationStore) Suggest(entry auditEntry) error {
+	f.journal = append(f.journal, entry)
+	return nil
+}
+
+func (f *fakeEscalationStore) Apply(charID uint32, step config.EscalationStep, expiresAt *time.Time, reason string, entry auditEntry) (uint32, error) {
+	f.journal = append(f.journal, entry)
+	f.applied = append(f.applied, step)
+	f.expires = append(f.expires, expiresAt)
+	return charID, nil
+}
+
+func offense(t *testing.T, s *Server, body string) escalationDecision {
+	t.Helper()
+	w := doRequest(s, http.MethodPost, "/characters/1/offenses", body, testToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	var decision escalationDecision
+	if err := json.NewDecoder(w.Body).Decode(&decision); err != nil {
+		t.Fatal(err)
+	}
+	return decision
+}
+
+func TestServeOffenseIsAdvisory(t *testing.T) {
+	s, registry, _ := newTestServer()
+	s.erupeConfig.Moderation = config.Moderation{Ladders: []config.EscalationLadder{chatAbuse}}
+	store := &fakeEscalationStore{}
+	s.escalation = store
+
+	for i := 0; i < 2; i++ {
+		decision := offense(t, s, `{"category": "chat-abuse", "reason": "spam"}`)
+		if decision.Applied || decision.Action != "warn" {
+			t.Errorf("suggestion %d: got %+v, want an unapplied warning", i+1, decision)
+		}
+	}
+	if len(store.applied) != 0 || len(registry.muted) != 0 {
+		t.Fatal("a suggestion was applied")
+	}
+	if len(store.journal) != 2 || store.journal[0].Rule == "" || store.journal[0].Category != "chat-abuse" {
+		t.Errorf("suggestions weren't journaled with their rule: %+v", store.journal)
+	}
+
+	if w := doRequest(s, http.MethodPost, "/characters/1/offenses", `{"category": "botting"}`, testToken); w.Code != http.StatusBadRequest {
+		t.Errorf("an unknown category got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := doRequest(s, http.MethodPost, "/characters/404/offenses", `{"category": "chat-abuse"}`, testToken); w.Code != http.StatusNotFound {
+		t.Errorf("a missing character got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestServeOffenseEscalates(t *testing.T) {
+	s, registry, _ := newTestServer()
+	s.erupeConfig.Moderation = config.Moderation{AutoApply: true, Ladders: []config.EscalationLadder{chatAbuse}}
+	store := &fakeEscalationStore{}
+	s.escalation = store
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		decision := offense(t, s, `{"category": "chat-abuse", "reason": "spam", "issuedBy": "gm"}`)
+		if !decision.Applied || decision.PriorActions != i {
+			t.Fatalf("offense %d: got %+v, want applied after %d earlier", i+1, decision, i)
+		}
+		got = append(got, describeStep(store.applied[i]))
+	}
+	want := []string{"warn", "mute 24h", "ban 72h", "ban permanently"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("offense %d: applied %q, want %q", i+1, got[i], want[i])
+		}
+	}
+	if len(registry.muted) != 1 || time.Until(registry.muted[0]) < 23*time.Hour {
+		t.Errorf("the mute reached the sessions as %v, want for a day", registry.muted)
+	}
+	if store.expires[3] != nil {
+		t.Error("the permanent ban expires")
+	}
+	for _, entry := range store.journal {
+		if entry.Rule == "" || entry.IssuedBy != "gm" {
+			t.Errorf("action journaled without its rule or moderator: %+v", entry)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package adminserver
+
+import (
+	"net/http"
+
+	"github.com/Solenataris/Erupe/server/channelserver"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// gachaStore reads the rates of the gacha banners, see channelserver.GachaRates.
+type gachaStore interface {
+	Rates() ([]channelserver.GachaBannerRates, error)
+}
+
+type dbGachaStore struct {
+	db *sqlx.DB
+}
+
+func (g dbGachaStore) Rates() ([]channelserver.GachaBannerRates, error) {
+	return channelserver.GachaRates(g.db)
+}
+
+// serveGachaRates lists every banner's prizes with the chance their weights give and how often they
+// were drawn, for publishing the rates and checking the draws keep to them.
+func (s *Server) serveGachaRates(w http.ResponseWriter, r *http.Request) {
+	banners, err := s.gacha.Rates()
+	if err != nil {
+		s.logger.Error("Failed to get gacha rates", zap.Error(err))
+		http.Error(w, "failed to get gacha rates", http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, banners)
+}
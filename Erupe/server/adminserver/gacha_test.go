@@ -0,0 +1,38 @@
+package adminserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/Solenataris/Erupe/server/channelserver"
+)
+
+type fakeGachaStore struct {
+	banners []channelserver.GachaBannerRates
+}
+
+func (f *fakeGachaStore) Rates() ([]channelserver.GachaBannerRates, error) {
+	return f.banners, nil
+}
+
+func TestServeGachaRates(t *testing.T) {
+	s, _, _ := newTestServer()
+	s.gacha = &fakeGachaStore{banners: []channelserver.GachaBannerRates{{
+		Hash:    7,
+		Draws:   4,
+		Entries: []channelserver.GachaEntryRate{{ItemHash: 70, Weight: 1, Rate: 1, Drawn: 4, Observed: 1}},
+	}}}
+
+	w := doRequest(s, http.MethodGet, "/gacha/rates", "", testToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	var banners []channelserver.GachaBannerRates
+	if err := json.NewDecoder(w.Body).Decode(&banners); err != nil {
+		t.Fatal(err)
+	}
+	if len(banners) != 1 || banners[0].Hash != 7 || banners[0].Entries[0].Drawn != 4 {
+		t.Errorf("got rates %+v", banners)
+	}
+}
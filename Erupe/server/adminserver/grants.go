@@ -0,0 +1,126 @@
+package adminserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Solenataris/Erupe/server/channelserver"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// grantStore queues item distributions for characters, journaling each one.
+type grantStore interface {
+	GrantItem(grant channelserver.ItemGrant) error
+	GrantItems(grants []channelserver.ItemGrant) (int, error) // All or none, skipping missing characters.
+}
+
+type dbGrantStore struct {
+	db *sqlx.DB
+}
+
+func (g dbGrantStore) GrantItem(grant channelserver.ItemGrant) error {
+	err := channelserver.GrantItem(g.db, grant)
+	if err == channelserver.ErrCharacterNotFound {
+		return errCharacterNotFound
+	}
+	return err
+}
+
+func (g dbGrantStore) GrantItems(grants []channelserver.ItemGrant) (int, error) {
+	return channelserver.GrantItems(g.db, grants)
+}
+
+type grantRequest struct {
+	ItemID   int    `json:"itemID"`
+	Quantity int    `json:"quantity"`
+	IssuedBy string `json:"issuedBy"`
+}
+
+// serveGrantItem sends the character an item it collects from the distribution counter. Unlike item box
+// edits it works while the character is online, the distribution is only read once it's claimed.
+func (s *Server) serveGrantItem(w http.ResponseWriter, r *http.Request) {
+	charID := parseCharID(r)
+	var req grantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid grant request", http.StatusBadRequest)
+		return
+	}
+	if err := channelserver.ValidateItemGrant(s.items, s.erupeConfig.ItemGrants.MaxQuantity, req.ItemID, req.Quantity); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.IssuedBy == "" {
+		req.IssuedBy = "admin API"
+	}
+
+	grant := channelserver.ItemGrant{CharID: charID, ItemID: uint16(req.ItemID), Quantity: uint16(req.Quantity), IssuedBy: req.IssuedBy}
+	err := s.grants.GrantItem(grant)
+	if err == errCharacterNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to give item", zap.Error(err), zap.Uint32("charID", charID))
+		http.Error(w, "failed to give item", http.StatusInternalServerError)
+		return
+	}
+	s.logger.Info("Gave item",
+		zap.Uint32("charID", charID),
+		zap.Int("itemID", req.ItemID),
+		zap.Int("quantity", req.Quantity),
+		zap.String("issuedBy", req.IssuedBy),
+	)
+	s.writeJSON(w, map[string]bool{"granted": true})
+}
+
+type targetedGrantRequest struct {
+	grantRequest
+	Target string `json:"target"` // Filter picking the characters, see package targeting.
+}
+
+// serveGrantTargeted sends the item to every character the filter picks, each a grant of its own, in a
+// single transaction. Characters deleted since the filter picked them are skipped.
+func (s *Server) serveGrantTargeted(w http.ResponseWriter, r *http.Request) {
+	var req targetedGrantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Target == "" {
+		http.Error(w, "invalid grant request", http.StatusBadRequest)
+		return
+	}
+	target, ok := parseTarget(w, req.Target)
+	if !ok {
+		return
+	}
+	if err := channelserver.ValidateItemGrant(s.items, s.erupeConfig.ItemGrants.MaxQuantity, req.ItemID, req.Quantity); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.IssuedBy == "" {
+		req.IssuedBy = "admin API"
+	}
+
+	charIDs, err := s.targets.CharacterIDs(target)
+	if err != nil {
+		s.logger.Error("Failed to find targeted characters", zap.String("target", req.Target), zap.Error(err))
+		http.Error(w, "failed to find characters", http.StatusInternalServerError)
+		return
+	}
+	grants := make([]channelserver.ItemGrant, len(charIDs))
+	for i, charID := range charIDs {
+		grants[i] = channelserver.ItemGrant{CharID: charID, ItemID: uint16(req.ItemID), Quantity: uint16(req.Quantity), IssuedBy: req.IssuedBy}
+	}
+	granted, err := s.grants.GrantItems(grants)
+	if err != nil {
+		s.logger.Error("Failed to give item to targeted characters", zap.Error(err), zap.String("target", req.Target))
+		http.Error(w, "failed to give item", http.StatusInternalServerError)
+		return
+	}
+	s.logger.Info("Gave item to targeted characters",
+		zap.String("target", req.Target),
+		zap.Int("characters", granted),
+		zap.Int("itemID", req.ItemID),
+		zap.Int("quantity", req.Quantity),
+		zap.String("issuedBy", req.IssuedBy),
+	)
+	s.writeJSON(w, map[string]int{"characters": granted})
+}
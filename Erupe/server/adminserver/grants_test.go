@@ -0,0 +1,95 @@
+package adminserver
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Solenataris/Erupe/common/itemtable"
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/server/channelserver"
+)
+
+// fakeGrantStore has no character 404, and fails granting to character 500.
+type fakeGrantStore struct {
+	grants []channelserver.ItemGrant
+}
+
+func (f *fakeGrantStore) GrantItem(grant channelserver.ItemGrant) error {
+	granted, err := f.GrantItems([]channelserver.ItemGrant{grant})
+	if err == nil && granted == 0 {
+		return errCharacterNotFound
+	}
+	return err
+}
+
+func (f *fakeGrantStore) GrantItems(grants []channelserver.ItemGrant) (int, error) {
+	var sent []channelserver.ItemGrant
+	for _, grant := range grants {
+		switch grant.CharID {
+		case 404:
+			continue
+		case 500:
+			return 0, errors.New("grant failed")
+		}
+		sent = append(sent, grant)
+	}
+	f.grants = append(f.grants, sent...)
+	return len(sent), nil
+}
+
+func newTestGrants(t *testing.T, s *Server) *fakeGrantStore {
+	items, err := itemtable.Parse(strings.NewReader("1,Potion\n1234,Armor Sphere\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	grants := &fakeGrantStore{}
+	s.grants = grants
+	s.items = items
+	s.erupeConfig.ItemGrants = config.ItemGrants{MaxQuantity: 99}
+	return grants
+}
+
+func TestGrantItem(t *testing.T) {
+	s, _, _ := newTestServer()
+	grants := newTestGrants(t, s)
+
+	// Online characters can be given items, they're claimed from the distribution counter.
+	w := doRequest(s, http.MethodPost, "/characters/1/grant", `{"itemID":1234,"quantity":5,"issuedBy":"gm"}`, testToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	want := channelserver.ItemGrant{CharID: 1, ItemID: 1234, Quantity: 5, IssuedBy: "gm"}
+	if len(grants.grants) != 1 || grants.grants[0] != want {
+		t.Errorf("got grants %v, want %v", grants.grants, want)
+	}
+
+	doRequest(s, http.MethodPost, "/characters/10/grant", `{"itemID":1,"quantity":1}`, testToken)
+	if len(grants.grants) != 2 || grants.grants[1].IssuedBy != "admin API" {
+		t.Errorf("got grants %v, want one issued by the admin API", grants.grants)
+	}
+}
+
+func TestGrantItemValidation(t *testing.T) {
+	s, _, _ := newTestServer()
+	grants := newTestGrants(t, s)
+
+	for _, body := range []string{
+		`{"itemID":2,"quantity":1}`,   // Not in the item table.
+		`{"itemID":1,"quantity":0}`,   // No quantity.
+		`{"itemID":1,"quantity":100}`, // Over the limit.
+		`{"itemID":-1,"quantity":1}`,  // Not an item ID.
+		`{"itemID":1,"quantity":"1"}`, // Not a number.
+	} {
+		if w := doRequest(s, http.MethodPost, "/characters/1/grant", body, testToken); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+	}
+	if w := doRequest(s, http.MethodPost, "/characters/404/grant", `{"itemID":1,"quantity":1}`, testToken); w.Code != http.StatusNotFound {
+		t.Errorf("got status %d for a missing character, want %d", w.Code, http.StatusNotFound)
+	}
+	if len(grants.grants) != 0 {
+		t.Errorf("invalid requests were granted: %v", grants.grants)
+	}
+}
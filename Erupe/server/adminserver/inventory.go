@@ -0,0 +1,540 @@
+package adminserver
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/Solenataris/Erupe/server/channelserver"
+	"github.com/Solenataris/Erupe/server/channelserver/compression/nullcomp"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// invalidEdit is an inventory edit refused for what it asks.
+type invalidEdit string
+
+func (e invalidEdit) Error() string { return string(e) }
+
+const (
+	errItemNotInBox     = invalidEdit("item isn't in the box")
+	errStackOverflow    = invalidEdit("stack would overflow")
+	errSavedataTooShort = invalidEdit("savedata too short for the configured offset")
+)
+
+var errNoSavedata = errors.New("the character has no savedata")
+
+// auditEntry is a change made through the admin API, journaled against the character it was made for.
+type auditEntry struct {
+	CharID   uint32
+	Action   string
+	Detail   string
+	IssuedBy string
+	Category string // Offense category of a moderation action, see escalation.go.
+	Rule     string // Escalation ladder step that decided a moderation action.
+}
+
+// inventoryStore reads and edits the shared item box of the account owning a character and the
+// character's savedata. The edits are refused with channelserver.ErrAccountOnline while a character of the
+// account is logged in: the channel holds the box and the client the savedata, and would save them over.
+type inventoryStore interface {
+	ItemBox(charID uint32) ([]channelserver.Item, error)
+	EditItemBox(charID uint32, edit func([]channelserver.Item) ([]channelserver.Item, error), entry auditEntry) error
+	Savedata(charID uint32) ([]byte, error)
+	EditSavedata(charID uint32, edit func(data []byte) (string, error), entry auditEntry) error
+}
+
+type dbInventoryStore struct {
+	db *sqlx.DB
+}
+
+func (i dbInventoryStore) ItemBox(charID uint32) ([]channelserver.Item, error) {
+	var data []byte
+	err := i.db.QueryRow("SELECT item_box FROM users, characters WHERE characters.id = $1 AND users.id = characters.user_id", charID).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, errCharacterNotFound
+	}
+	return channelserver.DecodeItemBox(data), err
+}
+
+// checkOffline locks the account's characters for the rest of the transaction and checks them offline.
+func checkOffline(tx *sql.Tx, charID uint32) error {
+	err := channelserver.CheckAccountOffline(tx, charID)
+	if err == channelserver.ErrCharacterNotFound {
+		return errCharacterNotFound
+	}
+	return err
+}
+
+// EditItemBox checks the account offline, edits its item box and journals the edit in one transaction.
+func (i dbInventoryStore) EditItemBox(charID uint32, edit func([]channelserver.Item) ([]channelserver.Item, error), entry auditEntry) error {
+	tx, err := i.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := checkOffline(tx, charID); err != nil {
+		return err
+	}
+	var data []byte
+	err = tx.QueryRow("SELECT item_box FROM users, characters WHERE characters.id = $1 AND users.id = characters.user_id FOR UPDATE OF users", charID).Scan(&data)
+	if err != nil {
+		return err
+	}
+	items, err := edit(channelserver.DecodeItemBox(data))
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE users SET item_box = $1 FROM characters WHERE users.id = characters.user_id AND characters.id = $2", channelserver.EncodeItemBox(items), charID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO admin_audit_log (character_id, action, detail, issued_by) VALUES ($1, $2, $3, $4)", entry.CharID, entry.Action, entry.Detail, entry.IssuedBy)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// loadSavedata returns the character's savedata decompressed, with the diffs of its saves applied.
+func loadSavedata(tx *sql.Tx, charID uint32) ([]byte, error) {
+	if _, err := channelserver.ConsolidateSavedata(tx, charID); err == sql.ErrNoRows {
+		return nil, errCharacterNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	var compressed []byte
+	if err := tx.QueryRow("SELECT savedata FROM characters WHERE id = $1", charID).Scan(&compressed); err != nil {
+		return nil, err
+	}
+	if compressed == nil {
+		return nil, errNoSavedata
+	}
+	return nullcomp.Decompress(compressed)
+}
+
+func (i dbInventoryStore) Savedata(charID uint32) ([]byte, error) {
+	tx, err := i.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	data, err := loadSavedata(tx, charID)
+	if err != nil {
+		return nil, err
+	}
+	return data, tx.Commit()
+}
+
+// EditSavedata checks the account offline, edits the character's savedata in place and journals the edit,
+// with the detail the edit returns, in one transaction. The savedata replaced is kept as an 'admin edit'
+// backup, restored like any other.
+func (i dbInventoryStore) EditSavedata(charID uint32, edit func(data []byte) (string, error), entry auditEntry) error {
+	tx, err := i.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := checkOffline(tx, charID); err != nil {
+		return err
+	}
+	data, err := loadSavedata(tx, charID)
+	if err != nil {
+		return err
+	}
+	if entry.Detail, err = edit(data); err != nil {
+		return err
+	}
+	compressed, err := nullcomp.Compress(data)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO savedata_backups (char_id, savedata, kind) SELECT id, savedata, 'admin edit' FROM characters WHERE id = $1", charID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE characters SET savedata = $1 WHERE id = $2", compressed, charID); err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO admin_audit_log (character_id, action, detail, issued_by) VALUES ($1, $2, $3, $4)", entry.CharID, entry.Action, entry.Detail, entry.IssuedBy)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type inventoryItem struct {
+	ItemID uint16 `json:"itemID"`
+	Amount uint16 `json:"amount"`
+}
+
+type inventoryRequest struct {
+	ItemID   uint16 `json:"itemID"`
+	Amount   int    `json:"amount"` // Removing 0 takes the whole stack.
+	IssuedBy string `json:"issuedBy"`
+}
+
+// addItem adds amount to the item's stack, starting a new stack if the box has none.
+func addItem(items []channelserver.Item, itemID uint16, amount int) ([]channelserver.Item, error) {
+	for i := range items {
+		if items[i].ItemId == itemID {
+			if int(items[i].Amount)+amount > math.MaxUint16 {
+				return nil, errStackOverflow
+			}
+			items[i].Amount += uint16(amount)
+			return items, nil
+		}
+	}
+	if amount > math.MaxUint16 {
+		return nil, errStackOverflow
+	}
+	return append(items, channelserver.Item{ItemId: itemID, Amount: uint16(amount)}), nil
+}
+
+// removeItem takes amount off the item's stack, dropping the stack once it's empty.
+func removeItem(items []channelserver.Item, itemID uint16, amount int) ([]channelserver.Item, error) {
+	for i := range items {
+		if items[i].ItemId != itemID {
+			continue
+		}
+		if amount == 0 || amount >= int(items[i].Amount) {
+			return append(items[:i], items[i+1:]...), nil
+		}
+		items[i].Amount -= uint16(amount)
+		return items, nil
+	}
+	return nil, errItemNotInBox
+}
+
+// writeInventoryError answers a failed inventory read or edit.
+func (s *Server) writeInventoryError(w http.ResponseWriter, err error, charID uint32, failure string) {
+	var invalid invalidEdit
+	switch {
+	case err == errCharacterNotFound || err == errNoSavedata:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err == channelserver.ErrAccountOnline:
+		http.Error(w, "the account is online, edit once it has logged out", http.StatusConflict)
+	case errors.As(err, &invalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		s.logger.Error("Failed to "+failure, zap.Error(err), zap.Uint32("charID", charID))
+		http.Error(w, "failed to "+failure, http.StatusInternalServerError)
+	}
+}
+
+// logInventoryEdit logs an edit journaled by the store.
+func (s *Server) logInventoryEdit(entry auditEntry) {
+	s.logger.Info("Edited inventory",
+		zap.Uint32("charID", entry.CharID),
+		zap.String("action", entry.Action),
+		zap.String("detail", entry.Detail),
+		zap.String("issuedBy", entry.IssuedBy),
+	)
+}
+
+func (s *Server) serveItemBox(w http.ResponseWriter, r *http.Request) {
+	charID := parseCharID(r)
+	items, err := s.inventory.ItemBox(charID)
+	if err != nil {
+		s.writeInventoryError(w, err, charID, "get item box")
+		return
+	}
+	list := make([]inventoryItem, 0, len(items))
+	for _, item := range items {
+		list = append(list, inventoryItem{item.ItemId, item.Amount})
+	}
+	s.writeJSON(w, list)
+}
+
+func (s *Server) serveAddItem(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeInventoryRequest(r)
+	if !ok || req.Amount == 0 {
+		http.Error(w, "invalid item request", http.StatusBadRequest)
+		return
+	}
+	s.editItemBox(w, parseCharID(r), "add item", req, addItem)
+}
+
+func (s *Server) serveRemoveItem(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeInventoryRequest(r)
+	if !ok {
+		http.Error(w, "invalid item request", http.StatusBadRequest)
+		return
+	}
+	s.editItemBox(w, parseCharID(r), "remove item", req, removeItem)
+}
+
+func decodeInventoryRequest(r *http.Request) (inventoryRequest, bool) {
+	var req inventoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Amount < 0 {
+		return req, false
+	}
+	if req.IssuedBy == "" {
+		req.IssuedBy = "admin API"
+	}
+	return req, true
+}
+
+// editItemBox applies the edit to the character's item box and journals it, refusing while the account is online.
+func (s *Server) editItemBox(w http.ResponseWriter, charID uint32, action string, req inventoryRequest, edit func([]channelserver.Item, uint16, int) ([]channelserver.Item, error)) {
+	entry := auditEntry{
+		CharID:   charID,
+		Action:   action,
+		Detail:   fmt.Sprintf("item %d, amount %d", req.ItemID, req.Amount),
+		IssuedBy: req.IssuedBy,
+	}
+	err := s.inventory.EditItemBox(charID, func(items []channelserver.Item) ([]channelserver.Item, error) {
+		return edit(items, req.ItemID, req.Amount)
+	}, entry)
+	if err != nil {
+		s.writeInventoryError(w, err, charID, "update item box")
+		return
+	}
+	s.logInventoryEdit(entry)
+	s.writeJSON(w, map[string]bool{"updated": true})
+}
+
+type zennyRequest struct {
+	Delta    int64  `json:"delta"`
+	IssuedBy string `json:"issuedBy"`
+}
+
+// readZenny returns the zenny held in the savedata at the offset.
+func readZenny(data []byte, offset int) (uint32, error) {
+	if len(data) < offset+4 {
+		return 0, errSavedataTooShort
+	}
+	return binary.LittleEndian.Uint32(data[offset:]), nil
+}
+
+func (s *Server) serveZenny(w http.ResponseWriter, r *http.Request) {
+	charID := parseCharID(r)
+	offset := s.erupeConfig.Admin.ZennyOffset
+	if offset == 0 {
+		http.Error(w, "the zenny offset isn't configured", http.StatusNotFound)
+		return
+	}
+	data, err := s.inventory.Savedata(charID)
+	var zenny uint32
+	if err == nil {
+		zenny, err = readZenny(data, offset)
+	}
+	if err != nil {
+		s.writeInventoryError(w, err, charID, "get zenny")
+		return
+	}
+	s.writeJSON(w, map[string]uint32{"zenny": zenny})
+}
+
+// serveAdjustZenny adds the delta to the character's zenny, refusing a balance past the range of its field.
+func (s *Server) serveAdjustZenny(w http.ResponseWriter, r *http.Request) {
+	charID := parseCharID(r)
+	offset := s.erupeConfig.Admin.ZennyOffset
+	if offset == 0 {
+		http.Error(w, "the zenny offset isn't configured", http.StatusNotFound)
+		return
+	}
+	var req zennyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Delta == 0 {
+		http.Error(w, "invalid zenny request", http.StatusBadRequest)
+		return
+	}
+	if req.IssuedBy == "" {
+		req.IssuedBy = "admin API"
+	}
+	entry := auditEntry{
+		CharID:   charID,
+		Action:   "adjust zenny",
+		IssuedBy: req.IssuedBy,
+	}
+	var zenny int64
+	err := s.inventory.EditSavedata(charID, func(data []byte) (string, error) {
+		held, err := readZenny(data, offset)
+		if err != nil {
+			return "", err
+		}
+		zenny = int64(held) + req.Delta
+		if zenny < 0 || zenny > math.MaxUint32 {
+			return "", invalidEdit("zenny would go out of range")
+		}
+		binary.LittleEndian.PutUint32(data[offset:], uint32(zenny))
+		entry.Detail = fmt.Sprintf("zenny %+d, %d to %d", req.Delta, held, zenny)
+		return entry.Detail, nil
+	}, entry)
+	if err != nil {
+		s.writeInventoryError(w, err, charID, "update zenny")
+		return
+	}
+	s.logInventoryEdit(entry)
+	s.writeJSON(w, map[string]int64{"zenny": zenny})
+}
+
+type equipBoxSlot struct {
+	Slot int    `json:"slot"`
+	Data string `json:"data"` // Slot bytes in hex, as the savedata holds them.
+}
+
+type equipBoxRequest struct {
+	Slot     int    `json:"slot"` // Slot to remove.
+	Data     string `json:"data"` // Slot bytes in hex to add in the first empty slot.
+	IssuedBy string `json:"issuedBy"`
+}
+
+// equipBox locates the equipment box in a savedata, the configured layout of its slots.
+type equipBox struct {
+	offset, slots, entrySize int
+}
+
+func (s *Server) equipBox() (equipBox, bool) {
+	admin := s.erupeConfig.Admin
+	box := equipBox{admin.EquipBoxOffset, admin.EquipBoxSlots, admin.EquipBoxEntrySize}
+	return box, box.offset > 0 && box.slots > 0 && box.entrySize > 0
+}
+
+// slot returns the bytes of the slot in the savedata, which are edited in place.
+func (b equipBox) slot(data []byte, slot int) ([]byte, error) {
+	if len(data) < b.offset+b.slots*b.entrySize {
+		return nil, errSavedataTooShort
+	}
+	if slot < 0 || slot >= b.slots {
+		return nil, invalidEdit("no such equipment box slot")
+	}
+	start := b.offset + slot*b.entrySize
+	return data[start : start+b.entrySize], nil
+}
+
+func emptySlot(entry []byte) bool {
+	for _, b := range entry {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// serveEquipBox lists the character's equipment box slots that aren't empty. No capture confirms the layout
+// of a slot, so they're listed as the savedata holds them.
+func (s *Server) serveEquipBox(w http.ResponseWriter, r *http.Request) {
+	charID := parseCharID(r)
+	box, ok := s.equipBox()
+	if !ok {
+		http.Error(w, "the equipment box layout isn't configured", http.StatusNotFound)
+		return
+	}
+	data, err := s.inventory.Savedata(charID)
+	if err != nil {
+		s.writeInventoryError(w, err, charID, "get equipment box")
+		return
+	}
+	list := []equipBoxSlot{}
+	for i := 0; i < box.slots; i++ {
+		entry, err := box.slot(data, i)
+		if err != nil {
+			s.writeInventoryError(w, err, charID, "get equipment box")
+			return
+		}
+		if !emptySlot(entry) {
+			list = append(list, equipBoxSlot{i, hex.EncodeToString(entry)})
+		}
+	}
+	s.writeJSON(w, list)
+}
+
+// serveAddEquipment writes the slot bytes in the first empty slot of the character's equipment box.
+func (s *Server) serveAddEquipment(w http.ResponseWriter, r *http.Request) {
+	charID := parseCharID(r)
+	box, ok := s.equipBox()
+	if !ok {
+		http.Error(w, "the equipment box layout isn't configured", http.StatusNotFound)
+		return
+	}
+	req, ok := decodeEquipBoxRequest(r)
+	entry, err := hex.DecodeString(req.Data)
+	if !ok || err != nil || len(entry) != box.entrySize || emptySlot(entry) {
+		http.Error(w, "invalid equipment request", http.StatusBadRequest)
+		return
+	}
+	audit := auditEntry{
+		CharID:   charID,
+		Action:   "add equipment",
+		IssuedBy: req.IssuedBy,
+	}
+	slot := -1
+	err = s.inventory.EditSavedata(charID, func(data []byte) (string, error) {
+		for i := 0; i < box.slots; i++ {
+			held, err := box.slot(data, i)
+			if err != nil {
+				return "", err
+			}
+			if emptySlot(held) {
+				copy(held, entry)
+				slot = i
+				audit.Detail = fmt.Sprintf("slot %d, %x", i, entry)
+				return audit.Detail, nil
+			}
+		}
+		return "", invalidEdit("the equipment box is full")
+	}, audit)
+	if err != nil {
+		s.writeInventoryError(w, err, charID, "update equipment box")
+		return
+	}
+	s.logInventoryEdit(audit)
+	s.writeJSON(w, map[string]int{"slot": slot})
+}
+
+// serveRemoveEquipment empties a slot of the character's equipment box.
+func (s *Server) serveRemoveEquipment(w http.ResponseWriter, r *http.Request) {
+	charID := parseCharID(r)
+	box, ok := s.equipBox()
+	if !ok {
+		http.Error(w, "the equipment box layout isn't configured", http.StatusNotFound)
+		return
+	}
+	req, ok := decodeEquipBoxRequest(r)
+	if !ok {
+		http.Error(w, "invalid equipment request", http.StatusBadRequest)
+		return
+	}
+	audit := auditEntry{
+		CharID:   charID,
+		Action:   "remove equipment",
+		IssuedBy: req.IssuedBy,
+	}
+	err := s.inventory.EditSavedata(charID, func(data []byte) (string, error) {
+		held, err := box.slot(data, req.Slot)
+		if err != nil {
+			return "", err
+		}
+		if emptySlot(held) {
+			return "", invalidEdit("the equipment box slot is empty")
+		}
+		audit.Detail = fmt.Sprintf("slot %d, %x", req.Slot, held)
+		for i := range held {
+			held[i] = 0
+		}
+		return audit.Detail, nil
+	}, audit)
+	if err != nil {
+		s.writeInventoryError(w, err, charID, "update equipment box")
+		return
+	}
+	s.logInventoryEdit(audit)
+	s.writeJSON(w, map[string]bool{"updated": true})
+}
+
+func decodeEquipBoxRequest(r *http.Request) (equipBoxRequest, bool) {
+	var req equipBoxRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return req, false
+	}
+	if req.IssuedBy == "" {
+		req.IssuedBy = "admin API"
+	}
+	return req, true
+}
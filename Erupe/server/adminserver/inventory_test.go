@@ -0,0 +1,239 @@
+package adminserver
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/Solenataris/Erupe/server/channelserver"
+)
+
+type fakeInventoryStore struct {
+	online   map[uint32]bool // Whether a character of the owning account is online, by character.
+	boxes    map[uint32][]channelserver.Item
+	savedata map[uint32][]byte
+	journal  []auditEntry
+}
+
+func (f *fakeInventoryStore) check(charID uint32) error {
+	online, ok := f.online[charID]
+	if !ok {
+		return errCharacterNotFound
+	}
+	if online {
+		return channelserver.ErrAccountOnline
+	}
+	return nil
+}
+
+func (f *fakeInventoryStore) ItemBox(charID uint32) ([]channelserver.Item, error) {
+	if _, ok := f.online[charID]; !ok {
+		return nil, errCharacterNotFound
+	}
+	return append([]channelserver.Item(nil), f.boxes[charID]...), nil
+}
+
+func (f *fakeInventoryStore) EditItemBox(charID uint32, edit func([]channelserver.Item) ([]channelserver.Item, error), entry auditEntry) error {
+	if err := f.check(charID); err != nil {
+		return err
+	}
+	items, err := edit(append([]channelserver.Item(nil), f.boxes[charID]...))
+	if err != nil {
+		return err
+	}
+	f.boxes[charID] = items
+	f.journal = append(f.journal, entry)
+	return nil
+}
+
+func (f *fakeInventoryStore) Savedata(charID uint32) ([]byte, error) {
+	if _, ok := f.online[charID]; !ok {
+		return nil, errCharacterNotFound
+	}
+	return append([]byte(nil), f.savedata[charID]...), nil
+}
+
+func (f *fakeInventoryStore) EditSavedata(charID uint32, edit func(data []byte) (string, error), entry auditEntry) error {
+	if err := f.check(charID); err != nil {
+		return err
+	}
+	data := append([]byte(nil), f.savedata[charID]...)
+	detail, err := edit(data)
+	if err != nil {
+		return err
+	}
+	f.savedata[charID] = data
+	entry.Detail = detail
+	f.journal = append(f.journal, entry)
+	return nil
+}
+
+func newTestInventory(s *Server) *fakeInventoryStore {
+	inventory := &fakeInventoryStore{
+		// Character 10 is offline, character 20 shares an account with an online character.
+		online: map[uint32]bool{10: false, 20: true},
+		boxes: map[uint32][]channelserver.Item{
+			10: {{ItemId: 100, Amount: 5}, {ItemId: 200, Amount: 1}},
+			20: {{ItemId: 100, Amount: 5}},
+		},
+		savedata: map[uint32][]byte{10: make([]byte, 64), 20: make([]byte, 64)},
+	}
+	s.inventory = inventory
+	return inventory
+}
+
+func TestServeItemBox(t *testing.T) {
+	s, _, _ := newTestServer()
+	newTestInventory(s)
+
+	w := doRequest(s, http.MethodGet, "/characters/10/itembox", "", testToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	var items []inventoryItem
+	if err := json.NewDecoder(w.Body).Decode(&items); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0] != (inventoryItem{100, 5}) {
+		t.Errorf("got items %+v", items)
+	}
+
+	if w := doRequest(s, http.MethodGet, "/characters/404/itembox", "", testToken); w.Code != http.StatusNotFound {
+		t.Errorf("unknown character got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestEditItemBox(t *testing.T) {
+	s, _, _ := newTestServer()
+	inventory := newTestInventory(s)
+
+	if w := doRequest(s, http.MethodPost, "/characters/10/itembox/add", `{"itemID": 100, "amount": 3, "issuedBy": "support"}`, testToken); w.Code != http.StatusOK {
+		t.Fatalf("add got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := doRequest(s, http.MethodPost, "/characters/10/itembox/add", `{"itemID": 300, "amount": 1}`, testToken); w.Code != http.StatusOK {
+		t.Fatalf("add got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := doRequest(s, http.MethodPost, "/characters/10/itembox/remove", `{"itemID": 200}`, testToken); w.Code != http.StatusOK {
+		t.Fatalf("remove got status %d, want %d", w.Code, http.StatusOK)
+	}
+	want := []channelserver.Item{{ItemId: 100, Amount: 8}, {ItemId: 300, Amount: 1}}
+	if got := inventory.boxes[10]; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got box %+v, want %+v", got, want)
+	}
+	if len(inventory.journal) != 3 || inventory.journal[0].IssuedBy != "support" || inventory.journal[1].IssuedBy != "admin API" {
+		t.Errorf("got journal %+v", inventory.journal)
+	}
+
+	if w := doRequest(s, http.MethodPost, "/characters/10/itembox/remove", `{"itemID": 999}`, testToken); w.Code != http.StatusBadRequest {
+		t.Errorf("removing a missing item got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := doRequest(s, http.MethodPost, "/characters/10/itembox/add", `{"itemID": 100, "amount": 65535}`, testToken); w.Code != http.StatusBadRequest {
+		t.Errorf("overflowing a stack got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := doRequest(s, http.MethodPost, "/characters/404/itembox/add", `{"itemID": 100, "amount": 1}`, testToken); w.Code != http.StatusNotFound {
+		t.Errorf("unknown character got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestEditItemBoxRefusedWhileOnline(t *testing.T) {
+	s, _, _ := newTestServer()
+	inventory := newTestInventory(s)
+
+	w := doRequest(s, http.MethodPost, "/characters/20/itembox/add", `{"itemID": 100, "amount": 1}`, testToken)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusConflict)
+	}
+	if inventory.boxes[20][0].Amount != 5 || len(inventory.journal) != 0 {
+		t.Error("item box was edited while the account was online")
+	}
+}
+
+func TestAdjustZenny(t *testing.T) {
+	s, _, _ := newTestServer()
+	inventory := newTestInventory(s)
+	binary.LittleEndian.PutUint32(inventory.savedata[10][8:], 1000)
+
+	if w := doRequest(s, http.MethodGet, "/characters/10/zenny", "", testToken); w.Code != http.StatusNotFound {
+		t.Errorf("without an offset got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	s.erupeConfig.Admin.ZennyOffset = 8
+
+	w := doRequest(s, http.MethodPost, "/characters/10/zenny", `{"delta": -400, "issuedBy": "support"}`, testToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if zenny := binary.LittleEndian.Uint32(inventory.savedata[10][8:]); zenny != 600 {
+		t.Errorf("got %d zenny, want 600", zenny)
+	}
+	if len(inventory.journal) != 1 || inventory.journal[0].Detail != "zenny -400, 1000 to 600" {
+		t.Errorf("got journal %+v", inventory.journal)
+	}
+	w = doRequest(s, http.MethodGet, "/characters/10/zenny", "", testToken)
+	var resp map[string]uint32
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp["zenny"] != 600 {
+		t.Errorf("got %v, %v", resp, err)
+	}
+
+	if w := doRequest(s, http.MethodPost, "/characters/10/zenny", `{"delta": -601}`, testToken); w.Code != http.StatusBadRequest {
+		t.Errorf("going below 0 got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := doRequest(s, http.MethodPost, "/characters/20/zenny", `{"delta": 1}`, testToken); w.Code != http.StatusConflict {
+		t.Errorf("an online account got status %d, want %d", w.Code, http.StatusConflict)
+	}
+	s.erupeConfig.Admin.ZennyOffset = 62
+	if w := doRequest(s, http.MethodPost, "/characters/10/zenny", `{"delta": 1}`, testToken); w.Code != http.StatusBadRequest {
+		t.Errorf("an offset past the savedata got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if len(inventory.journal) != 1 {
+		t.Errorf("refused edits were journaled: %+v", inventory.journal)
+	}
+}
+
+func TestEditEquipBox(t *testing.T) {
+	s, _, _ := newTestServer()
+	inventory := newTestInventory(s)
+	s.erupeConfig.Admin.EquipBoxOffset = 16
+	s.erupeConfig.Admin.EquipBoxSlots = 3
+	s.erupeConfig.Admin.EquipBoxEntrySize = 4
+	copy(inventory.savedata[10][16:], []byte{1, 2, 3, 4})
+
+	for _, data := range []string{"0a0b0c0d", "0e0f1011"} {
+		if w := doRequest(s, http.MethodPost, "/characters/10/equipbox/add", `{"data": "`+data+`"}`, testToken); w.Code != http.StatusOK {
+			t.Fatalf("add got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+	}
+	if w := doRequest(s, http.MethodPost, "/characters/10/equipbox/add", `{"data": "01010101"}`, testToken); w.Code != http.StatusBadRequest {
+		t.Errorf("adding to a full box got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := doRequest(s, http.MethodPost, "/characters/10/equipbox/remove", `{"slot": 0}`, testToken); w.Code != http.StatusOK {
+		t.Fatalf("remove got status %d, want %d", w.Code, http.StatusOK)
+	}
+
+	w := doRequest(s, http.MethodGet, "/characters/10/equipbox", "", testToken)
+	var slots []equipBoxSlot
+	if err := json.NewDecoder(w.Body).Decode(&slots); err != nil {
+		t.Fatal(err)
+	}
+	want := []equipBoxSlot{{1, "0a0b0c0d"}, {2, "0e0f1011"}}
+	if len(slots) != len(want) || slots[0] != want[0] || slots[1] != want[1] {
+		t.Errorf("got slots %+v, want %+v", slots, want)
+	}
+	if len(inventory.journal) != 3 || inventory.journal[2].Detail != "slot 0, 01020304" {
+		t.Errorf("got journal %+v", inventory.journal)
+	}
+
+	for _, body := range []string{`{"data": "0a0b"}`, `{"data": "00000000"}`, `{"data": "zz"}`} {
+		if w := doRequest(s, http.MethodPost, "/characters/10/equipbox/add", body, testToken); w.Code != http.StatusBadRequest {
+			t.Errorf("%s got status %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+	}
+	for _, body := range []string{`{"slot": 0}`, `{"slot": 3}`} {
+		if w := doRequest(s, http.MethodPost, "/characters/10/equipbox/remove", body, testToken); w.Code != http.StatusBadRequest {
+			t.Errorf("removing %s got status %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+	}
+	if w := doRequest(s, http.MethodPost, "/characters/20/equipbox/remove", `{"slot": 0}`, testToken); w.Code != http.StatusConflict {
+		t.Errorf("an online account got status %d, want %d", w.Code, http.StatusConflict)
+	}
+}
@@ -0,0 +1,65 @@
+package adminserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Solenataris/Erupe/server/invite"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// inviteStore generates the invite codes the sign server takes when registration needs one.
+type inviteStore interface {
+	Generate(maxUses int, expiresAt *time.Time) (string, error)
+}
+
+type dbInviteStore struct {
+	db *sqlx.DB
+}
+
+func (i dbInviteStore) Generate(maxUses int, expiresAt *time.Time) (string, error) {
+	return invite.Generate(i.db, maxUses, expiresAt)
+}
+
+type inviteRequest struct {
+	MaxUses  int    `json:"maxUses"`  // Accounts the code can register, 1 if left out.
+	Duration string `json:"duration"` // Go duration such as "168h", empty for a code that never expires.
+}
+
+type inviteResponse struct {
+	Code      string     `json:"code"`
+	MaxUses   int        `json:"maxUses"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+func (s *Server) serveGenerateInvite(w http.ResponseWriter, r *http.Request) {
+	var req inviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MaxUses < 0 {
+		http.Error(w, "invalid invite request", http.StatusBadRequest)
+		return
+	}
+	if req.MaxUses == 0 {
+		req.MaxUses = 1
+	}
+	var expiresAt *time.Time
+	if req.Duration != "" {
+		duration, err := time.ParseDuration(req.Duration)
+		if err != nil || duration <= 0 {
+			http.Error(w, "invalid invite duration", http.StatusBadRequest)
+			return
+		}
+		expires := time.Now().Add(duration)
+		expiresAt = &expires
+	}
+
+	code, err := s.invites.Generate(req.MaxUses, expiresAt)
+	if err != nil {
+		s.logger.Error("Failed to generate invite code", zap.Error(err))
+		http.Error(w, "failed to store invite code", http.StatusInternalServerError)
+		return
+	}
+	s.logger.Info("Generated invite code", zap.Int("maxUses", req.MaxUses), zap.String("duration", req.Duration))
+	s.writeJSON(w, inviteResponse{code, req.MaxUses, expiresAt})
+}
@@ -0,0 +1,43 @@
+package adminserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type fakeInviteStore struct {
+	maxUses   int
+	expiresAt *time.Time
+}
+
+func (f *fakeInviteStore) Generate(maxUses int, expiresAt *time.Time) (string, error) {
+	f.maxUses, f.expiresAt = maxUses, expiresAt
+	return "ABCDEFGHJK", nil
+}
+
+func TestServeGenerateInvite(t *testing.T) {
+	s, _, _ := newTestServer()
+	store := &fakeInviteStore{}
+	s.invites = store
+
+	w := doRequest(s, http.MethodPost, "/invites", `{"maxUses": 3, "duration": "168h"}`, testToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	var resp inviteResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != "ABCDEFGHJK" || store.maxUses != 3 || store.expiresAt == nil {
+		t.Errorf("got %+v stored with %d uses until %v", resp, store.maxUses, store.expiresAt)
+	}
+
+	if w := doRequest(s, http.MethodPost, "/invites", `{}`, testToken); w.Code != http.StatusOK || store.maxUses != 1 || store.expiresAt != nil {
+		t.Errorf("default invite got status %d, %d uses until %v", w.Code, store.maxUses, store.expiresAt)
+	}
+	if w := doRequest(s, http.MethodPost, "/invites", `{"duration": "soon"}`, testToken); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid duration got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,73 @@
+package adminserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/netip"
+
+	"github.com/Solenataris/Erupe/server/ipban"
+	"go.uber.org/zap"
+)
+
+// ipBanList stores IP bans, which the entrance and sign servers enforce.
+type ipBanList interface {
+	Add(prefix netip.Prefix, reason string, issuedBy string) error
+	Remove(prefix netip.Prefix) (bool, error)
+}
+
+type ipBanRequest struct {
+	Range    string `json:"range"` // CIDR range such as "198.51.100.0/24", or a lone address.
+	Reason   string `json:"reason"`
+	IssuedBy string `json:"issuedBy"`
+}
+
+func decodeIPBanRequest(r *http.Request) (ipBanRequest, netip.Prefix, bool) {
+	var req ipBanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return req, netip.Prefix{}, false
+	}
+	prefix, err := ipban.ParsePrefix(req.Range)
+	return req, prefix, err == nil
+}
+
+func (s *Server) serveAddIPBan(w http.ResponseWriter, r *http.Request) {
+	req, prefix, ok := decodeIPBanRequest(r)
+	if !ok {
+		http.Error(w, "invalid IP ban request", http.StatusBadRequest)
+		return
+	}
+	if req.IssuedBy == "" {
+		req.IssuedBy = "admin API"
+	}
+	if err := s.ipBans.Add(prefix, req.Reason, req.IssuedBy); err != nil {
+		s.logger.Error("Failed to add IP ban", zap.Error(err), zap.String("range", prefix.String()))
+		http.Error(w, "failed to store IP ban", http.StatusInternalServerError)
+		return
+	}
+	s.logger.Info("Banned IP range",
+		zap.String("range", prefix.String()),
+		zap.String("reason", req.Reason),
+		zap.String("issuedBy", req.IssuedBy),
+	)
+	s.writeJSON(w, map[string]string{"range": prefix.String()})
+}
+
+func (s *Server) serveRemoveIPBan(w http.ResponseWriter, r *http.Request) {
+	_, prefix, ok := decodeIPBanRequest(r)
+	if !ok {
+		http.Error(w, "invalid IP ban request", http.StatusBadRequest)
+		return
+	}
+	removed, err := s.ipBans.Remove(prefix)
+	if err != nil {
+		s.logger.Error("Failed to remove IP ban", zap.Error(err), zap.String("range", prefix.String()))
+		http.Error(w, "failed to remove IP ban", http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "range isn't banned", http.StatusNotFound)
+		return
+	}
+	s.logger.Info("Lifted IP range ban", zap.String("range", prefix.String()))
+	s.writeJSON(w, map[string]string{"range": prefix.String()})
+}
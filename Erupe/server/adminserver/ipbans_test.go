@@ -0,0 +1,51 @@
+package adminserver
+
+import (
+	"net/http"
+	"net/netip"
+	"testing"
+)
+
+type fakeIPBanList struct {
+	ranges map[netip.Prefix]string
+}
+
+func (f *fakeIPBanList) Add(prefix netip.Prefix, reason string, issuedBy string) error {
+	f.ranges[prefix] = issuedBy
+	return nil
+}
+
+func (f *fakeIPBanList) Remove(prefix netip.Prefix) (bool, error) {
+	_, ok := f.ranges[prefix]
+	delete(f.ranges, prefix)
+	return ok, nil
+}
+
+func TestServeIPBans(t *testing.T) {
+	s, _, _ := newTestServer()
+	list := &fakeIPBanList{ranges: make(map[netip.Prefix]string)}
+	s.ipBans = list
+
+	w := doRequest(s, http.MethodPost, "/ipbans", `{"range": "198.51.100.77/24", "reason": "ban evasion"}`, testToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	prefix := netip.MustParsePrefix("198.51.100.0/24")
+	if issuedBy, ok := list.ranges[prefix]; !ok || issuedBy != "admin API" {
+		t.Fatalf("got ranges %v, want %s issued by the admin API", list.ranges, prefix)
+	}
+
+	if w := doRequest(s, http.MethodPost, "/ipbans", `{"range": "198.51.100.0/33"}`, testToken); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid range got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	if w := doRequest(s, http.MethodDelete, "/ipbans", `{"range": "198.51.100.0/24"}`, testToken); w.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if len(list.ranges) != 0 {
+		t.Errorf("range wasn't removed: %v", list.ranges)
+	}
+	if w := doRequest(s, http.MethodDelete, "/ipbans", `{"range": "198.51.100.0/24"}`, testToken); w.Code != http.StatusNotFound {
+		t.Errorf("unbanned range got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
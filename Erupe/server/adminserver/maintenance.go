@@ -0,0 +1,75 @@
+package adminserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Solenataris/Erupe/server/lockdownserver"
+	"github.com/Solenataris/Erupe/server/maintenanceserver"
+	"go.uber.org/zap"
+)
+
+// maintenanceMode closes sign in and counts players down to disconnecting them.
+type maintenanceMode interface {
+	Begin(countdown time.Duration, kick bool, reason string, startedBy string) (lockdownserver.Status, error)
+	End() error
+	MaintenanceStatus() lockdownserver.Status
+}
+
+type maintenanceRequest struct {
+	Minutes   int    `json:"minutes"` // Length of the countdown, 0 to end it at once.
+	Kick      bool   `json:"kick"`    // Disconnect everyone, saving their progress, once it ends.
+	Reason    string `json:"reason"`  // Added to the countdown warnings.
+	StartedBy string `json:"startedBy"`
+}
+
+func (s *Server) serveMaintenance(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, s.maintenance.MaintenanceStatus())
+}
+
+// serveBeginMaintenance turns maintenance mode on, or replaces its countdown if it's already on.
+func (s *Server) serveBeginMaintenance(w http.ResponseWriter, r *http.Request) {
+	var req maintenanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Minutes < 0 {
+		http.Error(w, "invalid maintenance request", http.StatusBadRequest)
+		return
+	}
+	if req.StartedBy == "" {
+		req.StartedBy = "admin API"
+	}
+	status, err := s.maintenance.Begin(time.Duration(req.Minutes)*time.Minute, req.Kick, req.Reason, req.StartedBy)
+	if err != nil {
+		s.logger.Error("Failed to begin maintenance mode", zap.Error(err))
+		http.Error(w, "failed to begin maintenance mode", http.StatusInternalServerError)
+		return
+	}
+	s.logger.Info("Began maintenance mode through the admin API", zap.Int("minutes", req.Minutes), zap.Bool("kick", req.Kick), zap.String("startedBy", req.StartedBy))
+	s.writeJSON(w, status)
+}
+
+// serveEndMaintenance turns maintenance mode off, opening sign in again.
+func (s *Server) serveEndMaintenance(w http.ResponseWriter, r *http.Request) {
+	switch err := s.maintenance.End(); err {
+	case nil:
+		s.writeJSON(w, map[string]bool{"ended": true})
+	case lockdownserver.ErrNotActive:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		s.logger.Error("Failed to end maintenance mode", zap.Error(err))
+		http.Error(w, "failed to end maintenance mode", http.StatusInternalServerError)
+	}
+}
+
+// maintenanceTasks reports the last run of the scheduled DB maintenance tasks.
+type maintenanceTasks interface {
+	Status() []maintenanceserver.TaskStatus
+}
+
+func (s *Server) serveMaintenanceTasks(w http.ResponseWriter, r *http.Request) {
+	if s.tasks == nil {
+		http.Error(w, "scheduled maintenance is disabled", http.StatusNotFound)
+		return
+	}
+	s.writeJSON(w, s.tasks.Status())
+}
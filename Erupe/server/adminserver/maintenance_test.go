@@ -0,0 +1,84 @@
+package adminserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/server/lockdownserver"
+	"github.com/Solenataris/Erupe/server/maintenanceserver"
+)
+
+type fakeMaintenance struct {
+	status    lockdownserver.Status
+	countdown time.Duration
+}
+
+func (f *fakeMaintenance) Begin(countdown time.Duration, kick bool, reason string, startedBy string) (lockdownserver.Status, error) {
+	f.countdown = countdown
+	f.status = lockdownserver.Status{Active: true, Kick: kick, Reason: reason, StartedBy: startedBy}
+	return f.status, nil
+}
+
+func (f *fakeMaintenance) End() error {
+	if !f.status.Active {
+		return lockdownserver.ErrNotActive
+	}
+	f.status = lockdownserver.Status{}
+	return nil
+}
+
+func (f *fakeMaintenance) MaintenanceStatus() lockdownserver.Status { return f.status }
+
+func TestServeMaintenance(t *testing.T) {
+	s, _, _ := newTestServer()
+	maintenance := &fakeMaintenance{}
+	s.maintenance = maintenance
+
+	if w := doRequest(s, http.MethodPost, "/maintenance", `{"minutes": -1}`, testToken); w.Code != http.StatusBadRequest {
+		t.Errorf("negative countdown got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	w := doRequest(s, http.MethodPost, "/maintenance", `{"minutes": 15, "kick": true, "reason": "Patching."}`, testToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	var status lockdownserver.Status
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if !status.Active || !status.Kick || status.StartedBy != "admin API" || maintenance.countdown != 15*time.Minute {
+		t.Errorf("got %+v with a %v countdown", status, maintenance.countdown)
+	}
+	if w := doRequest(s, http.MethodGet, "/maintenance", "", testToken); w.Code != http.StatusOK {
+		t.Errorf("status got %d, want %d", w.Code, http.StatusOK)
+	}
+
+	if w := doRequest(s, http.MethodDelete, "/maintenance", "", testToken); w.Code != http.StatusOK {
+		t.Errorf("end got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := doRequest(s, http.MethodDelete, "/maintenance", "", testToken); w.Code != http.StatusNotFound {
+		t.Errorf("ending again got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+type fakeMaintenanceTasks []maintenanceserver.TaskStatus
+
+func (f fakeMaintenanceTasks) Status() []maintenanceserver.TaskStatus { return f }
+
+func TestServeMaintenanceTasks(t *testing.T) {
+	s, _, _ := newTestServer()
+	if w := doRequest(s, http.MethodGet, "/maintenance/tasks", "", testToken); w.Code != http.StatusNotFound {
+		t.Errorf("disabled maintenance got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	s.tasks = fakeMaintenanceTasks{{Task: "vacuum-hot-tables", Status: "completed", RowsAffected: 0}}
+	w := doRequest(s, http.MethodGet, "/maintenance/tasks", "", testToken)
+	var status []maintenanceserver.TaskStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("got status %d, %v", w.Code, err)
+	}
+	if len(status) != 1 || status[0].Task != "vacuum-hot-tables" || status[0].Status != "completed" {
+		t.Errorf("got %+v", status)
+	}
+}
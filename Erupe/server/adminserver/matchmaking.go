@@ -0,0 +1,16 @@
+package adminserver
+
+import (
+	"net/http"
+
+	"github.com/Solenataris/Erupe/server/channelserver"
+)
+
+// serveMatchmaking lists the matchmaking queue and pending matches of every channel.
+func (s *Server) serveMatchmaking(w http.ResponseWriter, r *http.Request) {
+	channels := []channelserver.MatchmakingStatus{}
+	for _, registry := range s.registries {
+		channels = append(channels, registry.MatchmakingQueue())
+	}
+	s.writeJSON(w, channels)
+}
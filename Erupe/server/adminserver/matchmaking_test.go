@@ -0,0 +1,33 @@
+package adminserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/Solenataris/Erupe/server/channelserver"
+)
+
+func TestServeMatchmaking(t *testing.T) {
+	s, registry, _ := newTestServer()
+	registry.queue = channelserver.MatchmakingStatus{
+		Channel: "Newbie",
+		Queue:   []channelserver.MatchmakingEntry{{CharID: 1, Name: "Alpha", MinFloor: 1, MaxFloor: 50}},
+		Matches: []channelserver.MatchmakingMatch{{StageID: "sl1Qs900p0a0u1", CharIDs: []uint32{2, 3}, Accepted: []uint32{2}}},
+	}
+
+	w := doRequest(s, http.MethodGet, "/matchmaking", "", testToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	var channels []channelserver.MatchmakingStatus
+	if err := json.NewDecoder(w.Body).Decode(&channels); err != nil {
+		t.Fatal(err)
+	}
+	if len(channels) != 1 || channels[0].Channel != "Newbie" || len(channels[0].Queue) != 1 || len(channels[0].Matches) != 1 {
+		t.Fatalf("got %+v", channels)
+	}
+	if match := channels[0].Matches[0]; match.StageID != "sl1Qs900p0a0u1" || len(match.Accepted) != 1 {
+		t.Errorf("got match %+v", match)
+	}
+}
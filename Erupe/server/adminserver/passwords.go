@@ -0,0 +1,62 @@
+package adminserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/server/channelserver"
+	"github.com/Solenataris/Erupe/server/signserver"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// passwordStore replaces account passwords, returning the account of the character.
+type passwordStore interface {
+	SetPassword(charID uint32, password string) (uint32, error)
+}
+
+// dbPasswordStore sets the password of the account owning the character, revoking the account as it does.
+type dbPasswordStore struct {
+	db          *sqlx.DB
+	erupeConfig *config.Config
+}
+
+func (p dbPasswordStore) SetPassword(charID uint32, password string) (uint32, error) {
+	var userID uint32
+	err := p.db.QueryRow("SELECT user_id FROM characters WHERE id = $1", charID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, errCharacterNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return userID, signserver.SetPassword(p.db, p.erupeConfig, userID, password)
+}
+
+type passwordRequest struct {
+	Password string `json:"password"`
+}
+
+// serveSetPassword replaces the password of the account owning the character and signs it out everywhere.
+func (s *Server) serveSetPassword(w http.ResponseWriter, r *http.Request) {
+	charID := parseCharID(r)
+	var req passwordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Password == "" {
+		http.Error(w, "invalid password request", http.StatusBadRequest)
+		return
+	}
+	userID, err := s.passwords.SetPassword(charID, req.Password)
+	if err == errCharacterNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to set password", zap.Error(err), zap.Uint32("charID", charID))
+		http.Error(w, "failed to set password", http.StatusInternalServerError)
+		return
+	}
+	s.logger.Info("Set password through the admin API", zap.Uint32("charID", charID), zap.Uint32("userID", userID))
+	s.writeJSON(w, map[string]bool{"kicked": s.revoke(userID, channelserver.RevokeCredentials)})
+}
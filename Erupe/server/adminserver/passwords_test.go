@@ -0,0 +1,43 @@
+package adminserver
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Solenataris/Erupe/server/channelserver"
+)
+
+type fakePasswordStore struct {
+	passwords map[uint32]string
+}
+
+func (f *fakePasswordStore) SetPassword(charID uint32, password string) (uint32, error) {
+	if charID == 404 {
+		return 0, errCharacterNotFound
+	}
+	f.passwords[charID] = password
+	return charID, nil
+}
+
+func TestServeSetPassword(t *testing.T) {
+	s, registry, _ := newTestServer()
+	passwords := &fakePasswordStore{passwords: make(map[uint32]string)}
+	s.passwords = passwords
+
+	if w := doRequest(s, http.MethodPost, "/characters/1/password", `{"password": ""}`, testToken); w.Code != http.StatusBadRequest {
+		t.Errorf("empty password got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	w := doRequest(s, http.MethodPost, "/characters/1/password", `{"password": "hunter2"}`, testToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if passwords.passwords[1] != "hunter2" {
+		t.Errorf("got passwords %v", passwords.passwords)
+	}
+	if len(registry.revoked) != 1 || registry.revoked[0] != channelserver.RevokeCredentials {
+		t.Errorf("account wasn't revoked for its credentials, got %v", registry.revoked)
+	}
+	if w := doRequest(s, http.MethodPost, "/characters/404/password", `{"password": "hunter2"}`, testToken); w.Code != http.StatusNotFound {
+		t.Errorf("unknown character got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
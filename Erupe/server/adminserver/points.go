@@ -0,0 +1,38 @@
+package adminserver
+
+import (
+	"net/http"
+
+	"github.com/Solenataris/Erupe/server/channelserver"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// Entries of a character's points ledger listed at once.
+const pointsLedgerLimit = 200
+
+// pointsStore reads the ledger of the characters' point balances, see channelserver.AddPoints.
+type pointsStore interface {
+	Ledger(charID uint32) ([]channelserver.PointsLedgerEntry, error)
+}
+
+type dbPointsStore struct {
+	db *sqlx.DB
+}
+
+func (p dbPointsStore) Ledger(charID uint32) ([]channelserver.PointsLedgerEntry, error) {
+	return channelserver.PointsLedger(p.db, charID, pointsLedgerLimit)
+}
+
+// servePointsLedger lists the latest changes of the character's point balances with what made them, the
+// newest first, for looking into a player's claim of lost points.
+func (s *Server) servePointsLedger(w http.ResponseWriter, r *http.Request) {
+	charID := parseCharID(r)
+	entries, err := s.points.Ledger(charID)
+	if err != nil {
+		s.logger.Error("Failed to get points ledger", zap.Error(err), zap.Uint32("charID", charID))
+		http.Error(w, "failed to get points ledger", http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, entries)
+}
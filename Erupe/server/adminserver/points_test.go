@@ -0,0 +1,43 @@
+package adminserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/Solenataris/Erupe/server/channelserver"
+)
+
+type fakePointsStore struct {
+	entries []channelserver.PointsLedgerEntry
+}
+
+func (f *fakePointsStore) Ledger(charID uint32) ([]channelserver.PointsLedgerEntry, error) {
+	entries := []channelserver.PointsLedgerEntry{}
+	for _, entry := range f.entries {
+		if entry.CharID == charID {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func TestServePointsLedger(t *testing.T) {
+	s, _, _ := newTestServer()
+	s.points = &fakePointsStore{entries: []channelserver.PointsLedgerEntry{
+		{ID: 2, CharID: 10, Currency: "frontier_points", Delta: -500, Balance: 1500, Source: "point shop"},
+		{ID: 1, CharID: 11, Currency: "festa_points", Delta: 20, Balance: 20, Source: "festa souls"},
+	}}
+
+	w := doRequest(s, http.MethodGet, "/characters/10/points/ledger", "", testToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	var entries []channelserver.PointsLedgerEntry
+	if err := json.NewDecoder(w.Body).Decode(&entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Source != "point shop" || entries[0].Balance != 1500 {
+		t.Errorf("got ledger %+v of character 10", entries)
+	}
+}
@@ -0,0 +1,30 @@
+package adminserver
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+type reloadResponse struct {
+	RestartRequired []string `json:"restartRequired"` // Settings that changed but only apply after a restart.
+}
+
+// serveReloadConfig reads the config file again, as SIGHUP does. A file that doesn't load or validate
+// leaves the running config as it is.
+func (s *Server) serveReloadConfig(w http.ResponseWriter, r *http.Request) {
+	if s.reloadConfig == nil {
+		http.Error(w, "config reloading isn't available", http.StatusNotImplemented)
+		return
+	}
+	restartRequired, err := s.reloadConfig()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	s.logger.Info("Reloaded config through the admin API", zap.Strings("restartRequired", restartRequired))
+	if restartRequired == nil {
+		restartRequired = []string{}
+	}
+	s.writeJSON(w, reloadResponse{restartRequired})
+}
@@ -0,0 +1,38 @@
+package adminserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestServeReloadConfig(t *testing.T) {
+	s, _, _ := newTestServer()
+	if w := doRequest(s, http.MethodPost, "/config/reload", "", testToken); w.Code != http.StatusNotImplemented {
+		t.Errorf("without a reloader got status %d, want %d", w.Code, http.StatusNotImplemented)
+	}
+
+	reloads := 0
+	s.reloadConfig = func() ([]string, error) {
+		reloads++
+		return []string{"Sign.Port"}, nil
+	}
+	w := doRequest(s, http.MethodPost, "/config/reload", "", testToken)
+	if w.Code != http.StatusOK || reloads != 1 {
+		t.Fatalf("got status %d after %d reloads, want %d after one", w.Code, reloads, http.StatusOK)
+	}
+	var resp reloadResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resp.RestartRequired, []string{"Sign.Port"}) {
+		t.Errorf("got %v needing a restart", resp.RestartRequired)
+	}
+
+	s.reloadConfig = func() ([]string, error) { return nil, errors.New("announcement 0 has no message") }
+	if w := doRequest(s, http.MethodPost, "/config/reload", "", testToken); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("invalid config got status %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+}
@@ -0,0 +1,62 @@
+package adminserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Solenataris/Erupe/server/restartserver"
+	"go.uber.org/zap"
+)
+
+// restartScheduler schedules and calls off the restarts of the process.
+type restartScheduler interface {
+	Schedule(at time.Time) error
+	Cancel() error
+	RestartStatus() restartserver.Status
+}
+
+type restartRequest struct {
+	At      time.Time `json:"at"`      // When to restart, or
+	Minutes int       `json:"minutes"` // how many minutes from now.
+}
+
+func (s *Server) serveRestart(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, s.restarts.RestartStatus())
+}
+
+// serveScheduleRestart replaces the next restart, configured or not, with the requested one.
+func (s *Server) serveScheduleRestart(w http.ResponseWriter, r *http.Request) {
+	var req restartRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.At.IsZero() == (req.Minutes <= 0) {
+		http.Error(w, "invalid restart request", http.StatusBadRequest)
+		return
+	}
+	at := req.At
+	if req.Minutes > 0 {
+		at = time.Now().Add(time.Duration(req.Minutes) * time.Minute)
+	}
+	switch err := s.restarts.Schedule(at); err {
+	case nil:
+	case restartserver.ErrDraining:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.logger.Info("Scheduled restart through the admin API", zap.Time("at", at))
+	s.writeJSON(w, s.restarts.RestartStatus())
+}
+
+// serveCancelRestart calls off the next restart, up until it starts draining players.
+func (s *Server) serveCancelRestart(w http.ResponseWriter, r *http.Request) {
+	switch err := s.restarts.Cancel(); err {
+	case nil:
+		s.writeJSON(w, map[string]bool{"cancelled": true})
+	case restartserver.ErrNoRestart:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusConflict)
+	}
+}
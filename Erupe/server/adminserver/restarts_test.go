@@ -0,0 +1,69 @@
+package adminserver
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/server/restartserver"
+)
+
+type fakeRestarts struct {
+	at       time.Time
+	draining bool
+}
+
+func (f *fakeRestarts) Schedule(at time.Time) error {
+	if f.draining {
+		return restartserver.ErrDraining
+	}
+	f.at = at
+	return nil
+}
+
+func (f *fakeRestarts) Cancel() error {
+	if f.draining {
+		return restartserver.ErrDraining
+	}
+	if f.at.IsZero() {
+		return restartserver.ErrNoRestart
+	}
+	f.at = time.Time{}
+	return nil
+}
+
+func (f *fakeRestarts) RestartStatus() restartserver.Status {
+	return restartserver.Status{Scheduled: !f.at.IsZero(), At: f.at, Draining: f.draining}
+}
+
+func TestServeRestart(t *testing.T) {
+	s, _, _ := newTestServer()
+	restarts := &fakeRestarts{}
+	s.restarts = restarts
+
+	if w := doRequest(s, http.MethodPost, "/restart", `{}`, testToken); w.Code != http.StatusBadRequest {
+		t.Errorf("restart without a time got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := doRequest(s, http.MethodPost, "/restart", `{"minutes": 10}`, testToken); w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if until := time.Until(restarts.at); until < 9*time.Minute || until > 10*time.Minute {
+		t.Errorf("restart scheduled %v from now, want 10m", until)
+	}
+	if w := doRequest(s, http.MethodGet, "/restart", "", testToken); w.Code != http.StatusOK {
+		t.Errorf("status got %d, want %d", w.Code, http.StatusOK)
+	}
+
+	if w := doRequest(s, http.MethodDelete, "/restart", "", testToken); w.Code != http.StatusOK {
+		t.Errorf("cancel got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := doRequest(s, http.MethodDelete, "/restart", "", testToken); w.Code != http.StatusNotFound {
+		t.Errorf("cancelling nothing got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	restarts.at = time.Now()
+	restarts.draining = true
+	if w := doRequest(s, http.MethodDelete, "/restart", "", testToken); w.Code != http.StatusConflict {
+		t.Errorf("cancelling a draining restart got status %d, want %d", w.Code, http.StatusConflict)
+	}
+}
@@ -0,0 +1,233 @@
+package adminserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Solenataris/Erupe/server/channelserver"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+var errBackupNotFound = errors.New("backup not found")
+
+// A forced restore retries every restoreRetryInterval until the character's logout save is written,
+// giving up after restoreLogoutTimeout.
+var (
+	restoreRetryInterval = 500 * time.Millisecond
+	restoreLogoutTimeout = 30 * time.Second
+)
+
+// savedataBackup is a savedata of a character kept in savedata_backups: one replaced by a save or a
+// restore, or a daily snapshot.
+type savedataBackup struct {
+	ID      uint32    `db:"id" json:"id"`
+	Kind    string    `db:"kind" json:"kind"`
+	TakenAt time.Time `db:"taken_at" json:"takenAt"`
+	Size    int       `db:"size" json:"size"`
+}
+
+// saveAnomaly is a save flagged in save_anomalies, whose savedata changed in size past
+// Channel.SavedataQuota.ChangePercent.
+type saveAnomaly struct {
+	ID           uint32    `db:"id" json:"id"`
+	CharID       uint32    `db:"char_id" json:"charID"`
+	PreviousSize int       `db:"previous_size" json:"previousSize"`
+	Size         int       `db:"size" json:"size"`
+	KeptPrevious bool      `db:"kept_previous" json:"keptPrevious"` // The save was refused.
+	CreatedAt    time.Time `db:"created_at" json:"createdAt"`
+}
+
+// The save anomalies listed of all characters, the most recent first.
+const saveAnomalyListLimit = 100
+
+// savedataStore lists the backups of a character's savedata and restores them, and lists the saves
+// flagged as anomalies. A restore is refused with channelserver.ErrAccountOnline while a character of the
+// account is logged in to any process, SignOut disconnects them.
+type savedataStore interface {
+	SavedataBackups(charID uint32) ([]savedataBackup, error)
+	RestoreSavedata(charID uint32, backupID uint32, entry auditEntry) error
+	SignOut(charID uint32) error
+	SaveAnomalies(charID uint32) ([]saveAnomaly, error)
+}
+
+type dbSavedataStore struct {
+	db *sqlx.DB
+}
+
+func (d dbSavedataStore) SavedataBackups(charID uint32) ([]savedataBackup, error) {
+	backups := []savedataBackup{}
+	err := d.db.Select(&backups, "SELECT id, kind, taken_at, length(savedata) AS size FROM savedata_backups WHERE char_id = $1 ORDER BY id DESC", charID)
+	return backups, err
+}
+
+// SaveAnomalies lists the character's save anomalies, or the most recent of all characters' for 0.
+func (d dbSavedataStore) SaveAnomalies(charID uint32) ([]saveAnomaly, error) {
+	anomalies := []saveAnomaly{}
+	err := d.db.Select(&anomalies, `
+		SELECT id, char_id, previous_size, size, kept_previous, created_at FROM save_anomalies
+		WHERE $1 = 0 OR char_id = $1 ORDER BY id DESC LIMIT $2
+	`, charID, saveAnomalyListLimit)
+	return anomalies, err
+}
+
+// RestoreSavedata checks the account offline, swaps the backup back in as the character's savedata and
+// journals it. The savedata it replaces is kept as a backup of its own, so the restore can be undone the
+// same way. The columns read out of the savedata, such as the HRP, catch up at the character's next save.
+func (d dbSavedataStore) RestoreSavedata(charID uint32, backupID uint32, entry auditEntry) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := checkOffline(tx, charID); err != nil {
+		return err
+	}
+	var savedata []byte
+	err = tx.QueryRow("SELECT savedata FROM savedata_backups WHERE id = $1 AND char_id = $2", backupID, charID).Scan(&savedata)
+	if err == sql.ErrNoRows {
+		return errBackupNotFound
+	}
+	if err != nil {
+		return err
+	}
+	// The savedata replaced is kept with the diffs of its saves applied, which the restored one drops.
+	if _, err := channelserver.ConsolidateSavedata(tx, charID); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO savedata_backups (char_id, savedata, kind) SELECT id, savedata, 'restore' FROM characters WHERE id = $1 AND savedata IS NOT NULL", charID)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec("UPDATE characters SET savedata = $1 WHERE id = $2", savedata, charID); err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO admin_audit_log (character_id, action, detail, issued_by) VALUES ($1, $2, $3, $4)", entry.CharID, entry.Action, entry.Detail, entry.IssuedBy)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type restoreSavedataRequest struct {
+	BackupID uint32 `json:"backupID"`
+	Force    bool   `json:"force"` // Restore while the character is online, disconnecting it.
+	IssuedBy string `json:"issuedBy"`
+}
+
+// SignOut revokes the account owning the character, disconnecting its sessions on every process sharing
+// the DB, see channelserver.RevokeAccount.
+func (d dbSavedataStore) SignOut(charID uint32) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var userID uint32
+	err = tx.QueryRow("SELECT user_id FROM characters WHERE id = $1", charID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return errCharacterNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := channelserver.RevokeAccount(tx, userID, channelserver.RevokeKicked); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// restoreAfterLogout disconnects the character wherever it's logged in, then restores the backup once its
+// logout save is written and the account shows offline, so the save doesn't overwrite the restored savedata.
+func (s *Server) restoreAfterLogout(charID uint32, backupID uint32, entry auditEntry) error {
+	s.kick(charID)
+	if err := s.savedata.SignOut(charID); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(restoreLogoutTimeout)
+	for {
+		err := s.savedata.RestoreSavedata(charID, backupID, entry)
+		if err != channelserver.ErrAccountOnline || !time.Now().Before(deadline) {
+			return err
+		}
+		time.Sleep(restoreRetryInterval)
+	}
+}
+
+func (s *Server) serveSavedataBackups(w http.ResponseWriter, r *http.Request) {
+	charID := parseCharID(r)
+	backups, err := s.savedata.SavedataBackups(charID)
+	if err != nil {
+		s.logger.Error("Failed to get savedata backups", zap.Error(err), zap.Uint32("charID", charID))
+		http.Error(w, "failed to get savedata backups", http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, backups)
+}
+
+// serveSaveAnomalies lists the saves flagged for a change in savedata size, of the character in the
+// path or of every character.
+func (s *Server) serveSaveAnomalies(w http.ResponseWriter, r *http.Request) {
+	charID := parseCharID(r)
+	anomalies, err := s.savedata.SaveAnomalies(charID)
+	if err != nil {
+		s.logger.Error("Failed to get save anomalies", zap.Error(err), zap.Uint32("charID", charID))
+		http.Error(w, "failed to get save anomalies", http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, anomalies)
+}
+
+// serveRestoreSavedata restores a backup of the character's savedata, refusing while the account is
+// online unless forced: the client holds its savedata and saves it over the restored one. A forced
+// restore disconnects the character first and waits for its logout save.
+func (s *Server) serveRestoreSavedata(w http.ResponseWriter, r *http.Request) {
+	charID := parseCharID(r)
+	var req restoreSavedataRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.BackupID == 0 {
+		http.Error(w, "invalid restore request", http.StatusBadRequest)
+		return
+	}
+	if req.IssuedBy == "" {
+		req.IssuedBy = "admin API"
+	}
+	entry := auditEntry{
+		CharID:   charID,
+		Action:   "restore savedata",
+		Detail:   fmt.Sprintf("backup %d", req.BackupID),
+		IssuedBy: req.IssuedBy,
+	}
+	err := s.savedata.RestoreSavedata(charID, req.BackupID, entry)
+	online := err == channelserver.ErrAccountOnline
+	if online && req.Force {
+		entry.Detail += ", forced while online"
+		err = s.restoreAfterLogout(charID, req.BackupID, entry)
+	}
+	switch {
+	case err == channelserver.ErrAccountOnline && !req.Force:
+		http.Error(w, "the character is online, restore once it has logged out or force the restore", http.StatusConflict)
+		return
+	case err == channelserver.ErrAccountOnline:
+		http.Error(w, "the character didn't log out in time, try again", http.StatusConflict)
+		return
+	case err == errBackupNotFound || err == errCharacterNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to restore savedata", zap.Error(err), zap.Uint32("charID", charID))
+		http.Error(w, "failed to restore savedata", http.StatusInternalServerError)
+		return
+	}
+	s.logger.Info("Restored savedata",
+		zap.Uint32("charID", charID),
+		zap.Uint32("backupID", req.BackupID),
+		zap.Bool("forced", online),
+		zap.String("issuedBy", req.IssuedBy),
+	)
+	s.writeJSON(w, map[string]bool{"restored": true})
+}
@@ -0,0 +1,185 @@
+package adminserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/common/testdb"
+	"github.com/Solenataris/Erupe/server/channelserver"
+)
+
+// fakeSavedataStore holds each character's savedata and its backups by ID. Signing an online character out
+// logs it out, unless it's stuck.
+type fakeSavedataStore struct {
+	savedata  map[uint32][]byte
+	backups   map[uint32]map[uint32][]byte
+	online    map[uint32]bool
+	stuck     map[uint32]bool
+	signedOut []uint32
+	journal   []auditEntry
+	anomalies []saveAnomaly
+}
+
+func (f *fakeSavedataStore) SavedataBackups(charID uint32) ([]savedataBackup, error) {
+	backups := []savedataBackup{}
+	for id, data := range f.backups[charID] {
+		backups = append(backups, savedataBackup{ID: id, Kind: "snapshot", Size: len(data)})
+	}
+	return backups, nil
+}
+
+func (f *fakeSavedataStore) RestoreSavedata(charID uint32, backupID uint32, entry auditEntry) error {
+	if f.online[charID] {
+		return channelserver.ErrAccountOnline
+	}
+	data, ok := f.backups[charID][backupID]
+	if !ok {
+		return errBackupNotFound
+	}
+	f.savedata[charID] = data
+	f.journal = append(f.journal, entry)
+	return nil
+}
+
+func (f *fakeSavedataStore) SignOut(charID uint32) error {
+	f.signedOut = append(f.signedOut, charID)
+	if !f.stuck[charID] {
+		delete(f.online, charID)
+	}
+	return nil
+}
+
+func (f *fakeSavedataStore) SaveAnomalies(charID uint32) ([]saveAnomaly, error) {
+	anomalies := []saveAnomaly{}
+	for _, anomaly := range f.anomalies {
+		if charID == 0 || anomaly.CharID == charID {
+			anomalies = append(anomalies, anomaly)
+		}
+	}
+	return anomalies, nil
+}
+
+func TestServeSaveAnomalies(t *testing.T) {
+	s, _, _ := newTestServer()
+	s.savedata = &fakeSavedataStore{anomalies: []saveAnomaly{
+		{ID: 2, CharID: 10, PreviousSize: 150000, Size: 4000, KeptPrevious: true},
+		{ID: 1, CharID: 11, PreviousSize: 150000, Size: 260000},
+	}}
+
+	var anomalies []saveAnomaly
+	w := doRequest(s, http.MethodGet, "/characters/10/savedata/anomalies", "", testToken)
+	if err := json.NewDecoder(w.Body).Decode(&anomalies); err != nil {
+		t.Fatal(err)
+	}
+	if len(anomalies) != 1 || anomalies[0].ID != 2 || !anomalies[0].KeptPrevious {
+		t.Errorf("got anomalies %+v of character 10", anomalies)
+	}
+	w = doRequest(s, http.MethodGet, "/savedata/anomalies", "", testToken)
+	if err := json.NewDecoder(w.Body).Decode(&anomalies); err != nil {
+		t.Fatal(err)
+	}
+	if len(anomalies) != 2 {
+		t.Errorf("got %d anomalies of all characters, want 2", len(anomalies))
+	}
+}
+
+func TestServeRestoreSavedata(t *testing.T) {
+	s, registry, _ := newTestServer()
+	store := &fakeSavedataStore{
+		savedata: map[uint32][]byte{1: {0xBA, 0xD0}, 2: {0xBA, 0xD0}, 10: {0xBA, 0xD0}},
+		backups:  map[uint32]map[uint32][]byte{1: {5: {0x60, 0x0D}}, 2: {7: {0x60, 0x0D}}, 10: {6: {0x60, 0x0D}}},
+		// Character 1 is online, on this process or another, character 2 doesn't log out.
+		online: map[uint32]bool{1: true, 2: true},
+		stuck:  map[uint32]bool{2: true},
+	}
+	s.savedata = store
+
+	if w := doRequest(s, http.MethodPost, "/characters/10/savedata/restore", `{"backupID": 5}`, testToken); w.Code != http.StatusNotFound {
+		t.Errorf("restoring another character's backup got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := doRequest(s, http.MethodPost, "/characters/10/savedata/restore", `{"backupID": 6, "issuedBy": "gm"}`, testToken); w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if !bytes.Equal(store.savedata[10], []byte{0x60, 0x0D}) {
+		t.Errorf("got savedata %x after the restore, want the backup's", store.savedata[10])
+	}
+	if len(store.journal) != 1 || store.journal[0].IssuedBy != "gm" || store.journal[0].Detail != "backup 6" {
+		t.Errorf("got journal %+v", store.journal)
+	}
+
+	if w := doRequest(s, http.MethodPost, "/characters/1/savedata/restore", `{"backupID": 5}`, testToken); w.Code != http.StatusConflict {
+		t.Errorf("restoring an online character got status %d, want %d", w.Code, http.StatusConflict)
+	}
+	if !bytes.Equal(store.savedata[1], []byte{0xBA, 0xD0}) {
+		t.Error("the savedata of an online character was restored")
+	}
+	if w := doRequest(s, http.MethodPost, "/characters/1/savedata/restore", `{"backupID": 5, "force": true}`, testToken); w.Code != http.StatusOK {
+		t.Fatalf("forced restore got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if !bytes.Equal(store.savedata[1], []byte{0x60, 0x0D}) || len(registry.kicked) != 1 || registry.kicked[0] != 1 || len(store.signedOut) != 1 {
+		t.Errorf("forced restore left savedata %x, kicked %v and signed out %v", store.savedata[1], registry.kicked, store.signedOut)
+	}
+	if last := store.journal[len(store.journal)-1]; last.Detail != "backup 5, forced while online" {
+		t.Errorf("the forced restore was journaled as %q", last.Detail)
+	}
+
+	// A character whose logout save isn't written in time isn't restored.
+	defer func(interval, timeout time.Duration) { restoreRetryInterval, restoreLogoutTimeout = interval, timeout }(restoreRetryInterval, restoreLogoutTimeout)
+	restoreRetryInterval, restoreLogoutTimeout = time.Millisecond, 10*time.Millisecond
+	if w := doRequest(s, http.MethodPost, "/characters/2/savedata/restore", `{"backupID": 7, "force": true}`, testToken); w.Code != http.StatusConflict {
+		t.Errorf("restoring a character that didn't log out got status %d, want %d", w.Code, http.StatusConflict)
+	}
+	if !bytes.Equal(store.savedata[2], []byte{0xBA, 0xD0}) {
+		t.Error("the savedata of a character that didn't log out was restored")
+	}
+}
+
+// TestRestoreSavedata runs against the database in ERUPE_TEST_DB, e.g.
+// "host=localhost user=postgres password=admin dbname=erupe_test sslmode=disable".
+func TestRestoreSavedata(t *testing.T) {
+	db := testdb.Open(t)
+
+	var userID, charID, backupID uint32
+	err := db.QueryRow("INSERT INTO users (username, password) VALUES ('restore_test', '') RETURNING id").Scan(&userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+	current, backup := []byte("current savedata"), []byte("backed up savedata")
+	err = db.QueryRow("INSERT INTO characters (user_id, is_new_character, name, savedata) VALUES ($1, false, 'restore', $2) RETURNING id", userID, current).Scan(&charID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM characters WHERE id = $1", charID)
+	defer db.Exec("DELETE FROM admin_audit_log WHERE character_id = $1", charID)
+	defer db.Exec("DELETE FROM savedata_backups WHERE char_id = $1", charID)
+	err = db.QueryRow("INSERT INTO savedata_backups (char_id, savedata, kind) VALUES ($1, $2, 'snapshot') RETURNING id", charID, backup).Scan(&backupID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := dbSavedataStore{db}
+	if err := store.RestoreSavedata(charID, backupID, auditEntry{CharID: charID, Action: "restore savedata"}); err != nil {
+		t.Fatal(err)
+	}
+	var got []byte
+	if err := db.QueryRow("SELECT savedata FROM characters WHERE id = $1", charID).Scan(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, backup) {
+		t.Errorf("got savedata %q after the restore, want %q", got, backup)
+	}
+	backups, err := store.SavedataBackups(charID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 || backups[0].Kind != "restore" || backups[0].Size != len(current) {
+		t.Errorf("got backups %+v, want the replaced savedata kept first", backups)
+	}
+	if err := store.RestoreSavedata(charID, backupID+1000, auditEntry{}); err != errBackupNotFound {
+		t.Errorf("restoring a missing backup got %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package adminserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Solenataris/Erupe/common/targeting"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// targetStore finds the characters a targeting filter picks.
+type targetStore interface {
+	Count(f *targeting.Filter) (int, error)
+	CharacterIDs(f *targeting.Filter) ([]uint32, error)
+}
+
+type dbTargetStore struct {
+	db *sqlx.DB
+}
+
+func (t dbTargetStore) Count(f *targeting.Filter) (int, error) {
+	return targeting.Count(t.db, f, time.Now())
+}
+
+func (t dbTargetStore) CharacterIDs(f *targeting.Filter) ([]uint32, error) {
+	return targeting.CharacterIDs(t.db, f, time.Now())
+}
+
+// parseTarget parses the filter of a request, answering a bad one. An empty filter is nil, picking everyone.
+func parseTarget(w http.ResponseWriter, target string) (*targeting.Filter, bool) {
+	if target == "" {
+		return nil, true
+	}
+	f, err := targeting.Parse(target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return f, true
+}
+
+type targetRequest struct {
+	Target string `json:"target"`
+}
+
+// servePreviewTarget counts the characters a filter picks, to check it before anything is sent with it.
+func (s *Server) servePreviewTarget(w http.ResponseWriter, r *http.Request) {
+	var req targetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Target == "" {
+		http.Error(w, "invalid target request", http.StatusBadRequest)
+		return
+	}
+	f, ok := parseTarget(w, req.Target)
+	if !ok {
+		return
+	}
+	count, err := s.targets.Count(f)
+	if err != nil {
+		s.logger.Error("Failed to count targeted characters", zap.String("target", req.Target), zap.Error(err))
+		http.Error(w, "failed to count characters", http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, map[string]int{"count": count})
+}
@@ -0,0 +1,90 @@
+package adminserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/Solenataris/Erupe/common/targeting"
+)
+
+type fakeTargetStore struct {
+	charIDs []uint32
+	filters []string
+}
+
+func (f *fakeTargetStore) Count(filter *targeting.Filter) (int, error) {
+	f.filters = append(f.filters, filter.String())
+	return len(f.charIDs), nil
+}
+
+func (f *fakeTargetStore) CharacterIDs(filter *targeting.Filter) ([]uint32, error) {
+	f.filters = append(f.filters, filter.String())
+	return f.charIDs, nil
+}
+
+func TestPreviewTarget(t *testing.T) {
+	s, _, _ := newTestServer()
+	targets := &fakeTargetStore{charIDs: []uint32{1, 2, 3}}
+	s.targets = targets
+
+	w := doRequest(s, http.MethodPost, "/targets/preview", `{"target": "gr >= 100 and last_login < 7d"}`, testToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var resp map[string]int
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp["count"] != 3 {
+		t.Errorf("got response %v, %v, want a count of 3", resp, err)
+	}
+
+	for _, body := range []string{`{}`, `{"target": "gr > 1 or 1=1"}`, `{"target": "name = 'x'"}`} {
+		if w := doRequest(s, http.MethodPost, "/targets/preview", body, testToken); w.Code != http.StatusBadRequest {
+			t.Errorf("%s got status %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+	}
+	if len(targets.filters) != 1 {
+		t.Errorf("invalid filters reached the store: %v", targets.filters)
+	}
+}
+
+func TestGrantTargeted(t *testing.T) {
+	s, _, _ := newTestServer()
+	grants := newTestGrants(t, s)
+	s.targets = &fakeTargetStore{charIDs: []uint32{1, 404, 7}}
+
+	w := doRequest(s, http.MethodPost, "/grants", `{"target": "courses has 6", "itemID": 1, "quantity": 3}`, testToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var resp map[string]int
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp["characters"] != 2 {
+		t.Errorf("got response %v, %v, want 2 characters", resp, err)
+	}
+	if len(grants.grants) != 2 || grants.grants[0].CharID != 1 || grants.grants[1].CharID != 7 || grants.grants[1].IssuedBy != "admin API" {
+		t.Errorf("got grants %v, want characters 1 and 7 with the deleted one skipped", grants.grants)
+	}
+
+	bad := []string{
+		`{"itemID": 1, "quantity": 3}`,
+		`{"target": "gr >", "itemID": 1, "quantity": 3}`,
+		`{"target": "gr > 1", "itemID": 9999, "quantity": 3}`,
+		`{"target": "gr > 1", "itemID": 1, "quantity": 500}`,
+	}
+	for _, body := range bad {
+		if w := doRequest(s, http.MethodPost, "/grants", body, testToken); w.Code != http.StatusBadRequest {
+			t.Errorf("%s got status %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+	}
+	if len(grants.grants) != 2 {
+		t.Errorf("invalid requests gave items: %v", grants.grants)
+	}
+
+	// A grant that fails sends none of the batch.
+	s.targets = &fakeTargetStore{charIDs: []uint32{1, 500, 7}}
+	if w := doRequest(s, http.MethodPost, "/grants", `{"target": "gr > 1", "itemID": 1, "quantity": 3}`, testToken); w.Code != http.StatusInternalServerError {
+		t.Errorf("a failed batch got status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if len(grants.grants) != 2 {
+		t.Errorf("a failed batch gave items: %v", grants.grants)
+	}
+}
@@ -0,0 +1,64 @@
+package adminserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Solenataris/Erupe/server/channelserver"
+	"go.uber.org/zap"
+)
+
+type traceRequest struct {
+	Minutes int `json:"minutes"` // How long to trace for, up to Admin.MaxTraceMinutes.
+}
+
+// serveStartTrace traces the character on every channel, so the trace follows it across channels.
+func (s *Server) serveStartTrace(w http.ResponseWriter, r *http.Request) {
+	charID := parseCharID(r)
+	var req traceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Minutes <= 0 {
+		http.Error(w, "invalid trace request", http.StatusBadRequest)
+		return
+	}
+	if max := s.erupeConfig.Admin.MaxTraceMinutes; max > 0 && req.Minutes > max {
+		req.Minutes = max
+	}
+
+	traces := []channelserver.PacketTraceInfo{}
+	for _, registry := range s.registries {
+		trace, err := registry.TracePackets(charID, time.Duration(req.Minutes)*time.Minute)
+		if err != nil {
+			s.logger.Error("Failed to start packet trace", zap.Error(err), zap.Uint32("charID", charID))
+			http.Error(w, "failed to start packet trace", http.StatusInternalServerError)
+			return
+		}
+		traces = append(traces, trace)
+	}
+	s.logger.Info("Tracing character's packets", zap.Uint32("charID", charID), zap.Int("minutes", req.Minutes))
+	s.writeJSON(w, traces)
+}
+
+func (s *Server) serveStopTrace(w http.ResponseWriter, r *http.Request) {
+	charID := parseCharID(r)
+	stopped := false
+	for _, registry := range s.registries {
+		if registry.StopTracingPackets(charID) {
+			stopped = true
+		}
+	}
+	if !stopped {
+		http.Error(w, "character isn't traced", http.StatusNotFound)
+		return
+	}
+	s.logger.Info("Stopped tracing character's packets", zap.Uint32("charID", charID))
+	s.writeJSON(w, map[string]bool{"stopped": true})
+}
+
+func (s *Server) serveTraces(w http.ResponseWriter, r *http.Request) {
+	traces := []channelserver.PacketTraceInfo{}
+	for _, registry := range s.registries {
+		traces = append(traces, registry.PacketTraces()...)
+	}
+	s.writeJSON(w, traces)
+}
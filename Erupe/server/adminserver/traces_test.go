@@ -0,0 +1,35 @@
+package adminserver
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServeTraces(t *testing.T) {
+	s, registry, _ := newTestServer()
+	s.erupeConfig.Admin.MaxTraceMinutes = 30
+
+	if w := doRequest(s, http.MethodPost, "/characters/1/trace", `{"minutes": 90}`, testToken); w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if d := registry.traces[1]; d != 30*time.Minute {
+		t.Errorf("got a %v trace, want it capped at 30m", d)
+	}
+	if w := doRequest(s, http.MethodPost, "/characters/2/trace", `{"minutes": 5}`, testToken); w.Code != http.StatusOK || len(registry.traces) != 2 {
+		t.Errorf("second trace got status %d, %d traces running", w.Code, len(registry.traces))
+	}
+	if w := doRequest(s, http.MethodPost, "/characters/3/trace", `{}`, testToken); w.Code != http.StatusBadRequest {
+		t.Errorf("trace without minutes got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := doRequest(s, http.MethodGet, "/traces", "", testToken); w.Code != http.StatusOK {
+		t.Errorf("listing traces got status %d, want %d", w.Code, http.StatusOK)
+	}
+
+	if w := doRequest(s, http.MethodDelete, "/characters/1/trace", "", testToken); w.Code != http.StatusOK {
+		t.Errorf("stopping trace got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := doRequest(s, http.MethodDelete, "/characters/1/trace", "", testToken); w.Code != http.StatusNotFound {
+		t.Errorf("stopping a stopped trace got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
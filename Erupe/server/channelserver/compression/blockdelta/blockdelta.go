@@ -0,0 +1,107 @@
+// Package blockdelta encodes a buffer as the fixed size blocks that differ from a base. A diff carries
+// checksums of the base it was taken against and of its result, so one applied to the wrong base or
+// damaged in storage is caught rather than producing a garbled buffer.
+package blockdelta
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// BlockSize is the size of the blocks compared, the last block of a buffer may be shorter.
+const BlockSize = 256
+
+var magic = [4]byte{'B', 'D', 'L', 1}
+
+// The header is the magic, the base checksum, the target length, the target checksum and the
+// block count, each block follows as its index and bytes.
+const headerSize = 4 + 4 + 4 + 4 + 4
+
+var (
+	// ErrCorrupt is returned for a diff that is malformed or whose result doesn't match its checksum.
+	ErrCorrupt = errors.New("blockdelta: corrupt diff")
+	// ErrWrongBase is returned for a diff taken against another base than the one given.
+	ErrWrongBase = errors.New("blockdelta: diff of another base")
+)
+
+// block returns the block i of data, nil past its end.
+func block(data []byte, i int) []byte {
+	start := i * BlockSize
+	if start >= len(data) {
+		return nil
+	}
+	end := start + BlockSize
+	if end > len(data) {
+		end = len(data)
+	}
+	return data[start:end]
+}
+
+func equal(a []byte, b []byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Diff returns the diff turning base into target.
+func Diff(base []byte, target []byte) []byte {
+	diff := make([]byte, headerSize, headerSize+BlockSize)
+	copy(diff, magic[:])
+	binary.BigEndian.PutUint32(diff[4:], crc32.ChecksumIEEE(base))
+	binary.BigEndian.PutUint32(diff[8:], uint32(len(target)))
+	binary.BigEndian.PutUint32(diff[12:], crc32.ChecksumIEEE(target))
+	var count uint32
+	index := make([]byte, 4)
+	for i := 0; i*BlockSize < len(target); i++ {
+		b := block(target, i)
+		if equal(b, block(base, i)) {
+			continue
+		}
+		binary.BigEndian.PutUint32(index, uint32(i))
+		diff = append(diff, index...)
+		diff = append(diff, b...)
+		count++
+	}
+	binary.BigEndian.PutUint32(diff[16:], count)
+	return diff
+}
+
+// Apply returns base with the diff applied, leaving base untouched.
+func Apply(base []byte, diff []byte) ([]byte, error) {
+	if len(diff) < headerSize || !equal(diff[:4], magic[:]) {
+		return nil, ErrCorrupt
+	}
+	if binary.BigEndian.Uint32(diff[4:]) != crc32.ChecksumIEEE(base) {
+		return nil, ErrWrongBase
+	}
+	length := int(binary.BigEndian.Uint32(diff[8:]))
+	sum := binary.BigEndian.Uint32(diff[12:])
+	count := binary.BigEndian.Uint32(diff[16:])
+
+	target := make([]byte, length)
+	copy(target, base)
+	rest := diff[headerSize:]
+	for n := uint32(0); n < count; n++ {
+		if len(rest) < 4 {
+			return nil, ErrCorrupt
+		}
+		i := int(binary.BigEndian.Uint32(rest))
+		b := block(target, i)
+		if b == nil || len(rest) < 4+len(b) {
+			return nil, ErrCorrupt
+		}
+		copy(b, rest[4:4+len(b)])
+		rest = rest[4+len(b):]
+	}
+	if len(rest) != 0 || crc32.ChecksumIEEE(target) != sum {
+		return nil, ErrCorrupt
+	}
+	return target, nil
+}
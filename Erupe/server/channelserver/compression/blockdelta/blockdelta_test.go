@@ -0,0 +1,75 @@
+package blockdelta
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func randomBytes(r *rand.Rand, n int) []byte {
+	data := make([]byte, n)
+	r.Read(data)
+	return data
+}
+
+func TestApplyRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	base := randomBytes(r, 10*BlockSize+17)
+	changed := append([]byte(nil), base...)
+	changed[3] ^= 0xFF
+	changed[5*BlockSize+100] ^= 0xFF
+	changed[len(changed)-1] ^= 0xFF
+
+	tests := []struct {
+		name   string
+		target []byte
+		blocks int
+	}{
+		{"unchanged", base, 0},
+		{"changed", changed, 3},
+		{"grown", append(append([]byte(nil), base...), randomBytes(r, 2*BlockSize)...), 3},
+		{"shrunk", base[:4*BlockSize+1], 1},
+		{"empty", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := Diff(base, tt.target)
+			if len(diff) > headerSize+tt.blocks*(4+BlockSize) {
+				t.Errorf("diff of %d bytes, want at most %d blocks", len(diff), tt.blocks)
+			}
+			got, err := Apply(base, diff)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.target) {
+				t.Error("applied diff doesn't give the target")
+			}
+		})
+	}
+	if base[3] == changed[3] {
+		t.Error("Apply changed the base")
+	}
+}
+
+func TestApplyDetectsCorruption(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	base := randomBytes(r, 8*BlockSize)
+	target := append([]byte(nil), base...)
+	target[2*BlockSize] ^= 0xFF
+	diff := Diff(base, target)
+
+	flipped := append([]byte(nil), diff...)
+	flipped[len(flipped)-1] ^= 0x01
+	if _, err := Apply(base, flipped); err != ErrCorrupt {
+		t.Errorf("diff with a flipped byte got %v, want %v", err, ErrCorrupt)
+	}
+	if _, err := Apply(base, diff[:len(diff)-10]); err != ErrCorrupt {
+		t.Errorf("truncated diff got %v, want %v", err, ErrCorrupt)
+	}
+	if _, err := Apply(base, diff[:5]); err != ErrCorrupt {
+		t.Errorf("diff cut in its header got %v, want %v", err, ErrCorrupt)
+	}
+	if _, err := Apply(target, diff); err != ErrWrongBase {
+		t.Errorf("diff applied to another base got %v, want %v", err, ErrWrongBase)
+	}
+}
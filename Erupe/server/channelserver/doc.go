@@ -0,0 +1,18 @@
+// Package channelserver implements the MHF channel server, where characters meet in stages and go on quests.
+//
+// Handlers run on one goroutine per session while timers, broadcasts and the Discord bot run on their own,
+// so every map shared between sessions is guarded by a lock and only reached through accessor methods such
+// as GetStage, stageList, getSemaphore and semaphoreList. The list accessors return a copy to walk without
+// holding the map lock.
+//
+// Locks must be taken in these orders, never the other way around:
+//
+//	Server.stagesLock -> Stage -> Session
+//	Raviente -> Server.semaphoreLock -> Semaphore -> Session
+//	Server.matchmaking -> Server.stagesLock -> Stage -> Session
+//
+// Only one stage or semaphore is locked at a time. The Server lock guarding the session map, the
+// userBinaryPartsLock, the packet trace set and the channel registry are taken on their own, copy
+// what's needed and unlock before taking any other lock.
+// Building with the lockorder tag checks the stage order at runtime, see sys_lockorder_debug.go.
+package channelserver
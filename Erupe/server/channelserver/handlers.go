@@ -1,21 +1,19 @@
 package channelserver
 
 import (
-	"bytes"
+	"database/sql"
 	"encoding/binary"
 	"encoding/hex"
-
 	"fmt"
-	"io/ioutil"
+
 	"math/bits"
 	"math/rand"
 	"time"
 
 	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/common/stringsupport"
 	"github.com/Solenataris/Erupe/network/mhfpacket"
 	"go.uber.org/zap"
-	"golang.org/x/text/encoding/japanese"
-	"golang.org/x/text/transform"
 )
 
 // Temporary function to just return no results for a MSG_MHF_ENUMERATE* packet
@@ -96,16 +94,13 @@ func updateRights(s *Session) {
 	s.QueueSendMHF(update)
 }
 
-func fixedSizeShiftJIS(text string, size int) []byte {
-	r := bytes.NewBuffer([]byte(text))
-	encoded, err := ioutil.ReadAll(transform.NewReader(r, japanese.ShiftJIS.NewEncoder()))
-	if err != nil {
-		panic(err)
-	}
+// Most bytes of a name, such as a character's, sent to the client behind a length byte counting its
+// null terminator.
+const nameMaxLength = 254
 
+func fixedSizeShiftJIS(text string, size int) []byte {
 	out := make([]byte, size)
-	copy(out, encoded)
-	out[len(out)-1] = 0
+	copy(out, stringsupport.EncodeShiftJIS(text, size-1))
 	return out
 }
 func handleMsgHead(s *Session, p mhfpacket.MHFPacket) {}
@@ -145,22 +140,92 @@ func handleMsgSysLogin(s *Session, p mhfpacket.MHFPacket) {
 	// 06 0A 0B = Boost Course, just actually 3 subs combined
 	// 08 09 1E = N Course, gives you the benefits of being in a netcafe (extra quests, N Points, daily freebies etc.) minimal and pointless
 	// 0C = N Boost course, ultra luxury course that ruins the game if in use
-	err := s.server.db.QueryRow("SELECT rights FROM users u INNER JOIN characters c ON u.id = c.user_id WHERE c.id = $1", pkt.CharID0).Scan(&rights)
+	// Turned away before the sign in token is used, so the client can take it to another channel.
+	if s.server.isDraining() {
+		s.logger.Info("Refused login to a draining channel", zap.Uint32("charID", pkt.CharID0))
+		doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+		return
+	}
+	start := time.Now()
+	var userID uint32
+	var moderator, admin bool
+	err := s.server.db.QueryRow("SELECT u.id, rights, moderator, admin FROM users u INNER JOIN characters c ON u.id = c.user_id WHERE c.id = $1 AND c.deleted_at IS NULL", pkt.CharID0).Scan(&userID, &rights, &moderator, &admin)
+	if err == sql.ErrNoRows {
+		s.logger.Info("Refused deleted character", zap.Uint32("charID", pkt.CharID0))
+		doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+		return
+	}
 	if err != nil {
 		panic(err)
 	}
-
-	s.server.db.QueryRow("SELECT name FROM characters WHERE id = $1", pkt.CharID0).Scan(&name)
+	ttl := time.Duration(s.server.erupeConfig.Sign.TokenTTL) * time.Second
+	accountLoaded := time.Now()
+	traceparent, err := checkSignToken(s.server.db, userID, pkt.LoginTokenNumber, pkt.LoginTokenString, ttl, time.Now())
+	// The token gives the trace of the sign in only once it's used, so the queries up to it are recorded after.
+	span := s.server.tracer.ContinueSince(traceparent, "channel login", start)
+	span.SetAttr("charID", pkt.CharID0)
+	span.Record("db: load account", start, accountLoaded, nil)
+	span.Record("db: check sign token", accountLoaded, time.Now(), err)
+	if err != nil {
+		s.logger.Info("Refused sign in token", zap.Uint32("charID", pkt.CharID0), zap.Error(err))
+		span.SetError(err)
+		span.End()
+		doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+		return
+	}
+	// Ended once the character enters the world, with the requests handled until then under it.
+	s.startLoginTrace(span)
+	// The sign server only turns away account bans, a banned character is refused here.
+	if banned, err := characterBanned(s.server.db, pkt.CharID0); err != nil || banned {
+		s.logger.Info("Refused banned character", zap.Uint32("charID", pkt.CharID0), zap.Error(err))
+		span.SetAttr("refused", "banned character")
+		s.endLoginTrace()
+		doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+		return
+	}
+	setup := span.Child("load character")
+	rights = applyNewcomerBoosts(s, userID, pkt.CharID0, rights)
+	rights = applyCatchUpBoosts(s, pkt.CharID0, rights)
+
+	var firstLogin sql.NullBool
+	var lastLogin int64
+	var forceRename bool
+	s.server.db.QueryRow("SELECT name, is_new_character, COALESCE(last_login, 0), force_rename FROM characters WHERE id = $1", pkt.CharID0).Scan(&name, &firstLogin, &lastLogin, &forceRename)
 	s.Lock()
 	s.Name = name
+	s.firstLogin = firstLogin.Bool
+	s.forceRename = forceRename
+	if lastLogin > 0 {
+		s.lastLogin = time.Unix(lastLogin, 0)
+	}
 	s.charID = pkt.CharID0
+	s.userID = userID
 	s.rights = rights
+	s.moderator = moderator || admin
+	s.admin = admin
+	s.loginTime = time.Now()
+	s.updateLogFields()
 	s.Unlock()
+	if err := markOnline(s); err == nil {
+		err = holdSignToken(s, pkt.LoginTokenNumber)
+	}
+	if err != nil {
+		s.logger.Error("Failed to mark the character online", zap.Error(err))
+		span.SetError(err)
+		s.endLoginTrace()
+		doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+		return
+	}
+	s.server.disconnectDuplicates(s)
+	expireRentals(s)
+	loadBlocklist(s)
+	loadMute(s)
+	awardDailySeasonPoints(s)
 	bf := byteframe.NewByteFrame()
 	bf.WriteUint32(uint32(Time_Current_Adjusted().Unix())) // Unix timestamp
 
 	if s.server.erupeConfig.DevModeOptions.ServerName != "" {
-		_, err := s.server.db.Exec("UPDATE servers SET current_players=$1 WHERE server_name=$2", uint32(len(s.server.sessions)), s.server.erupeConfig.DevModeOptions.ServerName)
+		_, err := s.server.db.Exec("UPDATE servers SET current_players=$1 WHERE server_name=$2", uint32(s.server.PlayerCount()), s.server.erupeConfig.DevModeOptions.ServerName)
 		if err != nil {
 			panic(err)
 		}
@@ -170,6 +235,7 @@ func handleMsgSysLogin(s *Session, p mhfpacket.MHFPacket) {
 	if err != nil {
 		panic(err)
 	}
+	setup.End()
 
 	doAckSimpleSucceed(s, pkt.AckHandle, bf.Data())
 }
@@ -179,33 +245,42 @@ func handleMsgSysLogout(s *Session, p mhfpacket.MHFPacket) {
 }
 
 func logoutPlayer(s *Session) {
+	s.logoutOnce.Do(func() {
+		defer close(s.loggedOut)
+		savePlayerOnLogout(s)
+		releaseSignToken(s)
+		markOffline(s)
+	})
+}
+
+func savePlayerOnLogout(s *Session) {
+	removeSessionFromSemaphore(s)
+	s.server.leaveMatchmaking(s)
+	cancelStageReservation(s)
+	dropPartyInvite(s)
 	if s.stage == nil {
 		return
 	}
+	s.server.beginSave()
+	defer s.server.endSave()
 
 	s.server.BroadcastMHF(&mhfpacket.MsgSysDeleteUser {
 		CharID: s.charID,
 	}, s)
 
+	s.server.Lock()
 	delete(s.server.sessions, s.rawConn)
+	playerCount := len(s.server.sessions)
+	s.server.Unlock()
 	s.rawConn.Close()
 
 	if s.server.erupeConfig.DevModeOptions.ServerName != "" {
-		_, err := s.server.db.Exec("UPDATE servers SET current_players=$1 WHERE server_name=$2", uint32(len(s.server.sessions)), s.server.erupeConfig.DevModeOptions.ServerName)
+		_, err := s.server.db.Exec("UPDATE servers SET current_players=$1 WHERE server_name=$2", uint32(playerCount), s.server.erupeConfig.DevModeOptions.ServerName)
 		if err != nil {
 			panic(err)
 		}
 	}
 
-	s.server.Lock()
-  for _, stage := range s.server.stages {
-    if _, exists := stage.reservedClientSlots[s.charID]; exists {
-      delete(stage.reservedClientSlots, s.charID)
-  	}
-	}
-	s.server.Unlock()
-
-	removeSessionFromSemaphore(s)
 	removeSessionFromStage(s)
 
 	var timePlayed int
@@ -213,15 +288,7 @@ func logoutPlayer(s *Session) {
 
 	timePlayed = (int(Time_Current_Adjusted().Unix()) - int(s.sessionStart)) + timePlayed
 
-	var rpGained int
-
-	if s.rights > 0x40000000 { // N Course
-		rpGained = timePlayed / 900
-		timePlayed = timePlayed % 900
-	} else {
-		rpGained = timePlayed / 1800
-		timePlayed = timePlayed % 1800
-	}
+	rpGained, timePlayed := playtimeRP(s, timePlayed)
 
 	_, err = s.server.db.Exec("UPDATE characters SET time_played = $1 WHERE id = $2", timePlayed, s.charID)
 	if err != nil {
@@ -243,6 +310,24 @@ func logoutPlayer(s *Session) {
 	}
 }
 
+// playtimeRP returns the RP earned for the playtime and the playtime left over towards the next RP.
+func playtimeRP(s *Session, timePlayed int) (int, int) {
+	var rpGained int
+
+	if s.rights > 0x40000000 { // N Course
+		rpGained = timePlayed / 900
+		timePlayed = timePlayed % 900
+	} else {
+		rpGained = timePlayed / 1800
+		timePlayed = timePlayed % 1800
+	}
+
+	if multiplier := s.server.erupeConfig.Current().Newcomer.RPMultiplier; s.isNewcomerBoosted() && multiplier > 1 {
+		rpGained = int(float64(rpGained) * multiplier)
+	}
+	return rpGained, timePlayed
+}
+
 func handleMsgSysSetStatus(s *Session, p mhfpacket.MHFPacket) {}
 
 func handleMsgSysPing(s *Session, p mhfpacket.MHFPacket) {
@@ -287,7 +372,13 @@ func handleMsgSysIssueLogkey(s *Session, p mhfpacket.MHFPacket) {
 func handleMsgSysRecordLog(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgSysRecordLog)
 	// remove a client returning to town from reserved slots to make sure the stage is hidden from board
-	delete(s.stage.reservedClientSlots, s.charID)
+	if stage := s.stage; stage != nil {
+		stage.Lock()
+		delete(stage.reservedClientSlots, s.charID)
+		acks := stage.grantQueuedReservationsLocked()
+		stage.Unlock()
+		sendReservationAcks(acks)
+	}
 	doAckSimpleSucceed(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
 }
 
@@ -414,97 +505,76 @@ func handleMsgMhfAcquireTitle(s *Session, p mhfpacket.MHFPacket) {}
 
 func handleMsgMhfEnumerateUnionItem(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfEnumerateUnionItem)
-	var boxContents []byte
-	bf := byteframe.NewByteFrame()
-	err := s.server.db.QueryRow("SELECT item_box FROM users, characters WHERE characters.id = $1 AND users.id = characters.user_id", int(s.charID)).Scan(&boxContents)
+	reply, err := s.itemBoxReply()
 	if err != nil {
 		s.logger.Fatal("Failed to get shared item box contents from db", zap.Error(err))
-	} else {
-		if len(boxContents) == 0 {
-			bf.WriteUint32(0x00)
-		} else {
-			amount := len(boxContents) / 4
-			bf.WriteUint16(uint16(amount))
-			bf.WriteUint32(0x00)
-			bf.WriteUint16(0x00)
-			for i := 0; i < amount; i++ {
-				bf.WriteUint32(binary.BigEndian.Uint32(boxContents[i*4 : i*4+4]))
-				if i+1 != amount {
-					bf.WriteUint64(0x00)
-				}
-			}
-		}
 	}
-	doAckBufSucceed(s, pkt.AckHandle, bf.Data())
+	doAckBufSucceed(s, pkt.AckHandle, reply)
 }
 
 func handleMsgMhfUpdateUnionItem(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfUpdateUnionItem)
-	// Get item cache from DB
-	var boxContents []byte
-	var oldItems []Item
-
-	err := s.server.db.QueryRow("SELECT item_box FROM users, characters WHERE characters.id = $1 AND users.id = characters.user_id", int(s.charID)).Scan(&boxContents)
+	oldItems, err := s.itemBox()
 	if err != nil {
 		s.logger.Fatal("Failed to get shared item box contents from db", zap.Error(err))
-	} else {
-		amount := len(boxContents) / 4
-		oldItems = make([]Item, amount)
-		for i := 0; i < amount; i++ {
-			oldItems[i].ItemId = binary.BigEndian.Uint16(boxContents[i*4 : i*4+2])
-			oldItems[i].Amount = binary.BigEndian.Uint16(boxContents[i*4+2 : i*4+4])
-		}
 	}
-
-	// Update item stacks
-	newItems := make([]Item, len(oldItems))
-	copy(newItems, oldItems)
-	for i := 0; i < int(pkt.Amount); i++ {
-		for j := 0; j <= len(oldItems); j++ {
-			if j == len(oldItems) {
-				var newItem Item
-				newItem.ItemId = pkt.Items[i].ItemId
-				newItem.Amount = pkt.Items[i].Amount
-				newItems = append(newItems, newItem)
-				break
-			}
-			if pkt.Items[i].ItemId == oldItems[j].ItemId {
-				newItems[j].Amount = pkt.Items[i].Amount
-				break
-			}
-		}
+	updates, violations, unlisted := checkUnionItems(s.server.items, oldItems, pkt.Items)
+	for _, update := range unlisted {
+		s.logger.Warn("Stored item box update of an item missing from the item table",
+			zap.Uint32("charID", s.charID),
+			zap.Uint16("itemID", update.ItemId),
+			zap.Uint16("amount", update.Amount),
+		)
 	}
-
-	// Delete empty item stacks
-	for i := len(newItems) - 1; i >= 0; i-- {
-		if int(newItems[i].Amount) == 0 {
-			copy(newItems[i:], newItems[i+1:])
-			newItems[len(newItems)-1] = make([]Item, 1)[0]
-			newItems = newItems[:len(newItems)-1]
-		}
-	}
-
-	// Create new item cache
-	bf := byteframe.NewByteFrame()
-	for i := 0; i < len(newItems); i++ {
-		bf.WriteUint16(newItems[i].ItemId)
-		bf.WriteUint16(newItems[i].Amount)
+	for _, violation := range violations {
+		s.logger.Warn("Refused item box update",
+			zap.Uint32("charID", s.charID),
+			zap.Uint16("itemID", violation.itemID),
+			zap.Uint16("amount", violation.amount),
+			zap.String("reason", violation.reason),
+		)
 	}
+	newItems := updateUnionItems(oldItems, updates)
 
 	// Upload new item cache
-	_, err = s.server.db.Exec("UPDATE users SET item_box = $1 FROM characters WHERE  users.id = characters.user_id AND characters.id = $2", bf.Data(), int(s.charID))
+	_, err = s.server.db.Exec("UPDATE users SET item_box = $1 FROM characters WHERE  users.id = characters.user_id AND characters.id = $2", EncodeItemBox(newItems), int(s.charID))
 	if err != nil {
 		s.logger.Fatal("Failed to update shared item box contents in db", zap.Error(err))
 	}
+	s.setItemBox(newItems)
+	if len(violations) > 0 {
+		doAckSimpleFail(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
+		return
+	}
 	doAckSimpleSucceed(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
 }
 
+// DecodeItemBox decodes the shared item box stored on the account, a list of item ID and amount pairs.
+func DecodeItemBox(data []byte) []Item {
+	items := make([]Item, len(data)/4)
+	for i := range items {
+		items[i].ItemId = binary.BigEndian.Uint16(data[i*4 : i*4+2])
+		items[i].Amount = binary.BigEndian.Uint16(data[i*4+2 : i*4+4])
+	}
+	return items
+}
+
+// EncodeItemBox encodes the shared item box for storing on the account.
+func EncodeItemBox(items []Item) []byte {
+	bf := byteframe.NewByteFrame()
+	for _, item := range items {
+		bf.WriteUint16(item.ItemId)
+		bf.WriteUint16(item.Amount)
+	}
+	return bf.Data()
+}
+
 func handleMsgMhfAcquireCafeItem(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfAcquireCafeItem)
-	var netcafe_points int
-	err := s.server.db.QueryRow("UPDATE characters SET netcafe_points = netcafe_points - $1 WHERE id = $2 RETURNING netcafe_points", pkt.PointCost, s.charID).Scan(&netcafe_points)
-	if err != nil {
-		s.logger.Fatal("Failed to get plate data savedata from db", zap.Error(err))
+	netcafe_points, ok := addPoints(s, CurrencyNetcafe, -int64(pkt.PointCost), "cafe item")
+	if !ok {
+		doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+		return
 	}
 	resp := byteframe.NewByteFrame()
 	resp.WriteUint32(uint32(netcafe_points))
@@ -539,19 +609,13 @@ func handleMsgMhfCheckDailyCafepoint(s *Session, p mhfpacket.MHFPacket) {
 		midday = midday.Add(24 * time.Hour)
 	}
 
-	// get time after which daily claiming would be valid from db
-	var dailyTime time.Time
-	err := s.server.db.QueryRow("SELECT COALESCE(daily_time, $2) FROM characters WHERE id = $1", s.charID, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)).Scan(&dailyTime)
+	claimed, err := claimDailyCafePoints(s.server.db, s.charID, t, midday)
 	if err != nil {
-		s.logger.Fatal("Failed to get daily_time savedata from db", zap.Error(err))
+		s.logger.Error("Failed to claim the daily cafe points", zap.Error(err))
+		doAckBufFail(s, pkt.AckHandle, make([]byte, 4))
+		return
 	}
-
-	if t.After(dailyTime) {
-		// +5 netcafe points and setting next valid window
-		_, err := s.server.db.Exec("UPDATE characters SET daily_time=$1, netcafe_points=netcafe_points::int + 5 WHERE id=$2", midday, s.charID)
-		if err != nil {
-			s.logger.Fatal("Failed to update daily_time and netcafe_points savedata in db", zap.Error(err))
-		}
+	if claimed {
 		doAckBufSucceed(s, pkt.AckHandle, []byte{0x01, 0x00, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01})
 	} else {
 		doAckBufSucceed(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
@@ -580,124 +644,63 @@ func handleMsgMhfCheckWeeklyStamp(s *Session, p mhfpacket.MHFPacket) {
 
 func handleMsgMhfExchangeWeeklyStamp(s *Session, p mhfpacket.MHFPacket) {}
 
+// Slots of the gook table, gook0 to gook5 and their statuses.
+const gookSlots = 6
+
 func handleMsgMhfEnumerateGuacot(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfEnumerateGuacot)
-	var data bool
-	err := s.server.db.QueryRow("SELECT gook0status FROM gook WHERE id = $1", s.charID).Scan(&data)
-	if err == nil {
-		tempresp := byteframe.NewByteFrame()
-		count := uint16(0)
-		var gook0 []byte
-		var gook1 []byte
-		var gook2 []byte
-		var gook3 []byte
-		var gook4 []byte
-		var gook5 []byte
-		var gook0status bool
-		var gook1status bool
-		var gook2status bool
-		var gook3status bool
-		var gook4status bool
-		var gook5status bool
-		_ = s.server.db.QueryRow("SELECT gook0 FROM gook WHERE id = $1", s.charID).Scan(&gook0)
-		_ = s.server.db.QueryRow("SELECT gook1 FROM gook WHERE id = $1", s.charID).Scan(&gook1)
-		_ = s.server.db.QueryRow("SELECT gook2 FROM gook WHERE id = $1", s.charID).Scan(&gook2)
-		_ = s.server.db.QueryRow("SELECT gook3 FROM gook WHERE id = $1", s.charID).Scan(&gook3)
-		_ = s.server.db.QueryRow("SELECT gook4 FROM gook WHERE id = $1", s.charID).Scan(&gook4)
-		_ = s.server.db.QueryRow("SELECT gook5 FROM gook WHERE id = $1", s.charID).Scan(&gook5)
-		_ = s.server.db.QueryRow("SELECT gook0status FROM gook WHERE id = $1", s.charID).Scan(&gook0status)
-		_ = s.server.db.QueryRow("SELECT gook1status FROM gook WHERE id = $1", s.charID).Scan(&gook1status)
-		_ = s.server.db.QueryRow("SELECT gook2status FROM gook WHERE id = $1", s.charID).Scan(&gook2status)
-		_ = s.server.db.QueryRow("SELECT gook3status FROM gook WHERE id = $1", s.charID).Scan(&gook3status)
-		_ = s.server.db.QueryRow("SELECT gook4status FROM gook WHERE id = $1", s.charID).Scan(&gook4status)
-		_ = s.server.db.QueryRow("SELECT gook5status FROM gook WHERE id = $1", s.charID).Scan(&gook5status)
-		if gook0status == true {
-			count++
-			tempresp.WriteBytes(gook0)
-		}
-		if gook1status == true {
-			count++
-			tempresp.WriteBytes(gook1)
-		}
-		if gook2status == true {
-			count++
-			tempresp.WriteBytes(gook2)
-		}
-		if gook3status == true {
-			count++
-			tempresp.WriteBytes(gook3)
-		}
-		if gook4status == true {
-			count++
-			tempresp.WriteBytes(gook4)
-		}
-		if gook5status == true {
+	var gooks [gookSlots][]byte
+	var statuses [gookSlots]bool
+	err := s.server.db.QueryRow(`
+		SELECT gook0, gook1, gook2, gook3, gook4, gook5,
+			COALESCE(gook0status, false), COALESCE(gook1status, false), COALESCE(gook2status, false),
+			COALESCE(gook3status, false), COALESCE(gook4status, false), COALESCE(gook5status, false)
+		FROM gook WHERE id = $1
+	`, s.charID).Scan(&gooks[0], &gooks[1], &gooks[2], &gooks[3], &gooks[4], &gooks[5],
+		&statuses[0], &statuses[1], &statuses[2], &statuses[3], &statuses[4], &statuses[5])
+	if err != nil {
+		doAckBufSucceed(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
+		return
+	}
+	tempresp := byteframe.NewByteFrame()
+	count := uint16(0)
+	for i := range gooks {
+		if statuses[i] {
 			count++
-			tempresp.WriteBytes(gook5)
-		}
-		if count == uint16(0) {
-			doAckBufSucceed(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
-		} else {
-			resp := byteframe.NewByteFrame()
-			resp.WriteUint16(count)
-			resp.WriteBytes(tempresp.Data())
-			doAckBufSucceed(s, pkt.AckHandle, resp.Data())
+			tempresp.WriteBytes(gooks[i])
 		}
-	} else {
+	}
+	if count == uint16(0) {
 		doAckBufSucceed(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
+	} else {
+		resp := byteframe.NewByteFrame()
+		resp.WriteUint16(count)
+		resp.WriteBytes(tempresp.Data())
+		doAckBufSucceed(s, pkt.AckHandle, resp.Data())
 	}
 }
 
 func handleMsgMhfUpdateGuacot(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfUpdateGuacot)
 	count := int(pkt.EntryCount)
-	fmt.Printf("handleMsgMhfUpdateGuacot:%d\n", count)
+	s.logger.Debug("Updating guacot", zap.Int("entries", count))
 	if count == 0 {
 		_, err := s.server.db.Exec("INSERT INTO gook(id,gook0status,gook1status,gook2status,gook3status,gook4status,gook5status) VALUES($1,bool(false),bool(false),bool(false),bool(false),bool(false),bool(false))", s.charID)
 		if err != nil {
-			fmt.Printf("INSERT INTO gook failure\n")
+			s.logger.Error("Failed to insert gook", zap.Error(err))
 		}
 	} else {
 		for i := 0; i < int(pkt.EntryCount); i++ {
-			gookindex := int(pkt.Entries[i].Unk0)
+			// The entry's first field is the slot, the table has gook0 to gook5.
+			gookindex := pkt.Entries[i].Unk0
+			if gookindex >= gookSlots {
+				s.logger.Warn("Refused guacot update of an unknown slot", zap.Uint32("slot", gookindex))
+				continue
+			}
 			buf := pkt.GuacotUpdateEntryToBytes(pkt.Entries[i])
-			//fmt.Printf("gookindex:%d\n", gookindex)
-			switch gookindex {
-			case 0:
-				s.server.db.Exec("UPDATE gook SET gook0 = $1 WHERE id = $2", buf, s.charID)
-				if pkt.Entries[i].Unk1 != uint16(0) {
-					s.server.db.Exec("UPDATE gook SET gook0status = $1 WHERE id = $2", bool(true), s.charID)
-				} else {
-					s.server.db.Exec("UPDATE gook SET gook0status = $1 WHERE id = $2", bool(false), s.charID)
-				}
-			case 1:
-				s.server.db.Exec("UPDATE gook SET gook1 = $1 WHERE id = $2", buf, s.charID)
-				if pkt.Entries[i].Unk1 != uint16(0) {
-					s.server.db.Exec("UPDATE gook SET gook1status = $1 WHERE id = $2", bool(true), s.charID)
-				} else {
-					s.server.db.Exec("UPDATE gook SET gook1status = $1 WHERE id = $2", bool(false), s.charID)
-				}
-			case 2:
-				s.server.db.Exec("UPDATE gook SET gook2 = $1 WHERE id = $2", buf, s.charID)
-				if pkt.Entries[i].Unk1 != uint16(0) {
-					s.server.db.Exec("UPDATE gook SET gook2status = $1 WHERE id = $2", bool(true), s.charID)
-				} else {
-					s.server.db.Exec("UPDATE gook SET gook2status = $1 WHERE id = $2", bool(false), s.charID)
-				}
-			case 3:
-				s.server.db.Exec("UPDATE gook SET gook3 = $1 WHERE id = $2", buf, s.charID)
-				if pkt.Entries[i].Unk1 != uint16(0) {
-					s.server.db.Exec("UPDATE gook SET gook3status = $1 WHERE id = $2", bool(true), s.charID)
-				} else {
-					s.server.db.Exec("UPDATE gook SET gook3status = $1 WHERE id = $2", bool(false), s.charID)
-				}
-			case 4:
-				s.server.db.Exec("UPDATE gook SET gook4 = $1 WHERE id = $2", buf, s.charID)
-				if pkt.Entries[i].Unk1 != uint16(0) {
-					s.server.db.Exec("UPDATE gook SET gook4status = $1 WHERE id = $2", bool(true), s.charID)
-				} else {
-					s.server.db.Exec("UPDATE gook SET gook4status = $1 WHERE id = $2", bool(false), s.charID)
-				}
+			query := fmt.Sprintf("UPDATE gook SET gook%[1]d = $1, gook%[1]dstatus = $2 WHERE id = $3", gookindex)
+			if _, err := s.server.db.Exec(query, buf, pkt.Entries[i].Unk1 != 0, s.charID); err != nil {
+				s.logger.Error("Failed to update guacot", zap.Uint32("slot", gookindex), zap.Error(err))
 			}
 		}
 	}
@@ -1712,8 +1715,8 @@ func handleMsgMhfUseUdShopCoin(s *Session, p mhfpacket.MHFPacket) {}
 func handleMsgMhfGetEnhancedMinidata(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfGetEnhancedMinidata)
 	// this looks to be the detailed chunk of information you can pull up on players in town
-	var data []byte
-	err := s.server.db.QueryRow("SELECT minidata FROM characters WHERE id = $1", pkt.CharID).Scan(&data)
+	// Usually prefetched with the rest of the stage's members on entering it.
+	data, err := s.server.appearance.minidata(pkt.CharID)
 	if err != nil {
 		data = make([]byte, 0x400) // returning empty might avoid a client softlock
 		//s.logger.Fatal("Failed to get minidata from db", zap.Error(err))
@@ -1727,6 +1730,10 @@ func handleMsgMhfSetEnhancedMinidata(s *Session, p mhfpacket.MHFPacket) {
 	if err != nil {
 		s.logger.Fatal("Failed to update minidata in db", zap.Error(err))
 	}
+	s.Lock()
+	s.minidata = pkt.RawDataPayload
+	s.Unlock()
+	s.server.appearance.set(s.charID, pkt.RawDataPayload)
 	doAckSimpleSucceed(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
 }
 
@@ -1752,10 +1759,17 @@ func handleMsgMhfGetTrendWeapon(s *Session, p mhfpacket.MHFPacket) {
 	// ED 05 0F 4C 05 0F F2 06 3A FE 06 41 E8 06 41 FA 07 3B 02 07 3F ED 07 40
 	// 24 08 3D 37 08 3F 66 08 41 EC 09 3D 38 09 3F 8A 09 41 EE 0A 0E 78 0A 0F
 	// AA 0A 0F F9 0B 3E 2E 0B 41 EF 0B 42 FB 0C 41 F0 0C 43 3F 0C 43 EE 0D 41 F1 0D 42 10 0D 42 3C 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00
-	doAckBufSucceed(s, pkt.AckHandle, make([]byte, 0xA9))
+	s.deferAck(pkt.AckHandle, func() ([]byte, error) {
+		weapons, err := getTrendWeapons(s)
+		if err != nil {
+			return nil, err
+		}
+		return buildTrendWeapons(weapons), nil
+	})
 }
 
 func handleMsgMhfUpdateUseTrendWeaponLog(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfUpdateUseTrendWeaponLog)
+	recordTrendWeaponUse(s, pkt.WeaponType, pkt.WeaponID)
 	doAckSimpleSucceed(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
 }
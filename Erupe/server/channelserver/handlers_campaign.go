@@ -1,6 +1,9 @@
 package channelserver
 
-import "github.com/Solenataris/Erupe/network/mhfpacket"
+import (
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"go.uber.org/zap"
+)
 
 func handleMsgMhfEnumerateCampaign(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfEnumerateCampaign)
@@ -12,7 +15,22 @@ func handleMsgMhfStateCampaign(s *Session, p mhfpacket.MHFPacket) {
 	doAckSimpleFail(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
 }
 
+// handleMsgMhfApplyCampaign redeems the code typed in the campaign menu, whose items go to the distribution counter.
 func handleMsgMhfApplyCampaign(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfApplyCampaign)
+	items, err := redeemCampaignCode(s.server.db, s.charID, pkt.Code)
+	switch err {
+	case nil:
+		s.logger.Info("Redeemed campaign code", zap.String("code", pkt.Code), zap.Int("items", len(items)))
+		doAckSimpleSucceed(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
+		sendServerChatMessage(s, "Code redeemed, collect the items from the distribution counter.")
+		return
+	case ErrCampaignCodeInvalid:
+		sendServerChatMessage(s, "That code doesn't exist or can't be redeemed anymore.")
+	case ErrCampaignCodeRedeemed:
+		sendServerChatMessage(s, "Your account already redeemed that code.")
+	default:
+		s.logger.Error("Failed to redeem campaign code", zap.Error(err), zap.String("code", pkt.Code))
+	}
 	doAckSimpleFail(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
 }
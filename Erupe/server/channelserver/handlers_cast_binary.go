@@ -4,10 +4,12 @@ import (
 	"fmt"
 	"strings"
 	"math"
+	"time"
 
 	"github.com/Andoryuuta/byteframe"
 	"github.com/Solenataris/Erupe/network/binpacket"
 	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"go.uber.org/zap"
 )
 
 // MSG_SYS_CAST[ED]_BINARY types enum
@@ -48,6 +50,33 @@ func sendServerChatMessage(s *Session, message string) {
 	s.QueueSendMHF(castedBin)
 }
 
+// parseCastBinary takes the payload to forward out of a casted binary, with its targets if it's targeted,
+// and parses it if it's a chat message. Lengths are the client's, a malformed binary fails with an error.
+func parseCastBinary(pkt *mhfpacket.MsgSysCastBinary) ([]byte, *binpacket.MsgBinTargeted, *binpacket.MsgBinChat, error) {
+	payload := pkt.RawDataPayload
+	var targeted *binpacket.MsgBinTargeted
+	if pkt.BroadcastType == BroadcastTypeTargeted {
+		targeted = &binpacket.MsgBinTargeted{}
+		if err := targeted.Parse(byteframe.NewByteFrameFromBytes(payload)); err != nil {
+			return nil, nil, nil, err
+		}
+		payload = targeted.RawDataPayload
+	}
+	if pkt.MessageType != BinaryMessageTypeChat {
+		return payload, targeted, nil, nil
+	}
+
+	bf := byteframe.NewByteFrameFromBytes(payload)
+	// IMPORTANT! Casted binary objects are sent _as they are in memory_,
+	// this means little endian for LE CPUs, might be different for PS3/PS4/PSP/XBOX.
+	bf.SetLE()
+	chat := &binpacket.MsgBinChat{}
+	if err := chat.Parse(bf); err != nil {
+		return nil, nil, nil, err
+	}
+	return payload, targeted, chat, nil
+}
+
 func handleMsgSysCastBinary(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgSysCastBinary)
 
@@ -61,22 +90,35 @@ func handleMsgSysCastBinary(s *Session, p mhfpacket.MHFPacket) {
     }
   }
 
-	// Parse out the real casted binary payload
-	var realPayload []byte
-	var msgBinTargeted *binpacket.MsgBinTargeted
-	if pkt.BroadcastType == BroadcastTypeTargeted {
-		bf := byteframe.NewByteFrameFromBytes(pkt.RawDataPayload)
-		msgBinTargeted = &binpacket.MsgBinTargeted{}
-		err := msgBinTargeted.Parse(bf)
-
-		if err != nil {
-			s.logger.Warn("Failed to parse targeted cast binary")
+	realPayload, msgBinTargeted, chatMessage, err := parseCastBinary(pkt)
+	if err != nil {
+		s.logger.Warn("Dropped malformed cast binary", zap.Uint8("broadcastType", pkt.BroadcastType), zap.Uint8("messageType", pkt.MessageType), zap.Error(err))
+		return
+	}
+	if chatMessage != nil {
+		if !s.allowChat(time.Now()) || s.muted(time.Now()) {
+			return
+		}
+		// An announcement is masked and checked as one before the chat filter could block it, and never
+		// sent on as chat.
+		if strings.HasPrefix(chatMessage.Message, "!announce") {
+			handleGuildAnnounceCommand(s, chatMessage.Message)
+			return
+		}
+		send, masked := filterChat(s, chatMessage)
+		if !send {
+			return
+		}
+		if masked {
+			bf := byteframe.NewByteFrame()
+			bf.SetLE()
+			chatMessage.Build(bf)
+			realPayload = bf.Data()
+		}
+		// A command is run before anything is sent, and never reaches the stage, the party or Discord.
+		if strings.HasPrefix(chatMessage.Message, "!") && handleChatCommand(s, chatMessage) {
 			return
 		}
-
-		realPayload = msgBinTargeted.RawDataPayload
-	} else {
-		realPayload = pkt.RawDataPayload
 	}
 
 	// Make the response to forward to the other client(s).
@@ -88,150 +130,170 @@ func handleMsgSysCastBinary(s *Session, p mhfpacket.MHFPacket) {
 	}
 
 	// Send to the proper recipients.
-	switch pkt.BroadcastType {
-	case BroadcastTypeWorld:
+	switch {
+	case isPartyChat(pkt, realPayload):
+		sendPartyChat(s, resp)
+	case pkt.BroadcastType == BroadcastTypeWorld:
 		s.server.BroadcastMHF(resp, s)
-	case BroadcastTypeStage:
-		s.stage.BroadcastMHF(resp, s)
-	case BroadcastTypeSemaphore:
+	case pkt.BroadcastType == BroadcastTypeStage:
+		if stage := s.currentStage(); stage != nil {
+			stage.BroadcastMHF(resp, s)
+		}
+	case pkt.BroadcastType == BroadcastTypeSemaphore:
 		if pkt.MessageType == 1 {
-			var session *Semaphore
-			if _, exists := s.server.semaphore["hs_l0u3B51J9k3"]; exists {
-				session = s.server.semaphore["hs_l0u3B51J9k3"]
-			} else if _, exists := s.server.semaphore["hs_l0u3B5129k3"]; exists {
-				session = s.server.semaphore["hs_l0u3B5129k3"]
-			} else if _, exists := s.server.semaphore["hs_l0u3B512Ak3"]; exists {
-				session = s.server.semaphore["hs_l0u3B512Ak3"]
-			}
-			(*session).BroadcastMHF(resp, s)
-		} else {
-			s.Lock()
-			haveStage := s.stage != nil
-			if haveStage {
-				s.stage.BroadcastMHF(resp, s)
+			if semaphore := s.server.raviSemaphore(); semaphore != nil {
+				semaphore.BroadcastMHF(resp, s)
 			}
-			s.Unlock()
+		} else if stage := s.currentStage(); stage != nil {
+			stage.BroadcastMHF(resp, s)
 		}
-	case BroadcastTypeTargeted:
+	case pkt.BroadcastType == BroadcastTypeTargeted:
+		// Guild and alliance chat reach the other channels through the chat bus, once per channel.
+		relayed := s.server.chatBus != nil && pkt.MessageType == BinaryMessageTypeChat && relaysChatType(chatTypeOf(realPayload))
 		for _, targetID := range (*msgBinTargeted).TargetCharIDs {
-			char := s.server.FindSessionByCharID(targetID)
+			var char *Session
+			if relayed {
+				char = s.server.FindSessionByCharID(targetID)
+			} else {
+				char = s.server.findSessionOnAnyChannel(targetID)
+			}
 
 			if char != nil {
 				char.QueueSendMHF(resp)
 			}
 		}
+		if relayed && len(msgBinTargeted.TargetCharIDs) > 0 {
+			s.server.relayChat(s, chatMessage.SenderName, false, msgBinTargeted.TargetCharIDs, realPayload)
+		}
 	default:
-		s.Lock()
-		haveStage := s.stage != nil
-		if haveStage {
-			s.stage.BroadcastMHF(resp, s)
+		if stage := s.currentStage(); stage != nil {
+			stage.BroadcastMHF(resp, s)
 		}
-		s.Unlock()
 	}
 
 	// Handle chat
-	if pkt.MessageType == BinaryMessageTypeChat {
-		bf := byteframe.NewByteFrameFromBytes(realPayload)
-
-		// IMPORTANT! Casted binary objects are sent _as they are in memory_,
-		// this means little endian for LE CPUs, might be different for PS3/PS4/PSP/XBOX.
-		bf.SetLE()
-
-		chatMessage := &binpacket.MsgBinChat{}
-		chatMessage.Parse(bf)
-
-		fmt.Printf("Got chat message: %+v\n", chatMessage)
+	if chatMessage != nil {
+		s.logger.Debug("Got chat message", zap.Any("message", chatMessage))
 
 		// Discord integration
 		if chatMessage.Type == binpacket.ChatTypeLocal || chatMessage.Type == binpacket.ChatTypeParty {
 			s.server.DiscordChannelSend(chatMessage.SenderName, chatMessage.Message)
 		}
+	}
+}
 
-		// RAVI COMMANDS V2
-		if strings.HasPrefix(chatMessage.Message, "!ravi") {
-			if checkRaviSemaphore(s) {
-				s.server.raviente.Lock()
-				if !strings.HasPrefix(chatMessage.Message, "!ravi ") {
-					sendServerChatMessage(s, "No Raviente command specified!")
-				} else {
-					if strings.HasPrefix(chatMessage.Message, "!ravi start") {
-						if s.server.raviente.register.startTime == 0 {
-							s.server.raviente.register.startTime = s.server.raviente.register.postTime
-							sendServerChatMessage(s, "The Great Slaying will begin in a moment")
-							s.notifyall()
-						} else {
-							sendServerChatMessage(s, "The Great Slaying has already begun!")
-						}
-					} else if strings.HasPrefix(chatMessage.Message, "!ravi sm") || strings.HasPrefix(chatMessage.Message, "!ravi setmultiplier") {
-						var num uint16
-						n, numerr := fmt.Sscanf(chatMessage.Message, "!ravi sm %d", &num)
-						if numerr != nil || n != 1 {
-							sendServerChatMessage(s, "Error in command. Format: !ravi sm n")
-						} else if s.server.raviente.state.damageMultiplier == 1 {
-							if num > 65535 {
-								sendServerChatMessage(s, "Raviente multiplier too high, defaulting to 20x")
-								s.server.raviente.state.damageMultiplier = 65535
-							} else {
-								sendServerChatMessage(s, fmt.Sprintf("Raviente multiplier set to %dx", num))
-								s.server.raviente.state.damageMultiplier = uint32(num)
-							}
-						} else {
-							sendServerChatMessage(s, fmt.Sprintf("Raviente multiplier is already set to %dx!", s.server.raviente.state.damageMultiplier))
-						}
-					} else if strings.HasPrefix(chatMessage.Message, "!ravi cm") || strings.HasPrefix(chatMessage.Message, "!ravi checkmultiplier") {
-						sendServerChatMessage(s, fmt.Sprintf("Raviente multiplier is currently %dx", s.server.raviente.state.damageMultiplier))
-					} else if strings.HasPrefix(chatMessage.Message, "!ravi sr") || strings.HasPrefix(chatMessage.Message, "!ravi sendres") {
-						if s.server.raviente.state.stateData[28] > 0 {
-							sendServerChatMessage(s, "Sending resurrection support!")
-							s.server.raviente.state.stateData[28] = 0
+// handleChatCommand runs the command a chat message starting with "!" holds. It reports false if the
+// message isn't a command, so it's sent on as chat, and true if it ran one, which is never broadcast.
+func handleChatCommand(s *Session, chatMessage *binpacket.MsgBinChat) bool {
+	switch {
+	// RAVI COMMANDS V2
+	case strings.HasPrefix(chatMessage.Message, "!ravi"):
+		if checkRaviSemaphore(s) {
+			s.server.raviente.Lock()
+			if !strings.HasPrefix(chatMessage.Message, "!ravi ") {
+				sendServerChatMessage(s, "No Raviente command specified!")
+			} else {
+				if strings.HasPrefix(chatMessage.Message, "!ravi start") {
+					if s.server.raviente.register.startTime == 0 {
+						s.server.raviente.register.startTime = s.server.raviente.register.postTime
+						sendServerChatMessage(s, "The Great Slaying will begin in a moment")
+						s.notifyall()
+					} else {
+						sendServerChatMessage(s, "The Great Slaying has already begun!")
+					}
+				} else if strings.HasPrefix(chatMessage.Message, "!ravi sm") || strings.HasPrefix(chatMessage.Message, "!ravi setmultiplier") {
+					var num uint16
+					n, numerr := fmt.Sscanf(chatMessage.Message, "!ravi sm %d", &num)
+					if numerr != nil || n != 1 {
+						sendServerChatMessage(s, "Error in command. Format: !ravi sm n")
+					} else if s.server.raviente.state.damageMultiplier == 1 {
+						if num > 65535 {
+							sendServerChatMessage(s, "Raviente multiplier too high, defaulting to 20x")
+							s.server.raviente.state.damageMultiplier = 65535
 						} else {
-							sendServerChatMessage(s, "Resurrection support has not been requested!")
+							sendServerChatMessage(s, fmt.Sprintf("Raviente multiplier set to %dx", num))
+							s.server.raviente.state.damageMultiplier = uint32(num)
 						}
-					} else if strings.HasPrefix(chatMessage.Message, "!ravi ss") || strings.HasPrefix(chatMessage.Message, "!ravi sendsed") {
-						sendServerChatMessage(s, "Sending sedation support if requested!")
-						// Total BerRavi HP
-						HP := s.server.raviente.state.stateData[0] + s.server.raviente.state.stateData[1] + s.server.raviente.state.stateData[2] + s.server.raviente.state.stateData[3] + s.server.raviente.state.stateData[4]
-						s.server.raviente.support.supportData[1] = HP
-					} else if strings.HasPrefix(chatMessage.Message, "!ravi rs") || strings.HasPrefix(chatMessage.Message, "!ravi reqsed") {
-						sendServerChatMessage(s, "Requesting sedation support!")
-						// Total BerRavi HP
-						HP := s.server.raviente.state.stateData[0] + s.server.raviente.state.stateData[1] + s.server.raviente.state.stateData[2] + s.server.raviente.state.stateData[3] + s.server.raviente.state.stateData[4]
-						s.server.raviente.support.supportData[1] = HP + 12
 					} else {
-						sendServerChatMessage(s, "Raviente command not recognised!")
+						sendServerChatMessage(s, fmt.Sprintf("Raviente multiplier is already set to %dx!", s.server.raviente.state.damageMultiplier))
+					}
+				} else if strings.HasPrefix(chatMessage.Message, "!ravi cm") || strings.HasPrefix(chatMessage.Message, "!ravi checkmultiplier") {
+					sendServerChatMessage(s, fmt.Sprintf("Raviente multiplier is currently %dx", s.server.raviente.state.damageMultiplier))
+				} else if strings.HasPrefix(chatMessage.Message, "!ravi sr") || strings.HasPrefix(chatMessage.Message, "!ravi sendres") {
+					if s.server.raviente.state.stateData[28] > 0 {
+						sendServerChatMessage(s, "Sending resurrection support!")
+						s.server.raviente.state.stateData[28] = 0
+					} else {
+						sendServerChatMessage(s, "Resurrection support has not been requested!")
 					}
+				} else if strings.HasPrefix(chatMessage.Message, "!ravi ss") || strings.HasPrefix(chatMessage.Message, "!ravi sendsed") {
+					sendServerChatMessage(s, "Sending sedation support if requested!")
+					// Total BerRavi HP
+					HP := s.server.raviente.state.stateData[0] + s.server.raviente.state.stateData[1] + s.server.raviente.state.stateData[2] + s.server.raviente.state.stateData[3] + s.server.raviente.state.stateData[4]
+					s.server.raviente.support.supportData[1] = HP
+				} else if strings.HasPrefix(chatMessage.Message, "!ravi rs") || strings.HasPrefix(chatMessage.Message, "!ravi reqsed") {
+					sendServerChatMessage(s, "Requesting sedation support!")
+					// Total BerRavi HP
+					HP := s.server.raviente.state.stateData[0] + s.server.raviente.state.stateData[1] + s.server.raviente.state.stateData[2] + s.server.raviente.state.stateData[3] + s.server.raviente.state.stateData[4]
+					s.server.raviente.support.supportData[1] = HP + 12
+				} else {
+					sendServerChatMessage(s, "Raviente command not recognised!")
 				}
-			} else {
-				sendServerChatMessage(s, "No one has joined the Great Slaying!")
 			}
-			s.server.raviente.Unlock()
+		} else {
+			sendServerChatMessage(s, "No one has joined the Great Slaying!")
 		}
-		// END RAVI COMMANDS V2
-
-		if strings.HasPrefix(chatMessage.Message, "!tele ") {
-			var x, y int16
-			n, err := fmt.Sscanf(chatMessage.Message, "!tele %d %d", &x, &y)
-			if err != nil || n != 2 {
+		s.server.raviente.Unlock()
+	// END RAVI COMMANDS V2
+	case strings.HasPrefix(chatMessage.Message, "!note"):
+		handleGuildNoteCommand(s, chatMessage.Message)
+	case strings.HasPrefix(chatMessage.Message, "!season"):
+		handleSeasonCommand(s, chatMessage.Message)
+	case strings.HasPrefix(chatMessage.Message, "!slot"):
+		handleSlotCommand(s, chatMessage.Message)
+	case strings.HasPrefix(chatMessage.Message, "!world"):
+		handleWorldChatCommand(s, chatMessage.SenderName, chatMessage.Message)
+	case strings.HasPrefix(chatMessage.Message, "!ready"):
+		handleReadyCommand(s, true)
+	case strings.HasPrefix(chatMessage.Message, "!notready"):
+		handleReadyCommand(s, false)
+	case strings.HasPrefix(chatMessage.Message, "!invite") || chatMessage.Message == "!accept" || chatMessage.Message == "!decline" || chatMessage.Message == "!dnd":
+		handlePartyInviteCommand(s, chatMessage.Message)
+	case strings.HasPrefix(chatMessage.Message, "!queue"):
+		handleQueueCommand(s, chatMessage.Message)
+	case strings.HasPrefix(chatMessage.Message, "!give"):
+		handleGiveCommand(s, chatMessage.Message)
+	case strings.HasPrefix(chatMessage.Message, "!summon"):
+		handleModeratorMoveCommand(s, chatMessage.Message)
+	case strings.HasPrefix(chatMessage.Message, "!tele "):
+		var x, y int16
+		n, err := fmt.Sscanf(chatMessage.Message, "!tele %d %d", &x, &y)
+		if err != nil || n != 2 {
+			// Moderators can also name a player to move to.
+			if !handleModeratorMoveCommand(s, chatMessage.Message) {
 				sendServerChatMessage(s, "Invalid command. Usage:\"!tele 500 500\"")
-			} else {
-				sendServerChatMessage(s, fmt.Sprintf("Teleporting to %d %d", x, y))
-
-				// Make the inside of the casted binary
-				payload := byteframe.NewByteFrame()
-				payload.SetLE()
-				payload.WriteUint8(2) // SetState type(position == 2)
-				payload.WriteInt16(x) // X
-				payload.WriteInt16(y) // Y
-				payloadBytes := payload.Data()
-
-				s.QueueSendMHF(&mhfpacket.MsgSysCastedBinary{
-					CharID:         s.charID,
-					MessageType:    BinaryMessageTypeState,
-					RawDataPayload: payloadBytes,
-				})
 			}
+		} else {
+			sendServerChatMessage(s, fmt.Sprintf("Teleporting to %d %d", x, y))
+
+			// Make the inside of the casted binary
+			payload := byteframe.NewByteFrame()
+			payload.SetLE()
+			payload.WriteUint8(2) // SetState type(position == 2)
+			payload.WriteInt16(x) // X
+			payload.WriteInt16(y) // Y
+			payloadBytes := payload.Data()
+
+			s.QueueSendMHF(&mhfpacket.MsgSysCastedBinary{
+				CharID:         s.charID,
+				MessageType:    BinaryMessageTypeState,
+				RawDataPayload: payloadBytes,
+			})
 		}
+	default:
+		return false
 	}
+	return true
 }
 
 func handleMsgSysCastedBinary(s *Session, p mhfpacket.MHFPacket) {}
@@ -0,0 +1,31 @@
+package channelserver
+
+import (
+	"testing"
+
+	"github.com/Solenataris/Erupe/network/binpacket"
+)
+
+func TestChatCommandsAreNotBroadcast(t *testing.T) {
+	s := newTestServer()
+	sender := newChatMember(t, s, 1)
+	bystander := newChatMember(t, s, 2)
+	moveToStage(t, sender, "sl1Ns200p0a0u0")
+	moveToStage(t, bystander, "sl1Ns200p0a0u0")
+
+	for _, test := range []struct {
+		message string
+		sent    bool
+	}{
+		{"!ready", false},
+		{"!give 7 1 1", false},
+		{"!summon Hunter", false},
+		{"hello", true},
+		{"!not a command", true},
+	} {
+		handleMsgSysCastBinary(sender, chatPacket(binpacket.ChatTypeLocal, BroadcastTypeStage, test.message))
+		if sent := len(sentPackets(bystander)) > 0; sent != test.sent {
+			t.Errorf("%q reached the stage: %v, want %v", test.message, sent, test.sent)
+		}
+	}
+}
@@ -21,6 +21,9 @@ type CharacterSaveData struct {
 
 	// Use provided setter/getter
 	baseSaveData []byte
+
+	diffs       int  // Diffs in savedata_diffs applied onto the savedata last written whole.
+	brokenDiffs bool // The diffs didn't apply, baseSaveData is the savedata last written whole.
 }
 
 func GetCharacterSaveData(s *Session, charID uint32) (*CharacterSaveData, error) {
@@ -64,6 +67,19 @@ func GetCharacterSaveData(s *Session, charID uint32) (*CharacterSaveData, error)
 		return nil, err
 	}
 
+	diffs, err := loadSavedataDiffs(s.server.db, charID)
+	if err != nil {
+		s.logger.Error("Failed to get savedata diffs from db", zap.Error(err), zap.Uint32("charID", charID))
+		return nil, err
+	}
+	saveData.diffs = len(diffs)
+	decompressedBaseSave, err = applySavedataDiffs(decompressedBaseSave, diffs)
+	if err != nil {
+		// The next save writes the savedata whole again, dropping the diffs.
+		s.logger.Error("Savedata diffs don't apply, falling back to the savedata last written whole", zap.Error(err), zap.Uint32("charID", charID))
+		saveData.brokenDiffs = true
+	}
+
 	saveData.SetBaseSaveData(decompressedBaseSave)
 
 	return saveData, nil
@@ -80,11 +96,19 @@ func (save *CharacterSaveData) Save(s *Session, transaction *sql.Tx) error {
 	}
 
 	updateSQL := "UPDATE characters	SET savedata=$1, is_new_character=$3 WHERE id=$2"
+	// The savedata is written whole, consolidating the diffs of the saves since the last time.
+	deleteDiffsSQL := "DELETE FROM savedata_diffs WHERE char_id=$1"
 
 	if transaction != nil {
 		_, err = transaction.Exec(updateSQL, compressedData, save.CharID, save.IsNewCharacter)
+		if err == nil {
+			_, err = transaction.Exec(deleteDiffsSQL, save.CharID)
+		}
 	} else {
 		_, err = s.server.db.Exec(updateSQL, compressedData, save.CharID, save.IsNewCharacter)
+		if err == nil {
+			_, err = s.server.db.Exec(deleteDiffsSQL, save.CharID)
+		}
 	}
 	if err != nil {
 		s.logger.Error("failed to save character data", zap.Error(err), zap.Uint32("charID", save.CharID))
@@ -9,17 +9,13 @@ import (
 func handleMsgSysEnumerateClient(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgSysEnumerateClient)
 
-	// Read-lock the stages map.
-	s.server.stagesLock.RLock()
-
-	stage, ok := s.server.stages[pkt.StageID]
+	stage, ok := s.server.GetStage(pkt.StageID)
 	if !ok {
-		s.logger.Fatal("Can't enumerate clients for stage that doesn't exist!", zap.String("stageID", pkt.StageID))
+		s.logger.Warn("Can't enumerate clients for stage that doesn't exist!", zap.String("stageID", pkt.StageID))
+		doAckBufFail(s, pkt.AckHandle, make([]byte, 4))
+		return
 	}
 
-	// Unlock the stages map.
-	s.server.stagesLock.RUnlock()
-
 	// Read-lock the stage and make the response with all of the charID's in the stage.
 	resp := byteframe.NewByteFrame()
 	stage.RLock()
@@ -60,8 +56,17 @@ func handleMsgMhfListMember(s *Session, p mhfpacket.MHFPacket) {
 }
 
 func handleMsgMhfOprMember(s *Session, p mhfpacket.MHFPacket) {
-	pkt := p.(*mhfpacket.MsgMhfListMember)
-	// TODO: add targetid(uint32) to charid(uint32)'s database under new field
+	pkt := p.(*mhfpacket.MsgMhfOprMember)
+	if pkt.Blacklist {
+		for _, charID := range pkt.CharIDs {
+			if err := setBlocked(s, charID, !pkt.Operation); err != nil {
+				s.logger.Error("Failed to update blocklist", zap.Error(err))
+				doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+				return
+			}
+		}
+	}
+	// TODO: add targetid(uint32) to charid(uint32)'s database under new field for the friend list
 	doAckSimpleSucceed(s, pkt.AckHandle, make([]byte, 4))
 }
 
@@ -2,16 +2,15 @@ package channelserver
 
 import (
 	"encoding/hex"
-	"encoding/binary"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 
 	"github.com/Andoryuuta/byteframe"
-	"github.com/Solenataris/Erupe/common/bfutil"
+	"github.com/Solenataris/Erupe/common/stringsupport"
 	"github.com/Solenataris/Erupe/network/mhfpacket"
-	"github.com/Solenataris/Erupe/server/channelserver/compression/deltacomp"
+	"github.com/Solenataris/Erupe/server/channelserver/compression/blockdelta"
 	"github.com/Solenataris/Erupe/server/channelserver/compression/nullcomp"
 	"go.uber.org/zap"
 )
@@ -20,86 +19,84 @@ func handleMsgMhfSavedata(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfSavedata)
 	characterSaveData, err := GetCharacterSaveData(s, s.charID)
 	if err != nil {
-		s.logger.Error("failed to retrieve character save data from db", zap.Error(err), zap.Uint32("charID", s.charID))
+		s.logger.Error("failed to retrieve character save data from db", zap.Error(err))
+		doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
 		return
 	}
-	// Var to hold the decompressed savedata for updating the launcher response fields.
-	var decompressedData []byte
-	if pkt.SaveType == 1 {
-		// Diff-based update.
-		// diffs themselves are also potentially compressed
-		diff, err := nullcomp.Decompress(pkt.RawDataPayload)
-		if err != nil {
-			s.logger.Fatal("Failed to decompress diff", zap.Error(err))
+	previous := characterSaveData.BaseSaveData()
+	// A truncated or garbled upload is refused rather than stored over the good save.
+	quota := s.server.erupeConfig.Current().Channel.SavedataQuota
+	saveData, err := newSavedata(pkt.SaveType, pkt.RawDataPayload, previous, quota)
+	if err != nil {
+		s.logger.Warn("Refused invalid savedata", zap.Uint8("saveType", pkt.SaveType), zap.Error(err))
+		doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+		return
+	}
+	if anomaly := checkSavedataSize(previous, saveData, quota); anomaly != nil {
+		s.logger.Warn("Savedata changed in size past the quota",
+			zap.Uint32("charID", s.charID),
+			zap.Int("previousSize", anomaly.previousSize),
+			zap.Int("size", anomaly.size),
+			zap.Bool("keptPrevious", anomaly.keptPrevious),
+		)
+		if err := recordSavedataAnomaly(s.server.db, s.charID, anomaly); err != nil {
+			s.logger.Error("Failed to record savedata anomaly", zap.Error(err))
 		}
-		// Perform diff.
-		s.logger.Info("Diffing...")
-		characterSaveData.SetBaseSaveData(deltacomp.ApplyDataDiff(diff, characterSaveData.BaseSaveData()))
-	} else {
-		// Regular blob update.
-		saveData, err := nullcomp.Decompress(pkt.RawDataPayload)
+		if anomaly.keptPrevious {
+			doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+			return
+		}
+	}
+	characterSaveData.SetBaseSaveData(saveData)
+	// A character is named in its first save. A new name the rules refuse is kept with the rest of the save,
+	// refusing the save would lose the progress in it, and the character is flagged to be renamed.
+	characterName := stringsupport.DecodeShiftJIS(saveData[88:100])
+	var forceRename *bool
+	if characterName != characterSaveData.Name {
+		err := checkNewName(s, false, characterName, s.charID)
 		if err != nil {
-			s.logger.Fatal("Failed to decompress savedata from packet", zap.Error(err))
+			refuseName(s, false, characterName, err)
+		}
+		// A check that failed to run leaves the flag as it was.
+		if refused := nameRuleBroken(err); refused || err == nil {
+			forceRename = &refused
 		}
-		s.logger.Info("Updating save with blob")
-		characterSaveData.SetBaseSaveData(saveData)
 	}
 	characterSaveData.IsNewCharacter = false
-	characterBaseSaveData := characterSaveData.BaseSaveData()
-	// Make a copy for updating the launcher fields.
-	decompressedData = make([]byte, len(characterBaseSaveData))
-	copy(decompressedData, characterBaseSaveData)
-	err = characterSaveData.Save(s, nil)
-	if err != nil {
-		s.logger.Fatal("Failed to update savedata in db", zap.Error(err))
+	protectResetRank(s, characterSaveData)
+	if capGatedRank(s.server.closedContentGates(), previous, characterSaveData.baseSaveData) {
+		s.logger.Info("Held the saved rank to the content gate caps", zap.Uint32("charID", s.charID))
 	}
-	s.logger.Info("Wrote recompressed savedata back to DB.")
-	dumpSaveData(s, pkt.RawDataPayload, "")
-
-	_, err = s.server.db.Exec("UPDATE characters SET weapon_type=$1 WHERE id=$2", uint16(decompressedData[128789]), s.charID)
-	if err != nil {
-		s.logger.Fatal("Failed to character weapon type in db", zap.Error(err))
-	}
-
-	isMale := uint8(decompressedData[80]) // 0x50
-	if isMale == 1 {
-		_, err = s.server.db.Exec("UPDATE characters SET is_female=true WHERE id=$1", s.charID)
+	characterSaveData.updateSaveDataWithStruct()
+	fields := readSavedataFields(characterSaveData.BaseSaveData())
+	fields.forceRename = forceRename
+	var write savedataWrite
+	if characterSaveData.diffs < s.server.erupeConfig.Current().Channel.SavedataDiffs && !characterSaveData.brokenDiffs && previous != nil {
+		write.diff = blockdelta.Diff(previous, characterSaveData.BaseSaveData())
 	} else {
-		_, err = s.server.db.Exec("UPDATE characters SET is_female=false WHERE id=$1", s.charID)
-	}
-	if err != nil {
-		s.logger.Fatal("Failed to character gender in db", zap.Error(err))
-	}
-
-	weaponId := binary.LittleEndian.Uint16(decompressedData[128522:128524]) // 0x1F60A
-	_, err = s.server.db.Exec("UPDATE characters SET weapon_id=$1 WHERE id=$2", weaponId, s.charID)
-	if err != nil {
-		s.logger.Fatal("Failed to update character weapon id in db", zap.Error(err))
-	}
-
-	hrp := binary.LittleEndian.Uint16(decompressedData[130550:130552]) // 0x1FDF6
-	_, err = s.server.db.Exec("UPDATE characters SET hrp=$1 WHERE id=$2", hrp, s.charID)
-	if err != nil {
-		s.logger.Fatal("Failed to update character hrp in db", zap.Error(err))
+		write.whole, err = characterSaveData.CompressedBaseData(s)
+		if err != nil {
+			doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+			return
+		}
 	}
 
-	grp := binary.LittleEndian.Uint32(decompressedData[130556:130560]) // 0x1FDFC
-	var gr uint16
-	if grp > 0 {
-		gr = grpToGR(grp)
-	} else {
-		gr = 0
+	tx, err := s.server.db.Begin()
+	if err == nil {
+		err = writeSavedata(tx, s.charID, write, fields, s.server.erupeConfig.Current().Channel.SavedataBackups)
 	}
-	_, err = s.server.db.Exec("UPDATE characters SET gr=$1 WHERE id=$2", gr, s.charID)
 	if err != nil {
-		s.logger.Fatal("Failed to update character gr in db", zap.Error(err))
+		s.logger.Error("Failed to update savedata in db", zap.Error(err))
+		doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+		return
 	}
-
-	characterName := s.clientContext.StrConv.MustDecode(bfutil.UpToNull(decompressedData[88:100]))
-	_, err = s.server.db.Exec("UPDATE characters SET name=$1 WHERE id=$2", characterName, s.charID)
-	if err != nil {
-		s.logger.Fatal("Failed to update character name in db", zap.Error(err))
+	if forceRename != nil {
+		s.Lock()
+		s.forceRename = *forceRename
+		s.Unlock()
 	}
+	s.logger.Info("Wrote recompressed savedata back to DB.")
+	dumpSaveData(s, pkt.RawDataPayload, "")
 	doAckSimpleSucceed(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
 }
 
@@ -188,9 +185,33 @@ func handleMsgMhfLoaddata(s *Session, p mhfpacket.MHFPacket) {
 	if err != nil {
 		s.logger.Fatal("Failed to get savedata from db", zap.Error(err))
 	}
+	// The client gets the savedata its last save left, with the diffs since it was written whole.
+	diffs, err := loadSavedataDiffs(s.server.db, s.charID)
+	if err == nil && data != nil && len(diffs) > 0 {
+		data, err = loaddataWithDiffs(data, diffs)
+	}
+	if err != nil {
+		s.logger.Error("Failed to get savedata diffs", zap.Error(err), zap.Uint32("charID", s.charID))
+		doAckBufFail(s, pkt.AckHandle, make([]byte, 4))
+		return
+	}
 	doAckBufSucceed(s, pkt.AckHandle, data)
 }
 
+// loaddataWithDiffs returns the compressed savedata with the diffs applied, or as it is when they don't
+// apply, like GetCharacterSaveData falls back to it.
+func loaddataWithDiffs(compressed []byte, diffs []savedataDiff) ([]byte, error) {
+	base, err := nullcomp.Decompress(compressed)
+	if err != nil {
+		return nil, err
+	}
+	data, err := applySavedataDiffs(base, diffs)
+	if err != nil {
+		return compressed, nil
+	}
+	return nullcomp.Compress(data)
+}
+
 func handleMsgMhfSaveScenarioData(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfSaveScenarioData)
 	_, err := s.server.db.Exec("UPDATE characters SET scenariodata = $1 WHERE characters.id = $2", pkt.RawDataPayload, int(s.charID))
@@ -3,7 +3,9 @@ package channelserver
 import (
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 )
@@ -49,8 +51,10 @@ func (s *Server) getCharacterForUser(uid int) (*Character, error) {
 
 func CountChars(s *Server) string {
 	count := 0
-	for _, stage := range s.stages {
+	for _, stage := range s.stageList() {
+		stage.RLock()
 		count += len(stage.clients)
+		stage.RUnlock()
 	}
 
 	message := fmt.Sprintf("Server [%s]: %d players;", s.name, count)
@@ -95,8 +99,9 @@ func getPlayerList(s *Server) ([]ListPlayer, int) {
 
 	bigNameLen := 0
 
-	for _, stage := range s.stages {
-		if len(stage.clients) == 0 {
+	for _, stage := range s.stageList() {
+		summary := stage.summary()
+		if len(summary.clients) == 0 {
 			continue
 		}
 
@@ -107,8 +112,8 @@ func getPlayerList(s *Server) ([]ListPlayer, int) {
 			questEmojis = questEmojis[:len(questEmojis)-1]
 		}
 
-		isQuest := stage.isQuest()
-		for client := range stage.clients {
+		isQuest := len(summary.reserved) > 0
+		for _, client := range summary.clients {
 			char, err := s.getCharacterForUser(int(client.charID))
 			if err == nil {
 				if len(char.Name) > bigNameLen {
@@ -153,8 +158,9 @@ func PlayerList(s *Server) string {
 func debug(s *Server) string {
 	list := ""
 
-	for _, stage := range s.stages {
-		if !stage.isQuest() && len(stage.objects) == 0 {
+	for _, stage := range s.stageList() {
+		summary := stage.summary()
+		if len(summary.reserved) == 0 && len(summary.objects) == 0 {
 			continue
 		}
 
@@ -162,21 +168,21 @@ func debug(s *Server) string {
 		isQuest := "false"
 		hasDeparted := "false"
 
-		if stage.isQuest() {
+		if len(summary.reserved) > 0 {
 			isQuest = "true"
 		}
 
 		list += fmt.Sprintf("    '-> isQuest: %s\n", isQuest)
 
-		if stage.isQuest() {
-			if stage.hasDeparted {
+		if len(summary.reserved) > 0 {
+			if summary.hasDeparted {
 				hasDeparted = "true"
 			}
 
 			list += fmt.Sprintf("    '-> isDeparted: %s\n", hasDeparted)
-			list += fmt.Sprintf("    '-> reserveSlots (%d/%d)\n", len(stage.reservedClientSlots), stage.maxPlayers)
+			list += fmt.Sprintf("    '-> reserveSlots (%d/%d)\n", len(summary.reserved), summary.maxPlayers)
 
-			for charid, _ := range stage.reservedClientSlots {
+			for _, charid := range summary.reserved {
 				char, err := s.getCharacterForUser(int(charid))
 				if err == nil {
 					list += fmt.Sprintf("        '-> %s\n", char.Name)
@@ -185,7 +191,7 @@ func debug(s *Server) string {
 		}
 
 		list += "    '-> objects: \n"
-		for _, obj := range stage.objects {
+		for _, obj := range summary.objects {
 			objInfo := fmt.Sprintf("X,Y,Z: %f %f %f", obj.x, obj.y, obj.z)
 			list += fmt.Sprintf("        '-> ObjectId: %d - %s\n", obj.id, objInfo)
 		}
@@ -197,21 +203,50 @@ func debug(s *Server) string {
 	return message
 }
 
+func bandwidthList(s *Server) string {
+	type sessionBandwidth struct {
+		name    string
+		in, out uint64
+	}
+	var list []sessionBandwidth
+
+	s.Lock()
+	for _, session := range s.sessions {
+		in, out := session.bandwidth.Totals()
+		list = append(list, sessionBandwidth{session.Name, in, out})
+	}
+	s.Unlock()
+
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].in+list[i].out > list[j].in+list[j].out
+	})
+
+	message := fmt.Sprintf("Bandwidth in Server: [%s ]\n", s.name)
+	in, out := s.bandwidth.Totals()
+	message += fmt.Sprintf("    '-> Total in: %d bytes, out: %d bytes\n", in, out)
+	for _, sb := range list {
+		message += fmt.Sprintf("        '-> %s: in %d, out %d\n", sb.name, sb.in, sb.out)
+	}
+
+	return message
+}
+
 func questlist(s *Server) string {
 	list := ""
 
-	for _, stage := range s.stages {
-		if !stage.isQuest() {
+	for _, stage := range s.stageList() {
+		summary := stage.summary()
+		if len(summary.reserved) == 0 {
 			continue
 		}
 
 		hasDeparted := ""
-		if stage.hasDeparted {
+		if summary.hasDeparted {
 			hasDeparted = " - departed"
 		}
-		list += fmt.Sprintf("    '-> StageId: %s (%d/%d) %s - %s\n", stage.id, len(stage.reservedClientSlots), stage.maxPlayers, hasDeparted, stage.createdAt)
+		list += fmt.Sprintf("    '-> StageId: %s (%d/%d) %s - %s\n", stage.id, len(summary.reserved), summary.maxPlayers, hasDeparted, summary.createdAt)
 
-		for charid, _ := range stage.reservedClientSlots {
+		for _, charid := range summary.reserved {
 			char, err := s.getCharacterForUser(int(charid))
 			if err == nil {
 				list += fmt.Sprintf("        '-> %s\n", char.Name)
@@ -226,14 +261,96 @@ func questlist(s *Server) string {
 }
 
 func removeStageById(s *Server, stageId string) string {
-	if s.stages[stageId] != nil {
-		delete(s.stages, stageId)
+	if s.RemoveStage(stageId) {
 		return "Stage deleted!"
 	}
 
 	return "Stage not found!"
 }
 
+// spawnEventObject parses the !spawn-object arguments and spawns the object.
+// Usage: !spawn-object <stage id> <minutes> <x> <y> <z> <rewards|none> <name>
+// The rewards are comma separated, each type:item id:quantity for an item or title:id for a title.
+func spawnEventObject(s *Server, args []string) string {
+	if len(args) < 8 {
+		return "Usage: !spawn-object <stage id> <minutes> <x> <y> <z> <type:item id:quantity,title:id|none> <name>"
+	}
+
+	var minutes int
+	var x, y, z float32
+	_, err := fmt.Sscanf(strings.Join(args[2:6], " "), "%d %f %f %f", &minutes, &x, &y, &z)
+	if err != nil || minutes <= 0 {
+		return "Invalid duration or position!"
+	}
+
+	reward, titles, err := parseEventRewards(args[6])
+	if err != nil {
+		return "Invalid reward, expected <type:item id:quantity> (type 7 for items), <title:id> or none"
+	}
+
+	name := strings.Join(args[7:], " ")
+	eventObj, err := s.SpawnEventObject(args[1], name, x, y, z, time.Duration(minutes)*time.Minute, reward, titles)
+	if err != nil {
+		return fmt.Sprintf("Failed to spawn object: %s", err)
+	}
+
+	return fmt.Sprintf("Spawned %s (ObjectId: %d) in %s for %d minutes", name, eventObj.objID, args[1], minutes)
+}
+
+// parseEventRewards parses the comma separated rewards of an event object, "none" for no reward.
+func parseEventRewards(arg string) ([]DistItemEntry, []uint16, error) {
+	if arg == "none" {
+		return nil, nil, nil
+	}
+	var items []DistItemEntry
+	var titles []uint16
+	for _, reward := range strings.Split(arg, ",") {
+		if strings.HasPrefix(reward, "title:") {
+			titleID, err := strconv.ParseUint(strings.TrimPrefix(reward, "title:"), 10, 16)
+			if err != nil {
+				return nil, nil, err
+			}
+			titles = append(titles, uint16(titleID))
+			continue
+		}
+		var entry DistItemEntry
+		if _, err := fmt.Sscanf(reward, "%d:%d:%d", &entry.ItemType, &entry.ItemID, &entry.Quantity); err != nil {
+			return nil, nil, err
+		}
+		items = append(items, entry)
+	}
+	return items, titles, nil
+}
+
+// grantRental parses the !rent arguments and lends the item.
+// Usage: !rent <char id> <days> <type:item id>
+func grantRental(s *Server, args []string) string {
+	if len(args) < 4 {
+		return "Usage: !rent <char id> <days> <type:item id>"
+	}
+
+	var charID uint32
+	var days int
+	_, err := fmt.Sscanf(args[1]+" "+args[2], "%d %d", &charID, &days)
+	if err != nil || days <= 0 {
+		return "Invalid character ID or duration!"
+	}
+
+	var itemType uint8
+	var itemID uint16
+	_, err = fmt.Sscanf(args[3], "%d:%d", &itemType, &itemID)
+	if err != nil {
+		return "Invalid item, expected <type:item id>"
+	}
+
+	expiresAt := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+	if err = GrantRental(s.db, charID, itemType, itemID, expiresAt); err != nil {
+		return fmt.Sprintf("Failed to grant rental: %s", err)
+	}
+
+	return fmt.Sprintf("Lent item %d to character %d until %s", itemID, charID, expiresAt.Format("2006-01-02 15:04"))
+}
+
 func cleanStr(str string) string {
 	return strings.ToLower(strings.Trim(str, " "))
 }
@@ -242,8 +359,8 @@ func getCharInfo(server *Server, charName string) string {
 	var s *Stage
 	var c *Session
 
-	for _, stage := range server.stages {
-		for client := range stage.clients {
+	for _, stage := range server.stageList() {
+		for _, client := range stage.summary().clients {
 
 			if client.Name == "" {
 				continue
@@ -263,10 +380,7 @@ func getCharInfo(server *Server, charName string) string {
 
 	objInfo := ""
 
-	obj := server.FindStageObjectByChar(c.charID)
-	// server.logger.Info("Found object: %+v", zap.Object("obj", obj))
-
-	if obj != nil {
+	if obj, ok := s.avatarState(c.charID); ok {
 		objInfo = fmt.Sprintf("X,Y,Z: %f %f %f", obj.x, obj.y, obj.z)
 	}
 
@@ -322,6 +436,11 @@ func (s *Server) onDiscordMessage(ds *discordgo.Session, m *discordgo.MessageCre
 		return
 	}
 
+	if commandName == "!bandwidth" && s.isDiscordAdmin(ds, m) {
+		ds.ChannelMessageSend(m.ChannelID, bandwidthList(s))
+		return
+	}
+
 	if commandName == "!questlist" && s.isDiscordAdmin(ds, m) {
 		ds.ChannelMessageSend(m.ChannelID, questlist(s))
 		return
@@ -338,6 +457,31 @@ func (s *Server) onDiscordMessage(ds *discordgo.Session, m *discordgo.MessageCre
 		return
 	}
 
+	if commandName == "!spawn-object" && s.isDiscordAdmin(ds, m) {
+		ds.ChannelMessageSend(m.ChannelID, spawnEventObject(s, args))
+		return
+	}
+
+	if commandName == "!rent" && s.isDiscordAdmin(ds, m) {
+		ds.ChannelMessageSend(m.ChannelID, grantRental(s, args))
+		return
+	}
+
+	if commandName == "!remove-object" && s.isDiscordAdmin(ds, m) {
+		var objID uint32
+		if len(args) < 3 {
+			ds.ChannelMessageSend(m.ChannelID, "Usage: !remove-object <stage id> <object id>")
+			return
+		}
+		fmt.Sscanf(args[2], "%d", &objID)
+		if s.RemoveEventObject(args[1], objID) {
+			ds.ChannelMessageSend(m.ChannelID, "Object removed!")
+		} else {
+			ds.ChannelMessageSend(m.ChannelID, "Object not found!")
+		}
+		return
+	}
+
 	if m.ChannelID == s.erupeConfig.Discord.RealtimeChannelID {
 		message := fmt.Sprintf("[DISCORD] %s: %s", m.Author.Username, m.Content)
 		s.BroadcastChatMessage(s.discordBot.NormalizeDiscordMessage(message))
@@ -3,7 +3,9 @@ package channelserver
 import (
 	"github.com/Solenataris/Erupe/network/mhfpacket"
 	"github.com/Solenataris/Erupe/common/stringsupport"
+	"github.com/Solenataris/Erupe/common/distpayload"
 	"github.com/Andoryuuta/byteframe"
+	"github.com/jmoiron/sqlx"
 	"go.uber.org/zap"
 )
 
@@ -26,34 +28,12 @@ type ItemDist struct {
 func handleMsgMhfEnumerateDistItem(s *Session, p mhfpacket.MHFPacket) {
   pkt := p.(*mhfpacket.MsgMhfEnumerateDistItem)
 	bf := byteframe.NewByteFrame()
-	distCount := 0
-	dists, err := s.server.db.Queryx(`
-		SELECT d.id, event_name, description, times_acceptable,
-		min_hr, max_hr, min_sr, max_sr, min_gr, max_gr,
-		(
-    	SELECT count(*)
-    	FROM distributions_accepted da
-    	WHERE d.id = da.distribution_id
-    	AND da.character_id = $1
-		) AS times_accepted,
-		CASE
-			WHEN (EXTRACT(epoch FROM deadline)::int) IS NULL THEN 0
-			ELSE (EXTRACT(epoch FROM deadline)::int)
-		END deadline
-		FROM distribution d
-		WHERE character_id = $1 AND type = $2 OR character_id IS NULL AND type = $2 ORDER BY id DESC;
-	`, s.charID, pkt.Unk0)
+	dists, err := enumerateDistributions(s.server.db, s.charID, pkt.Unk0)
 	if err != nil {
 		s.logger.Error("Error getting distribution data from db", zap.Error(err))
 		doAckBufSucceed(s, pkt.AckHandle, make([]byte, 4))
 	} else {
-		for dists.Next() {
-			distCount++
-			distData := &ItemDist{}
-			err = dists.StructScan(&distData)
-			if err != nil {
-				s.logger.Error("Error parsing item distribution data", zap.Error(err))
-			}
+		for _, distData := range dists {
 			bf.WriteUint32(distData.ID)
 			bf.WriteUint32(distData.Deadline)
 			bf.WriteUint32(0) // Unk
@@ -74,7 +54,7 @@ func handleMsgMhfEnumerateDistItem(s *Session, p mhfpacket.MHFPacket) {
 			bf.WriteBytes(make([]byte, 391))
 		}
 		resp := byteframe.NewByteFrame()
-		resp.WriteUint16(uint16(distCount))
+		resp.WriteUint16(uint16(len(dists)))
 		resp.WriteBytes(bf.Data())
 		doAckBufSucceed(s, pkt.AckHandle, resp.Data())
 	}
@@ -86,27 +66,21 @@ func handleMsgMhfApplyDistItem(s *Session, p mhfpacket.MHFPacket) {
   if pkt.DistributionID == 0 {
     doAckBufSucceed(s, pkt.AckHandle, make([]byte, 6))
   } else {
-		row := s.server.db.QueryRowx("SELECT data FROM distribution WHERE id = $1", pkt.DistributionID)
-		dist := &ItemDist{}
-		err := row.StructScan(dist)
-		if err != nil {
-			s.logger.Error("Error parsing item distribution data", zap.Error(err))
+		data, err := claimDistribution(s.server.db, s.server.items, s.charID, pkt.DistributionID)
+		if err == ErrDistributionUnavailable {
+			s.logger.Info("Refused claim of an unavailable distribution", zap.Uint32("distID", pkt.DistributionID))
+			doAckBufSucceed(s, pkt.AckHandle, make([]byte, 6))
+			return
+		} else if err != nil {
+			s.logger.Error("Failed to claim distribution", zap.Error(err), zap.Uint32("distID", pkt.DistributionID))
 			doAckBufSucceed(s, pkt.AckHandle, make([]byte, 6))
 			return
 		}
 
 		bf := byteframe.NewByteFrame()
 		bf.WriteUint32(0)
-		bf.WriteBytes(dist.Data)
-    doAckBufSucceed(s, pkt.AckHandle, bf.Data())
-
-		_, err = s.server.db.Exec(`
-			INSERT INTO public.distributions_accepted
-			VALUES ($1, $2)
-		`, pkt.DistributionID, s.charID)
-		if err != nil {
-			s.logger.Error("Error updating accepted dist count", zap.Error(err))
-		}
+		bf.WriteBytes(data)
+		doAckBufSucceed(s, pkt.AckHandle, bf.Data())
   }
 }
 
@@ -130,3 +104,28 @@ func handleMsgMhfGetDistDescription(s *Session, p mhfpacket.MHFPacket) {
 	bf.WriteNullTerminatedBytes(description)
 	doAckBufSucceed(s, pkt.AckHandle, bf.Data())
 }
+
+// DistItemEntry is a single item inside a distribution payload.
+type DistItemEntry struct {
+	ItemType uint8
+	ItemID   uint16
+	Quantity uint16
+}
+
+// buildDistItemData encodes a list of items in the payload format returned by MsgMhfApplyDistItem.
+func buildDistItemData(items []DistItemEntry) []byte {
+	entries := make([]distpayload.Item, len(items))
+	for i, item := range items {
+		entries[i] = distpayload.Item{Type: item.ItemType, ID: item.ItemID, Quantity: item.Quantity}
+	}
+	return distpayload.Encode(entries)
+}
+
+// createCharacterDistribution queues a one-time distribution that only the given character can claim.
+func createCharacterDistribution(db sqlx.Execer, charID uint32, eventName string, description string, items []DistItemEntry) error {
+	_, err := db.Exec(`
+		INSERT INTO distribution (character_id, type, event_name, description, times_acceptable, data)
+		VALUES ($1, 0, $2, $3, 1, $4)
+	`, charID, eventName, description, buildDistItemData(items))
+	return err
+}
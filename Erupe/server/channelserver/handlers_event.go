@@ -2,12 +2,12 @@ package channelserver
 
 import (
 	"fmt"
-	"math/rand"
 	"time"
 
 	"github.com/Andoryuuta/byteframe"
 	"github.com/Solenataris/Erupe/network/mhfpacket"
 	timeServerFix "github.com/Solenataris/Erupe/server/channelserver/timeserver"
+	"go.uber.org/zap"
 )
 
 func handleMsgMhfRegisterEvent(s *Session, p mhfpacket.MHFPacket) {
@@ -71,7 +71,7 @@ func handleMsgMhfGetWeeklySchedule(s *Session, p mhfpacket.MHFPacket) {
 			s.logger.Info("\nGenerating active feature...")
 		}
 		persistentEventSchedule = make([]activeFeature, 8)
-		//weapons := generateRandomNumber(1, 14, 8)
+		//weapons := generateRandomNumber(s.server.rng.Stream(rngStreamEvent), 1, 14, 8)
 		for x := -1; x < 7; x++ {
 			var feat uint32
 			feat |= 65535
@@ -80,7 +80,7 @@ func handleMsgMhfGetWeeklySchedule(s *Session, p mhfpacket.MHFPacket) {
 				ActiveFeatures: feat,
 				Unk1:           0,
 			}
-			fmt.Println(feat)
+			s.logger.Debug("Weekly feature", zap.Uint32("features", feat))
 		}
 	}
 
@@ -96,12 +96,11 @@ func handleMsgMhfGetWeeklySchedule(s *Session, p mhfpacket.MHFPacket) {
 	doAckBufSucceed(s, pkt.AckHandle, resp.Data())
 }
 
-func generateRandomNumber(start int, end int, count int) []int {
+func generateRandomNumber(r *RNGStream, start int, end int, count int) []int {
 	if end < start || (end-start) < count {
 		return nil
 	}
 	nums := make([]int, 0)
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
 	for len(nums) < count {
 		num := r.Intn((end - start)) + start
 		exist := false
@@ -247,7 +246,7 @@ func handleMsgMhfUseKeepLoginBoost(s *Session, p mhfpacket.MHFPacket) {
 func handleMsgMhfGetUdSchedule(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfGetUdSchedule)
 	var t = timeServerFix.Tstatic_midnight()
-	var event int = s.server.erupeConfig.DevModeOptions.Event
+	var event int = s.server.erupeConfig.Current().DevModeOptions.Event
 
 	year, month, day := t.Date()
 	midnight := time.Date(year, month, day, 0, 0, 0, 0, t.Location())
@@ -366,11 +365,12 @@ func ScheduleEvent(s *Session, fixWeek int) uint32 {
 func handleMsgMhfGetUdInfo(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfGetUdInfo)
 	// Message that appears on the Diva Defense NPC and triggers the green exclamation mark
-	udInfos := []struct {
+	type udNotice struct {
 		Text      string
 		StartTime time.Time
 		EndTime   time.Time
-	}{
+	}
+	udInfos := []udNotice{
 		/*{
 			Text:      " ~C17【Erupe】 is dead event!\n\n■Features\n~C18 Dont bother walking around!\n~C17 Take down your DB by doing \n~C17 nearly anything!",
 			StartTime: Time_static().Add(time.Duration(-5) * time.Minute), // Event started 5 minutes ago,
@@ -378,6 +378,18 @@ func handleMsgMhfGetUdInfo(s *Session, p mhfpacket.MHFPacket) {
 		}, */
 	}
 
+	// How far the server is with the Raviente this cycle.
+	cfg := s.server.erupeConfig.RavienteCycle
+	if cycle, ends, err := RavienteCycleAt(cfg, time.Now()); err == nil && cycle > 0 {
+		contributors, damage, err := s.server.ravienteCycleProgress(cycle)
+		if err != nil {
+			s.logger.Error("Failed to get Raviente cycle progress", zap.Error(err))
+		} else {
+			text := fmt.Sprintf("~C17Raviente cycle %d\n\n~C18%d hunters have dealt %d damage so far.\nProgress carries over until the cycle ends.", cycle, contributors, damage)
+			udInfos = append(udInfos, udNotice{text, ends.Add(-time.Duration(cfg.CycleDays) * 24 * time.Hour), ends})
+		}
+	}
+
 	resp := byteframe.NewByteFrame()
 	resp.WriteUint8(uint8(len(udInfos)))
 	for _, udInfo := range udInfos {
@@ -3,6 +3,7 @@ package channelserver
 import (
 	"github.com/Solenataris/Erupe/network/mhfpacket"
 	"github.com/Andoryuuta/byteframe"
+	"go.uber.org/zap"
 )
 
 func handleMsgMhfSaveMezfesData(s *Session, p mhfpacket.MHFPacket) {
@@ -38,23 +39,44 @@ func handleMsgMhfStateFestaU(s *Session, p mhfpacket.MHFPacket) {}
 // state festa (G)uild
 func handleMsgMhfStateFestaG(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfStateFestaG)
-
-	resp := byteframe.NewByteFrame()
-	resp.WriteUint32(0)
-	resp.WriteUint32(0)
-	resp.WriteUint32(0xFFFFFFFF)
-	resp.WriteUint32(0)
-	resp.WriteBytes([]byte{0x00, 0x00, 0x00}) // Not parsed.
-	resp.WriteUint8(0)
-
-	doAckBufSucceed(s, pkt.AckHandle, resp.Data())
+	if !s.server.festaActive() {
+		doAckBufSucceed(s, pkt.AckHandle, buildFestaGuildState(festaGuildStanding{}))
+		return
+	}
+	// Ranking the guild means adding up the souls of every guild in the festa.
+	s.deferAck(pkt.AckHandle, func() ([]byte, error) {
+		standing, err := getFestaGuildStanding(s)
+		if err != nil {
+			return nil, err
+		}
+		return buildFestaGuildState(standing), nil
+	})
 }
 
 func handleMsgMhfVoteFesta(s *Session, p mhfpacket.MHFPacket) {}
 
 func handleMsgMhfEntryFesta(s *Session, p mhfpacket.MHFPacket) {}
 
-func handleMsgMhfChargeFesta(s *Session, p mhfpacket.MHFPacket) {}
+func handleMsgMhfChargeFesta(s *Session, p mhfpacket.MHFPacket) {
+	pkt := p.(*mhfpacket.MsgMhfChargeFesta)
+	valid, err := festaChargeValid(s, pkt.FestaID, pkt.GuildID)
+	if err != nil {
+		s.logger.Error("Failed to check the festa charge", zap.Error(err))
+	}
+	if !valid {
+		s.logger.Warn("Refused a festa charge for another festa or guild", zap.Uint32("festaID", pkt.FestaID), zap.Uint32("guildID", pkt.GuildID))
+		doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+		return
+	}
+	souls, err := chargeFestaSouls(s, int(pkt.Souls))
+	if err != nil {
+		s.logger.Error("Failed to charge festa souls", zap.Error(err))
+	}
+	addSeasonPoints(s, souls*s.server.erupeConfig.SeasonPass.FestaSoulPoints)
+	addFestaPoints(s, souls)
+	addFestaSouls(s, souls)
+	doAckSimpleSucceed(s, pkt.AckHandle, make([]byte, 4))
+}
 
 func handleMsgMhfAcquireFesta(s *Session, p mhfpacket.MHFPacket) {}
 
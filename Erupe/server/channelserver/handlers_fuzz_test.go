@@ -0,0 +1,127 @@
+package channelserver
+
+import (
+	"testing"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/common/bfutil"
+	"github.com/Solenataris/Erupe/network/binpacket"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+)
+
+// The fuzz tests check that parsing a malformed packet fails it rather than panicking. Run one with
+// e.g. "go test -fuzz FuzzCastBinary ./server/channelserver", plain go test runs the seeds.
+
+// castBinaryBody is a MSG_SYS_CAST_BINARY body, without its opcode, carrying the payload.
+func castBinaryBody(broadcastType uint8, messageType uint8, payload []byte) []byte {
+	bf := byteframe.NewByteFrame()
+	bf.WriteUint16(0)
+	bf.WriteUint16(0)
+	bf.WriteUint8(broadcastType)
+	bf.WriteUint8(messageType)
+	bf.WriteUint16(uint16(len(payload)))
+	bf.WriteBytes(payload)
+	return bf.Data()
+}
+
+func chatPayload(message string, sender string) []byte {
+	bf := byteframe.NewByteFrame()
+	bf.SetLE()
+	(&binpacket.MsgBinChat{Type: binpacket.ChatTypeLocal, Message: message, SenderName: sender}).Build(bf)
+	return bf.Data()
+}
+
+func FuzzCastBinary(f *testing.F) {
+	chat := chatPayload("Hello", "Hunter")
+	targeted := append([]byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02}, chat...)
+	f.Add(castBinaryBody(BroadcastTypeStage, BinaryMessageTypeChat, chat))
+	f.Add(castBinaryBody(BroadcastTypeTargeted, BinaryMessageTypeChat, targeted))
+	f.Add(castBinaryBody(BroadcastTypeTargeted, BinaryMessageTypeChat, targeted[:5]))
+	f.Add(castBinaryBody(BroadcastTypeStage, BinaryMessageTypeChat, chat[:8]))
+	f.Add(castBinaryBody(BroadcastTypeWorld, BinaryMessageTypeChat, []byte{0, 1, 0, 0, 0, 0, 0, 0}))
+	f.Add(castBinaryBody(BroadcastTypeStage, BinaryMessageTypeChat, chatPayload("ハンターｱｲｳ", "猫")))
+	f.Add([]byte{0x00, 0x00, 0x00})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pkt := &mhfpacket.MsgSysCastBinary{}
+		if err := pkt.Parse(byteframe.NewByteFrameFromBytes(data), nil); err != nil {
+			return
+		}
+		payload, targeted, chat, err := parseCastBinary(pkt)
+		if err != nil {
+			return
+		}
+		if pkt.MessageType == BinaryMessageTypeChat && chat == nil {
+			t.Error("a chat message parsed without a chat")
+		}
+		if pkt.BroadcastType == BroadcastTypeTargeted && (targeted == nil || len(targeted.TargetCharIDs) != int(targeted.TargetCount)) {
+			t.Errorf("targeted binary parsed into %+v", targeted)
+		}
+		if len(payload) > len(pkt.RawDataPayload) {
+			t.Errorf("payload of %d bytes out of %d", len(payload), len(pkt.RawDataPayload))
+		}
+	})
+}
+
+// stageBinaryBody is a MSG_SYS_SET_STAGE_BINARY body, without its opcode.
+func stageBinaryBody(stageID string, binary []byte) []byte {
+	bf := byteframe.NewByteFrame()
+	bf.WriteUint8(1)
+	bf.WriteUint8(2)
+	bf.WriteUint8(uint8(len(stageID)))
+	bf.WriteUint16(uint16(len(binary)))
+	bf.WriteBytes([]byte(stageID))
+	bf.WriteBytes(binary)
+	return bf.Data()
+}
+
+func FuzzStageBinary(f *testing.F) {
+	f.Add(stageBinaryBody("sl1Qs210p0a0u0\x00", []byte{1, 2, 3, 4}))
+	f.Add(stageBinaryBody("sl1Qs210p0a0u0\x00", nil))
+	f.Add(stageBinaryBody("sl1Qs210p0a0u0\x00", []byte{1, 2, 3, 4})[:12])
+	f.Add([]byte{0x01, 0x02, 0xFF, 0xFF, 0xFF})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		s := newTestServer()
+		s.CreateStage("sl1Qs210p0a0u0", 4)
+		session := newQueuedSession(t, s, 100, "Hunter")
+
+		set := &mhfpacket.MsgSysSetStageBinary{}
+		if err := set.Parse(byteframe.NewByteFrameFromBytes(data), nil); err != nil {
+			return
+		}
+		handleMsgSysSetStageBinary(session, set)
+
+		// Asking for it back by the same header, with an ack handle and an unknown field in between.
+		bf := byteframe.NewByteFrame()
+		bf.WriteUint32(0x1234)
+		bf.WriteUint8(set.BinaryType0)
+		bf.WriteUint8(set.BinaryType1)
+		bf.WriteUint32(0)
+		bf.WriteUint8(uint8(len(set.StageID)))
+		bf.WriteBytes([]byte(set.StageID))
+		get := &mhfpacket.MsgSysGetStageBinary{}
+		if err := get.Parse(byteframe.NewByteFrameFromBytes(bf.Data()), nil); err != nil {
+			t.Fatalf("the request for the binary failed to parse: %v", err)
+		}
+		handleMsgSysGetStageBinary(session, get)
+		if len(sentPackets(session)) == 0 {
+			t.Error("the request for the binary wasn't answered")
+		}
+	})
+}
+
+func FuzzGuildPostRequest(f *testing.F) {
+	create := []byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3, 'h', 'i', 'a', 'b', 'c'}
+	f.Add(uint32(0), create)
+	f.Add(uint32(0), create[:18])
+	f.Add(uint32(2), []byte{0, 0, 0, 0, 0, 0, 0, 0, 0x62, 0x6E, 0x35, 0x00, 0xFF, 0xFF, 0xFF, 0xFF})
+	f.Add(uint32(4), []byte{0, 0, 0, 1, 0, 0, 0, 0, 0x62, 0x6E, 0x35, 0x00, 1})
+	f.Fuzz(func(t *testing.T, op uint32, data []byte) {
+		req, err := parseGuildPostRequest(op, data)
+		if err != nil && err != bfutil.ErrShortRead {
+			t.Errorf("got error %v, want %v", err, bfutil.ErrShortRead)
+		}
+		if len(req.title)+len(req.body) > len(data) {
+			t.Errorf("title and body of %d bytes out of %d", len(req.title)+len(req.body), len(data))
+		}
+	})
+}
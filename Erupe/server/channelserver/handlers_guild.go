@@ -15,6 +15,7 @@ import (
 
 	"github.com/Andoryuuta/byteframe"
 	"github.com/Solenataris/Erupe/common/bfutil"
+	"github.com/Solenataris/Erupe/common/names"
 	"github.com/Solenataris/Erupe/common/stringsupport"
 	"github.com/Solenataris/Erupe/network/mhfpacket"
 	"github.com/jmoiron/sqlx"
@@ -59,6 +60,7 @@ type Guild struct {
 	Rank           uint16         `db:"rank"`
 	AllianceID     uint32         `db:"alliance_id"`
 	Icon           *GuildIcon     `db:"icon"`
+	Version        uint32         `db:"settings_version"` // Of the settings, moved on by every write of them, see Save.
 
 	GuildLeader
 }
@@ -147,6 +149,7 @@ SELECT
 	)
 	END alliance_id,
 	icon,
+	g.settings_version,
 	(
 		SELECT count(1) FROM guild_characters gc WHERE gc.guild_id = g.id
 	) AS member_count
@@ -155,19 +158,6 @@ SELECT
 	JOIN characters lc on leader_id = lc.id
 `
 
-func (guild *Guild) Save(s *Session) error {
-	_, err := s.server.db.Exec(`
-		UPDATE guilds SET main_motto=$2, sub_motto=$3, comment=$4, pugi_name_1=$5, pugi_name_2=$6, pugi_name_3=$7, festival_colour=$8, icon=$9 WHERE id=$1
-	`, guild.ID, guild.MainMotto, guild.SubMotto, guild.Comment, guild.PugiName1, guild.PugiName2, guild.PugiName3, guild.FestivalColour, guild.Icon)
-
-	if err != nil {
-		s.logger.Error("failed to update guild data", zap.Error(err), zap.Uint32("guildID", guild.ID))
-		return err
-	}
-
-	return nil
-}
-
 func (guild *Guild) CreateApplication(s *Session, charID uint32, applicationType GuildApplicationType, transaction *sql.Tx) error {
 
 	sql := `
@@ -227,7 +217,7 @@ func (guild *Guild) Disband(s *Session) error {
 		return err
 	}
 
-	s.logger.Info("Character disbanded guild", zap.Uint32("charID", s.charID), zap.Uint32("guildID", guild.ID))
+	s.logger.Info("Character disbanded guild", zap.Uint32("guildID", guild.ID))
 
 	return nil
 }
@@ -246,6 +236,7 @@ func (guild *Guild) RemoveCharacter(s *Session, charID uint32) error {
 		return err
 	}
 
+	s.server.clearFestaTeam(charID)
 	return nil
 }
 
@@ -289,6 +280,7 @@ func (guild *Guild) AcceptApplication(s *Session, charID uint32) error {
 		return err
 	}
 
+	s.server.clearFestaTeam(charID)
 	return nil
 }
 
@@ -429,8 +421,8 @@ func CreateGuild(s *Session, guildName string) (int32, error) {
 	}
 
 	guildResult, err := transaction.Query(
-		"INSERT INTO guilds (name, leader_id) VALUES ($1, $2) RETURNING id",
-		guildName, s.charID,
+		"INSERT INTO guilds (name, name_key, leader_id) VALUES ($1, $2, $3) RETURNING id",
+		guildName, names.Key(guildName), s.charID,
 	)
 
 	if err != nil {
@@ -477,6 +469,7 @@ func CreateGuild(s *Session, guildName string) (int32, error) {
 		return 0, err
 	}
 
+	s.server.clearFestaTeam(s.charID)
 	return guildId, nil
 }
 
@@ -586,13 +579,26 @@ func buildGuildObjectFromDbResult(result *sqlx.Rows, err error, s *Session) (*Gu
 		return nil, err
 	}
 
+	// Comments saved before they were sanitized on upload may still hold corrupting bytes.
+	guild.Comment = sanitizeGuildComment(guild.Comment)
+
 	return guild, nil
 }
 
 func handleMsgMhfCreateGuild(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfCreateGuild)
 
-	guildId, err := CreateGuild(s, pkt.Name)
+	err := checkNewName(s, true, pkt.Name, 0)
+
+	if err != nil {
+		refuseName(s, true, pkt.Name, err)
+	}
+
+	var guildId int32
+
+	if err == nil {
+		guildId, err = CreateGuild(s, pkt.Name)
+	}
 
 	if err != nil {
 		bf := byteframe.NewByteFrame()
@@ -628,6 +634,9 @@ func handleMsgMhfOperateGuild(s *Session, p mhfpacket.MHFPacket) {
 		return
 	}
 
+	// Settings edits are based on the guild as the client was last sent it.
+	guild.Version = s.guildSettingsBase(guild.ID, guild.Version)
+
 	bf := byteframe.NewByteFrame()
 
 	switch pkt.Action {
@@ -686,17 +695,12 @@ func handleMsgMhfOperateGuild(s *Session, p mhfpacket.MHFPacket) {
 	case mhfpacket.OPERATE_GUILD_SET_AVOID_LEADERSHIP_FALSE:
 		handleAvoidLeadershipUpdate(s, pkt, false)
 	case mhfpacket.OPERATE_GUILD_UPDATE_COMMENT:
-		pbf := byteframe.NewByteFrameFromBytes(pkt.UnkData)
-
 		if !characterGuildInfo.IsLeader && !characterGuildInfo.IsSubLeader() {
 			doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
 			return
 		}
 
-		commentLength := pbf.ReadUint8()
-		_ = pbf.ReadUint32()
-
-		guild.Comment, err = s.clientContext.StrConv.Decode(bfutil.UpToNull(pbf.ReadBytes(uint(commentLength))))
+		guild.Comment, err = readGuildComment(s, pkt)
 
 		if err != nil {
 			s.logger.Warn("failed to convert guild comment to UTF8", zap.Error(err))
@@ -707,7 +711,7 @@ func handleMsgMhfOperateGuild(s *Session, p mhfpacket.MHFPacket) {
 		err = guild.Save(s)
 
 		if err != nil {
-			doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+			failSettingsWrite(s, pkt.AckHandle, err)
 			return
 		}
 
@@ -724,19 +728,28 @@ func handleMsgMhfOperateGuild(s *Session, p mhfpacket.MHFPacket) {
 		err := guild.Save(s)
 
 		if err != nil {
-			doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+			failSettingsWrite(s, pkt.AckHandle, err)
 			return
 		}
 	case mhfpacket.OPERATE_GUILD_RENAME_PUGI_1:
-		handleRenamePugi(s, pkt.UnkData, guild, 1)
+		if err := handleRenamePugi(s, pkt.UnkData, guild, 1); err != nil {
+			failSettingsWrite(s, pkt.AckHandle, err)
+			return
+		}
 		doAckSimpleSucceed(s, pkt.AckHandle, make([]byte, 4))
 		return
 	case mhfpacket.OPERATE_GUILD_RENAME_PUGI_2:
-		handleRenamePugi(s, pkt.UnkData, guild, 2)
+		if err := handleRenamePugi(s, pkt.UnkData, guild, 2); err != nil {
+			failSettingsWrite(s, pkt.AckHandle, err)
+			return
+		}
 		doAckSimpleSucceed(s, pkt.AckHandle, make([]byte, 4))
 		return
 	case mhfpacket.OPERATE_GUILD_RENAME_PUGI_3:
-		handleRenamePugi(s, pkt.UnkData, guild, 3)
+		if err := handleRenamePugi(s, pkt.UnkData, guild, 3); err != nil {
+			failSettingsWrite(s, pkt.AckHandle, err)
+			return
+		}
 		doAckSimpleSucceed(s, pkt.AckHandle, make([]byte, 4))
 		return
 	case mhfpacket.OPERATE_GUILD_CHANGE_PUGI_1:
@@ -757,11 +770,14 @@ func handleMsgMhfOperateGuild(s *Session, p mhfpacket.MHFPacket) {
 	doAckSimpleSucceed(s, pkt.AckHandle, bf.Data())
 }
 
-func handleRenamePugi(s *Session, data []byte, guild *Guild, num int) {
+func handleRenamePugi(s *Session, data []byte, guild *Guild, num int) error {
 	bf := byteframe.NewByteFrameFromBytes(data)
 	_ = bf.ReadUint8() // len
 	_ = bf.ReadUint32() // unk
-	name, _ := stringsupport.ConvertSJISBytesToString(bf.ReadNullTerminatedBytes())
+	name, err := bfutil.ReadNullTerminatedShiftJISString(bf, nameMaxLength)
+	if err != nil {
+		return err
+	}
 	switch num {
 	case 1:
 		guild.PugiName1 = name
@@ -770,7 +786,7 @@ func handleRenamePugi(s *Session, data []byte, guild *Guild, num int) {
 	default:
 		guild.PugiName3 = name
 	}
-	guild.Save(s)
+	return guild.Save(s)
 }
 
 func handleDonateRP(s *Session, pkt *mhfpacket.MsgMhfOperateGuild, bf *byteframe.ByteFrame, guild *Guild, isEvent bool) error {
@@ -891,15 +907,7 @@ func handleMsgMhfInfoGuild(s *Session, p mhfpacket.MHFPacket) {
 	}
 
 	if err == nil && guild != nil {
-		guildName, _ := stringsupport.ConvertUTF8ToShiftJIS(guild.Name)
-		guildComment, _ := stringsupport.ConvertUTF8ToShiftJIS(guild.Comment)
 		characterGuildData, err := GetCharacterGuildData(s, s.charID)
-		characterJoinedAt := uint32(0xFFFFFFFF)
-
-		if characterGuildData != nil && characterGuildData.JoinedAt != nil {
-			characterJoinedAt = uint32(characterGuildData.JoinedAt.Unix())
-		}
-
 		if err != nil {
 			resp := byteframe.NewByteFrame()
 			resp.WriteUint32(0) // Count
@@ -909,152 +917,12 @@ func handleMsgMhfInfoGuild(s *Session, p mhfpacket.MHFPacket) {
 			return
 		}
 
-		bf := byteframe.NewByteFrame()
-
-		bf.WriteUint32(guild.ID)
-		bf.WriteUint32(guild.LeaderCharID)
-		bf.WriteUint16(guild.Rank)
-		bf.WriteUint16(guild.MemberCount)
-
-		bf.WriteUint8(guild.MainMotto)
-		bf.WriteUint8(guild.SubMotto)
-
-		// Unk appears to be static
-		bf.WriteBytes([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
-
-		if characterGuildData == nil || characterGuildData.IsApplicant {
-			bf.WriteUint16(0x00)
-		} else if guild.LeaderCharID == s.charID {
-			bf.WriteUint16(0x01)
-		} else {
-			bf.WriteUint16(0x02)
-		}
-
-		leaderName := s.clientContext.StrConv.MustEncode(guild.LeaderName)
-
-		bf.WriteUint32(uint32(guild.CreatedAt.Unix()))
-		bf.WriteUint32(characterJoinedAt)
-		bf.WriteUint8(uint8(len(guildName)))
-		bf.WriteUint8(uint8(len(guildComment)))
-		bf.WriteUint8(uint8(5)) // Length of unknown string below
-		bf.WriteUint8(uint8(len(leaderName) + 1))
-		bf.WriteBytes(guildName)
-		bf.WriteBytes(guildComment)
-		bf.WriteUint8(FestivalColourCodes[guild.FestivalColour])
-		bf.WriteUint32(guild.RankRP)
-		bf.WriteNullTerminatedBytes(leaderName)
-		bf.WriteBytes([]byte{0x00, 0x00, 0x00, 0x00}) // Unk
-		bf.WriteBool(false)                           // isReturnGuild
-		bf.WriteBytes([]byte{0x01, 0x02, 0x02})       // Unk
-		bf.WriteUint32(guild.EventRP)
-
-		if guild.PugiName1 == "" {
-			bf.WriteUint16(0x0100)
-		} else {
-			bf.WriteUint8(uint8(len(guild.PugiName1)+1))
-			pugiName := s.clientContext.StrConv.MustEncode(guild.PugiName1)
-			bf.WriteNullTerminatedBytes(pugiName)
-		}
-		if guild.PugiName2 == "" {
-			bf.WriteUint16(0x0100)
-		} else {
-			bf.WriteUint8(uint8(len(guild.PugiName2)+1))
-			pugiName := s.clientContext.StrConv.MustEncode(guild.PugiName2)
-			bf.WriteNullTerminatedBytes(pugiName)
-		}
-		if guild.PugiName3 == "" {
-			bf.WriteUint16(0x0100)
-		} else {
-			bf.WriteUint8(uint8(len(guild.PugiName3)+1))
-			pugiName := s.clientContext.StrConv.MustEncode(guild.PugiName3)
-			bf.WriteNullTerminatedBytes(pugiName)
-		}
-
-		// probably guild pugi properties, should be status, stamina and luck outfits
-		bf.WriteBytes([]byte{
-			0x04, 0x02, 0x03, 0x04, 0x02, 0x03, 0x00, 0x00, 0x00, 0x4E,
-		})
-
-		// Unk flags
-		bf.WriteUint8(0x3C) // also seen as 0x32 on JP and 0x64 on TW
-
-		bf.WriteBytes([]byte{
-			0x00, 0x00, 0xD6, 0xD8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-		})
-
+		var alliance *GuildAlliance
 		if guild.AllianceID > 0 {
-			alliance, err := GetAllianceData(s, guild.AllianceID)
-			if err != nil {
-				bf.WriteUint32(0) // Error, no alliance
-			} else {
-				allianceName, _ := stringsupport.ConvertUTF8ToShiftJIS(alliance.Name)
-				allianceParentName, _ := stringsupport.ConvertUTF8ToShiftJIS(alliance.ParentGuild.Name)
-				allianceParentOwner, _ := stringsupport.ConvertUTF8ToShiftJIS(alliance.ParentGuild.LeaderName)
-				allianceSub1Name, _ := stringsupport.ConvertUTF8ToShiftJIS(alliance.SubGuild1.Name)
-				allianceSub1Owner, _ := stringsupport.ConvertUTF8ToShiftJIS(alliance.SubGuild1.LeaderName)
-				allianceSub2Name, _ := stringsupport.ConvertUTF8ToShiftJIS(alliance.SubGuild2.Name)
-				allianceSub2Owner, _ := stringsupport.ConvertUTF8ToShiftJIS(alliance.SubGuild2.LeaderName)
-				bf.WriteUint32(alliance.ID)
-				bf.WriteUint32(uint32(alliance.CreatedAt.Unix()))
-				bf.WriteUint16(uint16(alliance.TotalMembers))
-				bf.WriteUint16(0) // Unk0
-				bf.WriteUint16(uint16(len(allianceName)))
-				bf.WriteBytes(allianceName)
-				if alliance.SubGuild1ID > 0 {
-					if alliance.SubGuild2ID > 0 {
-						bf.WriteUint8(3)
-					} else {
-						bf.WriteUint8(2)
-					}
-				} else {
-					bf.WriteUint8(1)
-				}
-				bf.WriteUint32(alliance.ParentGuildID)
-				bf.WriteUint32(0) // Unk1
-				if alliance.ParentGuildID == guild.ID {
-					bf.WriteUint16(1)
-				} else {
-					bf.WriteUint16(0)
-				}
-				bf.WriteUint16(alliance.ParentGuild.Rank)
-				bf.WriteUint16(alliance.ParentGuild.MemberCount)
-				bf.WriteUint16(uint16(len(allianceParentName)))
-				bf.WriteBytes(allianceParentName)
-				bf.WriteUint16(uint16(len(allianceParentOwner)))
-				bf.WriteBytes(allianceParentOwner)
-				if alliance.SubGuild1ID > 0 {
-					bf.WriteUint32(alliance.SubGuild1ID)
-					bf.WriteUint32(0) // Unk1
-					if alliance.SubGuild1ID == guild.ID {
-						bf.WriteUint16(1)
-					} else {
-						bf.WriteUint16(0)
-					}
-					bf.WriteUint16(alliance.SubGuild1.Rank)
-					bf.WriteUint16(alliance.SubGuild1.MemberCount)
-					bf.WriteUint16(uint16(len(allianceSub1Name)))
-					bf.WriteBytes(allianceSub1Name)
-					bf.WriteUint16(uint16(len(allianceSub1Owner)))
-					bf.WriteBytes(allianceSub1Owner)
-				}
-				if alliance.SubGuild2ID > 0 {
-					bf.WriteUint32(alliance.SubGuild2ID)
-					bf.WriteUint32(0) // Unk1
-					if alliance.SubGuild2ID == guild.ID {
-						bf.WriteUint16(1)
-					} else {
-						bf.WriteUint16(0)
-					}
-					bf.WriteUint16(alliance.SubGuild2.Rank)
-					bf.WriteUint16(alliance.SubGuild2.MemberCount)
-					bf.WriteUint16(uint16(len(allianceSub2Name)))
-					bf.WriteBytes(allianceSub2Name)
-					bf.WriteUint16(uint16(len(allianceSub2Owner)))
-					bf.WriteBytes(allianceSub2Owner)
-				}
+			// A guild whose alliance can't be read is sent as having none.
+			if alliance, err = GetAllianceData(s, guild.AllianceID); err != nil {
+				alliance = nil
 			}
-		} else {
-			bf.WriteUint32(0) // No alliance
 		}
 
 		applicants, err := GetGuildMembers(s, guild.ID, true)
@@ -1067,54 +935,8 @@ func handleMsgMhfInfoGuild(s *Session, p mhfpacket.MHFPacket) {
 			doAckBufSucceed(s, pkt.AckHandle, resp.Data())
 		}
 
-		bf.WriteUint16(uint16(len(applicants)))
-
-		for _, applicant := range applicants {
-			applicantName := s.clientContext.StrConv.MustEncode(applicant.Name)
-			bf.WriteUint32(applicant.CharID)
-			bf.WriteUint32(0x05)
-			bf.WriteUint16(0x0032)
-			bf.WriteUint8(0x00)
-			bf.WriteUint16(uint16(len(applicantName)+1))
-			bf.WriteNullTerminatedBytes(applicantName)
-		}
-
-		bf.WriteUint16(0x0000)
-
-		/*
-		alliance application format
-		uint16 numapplicants (above)
-
-		uint32 guild id
-		uint32 guild leader id (for mail)
-		uint32 unk (always null in pcap)
-		uint16 unk (always 0001 in pcap)
-		uint16 len guild name
-		string nullterm guild name
-		uint16 len guild leader name
-		string nullterm guild leader name
-		*/
-
-		if guild.Icon != nil {
-			bf.WriteUint8(uint8(len(guild.Icon.Parts)))
-
-			for _, p := range guild.Icon.Parts {
-				bf.WriteUint16(p.Index)
-				bf.WriteUint16(p.ID)
-				bf.WriteUint8(p.Page)
-				bf.WriteUint8(p.Size)
-				bf.WriteUint8(p.Rotation)
-				bf.WriteUint8(p.Red)
-				bf.WriteUint8(p.Green)
-				bf.WriteUint8(p.Blue)
-				bf.WriteUint16(p.PosX)
-				bf.WriteUint16(p.PosY)
-			}
-		} else {
-			bf.WriteUint8(0x00)
-		}
-
-		doAckBufSucceed(s, pkt.AckHandle, bf.Data())
+		s.sawGuildSettings(guild.ID, guild.Version)
+		doAckBufSucceed(s, pkt.AckHandle, buildGuildInfo(s, guild, characterGuildData, alliance, applicants))
 	} else {
 		//// REALLY large/complex format... stubbing it out here for simplicity.
 		//resp := byteframe.NewByteFrame()
@@ -1125,6 +947,229 @@ func handleMsgMhfInfoGuild(s *Session, p mhfpacket.MHFPacket) {
 	}
 }
 
+// buildGuildInfo serializes the guild as the session's character sees it, from its membership of the guild,
+// nil if it has none, the guild's alliance, nil if it has none, and the guild's applicants.
+func buildGuildInfo(s *Session, guild *Guild, characterGuildData *GuildMember, alliance *GuildAlliance, applicants []*GuildMember) []byte {
+	guildName := stringsupport.EncodeShiftJIS(guild.Name, guildListTextMaxLength)
+	guildComment := stringsupport.EncodeShiftJIS(guild.Comment, guildCommentMaxLength)
+	characterJoinedAt := uint32(0xFFFFFFFF)
+
+	if characterGuildData != nil && characterGuildData.JoinedAt != nil {
+		characterJoinedAt = uint32(characterGuildData.JoinedAt.Unix())
+	}
+
+	bf := byteframe.NewByteFrame()
+
+	bf.WriteUint32(guild.ID)
+	bf.WriteUint32(guild.LeaderCharID)
+	bf.WriteUint16(guild.Rank)
+	bf.WriteUint16(guild.MemberCount)
+
+	bf.WriteUint8(guild.MainMotto)
+	bf.WriteUint8(guild.SubMotto)
+
+	// Unk appears to be static
+	bf.WriteBytes([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+
+	if characterGuildData == nil || characterGuildData.IsApplicant {
+		bf.WriteUint16(0x00)
+	} else if guild.LeaderCharID == s.charID {
+		bf.WriteUint16(0x01)
+	} else {
+		bf.WriteUint16(0x02)
+	}
+
+	leaderName := stringsupport.EncodeShiftJIS(guild.LeaderName, nameMaxLength)
+
+	bf.WriteUint32(uint32(guild.CreatedAt.Unix()))
+	bf.WriteUint32(characterJoinedAt)
+	bf.WriteUint8(uint8(len(guildName)))
+	bf.WriteUint8(uint8(len(guildComment)))
+	bf.WriteUint8(uint8(5)) // Length of unknown string below
+	bf.WriteUint8(uint8(len(leaderName) + 1))
+	bf.WriteBytes(guildName)
+	bf.WriteBytes(guildComment)
+	bf.WriteUint8(FestivalColourCodes[guild.FestivalColour])
+	bf.WriteUint32(guild.RankRP)
+	bf.WriteNullTerminatedBytes(leaderName)
+	bf.WriteBytes([]byte{0x00, 0x00, 0x00, 0x00}) // Unk
+	bf.WriteBool(false)                           // isReturnGuild
+	bf.WriteBytes([]byte{0x01, 0x02, 0x02})       // Unk
+	bf.WriteUint32(guild.EventRP)
+
+	if guild.PugiName1 == "" {
+		bf.WriteUint16(0x0100)
+	} else {
+		pugiName := stringsupport.EncodeShiftJIS(guild.PugiName1, nameMaxLength)
+		bf.WriteUint8(uint8(len(pugiName)+1))
+		bf.WriteNullTerminatedBytes(pugiName)
+	}
+	if guild.PugiName2 == "" {
+		bf.WriteUint16(0x0100)
+	} else {
+		pugiName := stringsupport.EncodeShiftJIS(guild.PugiName2, nameMaxLength)
+		bf.WriteUint8(uint8(len(pugiName)+1))
+		bf.WriteNullTerminatedBytes(pugiName)
+	}
+	if guild.PugiName3 == "" {
+		bf.WriteUint16(0x0100)
+	} else {
+		pugiName := stringsupport.EncodeShiftJIS(guild.PugiName3, nameMaxLength)
+		bf.WriteUint8(uint8(len(pugiName)+1))
+		bf.WriteNullTerminatedBytes(pugiName)
+	}
+
+	// probably guild pugi properties, should be status, stamina and luck outfits
+	bf.WriteBytes([]byte{
+		0x04, 0x02, 0x03, 0x04, 0x02, 0x03, 0x00, 0x00, 0x00, 0x4E,
+	})
+
+	// Unk flags
+	bf.WriteUint8(0x3C) // also seen as 0x32 on JP and 0x64 on TW
+
+	bf.WriteBytes([]byte{
+		0x00, 0x00, 0xD6, 0xD8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	})
+
+	if alliance != nil {
+		allianceName := stringsupport.EncodeShiftJIS(alliance.Name, nameMaxLength)
+		allianceParentName := stringsupport.EncodeShiftJIS(alliance.ParentGuild.Name, nameMaxLength)
+		allianceParentOwner := stringsupport.EncodeShiftJIS(alliance.ParentGuild.LeaderName, nameMaxLength)
+		allianceSub1Name := stringsupport.EncodeShiftJIS(alliance.SubGuild1.Name, nameMaxLength)
+		allianceSub1Owner := stringsupport.EncodeShiftJIS(alliance.SubGuild1.LeaderName, nameMaxLength)
+		allianceSub2Name := stringsupport.EncodeShiftJIS(alliance.SubGuild2.Name, nameMaxLength)
+		allianceSub2Owner := stringsupport.EncodeShiftJIS(alliance.SubGuild2.LeaderName, nameMaxLength)
+		bf.WriteUint32(alliance.ID)
+		bf.WriteUint32(uint32(alliance.CreatedAt.Unix()))
+		bf.WriteUint16(uint16(alliance.TotalMembers))
+		bf.WriteUint16(0) // Unk0
+		bf.WriteUint16(uint16(len(allianceName)))
+		bf.WriteBytes(allianceName)
+		if alliance.SubGuild1ID > 0 {
+			if alliance.SubGuild2ID > 0 {
+				bf.WriteUint8(3)
+			} else {
+				bf.WriteUint8(2)
+			}
+		} else {
+			bf.WriteUint8(1)
+		}
+		bf.WriteUint32(alliance.ParentGuildID)
+		bf.WriteUint32(0) // Unk1
+		if alliance.ParentGuildID == guild.ID {
+			bf.WriteUint16(1)
+		} else {
+			bf.WriteUint16(0)
+		}
+		bf.WriteUint16(alliance.ParentGuild.Rank)
+		bf.WriteUint16(alliance.ParentGuild.MemberCount)
+		bf.WriteUint16(uint16(len(allianceParentName)))
+		bf.WriteBytes(allianceParentName)
+		bf.WriteUint16(uint16(len(allianceParentOwner)))
+		bf.WriteBytes(allianceParentOwner)
+		if alliance.SubGuild1ID > 0 {
+			bf.WriteUint32(alliance.SubGuild1ID)
+			bf.WriteUint32(0) // Unk1
+			if alliance.SubGuild1ID == guild.ID {
+				bf.WriteUint16(1)
+			} else {
+				bf.WriteUint16(0)
+			}
+			bf.WriteUint16(alliance.SubGuild1.Rank)
+			bf.WriteUint16(alliance.SubGuild1.MemberCount)
+			bf.WriteUint16(uint16(len(allianceSub1Name)))
+			bf.WriteBytes(allianceSub1Name)
+			bf.WriteUint16(uint16(len(allianceSub1Owner)))
+			bf.WriteBytes(allianceSub1Owner)
+		}
+		if alliance.SubGuild2ID > 0 {
+			bf.WriteUint32(alliance.SubGuild2ID)
+			bf.WriteUint32(0) // Unk1
+			if alliance.SubGuild2ID == guild.ID {
+				bf.WriteUint16(1)
+			} else {
+				bf.WriteUint16(0)
+			}
+			bf.WriteUint16(alliance.SubGuild2.Rank)
+			bf.WriteUint16(alliance.SubGuild2.MemberCount)
+			bf.WriteUint16(uint16(len(allianceSub2Name)))
+			bf.WriteBytes(allianceSub2Name)
+			bf.WriteUint16(uint16(len(allianceSub2Owner)))
+			bf.WriteBytes(allianceSub2Owner)
+		}
+	} else {
+		bf.WriteUint32(0) // No alliance
+	}
+
+	bf.WriteUint16(uint16(len(applicants)))
+
+	for _, applicant := range applicants {
+		applicantName := stringsupport.EncodeShiftJIS(applicant.Name, nameMaxLength)
+		bf.WriteUint32(applicant.CharID)
+		bf.WriteUint32(0x05)
+		bf.WriteUint16(0x0032)
+		bf.WriteUint8(0x00)
+		bf.WriteUint16(uint16(len(applicantName)+1))
+		bf.WriteNullTerminatedBytes(applicantName)
+	}
+
+	bf.WriteUint16(0x0000)
+
+	/*
+	alliance application format
+	uint16 numapplicants (above)
+
+	uint32 guild id
+	uint32 guild leader id (for mail)
+	uint32 unk (always null in pcap)
+	uint16 unk (always 0001 in pcap)
+	uint16 len guild name
+	string nullterm guild name
+	uint16 len guild leader name
+	string nullterm guild leader name
+	*/
+
+	if guild.Icon != nil {
+		bf.WriteUint8(uint8(len(guild.Icon.Parts)))
+
+		for _, p := range guild.Icon.Parts {
+			bf.WriteUint16(p.Index)
+			bf.WriteUint16(p.ID)
+			bf.WriteUint8(p.Page)
+			bf.WriteUint8(p.Size)
+			bf.WriteUint8(p.Rotation)
+			bf.WriteUint8(p.Red)
+			bf.WriteUint8(p.Green)
+			bf.WriteUint8(p.Blue)
+			bf.WriteUint16(p.PosX)
+			bf.WriteUint16(p.PosY)
+		}
+	} else {
+		bf.WriteUint8(0x00)
+	}
+
+	return bf.Data()
+}
+
+// readGuildSearchTerm reads the name a guild search looks for, its length the client's.
+func readGuildSearchTerm(s *Session, bf *byteframe.ByteFrame) (string, error) {
+	if _, err := bfutil.TryReadBytes(bf, 8); err != nil {
+		return "", err
+	}
+	searchTermLength, err := bfutil.TryReadUint16(bf)
+	if err != nil {
+		return "", err
+	}
+	if _, err := bfutil.TryReadBytes(bf, 1); err != nil {
+		return "", err
+	}
+	searchTerm, err := bfutil.TryReadBytes(bf, uint(searchTermLength))
+	if err != nil {
+		return "", err
+	}
+	return s.clientContext.StrConv.Decode(bfutil.UpToNull(searchTerm))
+}
+
 func handleMsgMhfEnumerateGuild(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfEnumerateGuild)
 
@@ -1135,25 +1180,21 @@ func handleMsgMhfEnumerateGuild(s *Session, p mhfpacket.MHFPacket) {
 
 	switch pkt.Type {
 	case mhfpacket.ENUMERATE_GUILD_TYPE_GUILD_NAME:
-		bf.ReadBytes(8)
-		searchTermLength := bf.ReadUint16()
-		bf.ReadBytes(1)
-		searchTerm := bf.ReadBytes(uint(searchTermLength))
 		var searchTermSafe string
-		searchTermSafe, err = s.clientContext.StrConv.Decode(bfutil.UpToNull(searchTerm))
+		searchTermSafe, err = readGuildSearchTerm(s, bf)
 		if err != nil {
-			panic(err)
+			s.logger.Warn("Dropped malformed guild search", zap.Error(err))
+			doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+			return
 		}
 		guilds, err = FindGuildsByName(s, searchTermSafe)
 	case mhfpacket.ENUMERATE_GUILD_TYPE_LEADER_NAME:
-		bf.ReadBytes(8)
-		searchTermLength := bf.ReadUint16()
-		bf.ReadBytes(1)
-		searchTerm := bf.ReadBytes(uint(searchTermLength))
 		var searchTermSafe string
-		searchTermSafe, err = s.clientContext.StrConv.Decode(bfutil.UpToNull(searchTerm))
+		searchTermSafe, err = readGuildSearchTerm(s, bf)
 		if err != nil {
-			panic(err)
+			s.logger.Warn("Dropped malformed guild search", zap.Error(err))
+			doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+			return
 		}
 		rows, err = s.server.db.Queryx(fmt.Sprintf(`%s WHERE lc.name ILIKE $1`, guildInfoSelectQuery), searchTermSafe)
 		if err != nil {
@@ -1255,32 +1296,52 @@ func handleMsgMhfEnumerateGuild(s *Session, p mhfpacket.MHFPacket) {
 		return
 	}
 
-	bf = byteframe.NewByteFrame()
-	bf.WriteUint16(uint16(len(guilds)))
+	data, shown := buildGuildSearchResults(s, guilds)
+	doAckBufSucceed(s, pkt.AckHandle, data)
+	if shown < len(guilds) {
+		sendServerChatMessage(s, fmt.Sprintf("Showing the first %d of %d guilds, narrow the search to see the rest.", shown, len(guilds)))
+	}
+}
 
+// buildGuildSearchResults builds the guild search response, leaving out the guilds that
+// wouldn't fit in a single frame. It returns the response and the number of guilds in it.
+func buildGuildSearchResults(s *Session, guilds []*Guild) ([]byte, int) {
+	entries := byteframe.NewByteFrame()
+	shown := 0
 	for _, guild := range guilds {
-		guildName := s.clientContext.StrConv.MustEncode(guild.Name)
-		leaderName := s.clientContext.StrConv.MustEncode(guild.LeaderName)
-
-		bf.WriteUint8(0x00) // Unk
-		bf.WriteUint32(guild.ID)
-		bf.WriteUint32(guild.LeaderCharID)
-		bf.WriteUint16(guild.MemberCount)
-		bf.WriteUint8(0x00) // Unk
-		bf.WriteUint8(0x00) // Unk
-		bf.WriteUint16(guild.Rank)
-		bf.WriteUint32(uint32(guild.CreatedAt.Unix()))
-		bf.WriteUint8(uint8(len(guildName) + 1))
-		bf.WriteNullTerminatedBytes(guildName)
-		bf.WriteUint8(uint8(len(leaderName) + 1))
-		bf.WriteNullTerminatedBytes(leaderName)
-		bf.WriteUint8(0x01) // Unk
+		guildName := encodeGuildListText(s, guild.Name)
+		leaderName := encodeGuildListText(s, guild.LeaderName)
+
+		entry := byteframe.NewByteFrame()
+		entry.WriteUint8(0x00) // Unk
+		entry.WriteUint32(guild.ID)
+		entry.WriteUint32(guild.LeaderCharID)
+		entry.WriteUint16(guild.MemberCount)
+		entry.WriteUint8(0x00) // Unk
+		entry.WriteUint8(0x00) // Unk
+		entry.WriteUint16(guild.Rank)
+		entry.WriteUint32(uint32(guild.CreatedAt.Unix()))
+		entry.WriteUint8(uint8(len(guildName) + 1))
+		entry.WriteNullTerminatedBytes(guildName)
+		entry.WriteUint8(uint8(len(leaderName) + 1))
+		entry.WriteNullTerminatedBytes(leaderName)
+		entry.WriteUint8(0x01) // Unk
+
+		// The count before the entries and the two bytes after them.
+		if !s.ackFits(2 + len(entries.Data()) + len(entry.Data()) + 2) {
+			s.recordClippedResponse()
+			break
+		}
+		entries.WriteBytes(entry.Data())
+		shown++
 	}
 
+	bf := byteframe.NewByteFrame()
+	bf.WriteUint16(uint16(shown))
+	bf.WriteBytes(entries.Data())
 	bf.WriteUint8(0x01) // Unk
 	bf.WriteUint8(0x00) // Unk
-
-	doAckBufSucceed(s, pkt.AckHandle, bf.Data())
+	return bf.Data(), shown
 }
 
 func handleMsgMhfArrangeGuildMember(s *Session, p mhfpacket.MHFPacket) {
@@ -1291,14 +1352,12 @@ func handleMsgMhfArrangeGuildMember(s *Session, p mhfpacket.MHFPacket) {
 	if err != nil {
 		s.logger.Error(
 			"failed to respond to ArrangeGuildMember message",
-			zap.Uint32("charID", s.charID),
 		)
 		return
 	}
 
 	if guild.LeaderCharID != s.charID {
 		s.logger.Error("non leader attempting to rearrange guild members!",
-			zap.Uint32("charID", s.charID),
 			zap.Uint32("guildID", guild.ID),
 		)
 		return
@@ -1309,7 +1368,6 @@ func handleMsgMhfArrangeGuildMember(s *Session, p mhfpacket.MHFPacket) {
 	if err != nil {
 		s.logger.Error(
 			"failed to respond to ArrangeGuildMember message",
-			zap.Uint32("charID", s.charID),
 			zap.Uint32("guildID", guild.ID),
 		)
 		return
@@ -1355,7 +1413,7 @@ func handleMsgMhfEnumerateGuildMember(s *Session, p mhfpacket.MHFPacket) {
 	})
 
 	for _, member := range guildMembers {
-		name := s.clientContext.StrConv.MustEncode(member.Name)
+		name := stringsupport.EncodeShiftJIS(member.Name, nameMaxLength)
 
 		bf.WriteUint32(member.CharID)
 		bf.WriteUint16(member.HRP)
@@ -1565,7 +1623,6 @@ func handleMsgMhfUpdateGuildIcon(s *Session, p mhfpacket.MHFPacket) {
 		s.logger.Warn(
 			"character without leadership attempting to update guild icon",
 			zap.Uint32("guildID", guild.ID),
-			zap.Uint32("charID", s.charID),
 		)
 		doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
 		return
@@ -1591,11 +1648,12 @@ func handleMsgMhfUpdateGuildIcon(s *Session, p mhfpacket.MHFPacket) {
 	}
 
 	guild.Icon = icon
+	guild.Version = s.guildSettingsBase(guild.ID, guild.Version)
 
 	err = guild.Save(s)
 
 	if err != nil {
-		doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+		failSettingsWrite(s, pkt.AckHandle, err)
 		return
 	}
 
@@ -1703,7 +1761,13 @@ func handleMsgMhfEnumerateGuildMessageBoard(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfEnumerateGuildMessageBoard)
 	guild, _ := GetGuildInfoByCharacterId(s, s.charID)
 
-	msgs, err := s.server.db.Queryx("SELECT post_type, stamp_id, title, body, author_id, (EXTRACT(epoch FROM created_at)::int) as created_at, liked_by FROM guild_posts WHERE guild_id = $1 AND post_type = $2 ORDER BY created_at DESC", guild.ID, int(pkt.BoardType))
+	// The guild's latest announcements are listed among the messages, the news board softlocks past 4 posts.
+	msgs, err := s.server.db.Queryx(`
+		SELECT post_type, stamp_id, title, body, author_id, (EXTRACT(epoch FROM created_at)::int) as created_at, liked_by FROM guild_posts WHERE guild_id = $1 AND post_type = $2
+		UNION ALL
+		(SELECT 0, 0, $3, message, author_id, EXTRACT(epoch FROM created_at)::int, '' FROM guild_announcements WHERE guild_id = $1 AND $2 = 0 ORDER BY id DESC LIMIT $4)
+		ORDER BY created_at DESC
+	`, guild.ID, int(pkt.BoardType), guildAnnouncementTitle, s.server.erupeConfig.Current().GuildAnnouncements.History)
 	if err != nil {
 		s.logger.Fatal("Failed to get guild messages from db", zap.Error(err))
 	}
@@ -1711,9 +1775,15 @@ func handleMsgMhfEnumerateGuildMessageBoard(s *Session, p mhfpacket.MHFPacket) {
 	bf := byteframe.NewByteFrame()
 	noMsgs := true
 	postCount := 0
+	totalPosts := 0
+	full := false
 	for msgs.Next() {
 		noMsgs = false
-		postCount++
+		totalPosts++
+		if full {
+			// Keep counting the posts left out.
+			continue
+		}
 		var titleConv, bodyConv string
 
 		postData := &MessageBoardPost{}
@@ -1722,9 +1792,6 @@ func handleMsgMhfEnumerateGuildMessageBoard(s *Session, p mhfpacket.MHFPacket) {
 			s.logger.Fatal("Failed to get guild messages from db", zap.Error(err))
 		}
 
-		bf.WriteUint32(postData.Type)
-		bf.WriteUint32(postData.AuthorID)
-		bf.WriteUint64(postData.Timestamp)
 		liked := false
 		likedBySlice := strings.Split(postData.LikedBy, ",")
 		for i := 0; i < len(likedBySlice); i++ {
@@ -1734,20 +1801,34 @@ func handleMsgMhfEnumerateGuildMessageBoard(s *Session, p mhfpacket.MHFPacket) {
 				break
 			}
 		}
+		likes := uint32(len(likedBySlice))
 		if likedBySlice[0] == "" {
-			bf.WriteUint32(0)
-		} else {
-			bf.WriteUint32(uint32(len(likedBySlice)))
+			likes = 0
 		}
 
-		titleConv, _ = stringsupport.ConvertUTF8ToSJIS(postData.Title)
-		bodyConv, _ = stringsupport.ConvertUTF8ToSJIS(postData.Body)
-		bf.WriteBool(liked)
-		bf.WriteUint32(postData.StampID)
-		bf.WriteUint32(uint32(len(titleConv)))
-		bf.WriteBytes([]byte(titleConv))
-		bf.WriteUint32(uint32(len(bodyConv)))
-		bf.WriteBytes([]byte(bodyConv))
+		// Shift-JIS is never longer than UTF-8, nothing is cut.
+		titleConv = string(stringsupport.EncodeShiftJIS(postData.Title, len(postData.Title)))
+		bodyConv = string(stringsupport.EncodeShiftJIS(postData.Body, len(postData.Body)))
+		post := byteframe.NewByteFrame()
+		post.WriteUint32(postData.Type)
+		post.WriteUint32(postData.AuthorID)
+		post.WriteUint64(postData.Timestamp)
+		post.WriteUint32(likes)
+		post.WriteBool(liked)
+		post.WriteUint32(postData.StampID)
+		post.WriteUint32(uint32(len(titleConv)))
+		post.WriteBytes([]byte(titleConv))
+		post.WriteUint32(uint32(len(bodyConv)))
+		post.WriteBytes([]byte(bodyConv))
+
+		// The posts are newest first, so the oldest are left out once the frame is full.
+		if !s.ackFits(4 + len(bf.Data()) + len(post.Data())) {
+			full = true
+			s.recordClippedResponse()
+			continue
+		}
+		bf.WriteBytes(post.Data())
+		postCount++
 	}
 	if noMsgs {
 		doAckBufSucceed(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
@@ -1756,28 +1837,85 @@ func handleMsgMhfEnumerateGuildMessageBoard(s *Session, p mhfpacket.MHFPacket) {
 		data.WriteUint32(uint32(postCount))
 		data.WriteBytes(bf.Data())
 		doAckBufSucceed(s, pkt.AckHandle, data.Data())
+		if postCount < totalPosts {
+			sendServerChatMessage(s, fmt.Sprintf("Showing the newest %d of %d posts.", postCount, totalPosts))
+		}
 	}
 }
 
+// guildPostRequest is the request of a MSG_MHF_UPDATE_GUILD_MESSAGE_BOARD, the fields its operation has.
+type guildPostRequest struct {
+	postType  uint32 // 0 = message, 1 = news
+	stampID   uint32
+	timestamp uint64 // Of the post operated on, its creation in seconds.
+	title     []byte
+	body      []byte
+	like      bool
+}
+
+// parseGuildPostRequest reads the request of a message board operation. The title and body lengths are
+// the client's, a request shorter than they or the operation claim fails with bfutil.ErrShortRead.
+func parseGuildPostRequest(op uint32, data []byte) (guildPostRequest, error) {
+	var req guildPostRequest
+	var err error
+	bf := byteframe.NewByteFrameFromBytes(data)
+	if op > 4 {
+		return req, nil
+	}
+	if req.postType, err = bfutil.TryReadUint32(bf); err != nil {
+		return req, err
+	}
+	switch op {
+	case 0: // Create message
+		if req.stampID, err = bfutil.TryReadUint32(bf); err != nil {
+			return req, err
+		}
+	default:
+		if req.timestamp, err = bfutil.TryReadUint64(bf); err != nil {
+			return req, err
+		}
+	}
+	switch op {
+	case 0, 2: // Create or update message
+		titleLength, err := bfutil.TryReadUint32(bf)
+		if err != nil {
+			return req, err
+		}
+		bodyLength, err := bfutil.TryReadUint32(bf)
+		if err != nil {
+			return req, err
+		}
+		if req.title, err = bfutil.TryReadBytes(bf, uint(titleLength)); err != nil {
+			return req, err
+		}
+		req.body, err = bfutil.TryReadBytes(bf, uint(bodyLength))
+	case 3: // Update stamp
+		req.stampID, err = bfutil.TryReadUint32(bf)
+	case 4: // Like message
+		req.like, err = bfutil.TryReadBool(bf)
+	}
+	return req, err
+}
+
 func handleMsgMhfUpdateGuildMessageBoard(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfUpdateGuildMessageBoard)
-	bf := byteframe.NewByteFrameFromBytes(pkt.Request)
+	req, err := parseGuildPostRequest(pkt.MessageOp, pkt.Request)
+	if err != nil {
+		s.logger.Warn("Dropped malformed guild message board request", zap.Uint32("op", pkt.MessageOp), zap.Error(err))
+		doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+		return
+	}
 	guild, _ := GetGuildInfoByCharacterId(s, s.charID)
 	if guild == nil {
 		doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
 		return
 	}
 	var titleConv, bodyConv string
+	postType, stampId, timestamp := req.postType, req.stampID, req.timestamp
 	switch pkt.MessageOp {
 	case 0: // Create message
-		postType := bf.ReadUint32() // 0 = message, 1 = news
-		stampId := bf.ReadUint32()
-		titleLength := bf.ReadUint32()
-		bodyLength := bf.ReadUint32()
-		title := bf.ReadBytes(uint(titleLength))
-		body := bf.ReadBytes(uint(bodyLength))
-		titleConv, _ = stringsupport.ConvertSJISBytesToString(title)
-		bodyConv, _ = stringsupport.ConvertSJISBytesToString(body)
+		titleConv = stringsupport.DecodeShiftJIS(req.title)
+		bodyConv = stringsupport.DecodeShiftJIS(req.body)
 		_, err := s.server.db.Exec("INSERT INTO guild_posts (guild_id, author_id, stamp_id, post_type, title, body) VALUES ($1, $2, $3, $4, $5, $6)", guild.ID, s.charID, int(stampId), int(postType), titleConv, bodyConv)
 		if err != nil {
 			s.logger.Fatal("Failed to add new guild message to db", zap.Error(err))
@@ -1788,37 +1926,24 @@ func handleMsgMhfUpdateGuildMessageBoard(s *Session, p mhfpacket.MHFPacket) {
 			s.logger.Fatal("Failed to remove excess guild messages from db", zap.Error(err))
 		}
 	case 1: // Delete message
-		postType := bf.ReadUint32()
-		timestamp := bf.ReadUint64()
 		_, err := s.server.db.Exec("DELETE FROM guild_posts WHERE post_type = $1 AND (EXTRACT(epoch FROM created_at)::int) = $2 AND guild_id = $3", int(postType), int(timestamp), guild.ID)
 		if err != nil {
 			s.logger.Fatal("Failed to delete guild message from db", zap.Error(err))
 		}
 	case 2: // Update message
-		postType := bf.ReadUint32()
-		timestamp := bf.ReadUint64()
-		titleLength := bf.ReadUint32()
-		bodyLength := bf.ReadUint32()
-		title := bf.ReadBytes(uint(titleLength))
-		body := bf.ReadBytes(uint(bodyLength))
-		titleConv, _ = stringsupport.ConvertSJISBytesToString(title)
-		bodyConv, _ = stringsupport.ConvertSJISBytesToString(body)
+		titleConv = stringsupport.DecodeShiftJIS(req.title)
+		bodyConv = stringsupport.DecodeShiftJIS(req.body)
 		_, err := s.server.db.Exec("UPDATE guild_posts SET title = $1, body = $2 WHERE post_type = $3 AND (EXTRACT(epoch FROM created_at)::int) = $4 AND guild_id = $5", titleConv, bodyConv, int(postType), int(timestamp), guild.ID)
 		if err != nil {
 			s.logger.Fatal("Failed to update guild message in db", zap.Error(err))
 		}
 	case 3: // Update stamp
-		postType := bf.ReadUint32()
-		timestamp := bf.ReadUint64()
-		stampId := bf.ReadUint32()
 		_, err := s.server.db.Exec("UPDATE guild_posts SET stamp_id = $1 WHERE post_type = $2 AND (EXTRACT(epoch FROM created_at)::int) = $3 AND guild_id = $4", int(stampId), int(postType), int(timestamp), guild.ID)
 		if err != nil {
 			s.logger.Fatal("Failed to update guild message stamp in db", zap.Error(err))
 		}
 	case 4: // Like message
-		postType := bf.ReadUint32()
-		timestamp := bf.ReadUint64()
-		likeState := bf.ReadBool()
+		likeState := req.like
 		var likedBy string
 		err := s.server.db.QueryRow("SELECT liked_by FROM guild_posts WHERE post_type = $1 AND (EXTRACT(epoch FROM created_at)::int) = $2 AND guild_id = $3", int(postType), int(timestamp), guild.ID).Scan(&likedBy)
 		if err != nil {
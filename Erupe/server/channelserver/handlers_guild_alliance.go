@@ -18,11 +18,12 @@ parent_id,
 CASE
 	WHEN sub1_id IS NULL THEN 0
 	ELSE sub1_id
-END,
+END sub1_id,
 CASE
 	WHEN sub2_id IS NULL THEN 0
 	ELSE sub2_id
-END
+END sub2_id,
+settings_version
 FROM guild_alliances ga
 `
 
@@ -31,6 +32,7 @@ type GuildAlliance struct {
 	Name          string    `db:"name"`
 	CreatedAt     time.Time `db:"created_at"`
 	TotalMembers  uint16
+	Version       uint32    `db:"settings_version"` // Of the name and sub guilds, moved on by every write of them, see Save.
 
 	ParentGuildID uint32    `db:"parent_id"`
 	SubGuild1ID   uint32    `db:"sub1_id"`
@@ -100,6 +102,22 @@ func buildAllianceObjectFromDbResult(result *sqlx.Rows, err error, s *Session) (
 	return alliance, nil
 }
 
+// removeSubGuild takes a sub guild out of the alliance, the second moving up if the first leaves.
+// It reports whether the guild was a sub guild of the alliance.
+func (alliance *GuildAlliance) removeSubGuild(guildID uint32) bool {
+	switch guildID {
+	case alliance.SubGuild1ID:
+		alliance.SubGuild1ID, alliance.SubGuild2ID = alliance.SubGuild2ID, 0
+		alliance.SubGuild1, alliance.SubGuild2 = alliance.SubGuild2, Guild{}
+	case alliance.SubGuild2ID:
+		alliance.SubGuild2ID = 0
+		alliance.SubGuild2 = Guild{}
+	default:
+		return false
+	}
+	return true
+}
+
 func handleMsgMhfCreateJoint(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfCreateJoint)
 	_, err := s.server.db.Exec("INSERT INTO guild_alliances (name, parent_id) VALUES ($1, $2)", pkt.Name, pkt.GuildID)
@@ -139,8 +157,16 @@ func handleMsgMhfOperateJoint(s *Session, p mhfpacket.MHFPacket) {
 		}
 	case mhfpacket.OPERATE_JOINT_LEAVE:
 		if guild.LeaderCharID == s.charID {
-			// delete alliance application
-			// or leave alliance
+			// The parent guild disbands the alliance instead.
+			if !alliance.removeSubGuild(guild.ID) {
+				doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+				return
+			}
+			if err := alliance.Save(s); err != nil {
+				failSettingsWrite(s, pkt.AckHandle, err)
+				return
+			}
+			doAckSimpleSucceed(s, pkt.AckHandle, make([]byte, 4))
 		} else {
 			s.logger.Warn(
 				"Non-owner of guild attempted alliance leave",
@@ -142,7 +142,6 @@ func handleMsgMhfAnswerGuildScout(s *Session, p mhfpacket.MHFPacket) {
 			"could not retrieve guild invitation",
 			zap.Error(err),
 			zap.Uint32("guildID", guild.ID),
-			zap.Uint32("charID", s.charID),
 		)
 		doAckBufFail(s, pkt.AckHandle, make([]byte, 4))
 		return
@@ -276,7 +275,6 @@ func handleMsgMhfGetRejectGuildScout(s *Session, p mhfpacket.MHFPacket) {
 		s.logger.Error(
 			"failed to retrieve character guild scout status",
 			zap.Error(err),
-			zap.Uint32("charID", s.charID),
 		)
 		doAckSimpleFail(s, pkt.AckHandle, nil)
 		return
@@ -300,7 +298,6 @@ func handleMsgMhfSetRejectGuildScout(s *Session, p mhfpacket.MHFPacket) {
 		s.logger.Error(
 			"failed to update character guild scout status",
 			zap.Error(err),
-			zap.Uint32("charID", s.charID),
 		)
 		doAckSimpleFail(s, pkt.AckHandle, nil)
 		return
@@ -1,11 +1,47 @@
 package channelserver
 
-import "github.com/Solenataris/Erupe/network/mhfpacket"
+import (
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"go.uber.org/zap"
+)
 
 func handleMsgMhfEnumerateGuildTresure(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfEnumerateGuildTresure)
 
-	doAckBufSucceed(s, pkt.AckHandle, make([]byte, 4))
+	week := s.server.currentTreasureWeek()
+	if week == 0 {
+		doAckBufSucceed(s, pkt.AckHandle, make([]byte, 4))
+		return
+	}
+	standings, err := treasureRanking(s.server.db, week, s.server.erupeConfig.TreasureHunt.RankingSize)
+	if err != nil {
+		s.logger.Error("Failed to get the treasure ranking", zap.Error(err))
+		doAckBufFail(s, pkt.AckHandle, make([]byte, 4))
+		return
+	}
+	var own treasureStanding
+	if guild, err := GetGuildInfoByCharacterId(s, s.charID); err == nil && guild != nil {
+		own, _, err = guildTreasureStanding(s.server.db, guild.ID, week)
+		if err != nil {
+			s.logger.Error("Failed to get the guild's treasure standing", zap.Error(err))
+		}
+	}
+
+	bf := byteframe.NewByteFrame()
+	bf.WriteUint16(uint16(len(standings)))
+	for _, standing := range standings {
+		name := encodeGuildListText(s, standing.Name)
+		bf.WriteUint16(standing.Rank)
+		bf.WriteUint32(standing.GuildID)
+		bf.WriteUint32(standing.BestScore)
+		bf.WriteUint8(uint8(len(name) + 1))
+		bf.WriteNullTerminatedBytes(name)
+	}
+	// The character's guild, rank 0 if it hasn't scored this week.
+	bf.WriteUint16(own.Rank)
+	bf.WriteUint32(own.BestScore)
+	doAckBufSucceed(s, pkt.AckHandle, bf.Data())
 }
 
 func handleMsgMhfRegistGuildTresure(s *Session, p mhfpacket.MHFPacket) {}
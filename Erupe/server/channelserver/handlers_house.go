@@ -128,17 +128,12 @@ func handleMsgMhfSaveDecoMyset(s *Session, p mhfpacket.MHFPacket) {
 
 func handleMsgMhfEnumerateTitle(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfEnumerateTitle)
-	bf := byteframe.NewByteFrame()
-	titleCount := 114 // all titles unlocked
-	bf.WriteUint16(uint16(titleCount)) // title count
-	bf.WriteUint16(0) // unk
-	for i := 0; i < titleCount; i++ {
-		bf.WriteUint16(uint16(i))
-		bf.WriteUint16(0) // unk
-		bf.WriteUint32(0) // timestamp acquired
-		bf.WriteUint32(0) // timestamp updated
+	// All default titles are unlocked, the ones the server granted are added with their time.
+	granted, err := loadTitles(s.server.db, s.charID)
+	if err != nil {
+		s.logger.Error("Failed to load granted titles", zap.Error(err))
 	}
-	doAckBufSucceed(s, pkt.AckHandle, bf.Data())
+	doAckBufSucceed(s, pkt.AckHandle, buildTitleList(granted))
 }
 
 func handleMsgMhfOperateWarehouse(s *Session, p mhfpacket.MHFPacket) {}
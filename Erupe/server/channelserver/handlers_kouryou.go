@@ -9,10 +9,10 @@ import (
 func handleMsgMhfAddKouryouPoint(s *Session, p mhfpacket.MHFPacket) {
 	// hunting with both ranks maxed gets you these
 	pkt := p.(*mhfpacket.MsgMhfAddKouryouPoint)
-	var points int
-	err := s.server.db.QueryRow("UPDATE characters SET kouryou_point=COALESCE(kouryou_point + $1, $1) WHERE id=$2 RETURNING kouryou_point", pkt.KouryouPoints, s.charID).Scan(&points)
-	if err != nil {
-		s.logger.Fatal("Failed to update KouryouPoint in db", zap.Error(err))
+	points, ok := addPoints(s, CurrencyKouryou, int64(pkt.KouryouPoints), "kouryou hunt")
+	if !ok {
+		doAckBufFail(s, pkt.AckHandle, make([]byte, 4))
+		return
 	}
 	resp := byteframe.NewByteFrame()
 	resp.WriteUint32(uint32(points))
@@ -33,11 +33,11 @@ func handleMsgMhfGetKouryouPoint(s *Session, p mhfpacket.MHFPacket) {
 
 func handleMsgMhfExchangeKouryouPoint(s *Session, p mhfpacket.MHFPacket) {
 	// spent at the guildmaster, 10000 a roll
-	var points int
 	pkt := p.(*mhfpacket.MsgMhfExchangeKouryouPoint)
-	err := s.server.db.QueryRow("UPDATE characters SET kouryou_point=kouryou_point - $1 WHERE id=$2 RETURNING kouryou_point", pkt.KouryouPoints, s.charID).Scan(&points)
-	if err != nil {
-		s.logger.Fatal("Failed to update platemyset savedata in db", zap.Error(err))
+	points, ok := addPoints(s, CurrencyKouryou, -int64(pkt.KouryouPoints), "kouryou exchange")
+	if !ok {
+		doAckBufFail(s, pkt.AckHandle, make([]byte, 4))
+		return
 	}
 	resp := byteframe.NewByteFrame()
 	resp.WriteUint32(uint32(points))
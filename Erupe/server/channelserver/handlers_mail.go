@@ -4,12 +4,20 @@ import (
 	"database/sql"
 	"time"
 
+	"github.com/Solenataris/Erupe/common/bfutil"
+	"github.com/Solenataris/Erupe/common/stringsupport"
 	"github.com/Solenataris/Erupe/network/binpacket"
 	"github.com/Solenataris/Erupe/network/mhfpacket"
 	"github.com/Andoryuuta/byteframe"
 	"go.uber.org/zap"
 )
 
+// Most bytes of a mail body sent to the client, as many as a mail sent by one may have.
+const mailBodyMaxLength = 0xFFFF
+
+// Sender name shown for mail the server sends, which has no sending character.
+const systemMailSender = "System"
+
 type Mail struct {
 	ID                   int       `db:"id"`
 	SenderID             uint32    `db:"sender_id"`
@@ -131,7 +139,7 @@ func GetMailListForCharacter(s *Session, charID uint32) ([]Mail, error) {
 	rows, err := s.server.db.Queryx(`
 		SELECT
 			m.id,
-			m.sender_id,
+			COALESCE(m.sender_id, 0) AS sender_id,
 			m.recipient_id,
 			m.subject,
 			m.read,
@@ -142,13 +150,13 @@ func GetMailListForCharacter(s *Session, charID uint32) ([]Mail, error) {
 			m.is_guild_invite,
 			m.deleted,
 			m.locked,
-			c.name as sender_name
+			COALESCE(c.name, $2) as sender_name
 		FROM mail m
-			JOIN characters c ON c.id = m.sender_id
+			LEFT JOIN characters c ON c.id = m.sender_id
 		WHERE recipient_id = $1 AND deleted = false
 		ORDER BY m.created_at DESC, id DESC
 		LIMIT 32
-	`, charID)
+	`, charID, systemMailSender)
 
 	if err != nil {
 		s.logger.Error("failed to get mail for character", zap.Error(err), zap.Uint32("charID", charID))
@@ -178,7 +186,7 @@ func GetMailByID(s *Session, ID int) (*Mail, error) {
 	row := s.server.db.QueryRowx(`
 		SELECT
 			m.id,
-			m.sender_id,
+			COALESCE(m.sender_id, 0) AS sender_id,
 			m.recipient_id,
 			m.subject,
 			m.read,
@@ -190,12 +198,12 @@ func GetMailByID(s *Session, ID int) (*Mail, error) {
 			m.is_guild_invite,
 			m.deleted,
 			m.locked,
-			c.name as sender_name
+			COALESCE(c.name, $2) as sender_name
 		FROM mail m
-			JOIN characters c ON c.id = m.sender_id
+			LEFT JOIN characters c ON c.id = m.sender_id
 		WHERE m.id = $1
 		LIMIT 1
-	`, ID)
+	`, ID, systemMailSender)
 
 	mail := &Mail{}
 
@@ -213,6 +221,12 @@ func GetMailByID(s *Session, ID int) (*Mail, error) {
 	return mail, nil
 }
 
+// SendSystemMail mails the character from the server, with no sending character.
+func SendSystemMail(tx execer, charID uint32, subject string, body string) error {
+	_, err := tx.Exec("INSERT INTO mail (sender_id, recipient_id, subject, body) VALUES (NULL, $1, $2, $3)", charID, subject, body)
+	return err
+}
+
 func SendMailNotification(s *Session, m *Mail, recipient *Session) {
 	senderName, err := getCharacterName(s, m.SenderID)
 
@@ -275,8 +289,7 @@ func handleMsgMhfReadMail(s *Session, p mhfpacket.MHFPacket) {
 
 	bf := byteframe.NewByteFrame()
 
-	body := s.clientContext.StrConv.MustEncode(mail.Body)
-	bf.WriteNullTerminatedBytes(body)
+	bfutil.WriteShiftJISString(bf, mail.Body, mailBodyMaxLength)
 
 	doAckBufSucceed(s, pkt.AckHandle, bf.Data())
 }
@@ -308,8 +321,8 @@ func handleMsgMhfListMail(s *Session, p mhfpacket.MHFPacket) {
 
 
 		itemAttached := m.AttachedItemID != 0
-		subject := s.clientContext.StrConv.MustEncode(m.Subject)
-		sender := s.clientContext.StrConv.MustEncode(m.SenderName)
+		subject := stringsupport.EncodeShiftJIS(m.Subject, nameMaxLength)
+		sender := stringsupport.EncodeShiftJIS(m.SenderName, nameMaxLength)
 
 		msg.WriteUint32(m.SenderID)
 		msg.WriteUint32(uint32(m.CreatedAt.Unix()))
@@ -399,6 +412,11 @@ func handleMsgMhfOprtMail(s *Session, p mhfpacket.MHFPacket) {
 
 func handleMsgMhfSendMail(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfSendMail)
+	if pkt.ItemID != 0 && s.isRentalItem(pkt.ItemID) {
+		// Lent equipment can't be given away.
+		doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+		return
+	}
 	query := `
 		INSERT INTO mail (sender_id, recipient_id, subject, body, attached_item, attached_item_amount, is_guild_invite)
 		VALUES ($1, $2, $3, $4, $5, $6, $7)
@@ -1,7 +1,6 @@
 package channelserver
 
 import (
-	"fmt"
 	"math/rand"
 	"os"
 	"io"
@@ -158,10 +157,13 @@ func handleMsgMhfSaveMercenary(s *Session, p mhfpacket.MHFPacket) {
 		}
 	}
 	// gcp value is always present regardless
+	var previousGCP int
+	s.server.db.QueryRow("SELECT COALESCE(gcp, 0) FROM characters WHERE id=$1", s.charID).Scan(&previousGCP)
 	_, err := s.server.db.Exec("UPDATE characters SET gcp=$1 WHERE id=$2", GCPValue, s.charID)
 	if err != nil {
 		s.logger.Fatal("Failed to update savemercenary and gcp in db", zap.Error(err))
 	}
+	recordGCPEarned(s, int(GCPValue)-previousGCP)
 	doAckSimpleSucceed(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
 }
 
@@ -187,7 +189,6 @@ func handleMsgMhfReadMercenaryW(s *Session, p mhfpacket.MHFPacket) {
 	resp.WriteBytes(data)
 	resp.WriteUint16(0)
 	resp.WriteUint32(gcp)
-	fmt.Printf("% x", resp.Data())
 	doAckBufSucceed(s, pkt.AckHandle, resp.Data())
 }
 
@@ -1,32 +1,16 @@
 package channelserver
 
 import (
-	"fmt"
-
 	"github.com/Andoryuuta/byteframe"
 	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"go.uber.org/zap"
 )
 
 func handleMsgSysCreateObject(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgSysCreateObject)
 
-	// Lock the stage.
-	s.server.Lock()
-
 	// Make a new stage object and insert it into the stage.
-	objID := s.stage.GetNewObjectID(s.charID)
-	newObj := &StageObject{
-		id:          objID,
-		ownerCharID: s.charID,
-		x:           pkt.X,
-		y:           pkt.Y,
-		z:           pkt.Z,
-	}
-
-	s.stage.objects[s.charID] = newObj
-
-	// Unlock the stage.
-	s.server.Unlock()
+	objID := s.stage.createObject(s.charID, pkt.X, pkt.Y, pkt.Z)
 	// Response to our requesting client.
 	resp := byteframe.NewByteFrame()
 	resp.WriteUint32(objID) // New local obj handle.
@@ -48,16 +32,9 @@ func handleMsgSysDeleteObject(s *Session, p mhfpacket.MHFPacket) {}
 func handleMsgSysPositionObject(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgSysPositionObject)
 	if s.server.erupeConfig.DevMode && s.server.erupeConfig.DevModeOptions.OpcodeMessages {
-		fmt.Printf("[%s] with objectID [%d] move to (%f,%f,%f)\n\n", s.Name, pkt.ObjID, pkt.X, pkt.Y, pkt.Z)
+		s.packetLogger.Debug("Object moved", zap.Uint32("objectID", pkt.ObjID), zap.Float32("x", pkt.X), zap.Float32("y", pkt.Y), zap.Float32("z", pkt.Z))
 	}
-	s.stage.Lock()
-	object, ok := s.stage.objects[s.charID]
-	if ok {
-		object.x = pkt.X
-		object.y = pkt.Y
-		object.z = pkt.Z
-	}
-	s.stage.Unlock()
+	s.stage.moveObject(pkt.ObjID, s.charID, pkt.X, pkt.Y, pkt.Z)
 	// One of the few packets we can just re-broadcast directly.
 	s.stage.BroadcastMHF(pkt, s)
 }
@@ -66,7 +43,20 @@ func handleMsgSysRotateObject(s *Session, p mhfpacket.MHFPacket) {}
 
 func handleMsgSysDuplicateObject(s *Session, p mhfpacket.MHFPacket) {}
 
-func handleMsgSysSetObjectBinary(s *Session, p mhfpacket.MHFPacket) {}
+func handleMsgSysSetObjectBinary(s *Session, p mhfpacket.MHFPacket) {
+	pkt := p.(*mhfpacket.MsgSysSetObjectBinary)
+	if s.stage == nil {
+		return
+	}
+
+	// Interacting with an admin spawned event object claims its reward.
+	s.stage.RLock()
+	eventObj, isEventObj := s.stage.eventObjects[pkt.ObjID]
+	s.stage.RUnlock()
+	if isEventObj {
+		claimEventObject(s, eventObj)
+	}
+}
 
 func handleMsgSysGetObjectBinary(s *Session, p mhfpacket.MHFPacket) {}
 
@@ -8,6 +8,7 @@ import (
 
 	"github.com/Solenataris/Erupe/network/mhfpacket"
 	"github.com/Andoryuuta/byteframe"
+	"go.uber.org/zap"
 )
 
 func handleMsgSysGetFile(s *Session, p mhfpacket.MHFPacket) {
@@ -15,7 +16,7 @@ func handleMsgSysGetFile(s *Session, p mhfpacket.MHFPacket) {
 
 	// Debug print the request.
 	if pkt.IsScenario {
-		fmt.Printf("%+v\n", pkt.ScenarioIdentifer)
+		s.logger.Debug("Scenario requested", zap.Any("scenario", pkt.ScenarioIdentifer))
 		filename := fmt.Sprintf("%d_0_0_0_S%d_T%d_C%d", pkt.ScenarioIdentifer.CategoryID, pkt.ScenarioIdentifer.MainID, pkt.ScenarioIdentifer.Flags, pkt.ScenarioIdentifer.ChapterID)
 		// Read the scenario file.
 		data, err := ioutil.ReadFile(filepath.Join(s.server.erupeConfig.BinPath, fmt.Sprintf("scenarios/%s.bin", filename)))
@@ -31,12 +32,21 @@ func handleMsgSysGetFile(s *Session, p mhfpacket.MHFPacket) {
 			}
 			doAckBufSucceed(s, pkt.AckHandle, data)
 		} else {
+			if gate, ok := questGate(s.server.closedContentGates(), pkt.Filename); ok {
+				s.logger.Info("Refused quest held back by a content gate", zap.String("quest", pkt.Filename), zap.String("gate", gate))
+				doAckBufFail(s, pkt.AckHandle, make([]byte, 4))
+				return
+			}
 			// Get quest file.
 			data, err := ioutil.ReadFile(filepath.Join(s.server.erupeConfig.BinPath, fmt.Sprintf("quests/%s.bin", pkt.Filename)))
 			if err != nil {
 				panic(err)
 			}
-			doAckBufSucceed(s, pkt.AckHandle, data)
+			s.Lock()
+			s.questFile = pkt.Filename
+			s.Unlock()
+			noteQuestInstance(s, pkt.Filename)
+			doAckBufSucceed(s, pkt.AckHandle, scaledQuestFile(s, pkt.Filename, data))
 		}
 	}
 }
@@ -62,9 +72,14 @@ func handleMsgMhfSaveFavoriteQuest(s *Session, p mhfpacket.MHFPacket) {
 func handleMsgMhfEnumerateQuest(s *Session, p mhfpacket.MHFPacket) {
 	// local files are easier for now, probably best would be to generate dynamically
 	pkt := p.(*mhfpacket.MsgMhfEnumerateQuest)
+	if questListGated(s.server.closedContentGates(), pkt.QuestList) {
+		stubEnumerateNoResults(s, pkt.AckHandle)
+		updateRights(s)
+		return
+	}
 	data, err := ioutil.ReadFile(filepath.Join(s.server.erupeConfig.BinPath, fmt.Sprintf("questlists/list_%d.bin", pkt.QuestList)))
 	if err != nil {
-		fmt.Printf("questlists/list_%d.bin", pkt.QuestList)
+		s.logger.Warn("Missing quest list", zap.Uint16("list", pkt.QuestList), zap.Error(err))
 		stubEnumerateNoResults(s, pkt.AckHandle)
 	} else {
 		doAckBufSucceed(s, pkt.AckHandle, data)
@@ -10,6 +10,7 @@ func handleMsgSysOperateRegister(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgSysOperateRegister)
 	bf := byteframe.NewByteFrameFromBytes(pkt.RawDataPayload)
 	s.server.raviente.Lock()
+	s.server.loadRavienteCycleLocked()
 	switch pkt.RegisterID {
 	case 786461:
 		resp := byteframe.NewByteFrame()
@@ -159,6 +160,7 @@ func handleMsgSysOperateRegister(s *Session, p mhfpacket.MHFPacket) {
 				} else {
 					resp.WriteUint32(*ref + data * damageMultiplier)
 					*ref += data * damageMultiplier
+					s.server.raviente.contributions[s.charID] += data * damageMultiplier
 				}
 			case 13:
 				fallthrough
@@ -203,6 +205,9 @@ func handleMsgSysOperateRegister(s *Session, p mhfpacket.MHFPacket) {
 
 func handleMsgSysLoadRegister(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgSysLoadRegister)
+	s.server.raviente.Lock()
+	defer s.server.raviente.Unlock()
+	s.server.loadRavienteCycleLocked()
 	r := pkt.Unk1
 	switch r {
 		case 12:
@@ -256,79 +261,66 @@ func (s *Session) notifyplayer() {
 }
 
 func (s *Session) notifyall() {
-	if _, exists := s.server.semaphore["hs_l0u3B51J9k3"]; exists {
-		for session := range s.server.semaphore["hs_l0u3B51J9k3"].clients {
-			session.QueueSendNonBlocking([]byte{0x00, 0x3F, 0x00, 0x0C, 0x00, 0x1D})
-			session.QueueSendNonBlocking([]byte{0x00, 0x3F, 0x00, 0x0D, 0x00, 0x1D})
-			session.QueueSendNonBlocking([]byte{0x00, 0x3F, 0x00, 0x0E, 0x00, 0x1D})
-		}
-	} else if _, exists := s.server.semaphore["hs_l0u3B5129k3"]; exists {
-		for session := range s.server.semaphore["hs_l0u3B5129k3"].clients {
-			session.QueueSendNonBlocking([]byte{0x00, 0x3F, 0x00, 0x0C, 0x00, 0x1D})
-			session.QueueSendNonBlocking([]byte{0x00, 0x3F, 0x00, 0x0D, 0x00, 0x1D})
-			session.QueueSendNonBlocking([]byte{0x00, 0x3F, 0x00, 0x0E, 0x00, 0x1D})
-		}
-	} else if _, exists := s.server.semaphore["hs_l0u3B512Ak3"]; exists {
-		for session := range s.server.semaphore["hs_l0u3B512Ak3"].clients {
-			session.QueueSendNonBlocking([]byte{0x00, 0x3F, 0x00, 0x0C, 0x00, 0x1D})
-			session.QueueSendNonBlocking([]byte{0x00, 0x3F, 0x00, 0x0D, 0x00, 0x1D})
-			session.QueueSendNonBlocking([]byte{0x00, 0x3F, 0x00, 0x0E, 0x00, 0x1D})
-		}
+	semaphore := s.server.raviSemaphore()
+	if semaphore == nil {
+		return
+	}
+	semaphore.RLock()
+	defer semaphore.RUnlock()
+	for session := range semaphore.clients {
+		session.QueueSendNonBlocking([]byte{0x00, 0x3F, 0x00, 0x0C, 0x00, 0x1D})
+		session.QueueSendNonBlocking([]byte{0x00, 0x3F, 0x00, 0x0D, 0x00, 0x1D})
+		session.QueueSendNonBlocking([]byte{0x00, 0x3F, 0x00, 0x0E, 0x00, 0x1D})
 	}
 }
 
 func checkRaviSemaphore(s *Session) bool {
-	if _, exists := s.server.semaphore["hs_l0u3B51J9k3"]; exists {
-		return true
-	} else if _, exists := s.server.semaphore["hs_l0u3B5129k3"]; exists {
-		return true
-	} else if _, exists := s.server.semaphore["hs_l0u3B512Ak3"]; exists {
-		return true
-	}
-	return false
+	return s.server.raviSemaphore() != nil
 }
 
+// releaseRaviSemaphore resets the Raviente once the main semaphore of an instance has no holders left.
+// The semaphore locks are released before the Raviente lock is taken, which comes first in the lock order.
 func releaseRaviSemaphore(s *Session) {
-	s.server.raviente.Lock()
-	if _, exists := s.server.semaphore["hs_l0u3B51J9k3"]; exists {
-		if len(s.server.semaphore["hs_l0u3B51J9k3"].reservedClientSlots) == 0 {
-			resetRavi(s)
-		}
-	}
-	if _, exists := s.server.semaphore["hs_l0u3B5129k3"]; exists {
-		if len(s.server.semaphore["hs_l0u3B5129k3"].reservedClientSlots) == 0 {
-			resetRavi(s)
+	empty := false
+	for _, prefix := range raviSemaphorePrefixes {
+		if semaphore, exists := s.server.getSemaphore(prefix + "3"); exists {
+			semaphore.RLock()
+			if len(semaphore.reservedClientSlots) == 0 {
+				empty = true
+			}
+			semaphore.RUnlock()
 		}
 	}
-	if _, exists := s.server.semaphore["hs_l0u3B512Ak3"]; exists {
-		if len(s.server.semaphore["hs_l0u3B512Ak3"].reservedClientSlots) == 0 {
-			resetRavi(s)
-		}
+	if empty {
+		s.server.raviente.Lock()
+		endRavienteWindow(s.server)
+		s.server.raviente.Unlock()
 	}
-	s.server.raviente.Unlock()
 }
 
-func resetRavi(s *Session) {
-	s.server.raviente.register.nextTime = 0
-	s.server.raviente.register.startTime = 0
-	s.server.raviente.register.killedTime = 0
-	s.server.raviente.register.postTime = 0
-	s.server.raviente.register.ravienteType = 0
-	s.server.raviente.register.maxPlayers = 0
-	s.server.raviente.register.carveQuest = 0
-	s.server.raviente.state.damageMultiplier = 1
-	s.server.raviente.register.register = []uint32{0, 0, 0, 0, 0}
-	s.server.raviente.state.stateData = []uint32{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
-	s.server.raviente.support.supportData = []uint32{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+func resetRavi(s *Server) {
+	s.raviente.register.nextTime = 0
+	s.raviente.register.startTime = 0
+	s.raviente.register.killedTime = 0
+	s.raviente.register.postTime = 0
+	s.raviente.register.ravienteType = 0
+	s.raviente.register.maxPlayers = 0
+	s.raviente.register.carveQuest = 0
+	s.raviente.state.damageMultiplier = 1
+	s.raviente.register.register = []uint32{0, 0, 0, 0, 0}
+	s.raviente.state.stateData = []uint32{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	s.raviente.support.supportData = []uint32{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	s.raviente.cycle = 0
+	s.raviente.contributions = make(map[uint32]uint32)
 }
 
 // Unused
 func (s *Session) notifyticker() {
-	if _, exists := s.server.semaphore["hs_l0u3B51J9k3"]; exists {
-		s.server.semaphoreLock.Lock()
-		getSemaphore := s.server.semaphore["hs_l0u3B51J9k3"]
-		s.server.semaphoreLock.Unlock()
-		if _, exists := getSemaphore.reservedClientSlots[s.charID]; exists {
+	if semaphore, exists := s.server.getSemaphore("hs_l0u3B51J9k3"); exists {
+		semaphore.RLock()
+		_, reserved := semaphore.reservedClientSlots[s.charID]
+		semaphore.RUnlock()
+		if reserved {
 			s.notifyall()
 		}
 	}
@@ -1,21 +1,46 @@
 package channelserver
 
 import (
-	"fmt"
-	"strings"
+	"time"
 
 	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"go.uber.org/zap"
 )
 
+// removeSessionFromSemaphore releases every semaphore the session holds, so a disconnect
+// mid-quest doesn't leave the content in use. Semaphores left without holders are removed.
 func removeSessionFromSemaphore(s *Session) {
-	s.server.semaphoreLock.Lock()
-	for _, semaphore := range s.server.semaphore {
-		if _, exists := semaphore.clients[s]; exists {
-			delete(semaphore.clients, s)
+	s.Lock()
+	held := s.semaphores
+	s.semaphores = make(map[string]*Semaphore)
+	s.Unlock()
+
+	for _, semaphore := range s.server.semaphoreList() {
+		semaphore.Lock()
+		delete(semaphore.clients, s)
+		semaphore.Unlock()
+	}
+
+	var released []string
+	for id, semaphore := range held {
+		if semaphore.release(s) {
+			released = append(released, id)
 		}
 	}
+	// The Raviente is reset while its emptied semaphore is still registered.
 	releaseRaviSemaphore(s)
-	s.server.semaphoreLock.Unlock()
+	for _, id := range released {
+		s.server.removeSemaphoreIfEmpty(id, held[id])
+	}
+}
+
+// holdSemaphore records that the session holds a slot in the semaphore. The caller must hold the semaphore lock.
+func (s *Session) holdSemaphore(semaphore *Semaphore) {
+	semaphore.unownedSince = time.Time{}
+	s.Lock()
+	s.semaphore = semaphore
+	s.semaphores[semaphore.id_semaphore] = semaphore
+	s.Unlock()
 }
 
 func handleMsgSysCreateSemaphore(s *Session, p mhfpacket.MHFPacket) {
@@ -25,55 +50,29 @@ func handleMsgSysCreateSemaphore(s *Session, p mhfpacket.MHFPacket) {
 
 func handleMsgSysDeleteSemaphore(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgSysDeleteSemaphore)
-	sem := pkt.AckHandle
-	if s.server.semaphore != nil {
-		s.server.semaphoreLock.Lock()
-		for id := range s.server.semaphore {
-			switch sem {
-			case 917533:
-				if s.server.semaphore[id].id_semaphore == "hs_l0u3B51J9k3" {
-					delete(s.server.semaphore["hs_l0u3B51J9k3"].reservedClientSlots, s.charID)
-					delete(s.server.semaphore["hs_l0u3B51J9k3"].clients, s)
-				} else if s.server.semaphore[id].id_semaphore == "hs_l0u3B5129k3" {
-					delete(s.server.semaphore["hs_l0u3B5129k3"].reservedClientSlots, s.charID)
-					delete(s.server.semaphore["hs_l0u3B5129k3"].clients, s)
-				} else if s.server.semaphore[id].id_semaphore == "hs_l0u3B512Ak3" {
-					delete(s.server.semaphore["hs_l0u3B512Ak3"].reservedClientSlots, s.charID)
-					delete(s.server.semaphore["hs_l0u3B512Ak3"].clients, s)
-				}
-			case 851997:
-				if s.server.semaphore[id].id_semaphore == "hs_l0u3B51J9k4" {
-					delete(s.server.semaphore["hs_l0u3B51J9k4"].reservedClientSlots, s.charID)
-				} else if s.server.semaphore[id].id_semaphore == "hs_l0u3B5129k4" {
-					delete(s.server.semaphore["hs_l0u3B5129k4"].reservedClientSlots, s.charID)
-				} else if s.server.semaphore[id].id_semaphore == "hs_l0u3B512Ak4" {
-					delete(s.server.semaphore["hs_l0u3B512Ak4"].reservedClientSlots, s.charID)
-				}
-			case 786461:
-				if s.server.semaphore[id].id_semaphore == "hs_l0u3B51J9k5" {
-					delete(s.server.semaphore["hs_l0u3B51J9k5"].reservedClientSlots, s.charID)
-				} else if s.server.semaphore[id].id_semaphore == "hs_l0u3B5129k5" {
-					delete(s.server.semaphore["hs_l0u3B5129k5"].reservedClientSlots, s.charID)
-				} else if s.server.semaphore[id].id_semaphore == "hs_l0u3B512Ak5" {
-					delete(s.server.semaphore["hs_l0u3B512Ak5"].reservedClientSlots, s.charID)
-				}
-			default:
-				if len(s.server.semaphore[id].reservedClientSlots) != 0 {
-					if s.server.semaphore[id].id_semaphore != "hs_l0u3B51J9k3" &&
-					s.server.semaphore[id].id_semaphore != "hs_l0u3B51J9k4" &&
-					s.server.semaphore[id].id_semaphore != "hs_l0u3B51J9k5" &&
-					s.server.semaphore[id].id_semaphore != "hs_l0u3B5129k3" &&
-					s.server.semaphore[id].id_semaphore != "hs_l0u3B5129k4" &&
-					s.server.semaphore[id].id_semaphore != "hs_l0u3B5129k5" &&
-					s.server.semaphore[id].id_semaphore != "hs_l0u3B512Ak3" &&
-					s.server.semaphore[id].id_semaphore != "hs_l0u3B512Ak4" &&
-					s.server.semaphore[id].id_semaphore != "hs_l0u3B512Ak5" {
-						delete(s.server.semaphore[id].reservedClientSlots, s.charID)
-					}
-				}
+	for _, semaphore := range s.server.semaphoreList() {
+		semaphore.Lock()
+		part := raviSemaphorePart(semaphore.id_semaphore)
+		switch pkt.AckHandle {
+		case 917533:
+			if part == '3' {
+				delete(semaphore.reservedClientSlots, s.charID)
+				delete(semaphore.clients, s)
+			}
+		case 851997:
+			if part == '4' {
+				delete(semaphore.reservedClientSlots, s.charID)
+			}
+		case 786461:
+			if part == '5' {
+				delete(semaphore.reservedClientSlots, s.charID)
+			}
+		default:
+			if part == 0 {
+				delete(semaphore.reservedClientSlots, s.charID)
 			}
 		}
-		s.server.semaphoreLock.Unlock()
+		semaphore.Unlock()
 	}
 }
 
@@ -81,21 +80,15 @@ func handleMsgSysCreateAcquireSemaphore(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgSysCreateAcquireSemaphore)
 	SemaphoreID := pkt.SemaphoreID
 
-	newSemaphore, gotNewStage := s.server.semaphore[SemaphoreID]
-
-	fmt.Printf("Got reserve stage req, StageID: %v\n\n", SemaphoreID)
-	if !gotNewStage {
-		s.server.semaphoreLock.Lock()
-		if strings.HasPrefix(SemaphoreID, "hs_l0u3B51") {
-			s.server.semaphore[SemaphoreID] = NewSemaphore(SemaphoreID, 32)
-		} else {
-			s.server.semaphore[SemaphoreID] = NewSemaphore(SemaphoreID, 1)
-		}
-		newSemaphore = s.server.semaphore[SemaphoreID]
-		s.server.semaphoreLock.Unlock()
-	}
-
+	s.logger.Debug("Got acquire semaphore req", zap.String("semaphoreID", SemaphoreID))
+	newSemaphore := s.server.getOrCreateSemaphore(SemaphoreID)
 	newSemaphore.Lock()
+	// Removed after it was looked up, so there's a new one to acquire instead.
+	for newSemaphore.removed {
+		newSemaphore.Unlock()
+		newSemaphore = s.server.getOrCreateSemaphore(SemaphoreID)
+		newSemaphore.Lock()
+	}
 	defer newSemaphore.Unlock()
 	if _, exists := newSemaphore.reservedClientSlots[s.charID]; exists {
 		doAckSimpleSucceed(s, pkt.AckHandle, []byte{0x00, 0x0F, 0x00, 0x1D})
@@ -104,27 +97,23 @@ func handleMsgSysCreateAcquireSemaphore(s *Session, p mhfpacket.MHFPacket) {
 		case "hs_l0u3B51J9k3", "hs_l0u3B5129k3", "hs_l0u3B512Ak3":
 			newSemaphore.reservedClientSlots[s.charID] = nil
 			newSemaphore.clients[s] = s.charID
-			s.Lock()
-			s.semaphore = newSemaphore
-			s.Unlock()
+			newSemaphore.handle = 0x000E001D
+			s.holdSemaphore(newSemaphore)
 			doAckSimpleSucceed(s, pkt.AckHandle, []byte{0x00, 0x0E, 0x00, 0x1D})
 		case "hs_l0u3B51J9k4", "hs_l0u3B5129k4", "hs_l0u3B512Ak4":
 			newSemaphore.reservedClientSlots[s.charID] = nil
-			s.Lock()
-			s.semaphore = newSemaphore
-			s.Unlock()
+			newSemaphore.handle = 0x000D001D
+			s.holdSemaphore(newSemaphore)
 			doAckSimpleSucceed(s, pkt.AckHandle, []byte{0x00, 0x0D, 0x00, 0x1D})
 		case "hs_l0u3B51J9k5", "hs_l0u3B5129k5", "hs_l0u3B512Ak5":
 			newSemaphore.reservedClientSlots[s.charID] = nil
-			s.Lock()
-			s.semaphore = newSemaphore
-			s.Unlock()
+			newSemaphore.handle = 0x000C001D
+			s.holdSemaphore(newSemaphore)
 			doAckSimpleSucceed(s, pkt.AckHandle, []byte{0x00, 0x0C, 0x00, 0x1D})
 		default:
 			newSemaphore.reservedClientSlots[s.charID] = nil
-			s.Lock()
-			s.semaphore = newSemaphore
-			s.Unlock()
+			newSemaphore.handle = 0x000F0025
+			s.holdSemaphore(newSemaphore)
 			doAckSimpleSucceed(s, pkt.AckHandle, []byte{0x00, 0x0F, 0x00, 0x25})
 		}
 	} else {
@@ -144,10 +133,8 @@ func handleMsgSysReleaseSemaphore(s *Session, p mhfpacket.MHFPacket) {
 func handleMsgSysCheckSemaphore(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgSysCheckSemaphore)
 	resp := []byte{0x00, 0x00, 0x00, 0x00}
-	s.server.semaphoreLock.Lock()
-	if _, exists := s.server.semaphore[pkt.StageID]; exists {
+	if _, exists := s.server.getSemaphore(pkt.StageID); exists {
 		resp = []byte{0x00, 0x00, 0x00, 0x01}
 	}
-	s.server.semaphoreLock.Unlock()
 	doAckSimpleSucceed(s, pkt.AckHandle, resp)
 }
@@ -0,0 +1,265 @@
+package channelserver
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/common/fixtures"
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+)
+
+// The snapshot tests compare the responses to fixtures they wrote themselves, so they only catch a layout
+// changing. The captured tests compare them to what a real server sent, from the MSG_SYS_ACK frames in these
+// directories, one fixture each from the ack's opcode on, cut from the responses erupe fixtures import writes.
+const (
+	capturedEnterStageAckDir     = "channelserver/enter_stage_ack"     // Acks of entering an empty town.
+	capturedEnumerateQuestAckDir = "channelserver/enumerate_quest_ack" // Acks of the first quest board page.
+)
+
+// Fixed times, so the responses carrying them don't change between runs.
+var (
+	snapshotCreatedAt = time.Date(2022, 3, 14, 12, 0, 0, 0, time.UTC)
+	snapshotJoinedAt  = time.Date(2022, 4, 1, 20, 30, 0, 0, time.UTC)
+)
+
+// newQueuedSession is a logged in session with no send loop, its packets stay queued for sentPackets.
+func newQueuedSession(t *testing.T, s *Server, charID uint32, name string) *Session {
+	serverConn, clientConn := net.Pipe()
+	t.Cleanup(func() { clientConn.Close() })
+	session := NewSession(s, serverConn)
+	session.charID = charID
+	session.Name = name
+	s.Lock()
+	s.sessions[serverConn] = session
+	s.Unlock()
+	return session
+}
+
+// sentPackets returns what the session queued so far as its send loop would write it, each packet
+// followed by the MSG_SYS_END opcode.
+func sentPackets(session *Session) []byte {
+	var data []byte
+	for {
+		select {
+		case packet := <-session.sendPackets:
+			data = append(data, packet...)
+			data = append(data, 0x00, 0x10)
+		default:
+			return data
+		}
+	}
+}
+
+func TestEnterStageSnapshot(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, s *Server, stage *Stage)
+	}{
+		{"empty_town", func(t *testing.T, s *Server, stage *Stage) {}},
+		{"town_with_players", func(t *testing.T, s *Server, stage *Stage) {
+			for _, charID := range []uint32{300, 200} {
+				other := newQueuedSession(t, s, charID, "Other")
+				stage.Lock()
+				stage.addClient(other)
+				stage.Unlock()
+				other.stage = stage
+				stage.createObject(charID, float32(charID), 10, -5)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		s := newTestServer()
+		stage, _ := s.GetOrCreateStage("sl1Ns200p0a0u0")
+		tt.setup(t, s, stage)
+		session := newQueuedSession(t, s, 100, "Hunter")
+
+		handleMsgSysEnterStage(session, &mhfpacket.MsgSysEnterStage{AckHandle: 0x1234, StageID: "sl1Ns200p0a0u0"})
+		fixtures.Compare(t, "channelserver/enter_stage_"+tt.name, sentPackets(session),
+			"Packets sent to a character entering "+tt.name+", a snapshot written by TestEnterStageSnapshot rather than a client capture")
+	}
+}
+
+func TestInfoGuildSnapshot(t *testing.T) {
+	guild := &Guild{
+		ID:             5,
+		Name:           "Hunters",
+		MainMotto:      1,
+		SubMotto:       2,
+		CreatedAt:      snapshotCreatedAt,
+		MemberCount:    12,
+		RankRP:         48000,
+		EventRP:        1500,
+		Comment:        "Weekly raids\nEveryone welcome",
+		FestivalColour: FestivalColourBlue,
+		Rank:           7,
+		GuildLeader:    GuildLeader{LeaderCharID: 100, LeaderName: "Leader"},
+	}
+	decorated := *guild
+	decorated.PugiName1 = "Pugi"
+	decorated.PugiName3 = "Pigu"
+	decorated.AllianceID = 3
+	decorated.Icon = &GuildIcon{Parts: []GuildIconPart{
+		{Index: 0, ID: 12, Page: 1, Size: 5, Rotation: 2, Red: 255, Green: 128, Blue: 0, PosX: 100, PosY: 200},
+		{Index: 1, ID: 40, Page: 2, Size: 3, Rotation: 0, Red: 0, Green: 0, Blue: 255, PosX: 50, PosY: 60},
+	}}
+	alliance := &GuildAlliance{
+		ID:            3,
+		Name:          "Alliance",
+		CreatedAt:     snapshotCreatedAt,
+		TotalMembers:  30,
+		ParentGuildID: 9,
+		SubGuild1ID:   5,
+		SubGuild2ID:   11,
+		ParentGuild:   Guild{ID: 9, Name: "Parent", Rank: 10, MemberCount: 10, GuildLeader: GuildLeader{LeaderName: "Boss"}},
+		SubGuild1:     decorated,
+		SubGuild2:     Guild{ID: 11, Name: "Second", Rank: 2, MemberCount: 8, GuildLeader: GuildLeader{LeaderName: "Other"}},
+	}
+	joinedAt := snapshotJoinedAt
+	leader := &GuildMember{GuildID: 5, CharID: 100, JoinedAt: &joinedAt, IsLeader: true}
+	member := &GuildMember{GuildID: 5, CharID: 101, JoinedAt: &joinedAt}
+	applicants := []*GuildMember{{GuildID: 5, CharID: 200, Name: "Applicant", IsApplicant: true}}
+
+	tests := []struct {
+		name       string
+		charID     uint32
+		guild      *Guild
+		membership *GuildMember
+		alliance   *GuildAlliance
+		applicants []*GuildMember
+	}{
+		{"outsider", 300, guild, nil, nil, nil},
+		{"member", 101, guild, member, nil, nil},
+		{"leader_in_alliance", 100, &decorated, leader, alliance, applicants},
+	}
+	for _, tt := range tests {
+		s := newTestServer()
+		session := newQueuedSession(t, s, tt.charID, "Viewer")
+		got := buildGuildInfo(session, tt.guild, tt.membership, tt.alliance, tt.applicants)
+		fixtures.Compare(t, "channelserver/info_guild_"+tt.name, got,
+			"MSG_MHF_INFO_GUILD response for "+tt.name+", a snapshot written by TestInfoGuildSnapshot rather than a client capture")
+	}
+}
+
+func TestEnumerateQuestSnapshot(t *testing.T) {
+	binPath := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(binPath, "questlists"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// The board is served as stored, the page only has to be passed through unchanged.
+	page := []byte{0x00, 0x02, 0x00, 0x00, 0xDE, 0xAD, 0xBE, 0xEF, 0x01, 0x02, 0x03, 0x04}
+	if err := os.WriteFile(filepath.Join(binPath, "questlists", "list_0.bin"), page, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		questList uint16
+	}{
+		{"stored_page", 0},
+		{"missing_page", 42},
+	}
+	for _, tt := range tests {
+		s := newTestServer()
+		s.erupeConfig.BinPath = binPath
+		session := newQueuedSession(t, s, 100, "Hunter")
+		session.rights = 0x0E
+
+		handleMsgMhfEnumerateQuest(session, &mhfpacket.MsgMhfEnumerateQuest{AckHandle: 0x1234, QuestList: tt.questList})
+		fixtures.Compare(t, "channelserver/enumerate_quest_"+tt.name, sentPackets(session),
+			"Packets sent for a quest board page, "+tt.name+", a snapshot written by TestEnumerateQuestSnapshot rather than a client capture")
+	}
+}
+
+// capturedAcks loads the captured acks in the fixtures directory, skipping the test if there are none.
+func capturedAcks(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(fixtures.Dir(), dir, "*"+fixtures.Extension))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Skipf("no captured acks in fixtures/%s", dir)
+	}
+	acks := make(map[string][]byte)
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), fixtures.Extension)
+		data, err := fixtures.Load(dir + "/" + name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		acks[name] = data
+	}
+	return acks
+}
+
+// parseCapturedAck parses a captured MSG_SYS_ACK from its opcode on.
+func parseCapturedAck(t *testing.T, name string, data []byte) *mhfpacket.MsgSysAck {
+	t.Helper()
+	bf := byteframe.NewByteFrameFromBytes(data)
+	if opcode := network.PacketID(bf.ReadUint16()); opcode != network.MSG_SYS_ACK {
+		t.Fatalf("%s: packet has opcode %s", name, opcode)
+	}
+	pkt := &mhfpacket.MsgSysAck{}
+	if err := pkt.Parse(bf, nil); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return pkt
+}
+
+// sentAck returns the MSG_SYS_ACK the session queued for the handle.
+func sentAck(t *testing.T, session *Session, ackHandle uint32) []byte {
+	t.Helper()
+	for {
+		select {
+		case packet := <-session.sendPackets:
+			bf := byteframe.NewByteFrameFromBytes(packet)
+			if network.PacketID(bf.ReadUint16()) == network.MSG_SYS_ACK && bf.ReadUint32() == ackHandle {
+				return packet
+			}
+		default:
+			t.Fatalf("no ack was sent for handle %#x", ackHandle)
+			return nil
+		}
+	}
+}
+
+func TestCapturedEnterStageAcks(t *testing.T) {
+	for name, data := range capturedAcks(t, capturedEnterStageAckDir) {
+		captured := parseCapturedAck(t, name, data)
+		s := newTestServer()
+		session := newQueuedSession(t, s, 100, "Hunter")
+		handleMsgSysEnterStage(session, &mhfpacket.MsgSysEnterStage{AckHandle: captured.AckHandle, StageID: "sl1Ns200p0a0u0"})
+		if got := sentAck(t, session, captured.AckHandle); !bytes.HasPrefix(data, got) {
+			t.Errorf("%s: sent %x, the client was sent %x", name, got, data)
+		}
+	}
+}
+
+func TestCapturedEnumerateQuestAcks(t *testing.T) {
+	for name, data := range capturedAcks(t, capturedEnumerateQuestAckDir) {
+		captured := parseCapturedAck(t, name, data)
+		// The board is served as stored, so storing the captured page has to give the captured ack back.
+		binPath := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(binPath, "questlists"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(binPath, "questlists", "list_0.bin"), captured.AckData, 0644); err != nil {
+			t.Fatal(err)
+		}
+		s := newTestServer()
+		s.erupeConfig.BinPath = binPath
+		session := newQueuedSession(t, s, 100, "Hunter")
+		session.rights = 0x0E
+		handleMsgMhfEnumerateQuest(session, &mhfpacket.MsgMhfEnumerateQuest{AckHandle: captured.AckHandle})
+		if got := sentAck(t, session, captured.AckHandle); !bytes.HasPrefix(data, got) {
+			t.Errorf("%s: sent %x, the client was sent %x", name, got, data)
+		}
+	}
+}
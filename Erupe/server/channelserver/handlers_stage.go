@@ -3,6 +3,7 @@ package channelserver
 import (
 	"fmt"
 	"time"
+	"sort"
 	"strings"
 
 	"github.com/Solenataris/Erupe/network/mhfpacket"
@@ -12,44 +13,47 @@ import (
 
 func handleMsgSysCreateStage(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgSysCreateStage)
-	s.server.Lock()
-	defer s.server.Unlock()
-	if _, exists := s.server.stages[pkt.StageID]; exists {
-    doAckSimpleFail(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
-	} else {
-		stage := NewStage(pkt.StageID)
-		stage.maxPlayers = uint16(pkt.PlayerCount)
-		s.server.stages[stage.id] = stage
-		doAckSimpleSucceed(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
+	stage, created := s.server.CreateStage(pkt.StageID, uint16(pkt.PlayerCount))
+	if !created {
+		s.logger.Warn("Stage already exists", zap.String("StageID", pkt.StageID))
+		doAckSimpleFail(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
+		return
 	}
+	stage.Lock()
+	stage.host = s
+	stage.Unlock()
+	doAckSimpleSucceed(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
 }
 
 func handleMsgSysStageDestruct(s *Session, p mhfpacket.MHFPacket) {}
 
 func doStageTransfer(s *Session, ackHandle uint32, stageID string) {
-	// Remove this session from old stage clients list and put myself in the new one.
-	s.server.stagesLock.Lock()
-	newStage, gotNewStage := s.server.stages[stageID]
-	s.server.stagesLock.Unlock()
+	transferToStage(s, stageID, func() { doAckSimpleSucceed(s, ackHandle, []byte{0x00, 0x00, 0x00, 0x00}) })
+}
 
+// transferToStage moves the session into the stage and sends it the stage's clients and objects.
+// confirm answers the client's request once the session is in, nil where a test moves a session without one.
+func transferToStage(s *Session, stageID string, confirm func()) {
+	// Remove this session from old stage clients list and put myself in the new one.
 	if s.stage != nil {
 		removeSessionFromStage(s)
 	}
 
-	// Add the new stage.
-	if gotNewStage {
-		newStage.Lock()
-		newStage.clients[s] = s.charID
-		newStage.Unlock()
-	} else {
-		// Fix stages
-		s.logger.Info("Fix Map Appliqued")
-		s.server.stagesLock.Lock()
-		s.server.stages[stageID] = NewStage(stageID)
-		newStage = s.server.stages[stageID]
-		s.server.stagesLock.Unlock()
+	// Add the new stage, retrying if it was torn down between lookup and join.
+	var newStage *Stage
+	for {
+		var created bool
+		newStage, created = s.server.GetOrCreateStage(stageID)
+		if created {
+			// Fix stages
+			s.logger.Info("Fix Map Appliqued")
+		}
 		newStage.Lock()
-		newStage.clients[s] = s.charID
+		if !newStage.destroyed {
+			newStage.addClient(s)
+			newStage.Unlock()
+			break
+		}
 		newStage.Unlock()
 	}
 
@@ -57,13 +61,18 @@ func doStageTransfer(s *Session, ackHandle uint32, stageID string) {
 	s.Lock()
 	s.stageID = string(stageID)
 	s.stage = newStage
+	s.updateLogFields()
 	s.Unlock()
 
+	trackQuestDeparture(s, stageID)
+
 	// Tell the client to cleanup its current stage objects.
 	s.QueueSendMHF(&mhfpacket.MsgSysCleanupObject{})
 
 	// Confirm the stage entry.
-	doAckSimpleSucceed(s, ackHandle, []byte{0x00, 0x00, 0x00, 0x00})
+	if confirm != nil {
+		confirm()
+	}
 
 	// Notify existing stage clients that this new client has entered.
 	s.logger.Info("Sending MsgSysInsertUser")
@@ -89,54 +98,60 @@ func doStageTransfer(s *Session, ackHandle uint32, stageID string) {
 
 		// Notify the entree client about all of the existing clients in the stage.
 		s.logger.Info("Notifying entree about existing stage clients")
+		s.server.Lock()
+		sessions := make([]*Session, 0, len(s.server.sessions))
+		for _, session := range s.server.sessions {
+			sessions = append(sessions, session)
+		}
+		s.server.Unlock()
+		sort.Slice(sessions, func(i, j int) bool { return sessions[i].charID < sessions[j].charID })
 		s.stage.RLock()
-		clientNotif := byteframe.NewByteFrame()
+		clientNotif := newPacketGroup()
+		members := s.stage.clientsByJoinOrder()
 
 		// Get other players in the stage
-		for session := range s.stage.clients {
+		for _, session := range members {
 			var cur mhfpacket.MHFPacket
 			cur = &mhfpacket.MsgSysInsertUser{
 				CharID: session.charID,
 			}
-			clientNotif.WriteUint16(uint16(cur.Opcode()))
-			cur.Build(clientNotif, session.clientContext)
+			clientNotif.add(cur, session.clientContext)
 		}
 
 		// Get every players binary
-		for session := range s.server.sessions {
+		for _, session := range sessions {
 			var cur mhfpacket.MHFPacket
-			session := s.server.sessions[session]
 
 			cur = &mhfpacket.MsgSysNotifyUserBinary{
 				CharID:     session.charID,
 				BinaryType: 1,
 			}
-			clientNotif.WriteUint16(uint16(cur.Opcode()))
-			cur.Build(clientNotif, session.clientContext)
+			clientNotif.add(cur, session.clientContext)
 
 			cur = &mhfpacket.MsgSysNotifyUserBinary{
 				CharID:     session.charID,
 				BinaryType: 2,
 			}
-			clientNotif.WriteUint16(uint16(cur.Opcode()))
-			cur.Build(clientNotif, session.clientContext)
+			clientNotif.add(cur, session.clientContext)
 
 			cur = &mhfpacket.MsgSysNotifyUserBinary{
 				CharID:     session.charID,
 				BinaryType: 3,
 			}
-			clientNotif.WriteUint16(uint16(cur.Opcode()))
-			cur.Build(clientNotif, session.clientContext)
+			clientNotif.add(cur, session.clientContext)
 		}
 		s.stage.RUnlock()
-		clientNotif.WriteUint16(0x0010) // End it.
-		s.QueueSend(clientNotif.Data())
+		s.QueueSendGroup(clientNotif)
+
+		// The client asks for the display data of every member it was just told about, have it ready.
+		if err := s.server.appearance.prefetch(members); err != nil {
+			s.logger.Warn("Failed to prefetch the stage's display data", zap.Error(err))
+		}
 
 		// Notify the client to duplicate the existing objects.
 		s.logger.Info("Notifying entree about existing stage objects")
-		clientDupObjNotif := byteframe.NewByteFrame()
-		s.stage.RLock()
-		for _, obj := range s.stage.objects {
+		clientDupObjNotif := newPacketGroup()
+		for _, obj := range s.stage.objectStates() {
 				cur := &mhfpacket.MsgSysDuplicateObject{
 					ObjID:       obj.id,
 					X:           obj.x,
@@ -145,101 +160,158 @@ func doStageTransfer(s *Session, ackHandle uint32, stageID string) {
 					Unk0:        0,
 					OwnerCharID: obj.ownerCharID,
 				}
-				clientDupObjNotif.WriteUint16(uint16(cur.Opcode()))
-				cur.Build(clientDupObjNotif, s.clientContext)
+				clientDupObjNotif.add(cur, s.clientContext)
 		}
-		s.stage.RUnlock()
-		clientDupObjNotif.WriteUint16(0x0010) // End it.
-		s.QueueSend(clientDupObjNotif.Data())
+		s.QueueSendGroup(clientDupObjNotif)
 	}
 }
 
+// isQuestStageID reports whether the stage is a quest instance rather than a town or lobby.
+func isQuestStageID(stageID string) bool {
+	return strings.HasPrefix(stageID, "sl1Qs") || strings.HasPrefix(stageID, "sl2Qs") || strings.HasPrefix(stageID, "sl3Qs")
+}
+
 func removeEmptyStages(s *Session) {
-	s.server.Lock()
-	for sid, stage := range s.server.stages {
-		if len(stage.reservedClientSlots) == 0 && len(stage.clients) == 0 {
-			if strings.HasPrefix(sid, "sl1Qs") || strings.HasPrefix(sid, "sl2Qs") || strings.HasPrefix(sid, "sl3Qs") {
-				delete(s.server.stages, sid)
-			}
+	var candidates []string
+	s.server.stagesLock.RLock()
+	for sid := range s.server.stages {
+		if isQuestStageID(sid) {
+			candidates = append(candidates, sid)
 		}
 	}
-	s.server.Unlock()
+	s.server.stagesLock.RUnlock()
+
+	for _, sid := range candidates {
+		s.server.RemoveStageIfEmpty(sid)
+	}
 }
 
 func removeSessionFromStage(s *Session) {
-	s.stage.Lock()
-	defer s.stage.Unlock()
-
-	// Remove client from old stage.
-	delete(s.stage.clients, s)
-	delete(s.stage.reservedClientSlots, s.charID)
+	stage := s.stage
 
 	// Remove client from all reservations
-	s.server.Lock()
-	for _, stage := range s.server.stages {
-		if _, exists := stage.reservedClientSlots[s.charID]; exists {
-			delete(stage.reservedClientSlots, s.charID)
-		}
+	s.server.stagesLock.RLock()
+	for _, st := range s.server.stages {
+		st.Lock()
+		delete(st.reservedClientSlots, s.charID)
+		st.Unlock()
 	}
-	s.server.Unlock()
+	s.server.stagesLock.RUnlock()
 
-	// Delete old stage objects owned by the client.
+	stage.Lock()
+
+	// Remove client from old stage.
+	delete(stage.clients, s)
+	delete(stage.clientJoinOrder, s)
+	delete(stage.reservedClientSlots, s.charID)
+
+	// Delete the client's character, and hand its other objects over to the client that has been
+	// in the stage the longest. They're only deleted once nobody is left to own them.
 	s.logger.Info("Sending MsgSysDeleteObject to old stage clients")
-	for objID, stageObject := range s.stage.objects {
-		if stageObject.ownerCharID == s.charID {
-			// Broadcast the deletion to clients in the stage.
-			s.stage.BroadcastMHF(&mhfpacket.MsgSysDeleteObject{
-				ObjID: stageObject.id,
-			}, s)
-			// TODO(Andoryuuta): Should this be sent to the owner's client as well? it currently isn't.
-			// Actually delete it form the objects map.
-			delete(s.stage.objects, objID)
+	newOwner := stage.longestPresentClient()
+	for objID, stageObject := range stage.objects {
+		if stageObject.ownerCharID != s.charID {
+			continue
 		}
+		if newOwner != nil && !stageObject.avatar {
+			stageObject.ownerCharID = newOwner.charID
+			if slot, ok := stage.objectList[uint8(stageObject.id>>16)]; ok {
+				slot.charid = newOwner.charID
+			}
+			stage.broadcastMHFLocked(&mhfpacket.MsgSysDuplicateObject{
+				ObjID:       stageObject.id,
+				X:           stageObject.x,
+				Y:           stageObject.y,
+				Z:           stageObject.z,
+				OwnerCharID: newOwner.charID,
+			}, nil)
+			continue
+		}
+		// Broadcast the deletion to clients in the stage.
+		stage.broadcastMHFLocked(&mhfpacket.MsgSysDeleteObject{
+			ObjID: stageObject.id,
+		}, s)
+		// TODO(Andoryuuta): Should this be sent to the owner's client as well? it currently isn't.
+		// Actually delete it form the objects map.
+		delete(stage.objects, objID)
 	}
-	for objListID, stageObjectList := range s.stage.objectList {
+	for objListID, stageObjectList := range stage.objectList {
 		if stageObjectList.charid == s.charID {
 			//Added to prevent duplicates from flooding ObjectMap and causing server hangs
-			s.stage.objectList[objListID].status=false
-			s.stage.objectList[objListID].charid=0
+			stage.objectList[objListID].status=false
+			stage.objectList[objListID].charid=0
 		}
 	}
+	stage.Unlock()
 
 	removeEmptyStages(s)
 }
 
 
+// blockedFromEntering fails the entry with the same error as a full room if the
+// room's host has blocked the session, so the block isn't given away.
+func blockedFromEntering(s *Session, ackHandle uint32, stageID string) bool {
+	stage, exists := s.server.GetStage(stageID)
+	if !exists || !s.server.blockedFromStage(stage, s.charID) {
+		return false
+	}
+	doAckSimpleFail(s, ackHandle, []byte{0x00, 0x00, 0x00, 0x00})
+	return true
+}
+
 func handleMsgSysEnterStage(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgSysEnterStage)
-	fmt.Printf("The Stage is %s\n",pkt.StageID)
+	s.logger.Debug("Entering stage", zap.String("target", pkt.StageID))
+	if blockedFromEntering(s, pkt.AckHandle, pkt.StageID) || departureGated(s, pkt.AckHandle, pkt.StageID) {
+		return
+	}
 	// Push our current stage ID to the movement stack before entering another one.
 	s.Lock()
 	s.stageMoveStack.Push(s.stageID)
 	s.Unlock()
 
 	doStageTransfer(s, pkt.AckHandle, pkt.StageID)
+	s.loginMessage.Do(func() {
+		s.enteredWorld()
+		sendLoginMessage(s)
+		sendContentGateNotices(s)
+		sendReturnedRentals(s)
+		sendRenameNotice(s)
+	})
 }
 
 func handleMsgSysBackStage(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgSysBackStage)
 
-	if s.stage != nil {
-		removeSessionFromStage(s)
-	}
-
 	// Transfer back to the saved stage ID before the previous move or enter.
 	s.Lock()
 	backStage, err := s.stageMoveStack.Pop()
 	s.Unlock()
 
 	if err != nil {
-		panic(err)
+		// A session that reconnected has no moves to go back on, it stays where it is.
+		s.logger.Warn("Rejected back stage without a previous stage", zap.Error(err))
+		doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+		return
 	}
 
+	// The stage may have been cleaned up while the session was away, it's recreated from its ID. The first
+	// entry of a login pushed no stage at the bottom of the stack, the way back from it is the default stage
+	// of this world and land.
+	if backStage == "" {
+		s.logger.Warn("Moved back to the default stage from before the first stage", zap.String("stage", s.server.defaultStage))
+		backStage = s.server.defaultStage
+	} else if _, exists := s.server.GetStage(backStage); !exists {
+		s.logger.Warn("Recreating the previous stage, it was removed", zap.String("stage", backStage))
+	}
 	doStageTransfer(s, pkt.AckHandle, backStage)
 }
 
 func handleMsgSysMoveStage(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgSysMoveStage)
+	if blockedFromEntering(s, pkt.AckHandle, pkt.StageID) || departureGated(s, pkt.AckHandle, pkt.StageID) {
+		return
+	}
 
 	// Push our current stage ID to the movement stack before entering another one.
 	s.Lock()
@@ -254,36 +326,63 @@ func handleMsgSysLeaveStage(s *Session, p mhfpacket.MHFPacket) {}
 func handleMsgSysLockStage(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgSysLockStage)
 	// TODO(Andoryuuta): What does this packet _actually_ do?
-	doAckSimpleSucceed(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
+	// The host sends it on departure, so its ack waits for the party's ready check.
+	cfg := s.server.erupeConfig.Channel
+	stage, gotStage := s.server.GetStage(pkt.StageID)
+	if !gotStage || cfg.ReadyCheckTimeout <= 0 {
+		doAckSimpleSucceed(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
+		return
+	}
+
+	members := stage.startReadyCheck(s, pkt.AckHandle, time.Duration(cfg.ReadyCheckTimeout)*time.Second, cfg.ReadyCheckKick)
+	if len(members) == 0 {
+		doAckSimpleSucceed(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
+		return
+	}
+	for _, charID := range members {
+		session := s.server.FindSessionByCharID(charID)
+		if session == nil {
+			// Gone already, there's no one to wait for.
+			stage.confirmReady(charID)
+			continue
+		}
+		sendServerChatMessage(session, fmt.Sprintf("Your party is departing, say \"!ready\" or \"!notready\" within %d seconds.", cfg.ReadyCheckTimeout))
+	}
 }
 
 func handleMsgSysUnlockStage(s *Session, p mhfpacket.MHFPacket) {
-	s.reservationStage.RLock()
-	defer s.reservationStage.RUnlock()
+	stage := s.reservationStage
+	if stage == nil {
+		return
+	}
+
+	stage.RLock()
+	reserved := make([]uint32, 0, len(stage.reservedClientSlots))
+	for charID := range stage.reservedClientSlots {
+		reserved = append(reserved, charID)
+	}
+	stage.RUnlock()
 
 	destructMessage := &mhfpacket.MsgSysStageDestruct{}
 
-	for charID := range s.reservationStage.reservedClientSlots {
+	for _, charID := range reserved {
 		session := s.server.FindSessionByCharID(charID)
-		session.QueueSendMHF(destructMessage)
+		if session != nil {
+			session.QueueSendMHF(destructMessage)
+		}
 	}
 
-	s.server.Lock()
-	defer s.server.Unlock()
-
-	delete(s.server.stages, s.reservationStage.id)
+	s.server.RemoveStage(stage.id)
 }
 
 func handleMsgSysReserveStage(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgSysReserveStage)
 
 	stageID := pkt.StageID
-	fmt.Printf("Got reserve stage req, TargetCount:%v, StageID:%v\n", pkt.Unk0, stageID)
+	s.logger.Debug("Got reserve stage req", zap.Uint8("targetCount", pkt.Unk0), zap.String("target", stageID))
 
 	// Try to get the stage
-	s.server.stagesLock.Lock()
-	stage, gotStage := s.server.stages[stageID]
-	s.server.stagesLock.Unlock()
+	stage, gotStage := s.server.GetStage(stageID)
 
 	if !gotStage {
 		s.logger.Error("Failed to get stage", zap.String("StageID", stageID))
@@ -291,16 +390,41 @@ func handleMsgSysReserveStage(s *Session, p mhfpacket.MHFPacket) {
 		return
 	}
 
-	// Try to reserve a slot, fail if full.
+	// Looks the same as a full quest to a blocked player.
+	if s.server.blockedFromStage(stage, s.charID) {
+		doAckSimpleFail(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
+		return
+	}
+
+	// Try to reserve a slot, fail if full. The answers are sent once the stage lock is released.
 	stage.Lock()
-	defer stage.Unlock()
+
+	// The stage was removed after we looked it up, don't reserve into a dead stage.
+	if stage.destroyed {
+		stage.Unlock()
+		s.logger.Warn("Stage was removed before reservation", zap.String("StageID", stageID))
+		doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+		return
+	}
+
+	var acks []reservationAck
 
 	// Quick fix to allow readying up while party is full, more investigation needed
 	// Reserve stage is also sent when a player is ready, probably need to parse the
 	// request a little more thoroughly.
 	if _, exists := stage.reservedClientSlots[s.charID]; exists {
-		doAckSimpleSucceed(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
-	} else if uint16(len(stage.reservedClientSlots)) < stage.maxPlayers {
+		// A slot held for an invited character from another channel is taken up here, the member's own
+		// slot reserved again means they're ready.
+		s.Lock()
+		ready := s.reservationStage == stage
+		s.reservationStage = stage
+		s.Unlock()
+		if ready {
+			stage.reservedClientSlots[s.charID] = readyReservation{}
+		}
+		stage.dropFromReadyCheckLocked(s.charID)
+		acks = append(acks, reservationAck{s, pkt.AckHandle, true})
+	} else if uint16(stage.slotsTakenLocked()) < stage.maxPlayers {
 		// Add the charID to the stage's reservation map
 		stage.reservedClientSlots[s.charID] = nil
 
@@ -309,30 +433,21 @@ func handleMsgSysReserveStage(s *Session, p mhfpacket.MHFPacket) {
 		s.reservationStage = stage
 		s.Unlock()
 
-		doAckSimpleSucceed(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
+		acks = append(acks, reservationAck{s, pkt.AckHandle, true})
 	} else {
-		doAckSimpleFail(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
+		queued, previous := stage.queueReservationLocked(s, pkt.AckHandle)
+		acks = append(acks, previous...)
+		if !queued {
+			// Answered as full straight away rather than leaving the client hanging.
+			acks = append(acks, reservationAck{s, pkt.AckHandle, false})
+		}
 	}
+	stage.Unlock()
+	sendReservationAcks(acks)
 }
 
 func handleMsgSysUnreserveStage(s *Session, p mhfpacket.MHFPacket) {
-	// Clear the saved reservation stage
-	s.Lock()
-	stage := s.reservationStage
-	if stage != nil {
-		s.reservationStage = nil
-	}
-	s.Unlock()
-
-	// Remove the charID from the stage's reservation map
-	if stage != nil {
-		stage.Lock()
-		_, exists := stage.reservedClientSlots[s.charID]
-		if exists {
-			delete(stage.reservedClientSlots, s.charID)
-		}
-		stage.Unlock()
-	}
+	cancelStageReservation(s)
 }
 
 func handleMsgSysSetStagePass(s *Session, p mhfpacket.MHFPacket) {}
@@ -343,9 +458,7 @@ func handleMsgSysWaitStageBinary(s *Session, p mhfpacket.MHFPacket) {
 
 	// Try to get the stage
 	stageID := pkt.StageID
-	s.server.stagesLock.Lock()
-	stage, gotStage := s.server.stages[stageID]
-	s.server.stagesLock.Unlock()
+	stage, gotStage := s.server.GetStage(stageID)
 
 	// TODO(Andoryuuta): This is a hack for a binary part that none of the clients set, figure out what it represents.
 	// In the packet captures, it seemingly comes out of nowhere, so presumably the server makes it.
@@ -397,9 +510,7 @@ func handleMsgSysSetStageBinary(s *Session, p mhfpacket.MHFPacket) {
 
 	// Try to get the stage
 	stageID := pkt.StageID
-	s.server.stagesLock.Lock()
-	stage, gotStage := s.server.stages[stageID]
-	s.server.stagesLock.Unlock()
+	stage, gotStage := s.server.GetStage(stageID)
 
 	// If we got the stage, lock and set the data.
 	if gotStage {
@@ -417,9 +528,7 @@ func handleMsgSysGetStageBinary(s *Session, p mhfpacket.MHFPacket) {
 
 	// Try to get the stage
 	stageID := pkt.StageID
-	s.server.stagesLock.Lock()
-	stage, gotStage := s.server.stages[stageID]
-	s.server.stagesLock.Unlock()
+	stage, gotStage := s.server.GetStage(stageID)
 
 	// If we got the stage, lock and try to get the data.
 	var stageBinary []byte
@@ -465,10 +574,18 @@ func handleMsgSysEnumerateStage(s *Session, p mhfpacket.MHFPacket) {
 	resp := byteframe.NewByteFrame()
 	bf := byteframe.NewByteFrame()
 	var joinable int
-	for sid, stage := range s.server.stages {
+	// In ID order, so the same stages always make the same response.
+	sids := make([]string, 0, len(s.server.stages))
+	for sid := range s.server.stages {
+		sids = append(sids, sid)
+	}
+	sort.Strings(sids)
+	for _, sid := range sids {
+		stage := s.server.stages[sid]
+		// Only one stage is locked at a time, see Stage.
 		stage.RLock()
-		defer stage.RUnlock()
 		if len(stage.reservedClientSlots) == 0 && len(stage.clients) == 0 {
+			stage.RUnlock()
 			continue
 		}
 		joinable++
@@ -485,6 +602,7 @@ func handleMsgSysEnumerateStage(s *Session, p mhfpacket.MHFPacket) {
 		resp.WriteBool(len(stage.password) > 0) // Password protected.
 		resp.WriteUint8(uint8(len(sid)))
 		resp.WriteBytes([]byte(sid))
+		stage.RUnlock()
 	}
 	bf.WriteUint16(uint16(joinable))
 	bf.WriteBytes(resp.Data())
@@ -443,4 +443,6 @@ func init() {
 	handlerTable[network.MSG_SYS_reserve20D] = handleMsgSysReserve20D
 	handlerTable[network.MSG_SYS_reserve20E] = handleMsgSysReserve20E
 	handlerTable[network.MSG_SYS_reserve20F] = handleMsgSysReserve20F
+	handlerTable[network.MSG_SYS_ENQUEUE_MATCH] = handleMsgSysEnqueueMatch
+	handlerTable[network.MSG_SYS_MATCH_FOUND] = handleMsgSysMatchFound
 }
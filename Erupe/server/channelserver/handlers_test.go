@@ -0,0 +1,69 @@
+package channelserver
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/common/testdb"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+)
+
+// TestGuacotSlots runs against the database in ERUPE_TEST_DB, like TestRedeemCampaignCode.
+func TestGuacotSlots(t *testing.T) {
+	db := testdb.Open(t)
+	var userID, charID uint32
+	if err := db.QueryRow("INSERT INTO users (username, password) VALUES ('guacot_test', '') RETURNING id").Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+	err := db.QueryRow("INSERT INTO characters (user_id, is_new_character, name) VALUES ($1, false, 'guacot') RETURNING id", userID).Scan(&charID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM characters WHERE id = $1", charID)
+	defer db.Exec("DELETE FROM gook WHERE id = $1", charID)
+
+	s := newTestServer()
+	s.db = db
+	session := newQueuedSession(t, s, charID, "guacot")
+	enumerate := func() []byte {
+		handleMsgMhfEnumerateGuacot(session, &mhfpacket.MsgMhfEnumerateGuacot{AckHandle: 1})
+		bf := byteframe.NewByteFrameFromBytes(sentPackets(session))
+		bf.ReadUint16() // MSG_SYS_ACK.
+		bf.ReadUint32() // Ack handle.
+		bf.ReadUint16() // Is buffer response and error code.
+		return bf.ReadBytes(uint(bf.ReadUint16()))
+	}
+	update := func(entries ...*mhfpacket.GuacotUpdateEntry) {
+		handleMsgMhfUpdateGuacot(session, &mhfpacket.MsgMhfUpdateGuacot{AckHandle: 2, EntryCount: uint16(len(entries)), Entries: entries})
+		sentPackets(session)
+	}
+
+	if got := enumerate(); !bytes.Equal(got, make([]byte, 4)) {
+		t.Errorf("a character without guacots got %x", got)
+	}
+	// The client's first update has no entries and starts the row.
+	update()
+	first := &mhfpacket.GuacotUpdateEntry{Unk0: 0, Unk1: 1, DataSize: 2, RawDataPayload: []byte{0xAA, 0xBB}}
+	sixth := &mhfpacket.GuacotUpdateEntry{Unk0: 5, Unk1: 1, DataSize: 1, RawDataPayload: []byte{0xCC}}
+	update(first, sixth, &mhfpacket.GuacotUpdateEntry{Unk0: 6, Unk1: 1})
+
+	pkt := &mhfpacket.MsgMhfUpdateGuacot{}
+	want := byteframe.NewByteFrame()
+	want.WriteUint16(2)
+	want.WriteBytes(pkt.GuacotUpdateEntryToBytes(first))
+	want.WriteBytes(pkt.GuacotUpdateEntryToBytes(sixth))
+	if got := enumerate(); !bytes.Equal(got, want.Data()) {
+		t.Errorf("got guacots %x, want slots 0 and 5 as %x", got, want.Data())
+	}
+
+	// An entry without a status empties its slot.
+	update(&mhfpacket.GuacotUpdateEntry{Unk0: 0, Unk1: 0})
+	want = byteframe.NewByteFrame()
+	want.WriteUint16(1)
+	want.WriteBytes(pkt.GuacotUpdateEntryToBytes(sixth))
+	if got := enumerate(); !bytes.Equal(got, want.Data()) {
+		t.Errorf("got guacots %x after emptying slot 0, want %x", got, want.Data())
+	}
+}
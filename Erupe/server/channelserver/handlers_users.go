@@ -23,7 +23,10 @@ func handleMsgSysSetUserBinary(s *Session, p mhfpacket.MHFPacket) {
 		BinaryType: pkt.BinaryType,
 	}
 
-	s.stage.BroadcastMHF(msg, s)
+	// Sent ahead of the first stage entry too, the stage's clients are told once it enters.
+	if stage := s.currentStage(); stage != nil {
+		stage.BroadcastMHF(msg, s)
+	}
 }
 
 func handleMsgSysGetUserBinary(s *Session, p mhfpacket.MHFPacket) {
@@ -55,6 +58,10 @@ func handleMsgSysGetUserBinary(s *Session, p mhfpacket.MHFPacket) {
 			resp.WriteBytes(data)
 		}
 	} else {
+		festa := s.server.erupeConfig.Festa
+		if pkt.BinaryType == 3 && festa.Enabled && festa.TeamFlagOffset > 0 && s.stage != nil && !isQuestStageID(s.stage.id) {
+			data = withFestaTeam(data, festa.TeamFlagOffset, s.server.festaTeamFor(s.stage, pkt.CharID))
+		}
 		resp.WriteBytes(data)
 	}
 
@@ -64,3 +71,15 @@ func handleMsgSysGetUserBinary(s *Session, p mhfpacket.MHFPacket) {
 func handleMsgSysNotifyUserBinary(s *Session, p mhfpacket.MHFPacket) {}
 
 func handleMsgMhfGetBbsUserStatus(s *Session, p mhfpacket.MHFPacket) {}
+
+// withFestaTeam returns a copy of the character display binary with the festa team flag set,
+// overriding whatever flag the owning client picked for itself.
+func withFestaTeam(data []byte, offset int, team FestaTeam) []byte {
+	if len(data) <= offset {
+		return data
+	}
+	patched := make([]byte, len(data))
+	copy(patched, data)
+	patched[offset] = byte(team)
+	return patched
+}
@@ -0,0 +1,73 @@
+package channelserver
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// AdminSession is a connected character as listed by the admin API.
+type AdminSession struct {
+	CharID        uint32 `json:"charID"`
+	Name          string `json:"name"`
+	Stage         string `json:"stage"`
+	UptimeSeconds int64  `json:"uptimeSeconds"`
+}
+
+// AdminSessions lists the characters connected to the channel.
+func (s *Server) AdminSessions() []AdminSession {
+	s.Lock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.Unlock()
+
+	now := Time_Current_Adjusted().Unix()
+	list := make([]AdminSession, 0, len(sessions))
+	for _, session := range sessions {
+		session.Lock()
+		if session.charID != 0 {
+			list = append(list, AdminSession{
+				CharID:        session.charID,
+				Name:          session.Name,
+				Stage:         session.stageID,
+				UptimeSeconds: now - session.sessionStart,
+			})
+		}
+		session.Unlock()
+	}
+	return list
+}
+
+// Kick disconnects the character if it's on the channel, running the usual logout.
+func (s *Server) Kick(charID uint32) bool {
+	return s.disconnectCharacter(charID, DisconnectKicked)
+}
+
+// DisconnectAll saves and disconnects every session, as a shutdown does without closing the channel,
+// and returns how many there were. Saves still running when ctx is done are abandoned.
+func (s *Server) DisconnectAll(ctx context.Context) int {
+	s.waitForSaves(ctx)
+
+	s.Lock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.Unlock()
+	flushed := make(chan struct{})
+	go func() {
+		for _, session := range sessions {
+			s.flushSession(session)
+		}
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+		s.waitForSaves(ctx)
+	case <-ctx.Done():
+		s.logger.Warn("Timed out flushing sessions", zap.Error(ctx.Err()))
+	}
+	return len(sessions)
+}
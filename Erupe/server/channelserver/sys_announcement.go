@@ -0,0 +1,315 @@
+package channelserver
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Solenataris/Erupe/common/targeting"
+	"github.com/Solenataris/Erupe/config"
+	"go.uber.org/zap"
+)
+
+// How often the channel checks for announcements that are due.
+const announcementInterval = time.Second
+
+// cronField is the set of values a field of a cron spec matches.
+type cronField struct {
+	values map[int]bool
+	any    bool // The field was "*", which matters for the day and weekday fields.
+}
+
+func (f cronField) match(v int) bool {
+	return f.any || f.values[v]
+}
+
+// cronSpec is a parsed "minute hour day month weekday" schedule. Weekdays run from 0 for Sunday to 6,
+// 7 is Sunday as well. As with cron, a time matches either day field when both are restricted.
+type cronSpec struct {
+	minute, hour, day, month, weekday cronField
+}
+
+// parseCronSpec parses the five fields of a cron spec, each a "*" or a list of values, ranges such as
+// "1-5" and steps such as "*/15" or "0-30/10".
+func parseCronSpec(spec string) (*cronSpec, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron spec %q needs 5 fields, got %d", spec, len(fields))
+	}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	var parsed [5]cronField
+	for i, field := range fields {
+		f, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("cron spec %q: %w", spec, err)
+		}
+		parsed[i] = f
+	}
+	if parsed[4].values[7] {
+		parsed[4].values[0] = true
+	}
+	return &cronSpec{parsed[0], parsed[1], parsed[2], parsed[3], parsed[4]}, nil
+}
+
+func parseCronField(field string, min int, max int) (cronField, error) {
+	if field == "*" {
+		return cronField{any: true}, nil
+	}
+	f := cronField{values: make(map[int]bool)}
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return f, fmt.Errorf("invalid step in %q", part)
+			}
+			rangePart, step = part[:i], n
+		}
+		low, high := min, max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return f, fmt.Errorf("invalid value %q", part)
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return f, fmt.Errorf("invalid range %q", part)
+				}
+			}
+		}
+		if low < min || high > max || low > high {
+			return f, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := low; v <= high; v += step {
+			f.values[v] = true
+		}
+	}
+	return f, nil
+}
+
+func (c *cronSpec) matchDay(t time.Time) bool {
+	if !c.month.match(int(t.Month())) {
+		return false
+	}
+	if !c.day.any && !c.weekday.any {
+		return c.day.match(t.Day()) || c.weekday.match(int(t.Weekday()))
+	}
+	return c.day.match(t.Day()) && c.weekday.match(int(t.Weekday()))
+}
+
+// next returns the first minute after t the spec matches, in t's location.
+// A spec that can't match within eight years, such as "0 0 31 2 *", returns the zero time.
+func (c *cronSpec) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(8, 0, 0)
+	for t.Before(limit) {
+		if !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.hour.match(t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.minute.match(t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// scheduledAnnouncement is an announcement the channel sends and when it's next due.
+type scheduledAnnouncement struct {
+	message  string
+	target   *targeting.Filter // Characters it's sent to, nil for every one.
+	cron     *cronSpec
+	interval time.Duration
+	next     time.Time
+}
+
+// after returns when the announcement is due next after t.
+func (a *scheduledAnnouncement) after(t time.Time) time.Time {
+	if a.cron != nil {
+		return a.cron.next(t)
+	}
+	return t.Add(a.interval)
+}
+
+// newScheduledAnnouncement checks the schedule of an announcement, starting it at the given time.
+func newScheduledAnnouncement(cfg config.Announcement, start time.Time) (*scheduledAnnouncement, error) {
+	if cfg.Message == "" {
+		return nil, errors.New("announcement without a message")
+	}
+	a := &scheduledAnnouncement{message: cfg.Message}
+	if cfg.Target != "" {
+		target, err := targeting.Parse(cfg.Target)
+		if err != nil {
+			return nil, err
+		}
+		a.target = target
+	}
+	switch {
+	case cfg.Cron != "" && cfg.Interval > 0:
+		return nil, errors.New("announcement with both a cron spec and an interval")
+	case cfg.Cron != "":
+		spec, err := parseCronSpec(cfg.Cron)
+		if err != nil {
+			return nil, err
+		}
+		a.cron = spec
+	case cfg.Interval > 0:
+		a.interval = time.Duration(cfg.Interval) * time.Minute
+	default:
+		return nil, errors.New("announcement needs a cron spec or an interval")
+	}
+	a.next = a.after(start)
+	if a.next.IsZero() {
+		return nil, fmt.Errorf("cron spec %q never matches", cfg.Cron)
+	}
+	return a, nil
+}
+
+// announcer sends the scheduled announcements targeting the channel's world.
+type announcer struct {
+	sync.Mutex
+	scheduled []*scheduledAnnouncement
+	stop      chan struct{}
+}
+
+// targetsWorld reports whether an announcement for the given worlds is sent on the world.
+func targetsWorld(worlds []string, world string) bool {
+	if len(worlds) == 0 {
+		return true
+	}
+	for _, w := range worlds {
+		if strings.EqualFold(w, world) {
+			return true
+		}
+	}
+	return false
+}
+
+// announcementText fills the placeholders of an announcement in.
+func announcementText(message string, online int) string {
+	return strings.ReplaceAll(message, "{online}", strconv.Itoa(online))
+}
+
+// onlineCount returns the number of players on every channel of the process, or on this one if it runs alone.
+func (s *Server) onlineCount() int {
+	if s.registry == nil {
+		return s.PlayerCount()
+	}
+	online := 0
+	for _, server := range s.registry.Servers() {
+		online += server.PlayerCount()
+	}
+	return online
+}
+
+// Announce sends the message in chat if the channel's world is among the worlds, or any world if there
+// are none, reporting whether it did. With a target only the characters it picks get the message.
+func (s *Server) Announce(message string, worlds []string, target *targeting.Filter) bool {
+	if !targetsWorld(worlds, s.world) {
+		return false
+	}
+	text := announcementText(message, s.onlineCount())
+	if target == nil {
+		s.BroadcastChatMessage(text)
+		return true
+	}
+
+	sessions := make(map[uint32]*Session)
+	var charIDs []uint32
+	s.Lock()
+	for _, session := range s.sessions {
+		if session.charID != 0 {
+			sessions[session.charID] = session
+			charIDs = append(charIDs, session.charID)
+		}
+	}
+	s.Unlock()
+	picked, err := targeting.Among(s.db, target, Time_Current(), charIDs)
+	if err != nil {
+		s.logger.Error("Failed to pick the characters to announce to", zap.String("target", target.String()), zap.Error(err))
+		return true
+	}
+	for _, charID := range picked {
+		sendServerChatMessage(sessions[charID], text)
+	}
+	return true
+}
+
+// scheduleAnnouncements prepares the configured announcements targeting the channel's world, starting
+// their schedules at the given event clock time. Invalid ones are logged and left out.
+func (s *Server) scheduleAnnouncements(start time.Time) {
+	var scheduled []*scheduledAnnouncement
+	for i, cfg := range s.erupeConfig.Current().Announcements {
+		if !cfg.Enabled || !targetsWorld(cfg.Worlds, s.world) {
+			continue
+		}
+		a, err := newScheduledAnnouncement(cfg, start)
+		if err != nil {
+			s.logger.Error("Invalid announcement", zap.Int("index", i), zap.Error(err))
+			continue
+		}
+		scheduled = append(scheduled, a)
+	}
+	s.announcements.Lock()
+	s.announcements.scheduled = scheduled
+	s.announcements.Unlock()
+}
+
+// announceDue sends the announcements due by now and schedules their next send.
+// An announcement that fell due more than once since the last check is sent once.
+func (s *Server) announceDue(now time.Time) {
+	var due []*scheduledAnnouncement
+	s.announcements.Lock()
+	for _, a := range s.announcements.scheduled {
+		if a.next.IsZero() || now.Before(a.next) {
+			continue
+		}
+		due = append(due, a)
+		a.next = a.after(now)
+	}
+	s.announcements.Unlock()
+
+	for _, a := range due {
+		s.Announce(a.message, nil, a.target)
+	}
+}
+
+func (s *Server) startAnnouncements() {
+	s.scheduleAnnouncements(Time_Current())
+	stop := make(chan struct{})
+	s.announcements.Lock()
+	s.announcements.stop = stop
+	s.announcements.Unlock()
+	go func() {
+		ticker := time.NewTicker(announcementInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.announceDue(Time_Current())
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (s *Server) stopAnnouncements() {
+	s.announcements.Lock()
+	defer s.announcements.Unlock()
+	if s.announcements.stop != nil {
+		close(s.announcements.stop)
+		s.announcements.stop = nil
+	}
+}
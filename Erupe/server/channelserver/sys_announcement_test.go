@@ -0,0 +1,159 @@
+package channelserver
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+)
+
+var testJST = time.FixedZone("UTC+9", 9*60*60)
+
+func TestCronSpecNext(t *testing.T) {
+	// Wednesday.
+	from := time.Date(2022, 6, 1, 19, 45, 30, 0, testJST)
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"0 20 * * *", time.Date(2022, 6, 1, 20, 0, 0, 0, testJST)},
+		{"45 19 * * *", time.Date(2022, 6, 2, 19, 45, 0, 0, testJST)},
+		{"*/15 * * * *", time.Date(2022, 6, 1, 20, 0, 0, 0, testJST)},
+		{"0 4 * * 0", time.Date(2022, 6, 5, 4, 0, 0, 0, testJST)},
+		{"0 4 * * 7", time.Date(2022, 6, 5, 4, 0, 0, 0, testJST)},
+		{"30 12 1-3 * *", time.Date(2022, 6, 2, 12, 30, 0, 0, testJST)},
+		{"0 0 15 * 5", time.Date(2022, 6, 3, 0, 0, 0, 0, testJST)},
+		{"0 9,21 * 7 *", time.Date(2022, 7, 1, 9, 0, 0, 0, testJST)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, testJST)},
+	}
+	for _, tt := range tests {
+		spec, err := parseCronSpec(tt.spec)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.spec, err)
+		}
+		if got := spec.next(from); !got.Equal(tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+
+	never, _ := parseCronSpec("0 0 31 2 *")
+	if got := never.next(from); !got.IsZero() {
+		t.Errorf("February 31st got %v", got)
+	}
+}
+
+func TestParseCronSpecErrors(t *testing.T) {
+	for _, spec := range []string{"", "0 20 * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "a * * * *", "* * * * 8"} {
+		if _, err := parseCronSpec(spec); err == nil {
+			t.Errorf("%q didn't fail", spec)
+		}
+	}
+}
+
+func TestNewScheduledAnnouncement(t *testing.T) {
+	start := time.Date(2022, 6, 1, 12, 0, 0, 0, testJST)
+	a, err := newScheduledAnnouncement(config.Announcement{Message: "Hi", Interval: 30}, start)
+	if err != nil || !a.next.Equal(start.Add(30*time.Minute)) {
+		t.Errorf("interval announcement got %+v, %v", a, err)
+	}
+	invalid := []config.Announcement{
+		{Interval: 30},
+		{Message: "Hi"},
+		{Message: "Hi", Cron: "0 20 * * *", Interval: 30},
+		{Message: "Hi", Cron: "0 0 31 2 *"},
+		{Message: "Hi", Cron: "every day"},
+		{Message: "Hi", Interval: 30, Target: "gr > 1; DROP TABLE characters"},
+	}
+	for _, cfg := range invalid {
+		if _, err := newScheduledAnnouncement(cfg, start); err == nil {
+			t.Errorf("%+v didn't fail", cfg)
+		}
+	}
+}
+
+func TestTargetsWorld(t *testing.T) {
+	if !targetsWorld(nil, "Newbie") {
+		t.Error("announcement without worlds isn't sent everywhere")
+	}
+	if !targetsWorld([]string{"Normal", "newbie"}, "Newbie") {
+		t.Error("announcement for the world isn't sent on it")
+	}
+	if targetsWorld([]string{"Normal"}, "Newbie") {
+		t.Error("announcement for another world is sent")
+	}
+}
+
+// announcementsSent returns how many of the packets queued for the session hold the text.
+func announcementsSent(session *Session, text string) int {
+	return strings.Count(string(sentPackets(session)), text)
+}
+
+func TestAnnounceDue(t *testing.T) {
+	s := newTestServer()
+	s.world = "Newbie"
+	s.erupeConfig.Announcements = []config.Announcement{
+		{Enabled: true, Message: "Raviente at 20:00, {online} online", Cron: "0 20 * * *"},
+		{Enabled: true, Message: "Vote for us", Interval: 60},
+		{Enabled: true, Message: "Normal only", Interval: 1, Worlds: []string{"Normal"}},
+		{Enabled: false, Message: "Disabled", Interval: 1},
+		{Enabled: true, Message: "Broken", Cron: "soon"},
+	}
+	session := newQueuedSession(t, s, 1, "Alpha")
+	newQueuedSession(t, s, 2, "Beta")
+
+	start := time.Date(2022, 6, 1, 19, 30, 0, 0, testJST)
+	s.scheduleAnnouncements(start)
+	if len(s.announcements.scheduled) != 2 {
+		t.Fatalf("got %d announcements scheduled, want the two for this world", len(s.announcements.scheduled))
+	}
+
+	s.announceDue(start.Add(29*time.Minute + 59*time.Second))
+	if sent := sentPackets(session); len(sent) != 0 {
+		t.Fatalf("sent %d bytes before anything was due", len(sent))
+	}
+
+	s.announceDue(time.Date(2022, 6, 1, 20, 0, 0, 0, testJST))
+	if n := announcementsSent(session, "Raviente at 20:00, 2 online"); n != 1 {
+		t.Errorf("got the cron announcement %d times at 20:00, want once", n)
+	}
+
+	s.announceDue(time.Date(2022, 6, 1, 20, 0, 1, 0, testJST))
+	if n := announcementsSent(session, "Raviente"); n != 0 {
+		t.Errorf("cron announcement repeated a second later")
+	}
+
+	s.announceDue(start.Add(time.Hour))
+	if n := announcementsSent(session, "Vote for us"); n != 1 {
+		t.Errorf("got the interval announcement %d times after an hour, want once", n)
+	}
+
+	// A check arriving late, such as after the process was suspended, catches up with a single send.
+	s.announceDue(time.Date(2022, 6, 3, 21, 0, 0, 0, testJST))
+	data := string(sentPackets(session))
+	if strings.Count(data, "Raviente") != 1 || strings.Count(data, "Vote for us") != 1 {
+		t.Errorf("late check didn't send each announcement once")
+	}
+	if next := s.announcements.scheduled[0].next; !next.Equal(time.Date(2022, 6, 4, 20, 0, 0, 0, testJST)) {
+		t.Errorf("cron announcement next due %v, want the next day at 20:00", next)
+	}
+	if next := s.announcements.scheduled[1].next; !next.Equal(time.Date(2022, 6, 3, 22, 0, 0, 0, testJST)) {
+		t.Errorf("interval announcement next due %v, want an hour after the late check", next)
+	}
+}
+
+func TestAnnounceTargetsWorld(t *testing.T) {
+	s := newTestServer()
+	s.world = "Newbie"
+	session := newQueuedSession(t, s, 1, "Alpha")
+
+	if s.Announce("For Normal", []string{"Normal"}, nil) {
+		t.Error("announcement for another world was sent")
+	}
+	if !s.Announce("{online} online", []string{"Newbie"}, nil) {
+		t.Error("announcement for the world wasn't sent")
+	}
+	if n := announcementsSent(session, "1 online"); n != 1 {
+		t.Errorf("got the announcement %d times, want once with the online count", n)
+	}
+}
@@ -0,0 +1,132 @@
+package channelserver
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// appearanceTTL is how long prefetched display data answers the requests for it.
+const appearanceTTL = 30 * time.Second
+
+type appearanceEntry struct {
+	minidata []byte
+	expires  time.Time
+}
+
+// appearanceCache holds the enhanced minidata of characters, the display data a client asks for one character
+// at a time as it draws the players around it. It's filled for a whole stage when a session enters it, from
+// what the members' sessions hold and one query for the rest, so the requests that follow don't each query.
+type appearanceCache struct {
+	sync.Mutex
+	entries map[uint32]appearanceEntry
+	load    func(charIDs []uint32) (map[uint32][]byte, error)
+}
+
+func newAppearanceCache(db *sqlx.DB) *appearanceCache {
+	return &appearanceCache{
+		entries: make(map[uint32]appearanceEntry),
+		load: func(charIDs []uint32) (map[uint32][]byte, error) {
+			if db == nil {
+				return nil, nil
+			}
+			return loadMinidata(db, charIDs)
+		},
+	}
+}
+
+// loadMinidata loads the minidata of the characters in a single query. Characters that don't exist are left out.
+func loadMinidata(db *sqlx.DB, charIDs []uint32) (map[uint32][]byte, error) {
+	ids := make([]int64, len(charIDs))
+	for i, id := range charIDs {
+		ids[i] = int64(id)
+	}
+	rows, err := db.Query("SELECT id, minidata FROM characters WHERE id = ANY($1)", pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	minidata := make(map[uint32][]byte, len(charIDs))
+	for rows.Next() {
+		var id uint32
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, err
+		}
+		minidata[id] = data
+	}
+	return minidata, rows.Err()
+}
+
+// putLocked caches the character's minidata for appearanceTTL. The caller holds the lock.
+func (c *appearanceCache) putLocked(charID uint32, minidata []byte, now time.Time) {
+	c.entries[charID] = appearanceEntry{minidata, now.Add(appearanceTTL)}
+}
+
+// set caches minidata the character's client just saved.
+func (c *appearanceCache) set(charID uint32, minidata []byte) {
+	c.Lock()
+	defer c.Unlock()
+	c.putLocked(charID, minidata, time.Now())
+}
+
+// minidata returns the character's minidata, from the cache while it's fresh and loaded on its own otherwise.
+func (c *appearanceCache) minidata(charID uint32) ([]byte, error) {
+	now := time.Now()
+	c.Lock()
+	entry, ok := c.entries[charID]
+	c.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.minidata, nil
+	}
+	loaded, err := c.load([]uint32{charID})
+	if err != nil {
+		return nil, err
+	}
+	data, ok := loaded[charID]
+	if !ok {
+		return nil, ErrCharacterNotFound
+	}
+	c.set(charID, data)
+	return data, nil
+}
+
+// prefetch caches the minidata of the stage's members. Sessions that hold their minidata give it as is, the
+// members without it or a fresh entry are loaded together. Expired entries are dropped along the way.
+func (c *appearanceCache) prefetch(members []*Session) error {
+	now := time.Now()
+	var missing []uint32
+	c.Lock()
+	for charID, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, charID)
+		}
+	}
+	for _, member := range members {
+		member.Lock()
+		minidata, charID := member.minidata, member.charID
+		member.Unlock()
+		if minidata != nil {
+			c.putLocked(charID, minidata, now)
+		} else if _, ok := c.entries[charID]; !ok {
+			missing = append(missing, charID)
+		}
+	}
+	c.Unlock()
+	if len(missing) == 0 {
+		return nil
+	}
+
+	loaded, err := c.load(missing)
+	if err != nil {
+		return err
+	}
+	c.Lock()
+	defer c.Unlock()
+	for charID, minidata := range loaded {
+		c.putLocked(charID, minidata, now)
+	}
+	return nil
+}
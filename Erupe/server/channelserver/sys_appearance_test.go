@@ -0,0 +1,111 @@
+package channelserver
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// countingAppearanceCache is a cache loading every character's minidata without a database, counting the queries.
+func countingAppearanceCache(queries *int) *appearanceCache {
+	cache := newAppearanceCache(nil)
+	cache.load = func(charIDs []uint32) (map[uint32][]byte, error) {
+		*queries++
+		loaded := make(map[uint32][]byte, len(charIDs))
+		for _, charID := range charIDs {
+			loaded[charID] = []byte(fmt.Sprintf("minidata %d", charID))
+		}
+		return loaded, nil
+	}
+	return cache
+}
+
+func stageMembers(t *testing.T, s *Server, count int, cached int) []*Session {
+	members := make([]*Session, count)
+	for i := range members {
+		members[i] = newQueuedSession(t, s, uint32(i+1), "hunter")
+		if i < cached {
+			members[i].minidata = []byte(fmt.Sprintf("saved %d", i+1))
+		}
+	}
+	return members
+}
+
+func TestAppearancePrefetch(t *testing.T) {
+	s := newTestServer()
+	var queries int
+	cache := countingAppearanceCache(&queries)
+	members := stageMembers(t, s, 5, 2)
+
+	if err := cache.prefetch(members); err != nil || queries != 1 {
+		t.Fatalf("prefetching 3 uncached members took %d queries, %v", queries, err)
+	}
+	for _, member := range members {
+		want := fmt.Sprintf("minidata %d", member.charID)
+		if member.minidata != nil {
+			want = string(member.minidata)
+		}
+		if data, err := cache.minidata(member.charID); err != nil || string(data) != want {
+			t.Errorf("character %d got %q, %v, want %q", member.charID, data, err, want)
+		}
+	}
+	if queries != 1 {
+		t.Errorf("requests after the prefetch took %d more queries", queries-1)
+	}
+	if err := cache.prefetch(members); err != nil || queries != 1 {
+		t.Errorf("prefetching fresh entries took %d more queries", queries-1)
+	}
+
+	// An expired entry is loaded again.
+	cache.entries[3] = appearanceEntry{[]byte("old"), time.Now().Add(-time.Second)}
+	if data, _ := cache.minidata(3); string(data) != "minidata 3" || queries != 2 {
+		t.Errorf("an expired entry got %q after %d queries", data, queries)
+	}
+	cache.set(3, []byte("new"))
+	if data, _ := cache.minidata(3); string(data) != "new" {
+		t.Errorf("saved minidata got %q", data)
+	}
+}
+
+// BenchmarkStageEntryMinidata counts the queries of a client drawing the 30 players of a hub, each asking
+// for their minidata, without and with the prefetch at entry.
+func BenchmarkStageEntryMinidata(b *testing.B) {
+	s := newTestServer()
+	members := make([]*Session, 30)
+	for i := range members {
+		serverConn, clientConn := net.Pipe()
+		defer clientConn.Close()
+		members[i] = NewSession(s, serverConn)
+		members[i].charID = uint32(i + 1)
+	}
+	for _, bench := range []struct {
+		name     string
+		prefetch bool
+		cached   int
+	}{
+		{"OneByOne", false, 0},
+		{"Prefetch", true, 0},
+		{"PrefetchCachedSessions", true, 30},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			for i, member := range members {
+				member.minidata = nil
+				if i < bench.cached {
+					member.minidata = []byte("saved")
+				}
+			}
+			var queries int
+			for n := 0; n < b.N; n++ {
+				cache := countingAppearanceCache(&queries)
+				if bench.prefetch {
+					cache.prefetch(members)
+				}
+				for _, member := range members {
+					cache.minidata(member.charID)
+				}
+			}
+			b.ReportMetric(float64(queries)/float64(b.N), "queries/op")
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package channelserver
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// A ban in force on the character with the given ID, either on its whole account or on the character alone.
+// Temporary bans lapse on their own once expires_at passes.
+const activeBanCondition = `c.user_id = b.user_id AND (b.character_id IS NULL OR b.character_id = c.id)
+	AND (b.expires_at IS NULL OR b.expires_at > now())`
+
+// characterBanned reports whether the character has a ban in force, checked when it enters the channel.
+func characterBanned(db *sqlx.DB, charID uint32) (bool, error) {
+	var banned bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM bans b JOIN characters c ON "+activeBanCondition+" WHERE c.id = $1)", charID).Scan(&banned)
+	return banned, err
+}
+
+// scheduleBanEnforcement checks for new bans every BanPollInterval and disconnects the characters they cover.
+func (s *Server) scheduleBanEnforcement() {
+	interval := time.Duration(s.erupeConfig.Channel.BanPollInterval) * time.Second
+	if interval <= 0 {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.isShuttingDown {
+		return
+	}
+	s.banEnforcementTimer = time.AfterFunc(interval, func() {
+		s.enforceBans()
+		s.scheduleBanEnforcement()
+	})
+}
+
+// stopBanEnforcement cancels the pending ban check.
+func (s *Server) stopBanEnforcement() {
+	s.Lock()
+	defer s.Unlock()
+	if s.banEnforcementTimer != nil {
+		s.banEnforcementTimer.Stop()
+	}
+}
+
+// enforceBans disconnects the connected characters covered by bans stored since the last check.
+// The first check only notes the latest ban, characters banned before then are turned away at login.
+func (s *Server) enforceBans() {
+	var latest int
+	if err := s.db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM bans").Scan(&latest); err != nil {
+		s.logger.Error("Failed to get the latest ban", zap.Error(err))
+		return
+	}
+	if !s.checkedBans {
+		s.lastBanID = latest
+		s.checkedBans = true
+		return
+	}
+	if latest <= s.lastBanID {
+		return
+	}
+
+	var charIDs []uint32
+	err := s.db.Select(&charIDs, "SELECT DISTINCT c.id FROM bans b JOIN characters c ON "+activeBanCondition+" WHERE b.id > $1 AND b.id <= $2", s.lastBanID, latest)
+	if err != nil {
+		s.logger.Error("Failed to get new bans", zap.Error(err))
+		return
+	}
+	s.lastBanID = latest
+	for _, charID := range charIDs {
+		s.disconnectCharacter(charID, DisconnectBanned)
+	}
+}
@@ -0,0 +1,140 @@
+package channelserver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Solenataris/Erupe/network"
+	"go.uber.org/zap"
+)
+
+const numPacketIDs = int(network.MSG_SYS_MATCH_FOUND) + 1
+
+// BandwidthStats counts bytes received and sent, in total and per opcode.
+// All counters are updated atomically so they can be read while the session is live.
+type BandwidthStats struct {
+	bytesIn   uint64
+	bytesOut  uint64
+	opcodeIn  [numPacketIDs]uint64
+	opcodeOut [numPacketIDs]uint64
+}
+
+// OpcodeBandwidth holds the byte counters of a single opcode.
+type OpcodeBandwidth struct {
+	Opcode network.PacketID
+	In     uint64
+	Out    uint64
+}
+
+func (b *BandwidthStats) addFrameIn(n int) {
+	atomic.AddUint64(&b.bytesIn, uint64(n))
+}
+
+func (b *BandwidthStats) addFrameOut(n int) {
+	atomic.AddUint64(&b.bytesOut, uint64(n))
+}
+
+func (b *BandwidthStats) addOpcodeIn(opcode network.PacketID, n int) {
+	if int(opcode) < numPacketIDs {
+		atomic.AddUint64(&b.opcodeIn[opcode], uint64(n))
+	}
+}
+
+func (b *BandwidthStats) addOpcodeOut(opcode network.PacketID, n int) {
+	if int(opcode) < numPacketIDs {
+		atomic.AddUint64(&b.opcodeOut[opcode], uint64(n))
+	}
+}
+
+// Totals returns the total number of bytes received and sent, including framing.
+func (b *BandwidthStats) Totals() (in uint64, out uint64) {
+	return atomic.LoadUint64(&b.bytesIn), atomic.LoadUint64(&b.bytesOut)
+}
+
+// Opcodes returns the counters of every opcode that has seen traffic, busiest first.
+func (b *BandwidthStats) Opcodes() []OpcodeBandwidth {
+	var result []OpcodeBandwidth
+	for i := 0; i < numPacketIDs; i++ {
+		in := atomic.LoadUint64(&b.opcodeIn[i])
+		out := atomic.LoadUint64(&b.opcodeOut[i])
+		if in == 0 && out == 0 {
+			continue
+		}
+		result = append(result, OpcodeBandwidth{network.PacketID(i), in, out})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].In+result[i].Out > result[j].In+result[j].Out
+	})
+	return result
+}
+
+// recordFrameIn accounts for a full frame read off the wire.
+func (s *Session) recordFrameIn(payloadLen int) {
+	n := payloadLen + network.CryptPacketHeaderLength
+	s.bandwidth.addFrameIn(n)
+	s.server.bandwidth.addFrameIn(n)
+}
+
+// recordPacketIn accounts for a single packet parsed out of a received frame.
+func (s *Session) recordPacketIn(opcode network.PacketID, n int) {
+	s.bandwidth.addOpcodeIn(opcode, n)
+	s.server.bandwidth.addOpcodeIn(opcode, n)
+}
+
+// recordFrameOut accounts for a frame written to the wire, attributing it to its leading opcode.
+func (s *Session) recordFrameOut(data []byte) {
+	n := len(data) + network.CryptPacketHeaderLength
+	s.bandwidth.addFrameOut(n)
+	s.server.bandwidth.addFrameOut(n)
+	if len(data) >= 2 {
+		opcode := network.PacketID(binary.BigEndian.Uint16(data))
+		s.bandwidth.addOpcodeOut(opcode, len(data))
+		s.server.bandwidth.addOpcodeOut(opcode, len(data))
+	}
+}
+
+// logBandwidthSummary logs the session's traffic totals and busiest opcodes.
+func (s *Session) logBandwidthSummary() {
+	in, out := s.bandwidth.Totals()
+	var top []string
+	for i, op := range s.bandwidth.Opcodes() {
+		if i == 5 {
+			break
+		}
+		top = append(top, fmt.Sprintf("%s=%d/%d", op.Opcode, op.In, op.Out))
+	}
+	s.logger.Info("Session bandwidth summary",
+		zap.Uint64("bytesIn", in),
+		zap.Uint64("bytesOut", out),
+		zap.String("topOpcodes", strings.Join(top, " ")),
+	)
+}
+
+// WriteMetrics writes the server's counters in the Prometheus text exposition format.
+func (s *Server) WriteMetrics(w io.Writer) {
+	channel := strconv.Quote(strings.TrimSpace(s.name))
+	in, out := s.bandwidth.Totals()
+
+	s.Lock()
+	sessionCount := len(s.sessions)
+	s.Unlock()
+
+	fmt.Fprintf(w, "erupe_channel_sessions{channel=%s} %d\n", channel, sessionCount)
+	fmt.Fprintf(w, "erupe_channel_bytes_in_total{channel=%s} %d\n", channel, in)
+	fmt.Fprintf(w, "erupe_channel_bytes_out_total{channel=%s} %d\n", channel, out)
+	for _, op := range s.bandwidth.Opcodes() {
+		fmt.Fprintf(w, "erupe_channel_opcode_bytes_in_total{channel=%s,opcode=\"%s\"} %d\n", channel, op.Opcode, op.In)
+		fmt.Fprintf(w, "erupe_channel_opcode_bytes_out_total{channel=%s,opcode=\"%s\"} %d\n", channel, op.Opcode, op.Out)
+	}
+	fmt.Fprintf(w, "erupe_channel_retransmits_total{channel=%s} %d\n", channel, atomic.LoadUint64(&s.retransmits))
+	s.frameLimits.writeMetrics(w, channel)
+	s.disconnects.writeMetrics(w, channel)
+	s.deferred.writeMetrics(w, channel)
+	s.writeQuestInstanceMetrics(w, channel, time.Now())
+}
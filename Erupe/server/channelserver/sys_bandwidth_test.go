@@ -0,0 +1,63 @@
+package channelserver
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Solenataris/Erupe/network"
+)
+
+func TestBandwidthAccounting(t *testing.T) {
+	s := newTestServer()
+	s.name = "Test Channel"
+	session := newQueuedSession(t, s, 1, "Hunter")
+	other := newQueuedSession(t, s, 2, "Quiet")
+
+	// A frame of two NOPs and its end, the way the receive loop accounts for it.
+	frame := []byte{0x00, byte(network.MSG_SYS_NOP), 0x00, byte(network.MSG_SYS_NOP), 0x00, 0x10}
+	session.recordFrameIn(len(frame))
+	session.handlePacketGroup(frame)
+	session.recordFrameOut([]byte{0x00, byte(network.MSG_SYS_PING), 1, 2, 3, 4, 0x00, 0x10})
+
+	in, out := session.bandwidth.Totals()
+	if in != uint64(len(frame)+network.CryptPacketHeaderLength) || out != 8+network.CryptPacketHeaderLength {
+		t.Errorf("session counted %d bytes in and %d out", in, out)
+	}
+	if serverIn, serverOut := s.bandwidth.Totals(); serverIn != in || serverOut != out {
+		t.Errorf("server counted %d bytes in and %d out, the session %d and %d", serverIn, serverOut, in, out)
+	}
+	if in, out := other.bandwidth.Totals(); in != 0 || out != 0 {
+		t.Errorf("an idle session counted %d bytes in and %d out", in, out)
+	}
+
+	opcodes := session.bandwidth.Opcodes()
+	if len(opcodes) != 3 || opcodes[0].Opcode != network.MSG_SYS_PING || opcodes[0].Out != 8 {
+		t.Fatalf("got opcode counters %+v", opcodes)
+	}
+	if opcodes[1].Opcode != network.MSG_SYS_NOP || opcodes[1].In != 4 || opcodes[1].Out != 0 {
+		t.Errorf("two NOPs counted as %+v", opcodes[1])
+	}
+	if opcodes[2].Opcode != network.MSG_SYS_END || opcodes[2].In != 2 {
+		t.Errorf("the frame's end counted as %+v", opcodes[2])
+	}
+
+	var metrics bytes.Buffer
+	s.WriteMetrics(&metrics)
+	for _, line := range []string{
+		`erupe_channel_sessions{channel="Test Channel"} 2`,
+		fmt.Sprintf(`erupe_channel_bytes_in_total{channel="Test Channel"} %d`, in),
+		`erupe_channel_opcode_bytes_in_total{channel="Test Channel",opcode="MSG_SYS_NOP"} 4`,
+		`erupe_channel_opcode_bytes_out_total{channel="Test Channel",opcode="MSG_SYS_PING"} 8`,
+	} {
+		if !strings.Contains(metrics.String(), line+"\n") {
+			t.Errorf("metrics are missing %q:\n%s", line, metrics.String())
+		}
+	}
+
+	list := bandwidthList(s)
+	if strings.Index(list, "Hunter") < 0 || strings.Index(list, "Hunter") > strings.Index(list, "Quiet") {
+		t.Errorf("the busiest session isn't listed first:\n%s", list)
+	}
+}
@@ -0,0 +1,68 @@
+package channelserver
+
+import (
+	"go.uber.org/zap"
+)
+
+// loadBlocklist caches the characters the session's character has blacklisted,
+// so room and quest joins can be checked without a DB query.
+func loadBlocklist(s *Session) {
+	var blockedIDs []uint32
+	err := s.server.db.Select(&blockedIDs, "SELECT blocked_id FROM blocked_characters WHERE character_id = $1", s.charID)
+	if err != nil {
+		s.logger.Error("Failed to get blocklist", zap.Error(err))
+		return
+	}
+
+	blocked := make(map[uint32]bool, len(blockedIDs))
+	for _, charID := range blockedIDs {
+		blocked[charID] = true
+	}
+	s.Lock()
+	s.blocked = blocked
+	s.Unlock()
+}
+
+// setBlocked adds or removes a character from the session's blocklist.
+func setBlocked(s *Session, charID uint32, blocked bool) error {
+	var err error
+	if blocked {
+		_, err = s.server.db.Exec("INSERT INTO blocked_characters (character_id, blocked_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", s.charID, charID)
+	} else {
+		_, err = s.server.db.Exec("DELETE FROM blocked_characters WHERE character_id = $1 AND blocked_id = $2", s.charID, charID)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if s.blocked == nil {
+		s.blocked = make(map[uint32]bool)
+	}
+	if blocked {
+		s.blocked[charID] = true
+	} else {
+		delete(s.blocked, charID)
+	}
+	return nil
+}
+
+// isBlocking reports whether the session's character has blocked the character.
+func (s *Session) isBlocking(charID uint32) bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.blocked[charID]
+}
+
+// blockedFromStage reports whether the stage's host has blocked the character, which keeps
+// it out of the host's rooms and quests. Rooms with a password are left to the host.
+func (s *Server) blockedFromStage(stage *Stage, charID uint32) bool {
+	if !s.erupeConfig.Channel.BlocklistEnforcement {
+		return false
+	}
+	stage.RLock()
+	host, password := stage.host, stage.password
+	stage.RUnlock()
+	return host != nil && password == "" && host.isBlocking(charID)
+}
@@ -0,0 +1,63 @@
+package channelserver
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+)
+
+func newBlocklistTestSession(t *testing.T, s *Server, charID uint32) *Session {
+	session, client := newTestSession(s)
+	session.charID = charID
+	go io.Copy(ioutil.Discard, client)
+	t.Cleanup(func() { client.Close() })
+	return session
+}
+
+func TestBlockedPlayerKeptOutOfHostedStage(t *testing.T) {
+	s := newTestServer()
+	s.erupeConfig.Channel.BlocklistEnforcement = true
+	stageID := "sl1Qs999p0a0u999"
+
+	host := newBlocklistTestSession(t, s, 1)
+	host.blocked = map[uint32]bool{2: true}
+	blocked := newBlocklistTestSession(t, s, 2)
+	other := newBlocklistTestSession(t, s, 3)
+	handleMsgSysCreateStage(host, &mhfpacket.MsgSysCreateStage{StageID: stageID, PlayerCount: 4})
+	stage, _ := s.GetStage(stageID)
+
+	handleMsgSysReserveStage(blocked, &mhfpacket.MsgSysReserveStage{StageID: stageID})
+	handleMsgSysReserveStage(other, &mhfpacket.MsgSysReserveStage{StageID: stageID})
+	handleMsgSysEnterStage(blocked, &mhfpacket.MsgSysEnterStage{StageID: stageID})
+	handleMsgSysEnterStage(other, &mhfpacket.MsgSysEnterStage{StageID: stageID})
+
+	stage.RLock()
+	_, blockedReserved := stage.reservedClientSlots[blocked.charID]
+	_, blockedEntered := stage.clients[blocked]
+	_, otherEntered := stage.clients[other]
+	stage.RUnlock()
+	if blockedReserved || blockedEntered {
+		t.Error("blocked player joined the host's quest")
+	}
+	if !otherEntered {
+		t.Error("player that isn't blocked couldn't join the host's quest")
+	}
+
+	// The host handed out the password, so the block no longer applies.
+	stage.Lock()
+	stage.password = "1234"
+	stage.Unlock()
+	if s.blockedFromStage(stage, blocked.charID) {
+		t.Error("blocked player kept out of a room with a password")
+	}
+
+	stage.Lock()
+	stage.password = ""
+	stage.Unlock()
+	s.erupeConfig.Channel.BlocklistEnforcement = false
+	if s.blockedFromStage(stage, blocked.charID) {
+		t.Error("blocked player kept out with enforcement disabled")
+	}
+}
@@ -0,0 +1,161 @@
+package channelserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Solenataris/Erupe/common/distpayload"
+	"github.com/Solenataris/Erupe/common/itemtable"
+	"github.com/Solenataris/Erupe/server/invite"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	// ErrCampaignCodeInvalid is returned for a code that doesn't exist, is outside its window or has no uses left.
+	ErrCampaignCodeInvalid = errors.New("invalid campaign code")
+	// ErrCampaignCodeRedeemed is returned when the account redeemed the code as often as it can.
+	ErrCampaignCodeRedeemed = errors.New("campaign code already redeemed")
+	// ErrCampaignCodeExists is returned when creating a code that's already taken.
+	ErrCampaignCodeExists = errors.New("campaign code already exists")
+)
+
+// maxCampaignCodeLength is the longest code the campaign menu sends, short of its field's terminator.
+const maxCampaignCodeLength = 15
+
+// CampaignItem is an item of a campaign code's bundle.
+type CampaignItem struct {
+	ItemID   uint16 `json:"itemID"`
+	Quantity uint16 `json:"quantity"`
+}
+
+// CampaignCode is a code players type in the campaign menu for a bundle of items.
+type CampaignCode struct {
+	Code         string         `json:"code"` // Letters and digits, generated if left empty.
+	Items        []CampaignItem `json:"items"`
+	MaxUses      int            `json:"maxUses"`      // Redemptions of everyone together, 0 for no cap.
+	AccountLimit int            `json:"accountLimit"` // Redemptions of each account, 1 if left out.
+	StartsAt     *time.Time     `json:"startsAt"`     // Nil opens the window straight away.
+	EndsAt       *time.Time     `json:"endsAt"`       // Nil never closes it.
+}
+
+// ValidateCampaignCode checks a code before it's created: the code picked for it, if any, has to be letters
+// and digits the campaign menu can send, and its items are checked as any other grant is.
+func ValidateCampaignCode(items *itemtable.Table, maxQuantity int, code CampaignCode) error {
+	if len(code.Code) > maxCampaignCodeLength {
+		return fmt.Errorf("a code is at most %d letters", maxCampaignCodeLength)
+	}
+	for _, c := range code.Code {
+		if (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return errors.New("a code is letters and digits only")
+		}
+	}
+	if len(code.Items) == 0 || len(code.Items) > distpayload.MaxItems {
+		return fmt.Errorf("a code needs 1 to %d items", distpayload.MaxItems)
+	}
+	for _, item := range code.Items {
+		if err := ValidateItemGrant(items, maxQuantity, int(item.ItemID), int(item.Quantity)); err != nil {
+			return err
+		}
+	}
+	if code.MaxUses < 0 || code.AccountLimit < 0 {
+		return errors.New("uses can't be negative")
+	}
+	if code.StartsAt != nil && code.EndsAt != nil && !code.EndsAt.After(*code.StartsAt) {
+		return errors.New("a code has to end after it starts")
+	}
+	return nil
+}
+
+// CreateCampaignCode stores the code, generating one if it has none, and returns it.
+func CreateCampaignCode(db *sqlx.DB, code CampaignCode) (string, error) {
+	name := strings.ToUpper(code.Code)
+	if name == "" {
+		var err error
+		if name, err = invite.NewCode(10); err != nil {
+			return "", err
+		}
+	}
+	if code.AccountLimit == 0 {
+		code.AccountLimit = 1
+	}
+	var maxUses interface{}
+	if code.MaxUses > 0 {
+		maxUses = code.MaxUses
+	}
+	items, err := json.Marshal(code.Items)
+	if err != nil {
+		return "", err
+	}
+	res, err := db.Exec(`
+		INSERT INTO campaign_codes (code, items, max_uses, account_limit, starts_at, ends_at)
+		VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (code) DO NOTHING
+	`, name, items, maxUses, code.AccountLimit, code.StartsAt, code.EndsAt)
+	if err != nil {
+		return "", err
+	}
+	if rows, err := res.RowsAffected(); err != nil {
+		return "", err
+	} else if rows != 1 {
+		return "", ErrCampaignCodeExists
+	}
+	return name, nil
+}
+
+// redeemCampaignCode redeems the code for the character and queues its bundle as a distribution for it, in one
+// transaction. Counting the use locks the code's row until the redemption is recorded, so neither the cap nor
+// the account's limit is passed however many redemptions race for it.
+func redeemCampaignCode(db *sqlx.DB, charID uint32, code string) ([]CampaignItem, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var accountLimit int
+	var data []byte
+	err = tx.QueryRow(`
+		UPDATE campaign_codes SET uses = uses + 1
+		WHERE code = $1 AND (max_uses IS NULL OR uses < max_uses)
+		AND (starts_at IS NULL OR starts_at <= now()) AND (ends_at IS NULL OR ends_at > now())
+		RETURNING account_limit, items
+	`, code).Scan(&accountLimit, &data)
+	if err == sql.ErrNoRows {
+		return nil, ErrCampaignCodeInvalid
+	} else if err != nil {
+		return nil, err
+	}
+	var userID uint32
+	if err = tx.QueryRow("SELECT user_id FROM characters WHERE id = $1", charID).Scan(&userID); err != nil {
+		return nil, err
+	}
+	var redeemed int
+	err = tx.QueryRow("SELECT count(*) FROM campaign_redemptions WHERE code = $1 AND user_id = $2", code, userID).Scan(&redeemed)
+	if err != nil {
+		return nil, err
+	}
+	if redeemed >= accountLimit {
+		return nil, ErrCampaignCodeRedeemed
+	}
+
+	var items []CampaignItem
+	if err = json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("campaign code %s: %w", code, err)
+	}
+	entries := make([]DistItemEntry, len(items))
+	for i, item := range items {
+		entries[i] = DistItemEntry{distpayload.TypeItem, item.ItemID, item.Quantity}
+	}
+	if err = createCharacterDistribution(tx, charID, "Campaign", "~C05Redeemed with the code "+code+".", entries); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec("INSERT INTO campaign_redemptions (code, user_id, character_id) VALUES ($1, $2, $3)", code, userID, charID)
+	if err != nil {
+		return nil, err
+	}
+	return items, tx.Commit()
+}
@@ -0,0 +1,179 @@
+package channelserver
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/common/bfutil"
+	"github.com/Solenataris/Erupe/common/fixtures"
+	"github.com/Solenataris/Erupe/common/testdb"
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+)
+
+// capturedCampaignApplyDir holds MSG_MHF_APPLY_CAMPAIGN packets of clients entering a code in the campaign
+// menu, one fixture each from its opcode on, cut from the session captures of PacketCapture.
+const capturedCampaignApplyDir = "channelserver/apply_campaign"
+
+// parseApplyCampaign parses a client's MSG_MHF_APPLY_CAMPAIGN packet from its opcode on, as the session's
+// packet loop does, and returns it with the bytes left after it.
+func parseApplyCampaign(t *testing.T, session *Session, data []byte) (*mhfpacket.MsgMhfApplyCampaign, int) {
+	t.Helper()
+	bf := byteframe.NewByteFrameFromBytes(data)
+	if opcode := network.PacketID(bf.ReadUint16()); opcode != network.MSG_MHF_APPLY_CAMPAIGN {
+		t.Fatalf("packet has opcode %s", opcode)
+	}
+	pkt := mhfpacket.FromOpcode(network.MSG_MHF_APPLY_CAMPAIGN)
+	if err := pkt.Parse(bf, session.clientContext); err != nil {
+		t.Fatal(err)
+	}
+	return pkt.(*mhfpacket.MsgMhfApplyCampaign), bfutil.Remaining(bf)
+}
+
+func TestParseApplyCampaign(t *testing.T) {
+	s := newTestServer()
+	session := newQueuedSession(t, s, 100, "Hunter")
+	// The fields known before the codes, then the code's field and the end of the frame.
+	bf := byteframe.NewByteFrame()
+	bf.WriteUint16(uint16(network.MSG_MHF_APPLY_CAMPAIGN))
+	bf.WriteUint32(0x00C0FFEE)
+	bf.WriteBytes([]byte{0x01, 0x02, 0x00, 0x03})
+	bf.WriteBytes(append([]byte("SUMMER2024"), make([]byte, 6)...))
+	bf.WriteUint16(0x0010)
+
+	pkt, left := parseApplyCampaign(t, session, bf.Data())
+	if pkt.AckHandle != 0x00C0FFEE || pkt.Unk0 != 1 || pkt.Unk1 != 2 || pkt.Unk2 != 3 || pkt.Code != "SUMMER2024" || left != 2 {
+		t.Errorf("parsed %+v with %d bytes left", pkt, left)
+	}
+
+	rebuilt := byteframe.NewByteFrame()
+	rebuilt.WriteUint16(uint16(network.MSG_MHF_APPLY_CAMPAIGN))
+	pkt.Build(rebuilt, session.clientContext)
+	rebuilt.WriteUint16(0x0010)
+	if string(rebuilt.Data()) != string(bf.Data()) {
+		t.Errorf("built %x, want %x", rebuilt.Data(), bf.Data())
+	}
+}
+
+func TestCapturedCampaignApplies(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join(fixtures.Dir(), capturedCampaignApplyDir, "*"+fixtures.Extension))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Skipf("no captured campaign applies in fixtures/%s", capturedCampaignApplyDir)
+	}
+	s := newTestServer()
+	session := newQueuedSession(t, s, 100, "Hunter")
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), fixtures.Extension)
+		data, err := fixtures.Load(capturedCampaignApplyDir + "/" + name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		// The packet takes up the capture up to the frame's end, if the capture has one.
+		pkt, left := parseApplyCampaign(t, session, data)
+		if pkt.Code == "" || (left != 0 && left != 2) {
+			t.Errorf("%s: parsed code %q with %d bytes left", name, pkt.Code, left)
+		}
+	}
+}
+
+// TestRedeemCampaignCode runs against the database in ERUPE_TEST_DB, like TestDrawGacha.
+func TestRedeemCampaignCode(t *testing.T) {
+	db := testdb.Open(t)
+
+	// Two accounts, the first with two characters.
+	var chars []uint32
+	for i, username := range []string{"campaign_test", "campaign_test2"} {
+		var userID uint32
+		err := db.QueryRow("INSERT INTO users (username, password) VALUES ($1, '') RETURNING id", username).Scan(&userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+		for n := 0; n < 2-i; n++ {
+			var charID uint32
+			err = db.QueryRow("INSERT INTO characters (user_id, is_new_character, name) VALUES ($1, false, 'campaign') RETURNING id", userID).Scan(&charID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Exec("DELETE FROM distribution WHERE character_id = $1", charID)
+			defer db.Exec("DELETE FROM characters WHERE id = $1", charID)
+			chars = append(chars, charID)
+		}
+	}
+	create := func(code CampaignCode) string {
+		code.Items = []CampaignItem{{ItemID: 1, Quantity: 3}}
+		name, err := CreateCampaignCode(db, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Exec("DELETE FROM campaign_codes WHERE code = $1", name) })
+		return name
+	}
+
+	// Once per account: the account's second character is refused, the other account isn't.
+	code := create(CampaignCode{Code: "CampaignTest1"})
+	if code != "CAMPAIGNTEST1" {
+		t.Errorf("code stored as %q", code)
+	}
+	if items, err := redeemCampaignCode(db, chars[0], "campaigntest1"); err != nil || len(items) != 1 || items[0].Quantity != 3 {
+		t.Fatalf("redeeming got %+v, %v", items, err)
+	}
+	if _, err := redeemCampaignCode(db, chars[1], code); err != ErrCampaignCodeRedeemed {
+		t.Errorf("an account's second redemption got %v, want %v", err, ErrCampaignCodeRedeemed)
+	}
+	if _, err := redeemCampaignCode(db, chars[2], code); err != nil {
+		t.Errorf("another account couldn't redeem: %v", err)
+	}
+	var dists int
+	db.QueryRow("SELECT count(*) FROM distribution WHERE character_id IN ($1, $2, $3)", chars[0], chars[1], chars[2]).Scan(&dists)
+	if dists != 2 {
+		t.Errorf("2 redemptions queued %d distributions", dists)
+	}
+	if _, err := CreateCampaignCode(db, CampaignCode{Code: code, Items: []CampaignItem{{1, 1}}}); err != ErrCampaignCodeExists {
+		t.Errorf("recreating the code got %v, want %v", err, ErrCampaignCodeExists)
+	}
+
+	// A code of 3 uses that many accounts race for is redeemed 3 times.
+	capped := create(CampaignCode{MaxUses: 3, AccountLimit: 100})
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	redeemed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(charID uint32) {
+			defer wg.Done()
+			_, err := redeemCampaignCode(db, charID, capped)
+			if err != nil && err != ErrCampaignCodeInvalid {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			if err == nil {
+				redeemed++
+			}
+			mu.Unlock()
+		}(chars[i%len(chars)])
+	}
+	wg.Wait()
+	if redeemed != 3 {
+		t.Errorf("a code of 3 uses was redeemed %d times", redeemed)
+	}
+
+	// Outside its window a code can't be redeemed.
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	for _, window := range []CampaignCode{{EndsAt: &past}, {StartsAt: &future}} {
+		if _, err := redeemCampaignCode(db, chars[0], create(window)); err != ErrCampaignCodeInvalid {
+			t.Errorf("a code outside its window got %v, want %v", err, ErrCampaignCodeInvalid)
+		}
+	}
+	if _, err := redeemCampaignCode(db, chars[0], "NOSUCHCODE"); err != ErrCampaignCodeInvalid {
+		t.Errorf("an unknown code got %v, want %v", err, ErrCampaignCodeInvalid)
+	}
+}
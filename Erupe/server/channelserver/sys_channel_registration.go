@@ -0,0 +1,188 @@
+package channelserver
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// How often a draining channel asks its players to move to another channel.
+const drainNudgeInterval = time.Minute
+
+// drainMessage is the chat message players on a draining channel get.
+const drainMessage = "This channel is closing, please move to another channel. Your progress is saved either way."
+
+// RegisteredChannel is a channel's row in the server_channels table, see Server.Register.
+type RegisteredChannel struct {
+	ID          int       `db:"id" json:"id"`
+	World       string    `db:"world" json:"world"`
+	Address     string    `db:"address" json:"address"`
+	Port        uint16    `db:"port" json:"port"`
+	MaxPlayers  uint16    `db:"max_players" json:"maxPlayers"`
+	Players     uint16    `db:"current_players" json:"players"`
+	Draining    bool      `db:"draining" json:"draining"`
+	HeartbeatAt time.Time `db:"heartbeat_at" json:"heartbeatAt"`
+}
+
+// RegisteredChannels returns the registered channels with a heartbeat within the TTL, or every one for 0.
+func RegisteredChannels(db *sqlx.DB, ttl time.Duration) ([]RegisteredChannel, error) {
+	channels := []RegisteredChannel{}
+	query := "SELECT id, world, address, port, max_players, current_players, draining, heartbeat_at FROM server_channels"
+	var err error
+	if ttl > 0 {
+		err = db.Select(&channels, query+" WHERE heartbeat_at > now() - $1 * interval '1 second' ORDER BY id", ttl.Seconds())
+	} else {
+		err = db.Select(&channels, query+" ORDER BY id")
+	}
+	return channels, err
+}
+
+// DrainChannel asks the registered channel to empty, reporting whether it's registered. It's dropped
+// from the entrance list at once and picks the request up with its next heartbeat.
+func DrainChannel(db *sqlx.DB, id int) (bool, error) {
+	res, err := db.Exec("UPDATE server_channels SET draining = true WHERE id = $1", id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	return rows == 1, err
+}
+
+// channelRegistration is the channel's row in the server_channels table while it's registered.
+type channelRegistration struct {
+	id         int
+	address    string
+	port       uint16
+	maxPlayers uint16
+	timer      *time.Timer
+	draining   bool
+	lastNudge  time.Time
+	drained    chan struct{} // Closed once the channel emptied and deregistered.
+}
+
+// Register adds the channel to the server_channels table under the given address and keeps its row alive
+// with a heartbeat, so entrance servers sharing the DB list it. A channel asked to drain stops taking
+// players, asks those left to move and deregisters once it's empty.
+func (s *Server) Register(address string, port uint16, maxPlayers uint16) error {
+	r := &channelRegistration{address: address, port: port, maxPlayers: maxPlayers, drained: make(chan struct{})}
+	if err := s.insertRegistration(r); err != nil {
+		return err
+	}
+	s.Lock()
+	s.registration = r
+	s.Unlock()
+	s.logger.Info("Registered channel", zap.Int("id", r.id), zap.String("address", address), zap.Uint16("port", port))
+	s.scheduleHeartbeat()
+	return nil
+}
+
+// insertRegistration writes the channel's row, taking over a stale one left at the same address.
+func (s *Server) insertRegistration(r *channelRegistration) error {
+	return s.db.QueryRow(`
+		INSERT INTO server_channels (world, address, port, max_players) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (address, port) DO UPDATE SET world = $1, max_players = $4, current_players = 0, draining = false, heartbeat_at = now()
+		RETURNING id
+	`, s.world, r.address, r.port, r.maxPlayers).Scan(&r.id)
+}
+
+// Drained is closed once the registered channel was drained and deregistered, it's never closed otherwise.
+func (s *Server) Drained() <-chan struct{} {
+	s.Lock()
+	defer s.Unlock()
+	if s.registration == nil {
+		return nil
+	}
+	return s.registration.drained
+}
+
+// isDraining reports whether the channel was asked to drain, which turns new logins away.
+func (s *Server) isDraining() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.registration != nil && s.registration.draining
+}
+
+func (s *Server) scheduleHeartbeat() {
+	interval := time.Duration(s.erupeConfig.Channel.HeartbeatInterval) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.isShuttingDown || s.registration == nil {
+		return
+	}
+	s.registration.timer = time.AfterFunc(interval, func() {
+		if s.heartbeat() {
+			s.scheduleHeartbeat()
+		}
+	})
+}
+
+// heartbeat publishes the player count and picks up a drain request, reporting whether the channel is
+// still registered.
+func (s *Server) heartbeat() bool {
+	s.Lock()
+	r := s.registration
+	s.Unlock()
+	if r == nil {
+		return false
+	}
+
+	players := s.PlayerCount()
+	var draining bool
+	err := s.db.QueryRow("UPDATE server_channels SET current_players = $1, heartbeat_at = now() WHERE id = $2 RETURNING draining", players, r.id).Scan(&draining)
+	if err == sql.ErrNoRows {
+		// Deleted from under the channel, such as by a DB cleanup, it registers again.
+		s.logger.Warn("Channel registration was removed, registering again", zap.Int("id", r.id))
+		err = s.insertRegistration(r)
+	}
+	if err != nil {
+		s.logger.Error("Failed to update channel registration", zap.Error(err))
+		return true
+	}
+	if !draining {
+		return true
+	}
+
+	s.Lock()
+	first := !r.draining
+	r.draining = true
+	nudge := time.Since(r.lastNudge) >= drainNudgeInterval
+	if nudge {
+		r.lastNudge = time.Now()
+	}
+	s.Unlock()
+	if first {
+		s.logger.Info("Draining channel", zap.Int("id", r.id), zap.Int("players", players))
+	}
+	if players > 0 {
+		if nudge {
+			s.BroadcastChatMessage(drainMessage)
+		}
+		return true
+	}
+
+	s.deregister()
+	s.logger.Info("Drained channel", zap.Int("id", r.id))
+	close(r.drained)
+	return false
+}
+
+// deregister deletes the channel's row and stops its heartbeat.
+func (s *Server) deregister() {
+	s.Lock()
+	r := s.registration
+	if r != nil && r.timer != nil {
+		r.timer.Stop()
+	}
+	s.Unlock()
+	if r == nil {
+		return
+	}
+	if _, err := s.db.Exec("DELETE FROM server_channels WHERE id = $1", r.id); err != nil {
+		s.logger.Error("Failed to deregister channel", zap.Int("id", r.id), zap.Error(err))
+	}
+}
@@ -0,0 +1,84 @@
+package channelserver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Solenataris/Erupe/common/testdb"
+	"github.com/Solenataris/Erupe/config"
+	"go.uber.org/zap"
+)
+
+// TestChannelDrain runs against the database in ERUPE_TEST_DB, e.g.
+// "host=localhost user=postgres password=admin dbname=erupe_test sslmode=disable".
+// A second channel registers next to a running one and is drained while a player is still on it.
+func TestChannelDrain(t *testing.T) {
+	db := testdb.Open(t)
+	defer db.Exec("DELETE FROM server_channels WHERE address = '198.51.100.7'")
+
+	erupeConfig := &config.Config{Channel: config.Channel{HeartbeatInterval: 3600}}
+	newChannel := func(port uint16) *Server {
+		s := NewServer(&Config{Logger: zap.NewNop(), DB: db, ErupeConfig: erupeConfig, World: "Autoscaled"})
+		if err := s.Register("198.51.100.7", port, 100); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(s.deregister)
+		return s
+	}
+	running := newChannel(54101)
+	spun := newChannel(54102)
+
+	registered, err := RegisteredChannels(db, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spunID int
+	found := 0
+	for _, channel := range registered {
+		if channel.Address == "198.51.100.7" {
+			found++
+			if channel.Port == 54102 {
+				spunID = channel.ID
+			}
+		}
+	}
+	if found != 2 || spunID == 0 {
+		t.Fatalf("got %+v registered, want both channels", registered)
+	}
+
+	player := newQueuedSession(t, spun, 1, "Alpha")
+	if ok, err := DrainChannel(db, spunID); err != nil || !ok {
+		t.Fatalf("drain got %v, %v", ok, err)
+	}
+	if !spun.heartbeat() {
+		t.Fatal("the channel deregistered with a player still on it")
+	}
+	if !spun.isDraining() || running.isDraining() {
+		t.Errorf("draining: spun channel %v, running channel %v, want only the spun one", spun.isDraining(), running.isDraining())
+	}
+	if sent := string(sentPackets(player)); !strings.Contains(sent, drainMessage) {
+		t.Errorf("the player wasn't asked to move, got %q", sent)
+	}
+
+	spun.Lock()
+	for conn := range spun.sessions {
+		delete(spun.sessions, conn)
+	}
+	spun.Unlock()
+	if spun.heartbeat() {
+		t.Fatal("the empty channel stayed registered")
+	}
+	select {
+	case <-spun.Drained():
+	default:
+		t.Error("Drained wasn't closed")
+	}
+	var left int
+	db.QueryRow("SELECT COUNT(*) FROM server_channels WHERE address = '198.51.100.7'").Scan(&left)
+	if left != 1 {
+		t.Errorf("%d channels registered after the drain, want the running one", left)
+	}
+	if !running.heartbeat() {
+		t.Error("the running channel lost its registration")
+	}
+}
@@ -0,0 +1,47 @@
+package channelserver
+
+import "sync"
+
+// ChannelRegistry holds every channel server running in the process, so a lookup can reach
+// characters on any of them. Each channel keeps its own stages, only sessions are shared.
+type ChannelRegistry struct {
+	sync.RWMutex
+	servers []*Server
+}
+
+// NewChannelRegistry creates an empty registry, channels join it through Config.Registry.
+func NewChannelRegistry() *ChannelRegistry {
+	return &ChannelRegistry{}
+}
+
+func (r *ChannelRegistry) add(s *Server) {
+	r.Lock()
+	defer r.Unlock()
+	r.servers = append(r.servers, s)
+}
+
+// Servers returns a copy of the registered channels in the order they were created.
+func (r *ChannelRegistry) Servers() []*Server {
+	r.RLock()
+	defer r.RUnlock()
+	return append([]*Server(nil), r.servers...)
+}
+
+// FindSessionByCharID looks for the character on every registered channel.
+func (r *ChannelRegistry) FindSessionByCharID(charID uint32) *Session {
+	for _, server := range r.Servers() {
+		if session := server.FindSessionByCharID(charID); session != nil {
+			return session
+		}
+	}
+	return nil
+}
+
+// findSessionOnAnyChannel looks for the character on every channel of the process,
+// or only on this one when it isn't part of a registry.
+func (s *Server) findSessionOnAnyChannel(charID uint32) *Session {
+	if s.registry == nil {
+		return s.FindSessionByCharID(charID)
+	}
+	return s.registry.FindSessionByCharID(charID)
+}
@@ -0,0 +1,61 @@
+package channelserver
+
+import (
+	"testing"
+
+	"github.com/Solenataris/Erupe/config"
+	"go.uber.org/zap"
+)
+
+func TestChannelRegistryFindsCharacterOnOtherChannel(t *testing.T) {
+	registry := NewChannelRegistry()
+	var channels []*Server
+	for _, name := range []string{"World #1", "World #1", "World #2", "World #2"} {
+		channels = append(channels, NewServer(&Config{
+			Logger:      zap.NewNop(),
+			ErupeConfig: &config.Config{},
+			Name:        name,
+			Registry:    registry,
+		}))
+	}
+	if got := len(registry.Servers()); got != 4 {
+		t.Fatalf("registry holds %d channels, want 4", got)
+	}
+
+	session, _ := newTestSession(channels[3])
+	session.charID = 1234
+	lobby, _ := channels[3].GetOrCreateStage("sl1Ns200p0a0u0")
+	lobby.Lock()
+	lobby.clients[session] = session.charID
+	lobby.Unlock()
+
+	if channels[0].FindSessionByCharID(1234) != nil {
+		t.Error("the character was found on a channel it isn't on")
+	}
+	if got := channels[0].findSessionOnAnyChannel(1234); got != session {
+		t.Errorf("found %v through the registry, want the session on the fourth channel", got)
+	}
+	if got := channels[0].findSessionOnAnyChannel(5678); got != nil {
+		t.Errorf("found %v for a character that isn't online", got)
+	}
+
+	// Stages stay per channel.
+	channels[0].GetOrCreateStage("sl1Qs000p0a0u0")
+	if _, ok := channels[1].GetStage("sl1Qs000p0a0u0"); ok {
+		t.Error("a stage created on one channel showed up on another")
+	}
+}
+
+func TestFindSessionOnAnyChannelWithoutRegistry(t *testing.T) {
+	s := newTestServer()
+	session, _ := newTestSession(s)
+	session.charID = 1234
+	lobby, _ := s.GetOrCreateStage("sl1Ns200p0a0u0")
+	lobby.Lock()
+	lobby.clients[session] = session.charID
+	lobby.Unlock()
+
+	if got := s.findSessionOnAnyChannel(1234); got != session {
+		t.Errorf("found %v, want the session on the channel itself", got)
+	}
+}
@@ -1,12 +1,19 @@
 package channelserver
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/common/itemtable"
+	"github.com/Solenataris/Erupe/common/tracing"
 	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/network"
 	"github.com/Solenataris/Erupe/network/binpacket"
 	"github.com/Solenataris/Erupe/network/mhfpacket"
 	"github.com/Solenataris/Erupe/server/discordbot"
@@ -33,12 +40,21 @@ const (
 
 // Config struct allows configuring the server.
 type Config struct {
-	Logger      *zap.Logger
-	DB          *sqlx.DB
-	DiscordBot  *discordbot.DiscordBot
-	ErupeConfig *config.Config
-	Name        string
-	Enable      bool
+	Logger       *zap.Logger
+	PacketLogger *zap.Logger // Logs the packet dumps of DevModeOptions, nil for Logger.
+	DB           *sqlx.DB
+	DiscordBot   *discordbot.DiscordBot
+	ErupeConfig  *config.Config
+	Name         string
+	World        string // Name of the world the channel belongs to, announcements can target it.
+	Index        int    // Index of the channel in its world's entrance entry.
+	Enable       bool
+	Registry     *ChannelRegistry // The channels of the process to reach characters on, nil for just this one.
+	ChatBus      ChatBus          // Relays guild, alliance and world chat between channels, nil keeps chat on this one.
+	Items        *itemtable.Table // Items admins can give and item box updates are checked against, nil allows any plausible item ID.
+	Tracer       *tracing.Tracer  // Traces logins, nil when tracing is off.
+
+	DefaultStage string // Stage players go back to when their previous stage can't be known, Mezeporta if empty.
 }
 
 // Map key type for a user binary part.
@@ -51,6 +67,7 @@ type userBinaryPartID struct {
 type Server struct {
 	sync.Mutex
 	logger         *zap.Logger
+	packetLogger   *zap.Logger
 	db             *sqlx.DB
 	erupeConfig    *config.Config
 	acceptConns    chan net.Conn
@@ -59,13 +76,19 @@ type Server struct {
 	listener       net.Listener // Listener that is created when Server.Start is called.
 	isShuttingDown bool
 
-	stagesLock sync.RWMutex
+	// Held for reading by every save in flight, so shutdown can wait for them to commit.
+	savesLock sync.RWMutex
+
+	stagesLock stagesRWMutex
 	stages     map[string]*Stage
 
 	// UserBinary
 	userBinaryPartsLock sync.RWMutex
 	userBinaryParts     map[userBinaryPartID][]byte
 
+	// Display data prefetched at stage entry.
+	appearance *appearanceCache
+
 	// Semaphore
 	semaphoreLock sync.RWMutex
 	semaphore     map[string]*Semaphore
@@ -73,10 +96,96 @@ type Server struct {
 	// Discord chat integration
 	discordBot *discordbot.DiscordBot
 
-	name   string
-	enable bool
+	name         string
+	world        string
+	index        int
+	enable       bool
+	defaultStage string
+
+	// Every channel of the process, nil when the channel runs on its own.
+	registry *ChannelRegistry
+
+	// Chat relayed between channels, see sys_chat_relay.go.
+	instanceID      string
+	chatBus         ChatBus
+	chatUnsubscribe func()
+	chatRelaySeq    uint64
+	relayedChats    *seenMessages
+
+	// Characters recorded online, see sys_presence.go.
+	presenceSeq   uint64
+	presenceTimer *time.Timer
+
+	// Road matchmaking queue, see sys_matchmaking.go.
+	matchmaking *matchmaker
+
+	// Scheduled chat announcements, see sys_announcement.go.
+	announcements *announcer
+
+	// Items admins can give through "!give", see sys_item_grant.go, and the stack limits of item box updates, see sys_item_box.go.
+	items *itemtable.Table
+
+	// Words filtered out of chat, names and guild texts, a *wordfilter.Filter, see sys_wordfilter.go.
+	wordFilter atomic.Value
 
 	raviente *Raviente
+
+	// Opcode handlers and their middleware.
+	handlers *handlerRegistry
+
+	// Bytes sent and received by all sessions of this server.
+	bandwidth *BandwidthStats
+
+	// Responses that ran past a single frame, see sys_frame_limit.go.
+	frameLimits FrameLimitStats
+
+	// Ended sessions by why they ended, see sys_disconnect.go.
+	disconnects DisconnectStats
+
+	// Traces logins from the sign in through to the character entering the world, see sys_tracing.go.
+	tracer *tracing.Tracer
+
+	// Retransmitted requests answered without handling them again, see sys_retransmit.go. Accessed atomically.
+	retransmits uint64
+
+	// Heavy work of requests run off the recv loops, see sys_deferred.go.
+	deferred *deferredPool
+
+	// Largest body the send loop writes in a single frame.
+	frameLimit int
+
+	// Per-character packet traces started through the admin API, see sys_packet_trace.go.
+	packetTraces *packetTraceSet
+
+	// Festa team schedule, see scheduleFesta.
+	festaRegistrationEnd time.Time
+	festaEnd             time.Time
+	festaTimers          []*time.Timer
+
+	dailyResetTimer       *time.Timer
+	semaphoreReclaimTimer *time.Timer
+	questReapTimer        *time.Timer
+	seasonResetTimer      *time.Timer
+	ravienteCycleTimer    *time.Timer
+	treasureWeekTimer     *time.Timer
+
+	// Live ban enforcement, see sys_ban.go.
+	banEnforcementTimer *time.Timer
+	checkedBans         bool
+	lastBanID           int
+
+	// Content gates opened early and announced, see sys_content_gate.go.
+	contentGates     contentGateState
+	contentGateTimer *time.Timer
+
+	// Items of the exchange shops, see sys_shop.go.
+	shops shopCache
+
+	// The channel's row in the server_channels table, nil unless registered, see sys_channel_registration.go.
+	registration *channelRegistration
+
+	// Named RNG streams used by the gacha, lottery and other random rolls.
+	rng *RNG
 }
 
 type Raviente struct {
@@ -85,6 +194,10 @@ type Raviente struct {
 	register *RavienteRegister
 	state    *RavienteState
 	support  *RavienteSupport
+
+	// Progress carried between the windows of a cycle, see sys_raviente_cycle.go.
+	cycle         int               // Cycle the progress belongs to, 0 until it's loaded.
+	contributions map[uint32]uint32 // Damage dealt by each character in the cycle.
 }
 
 type RavienteRegister struct {
@@ -130,6 +243,7 @@ func NewRaviente() *Raviente {
 		register: ravienteRegister,
 		state: ravienteState,
 		support: ravienteSupport,
+		contributions: make(map[uint32]uint32),
 	}
 	return raviente
 }
@@ -138,6 +252,7 @@ func NewRaviente() *Raviente {
 func NewServer(config *Config) *Server {
 	s := &Server {
 		logger:          config.Logger,
+		packetLogger:    config.PacketLogger,
 		db:              config.DB,
 		erupeConfig:     config.ErupeConfig,
 		acceptConns:     make(chan net.Conn),
@@ -145,12 +260,48 @@ func NewServer(config *Config) *Server {
 		sessions:        make(map[net.Conn]*Session),
 		stages:          make(map[string]*Stage),
 		userBinaryParts: make(map[userBinaryPartID][]byte),
+		appearance:      newAppearanceCache(config.DB),
 		semaphore:       make(map[string]*Semaphore),
 		discordBot:      config.DiscordBot,
 		name:            config.Name,
+		world:           config.World,
+		index:           config.Index,
 		enable:          config.Enable,
+		defaultStage:    config.DefaultStage,
+		registry:        config.Registry,
+		instanceID:      newChannelInstanceID(),
+		chatBus:         config.ChatBus,
+		relayedChats:    newSeenMessages(chatRelaySeenSize),
+		matchmaking:     newMatchmaker(),
+		announcements:   &announcer{},
+		items:           config.Items,
+		tracer:          config.Tracer,
 		raviente:        NewRaviente(),
+		bandwidth:       &BandwidthStats{},
+		deferred:        newDeferredPool(config.Logger),
+		frameLimit:      network.CryptPacketMaxDataSize,
+		packetTraces:    newPacketTraceSet(),
+		handlers:        newDefaultHandlerRegistry(config.Logger),
+		rng:             NewRNG(config.DB, config.Name, config.Logger, config.ErupeConfig.DevModeOptions.LogRNGDraws),
+	}
+	if s.packetLogger == nil {
+		s.packetLogger = s.logger
+	}
+	s.handlers.use(retransmitMiddleware(s))
+	if s.tracer != nil {
+		s.handlers.use(tracingMiddleware)
 	}
+	s.handlers.useFor(network.MSG_MHF_SAVEDATA, saveTrackingMiddleware(s))
+	if s.defaultStage == "" {
+		s.defaultStage = MezeportaStageId
+	}
+	if s.registry != nil {
+		s.registry.add(s)
+	}
+	if s.chatBus != nil {
+		s.chatUnsubscribe = s.chatBus.Subscribe(s.receiveRelayedChat)
+	}
+	s.loadWordFilter()
 
 	// Mezeporta
 	s.stages["sl1Ns200p0a0u0"] = NewStage("sl1Ns200p0a0u0")
@@ -199,6 +350,21 @@ func (s *Server) Start(port int) error {
 	go s.acceptClients()
 	go s.manageSessions()
 
+	s.scheduleFesta()
+	s.scheduleDailyReset()
+	s.scheduleSemaphoreReclaim()
+	s.scheduleQuestReap()
+	s.scheduleBanEnforcement()
+	s.schedulePresenceHeartbeat()
+	s.pollContentGates()
+	s.scheduleContentGatePoll()
+	s.scheduleSeasonReset()
+	s.scheduleRavienteCycleEnd()
+	s.scheduleTreasureWeekEnd()
+	s.startMatchmaking()
+	s.startAnnouncements()
+	s.deferred.start(s.erupeConfig.Channel.DeferredWorkers, s.erupeConfig.Channel.DeferredQueueSize)
+
 	// Start the discord bot for chat integration.
 	if s.erupeConfig.Discord.Enabled && s.discordBot != nil {
 		s.discordBot.Session.AddHandler(s.onDiscordMessage)
@@ -207,17 +373,97 @@ func (s *Server) Start(port int) error {
 	return nil
 }
 
-// Shutdown tries to shut down the server gracefully.
-func (s *Server) Shutdown() {
+// ConfigReloaded picks up a reloaded config, see config.Reload. The announcements are scheduled again,
+// their intervals counted from now, and the word filter is compiled again with its list file.
+func (s *Server) ConfigReloaded() {
+	s.scheduleAnnouncements(Time_Current())
+	s.loadWordFilter()
+}
+
+// Shutdown stops accepting clients, counts down in chat, then waits for in-flight
+// saves and flushes every session before closing the connections.
+// Saves still running when ctx is done are abandoned.
+func (s *Server) Shutdown(ctx context.Context) {
 	s.Lock()
 	s.isShuttingDown = true
 	s.Unlock()
 
 	s.listener.Close()
+	s.deregister()
+	s.stopFesta()
+	s.stopDailyReset()
+	s.stopSemaphoreReclaim()
+	s.stopQuestReap()
+	s.stopBanEnforcement()
+	s.stopContentGatePoll()
+	s.stopSeasonReset()
+	s.stopRavienteCycleEnd()
+	s.stopTreasureWeekEnd()
+	s.stopMatchmaking()
+	s.stopAnnouncements()
+	if s.chatUnsubscribe != nil {
+		s.chatUnsubscribe()
+	}
+
+	s.shutdownCountdown(ctx, s.erupeConfig.Channel.ShutdownCountdown)
+	s.DisconnectAll(ctx)
+	s.stopPresenceHeartbeat()
+	s.deferred.stop()
+	s.rng.save()
+	s.stopPacketTraces()
 
 	close(s.acceptConns)
 }
 
+// shutdownCountdown warns all players of the shutdown, every ten seconds and then each of the last five.
+func (s *Server) shutdownCountdown(ctx context.Context, seconds int) {
+	for remaining := seconds; remaining > 0; remaining-- {
+		if remaining == seconds || remaining%10 == 0 || remaining <= 5 {
+			s.BroadcastChatMessage(fmt.Sprintf("Server restarting in %d seconds", remaining))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// beginSave marks a save as in flight until the matching endSave.
+func (s *Server) beginSave() {
+	s.savesLock.RLock()
+}
+
+func (s *Server) endSave() {
+	s.savesLock.RUnlock()
+}
+
+// waitForSaves blocks until every save in flight has finished or ctx is done.
+func (s *Server) waitForSaves(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		s.savesLock.Lock()
+		s.savesLock.Unlock()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.logger.Warn("Timed out waiting for saves to finish", zap.Error(ctx.Err()))
+	}
+}
+
+// flushSession writes out the session's unsaved state and disconnects it.
+func (s *Server) flushSession(session *Session) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Error("Failed to flush session on shutdown", zap.Uint32("charID", session.charID), zap.Any("panic", r))
+		}
+		session.closeWith(DisconnectShutdown)
+	}()
+	logoutPlayer(session)
+}
+
 func (s *Server) acceptClients() {
 	for {
 		conn, err := s.listener.Accept()
@@ -270,6 +516,9 @@ func (s *Server) manageSessions() {
 
 // BroadcastMHF queues a MHFPacket to be sent to all sessions.
 func (s *Server) BroadcastMHF(pkt mhfpacket.MHFPacket, ignoredSession *Session) {
+	s.Lock()
+	defer s.Unlock()
+
 	// Broadcast the data.
 	for _, session := range s.sessions {
 		if session == ignoredSession {
@@ -308,6 +557,13 @@ func (s *Server) BroadcastChatMessage(message string) {
 	}, nil)
 }
 
+// PlayerCount returns the number of clients connected to the channel.
+func (s *Server) PlayerCount() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.sessions)
+}
+
 func (s *Server) DiscordChannelSend(charName string, content string) {
 	if s.erupeConfig.Discord.Enabled && s.discordBot != nil {
 		message := fmt.Sprintf("**%s** : %s", charName, content)
@@ -332,20 +588,35 @@ func (s *Server) FindSessionByCharID(charID uint32) *Session {
 	return nil
 }
 
-func (s *Server) FindStageObjectByChar(charID uint32) *StageObject {
-	s.stagesLock.RLock()
-	defer s.stagesLock.RUnlock()
-	for _, stage := range s.stages {
-		stage.RLock()
-		for objId := range stage.objects {
-			obj := stage.objects[objId]
-			if obj.ownerCharID == charID {
-				stage.RUnlock()
-				return obj
-			}
+// findSessionByName looks for a character on the channel by name, ignoring case.
+func (s *Server) findSessionByName(name string) *Session {
+	s.Lock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.Unlock()
+	for _, session := range sessions {
+		session.Lock()
+		found := session.charID != 0 && strings.EqualFold(session.Name, name)
+		session.Unlock()
+		if found {
+			return session
 		}
-		stage.RUnlock()
 	}
+	return nil
+}
 
+// findSessionByNameOnAnyChannel looks for a character by name on every channel of the process,
+// or only on this one when it isn't part of a registry.
+func (s *Server) findSessionByNameOnAnyChannel(name string) *Session {
+	if s.registry == nil {
+		return s.findSessionByName(name)
+	}
+	for _, server := range s.registry.Servers() {
+		if session := server.findSessionByName(name); session != nil {
+			return session
+		}
+	}
 	return nil
 }
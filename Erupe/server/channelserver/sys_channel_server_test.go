@@ -0,0 +1,162 @@
+package channelserver
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/common/testdb"
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"github.com/Solenataris/Erupe/server/channelserver/compression/nullcomp"
+	"go.uber.org/zap"
+)
+
+// dispatchSlowSave sends the session's save through the handler registry, the way a received packet
+// is, to a handler that takes a while before doing the save.
+func dispatchSlowSave(s *Server, session *Session, save func(*Session, mhfpacket.MHFPacket), pkt mhfpacket.MHFPacket) chan struct{} {
+	started, saved := make(chan struct{}), make(chan struct{})
+	s.handlers.register(network.MSG_MHF_SAVEDATA, func(s *Session, p mhfpacket.MHFPacket) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		save(s, p)
+		close(saved)
+	})
+	go s.handlers.dispatch(session, network.MSG_MHF_SAVEDATA, pkt)
+	<-started
+	return saved
+}
+
+func TestShutdownWaitsForSaves(t *testing.T) {
+	s := newTestServer()
+	if err := s.Start(0); err != nil {
+		t.Fatal(err)
+	}
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	session := NewSession(s, serverConn)
+	session.charID = 1
+
+	saved := dispatchSlowSave(s, session, func(*Session, mhfpacket.MHFPacket) {}, &mhfpacket.MsgMhfSavedata{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.Shutdown(ctx)
+
+	select {
+	case <-saved:
+	default:
+		t.Fatal("Shutdown returned before the in-flight save finished")
+	}
+}
+
+// TestShutdownCommitsPendingSave runs against the database in ERUPE_TEST_DB, e.g.
+// "host=localhost user=postgres password=admin dbname=erupe_test sslmode=disable".
+func TestShutdownCommitsPendingSave(t *testing.T) {
+	db := testdb.Open(t)
+
+	var userID, charID uint32
+	err := db.QueryRow("INSERT INTO users (username, password) VALUES ('shutdown_test', '') RETURNING id").Scan(&userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+	err = db.QueryRow("INSERT INTO characters (user_id, is_new_character, name) VALUES ($1, false, 'shutdown') RETURNING id", userID).Scan(&charID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM characters WHERE id = $1", charID)
+	defer db.Exec("DELETE FROM savedata_backups WHERE char_id = $1", charID)
+
+	s := NewServer(&Config{Logger: zap.NewNop(), DB: db, ErupeConfig: &config.Config{}})
+	if err := s.Start(0); err != nil {
+		t.Fatal(err)
+	}
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go io.Copy(ioutil.Discard, clientConn)
+	session := NewSession(s, serverConn)
+	go session.sendLoop()
+	session.charID = charID
+
+	// A save that's still being handled when the shutdown starts.
+	save := make([]byte, 0x20000)
+	copy(save[88:], "shutdown")
+	save[0x1000] = 0xEF
+	payload, err := nullcomp.Compress(save)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchSlowSave(s, session, handleMsgMhfSavedata, &mhfpacket.MsgMhfSavedata{SaveType: 2, AckHandle: 1, RawDataPayload: payload})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.Shutdown(ctx)
+
+	var got []byte
+	if err := db.QueryRow("SELECT savedata FROM characters WHERE id = $1", charID).Scan(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = nullcomp.Decompress(got); err != nil || len(got) <= 0x1000 || got[0x1000] != 0xEF {
+		t.Errorf("the save handled during the shutdown wasn't stored, %v", err)
+	}
+}
+
+func TestConfigReload(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	writeConfig := func(contents string) {
+		if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeConfig(`{"host_ip": "127.0.0.1", "newcomer": {"enabled": true, "rpMultiplier": 2}}`)
+	erupeConfig, err := config.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(&Config{Logger: zap.NewNop(), ErupeConfig: erupeConfig})
+	session := newQueuedSession(t, s, 1, "Alpha")
+	session.newcomerUntil = time.Now().Add(time.Hour)
+	if rp, _ := playtimeRP(session, 3600); rp != 4 {
+		t.Fatalf("an hour boosted twice got %d RP, want 4", rp)
+	}
+
+	// An invalid file leaves the multiplier as it was.
+	writeConfig(`{"host_ip": "127.0.0.1", "newcomer": {"enabled": true, "rpMultiplier": -3}}`)
+	if _, err := config.Reload(erupeConfig); err == nil {
+		t.Fatal("reloaded a negative multiplier")
+	}
+	if rp, _ := playtimeRP(session, 3600); rp != 4 {
+		t.Errorf("after a failed reload got %d RP, want 4", rp)
+	}
+
+	writeConfig(`{"host_ip": "127.0.0.1", "newcomer": {"enabled": true, "rpMultiplier": 3},
+		"announcements": [{"enabled": true, "message": "Hello", "interval": 30}]}`)
+	if _, err := config.Reload(erupeConfig); err != nil {
+		t.Fatal(err)
+	}
+	s.ConfigReloaded()
+	if rp, _ := playtimeRP(session, 3600); rp != 6 {
+		t.Errorf("after the reload got %d RP, want 6", rp)
+	}
+	s.announcements.Lock()
+	scheduled := len(s.announcements.scheduled)
+	s.announcements.Unlock()
+	if scheduled != 1 {
+		t.Errorf("%d announcements scheduled after the reload, want the new one", scheduled)
+	}
+}
@@ -0,0 +1,116 @@
+package channelserver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Solenataris/Erupe/config"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+var (
+	errNotEnoughCoins   = errors.New("not enough gacha coins")
+	errCharacterSlotMax = errors.New("account has every character slot")
+)
+
+// deductGachaCoins takes the cost from the trial coins if they cover more than it, otherwise from the premium coins.
+// It returns false, deducting nothing, when neither covers it.
+func deductGachaCoins(db sqlx.Execer, charID uint32, cost uint32) (bool, error) {
+	res, err := db.Exec(`
+		UPDATE characters SET
+			gacha_trial = CASE WHEN (gacha_trial > $1) THEN gacha_trial - $1 ELSE gacha_trial END,
+			gacha_prem = CASE WHEN NOT (gacha_trial > $1) THEN gacha_prem - $1 ELSE gacha_prem END
+		WHERE id = $2 AND (gacha_trial > $1 OR gacha_prem >= $1)
+	`, cost, charID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	return rows == 1, err
+}
+
+// characterSlots returns how many characters the character's account has and how many it can have.
+func characterSlots(db *sqlx.DB, charID uint32, cfg config.CharacterSlots) (int, int, error) {
+	var used, extra int
+	err := db.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM characters c WHERE c.user_id = u.id AND c.deleted_at IS NULL), u.extra_slots
+		FROM users u JOIN characters ch ON ch.user_id = u.id WHERE ch.id = $1
+	`, charID).Scan(&used, &extra)
+	return used, cfg.Limit(extra), err
+}
+
+// buyCharacterSlot pays for a slot with the character's gacha coins and adds it to its account.
+func buyCharacterSlot(db *sqlx.DB, charID uint32, cfg config.CharacterSlots) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		UPDATE users SET extra_slots = extra_slots + 1
+		WHERE id = (SELECT user_id FROM characters WHERE id = $1) AND $2 + extra_slots < $3
+	`, charID, cfg.Base, cfg.Max)
+	if err != nil {
+		return err
+	}
+	if rows, err := res.RowsAffected(); err != nil || rows != 1 {
+		if err == nil {
+			err = errCharacterSlotMax
+		}
+		return err
+	}
+	paid, err := deductGachaCoins(tx, charID, cfg.SlotPrice)
+	if err != nil {
+		return err
+	}
+	if !paid {
+		return errNotEnoughCoins
+	}
+	return tx.Commit()
+}
+
+// handleSlotCommand runs the character slot chat commands. "!slot" shows the account's slots
+// and "!slot buy" buys one more with gacha coins.
+func handleSlotCommand(s *Session, message string) {
+	message = strings.TrimSpace(message)
+	if message != "!slot" && message != "!slot buy" {
+		sendServerChatMessage(s, "Usage: \"!slot\" or \"!slot buy\"")
+		return
+	}
+	cfg := s.server.erupeConfig.CharacterSlots
+
+	if message == "!slot buy" {
+		if cfg.SlotPrice == 0 {
+			sendServerChatMessage(s, "Character slots can't be bought on this server.")
+			return
+		}
+		switch err := buyCharacterSlot(s.server.db, s.charID, cfg); err {
+		case nil:
+			sendServerChatMessage(s, fmt.Sprintf("Bought a character slot for %d coins.", cfg.SlotPrice))
+		case errNotEnoughCoins:
+			sendServerChatMessage(s, fmt.Sprintf("A character slot costs %d coins.", cfg.SlotPrice))
+			return
+		case errCharacterSlotMax:
+			sendServerChatMessage(s, "Your account already has every character slot.")
+			return
+		default:
+			s.logger.Error("Failed to buy character slot", zap.Error(err))
+			sendServerChatMessage(s, "Failed to buy a character slot.")
+			return
+		}
+	}
+
+	used, limit, err := characterSlots(s.server.db, s.charID, cfg)
+	if err != nil {
+		s.logger.Error("Failed to get character slots", zap.Error(err))
+		sendServerChatMessage(s, "Failed to get your character slots.")
+		return
+	}
+	sendServerChatMessage(s, fmt.Sprintf("Character slots: %d of %d used.", used, limit))
+	if cfg.SlotPrice > 0 && limit < cfg.Max {
+		sendServerChatMessage(s, fmt.Sprintf("Buy another for %d coins with \"!slot buy\".", cfg.SlotPrice))
+	}
+}
@@ -0,0 +1,309 @@
+package channelserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/network/binpacket"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// Messages queued for a channel before further relayed chat is dropped.
+const chatRelayQueueSize = 64
+
+// Relayed message IDs each channel remembers to drop a message it already delivered.
+const chatRelaySeenSize = 1024
+
+// The Postgres NOTIFY channel relayed chat is published on.
+const chatRelayNotifyChannel = "erupe_chat_relay"
+
+// ChatRelayMessage is a chat message passed between channels, carrying its original sender
+// so the receiving clients see who wrote it and can apply their blacklist.
+type ChatRelayMessage struct {
+	ID         string   `json:"id"`
+	Origin     string   `json:"origin"` // Instance ID of the channel it was sent on.
+	World      bool     `json:"world"`  // Goes to every character, otherwise only to Targets.
+	Targets    []uint32 `json:"targets,omitempty"`
+	CharID     uint32   `json:"charID"`
+	SenderName string   `json:"senderName"`
+	Payload    []byte   `json:"payload"` // The MsgBinChat to cast to the recipients.
+}
+
+// ChatBus passes chat messages between channels. Every subscriber gets every message,
+// including its own, and drops the ones it sent or already saw.
+type ChatBus interface {
+	Publish(msg ChatRelayMessage) error
+	Subscribe(deliver func(ChatRelayMessage)) (unsubscribe func())
+}
+
+// chatSubscribers hands each message to every subscriber's queue, which a goroutine per
+// subscriber drains so a slow channel doesn't hold up the others.
+type chatSubscribers struct {
+	sync.Mutex
+	next   int
+	queues map[int]chan ChatRelayMessage
+}
+
+func (c *chatSubscribers) subscribe(deliver func(ChatRelayMessage)) func() {
+	c.Lock()
+	defer c.Unlock()
+	if c.queues == nil {
+		c.queues = make(map[int]chan ChatRelayMessage)
+	}
+	id := c.next
+	c.next++
+	queue := make(chan ChatRelayMessage, chatRelayQueueSize)
+	c.queues[id] = queue
+	go func() {
+		for msg := range queue {
+			deliver(msg)
+		}
+	}()
+	return func() {
+		c.Lock()
+		defer c.Unlock()
+		if queue, ok := c.queues[id]; ok {
+			delete(c.queues, id)
+			close(queue)
+		}
+	}
+}
+
+// fanOut queues the message for every subscriber, returning how many queues were full.
+func (c *chatSubscribers) fanOut(msg ChatRelayMessage) int {
+	c.Lock()
+	defer c.Unlock()
+	dropped := 0
+	for _, queue := range c.queues {
+		select {
+		case queue <- msg:
+		default:
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// LocalChatBus relays chat between the channels of a single process.
+type LocalChatBus struct {
+	subscribers chatSubscribers
+}
+
+// NewLocalChatBus creates a bus for the channels of this process.
+func NewLocalChatBus() *LocalChatBus {
+	return &LocalChatBus{}
+}
+
+func (b *LocalChatBus) Publish(msg ChatRelayMessage) error {
+	if dropped := b.subscribers.fanOut(msg); dropped > 0 {
+		return fmt.Errorf("chat relay queue full on %d channels", dropped)
+	}
+	return nil
+}
+
+func (b *LocalChatBus) Subscribe(deliver func(ChatRelayMessage)) func() {
+	return b.subscribers.subscribe(deliver)
+}
+
+// PostgresChatBus relays chat through Postgres LISTEN/NOTIFY, reaching the channels of every
+// process sharing the DB. A single listener serves all the channels of a process.
+type PostgresChatBus struct {
+	logger      *zap.Logger
+	db          *sqlx.DB
+	listener    *pq.Listener
+	subscribers chatSubscribers
+}
+
+// NewPostgresChatBus listens for relayed chat on a connection of its own, opened with connectString.
+func NewPostgresChatBus(logger *zap.Logger, db *sqlx.DB, connectString string) (*PostgresChatBus, error) {
+	b := &PostgresChatBus{logger: logger, db: db}
+	b.listener = pq.NewListener(connectString, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.Warn("Chat relay listener error", zap.Error(err))
+		}
+	})
+	if err := b.listener.Listen(chatRelayNotifyChannel); err != nil {
+		b.listener.Close()
+		return nil, err
+	}
+	go b.listen()
+	return b, nil
+}
+
+func (b *PostgresChatBus) listen() {
+	for notification := range b.listener.Notify {
+		// A nil notification means the connection was re-established, messages sent meanwhile are lost.
+		if notification == nil {
+			continue
+		}
+		var msg ChatRelayMessage
+		if err := json.Unmarshal([]byte(notification.Extra), &msg); err != nil {
+			b.logger.Warn("Dropped malformed relayed chat", zap.Error(err))
+			continue
+		}
+		if dropped := b.subscribers.fanOut(msg); dropped > 0 {
+			b.logger.Warn("Relayed chat dropped, queue full", zap.Int("channels", dropped))
+		}
+	}
+}
+
+func (b *PostgresChatBus) Publish(msg ChatRelayMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = b.db.Exec("SELECT pg_notify($1, $2)", chatRelayNotifyChannel, string(data))
+	return err
+}
+
+func (b *PostgresChatBus) Subscribe(deliver func(ChatRelayMessage)) func() {
+	return b.subscribers.subscribe(deliver)
+}
+
+// Close stops listening, channels still subscribed get no more messages.
+func (b *PostgresChatBus) Close() error {
+	return b.listener.Close()
+}
+
+// seenMessages remembers the most recent relayed message IDs.
+type seenMessages struct {
+	sync.Mutex
+	ids   map[string]bool
+	order []string
+	next  int
+}
+
+func newSeenMessages(size int) *seenMessages {
+	return &seenMessages{ids: make(map[string]bool, size), order: make([]string, size)}
+}
+
+// add records the ID, reporting false if it was already seen.
+func (m *seenMessages) add(id string) bool {
+	m.Lock()
+	defer m.Unlock()
+	if m.ids[id] {
+		return false
+	}
+	delete(m.ids, m.order[m.next])
+	m.order[m.next] = id
+	m.next = (m.next + 1) % len(m.order)
+	m.ids[id] = true
+	return true
+}
+
+func newChannelInstanceID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// relayChat publishes a chat message the channel delivered to its own sessions, for the others to deliver.
+func (s *Server) relayChat(sender *Session, senderName string, world bool, targets []uint32, payload []byte) {
+	if s.chatBus == nil {
+		return
+	}
+	msg := ChatRelayMessage{
+		ID:         fmt.Sprintf("%s-%d", s.instanceID, atomic.AddUint64(&s.chatRelaySeq, 1)),
+		Origin:     s.instanceID,
+		World:      world,
+		Targets:    targets,
+		CharID:     sender.charID,
+		SenderName: senderName,
+		Payload:    payload,
+	}
+	if err := s.chatBus.Publish(msg); err != nil {
+		s.logger.Warn("Failed to relay chat", zap.Error(err))
+	}
+}
+
+// receiveRelayedChat delivers a message from another channel, once, to the recipients on this one.
+func (s *Server) receiveRelayedChat(msg ChatRelayMessage) {
+	if msg.Origin == s.instanceID || !s.relayedChats.add(msg.ID) {
+		return
+	}
+	var recipients []*Session
+	if msg.World {
+		s.Lock()
+		for _, session := range s.sessions {
+			recipients = append(recipients, session)
+		}
+		s.Unlock()
+	} else {
+		for _, charID := range msg.Targets {
+			if session := s.FindSessionByCharID(charID); session != nil {
+				recipients = append(recipients, session)
+			}
+		}
+	}
+	broadcastType := uint8(BroadcastTypeTargeted)
+	if msg.World {
+		broadcastType = BroadcastTypeWorld
+	}
+	castChat(recipients, msg.CharID, broadcastType, msg.Payload)
+}
+
+// castChat sends a chat payload to the sessions whose character hasn't blacklisted the sender.
+func castChat(recipients []*Session, senderID uint32, broadcastType uint8, payload []byte) {
+	for _, session := range recipients {
+		if session.isBlocking(senderID) {
+			continue
+		}
+		session.QueueSendMHF(&mhfpacket.MsgSysCastedBinary{
+			CharID:         senderID,
+			BroadcastType:  broadcastType,
+			MessageType:    BinaryMessageTypeChat,
+			RawDataPayload: payload,
+		})
+	}
+}
+
+// relaysChatType reports whether chat of the type is relayed to the other channels.
+func relaysChatType(chatType binpacket.ChatType) bool {
+	return chatType == binpacket.ChatTypeGuild || chatType == binpacket.ChatTypeAlliance
+}
+
+// chatTypeOf reads the type of a MsgBinChat payload without parsing the rest of it.
+func chatTypeOf(payload []byte) binpacket.ChatType {
+	if len(payload) < 2 {
+		return 0
+	}
+	return binpacket.ChatType(payload[1])
+}
+
+// handleWorldChatCommand sends "!world <message>" to every character on every channel.
+func handleWorldChatCommand(s *Session, senderName string, message string) {
+	if len(message) <= len("!world ") {
+		sendServerChatMessage(s, "Usage: !world <message>")
+		return
+	}
+	bf := byteframe.NewByteFrame()
+	bf.SetLE()
+	chat := &binpacket.MsgBinChat{
+		Type:       binpacket.ChatTypeLocal,
+		Message:    "[World] " + message[len("!world "):],
+		SenderName: senderName,
+	}
+	chat.Build(bf)
+
+	s.server.Lock()
+	recipients := make([]*Session, 0, len(s.server.sessions))
+	for _, session := range s.server.sessions {
+		if session != s {
+			recipients = append(recipients, session)
+		}
+	}
+	s.server.Unlock()
+	castChat(recipients, s.charID, BroadcastTypeWorld, bf.Data())
+	s.server.relayChat(s, senderName, true, nil, bf.Data())
+}
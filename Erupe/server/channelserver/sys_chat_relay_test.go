@@ -0,0 +1,169 @@
+package channelserver
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/binpacket"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"go.uber.org/zap"
+)
+
+// newChatRelayChannels returns two channels sharing an in-process chat bus but no registry,
+// so anything that reaches the other channel went through the bus.
+func newChatRelayChannels() (*Server, *Server) {
+	bus := NewLocalChatBus()
+	newChannel := func(name string) *Server {
+		return NewServer(&Config{
+			Logger:      zap.NewNop(),
+			ErupeConfig: &config.Config{},
+			Name:        name,
+			ChatBus:     bus,
+		})
+	}
+	return newChannel("World #1"), newChannel("World #1")
+}
+
+// newChatMember returns a session without a send loop standing in the lobby, so the chat it gets can be read from its queue.
+func newChatMember(t *testing.T, s *Server, charID uint32) *Session {
+	serverConn, clientConn := net.Pipe()
+	t.Cleanup(func() { clientConn.Close() })
+	session := NewSession(s, serverConn)
+	session.charID = charID
+	s.Lock()
+	s.sessions[serverConn] = session
+	s.Unlock()
+	lobby, _ := s.GetOrCreateStage("sl1Ns200p0a0u0")
+	lobby.Lock()
+	lobby.clients[session] = charID
+	lobby.Unlock()
+	return session
+}
+
+func guildChatPacket(message string, senderName string, targets ...uint32) *mhfpacket.MsgSysCastBinary {
+	chat := byteframe.NewByteFrame()
+	chat.SetLE()
+	(&binpacket.MsgBinChat{Type: binpacket.ChatTypeGuild, Message: message, SenderName: senderName}).Build(chat)
+	bf := byteframe.NewByteFrame()
+	(&binpacket.MsgBinTargeted{TargetCount: uint16(len(targets)), TargetCharIDs: targets, RawDataPayload: chat.Data()}).Build(bf)
+	return &mhfpacket.MsgSysCastBinary{
+		BroadcastType:  BroadcastTypeTargeted,
+		MessageType:    BinaryMessageTypeChat,
+		RawDataPayload: bf.Data(),
+	}
+}
+
+// receivedChat returns the senders of the chat packets queued for the session, waiting for more until the wait is up.
+func receivedChat(session *Session, wait time.Duration) []uint32 {
+	var senders []uint32
+	timeout := time.After(wait)
+	for {
+		var data []byte
+		select {
+		case data = <-session.sendPackets:
+		default:
+			select {
+			case data = <-session.sendPackets:
+			case <-timeout:
+				return senders
+			}
+		}
+		if network.PacketID(binary.BigEndian.Uint16(data)) == network.MSG_SYS_CASTED_BINARY && data[7] == BinaryMessageTypeChat {
+			senders = append(senders, binary.BigEndian.Uint32(data[2:]))
+		}
+	}
+}
+
+func TestGuildChatRelayedOnce(t *testing.T) {
+	a, b := newChatRelayChannels()
+	sender := newChatMember(t, a, 1)
+	local := newChatMember(t, a, 2)
+	remote := newChatMember(t, b, 3)
+
+	handleMsgSysCastBinary(sender, guildChatPacket("hello", "Sender", 2, 3))
+
+	if got := receivedChat(remote, 200*time.Millisecond); len(got) != 1 || got[0] != 1 {
+		t.Errorf("the member on the other channel got chat from %v, want the sender's once", got)
+	}
+	if got := receivedChat(local, 0); len(got) != 1 || got[0] != 1 {
+		t.Errorf("the member on the sender's channel got chat from %v, want the sender's once", got)
+	}
+	if got := receivedChat(sender, 0); len(got) != 0 {
+		t.Errorf("the sender got its own chat back %d times", len(got))
+	}
+}
+
+func TestRelayedChatDeduplicated(t *testing.T) {
+	a, b := newChatRelayChannels()
+	newChatMember(t, a, 1)
+	remote := newChatMember(t, b, 3)
+
+	msg := ChatRelayMessage{ID: "elsewhere-1", Origin: "elsewhere", Targets: []uint32{3}, CharID: 1, Payload: []byte{0, 2}}
+	b.receiveRelayedChat(msg)
+	b.receiveRelayedChat(msg)
+	if got := receivedChat(remote, 0); len(got) != 1 {
+		t.Errorf("a message relayed twice arrived %d times, want once", len(got))
+	}
+}
+
+func TestRelayedChatHonoursBlacklist(t *testing.T) {
+	a, b := newChatRelayChannels()
+	sender := newChatMember(t, a, 1)
+	remote := newChatMember(t, b, 3)
+	remote.blocked = map[uint32]bool{1: true}
+
+	handleMsgSysCastBinary(sender, guildChatPacket("hello", "Sender", 3))
+	if got := receivedChat(remote, 100*time.Millisecond); len(got) != 0 {
+		t.Errorf("chat from a blacklisted character arrived %d times", len(got))
+	}
+}
+
+func TestWorldChatReachesEveryChannel(t *testing.T) {
+	a, b := newChatRelayChannels()
+	sender := newChatMember(t, a, 1)
+	local := newChatMember(t, a, 2)
+	remote := newChatMember(t, b, 3)
+
+	handleWorldChatCommand(sender, "Sender", "!world hello everyone")
+	if got := receivedChat(remote, 200*time.Millisecond); len(got) != 1 || got[0] != 1 {
+		t.Errorf("the other channel got world chat from %v, want the sender's once", got)
+	}
+	if got := receivedChat(local, 0); len(got) != 1 {
+		t.Errorf("the sender's channel got world chat %d times, want once", len(got))
+	}
+	if got := receivedChat(sender, 0); len(got) != 0 {
+		t.Errorf("the sender got its world chat back %d times", len(got))
+	}
+}
+
+func TestWorldChatCommandNotEchoed(t *testing.T) {
+	a, b := newChatRelayChannels()
+	sender := newChatMember(t, a, 1)
+	local := newChatMember(t, a, 2)
+	newChatMember(t, b, 3)
+
+	// Sent as stage chat, the command itself must not reach the stage next to the world message.
+	handleMsgSysCastBinary(sender, chatPacket(binpacket.ChatTypeLocal, BroadcastTypeStage, "!world hello"))
+	var messages []string
+	for len(local.sendPackets) > 0 {
+		data := <-local.sendPackets
+		if network.PacketID(binary.BigEndian.Uint16(data)) != network.MSG_SYS_CASTED_BINARY || data[7] != BinaryMessageTypeChat {
+			continue
+		}
+		bf := byteframe.NewByteFrameFromBytes(data[10:])
+		bf.SetLE()
+		chat := &binpacket.MsgBinChat{}
+		if err := chat.Parse(bf); err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, chat.Message)
+	}
+	if len(messages) != 1 || messages[0] != "[World] hello" {
+		t.Errorf("the sender's stage got %q, want the world message once", messages)
+	}
+}
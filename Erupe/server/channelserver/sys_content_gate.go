@@ -0,0 +1,280 @@
+package channelserver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// How often a channel picks up the content gates opened early through the admin API and tells its
+// players about the gates that opened.
+const contentGatePollInterval = time.Minute
+
+// contentGateState is what a channel knows about the content gates of the config beyond their dates.
+type contentGateState struct {
+	sync.Mutex
+	opened    map[string]bool // Gates opened early, replaced as a whole on every poll.
+	announced map[string]bool // Gates open at the last poll, nil before the first.
+}
+
+// ContentGateOverride is a content gate opened before its date through the admin API.
+type ContentGateOverride struct {
+	Name     string    `db:"name" json:"name"`
+	OpenedBy string    `db:"opened_by" json:"openedBy"`
+	OpenedAt time.Time `db:"opened_at" json:"openedAt"`
+}
+
+// ContentGateOverrides returns the content gates opened early.
+func ContentGateOverrides(db *sqlx.DB) ([]ContentGateOverride, error) {
+	overrides := []ContentGateOverride{}
+	err := db.Select(&overrides, "SELECT name, opened_by, opened_at FROM content_gate_overrides ORDER BY opened_at")
+	return overrides, err
+}
+
+// OpenContentGate opens the named content gate before its date. Every channel picks it up with its next
+// poll. Opening a gate again keeps when it was first opened.
+func OpenContentGate(db *sqlx.DB, name string, openedBy string) error {
+	_, err := db.Exec("INSERT INTO content_gate_overrides (name, opened_by) VALUES ($1, $2) ON CONFLICT (name) DO NOTHING", name, openedBy)
+	return err
+}
+
+// ContentGateOpen reports whether the gate is open at now, by its date or because it's among the opened.
+func ContentGateOpen(gate config.ContentGate, now time.Time, opened map[string]bool) bool {
+	if opened[gate.Name] {
+		return true
+	}
+	// Validated with the config.
+	opens, _ := time.Parse(time.RFC3339, gate.Opens)
+	return !now.Before(opens)
+}
+
+// closedContentGates returns the gates of the config still closed. The config is read each time, so a
+// gate opening, or reloaded, applies to players already online.
+func (s *Server) closedContentGates() []config.ContentGate {
+	gates := s.erupeConfig.Current().ContentGates
+	if len(gates) == 0 {
+		return nil
+	}
+	s.contentGates.Lock()
+	opened := s.contentGates.opened
+	s.contentGates.Unlock()
+	now := time.Now()
+	var closed []config.ContentGate
+	for _, gate := range gates {
+		if !ContentGateOpen(gate, now, opened) {
+			closed = append(closed, gate)
+		}
+	}
+	return closed
+}
+
+// questGate returns the name of the closed gate holding back the quest file, such as "23045d0", whose
+// ID is the number its name starts with.
+func questGate(closed []config.ContentGate, filename string) (string, bool) {
+	if len(closed) == 0 || len(filename) < 5 {
+		return "", false
+	}
+	id, err := strconv.Atoi(filename[:5])
+	if err != nil {
+		return "", false
+	}
+	for _, gate := range closed {
+		for _, quests := range gate.Quests {
+			if id >= quests.First && id <= quests.Last {
+				return gate.Name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// departureGated fails the entry into a quest stage whose quest a closed gate holds back, reporting
+// whether it did. The quest is the one the instance was noted for, or the last file the session loaded
+// when no member loaded one yet. It catches a quest loaded before its gate closed, such as with a config
+// reload, and members joining an instance of a gated quest.
+func departureGated(s *Session, ackHandle uint32, stageID string) bool {
+	if !isQuestStageID(stageID) {
+		return false
+	}
+	s.Lock()
+	quest := s.questFile
+	s.Unlock()
+	if stage, exists := s.server.GetStage(stageID); exists {
+		stage.RLock()
+		if stage.questID != "" {
+			quest = stage.questID
+		}
+		stage.RUnlock()
+	}
+	gate, ok := questGate(s.server.closedContentGates(), quest)
+	if !ok {
+		return false
+	}
+	s.logger.Info("Refused departure on a quest held back by a content gate", zap.String("quest", quest), zap.String("gate", gate))
+	doAckSimpleFail(s, ackHandle, make([]byte, 4))
+	return true
+}
+
+// questListGated reports whether a closed gate holds back the quest list.
+func questListGated(closed []config.ContentGate, list uint16) bool {
+	for _, gate := range closed {
+		for _, gated := range gate.QuestLists {
+			if gated == list {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// featureGated reports whether a closed gate holds back the feature, one of config.ContentGateFeatures.
+func (s *Server) featureGated(feature string) bool {
+	for _, gate := range s.closedContentGates() {
+		for _, gated := range gate.Features {
+			if gated == feature {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// savedataRank reads the HRP and G rank points out of a savedata, 0 for one too short to hold them.
+func savedataRank(data []byte) (uint16, uint32) {
+	if len(data) < saveDataGRPOffset+4 {
+		return 0, 0
+	}
+	return binary.LittleEndian.Uint16(data[saveDataHRPOffset:]), binary.LittleEndian.Uint32(data[saveDataGRPOffset:])
+}
+
+// capRank returns the rank held to the cap, or to the previous rank where that's already past the cap.
+func capRank(rank uint32, previous uint32, limit uint32) uint32 {
+	if previous > limit {
+		limit = previous
+	}
+	if rank > limit {
+		return limit
+	}
+	return rank
+}
+
+// capGatedRank holds the HRP and G rank points of the savedata to the caps of the closed gates,
+// reporting whether it changed them. The rank ups are the client's own, so the rank it saves past a cap
+// is cut back. Ranks already past a cap in the previous savedata, such as ones earned before the gate
+// was configured, are kept.
+func capGatedRank(closed []config.ContentGate, previous []byte, data []byte) bool {
+	if len(closed) == 0 || len(data) < saveDataGRPOffset+4 {
+		return false
+	}
+	hrp, grp := savedataRank(data)
+	prevHRP, prevGRP := savedataRank(previous)
+	cappedHRP, cappedGRP := uint32(hrp), grp
+	for _, gate := range closed {
+		if gate.HRPCap > 0 {
+			cappedHRP = capRank(cappedHRP, uint32(prevHRP), uint32(gate.HRPCap))
+		}
+		if gate.NoGRank {
+			cappedGRP = capRank(cappedGRP, prevGRP, 0)
+		} else if gate.GRPCap > 0 {
+			cappedGRP = capRank(cappedGRP, prevGRP, gate.GRPCap)
+		}
+	}
+	if cappedHRP == uint32(hrp) && cappedGRP == grp {
+		return false
+	}
+	binary.LittleEndian.PutUint16(data[saveDataHRPOffset:], uint16(cappedHRP))
+	binary.LittleEndian.PutUint32(data[saveDataGRPOffset:], cappedGRP)
+	return true
+}
+
+// contentGateNotices are the lines telling when the closed gates open, the soonest first.
+func contentGateNotices(closed []config.ContentGate) []string {
+	gates := append([]config.ContentGate(nil), closed...)
+	opens := func(gate config.ContentGate) time.Time {
+		t, _ := time.Parse(time.RFC3339, gate.Opens)
+		return t
+	}
+	sort.SliceStable(gates, func(i, j int) bool { return opens(gates[i]).Before(opens(gates[j])) })
+	notices := make([]string, len(gates))
+	for i, gate := range gates {
+		notices[i] = fmt.Sprintf("%s unlocks on %s.", gate.Name, loginMessageTime(opens(gate)))
+	}
+	return notices
+}
+
+// sendContentGateNotices tells the session when the closed gates open, after its login message.
+func sendContentGateNotices(s *Session) {
+	for _, notice := range contentGateNotices(s.server.closedContentGates()) {
+		sendServerChatMessage(s, notice)
+	}
+}
+
+// scheduleContentGatePoll polls the content gates every contentGatePollInterval.
+func (s *Server) scheduleContentGatePoll() {
+	s.Lock()
+	defer s.Unlock()
+	if s.isShuttingDown {
+		return
+	}
+	s.contentGateTimer = time.AfterFunc(contentGatePollInterval, func() {
+		s.pollContentGates()
+		s.scheduleContentGatePoll()
+	})
+}
+
+// stopContentGatePoll cancels the pending content gate poll.
+func (s *Server) stopContentGatePoll() {
+	s.Lock()
+	defer s.Unlock()
+	if s.contentGateTimer != nil {
+		s.contentGateTimer.Stop()
+	}
+}
+
+// pollContentGates picks up the gates opened early and tells the players about the gates that opened
+// since the last poll. The first poll only notes the gates already open.
+func (s *Server) pollContentGates() {
+	gates := s.erupeConfig.Current().ContentGates
+	if len(gates) == 0 {
+		return
+	}
+	overrides, err := ContentGateOverrides(s.db)
+	if err != nil {
+		s.logger.Error("Failed to get content gate overrides", zap.Error(err))
+		return
+	}
+	opened := make(map[string]bool)
+	for _, override := range overrides {
+		opened[override.Name] = true
+	}
+
+	now := time.Now()
+	var lifted []string
+	s.contentGates.Lock()
+	s.contentGates.opened = opened
+	first := s.contentGates.announced == nil
+	if first {
+		s.contentGates.announced = make(map[string]bool)
+	}
+	for _, gate := range gates {
+		if ContentGateOpen(gate, now, opened) && !s.contentGates.announced[gate.Name] {
+			s.contentGates.announced[gate.Name] = true
+			if !first {
+				lifted = append(lifted, gate.Name)
+			}
+		}
+	}
+	s.contentGates.Unlock()
+
+	for _, name := range lifted {
+		s.logger.Info("Content gate opened", zap.String("gate", name))
+		s.BroadcastChatMessage(fmt.Sprintf("%s is now open!", name))
+	}
+}
@@ -0,0 +1,127 @@
+package channelserver
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+)
+
+func gatedSavedata(hrp uint16, grp uint32) []byte {
+	data := make([]byte, saveDataGRPOffset+4)
+	binary.LittleEndian.PutUint16(data[saveDataHRPOffset:], hrp)
+	binary.LittleEndian.PutUint32(data[saveDataGRPOffset:], grp)
+	return data
+}
+
+func TestClosedContentGates(t *testing.T) {
+	s := newTestServer()
+	s.erupeConfig.ContentGates = []config.ContentGate{
+		{Name: "G rank", Opens: time.Now().Add(time.Hour).Format(time.RFC3339)},
+		{Name: "Festa", Opens: time.Now().Add(-time.Hour).Format(time.RFC3339), Features: []string{"festa"}},
+		{Name: "Point shop", Opens: time.Now().Add(time.Hour).Format(time.RFC3339), Features: []string{"pointshop"}},
+	}
+	closed := s.closedContentGates()
+	if len(closed) != 2 || closed[0].Name != "G rank" || closed[1].Name != "Point shop" {
+		t.Fatalf("got closed gates %+v", closed)
+	}
+	if s.featureGated("festa") || !s.featureGated("pointshop") {
+		t.Error("features weren't held back by the closed gates alone")
+	}
+
+	s.contentGates.opened = map[string]bool{"Point shop": true}
+	if closed := s.closedContentGates(); len(closed) != 1 || closed[0].Name != "G rank" {
+		t.Errorf("got closed gates %+v after opening one early", closed)
+	}
+}
+
+func TestQuestGate(t *testing.T) {
+	closed := []config.ContentGate{{Name: "G rank", Quests: []config.QuestIDRange{{First: 23000, Last: 23999}}, QuestLists: []uint16{42}}}
+	tests := []struct {
+		filename string
+		gated    bool
+	}{
+		{"23045d0", true},
+		{"23999n1", true},
+		{"22999d0", false},
+		{"2304", false},
+		{"abcdefg", false},
+	}
+	for _, tt := range tests {
+		if gate, gated := questGate(closed, tt.filename); gated != tt.gated || (gated && gate != "G rank") {
+			t.Errorf("%q: got gate %q, %v, want %v", tt.filename, gate, gated, tt.gated)
+		}
+	}
+	if !questListGated(closed, 42) || questListGated(closed, 0) {
+		t.Error("quest lists weren't gated as configured")
+	}
+}
+
+func TestCapGatedRank(t *testing.T) {
+	closed := []config.ContentGate{{HRPCap: 5000}, {NoGRank: true}}
+	tests := []struct {
+		name     string
+		previous []byte
+		data     []byte
+		hrp      uint16
+		grp      uint32
+		capped   bool
+	}{
+		{"below the caps", gatedSavedata(1000, 0), gatedSavedata(2000, 0), 2000, 0, false},
+		{"past the HRP cap", gatedSavedata(4000, 0), gatedSavedata(6000, 0), 5000, 0, true},
+		{"into G rank", gatedSavedata(5000, 0), gatedSavedata(5000, 1), 5000, 0, true},
+		{"already past the caps", gatedSavedata(7000, 300), gatedSavedata(7500, 900), 7000, 300, true},
+		{"first save", nil, gatedSavedata(9000, 0), 5000, 0, true},
+	}
+	for _, tt := range tests {
+		capped := capGatedRank(closed, tt.previous, tt.data)
+		hrp, grp := savedataRank(tt.data)
+		if capped != tt.capped || hrp != tt.hrp || grp != tt.grp {
+			t.Errorf("%s: got HRP %d, GRP %d, capped %v, want %d, %d, %v", tt.name, hrp, grp, capped, tt.hrp, tt.grp, tt.capped)
+		}
+	}
+}
+
+func TestContentGateNotices(t *testing.T) {
+	closed := []config.ContentGate{
+		{Name: "G rank", Opens: "2022-09-01T00:00:00+09:00"},
+		{Name: "Festa", Opens: "2022-08-01T12:00:00+09:00"},
+	}
+	notices := contentGateNotices(closed)
+	if len(notices) != 2 || notices[0] != "Festa unlocks on 2022-08-01 12:00." || notices[1] != "G rank unlocks on 2022-09-01 00:00." {
+		t.Errorf("got notices %q", notices)
+	}
+}
+
+func TestDepartureGated(t *testing.T) {
+	s := newTestServer()
+	s.erupeConfig.ContentGates = []config.ContentGate{
+		{Name: "G rank", Opens: time.Now().Add(time.Hour).Format(time.RFC3339), Quests: []config.QuestIDRange{{First: 23000, Last: 23999}}},
+	}
+	session := newQueuedSession(t, s, 100, "Hunter")
+	session.questFile = "23045d0"
+	if departureGated(session, 0x10, "sl1Ns200p0a0u0") {
+		t.Error("entering a town was refused")
+	}
+	if !departureGated(session, 0x11, "sl1Qs000p0a0u0") {
+		t.Error("a departure on a gated quest was let through")
+	}
+
+	// Joining someone's instance, its quest is the one checked.
+	joined, _ := s.CreateStage("sl1Qs001p0a0u0", 4)
+	joined.questID = "22000d0"
+	if departureGated(session, 0x12, joined.id) {
+		t.Error("joining an instance of an open quest was refused")
+	}
+	joined.questID = "23100d0"
+	session.questFile = ""
+	if !departureGated(session, 0x13, joined.id) {
+		t.Error("joining an instance of a gated quest was let through")
+	}
+
+	s.contentGates.opened = map[string]bool{"G rank": true}
+	if departureGated(session, 0x14, joined.id) {
+		t.Error("a departure was refused after its gate opened")
+	}
+}
@@ -0,0 +1,124 @@
+package channelserver
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// Currency is a balance of points the server keeps for a character, named by its characters column.
+// Zenny isn't one, it lives in the savedata and the client changes it itself.
+type Currency string
+
+// The currencies held in the characters table.
+const (
+	CurrencyFrontier Currency = "frontier_points"
+	CurrencyNetcafe  Currency = "netcafe_points"
+	CurrencyKouryou  Currency = "kouryou_point"
+	CurrencyFesta    Currency = "festa_points"
+)
+
+var currencies = map[Currency]bool{
+	CurrencyFrontier: true,
+	CurrencyNetcafe:  true,
+	CurrencyKouryou:  true,
+	CurrencyFesta:    true,
+}
+
+// maxPoints is the most a balance holds, what the int columns hold, well within the uint32 the client
+// reads the balances as.
+const maxPoints = math.MaxInt32
+
+// rowQuerier runs a query of a single row, a database or a transaction.
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// AddPoints changes the character's balance of the currency by delta and journals the change to
+// points_ledger with its source, in a single statement, returning the new balance. Concurrent changes
+// of a balance queue up on its row, so none is lost. A credit past maxPoints is cut back to it, the
+// ledger recording what was added, and a debit past the balance is refused with ErrNotEnoughPoints.
+func AddPoints(db rowQuerier, charID uint32, currency Currency, delta int64, source string) (int64, error) {
+	if !currencies[currency] {
+		return 0, fmt.Errorf("unknown currency %q", currency)
+	}
+	var balance int64
+	err := db.QueryRow(fmt.Sprintf(`
+		WITH old AS (
+			SELECT COALESCE(%[1]s, 0)::bigint AS balance FROM characters WHERE id = $1 FOR UPDATE
+		), updated AS (
+			UPDATE characters SET %[1]s = LEAST(old.balance + $2, $3) FROM old
+			WHERE id = $1 AND old.balance + $2 >= 0
+			RETURNING characters.%[1]s::bigint AS balance, old.balance AS old
+		)
+		INSERT INTO points_ledger (char_id, currency, delta, balance, source)
+		SELECT $1, $4, balance - old, balance, $5 FROM updated
+		RETURNING balance
+	`, currency), charID, delta, maxPoints, string(currency), source).Scan(&balance)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotEnoughPoints
+	}
+	return balance, err
+}
+
+// addPoints changes the balance of the session's character, see AddPoints, logging why it didn't.
+func addPoints(s *Session, currency Currency, delta int64, source string) (int64, bool) {
+	balance, err := AddPoints(s.server.db, s.charID, currency, delta, source)
+	if err == ErrNotEnoughPoints {
+		s.logger.Info("Refused points change past the balance", zap.String("currency", string(currency)), zap.Int64("delta", delta), zap.String("source", source))
+		return 0, false
+	} else if err != nil {
+		s.logger.Error("Failed to change points", zap.String("currency", string(currency)), zap.String("source", source), zap.Error(err))
+		return 0, false
+	}
+	return balance, true
+}
+
+// Netcafe points the daily cafe check credits.
+const dailyCafePoints = 5
+
+// claimDailyCafePoints credits the daily cafe points unless they're already claimed until now, moving the
+// next claim to next, and reports whether they were credited.
+func claimDailyCafePoints(db *sqlx.DB, charID uint32, now time.Time, next time.Time) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("UPDATE characters SET daily_time = $1 WHERE id = $2 AND (daily_time IS NULL OR daily_time < $3)", next, charID, now)
+	if err != nil {
+		return false, err
+	}
+	if rows, err := res.RowsAffected(); err != nil || rows == 0 {
+		return false, err
+	}
+	if _, err := AddPoints(tx, charID, CurrencyNetcafe, dailyCafePoints, "daily cafe"); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// PointsLedgerEntry is a change of a character's balance, see AddPoints.
+type PointsLedgerEntry struct {
+	ID        int       `db:"id" json:"id"`
+	CharID    uint32    `db:"char_id" json:"charID"`
+	Currency  string    `db:"currency" json:"currency"`
+	Delta     int64     `db:"delta" json:"delta"`
+	Balance   int64     `db:"balance" json:"balance"`
+	Source    string    `db:"source" json:"source"`
+	CreatedAt time.Time `db:"created_at" json:"createdAt"`
+}
+
+// PointsLedger returns the latest changes of the character's balances, the newest first.
+func PointsLedger(db *sqlx.DB, charID uint32, limit int) ([]PointsLedgerEntry, error) {
+	entries := []PointsLedgerEntry{}
+	err := db.Select(&entries, `
+		SELECT id, char_id, currency, delta, balance, source, created_at FROM points_ledger
+		WHERE char_id = $1 ORDER BY id DESC LIMIT $2
+	`, charID, limit)
+	return entries, err
+}
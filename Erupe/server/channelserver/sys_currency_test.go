@@ -0,0 +1,68 @@
+package channelserver
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/Solenataris/Erupe/common/testdb"
+)
+
+func TestAddPointsRejectsUnknownCurrency(t *testing.T) {
+	if _, err := AddPoints(nil, 1, Currency("zenny; DROP TABLE characters"), 1, "test"); err == nil {
+		t.Error("changed a balance of an unknown currency")
+	}
+}
+
+// TestAddPoints runs against the database in ERUPE_TEST_DB, e.g.
+// "host=localhost user=postgres password=admin dbname=erupe_test sslmode=disable".
+func TestAddPoints(t *testing.T) {
+	db := testdb.Open(t)
+
+	var userID, charID uint32
+	err := db.QueryRow("INSERT INTO users (username, password) VALUES ('points_test', '') RETURNING id").Scan(&userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+	err = db.QueryRow("INSERT INTO characters (user_id, is_new_character, name) VALUES ($1, false, 'points') RETURNING id", userID).Scan(&charID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM characters WHERE id = $1", charID)
+	defer db.Exec("DELETE FROM points_ledger WHERE char_id = $1", charID)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if _, err := AddPoints(db, charID, CurrencyFrontier, 5, "test"); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	balance, err := AddPoints(db, charID, CurrencyFrontier, 0, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 1000 {
+		t.Errorf("got balance %d after 200 concurrent credits of 5, want 1000", balance)
+	}
+
+	if _, err := AddPoints(db, charID, CurrencyFrontier, -1001, "test"); err != ErrNotEnoughPoints {
+		t.Errorf("debit past the balance got %v, want %v", err, ErrNotEnoughPoints)
+	}
+	if balance, err := AddPoints(db, charID, CurrencyFrontier, maxPoints, "test"); err != nil || balance != maxPoints {
+		t.Errorf("credit past the cap got balance %d, %v, want %d", balance, err, maxPoints)
+	}
+	var delta int64
+	if err := db.QueryRow("SELECT delta FROM points_ledger WHERE char_id = $1 ORDER BY id DESC LIMIT 1", charID).Scan(&delta); err != nil {
+		t.Fatal(err)
+	}
+	if delta != maxPoints-1000 {
+		t.Errorf("ledger recorded a credit of %d past the cap, want %d", delta, maxPoints-1000)
+	}
+}
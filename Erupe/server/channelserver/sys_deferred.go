@@ -0,0 +1,181 @@
+package channelserver
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Solenataris/Erupe/network"
+	"go.uber.org/zap"
+)
+
+// Deferred work pool defaults used when the config leaves them unset.
+const (
+	defaultDeferredWorkers   = 4
+	defaultDeferredQueueSize = 256
+)
+
+// Requests handled once the session's deferred tasks are done, as they have to be answered before the
+// packets after them are handled and after every request before them:
+//   - MSG_SYS_LOGOUT, so the answers still owed are queued before the session closes.
+//   - MSG_SYS_ENTER_STAGE, MSG_SYS_MOVE_STAGE and MSG_SYS_BACK_STAGE, the client drops the requests of the
+//     area it leaves that weren't answered yet.
+//   - MSG_MHF_CHARGE_FESTA and MSG_MHF_UPDATE_USE_TREND_WEAPON_LOG, whose writes a festa standings or
+//     trend weapons request sent before them mustn't see.
+//
+// The handlers of these opcodes don't defer their own work.
+var orderedOpcodes = map[network.PacketID]bool{
+	network.MSG_SYS_LOGOUT:                      true,
+	network.MSG_SYS_ENTER_STAGE:                 true,
+	network.MSG_SYS_MOVE_STAGE:                  true,
+	network.MSG_SYS_BACK_STAGE:                  true,
+	network.MSG_MHF_CHARGE_FESTA:                true,
+	network.MSG_MHF_UPDATE_USE_TREND_WEAPON_LOG: true,
+}
+
+// deferredTask is the work of a request run by the pool and the completion callback answering it.
+type deferredTask struct {
+	session  *Session
+	opcode   network.PacketID
+	queuedAt time.Time
+	work     func() ([]byte, error)
+	done     func(data []byte, err error)
+}
+
+// deferredPool runs the heavy work of requests, such as aggregating the festa standings, off the recv
+// loops of the sessions making them, so a session goes on handling its next packets meanwhile.
+// Until the pool is started, and while its queue is full, tasks run inline instead.
+type deferredPool struct {
+	sync.RWMutex
+	tasks   chan deferredTask // nil unless started.
+	workers sync.WaitGroup
+	logger  *zap.Logger
+
+	// All counters are updated atomically.
+	queued    int64  // Tasks waiting for a worker.
+	completed uint64 // Tasks run by the workers.
+	inline    uint64 // Tasks run inline as the queue was full or the pool not started.
+	latencyNs uint64 // Time from queueing to completion of the tasks run by the workers.
+}
+
+func newDeferredPool(logger *zap.Logger) *deferredPool {
+	return &deferredPool{logger: logger}
+}
+
+// start runs the workers, taking tasks from a queue of the given size.
+func (p *deferredPool) start(workers int, queueSize int) {
+	if workers <= 0 {
+		workers = defaultDeferredWorkers
+	}
+	if queueSize <= 0 {
+		queueSize = defaultDeferredQueueSize
+	}
+	p.Lock()
+	defer p.Unlock()
+	if p.tasks != nil {
+		return
+	}
+	p.tasks = make(chan deferredTask, queueSize)
+	for i := 0; i < workers; i++ {
+		p.workers.Add(1)
+		go p.work(p.tasks)
+	}
+}
+
+// stop lets the workers finish the queued tasks and waits for them, later tasks run inline.
+func (p *deferredPool) stop() {
+	p.Lock()
+	tasks := p.tasks
+	p.tasks = nil
+	p.Unlock()
+	if tasks != nil {
+		close(tasks)
+		p.workers.Wait()
+	}
+}
+
+func (p *deferredPool) work(tasks chan deferredTask) {
+	defer p.workers.Done()
+	for task := range tasks {
+		atomic.AddInt64(&p.queued, -1)
+		p.run(task)
+		atomic.AddUint64(&p.completed, 1)
+		atomic.AddUint64(&p.latencyNs, uint64(time.Since(task.queuedAt)))
+	}
+}
+
+// submit queues the task for a worker, or runs it inline when there's no room for it.
+func (p *deferredPool) submit(task deferredTask) {
+	p.RLock()
+	if p.tasks != nil {
+		select {
+		case p.tasks <- task:
+			atomic.AddInt64(&p.queued, 1)
+			p.RUnlock()
+			return
+		default:
+		}
+	}
+	p.RUnlock()
+	atomic.AddUint64(&p.inline, 1)
+	p.run(task)
+}
+
+// run does the task's work and completes it, a panic of the work failing it rather than the worker.
+func (p *deferredPool) run(task deferredTask) {
+	defer task.session.deferredTasks.Done()
+	data, err := func() (data []byte, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				p.logger.Error("Recovered from panic in deferred task", zap.String("opcode", task.opcode.String()), zap.Any("panic", r), zap.Stack("stack"))
+				err = fmt.Errorf("deferred task panicked: %v", r)
+			}
+		}()
+		return task.work()
+	}()
+	task.done(data, err)
+}
+
+// writeMetrics writes the queue depth, task counts and latency in the Prometheus text exposition format.
+func (p *deferredPool) writeMetrics(w io.Writer, channel string) {
+	fmt.Fprintf(w, "erupe_channel_deferred_queue_depth{channel=%s} %d\n", channel, atomic.LoadInt64(&p.queued))
+	fmt.Fprintf(w, "erupe_channel_deferred_inline_total{channel=%s} %d\n", channel, atomic.LoadUint64(&p.inline))
+	fmt.Fprintf(w, "erupe_channel_deferred_task_seconds_sum{channel=%s} %g\n", channel, time.Duration(atomic.LoadUint64(&p.latencyNs)).Seconds())
+	fmt.Fprintf(w, "erupe_channel_deferred_task_seconds_count{channel=%s} %d\n", channel, atomic.LoadUint64(&p.completed))
+}
+
+// deferTask runs work off the recv loop and hands what it returns to done, on a worker of the server's
+// pool. Deferring from the handler of an ordered opcode runs the work inline.
+func (s *Session) deferTask(work func() ([]byte, error), done func(data []byte, err error)) {
+	opcode := network.PacketID(atomic.LoadUint32(&s.handling))
+	s.deferredTasks.Add(1)
+	task := deferredTask{session: s, opcode: opcode, queuedAt: time.Now(), work: work, done: done}
+	if orderedOpcodes[opcode] {
+		s.server.deferred.run(task)
+		return
+	}
+	s.server.deferred.submit(task)
+}
+
+// deferAck answers the request with the ack handle once work has built its response off the recv loop,
+// failing it if work fails. The answer is dropped if the session ended meanwhile.
+func (s *Session) deferAck(ackHandle uint32, work func() ([]byte, error)) {
+	s.deferTask(work, func(data []byte, err error) {
+		if atomic.LoadInt32(&s.disconnectReason) != 0 {
+			return
+		}
+		if err != nil {
+			s.logger.Error("Failed deferred request", zap.Error(err))
+			doAckBufFail(s, ackHandle, make([]byte, 4))
+			return
+		}
+		doAckBufSucceed(s, ackHandle, data)
+	})
+}
+
+// waitDeferred waits for the session's deferred tasks, before an ordered opcode is handled.
+func (s *Session) waitDeferred() {
+	s.deferredTasks.Wait()
+}
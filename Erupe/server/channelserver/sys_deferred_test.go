@@ -0,0 +1,159 @@
+package channelserver
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+)
+
+func TestDeferAckOffRecvLoop(t *testing.T) {
+	s := newTestServer()
+	s.deferred.start(1, 4)
+	defer s.deferred.stop()
+	session := newQueuedSession(t, s, 100, "Hunter")
+	atomic.StoreUint32(&session.handling, uint32(network.MSG_MHF_GET_TREND_WEAPON))
+
+	release := make(chan struct{})
+	session.deferAck(0x1234, func() ([]byte, error) {
+		<-release
+		return []byte{0xAB}, nil
+	})
+	if sent := sentPackets(session); len(sent) != 0 {
+		t.Fatalf("answered % X before the work was done", sent)
+	}
+	close(release)
+	session.waitDeferred()
+	if sent := sentPackets(session); !bytes.Contains(sent, []byte{0x00, 0x00, 0x12, 0x34}) || !bytes.Contains(sent, []byte{0xAB}) {
+		t.Errorf("sent % X, want the ack of 0x1234", sent)
+	}
+
+	var metrics strings.Builder
+	s.WriteMetrics(&metrics)
+	for _, want := range []string{
+		`erupe_channel_deferred_queue_depth{channel=""} 0`,
+		`erupe_channel_deferred_task_seconds_count{channel=""} 1`,
+		`erupe_channel_deferred_inline_total{channel=""} 0`,
+	} {
+		if !strings.Contains(metrics.String(), want) {
+			t.Errorf("metrics %q don't contain %q", metrics.String(), want)
+		}
+	}
+}
+
+func TestDeferInline(t *testing.T) {
+	s := newTestServer()
+	session := newQueuedSession(t, s, 100, "Hunter")
+	atomic.StoreUint32(&session.handling, uint32(network.MSG_MHF_GET_TREND_WEAPON))
+
+	// Without workers the task runs before deferAck returns.
+	session.deferAck(0x01, func() ([]byte, error) { return []byte{0xAB}, nil })
+	if len(sentPackets(session)) == 0 {
+		t.Error("not answered inline without workers")
+	}
+	if n := atomic.LoadUint64(&s.deferred.inline); n != 1 {
+		t.Errorf("%d tasks counted inline, want 1", n)
+	}
+
+	// Nor is the work of an ordered opcode deferred.
+	s.deferred.start(1, 4)
+	defer s.deferred.stop()
+	atomic.StoreUint32(&session.handling, uint32(network.MSG_SYS_LOGOUT))
+	session.deferAck(0x02, func() ([]byte, error) { return []byte{0xAB}, nil })
+	if len(sentPackets(session)) == 0 {
+		t.Error("work of an ordered opcode deferred")
+	}
+}
+
+func TestDeferredPanicFailsAck(t *testing.T) {
+	s := newTestServer()
+	s.deferred.start(1, 4)
+	defer s.deferred.stop()
+	session := newQueuedSession(t, s, 100, "Hunter")
+	atomic.StoreUint32(&session.handling, uint32(network.MSG_MHF_GET_TREND_WEAPON))
+
+	session.deferAck(0x1234, func() ([]byte, error) { panic("aggregation broke") })
+	session.waitDeferred()
+	bf := byteframe.NewByteFrameFromBytes(sentPackets(session))
+	if opcode := network.PacketID(bf.ReadUint16()); opcode != network.MSG_SYS_ACK {
+		t.Fatalf("sent %s, want MSG_SYS_ACK", opcode)
+	}
+	ackHandle := bf.ReadUint32()
+	bf.ReadUint8() // Is buffer response.
+	if errorCode := bf.ReadUint8(); ackHandle != 0x1234 || errorCode == 0 {
+		t.Errorf("ack %#x with error code %d, want a failed ack of 0x1234", ackHandle, errorCode)
+	}
+}
+
+func TestOrderedOpcodeWaitsForDeferred(t *testing.T) {
+	s := newTestServer()
+	s.deferred.start(1, 4)
+	defer s.deferred.stop()
+	session := newQueuedSession(t, s, 100, "Hunter")
+
+	var mu sync.Mutex
+	var order []string
+	record := func(step string) {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, step)
+	}
+	release := make(chan struct{})
+	s.handlers.register(network.MSG_MHF_GET_TREND_WEAPON, func(s *Session, p mhfpacket.MHFPacket) {
+		s.deferAck(p.(*mhfpacket.MsgMhfGetTrendWeapon).AckHandle, func() ([]byte, error) {
+			<-release
+			record("trends")
+			return nil, nil
+		})
+	})
+	s.handlers.register(network.MSG_MHF_UPDATE_USE_TREND_WEAPON_LOG, func(s *Session, p mhfpacket.MHFPacket) {
+		record("use")
+	})
+
+	get := byteframe.NewByteFrame()
+	get.WriteUint16(uint16(network.MSG_MHF_GET_TREND_WEAPON))
+	get.WriteUint32(0x01)
+	session.handlePacketGroup(get.Data())
+
+	use := byteframe.NewByteFrame()
+	use.WriteUint16(uint16(network.MSG_MHF_UPDATE_USE_TREND_WEAPON_LOG))
+	use.WriteUint32(0x02)
+	use.WriteUint8(3)
+	use.WriteUint16(0x3C44)
+	handled := make(chan struct{})
+	go func() {
+		session.handlePacketGroup(use.Data())
+		close(handled)
+	}()
+	select {
+	case <-handled:
+		t.Fatal("the use was handled while the trends were still being built")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-handled
+	if strings.Join(order, ",") != "trends,use" {
+		t.Errorf("handled in the order %v, want the trends before the use", order)
+	}
+}
+
+func TestBuildTrendWeapons(t *testing.T) {
+	data := buildTrendWeapons([]trendWeapon{{0, 0x3C44}, {0, 0x3C76}, {1, 0x0F20}})
+	if len(data) != trendWeaponResponseSize {
+		t.Fatalf("%d bytes, want %d", len(data), trendWeaponResponseSize)
+	}
+	if want := []byte{0x03, 0x00, 0x3C, 0x44, 0x00, 0x3C, 0x76, 0x01, 0x0F, 0x20, 0x00}; !bytes.Equal(data[:len(want)], want) {
+		t.Errorf("starts % X, want % X", data[:len(want)], want)
+	}
+
+	many := make([]trendWeapon, 100)
+	if data := buildTrendWeapons(many); len(data) != trendWeaponResponseSize || data[0] != 56 {
+		t.Errorf("%d bytes listing %d weapons, want %d listing 56", len(data), data[0], trendWeaponResponseSize)
+	}
+}
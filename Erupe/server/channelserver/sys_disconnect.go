@@ -0,0 +1,205 @@
+package channelserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/Solenataris/Erupe/network"
+	"go.uber.org/zap"
+)
+
+// DisconnectReason is why a session ended, logged with its end and counted in the metrics.
+type DisconnectReason int32
+
+// Disconnect reasons, the zero value is a session still connected.
+const (
+	DisconnectClientClosed DisconnectReason = iota + 1
+	DisconnectLogout
+	DisconnectConnectionLost
+	DisconnectIdleTimeout
+	DisconnectKicked
+	DisconnectBanned
+	DisconnectCredentials
+	DisconnectDuplicateLogin
+	DisconnectShutdown // Also a maintenance lockdown disconnecting everyone.
+	DisconnectProtocolError
+	DisconnectCryptError
+	DisconnectQueueOverflow
+	DisconnectPacketFlood
+	DisconnectQuestTimeout
+	numDisconnectReasons
+)
+
+var disconnectReasonNames = [numDisconnectReasons]string{
+	DisconnectClientClosed:   "client_closed",
+	DisconnectLogout:         "logout",
+	DisconnectConnectionLost: "connection_lost",
+	DisconnectIdleTimeout:    "idle_timeout",
+	DisconnectKicked:         "kicked",
+	DisconnectBanned:         "banned",
+	DisconnectCredentials:    "credentials_changed",
+	DisconnectDuplicateLogin: "duplicate_login",
+	DisconnectShutdown:       "server_shutdown",
+	DisconnectProtocolError:  "protocol_error",
+	DisconnectCryptError:     "crypt_error",
+	DisconnectQueueOverflow:  "queue_overflow",
+	DisconnectPacketFlood:    "packet_flood",
+	DisconnectQuestTimeout:   "quest_timeout",
+}
+
+func (r DisconnectReason) String() string {
+	if r > 0 && r < numDisconnectReasons {
+		return disconnectReasonNames[r]
+	}
+	return fmt.Sprintf("DisconnectReason(%d)", int32(r))
+}
+
+// disconnectMessages are the chat messages players get before the server disconnects them, by reason.
+// The others leave nothing to tell: the client is gone or not reading, or was warned already, as of a shutdown.
+var disconnectMessages = map[DisconnectReason]string{
+	DisconnectKicked:         "You have been disconnected by a moderator.",
+	DisconnectBanned:         "Your account has been banned.",
+	DisconnectCredentials:    "Your password was changed, please sign in again.",
+	DisconnectDuplicateLogin: "Your character signed in from another connection.",
+	DisconnectProtocolError:  "The server couldn't handle your request, please sign in again.",
+	DisconnectQuestTimeout:   "Your quest ran for too long and was ended, no rewards were given. Sign in again to return to town.",
+}
+
+// DisconnectStats counts the ended sessions of a server by reason.
+type DisconnectStats struct {
+	counts [numDisconnectReasons]uint64
+}
+
+func (d *DisconnectStats) add(reason DisconnectReason) {
+	if reason > 0 && reason < numDisconnectReasons {
+		atomic.AddUint64(&d.counts[reason], 1)
+	}
+}
+
+// Count returns how many sessions ended for the reason.
+func (d *DisconnectStats) Count(reason DisconnectReason) uint64 {
+	if reason <= 0 || reason >= numDisconnectReasons {
+		return 0
+	}
+	return atomic.LoadUint64(&d.counts[reason])
+}
+
+func (d *DisconnectStats) writeMetrics(w io.Writer, channel string) {
+	for reason := DisconnectReason(1); reason < numDisconnectReasons; reason++ {
+		fmt.Fprintf(w, "erupe_channel_disconnects_total{channel=%s,reason=\"%s\"} %d\n", channel, reason, d.Count(reason))
+	}
+}
+
+// closeWith disconnects the session for the reason once what's queued for it has been sent, telling the
+// player why first when there's a message for it and the character is in game. Only the first reason a
+// session is closed with sticks, closing it again does nothing. The recv loop then ends the session and
+// runs the usual logout.
+func (s *Session) closeWith(reason DisconnectReason) {
+	if !atomic.CompareAndSwapInt32(&s.disconnectReason, 0, int32(reason)) {
+		return
+	}
+	if reason == DisconnectQueueOverflow || reason == DisconnectPacketFlood || reason == DisconnectLogout || reason == DisconnectShutdown {
+		// Nothing queued is getting through, the client isn't worth sending more, or the client or the
+		// channel is on its way out.
+		s.rawConn.Close()
+		return
+	}
+	if message, ok := disconnectMessages[reason]; ok && s.charID != 0 {
+		sendServerChatMessage(s, message)
+	}
+	// A nil packet makes the send loop close the connection once the queue has been flushed.
+	select {
+	case s.sendPackets <- nil:
+	default:
+		s.rawConn.Close()
+	}
+}
+
+// readErrorReason is the reason for a session whose connection failed to read with err, when the
+// server hadn't closed it already.
+func readErrorReason(err error) DisconnectReason {
+	switch {
+	case errors.Is(err, io.EOF):
+		return DisconnectClientClosed
+	case errors.Is(err, network.ErrChecksumMismatch):
+		return DisconnectCryptError
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return DisconnectIdleTimeout
+	}
+	return DisconnectConnectionLost
+}
+
+// endSession logs why the session ended, counts it and runs the logout. A reason the server closed the
+// session with beats the one the recv loop found, such as the read error of a kicked session's closed
+// connection.
+func (s *Session) endSession(found DisconnectReason, err error) {
+	atomic.CompareAndSwapInt32(&s.disconnectReason, 0, int32(found))
+	reason := DisconnectReason(atomic.LoadInt32(&s.disconnectReason))
+	s.server.disconnects.add(reason)
+	fields := []zap.Field{zap.Stringer("reason", reason)}
+	if err != nil && reason == found && reason != DisconnectClientClosed {
+		fields = append(fields, zap.Error(err))
+	}
+	if reason == DisconnectConnectionLost || reason == DisconnectCryptError || reason == DisconnectProtocolError || reason == DisconnectQueueOverflow || reason == DisconnectPacketFlood {
+		s.logger.Warn("Session ended", fields...)
+	} else {
+		s.logger.Info("Session ended", fields...)
+	}
+	s.logBandwidthSummary()
+	s.disconnect()
+}
+
+// disconnectCharacter closes the character's session if it's on the channel.
+func (s *Server) disconnectCharacter(charID uint32, reason DisconnectReason) bool {
+	s.Lock()
+	var found *Session
+	for _, session := range s.sessions {
+		if session.charID == charID {
+			found = session
+			break
+		}
+	}
+	s.Unlock()
+	if found == nil {
+		return false
+	}
+	s.logger.Info("Disconnecting character", zap.Uint32("charID", charID), zap.Stringer("reason", reason))
+	found.closeWith(reason)
+	return true
+}
+
+// How long a duplicate login waits for an old session to send what's queued for it before its connection is cut.
+const duplicateFlushTimeout = 10 * time.Second
+
+// disconnectDuplicates closes the other sessions of the session's character on the channel, left over
+// from a client that signed in again before its old connection timed out. It returns once their logout
+// saves are written, so the session signing in doesn't load the character's data before they overwrite it.
+func (s *Server) disconnectDuplicates(session *Session) {
+	var duplicates []*Session
+	s.Lock()
+	for _, other := range s.sessions {
+		if other != session && other.charID == session.charID {
+			duplicates = append(duplicates, other)
+		}
+	}
+	s.Unlock()
+	for _, other := range duplicates {
+		s.logger.Info("Disconnecting duplicate login", zap.Uint32("charID", session.charID))
+		other.closeWith(DisconnectDuplicateLogin)
+	}
+	for _, other := range duplicates {
+		select {
+		case <-other.loggedOut:
+		case <-time.After(duplicateFlushTimeout):
+			// The old client isn't reading, cutting the connection ends its recv loop and runs the logout.
+			other.rawConn.Close()
+			<-other.loggedOut
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package channelserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/network"
+)
+
+func TestReadErrorReason(t *testing.T) {
+	tests := []struct {
+		err  error
+		want DisconnectReason
+	}{
+		{io.EOF, DisconnectClientClosed},
+		{fmt.Errorf("reading: %w", io.EOF), DisconnectClientClosed},
+		{network.ErrChecksumMismatch, DisconnectCryptError},
+		{os.ErrDeadlineExceeded, DisconnectIdleTimeout},
+		{net.ErrClosed, DisconnectConnectionLost},
+		{io.ErrUnexpectedEOF, DisconnectConnectionLost},
+		{errors.New("connection reset by peer"), DisconnectConnectionLost},
+	}
+	for _, tt := range tests {
+		if got := readErrorReason(tt.err); got != tt.want {
+			t.Errorf("readErrorReason(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestCloseWith(t *testing.T) {
+	s := newTestServer()
+	session := newQueuedSession(t, s, 1, "Alpha")
+
+	if !s.Kick(1) {
+		t.Fatal("didn't find the character to kick")
+	}
+	sent, closed := revokedWith(session)
+	if !closed || !strings.Contains(sent, disconnectMessages[DisconnectKicked]) {
+		t.Errorf("kicked session got %q and closed %v, want the kick message then a close", sent, closed)
+	}
+
+	// The read error of the connection the kick closed doesn't override why the session ended.
+	session.closeWith(DisconnectBanned)
+	if sent, closed := revokedWith(session); sent != "" || closed {
+		t.Errorf("closing again sent %q and closed %v, want nothing", sent, closed)
+	}
+	session.endSession(readErrorReason(net.ErrClosed), net.ErrClosed)
+	if n := s.disconnects.Count(DisconnectKicked); n != 1 {
+		t.Errorf("counted %d kicked sessions, want 1", n)
+	}
+	if n := s.disconnects.Count(DisconnectConnectionLost); n != 0 {
+		t.Errorf("counted %d lost connections, want 0", n)
+	}
+
+	var metrics bytes.Buffer
+	s.WriteMetrics(&metrics)
+	if want := `erupe_channel_disconnects_total{channel="",reason="kicked"} 1`; !strings.Contains(metrics.String(), want) {
+		t.Errorf("metrics %q don't contain %q", metrics.String(), want)
+	}
+}
+
+func TestDisconnectDuplicates(t *testing.T) {
+	s := newTestServer()
+	old := newQueuedSession(t, s, 1, "Alpha")
+	other := newQueuedSession(t, s, 2, "Beta")
+	current := newQueuedSession(t, s, 1, "Alpha")
+
+	done := make(chan struct{})
+	go func() {
+		s.disconnectDuplicates(current)
+		close(done)
+	}()
+	// The old session's send loop flushes the message, then its recv loop ends and runs the logout.
+	var sent string
+	var closed bool
+	for !closed {
+		var more string
+		more, closed = revokedWith(old)
+		sent += more
+	}
+	if !strings.Contains(sent, disconnectMessages[DisconnectDuplicateLogin]) {
+		t.Errorf("old session got %q, want the duplicate login message", sent)
+	}
+	select {
+	case <-done:
+		t.Fatal("the login went on before the old session's logout save")
+	case <-time.After(10 * time.Millisecond):
+	}
+	logoutPlayer(old)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the login kept waiting after the old session logged out")
+	}
+	if _, closed := revokedWith(current); closed {
+		t.Error("the session signing in was closed")
+	}
+	if _, closed := revokedWith(other); closed {
+		t.Error("another character's session was closed")
+	}
+}
@@ -0,0 +1,106 @@
+package channelserver
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/Solenataris/Erupe/common/distpayload"
+	"github.com/Solenataris/Erupe/common/itemtable"
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrDistributionUnavailable is returned when a distribution doesn't exist for the character, has passed its
+// deadline or was claimed as often as it can be.
+var ErrDistributionUnavailable = errors.New("distribution unavailable")
+
+// distributionAcceptedSQL counts the claims of a distribution d by the character in $1, or by every
+// character of its account for a distribution of the account scope. Deleted characters still count.
+const distributionAcceptedSQL = `(
+	SELECT count(*)
+	FROM distributions_accepted da
+	JOIN characters c ON c.id = da.character_id
+	WHERE da.distribution_id = d.id
+	AND (c.id = $1 OR d.scope = 'account' AND c.user_id = (SELECT user_id FROM characters WHERE id = $1))
+)`
+
+// enumerateDistributions returns the distributions of the type the character can see, newest first.
+// Those past their deadline or out of claims are left out.
+func enumerateDistributions(db *sqlx.DB, charID uint32, distType uint8) ([]ItemDist, error) {
+	var dists []ItemDist
+	err := db.Select(&dists, `
+		SELECT d.id, event_name, description, times_acceptable,
+		min_hr, max_hr, min_sr, max_sr, min_gr, max_gr,
+		`+distributionAcceptedSQL+` AS times_accepted,
+		COALESCE(EXTRACT(epoch FROM deadline)::int, 0) AS deadline
+		FROM distribution d
+		WHERE (character_id = $1 OR character_id IS NULL) AND type = $2
+		AND (deadline IS NULL OR deadline > now())
+		AND (max_claims IS NULL OR claims < max_claims)
+		ORDER BY id DESC
+	`, charID, distType)
+	return dists, err
+}
+
+// claimDistribution claims the distribution for the character and returns its payload, in one transaction.
+// The distribution's row stays locked from counting the claim to recording it, so a claim sent twice at once
+// is only granted once. A payload that fails the checks of other grants isn't claimed.
+func claimDistribution(db *sqlx.DB, items *itemtable.Table, charID uint32, distID uint32) ([]byte, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var timesAcceptable int
+	var data []byte
+	err = tx.QueryRow(`
+		UPDATE distribution SET claims = claims + 1
+		WHERE id = $1 AND (character_id = $2 OR character_id IS NULL)
+		AND (deadline IS NULL OR deadline > now())
+		AND (max_claims IS NULL OR claims < max_claims)
+		RETURNING times_acceptable, data
+	`, distID, charID).Scan(&timesAcceptable, &data)
+	if err == sql.ErrNoRows {
+		return nil, ErrDistributionUnavailable
+	} else if err != nil {
+		return nil, err
+	}
+	var accepted int
+	err = tx.QueryRow("SELECT "+distributionAcceptedSQL+" FROM distribution d WHERE d.id = $2", charID, distID).Scan(&accepted)
+	if err != nil {
+		return nil, err
+	}
+	if accepted >= timesAcceptable {
+		return nil, ErrDistributionUnavailable
+	}
+	if err = validateDistData(items, data); err != nil {
+		return nil, fmt.Errorf("distribution %d: %w", distID, err)
+	}
+	if _, err = tx.Exec("INSERT INTO distributions_accepted VALUES ($1, $2)", distID, charID); err != nil {
+		return nil, err
+	}
+	return data, tx.Commit()
+}
+
+// validateDistData checks the plain items of a distribution's payload as the items of a grant are checked,
+// against the item table or, without one, the IDs known to exist. The other types aren't known well enough
+// to check, the payload only has to be well formed for them.
+func validateDistData(items *itemtable.Table, data []byte) error {
+	entries, err := distpayload.Parse(data)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Type != distpayload.TypeItem {
+			continue
+		}
+		if err = distpayload.Validate([]distpayload.Item{entry}); err != nil {
+			return err
+		}
+		if items != nil && !items.Has(entry.ID) {
+			return fmt.Errorf("item %d isn't in the item table", entry.ID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package channelserver
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Solenataris/Erupe/common/distpayload"
+	"github.com/Solenataris/Erupe/common/itemtable"
+	"github.com/Solenataris/Erupe/common/testdb"
+)
+
+func TestValidateDistData(t *testing.T) {
+	items, err := itemtable.Parse(strings.NewReader("1,Potion\n1234,Armor Sphere\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		items *itemtable.Table
+		data  []byte
+		ok    bool
+	}{
+		{"listed item", items, distpayload.Encode([]distpayload.Item{{Type: 7, ID: 1234, Quantity: 5}}), true},
+		{"unlisted item", items, distpayload.Encode([]distpayload.Item{{Type: 7, ID: 2, Quantity: 1}}), false},
+		{"no quantity", items, distpayload.Encode([]distpayload.Item{{Type: 7, ID: 1, Quantity: 0}}), false},
+		{"no table, plausible ID", nil, distpayload.Encode([]distpayload.Item{{Type: 7, ID: 2, Quantity: 1}}), true},
+		{"other type", items, distpayload.Encode([]distpayload.Item{{Type: 10, ID: 0, Quantity: 500}}), true},
+		{"truncated", items, []byte{0x02, 0x07}, false},
+	}
+	for _, test := range tests {
+		if err := validateDistData(test.items, test.data); (err == nil) != test.ok {
+			t.Errorf("%s: got %v", test.name, err)
+		}
+	}
+}
+
+// TestClaimDistribution runs against the database in ERUPE_TEST_DB, like TestDrawGacha.
+func TestClaimDistribution(t *testing.T) {
+	db := testdb.Open(t)
+
+	var userID, charID, altID uint32
+	err := db.QueryRow("INSERT INTO users (username, password) VALUES ('distribution_test', '') RETURNING id").Scan(&userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+	for _, id := range []*uint32{&charID, &altID} {
+		err = db.QueryRow("INSERT INTO characters (user_id, is_new_character, name) VALUES ($1, false, 'dist') RETURNING id", userID).Scan(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Exec("DELETE FROM distributions_accepted WHERE character_id = $1", *id)
+		defer db.Exec("DELETE FROM characters WHERE id = $1", *id)
+	}
+
+	data := distpayload.Encode([]distpayload.Item{{Type: distpayload.TypeItem, ID: 1, Quantity: 1}})
+	insert := func(scope string, maxClaims interface{}, deadline string) uint32 {
+		var id uint32
+		err := db.QueryRow(`
+			INSERT INTO distribution (character_id, type, event_name, times_acceptable, scope, max_claims, deadline, data)
+			VALUES (NULL, 77, 'test', 1, $1, $2, now() + $3::interval, $4) RETURNING id
+		`, scope, maxClaims, deadline, data).Scan(&id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Exec("DELETE FROM distribution WHERE id = $1", id) })
+		return id
+	}
+
+	// A claim sent many times at once is granted once.
+	distID := insert("character", nil, "1 day")
+	var wg sync.WaitGroup
+	var granted, refused int
+	var mu sync.Mutex
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := claimDistribution(db, nil, charID, distID)
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				granted++
+			} else if err == ErrDistributionUnavailable {
+				refused++
+			} else {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if granted != 1 || refused != 7 {
+		t.Errorf("8 claims at once granted %d and refused %d", granted, refused)
+	}
+	if _, err := claimDistribution(db, nil, altID, distID); err != nil {
+		t.Errorf("the other character of the account couldn't claim a character distribution: %v", err)
+	}
+
+	// An account distribution counts the claims of every character of the account.
+	accountID := insert("account", nil, "1 day")
+	if _, err := claimDistribution(db, nil, charID, accountID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := claimDistribution(db, nil, altID, accountID); err != ErrDistributionUnavailable {
+		t.Errorf("a second character claimed an account distribution: %v", err)
+	}
+
+	// The cap counts everyone's claims.
+	cappedID := insert("character", 1, "1 day")
+	claimDistribution(db, nil, charID, cappedID)
+	if _, err := claimDistribution(db, nil, altID, cappedID); err != ErrDistributionUnavailable {
+		t.Errorf("a distribution past its cap was claimed: %v", err)
+	}
+
+	// An expired distribution is neither listed nor claimable.
+	expiredID := insert("character", nil, "-1 hour")
+	if _, err := claimDistribution(db, nil, charID, expiredID); err != ErrDistributionUnavailable {
+		t.Errorf("an expired distribution was claimed: %v", err)
+	}
+	dists, err := enumerateDistributions(db, altID, 77)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed := make(map[uint32]uint16)
+	for _, dist := range dists {
+		listed[dist.ID] = dist.TimesAccepted
+	}
+	if _, ok := listed[expiredID]; ok {
+		t.Error("an expired distribution was listed")
+	}
+	if _, ok := listed[cappedID]; ok {
+		t.Error("a distribution past its cap was listed")
+	}
+	if listed[accountID] != 1 || listed[distID] != 1 {
+		t.Errorf("listed the claims as %v", listed)
+	}
+}
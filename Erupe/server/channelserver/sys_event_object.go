@@ -0,0 +1,164 @@
+package channelserver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"go.uber.org/zap"
+)
+
+// EventObject is a temporary, server owned object spawned by an admin for community events.
+type EventObject struct {
+	// Unique key used to record claims, stays valid across object ID reuse.
+	key     string
+	name    string
+	objID   uint32
+	stageID string
+	expires time.Time
+
+	// Optional reward handed out once per character on interaction.
+	reward []DistItemEntry
+	titles []uint16
+
+	// Removes the object once it expires. Guarded by the stage lock.
+	timer *time.Timer
+}
+
+// SpawnEventObject creates a temporary object in the given stage and broadcasts it to everyone inside.
+// The object is removed automatically once the duration elapses.
+func (s *Server) SpawnEventObject(stageID string, name string, x, y, z float32, duration time.Duration, reward []DistItemEntry, titles []uint16) (*EventObject, error) {
+	stage, exists := s.GetStage(stageID)
+	if !exists {
+		return nil, fmt.Errorf("stage %s does not exist", stageID)
+	}
+
+	stage.Lock()
+	objID := stage.GetNewObjectID(0)
+	stage.objects[objID] = &StageObject{
+		id: objID,
+		x:  x,
+		y:  y,
+		z:  z,
+	}
+	now := time.Now()
+	eventObj := &EventObject{
+		key:     fmt.Sprintf("%s-%d", name, now.UnixNano()),
+		name:    name,
+		objID:   objID,
+		stageID: stageID,
+		expires: now.Add(duration),
+		reward:  reward,
+		titles:  titles,
+	}
+	// The timer is set before the object can be found, so a removal never sees it unset.
+	eventObj.timer = time.AfterFunc(duration, func() {
+		s.removeEventObject(stageID, objID, eventObj)
+	})
+	stage.eventObjects[objID] = eventObj
+	stage.broadcastMHFLocked(&mhfpacket.MsgSysDuplicateObject{
+		ObjID: objID,
+		X:     x,
+		Y:     y,
+		Z:     z,
+	}, nil)
+	stage.Unlock()
+
+	s.logger.Info("Spawned event object",
+		zap.String("name", name),
+		zap.String("stageID", stageID),
+		zap.Uint32("objID", objID),
+		zap.Duration("duration", duration),
+	)
+	return eventObj, nil
+}
+
+// RemoveEventObject deletes an event object from its stage and tells the clients to drop it.
+func (s *Server) RemoveEventObject(stageID string, objID uint32) bool {
+	return s.removeEventObject(stageID, objID, nil)
+}
+
+// removeEventObject removes the object with the ID, only if it's still the given one when that isn't nil.
+// An expiry timer firing as the object is removed must leave one that reused its ID alone.
+func (s *Server) removeEventObject(stageID string, objID uint32, only *EventObject) bool {
+	stage, exists := s.GetStage(stageID)
+	if !exists {
+		return false
+	}
+
+	stage.Lock()
+	defer stage.Unlock()
+	eventObj, exists := stage.eventObjects[objID]
+	if !exists || (only != nil && eventObj != only) {
+		return false
+	}
+	eventObj.timer.Stop()
+	delete(stage.eventObjects, objID)
+	delete(stage.objects, objID)
+
+	// Free up the object slot, see Stage.GetNewObjectID for the ID layout.
+	if slot, ok := stage.objectList[uint8(objID>>16)]; ok {
+		slot.status = false
+		slot.charid = 0
+	}
+
+	stage.broadcastMHFLocked(&mhfpacket.MsgSysDeleteObject{ObjID: objID}, nil)
+	return true
+}
+
+// claimEventObject hands out the object's reward to the session's character if it hasn't claimed it yet.
+// Claims are recorded with a unique key so retried interactions can't grant the reward twice.
+func claimEventObject(s *Session, eventObj *EventObject) {
+	if len(eventObj.reward) == 0 && len(eventObj.titles) == 0 {
+		return
+	}
+	if time.Now().After(eventObj.expires) {
+		return
+	}
+
+	tx, err := s.server.db.Beginx()
+	if err != nil {
+		s.logger.Error("Failed to begin event object claim", zap.Error(err))
+		return
+	}
+	res, err := tx.Exec(`
+		INSERT INTO event_object_claims (object_key, character_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, eventObj.key, s.charID)
+	if err != nil {
+		tx.Rollback()
+		s.logger.Error("Failed to record event object claim", zap.Error(err))
+		return
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		tx.Rollback()
+		sendServerChatMessage(s, fmt.Sprintf("You already found %s!", eventObj.name))
+		return
+	}
+
+	if len(eventObj.reward) > 0 {
+		err = createCharacterDistribution(tx, s.charID, eventObj.name, fmt.Sprintf("~C05You found %s!", eventObj.name), eventObj.reward)
+		if err != nil {
+			tx.Rollback()
+			s.logger.Error("Failed to create event object reward", zap.Error(err))
+			return
+		}
+	}
+	for _, titleID := range eventObj.titles {
+		if err = grantTitle(tx, s.charID, titleID); err != nil {
+			tx.Rollback()
+			s.logger.Error("Failed to grant event object title", zap.Error(err), zap.Uint16("titleID", titleID))
+			return
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		s.logger.Error("Failed to commit event object claim", zap.Error(err))
+		return
+	}
+	if len(eventObj.reward) > 0 {
+		sendServerChatMessage(s, fmt.Sprintf("You found %s! Your reward is waiting in the distribution box.", eventObj.name))
+	} else {
+		sendServerChatMessage(s, fmt.Sprintf("You found %s! Check your titles.", eventObj.name))
+	}
+}
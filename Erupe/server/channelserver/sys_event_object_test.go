@@ -0,0 +1,131 @@
+package channelserver
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/common/testdb"
+)
+
+func TestEventObjectSpawnRemoveRace(t *testing.T) {
+	s := newTestServer()
+	stageID := "sl1Ns200p0a0u0"
+	s.GetOrCreateStage(stageID)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				eventObj, err := s.SpawnEventObject(stageID, "Egg", 0, 0, 0, time.Millisecond, nil, nil)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				s.RemoveEventObject(stageID, eventObj.objID)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The expiry timers that fired before their removal raced it, wait them out.
+	time.Sleep(10 * time.Millisecond)
+	stage, _ := s.GetStage(stageID)
+	stage.RLock()
+	defer stage.RUnlock()
+	if len(stage.eventObjects) != 0 {
+		t.Errorf("%d event objects were left behind", len(stage.eventObjects))
+	}
+}
+
+func TestParseEventRewards(t *testing.T) {
+	items, titles, err := parseEventRewards("7:1234:2,title:120,7:5:1")
+	if err != nil || len(items) != 2 || items[0].ItemID != 1234 || items[0].Quantity != 2 || items[1].ItemID != 5 {
+		t.Errorf("got items %+v, %v", items, err)
+	}
+	if len(titles) != 1 || titles[0] != 120 {
+		t.Errorf("got titles %v", titles)
+	}
+	if items, titles, err := parseEventRewards("none"); items != nil || titles != nil || err != nil {
+		t.Errorf("none got %+v, %v, %v", items, titles, err)
+	}
+	for _, arg := range []string{"title:", "title:70000", "7:1234", "potion"} {
+		if _, _, err := parseEventRewards(arg); err == nil {
+			t.Errorf("%q was accepted", arg)
+		}
+	}
+}
+
+func TestBuildTitleList(t *testing.T) {
+	acquired := time.Unix(1700000000, 0)
+	data := buildTitleList(map[uint16]time.Time{3: acquired, 200: acquired, 150: acquired})
+
+	count := int(binary.BigEndian.Uint16(data))
+	if count != defaultTitleCount+2 || len(data) != 4+count*12 {
+		t.Fatalf("got %d titles in %d bytes", count, len(data))
+	}
+	entry := func(i int) (uint16, uint32) {
+		offset := 4 + i*12
+		return binary.BigEndian.Uint16(data[offset:]), binary.BigEndian.Uint32(data[offset+4:])
+	}
+	for i, want := range map[int]struct {
+		id       uint16
+		acquired uint32
+	}{
+		0:                     {0, 0},
+		3:                     {3, uint32(acquired.Unix())},
+		defaultTitleCount - 1: {defaultTitleCount - 1, 0},
+		defaultTitleCount:     {150, uint32(acquired.Unix())},
+		defaultTitleCount + 1: {200, uint32(acquired.Unix())},
+	} {
+		if id, at := entry(i); id != want.id || at != want.acquired {
+			t.Errorf("entry %d is title %d acquired at %d, want %d at %d", i, id, at, want.id, want.acquired)
+		}
+	}
+}
+
+// TestClaimEventObject runs against the database in ERUPE_TEST_DB, like TestRedeemCampaignCode.
+func TestClaimEventObject(t *testing.T) {
+	db := testdb.Open(t)
+
+	var userID, charID uint32
+	if err := db.QueryRow("INSERT INTO users (username, password) VALUES ('event_object_test', '') RETURNING id").Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+	err := db.QueryRow("INSERT INTO characters (user_id, is_new_character, name) VALUES ($1, false, 'eventobj') RETURNING id", userID).Scan(&charID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM characters WHERE id = $1", charID)
+	defer db.Exec("DELETE FROM distribution WHERE character_id = $1", charID)
+	defer db.Exec("DELETE FROM character_titles WHERE character_id = $1", charID)
+	defer db.Exec("DELETE FROM event_object_claims WHERE character_id = $1", charID)
+
+	s := newTestServer()
+	s.db = db
+	stageID := "sl1Ns200p0a0u0"
+	s.GetOrCreateStage(stageID)
+	eventObj, err := s.SpawnEventObject(stageID, "Golden Egg", 0, 0, 0, time.Minute, []DistItemEntry{{ItemType: 7, ItemID: 1, Quantity: 1}}, []uint16{150})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.RemoveEventObject(stageID, eventObj.objID)
+	session := newQueuedSession(t, s, charID, "eventobj")
+
+	claimEventObject(session, eventObj)
+	claimEventObject(session, eventObj)
+
+	var dists int
+	db.QueryRow("SELECT count(*) FROM distribution WHERE character_id = $1", charID).Scan(&dists)
+	if dists != 1 {
+		t.Errorf("claiming twice queued %d distributions", dists)
+	}
+	titles, err := loadTitles(db, charID)
+	if _, ok := titles[150]; err != nil || !ok || len(titles) != 1 {
+		t.Errorf("claiming granted titles %v, %v", titles, err)
+	}
+}
@@ -0,0 +1,408 @@
+package channelserver
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"go.uber.org/zap"
+)
+
+// FestaTeam is the festa team a guild is assigned to.
+type FestaTeam uint8
+
+const (
+	FestaTeamNone FestaTeam = iota
+	FestaTeamBlue
+	FestaTeamRed
+)
+
+// Festa guild change modes, see config.Festa.
+const (
+	festaGuildChangeKeep    = "keep"
+	festaGuildChangeExclude = "exclude"
+)
+
+// festaPeriod parses the time the festa registration closes and the time the festa ends.
+func festaPeriod(cfg config.Festa) (registrationEnd time.Time, end time.Time, err error) {
+	registrationEnd, err = time.Parse(time.RFC3339, cfg.RegistrationEnd)
+	if err != nil {
+		return
+	}
+	end, err = time.Parse(time.RFC3339, cfg.End)
+	return
+}
+
+// festaGuild is a guild taking part in the team assignment.
+type festaGuild struct {
+	id      uint32
+	members int
+}
+
+// assignFestaTeams splits the guilds into two teams with member counts as even as possible.
+// Larger guilds are placed first, ties are broken by guild ID so every channel agrees.
+func assignFestaTeams(guilds []festaGuild) map[uint32]FestaTeam {
+	sorted := make([]festaGuild, len(guilds))
+	copy(sorted, guilds)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].members != sorted[j].members {
+			return sorted[i].members > sorted[j].members
+		}
+		return sorted[i].id < sorted[j].id
+	})
+
+	teams := make(map[uint32]FestaTeam, len(sorted))
+	var blue, red int
+	for _, guild := range sorted {
+		if blue <= red {
+			teams[guild.id] = FestaTeamBlue
+			blue += guild.members
+		} else {
+			teams[guild.id] = FestaTeamRed
+			red += guild.members
+		}
+	}
+	return teams
+}
+
+// festaMembership is the guild a character was in when the teams were assigned and the guild it is in now.
+type festaMembership struct {
+	assignedGuild    uint32
+	assignedTeam     FestaTeam
+	currentGuild     uint32
+	currentGuildTeam FestaTeam
+}
+
+// team resolves the character's festa team, applying the guild change mode if
+// the character is no longer in the guild it had when the teams were assigned.
+func (m festaMembership) team(guildChangeMode string) FestaTeam {
+	if m.assignedGuild == m.currentGuild {
+		return m.assignedTeam
+	}
+	if guildChangeMode != festaGuildChangeKeep {
+		return FestaTeamNone
+	}
+	if m.assignedTeam != FestaTeamNone {
+		return m.assignedTeam
+	}
+	// Wasn't in a guild when the teams were assigned, so there's no original team to keep.
+	return m.currentGuildTeam
+}
+
+// scheduleFesta assigns the guild teams when registration closes and clears the team flags when the festa ends.
+func (s *Server) scheduleFesta() {
+	cfg := s.erupeConfig.Festa
+	if !cfg.Enabled {
+		return
+	}
+	registrationEnd, end, err := festaPeriod(cfg)
+	if err != nil {
+		s.logger.Error("Invalid festa schedule", zap.Error(err))
+		return
+	}
+	s.festaRegistrationEnd = registrationEnd
+	s.festaEnd = end
+
+	now := time.Now()
+	if now.After(end) {
+		return
+	}
+	if now.Before(registrationEnd) {
+		s.festaTimers = append(s.festaTimers, time.AfterFunc(registrationEnd.Sub(now), func() {
+			s.assignFestaTeamsOnce()
+			s.notifyFestaTeamsChanged()
+		}))
+	} else {
+		s.assignFestaTeamsOnce()
+	}
+	s.festaTimers = append(s.festaTimers, time.AfterFunc(end.Sub(now), s.notifyFestaTeamsChanged))
+}
+
+// stopFesta cancels the pending festa timers.
+func (s *Server) stopFesta() {
+	for _, timer := range s.festaTimers {
+		timer.Stop()
+	}
+}
+
+// festaActive reports whether the teams have been assigned and the festa hasn't ended yet, nor is held
+// back by a content gate.
+func (s *Server) festaActive() bool {
+	now := time.Now()
+	return s.erupeConfig.Festa.Enabled && now.After(s.festaRegistrationEnd) && now.Before(s.festaEnd) && !s.featureGated("festa")
+}
+
+// assignFestaTeamsOnce stores the team of every guild and the guild membership of its characters,
+// unless the teams for this festa were already assigned.
+func (s *Server) assignFestaTeamsOnce() {
+	festaID := s.erupeConfig.Festa.ID
+	tx, err := s.db.Beginx()
+	if err != nil {
+		s.logger.Error("Failed to begin festa team assignment", zap.Error(err))
+		return
+	}
+	defer tx.Rollback()
+
+	var assigned bool
+	err = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM festa_guild_teams WHERE festa_id = $1)", festaID).Scan(&assigned)
+	if err != nil {
+		s.logger.Error("Failed to check festa team assignment", zap.Error(err))
+		return
+	}
+	if assigned {
+		return
+	}
+
+	rows, err := tx.Query("SELECT g.id, COUNT(gc.id) FROM guilds g LEFT JOIN guild_characters gc ON gc.guild_id = g.id GROUP BY g.id")
+	if err != nil {
+		s.logger.Error("Failed to get guilds for festa team assignment", zap.Error(err))
+		return
+	}
+	var guilds []festaGuild
+	for rows.Next() {
+		var guild festaGuild
+		if err = rows.Scan(&guild.id, &guild.members); err != nil {
+			rows.Close()
+			s.logger.Error("Failed to read guild for festa team assignment", zap.Error(err))
+			return
+		}
+		guilds = append(guilds, guild)
+	}
+	rows.Close()
+
+	for guildID, team := range assignFestaTeams(guilds) {
+		_, err = tx.Exec("INSERT INTO festa_guild_teams (festa_id, guild_id, team) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING", festaID, guildID, team)
+		if err != nil {
+			s.logger.Error("Failed to store festa guild team", zap.Error(err))
+			return
+		}
+	}
+	_, err = tx.Exec(`
+		INSERT INTO festa_character_teams (festa_id, character_id, guild_id, team)
+		SELECT ft.festa_id, gc.character_id, gc.guild_id, ft.team
+		FROM guild_characters gc
+		JOIN festa_guild_teams ft ON ft.guild_id = gc.guild_id
+		WHERE ft.festa_id = $1
+		ON CONFLICT DO NOTHING
+	`, festaID)
+	if err != nil {
+		s.logger.Error("Failed to store festa character teams", zap.Error(err))
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		s.logger.Error("Failed to commit festa team assignment", zap.Error(err))
+		return
+	}
+	s.logger.Info("Assigned festa teams", zap.Int("festaID", festaID), zap.Int("guilds", len(guilds)))
+}
+
+// festaTeamFor returns the festa team shown above the character, or FestaTeamNone outside the festa.
+// The team of a character in the stage is kept on its session, clients ask for it every time they
+// draw the character.
+func (s *Server) festaTeamFor(stage *Stage, charID uint32) FestaTeam {
+	if !s.festaActive() {
+		return FestaTeamNone
+	}
+	var member *Session
+	stage.RLock()
+	for session, id := range stage.clients {
+		if id == charID {
+			member = session
+			break
+		}
+	}
+	stage.RUnlock()
+	if member != nil {
+		member.Lock()
+		team, cached := member.festaTeam, member.festaTeamCached
+		member.Unlock()
+		if cached {
+			return team
+		}
+	}
+
+	team, err := s.loadFestaTeam(charID)
+	if err != nil {
+		s.logger.Error("Failed to get festa team", zap.Error(err), zap.Uint32("charID", charID))
+		return FestaTeamNone
+	}
+	if member != nil {
+		member.Lock()
+		member.festaTeam, member.festaTeamCached = team, true
+		member.Unlock()
+	}
+	return team
+}
+
+// clearFestaTeam drops the festa team kept on the character's session, after it changed guild.
+func (s *Server) clearFestaTeam(charID uint32) {
+	if session := s.FindSessionByCharID(charID); session != nil {
+		session.Lock()
+		session.festaTeamCached = false
+		session.Unlock()
+	}
+}
+
+// loadFestaTeam reads the character's festa team from its guild and the guild it had when the teams were assigned.
+func (s *Server) loadFestaTeam(charID uint32) (FestaTeam, error) {
+	var m festaMembership
+	err := s.db.QueryRow(`
+		SELECT COALESCE(fct.guild_id, 0), COALESCE(fct.team, 0), COALESCE(gc.guild_id, 0), COALESCE(fgt.team, 0)
+		FROM characters c
+		LEFT JOIN festa_character_teams fct ON fct.character_id = c.id AND fct.festa_id = $1
+		LEFT JOIN guild_characters gc ON gc.character_id = c.id
+		LEFT JOIN festa_guild_teams fgt ON fgt.guild_id = gc.guild_id AND fgt.festa_id = $1
+		WHERE c.id = $2
+	`, s.erupeConfig.Festa.ID, charID).Scan(&m.assignedGuild, &m.assignedTeam, &m.currentGuild, &m.currentGuildTeam)
+	if err != nil {
+		return FestaTeamNone, err
+	}
+	return m.team(s.erupeConfig.Festa.GuildChangeMode), nil
+}
+
+// notifyFestaTeamsChanged makes clients in hub stages re-fetch the display data of everyone
+// around them, so the team flags appear and disappear without having to change stage.
+func (s *Server) notifyFestaTeamsChanged() {
+	s.stagesLock.RLock()
+	defer s.stagesLock.RUnlock()
+	for stageID, stage := range s.stages {
+		if isQuestStageID(stageID) {
+			continue
+		}
+		stage.RLock()
+		for session := range stage.clients {
+			session.Lock()
+			session.festaTeamCached = false
+			session.Unlock()
+			stage.broadcastMHFLocked(&mhfpacket.MsgSysNotifyUserBinary{
+				CharID:     session.charID,
+				BinaryType: 3,
+			}, session)
+		}
+		stage.RUnlock()
+	}
+}
+
+// earnFestaSouls credits the souls of the festa quest the session's character returned from, which its
+// charges draw on.
+func earnFestaSouls(s *Session, filename string) {
+	souls := 0
+	for _, quest := range s.server.erupeConfig.Festa.SoulQuests {
+		if quest.Quest == filename {
+			souls = quest.Souls
+		}
+	}
+	if souls <= 0 || !s.server.festaActive() {
+		return
+	}
+	_, err := s.server.db.Exec(`
+		INSERT INTO festa_soul_earnings (festa_id, character_id, earned) VALUES ($1, $2, $3)
+		ON CONFLICT (festa_id, character_id) DO UPDATE SET earned = festa_soul_earnings.earned + $3
+	`, s.server.erupeConfig.Festa.ID, s.charID, souls)
+	if err != nil {
+		s.logger.Error("Failed to credit festa souls", zap.Error(err))
+	}
+}
+
+// chargeFestaSouls takes the souls the client charges off those the session's character earned and hasn't
+// charged yet, returning how many were taken. What the client claims past them is refused.
+func chargeFestaSouls(s *Session, souls int) (int, error) {
+	if souls <= 0 || !s.server.festaActive() {
+		return 0, nil
+	}
+	var charged int
+	err := s.server.db.QueryRow(`
+		WITH held AS (
+			SELECT earned - charged AS souls FROM festa_soul_earnings WHERE festa_id = $1 AND character_id = $2 FOR UPDATE
+		)
+		UPDATE festa_soul_earnings e SET charged = e.charged + LEAST($3, held.souls) FROM held
+		WHERE e.festa_id = $1 AND e.character_id = $2 RETURNING LEAST($3, held.souls)
+	`, s.server.erupeConfig.Festa.ID, s.charID, souls).Scan(&charged)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	if charged < souls {
+		s.logger.Warn("Refused festa souls the character didn't earn", zap.Int("souls", souls), zap.Int("charged", charged))
+	}
+	return charged, nil
+}
+
+// festaChargeValid reports whether a charge names the running festa and the character's guild, with a team in
+// it. Both come from the client, the souls would otherwise go to whatever festa and guild it names.
+func festaChargeValid(s *Session, festaID uint32, guildID uint32) (bool, error) {
+	if festaID != uint32(s.server.erupeConfig.Festa.ID) {
+		return false, nil
+	}
+	var valid bool
+	err := s.server.db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM guild_characters gc
+			JOIN festa_guild_teams ft ON ft.guild_id = gc.guild_id AND ft.festa_id = $1
+			WHERE gc.character_id = $2 AND gc.guild_id = $3
+		)
+	`, s.server.erupeConfig.Festa.ID, s.charID, guildID).Scan(&valid)
+	return valid, err
+}
+
+// addFestaSouls credits the souls the session's character charged to its guild's festa standing,
+// if the guild has a team in the running festa.
+func addFestaSouls(s *Session, souls int) {
+	if souls <= 0 || !s.server.festaActive() {
+		return
+	}
+	_, err := s.server.db.Exec(`
+		INSERT INTO festa_souls (festa_id, guild_id, character_id, souls)
+		SELECT ft.festa_id, gc.guild_id, gc.character_id, $3
+		FROM guild_characters gc
+		JOIN festa_guild_teams ft ON ft.guild_id = gc.guild_id AND ft.festa_id = $1
+		WHERE gc.character_id = $2
+		ON CONFLICT (festa_id, guild_id, character_id) DO UPDATE SET souls = festa_souls.souls + EXCLUDED.souls
+	`, s.server.erupeConfig.Festa.ID, s.charID, souls)
+	if err != nil {
+		s.logger.Error("Failed to add festa souls", zap.Error(err))
+	}
+}
+
+// festaGuildStanding is the souls a guild charged in the running festa and its rank among the guilds
+// taking part, 0 for a guild that charged none.
+type festaGuildStanding struct {
+	Souls uint32 `db:"souls"`
+	Rank  uint32 `db:"rank"`
+}
+
+// getFestaGuildStanding returns the standing of the session's character's guild in the running festa.
+func getFestaGuildStanding(s *Session) (festaGuildStanding, error) {
+	var standing festaGuildStanding
+	err := s.server.db.Get(&standing, `
+		SELECT souls, rank FROM (
+			SELECT guild_id, SUM(souls) AS souls, RANK() OVER (ORDER BY SUM(souls) DESC) AS rank
+			FROM festa_souls WHERE festa_id = $1 GROUP BY guild_id
+		) standings
+		WHERE guild_id = (SELECT guild_id FROM guild_characters WHERE character_id = $2)
+	`, s.server.erupeConfig.Festa.ID, s.charID)
+	if err == sql.ErrNoRows {
+		return festaGuildStanding{}, nil
+	}
+	return standing, err
+}
+
+// buildFestaGuildState serializes the MSG_MHF_STATE_FESTA_G response. The rank placement is a guess,
+// 0xFFFFFFFF showing as unranked.
+func buildFestaGuildState(standing festaGuildStanding) []byte {
+	rank := uint32(0xFFFFFFFF)
+	if standing.Rank > 0 {
+		rank = standing.Rank
+	}
+	resp := byteframe.NewByteFrame()
+	resp.WriteUint32(standing.Souls)
+	resp.WriteUint32(0)
+	resp.WriteUint32(rank)
+	resp.WriteUint32(0)
+	resp.WriteBytes([]byte{0x00, 0x00, 0x00}) // Not parsed.
+	resp.WriteUint8(0)
+	return resp.Data()
+}
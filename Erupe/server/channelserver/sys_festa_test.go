@@ -0,0 +1,178 @@
+package channelserver
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/common/testdb"
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+)
+
+func TestFestaTeamGuildChangeModes(t *testing.T) {
+	tests := []struct {
+		name        string
+		membership  festaMembership
+		wantKeep    FestaTeam
+		wantExclude FestaTeam
+	}{
+		{
+			name:        "same guild",
+			membership:  festaMembership{assignedGuild: 1, assignedTeam: FestaTeamRed, currentGuild: 1, currentGuildTeam: FestaTeamRed},
+			wantKeep:    FestaTeamRed,
+			wantExclude: FestaTeamRed,
+		},
+		{
+			name:        "moved to guild on the other team",
+			membership:  festaMembership{assignedGuild: 1, assignedTeam: FestaTeamRed, currentGuild: 2, currentGuildTeam: FestaTeamBlue},
+			wantKeep:    FestaTeamRed,
+			wantExclude: FestaTeamNone,
+		},
+		{
+			name:        "left guild",
+			membership:  festaMembership{assignedGuild: 1, assignedTeam: FestaTeamBlue},
+			wantKeep:    FestaTeamBlue,
+			wantExclude: FestaTeamNone,
+		},
+		{
+			name:        "joined guild after assignment",
+			membership:  festaMembership{currentGuild: 2, currentGuildTeam: FestaTeamBlue},
+			wantKeep:    FestaTeamBlue,
+			wantExclude: FestaTeamNone,
+		},
+		{
+			name:       "never in a guild",
+			membership: festaMembership{},
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.membership.team(festaGuildChangeKeep); got != tt.wantKeep {
+			t.Errorf("%s: keep mode got team %d, want %d", tt.name, got, tt.wantKeep)
+		}
+		if got := tt.membership.team(festaGuildChangeExclude); got != tt.wantExclude {
+			t.Errorf("%s: exclude mode got team %d, want %d", tt.name, got, tt.wantExclude)
+		}
+	}
+}
+
+func TestAssignFestaTeamsBalancesMembers(t *testing.T) {
+	guilds := []festaGuild{{1, 10}, {2, 30}, {3, 20}, {4, 5}, {5, 5}}
+	teams := assignFestaTeams(guilds)
+
+	members := map[FestaTeam]int{}
+	for _, guild := range guilds {
+		team, ok := teams[guild.id]
+		if !ok || team == FestaTeamNone {
+			t.Fatalf("guild %d was not assigned a team", guild.id)
+		}
+		members[team] += guild.members
+	}
+	if members[FestaTeamBlue] != 35 || members[FestaTeamRed] != 35 {
+		t.Errorf("got %d blue and %d red members, want 35 each", members[FestaTeamBlue], members[FestaTeamRed])
+	}
+}
+
+func TestBuildFestaGuildState(t *testing.T) {
+	ranked := buildFestaGuildState(festaGuildStanding{Souls: 1200, Rank: 2})
+	if want := []byte{0, 0, 0x04, 0xB0, 0, 0, 0, 0, 0, 0, 0, 2}; !bytes.Equal(ranked[:12], want) {
+		t.Errorf("ranked guild starts % X, want % X", ranked[:12], want)
+	}
+	unranked := buildFestaGuildState(festaGuildStanding{})
+	if want := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF}; !bytes.Equal(unranked[:12], want) {
+		t.Errorf("guild without souls starts % X, want % X", unranked[:12], want)
+	}
+	if len(ranked) != 20 || len(unranked) != 20 {
+		t.Errorf("got %d and %d bytes, want 20", len(ranked), len(unranked))
+	}
+}
+
+func TestFestaTeamKeptOnSession(t *testing.T) {
+	s := newTestServer()
+	s.erupeConfig.Festa = config.Festa{Enabled: true, TeamFlagOffset: 2}
+	s.festaRegistrationEnd = time.Now().Add(-time.Hour)
+	s.festaEnd = time.Now().Add(time.Hour)
+	viewer := newQueuedSession(t, s, 1, "Alpha")
+	member := newQueuedSession(t, s, 2, "Beta")
+	stage := moveToStage(t, viewer, "sl1Ns200p0a0u0")
+	moveToStage(t, member, "sl1Ns200p0a0u0")
+
+	// The team kept on the session answers without the database, which the test server doesn't have.
+	member.festaTeam, member.festaTeamCached = FestaTeamRed, true
+	if team := s.festaTeamFor(stage, member.charID); team != FestaTeamRed {
+		t.Errorf("got team %d, want the one kept on the session", team)
+	}
+	s.userBinaryParts[userBinaryPartID{member.charID, 3}] = []byte{1, 2, 3, 4}
+	handleMsgSysGetUserBinary(viewer, &mhfpacket.MsgSysGetUserBinary{AckHandle: 1, CharID: member.charID, BinaryType: 3})
+	if sent := sentPackets(viewer); !bytes.Contains(sent, []byte{1, 2, byte(FestaTeamRed), 4}) {
+		t.Errorf("the display binary wasn't patched at the configured offset: %x", sent)
+	}
+
+	// Without an offset the binary is sent as the client saved it.
+	s.erupeConfig.Festa.TeamFlagOffset = 0
+	handleMsgSysGetUserBinary(viewer, &mhfpacket.MsgSysGetUserBinary{AckHandle: 1, CharID: member.charID, BinaryType: 3})
+	if sent := sentPackets(viewer); !bytes.Contains(sent, []byte{1, 2, 3, 4}) {
+		t.Errorf("the display binary was patched without an offset: %x", sent)
+	}
+
+	s.clearFestaTeam(member.charID)
+	if member.festaTeamCached {
+		t.Error("a guild change left the team on the session")
+	}
+}
+
+func TestChargeFestaRefusesAnotherFesta(t *testing.T) {
+	s := newTestServer()
+	s.erupeConfig.Festa = config.Festa{Enabled: true, ID: 9001}
+	session := newQueuedSession(t, s, 1, "Alpha")
+
+	// The server has no database, checking the guild or charging the souls would panic.
+	handleMsgMhfChargeFesta(session, &mhfpacket.MsgMhfChargeFesta{AckHandle: 1, FestaID: 9000, GuildID: 1, Souls: 5})
+	if sent := sentPackets(session); len(sent) == 0 {
+		t.Error("the refused charge wasn't acked")
+	}
+}
+
+// TestChargeFestaSouls runs against the database in ERUPE_TEST_DB, like TestClaimEventObject.
+func TestChargeFestaSouls(t *testing.T) {
+	db := testdb.Open(t)
+
+	var userID, charID uint32
+	if err := db.QueryRow("INSERT INTO users (username, password) VALUES ('festa_souls_test', '') RETURNING id").Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+	err := db.QueryRow("INSERT INTO characters (user_id, is_new_character, name) VALUES ($1, false, 'festasouls') RETURNING id", userID).Scan(&charID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM characters WHERE id = $1", charID)
+	defer db.Exec("DELETE FROM festa_soul_earnings WHERE character_id = $1", charID)
+
+	s := newTestServer()
+	s.db = db
+	s.erupeConfig.Festa = config.Festa{Enabled: true, ID: 9001, SoulQuests: []config.FestaSoulQuest{{Quest: "23045d0", Souls: 5}}}
+	s.festaRegistrationEnd = time.Now().Add(-time.Hour)
+	s.festaEnd = time.Now().Add(time.Hour)
+	session := newQueuedSession(t, s, charID, "festasouls")
+
+	if souls, err := chargeFestaSouls(session, 10); err != nil || souls != 0 {
+		t.Errorf("charging before earning any took %d, %v", souls, err)
+	}
+	earnFestaSouls(session, "23045d0")
+	earnFestaSouls(session, "21731d0")
+
+	// The character has no guild, a charge naming one or another festa is refused and charges nothing.
+	handleMsgMhfChargeFesta(session, &mhfpacket.MsgMhfChargeFesta{AckHandle: 1, FestaID: 9001, GuildID: 1, Souls: 5})
+	handleMsgMhfChargeFesta(session, &mhfpacket.MsgMhfChargeFesta{AckHandle: 2, FestaID: 9000, GuildID: 0, Souls: 5})
+	sentPackets(session)
+	if souls, err := chargeFestaSouls(session, 3); err != nil || souls != 3 {
+		t.Errorf("charging 3 of 5 earned took %d, %v", souls, err)
+	}
+	if souls, err := chargeFestaSouls(session, 100); err != nil || souls != 2 {
+		t.Errorf("charging past the souls earned took %d, %v, want 2", souls, err)
+	}
+	if souls, err := chargeFestaSouls(session, 1); err != nil || souls != 0 {
+		t.Errorf("charging once every soul was took %d, %v", souls, err)
+	}
+}
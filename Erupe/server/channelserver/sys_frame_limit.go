@@ -0,0 +1,71 @@
+package channelserver
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"go.uber.org/zap"
+)
+
+// Bytes a buffer ack adds around its payload on the wire: the opcode, the ack header with
+// the extended size field and the trailing MSG_SYS_END.
+const ackFrameOverhead = 2 + 4 + 1 + 1 + 2 + 4 + 2
+
+// FrameLimitStats counts, per request opcode, the responses that ran past a single frame.
+// All counters are updated atomically.
+type FrameLimitStats struct {
+	oversized [numPacketIDs]uint64
+	clipped   [numPacketIDs]uint64
+}
+
+func (f *FrameLimitStats) addOversized(opcode network.PacketID) {
+	if int(opcode) < numPacketIDs {
+		atomic.AddUint64(&f.oversized[opcode], 1)
+	}
+}
+
+func (f *FrameLimitStats) addClipped(opcode network.PacketID) {
+	if int(opcode) < numPacketIDs {
+		atomic.AddUint64(&f.clipped[opcode], 1)
+	}
+}
+
+// writeMetrics writes the non-zero counters in the Prometheus text exposition format.
+func (f *FrameLimitStats) writeMetrics(w io.Writer, channel string) {
+	for i := 0; i < numPacketIDs; i++ {
+		if n := atomic.LoadUint64(&f.oversized[i]); n > 0 {
+			fmt.Fprintf(w, "erupe_channel_oversized_responses_total{channel=%s,opcode=\"%s\"} %d\n", channel, network.PacketID(i), n)
+		}
+		if n := atomic.LoadUint64(&f.clipped[i]); n > 0 {
+			fmt.Fprintf(w, "erupe_channel_clipped_responses_total{channel=%s,opcode=\"%s\"} %d\n", channel, network.PacketID(i), n)
+		}
+	}
+}
+
+// ackFits reports whether a buffer ack with a payload of size bytes fits in a single frame.
+func (s *Session) ackFits(size int) bool {
+	return size+ackFrameOverhead <= s.server.frameLimit
+}
+
+// handlingOpcode returns the opcode of the request the session is handling.
+func (s *Session) handlingOpcode() network.PacketID {
+	return network.PacketID(atomic.LoadUint32(&s.handling))
+}
+
+// recordOversizedAck accounts for an ack too big for a single frame, which the client can't read whole.
+func (s *Session) recordOversizedAck(ack *mhfpacket.MsgSysAck) {
+	opcode := s.handlingOpcode()
+	s.server.frameLimits.addOversized(opcode)
+	s.logger.Warn("Sent a response past the frame limit, its builder should clip it",
+		zap.String("opcode", opcode.String()),
+		zap.Int("size", len(ack.AckData)),
+	)
+}
+
+// recordClippedResponse accounts for a response its builder cut short to fit in a single frame.
+func (s *Session) recordClippedResponse() {
+	s.server.frameLimits.addClipped(s.handlingOpcode())
+}
@@ -0,0 +1,89 @@
+package channelserver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+)
+
+func TestOversizedAckIsCounted(t *testing.T) {
+	s := newTestServer()
+	s.frameLimit = 64
+	session, client := newTestSession(s)
+	t.Cleanup(func() { client.Close() })
+	session.handling = uint32(network.MSG_MHF_ENUMERATE_GUILD)
+
+	payload := bytes.Repeat([]byte{0xAB}, 150)
+	received := make(chan []byte, 1)
+	go func() {
+		cc := network.NewCryptConn(client)
+		frame, err := cc.ReadPacket()
+		if err != nil {
+			close(received)
+			return
+		}
+		received <- frame
+		io.Copy(ioutil.Discard, client)
+	}()
+	doAckBufSucceed(session, 1, payload)
+
+	// The ack still goes out whole in a single frame.
+	select {
+	case frame := <-received:
+		bf := byteframe.NewByteFrameFromBytes(frame)
+		if opcode := network.PacketID(bf.ReadUint16()); opcode != network.MSG_SYS_ACK {
+			t.Fatalf("got opcode %s, want MSG_SYS_ACK", opcode)
+		}
+		ack := &mhfpacket.MsgSysAck{}
+		ack.Parse(bf, session.clientContext)
+		if !bytes.Equal(ack.AckData, payload) || !bytes.HasSuffix(frame, []byte{0x00, 0x10}) {
+			t.Error("the ack wasn't sent whole in one frame")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ack wasn't received")
+	}
+
+	var metrics strings.Builder
+	s.frameLimits.writeMetrics(&metrics, `"test"`)
+	if want := `erupe_channel_oversized_responses_total{channel="test",opcode="MSG_MHF_ENUMERATE_GUILD"} 1`; !strings.Contains(metrics.String(), want) {
+		t.Errorf("metrics %q don't contain %q", metrics.String(), want)
+	}
+}
+
+func TestGuildSearchResultsAreClipped(t *testing.T) {
+	s := newTestServer()
+	s.frameLimit = 200
+	session, client := newTestSession(s)
+	t.Cleanup(func() { client.Close() })
+	session.handling = uint32(network.MSG_MHF_ENUMERATE_GUILD)
+
+	var guilds []*Guild
+	for i := 0; i < 10; i++ {
+		guilds = append(guilds, &Guild{ID: uint32(i), Name: fmt.Sprintf("Guild %d", i), GuildLeader: GuildLeader{LeaderName: "Leader"}})
+	}
+	data, shown := buildGuildSearchResults(session, guilds)
+	if shown == 0 || shown >= len(guilds) {
+		t.Fatalf("got %d guilds shown, want some but not all", shown)
+	}
+	if !session.ackFits(len(data)) {
+		t.Errorf("clipped response of %d bytes still doesn't fit", len(data))
+	}
+	if count := byteframe.NewByteFrameFromBytes(data).ReadUint16(); int(count) != shown {
+		t.Errorf("response counts %d guilds, want %d", count, shown)
+	}
+	if n := s.frameLimits.clipped[network.MSG_MHF_ENUMERATE_GUILD]; n != 1 {
+		t.Errorf("got %d clipped responses, want 1", n)
+	}
+
+	if _, shown := buildGuildSearchResults(session, guilds[:1]); shown != 1 {
+		t.Errorf("got %d guilds shown of 1", shown)
+	}
+}
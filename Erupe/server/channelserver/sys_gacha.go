@@ -0,0 +1,378 @@
+package channelserver
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/sachaos/lottery"
+	"go.uber.org/zap"
+)
+
+// Errors rolling a gacha.
+var (
+	ErrGachaUnknown        = errors.New("the gacha has no such roll")
+	ErrGachaNotEnoughCoins = errors.New("not enough gacha coins for the roll")
+	ErrGachaEmpty          = errors.New("the gacha has nothing left to draw")
+)
+
+// The gacha_shop_items entryType of a banner's prizes, the other entry types are its rolls.
+const gachaPrizeEntry = 100
+
+// Currency type of a roll paid in the gacha coins the server holds. Rolls paid in items are taken by the
+// client itself, which sends the savedata right after.
+const gachaCoinCurrency = 19
+
+// gachaKind is how a banner draws.
+type gachaKind int
+
+const (
+	gachaNormal gachaKind = iota
+	gachaStepup           // Normal draws, and each step gives the bonus items of its roll and unlocks the next.
+	gachaBox              // A drawn prize is gone from the character's box until it's reset.
+)
+
+// gachaItem is a prize of a banner, the items it gives with the weight it's drawn with.
+type gachaItem struct {
+	itemhash   uint32
+	percentage uint16
+	rarityIcon byte
+	itemCount  byte
+	itemType   pq.Int64Array
+	itemId     pq.Int64Array
+	quantity   pq.Int64Array
+}
+
+func (i gachaItem) Weight() int {
+	return int(i.percentage)
+}
+
+// gachaPity guarantees a prize of at least the rarity on the roll that makes rolls in a row without one.
+type gachaPity struct {
+	rolls  int // 0 for no guarantee.
+	rarity int
+}
+
+// rare reports whether the prize is of the guaranteed rarity, which starts the count of rolls over.
+func (p gachaPity) rare(item *gachaItem) bool {
+	return int(item.rarityIcon) >= p.rarity
+}
+
+// gachaResult is a prize drawn, and whether the pity guarantee drew it.
+type gachaResult struct {
+	item *gachaItem
+	pity bool
+}
+
+// rollGacha draws rolls prizes, since being the rolls made without a rare prize before these. A roll
+// that reaches the pity guarantee only draws from the rare prizes, by their weights. In a box a drawn
+// prize isn't drawn again. It returns the prizes and the rolls without a rare prize after them, which
+// aren't counted for a banner without a guarantee.
+func rollGacha(l lottery.Lottery, items []*gachaItem, rolls int, pity gachaPity, since int, box bool) ([]gachaResult, int) {
+	var results []gachaResult
+	pool := append([]*gachaItem(nil), items...)
+	for x := 0; x < rolls; x++ {
+		candidates := pool
+		guaranteed := false
+		if pity.rolls > 0 && since+1 >= pity.rolls {
+			var rare []*gachaItem
+			for _, item := range pool {
+				if pity.rare(item) && item.Weight() > 0 {
+					rare = append(rare, item)
+				}
+			}
+			if len(rare) > 0 {
+				candidates, guaranteed = rare, true
+			}
+		}
+		weighters := make([]lottery.Weighter, len(candidates))
+		for i, item := range candidates {
+			weighters[i] = item
+		}
+		ind := l.Draw(weighters)
+		if ind < 0 {
+			break
+		}
+		item := candidates[ind]
+		results = append(results, gachaResult{item: item, pity: guaranteed})
+		switch {
+		case pity.rolls == 0 || pity.rare(item):
+			since = 0
+		default:
+			since++
+		}
+		if box {
+			for i := range pool {
+				if pool[i] == item {
+					pool = append(pool[:i], pool[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+	return results, since
+}
+
+// gachaRoll is a roll of a banner: what it costs, how many prizes it draws and the bonus items of a step.
+type gachaRoll struct {
+	currType   byte
+	currNumber uint16
+	rollsCount byte
+	step       gachaItem
+}
+
+// loadGachaItems reads the prizes of a banner the query returns.
+func loadGachaItems(tx *sqlx.Tx, query string, args ...interface{}) ([]*gachaItem, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []*gachaItem
+	for rows.Next() {
+		item := &gachaItem{}
+		err = rows.Scan(&item.itemhash, &item.percentage, &item.rarityIcon, &item.itemCount, &item.itemType, &item.itemId, &item.quantity)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// writeGachaItems writes the items a prize gives as the gacha box stores them: type, ID and quantity.
+func writeGachaItems(bf *byteframe.ByteFrame, item *gachaItem, rarity bool) int {
+	y := 0
+	for ; y < int(item.itemCount) && y < len(item.itemType) && y < len(item.itemId) && y < len(item.quantity); y++ {
+		bf.WriteUint8(uint8(item.itemType[y]))
+		bf.WriteUint16(uint16(item.itemId[y]))
+		bf.WriteUint16(uint16(item.quantity[y]))
+		if rarity {
+			bf.WriteUint8(item.rarityIcon)
+		}
+	}
+	return y
+}
+
+// gachaResultsResp builds the answer to a roll: the number of items drawn, then each item's type, ID,
+// quantity and rarity.
+func gachaResultsResp(results []gachaResult) []byte {
+	resp := byteframe.NewByteFrame()
+	resp.WriteUint8(0) // results go here later
+	count := 0
+	for _, result := range results {
+		count += writeGachaItems(resp, result.item, true)
+	}
+	resp.Seek(0, 0)
+	resp.WriteUint8(uint8(count))
+	return resp.Data()
+}
+
+// drawGacha makes a roll of a banner for the character in one transaction: it takes the coins the roll
+// costs, draws its prizes with the pity guarantee of the banner, puts them in the character's gacha box
+// for the cat to hand out, and records them in gacha_history. Without the coins nothing changes and
+// ErrGachaNotEnoughCoins is returned. The step bonus items are in the roll returned.
+func drawGacha(db *sqlx.DB, l lottery.Lottery, charID uint32, hash uint32, rollType uint8, kind gachaKind) ([]gachaResult, gachaRoll, error) {
+	var roll gachaRoll
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, roll, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+		SELECT currType, currNumber, rollsCount, COALESCE(itemCount, 0), COALESCE(itemType, '{}'), COALESCE(itemId, '{}'), COALESCE(quantity, '{}')
+		FROM gacha_shop_items WHERE shophash = $1 AND entryType = $2
+	`, hash, rollType).Scan(&roll.currType, &roll.currNumber, &roll.rollsCount, &roll.step.itemCount, &roll.step.itemType, &roll.step.itemId, &roll.step.quantity)
+	if err == sql.ErrNoRows {
+		return nil, roll, ErrGachaUnknown
+	}
+	if err != nil {
+		return nil, roll, err
+	}
+	if kind != gachaStepup {
+		roll.step = gachaItem{}
+	}
+	var pity gachaPity
+	err = tx.QueryRow("SELECT pity_rolls, pity_rarity FROM gacha_shop WHERE hash = $1", hash).Scan(&pity.rolls, &pity.rarity)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, roll, err
+	}
+
+	if roll.currType == gachaCoinCurrency {
+		paid, err := deductGachaCoins(tx, charID, uint32(roll.currNumber))
+		if err != nil {
+			return nil, roll, err
+		}
+		if !paid {
+			return nil, roll, ErrGachaNotEnoughCoins
+		}
+	}
+
+	query := "SELECT itemhash, percentage, rarityIcon, itemCount, itemType, itemId, quantity FROM gacha_shop_items WHERE shophash = $1 AND entryType = $2"
+	args := []interface{}{hash, gachaPrizeEntry}
+	if kind == gachaBox {
+		query += " AND NOT itemhash = ANY(COALESCE((SELECT used_itemhash FROM lucky_box_state WHERE char_id = $3 AND shophash = $1), '{}'))"
+		args = append(args, charID)
+	}
+	items, err := loadGachaItems(tx, query, args...)
+	if err != nil {
+		return nil, roll, err
+	}
+
+	// The pity count row is locked for the roll, so two rolls at once can't both miss the guarantee.
+	var since int
+	_, err = tx.Exec("INSERT INTO gacha_pity (char_id, shophash) VALUES ($1, $2) ON CONFLICT DO NOTHING", charID, hash)
+	if err != nil {
+		return nil, roll, err
+	}
+	err = tx.QueryRow("SELECT rolls FROM gacha_pity WHERE char_id = $1 AND shophash = $2 FOR UPDATE", charID, hash).Scan(&since)
+	if err != nil {
+		return nil, roll, err
+	}
+
+	results, since := rollGacha(l, items, int(roll.rollsCount), pity, since, kind == gachaBox)
+	if len(results) == 0 && roll.step.itemCount == 0 {
+		return nil, roll, ErrGachaEmpty
+	}
+
+	var data []byte
+	err = tx.QueryRow("SELECT COALESCE(gacha_items, '\\x00') FROM characters WHERE id = $1 FOR UPDATE", charID).Scan(&data)
+	if err != nil {
+		return nil, roll, err
+	}
+	if len(data) == 0 {
+		data = []byte{0x00}
+	}
+	box := byteframe.NewByteFrame()
+	count := int(data[0])
+	var used pq.Int64Array
+	for _, result := range results {
+		count += writeGachaItems(box, result.item, false)
+		used = append(used, int64(result.item.itemhash))
+		_, err = tx.Exec(`
+			INSERT INTO gacha_history (char_id, shophash, entry_type, itemhash, rarity, pity) VALUES ($1, $2, $3, $4, $5, $6)
+		`, charID, hash, rollType, result.item.itemhash, result.item.rarityIcon, result.pity)
+		if err != nil {
+			return nil, roll, err
+		}
+	}
+	count += writeGachaItems(box, &roll.step, false)
+	data = append(data, box.Data()...)
+	data[0] = byte(count)
+	if _, err = tx.Exec("UPDATE characters SET gacha_items = $1 WHERE id = $2", data, charID); err != nil {
+		return nil, roll, err
+	}
+	if _, err = tx.Exec("UPDATE gacha_pity SET rolls = $1 WHERE char_id = $2 AND shophash = $3", since, charID, hash); err != nil {
+		return nil, roll, err
+	}
+
+	switch kind {
+	case gachaBox:
+		_, err = tx.Exec(`
+			INSERT INTO lucky_box_state (char_id, shophash, used_itemhash) VALUES ($1, $2, $3)
+			ON CONFLICT (char_id, shophash) DO UPDATE SET used_itemhash = COALESCE(lucky_box_state.used_itemhash, '{}') || $3::int[]
+		`, charID, hash, used)
+	case gachaStepup:
+		_, err = tx.Exec("UPDATE stepup_state SET step_progression = $1 WHERE char_id = $2 AND shophash = $3", int(rollType)+1, charID, hash)
+	}
+	if err != nil {
+		return nil, roll, err
+	}
+	return results, roll, tx.Commit()
+}
+
+// playGacha rolls the banner for the session's character, failing the ack when the roll can't be made.
+func playGacha(s *Session, ackHandle uint32, hash uint32, rollType uint8, kind gachaKind, stream string) ([]gachaResult, gachaRoll, bool) {
+	results, roll, err := drawGacha(s.server.db, s.server.rng.Stream(stream), s.charID, hash, rollType, kind)
+	if err != nil {
+		if err == ErrGachaNotEnoughCoins || err == ErrGachaUnknown || err == ErrGachaEmpty {
+			s.logger.Info("Refused gacha roll", zap.Uint32("gachaHash", hash), zap.Uint8("rollType", rollType), zap.Error(err))
+		} else {
+			s.logger.Error("Failed to roll gacha", zap.Uint32("gachaHash", hash), zap.Uint8("rollType", rollType), zap.Error(err))
+		}
+		doAckBufFail(s, ackHandle, make([]byte, 1))
+		return nil, roll, false
+	}
+	return results, roll, true
+}
+
+// GachaEntryRate is a prize of a banner with the chance its weight gives a roll and how often it was drawn.
+type GachaEntryRate struct {
+	ItemHash uint32  `json:"itemHash"`
+	Rarity   int     `json:"rarity"`
+	Weight   int     `json:"weight"`
+	Rate     float64 `json:"rate"`     // Chance of a roll drawing it, by the weights alone.
+	Drawn    int     `json:"drawn"`    // Times it was drawn.
+	PityDraw int     `json:"pityDraw"` // Times the pity guarantee drew it, out of Drawn.
+	Observed float64 `json:"observed"` // Share of the banner's draws it was.
+}
+
+// GachaBannerRates are the prizes of a banner with their rates, published for transparency.
+type GachaBannerRates struct {
+	Hash       uint32           `json:"hash"`
+	Name       string           `json:"name"`
+	PityRolls  int              `json:"pityRolls"`
+	PityRarity int              `json:"pityRarity"`
+	Draws      int              `json:"draws"`
+	Entries    []GachaEntryRate `json:"entries"`
+}
+
+// GachaRates returns the configured and drawn rates of the prizes of every banner, from gacha_history.
+func GachaRates(db *sqlx.DB) ([]GachaBannerRates, error) {
+	rows, err := db.Query(`
+		SELECT gs.hash, COALESCE(gs.gachaName, ''), gs.pity_rolls, gs.pity_rarity, gsi.itemhash, gsi.rarityIcon, gsi.percentage,
+			COUNT(gh.id), COUNT(gh.id) FILTER (WHERE gh.pity)
+		FROM gacha_shop gs
+		JOIN gacha_shop_items gsi ON gsi.shophash = gs.hash AND gsi.entryType = $1
+		LEFT JOIN gacha_history gh ON gh.shophash = gs.hash AND gh.itemhash = gsi.itemhash
+		GROUP BY gs.hash, gs.gachaName, gs.pity_rolls, gs.pity_rarity, gsi.itemhash, gsi.rarityIcon, gsi.percentage
+		ORDER BY gs.hash, gsi.itemhash
+	`, gachaPrizeEntry)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	banners := []GachaBannerRates{}
+	for rows.Next() {
+		var banner GachaBannerRates
+		var entry GachaEntryRate
+		err = rows.Scan(&banner.Hash, &banner.Name, &banner.PityRolls, &banner.PityRarity, &entry.ItemHash, &entry.Rarity, &entry.Weight, &entry.Drawn, &entry.PityDraw)
+		if err != nil {
+			return nil, err
+		}
+		if len(banners) == 0 || banners[len(banners)-1].Hash != banner.Hash {
+			banners = append(banners, banner)
+		}
+		last := &banners[len(banners)-1]
+		last.Entries = append(last.Entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range banners {
+		banners[i].computeRates()
+	}
+	return banners, nil
+}
+
+// computeRates fills in the rates of the entries from their weights and draws.
+func (b *GachaBannerRates) computeRates() {
+	weights := 0
+	b.Draws = 0
+	for _, entry := range b.Entries {
+		weights += entry.Weight
+		b.Draws += entry.Drawn
+	}
+	for i := range b.Entries {
+		entry := &b.Entries[i]
+		if weights > 0 {
+			entry.Rate = float64(entry.Weight) / float64(weights)
+		}
+		if b.Draws > 0 {
+			entry.Observed = float64(entry.Drawn) / float64(b.Draws)
+		}
+	}
+}
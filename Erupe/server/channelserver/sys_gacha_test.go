@@ -0,0 +1,145 @@
+package channelserver
+
+import (
+	"math"
+	"testing"
+
+	"github.com/Solenataris/Erupe/common/testdb"
+)
+
+func TestRollGachaWeights(t *testing.T) {
+	items := []*gachaItem{
+		{itemhash: 1, percentage: 700},
+		{itemhash: 2, percentage: 250},
+		{itemhash: 3, percentage: 50},
+	}
+	const rolls = 100000
+	results, _ := rollGacha(&RNGStream{seed: 7}, items, rolls, gachaPity{}, 0, false)
+	if len(results) != rolls {
+		t.Fatalf("drew %d of %d rolls", len(results), rolls)
+	}
+	drawn := make(map[uint32]int)
+	for _, result := range results {
+		drawn[result.item.itemhash]++
+	}
+	for _, item := range items {
+		want := float64(item.percentage) / 1000
+		got := float64(drawn[item.itemhash]) / rolls
+		if math.Abs(got-want) > 0.01 {
+			t.Errorf("prize %d drawn %.3f of the rolls, want %.3f", item.itemhash, got, want)
+		}
+	}
+}
+
+func TestRollGachaPity(t *testing.T) {
+	items := []*gachaItem{
+		{itemhash: 1, percentage: 10000, rarityIcon: 1},
+		{itemhash: 2, percentage: 1, rarityIcon: 3},
+	}
+	pity := gachaPity{rolls: 10, rarity: 3}
+	results, since := rollGacha(&RNGStream{seed: 7}, items, 25, pity, 0, false)
+	for i, result := range results {
+		guaranteed := i == 9 || i == 19
+		if result.pity != guaranteed || (guaranteed && result.item.itemhash != 2) {
+			t.Errorf("roll %d drew prize %d with pity %v", i+1, result.item.itemhash, result.pity)
+		}
+	}
+	if since != 5 {
+		t.Errorf("%d rolls counted towards the guarantee after the last one, want 5", since)
+	}
+
+	// The count carries over from the rolls before.
+	results, since = rollGacha(&RNGStream{seed: 7}, items, 2, pity, 8, false)
+	if results[0].pity || !results[1].pity || since != 0 {
+		t.Errorf("rolls after 8 without a rare prize got pity %v, %v and count %d", results[0].pity, results[1].pity, since)
+	}
+
+	// A banner without a guarantee doesn't count.
+	if _, since := rollGacha(&RNGStream{seed: 7}, items, 30, gachaPity{}, 0, false); since != 0 {
+		t.Errorf("a banner without pity counted %d rolls", since)
+	}
+}
+
+func TestRollGachaBox(t *testing.T) {
+	items := []*gachaItem{
+		{itemhash: 1, percentage: 1},
+		{itemhash: 2, percentage: 1},
+		{itemhash: 3, percentage: 1},
+	}
+	results, _ := rollGacha(&RNGStream{seed: 7}, items, 5, gachaPity{}, 0, true)
+	seen := make(map[uint32]bool)
+	for _, result := range results {
+		if seen[result.item.itemhash] {
+			t.Errorf("box drew prize %d twice", result.item.itemhash)
+		}
+		seen[result.item.itemhash] = true
+	}
+	if len(results) != 3 || len(items) != 3 {
+		t.Errorf("box of 3 drew %d prizes and kept %d", len(results), len(items))
+	}
+}
+
+func TestGachaBannerRates(t *testing.T) {
+	banner := GachaBannerRates{Entries: []GachaEntryRate{
+		{ItemHash: 1, Weight: 75, Drawn: 30},
+		{ItemHash: 2, Weight: 25, Drawn: 10},
+	}}
+	banner.computeRates()
+	if banner.Draws != 40 || banner.Entries[0].Rate != 0.75 || banner.Entries[1].Observed != 0.25 {
+		t.Errorf("got rates %+v", banner)
+	}
+}
+
+// TestDrawGacha runs against the database in ERUPE_TEST_DB, like TestAddPoints.
+func TestDrawGacha(t *testing.T) {
+	db := testdb.Open(t)
+
+	var userID, charID uint32
+	err := db.QueryRow("INSERT INTO users (username, password) VALUES ('gacha_test', '') RETURNING id").Scan(&userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+	err = db.QueryRow("INSERT INTO characters (user_id, is_new_character, name, gacha_trial, gacha_prem) VALUES ($1, false, 'gacha', 0, 15) RETURNING id", userID).Scan(&charID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM characters WHERE id = $1", charID)
+	defer db.Exec("DELETE FROM gacha_history WHERE char_id = $1", charID)
+	defer db.Exec("DELETE FROM gacha_pity WHERE char_id = $1", charID)
+
+	const hash = 0x7E4F0001
+	_, err = db.Exec("INSERT INTO gacha_shop (hash, gachaName, pity_rolls, pity_rarity) VALUES ($1, 'test', 3, 2)", hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM gacha_shop WHERE hash = $1", hash)
+	_, err = db.Exec(`
+		INSERT INTO gacha_shop_items (shophash, entryType, itemhash, currType, currNumber, currQuant, percentage, rarityIcon, rollsCount, itemCount, itemType, itemId, quantity)
+		VALUES ($1, 0, $2, 19, 10, 0, 0, 0, 1, 0, '{}', '{}', '{}'),
+			($1, 100, $2 + 1, 0, 0, 0, 10000, 1, 0, 1, '{7}', '{100}', '{1}'),
+			($1, 100, $2 + 2, 0, 0, 0, 1, 2, 0, 1, '{7}', '{200}', '{1}')
+	`, hash, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM gacha_shop_items WHERE shophash = $1", hash)
+
+	rng := &RNGStream{seed: 7}
+	results, _, err := drawGacha(db, rng, charID, hash, 0, gachaNormal)
+	if err != nil || len(results) != 1 {
+		t.Fatalf("roll got %d prizes, %v", len(results), err)
+	}
+	// 5 coins are left, short of the next roll, which changes nothing.
+	if _, _, err := drawGacha(db, rng, charID, hash, 0, gachaNormal); err != ErrGachaNotEnoughCoins {
+		t.Fatalf("roll without the coins got %v, want %v", err, ErrGachaNotEnoughCoins)
+	}
+	var coins, rolls, since int
+	var box []byte
+	db.QueryRow("SELECT gacha_prem, gacha_items FROM characters WHERE id = $1", charID).Scan(&coins, &box)
+	db.QueryRow("SELECT COUNT(*) FROM gacha_history WHERE char_id = $1", charID).Scan(&rolls)
+	db.QueryRow("SELECT rolls FROM gacha_pity WHERE char_id = $1 AND shophash = $2", charID, hash).Scan(&since)
+	if coins != 5 || len(box) != 6 || box[0] != 1 || rolls != 1 || since != 1 {
+		t.Errorf("after a refused roll got %d coins, box %x, %d rolls in the history and pity count %d", coins, box, rolls, since)
+	}
+}
@@ -0,0 +1,185 @@
+package channelserver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Solenataris/Erupe/common/wordfilter"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// Longest officer note kept for a member, in characters.
+const guildNoteMaxLength = 60
+
+// Days of activity shown to officers.
+const guildActivityDays = 30
+
+// guildMemberActivity is a member's activity as shown to the guild's leader and officers.
+type guildMemberActivity struct {
+	CharID      uint32 `db:"character_id"`
+	Name        string `db:"name"`
+	LastLogin   uint32 `db:"last_login"`
+	GuildQuests int    `db:"guild_quests"`
+	GCPEarned   int    `db:"gcp_earned"`
+	Note        string `db:"note"`
+}
+
+// format formats the activity as a single chat line.
+func (a guildMemberActivity) format(now time.Time) string {
+	seen := "never"
+	if a.LastLogin > 0 {
+		days := int(now.Sub(time.Unix(int64(a.LastLogin), 0)).Hours() / 24)
+		switch days {
+		case 0:
+			seen = "today"
+		case 1:
+			seen = "1 day ago"
+		default:
+			seen = fmt.Sprintf("%d days ago", days)
+		}
+	}
+	line := fmt.Sprintf("%s: seen %s, %d guild quests, %d GCP", a.Name, seen, a.GuildQuests, a.GCPEarned)
+	if a.Note != "" {
+		line += " - " + a.Note
+	}
+	return line
+}
+
+// cleanGuildNote trims the note to guildNoteMaxLength characters and masks filtered words.
+func cleanGuildNote(note string, words *wordfilter.Filter) string {
+	note = strings.TrimSpace(note)
+	if utf8.RuneCountInString(note) > guildNoteMaxLength {
+		note = string([]rune(note)[:guildNoteMaxLength])
+	}
+	return words.Mask(note)
+}
+
+// recordGuildQuest counts a quest departure towards the session's guild activity
+// if a guildmate is in the party.
+func recordGuildQuest(s *Session) {
+	stage := s.stage
+	if stage == nil {
+		return
+	}
+	stage.RLock()
+	party := make([]int64, 0, len(stage.reservedClientSlots))
+	for charID := range stage.reservedClientSlots {
+		if charID != s.charID {
+			party = append(party, int64(charID))
+		}
+	}
+	stage.RUnlock()
+	if len(party) == 0 {
+		return
+	}
+
+	_, err := s.server.db.Exec(`
+		INSERT INTO guild_member_activity (character_id, day, guild_quests)
+		SELECT $1, current_date, 1 WHERE EXISTS (
+			SELECT 1 FROM guild_characters me JOIN guild_characters mate ON mate.guild_id = me.guild_id
+			WHERE me.character_id = $1 AND mate.character_id = ANY($2)
+		)
+		ON CONFLICT (character_id, day) DO UPDATE SET guild_quests = guild_member_activity.guild_quests + 1
+	`, s.charID, pq.Array(party))
+	if err != nil {
+		s.logger.Error("Failed to record guild quest", zap.Error(err))
+	}
+}
+
+// recordGCPEarned adds GCP the session earned to today's guild activity.
+func recordGCPEarned(s *Session, earned int) {
+	if earned <= 0 {
+		return
+	}
+	_, err := s.server.db.Exec(`
+		INSERT INTO guild_member_activity (character_id, day, gcp_earned) VALUES ($1, current_date, $2)
+		ON CONFLICT (character_id, day) DO UPDATE SET gcp_earned = guild_member_activity.gcp_earned + $2
+	`, s.charID, earned)
+	if err != nil {
+		s.logger.Error("Failed to record GCP earned", zap.Error(err))
+	}
+}
+
+// guildOfficerOf returns the session's guild membership if it leads the guild or is an officer of it.
+func guildOfficerOf(s *Session) *GuildMember {
+	member, err := GetCharacterGuildData(s, s.charID)
+	if err != nil || member == nil || member.IsApplicant || !(member.IsLeader || member.IsSubLeader()) {
+		return nil
+	}
+	return member
+}
+
+// handleGuildNoteCommand runs the officer chat commands. "!notes" lists every member's activity
+// over the last 30 days with their note, "!note <name> <text>" sets a member's note and leaving
+// out the text clears it.
+func handleGuildNoteCommand(s *Session, message string) {
+	message = strings.TrimSpace(message)
+	officer := guildOfficerOf(s)
+	if officer == nil {
+		sendServerChatMessage(s, "Only the guild leader and officers can see member notes.")
+		return
+	}
+
+	if message == "!notes" {
+		var members []guildMemberActivity
+		err := s.server.db.Select(&members, `
+			SELECT c.id AS character_id, c.name, c.last_login,
+				COALESCE(SUM(a.guild_quests), 0) AS guild_quests, COALESCE(SUM(a.gcp_earned), 0) AS gcp_earned,
+				COALESCE(n.note, '') AS note
+			FROM guild_characters gc
+			JOIN characters c ON c.id = gc.character_id
+			LEFT JOIN guild_member_activity a ON a.character_id = c.id AND a.day > current_date - $2::int
+			LEFT JOIN guild_member_notes n ON n.guild_id = gc.guild_id AND n.character_id = c.id
+			WHERE gc.guild_id = $1
+			GROUP BY c.id, c.name, c.last_login, n.note
+			ORDER BY c.last_login
+		`, officer.GuildID, guildActivityDays)
+		if err != nil {
+			s.logger.Error("Failed to get guild member activity", zap.Error(err))
+			sendServerChatMessage(s, "Failed to get member activity.")
+			return
+		}
+		now := Time_Current()
+		for _, member := range members {
+			sendServerChatMessage(s, member.format(now))
+		}
+		return
+	}
+
+	args := strings.SplitN(strings.TrimPrefix(message, "!note "), " ", 2)
+	if !strings.HasPrefix(message, "!note ") || args[0] == "" {
+		sendServerChatMessage(s, "Usage: \"!notes\" or \"!note <name> <text>\"")
+		return
+	}
+	var charID uint32
+	err := s.server.db.QueryRow(`
+		SELECT c.id FROM guild_characters gc JOIN characters c ON c.id = gc.character_id
+		WHERE gc.guild_id = $1 AND c.name = $2
+	`, officer.GuildID, args[0]).Scan(&charID)
+	if err != nil {
+		sendServerChatMessage(s, fmt.Sprintf("%s isn't in the guild.", args[0]))
+		return
+	}
+
+	var note string
+	if len(args) > 1 {
+		note = cleanGuildNote(args[1], s.server.words())
+	}
+	if note == "" {
+		_, err = s.server.db.Exec("DELETE FROM guild_member_notes WHERE guild_id = $1 AND character_id = $2", officer.GuildID, charID)
+	} else {
+		_, err = s.server.db.Exec(`
+			INSERT INTO guild_member_notes (guild_id, character_id, note, author_id) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (guild_id, character_id) DO UPDATE SET note = $3, author_id = $4, updated_at = now()
+		`, officer.GuildID, charID, note, s.charID)
+	}
+	if err != nil {
+		s.logger.Error("Failed to save guild member note", zap.Error(err))
+		sendServerChatMessage(s, "Failed to save the note.")
+		return
+	}
+	sendServerChatMessage(s, fmt.Sprintf("Note on %s saved.", args[0]))
+}
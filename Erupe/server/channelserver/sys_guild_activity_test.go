@@ -0,0 +1,48 @@
+package channelserver
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Solenataris/Erupe/common/wordfilter"
+)
+
+func TestCleanGuildNote(t *testing.T) {
+	words := wordfilter.New([]string{"noob", " leech "})
+	if got := cleanGuildNote("  Carried by NOOBs, leeches  ", words); got != "Carried by ****s, *****es" {
+		t.Errorf("got %q", got)
+	}
+
+	long := strings.Repeat("あ", guildNoteMaxLength+10)
+	if got := cleanGuildNote(long, nil); utf8.RuneCountInString(got) != guildNoteMaxLength {
+		t.Errorf("got a note of %d characters, want %d", utf8.RuneCountInString(got), guildNoteMaxLength)
+	}
+}
+
+func TestGuildMemberActivityFormat(t *testing.T) {
+	now := time.Date(2022, 6, 30, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		activity guildMemberActivity
+		want     string
+	}{
+		{
+			guildMemberActivity{Name: "Alpha", LastLogin: uint32(now.Add(-time.Hour).Unix()), GuildQuests: 12, GCPEarned: 3400},
+			"Alpha: seen today, 12 guild quests, 3400 GCP",
+		},
+		{
+			guildMemberActivity{Name: "Beta", LastLogin: uint32(now.AddDate(0, 0, -45).Unix()), Note: "On a break until July"},
+			"Beta: seen 45 days ago, 0 guild quests, 0 GCP - On a break until July",
+		},
+		{
+			guildMemberActivity{Name: "Gamma"},
+			"Gamma: seen never, 0 guild quests, 0 GCP",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.activity.format(now); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
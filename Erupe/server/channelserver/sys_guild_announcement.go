@@ -0,0 +1,182 @@
+package channelserver
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/common/stringsupport"
+	"github.com/Solenataris/Erupe/common/wordfilter"
+	"github.com/Solenataris/Erupe/network/binpacket"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"go.uber.org/zap"
+)
+
+// Most bytes of an announcement once encoded, what a chat line holds without wrapping off the log.
+const guildAnnouncementMaxLength = 200
+
+// Subject of the mail offline members get an announcement in, and the title it's listed under on the
+// guild's message board.
+const guildAnnouncementTitle = "Guild announcement"
+
+var (
+	errAnnouncementEmpty    = errors.New("announcement is empty")
+	errAnnouncementTooLong  = errors.New("announcement is too long")
+	errAnnouncementEncoding = errors.New("announcement has characters the game can't show")
+)
+
+// cleanGuildAnnouncement masks the filtered words in an announcement and checks the client can show it,
+// not cutting it short as a note is, since the officer is there to fix it.
+func cleanGuildAnnouncement(text string, words *wordfilter.Filter) (string, error) {
+	text = words.Mask(strings.TrimSpace(text))
+	if text == "" {
+		return "", errAnnouncementEmpty
+	}
+	for _, r := range text {
+		if unicode.IsControl(r) {
+			return "", errAnnouncementEncoding
+		}
+	}
+	encoded, err := stringsupport.ConvertUTF8ToShiftJIS(text)
+	if err != nil {
+		return "", errAnnouncementEncoding
+	}
+	if len(encoded) > guildAnnouncementMaxLength {
+		return "", errAnnouncementTooLong
+	}
+	return text, nil
+}
+
+// sendGuildNotice shows an announcement of the guild to the session as a system message, the way the
+// server's own announcements are, so it stands out from the guild chat.
+func sendGuildNotice(session *Session, guildName string, author string, text string) {
+	bf := byteframe.NewByteFrame()
+	bf.SetLE()
+	msgBinChat := &binpacket.MsgBinChat{
+		Type:       5,
+		Flags:      0x80,
+		Message:    fmt.Sprintf("%s: %s", author, text),
+		SenderName: guildName,
+	}
+	msgBinChat.Build(bf)
+	session.QueueSendMHF(&mhfpacket.MsgSysCastedBinary{
+		CharID:         0xFFFFFFFF,
+		MessageType:    BinaryMessageTypeChat,
+		RawDataPayload: bf.Data(),
+	})
+}
+
+// recordGuildAnnouncement stores an announcement of the guild unless the guild sent one within the
+// cooldown, then drops those past the history. It reports whether it stored it and, if not, the
+// minutes left until the guild may send the next.
+func recordGuildAnnouncement(s *Session, guildID uint32, text string) (bool, int, error) {
+	cfg := s.server.erupeConfig.Current().GuildAnnouncements
+	cooldown := cfg.Cooldown * 60
+	var id int
+	err := s.server.db.QueryRow(`
+		INSERT INTO guild_announcements (guild_id, author_id, message)
+		SELECT $1, $2, $3 WHERE NOT EXISTS (
+			SELECT 1 FROM guild_announcements WHERE guild_id = $1 AND created_at > now() - make_interval(secs => $4)
+		)
+		RETURNING id
+	`, guildID, s.charID, text, cooldown).Scan(&id)
+	if err == sql.ErrNoRows {
+		var left int
+		err = s.server.db.QueryRow(`
+			SELECT CEIL(EXTRACT(epoch FROM MAX(created_at) + make_interval(secs => $2) - now()) / 60)::int
+			FROM guild_announcements WHERE guild_id = $1
+		`, guildID, cooldown).Scan(&left)
+		return false, left, err
+	}
+	if err != nil {
+		return false, 0, err
+	}
+
+	// The latest is kept whatever the history, the cooldown runs from it.
+	_, err = s.server.db.Exec(`
+		DELETE FROM guild_announcements WHERE guild_id = $1 AND id NOT IN (
+			SELECT id FROM guild_announcements WHERE guild_id = $1 ORDER BY id DESC LIMIT GREATEST($2, 1)
+		)
+	`, guildID, cfg.History)
+	if err != nil {
+		s.logger.Error("Failed to trim guild announcements", zap.Uint32("guildID", guildID), zap.Error(err))
+	}
+	return true, 0, nil
+}
+
+// handleGuildAnnounceCommand runs "!announce <text>", the guild leader and officers sending an
+// announcement to the other members. Members online on any channel are shown it, the others are mailed
+// it. A guild sends one announcement per cooldown and its latest are listed on its message board.
+func handleGuildAnnounceCommand(s *Session, message string) {
+	officer := guildOfficerOf(s)
+	if officer == nil {
+		sendServerChatMessage(s, "Only the guild leader and officers can send announcements.")
+		return
+	}
+	if !strings.HasPrefix(message, "!announce ") {
+		sendServerChatMessage(s, "Usage: \"!announce <text>\"")
+		return
+	}
+	text, err := cleanGuildAnnouncement(strings.TrimPrefix(message, "!announce "), s.server.words())
+	switch err {
+	case nil:
+	case errAnnouncementTooLong:
+		sendServerChatMessage(s, fmt.Sprintf("Announcements are limited to %d bytes, about %d Japanese characters.", guildAnnouncementMaxLength, guildAnnouncementMaxLength/2))
+		return
+	case errAnnouncementEncoding:
+		sendServerChatMessage(s, "The announcement has characters the game can't show.")
+		return
+	default:
+		sendServerChatMessage(s, "Usage: \"!announce <text>\"")
+		return
+	}
+
+	guild, err := GetGuildInfoByID(s, officer.GuildID)
+	if err != nil || guild == nil {
+		s.logger.Error("Failed to get the guild to announce to", zap.Uint32("guildID", officer.GuildID), zap.Error(err))
+		sendServerChatMessage(s, "Failed to send the announcement.")
+		return
+	}
+	members, err := GetGuildMembers(s, guild.ID, false)
+	if err != nil {
+		s.logger.Error("Failed to get the guild members to announce to", zap.Uint32("guildID", guild.ID), zap.Error(err))
+		sendServerChatMessage(s, "Failed to send the announcement.")
+		return
+	}
+	stored, left, err := recordGuildAnnouncement(s, guild.ID, text)
+	if err != nil {
+		s.logger.Error("Failed to record guild announcement", zap.Uint32("guildID", guild.ID), zap.Error(err))
+		sendServerChatMessage(s, "Failed to send the announcement.")
+		return
+	}
+	if !stored {
+		sendServerChatMessage(s, fmt.Sprintf("Your guild can send its next announcement in %d minute(s).", left))
+		return
+	}
+
+	online, mailed := 0, 0
+	for _, member := range members {
+		if member.CharID == s.charID {
+			continue
+		}
+		if session := s.server.findSessionOnAnyChannel(member.CharID); session != nil {
+			sendGuildNotice(session, guild.Name, officer.Name, text)
+			online++
+			continue
+		}
+		mail := &Mail{
+			SenderID:    s.charID,
+			RecipientID: member.CharID,
+			Subject:     guildAnnouncementTitle,
+			Body:        text,
+		}
+		if mail.Send(s, nil) == nil {
+			mailed++
+		}
+	}
+	s.logger.Info("Guild announcement sent", zap.Uint32("guildID", guild.ID), zap.Int("online", online), zap.Int("mailed", mailed))
+	sendServerChatMessage(s, fmt.Sprintf("Announcement shown to %d member(s) online and mailed to %d.", online, mailed))
+}
@@ -0,0 +1,84 @@
+package channelserver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Solenataris/Erupe/common/testdb"
+	"github.com/Solenataris/Erupe/common/wordfilter"
+)
+
+func TestCleanGuildAnnouncement(t *testing.T) {
+	words := wordfilter.New([]string{"noob"})
+	tests := []struct {
+		text string
+		want string
+		err  error
+	}{
+		{"  Guild hunt at 20:00, noobs welcome  ", "Guild hunt at 20:00, ****s welcome", nil},
+		{"ギルド狩りは今夜です", "ギルド狩りは今夜です", nil},
+		{"   ", "", errAnnouncementEmpty},
+		{"Bring potions 🧪", "", errAnnouncementEncoding},
+		{"Line one\nline two", "", errAnnouncementEncoding},
+		{strings.Repeat("a", guildAnnouncementMaxLength), strings.Repeat("a", guildAnnouncementMaxLength), nil},
+		{strings.Repeat("a", guildAnnouncementMaxLength+1), "", errAnnouncementTooLong},
+		// Japanese characters take two bytes once encoded.
+		{strings.Repeat("あ", guildAnnouncementMaxLength/2+1), "", errAnnouncementTooLong},
+	}
+	for _, tt := range tests {
+		got, err := cleanGuildAnnouncement(tt.text, words)
+		if got != tt.want || err != tt.err {
+			t.Errorf("cleanGuildAnnouncement(%q) = %q, %v, want %q, %v", tt.text, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+// TestGuildAnnounceCommand runs against the database in ERUPE_TEST_DB, like TestTreasureWeek. An officer's
+// "!announce" in guild chat reaches the other member as a notice, masked even with the chat filter blocking,
+// and is neither sent on as chat nor shown back to the officer.
+func TestGuildAnnounceCommand(t *testing.T) {
+	db := testdb.Open(t)
+
+	var userID uint32
+	if err := db.QueryRow("INSERT INTO users (username, password) VALUES ('announce_test', '') RETURNING id").Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+	chars := make([]uint32, 2) // The leader and a member.
+	for i := range chars {
+		if err := db.QueryRow("INSERT INTO characters (user_id, is_new_character, name) VALUES ($1, false, 'announce_test') RETURNING id", userID).Scan(&chars[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer db.Exec("DELETE FROM characters WHERE user_id = $1", userID)
+	var guildID uint32
+	if err := db.QueryRow("INSERT INTO guilds (name, leader_id) VALUES ('AnnounceTest', $1) RETURNING id", chars[0]).Scan(&guildID); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM guilds WHERE id = $1", guildID)
+	defer db.Exec("DELETE FROM guild_characters WHERE guild_id = $1", guildID)
+	defer db.Exec("DELETE FROM guild_announcements WHERE guild_id = $1", guildID)
+	defer db.Exec("DELETE FROM mail WHERE sender_id = $1", chars[0])
+	for _, charID := range chars {
+		if _, err := db.Exec("INSERT INTO guild_characters (guild_id, character_id) VALUES ($1, $2)", guildID, charID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := newTestServer()
+	s.db = db
+	s.erupeConfig.WordFilter = []string{"noob"}
+	s.erupeConfig.ChatFilter = "block"
+	s.loadWordFilter()
+	leader := newChatMember(t, s, chars[0])
+	member := newChatMember(t, s, chars[1])
+
+	handleMsgSysCastBinary(leader, guildChatPacket("!announce Hunt tonight, noobs welcome", "Leader", chars[1]))
+	got, shown := string(sentPackets(member)), string(sentPackets(leader))
+	if !strings.Contains(got, "Hunt tonight, ****s welcome") || strings.Contains(got, "!announce") {
+		t.Errorf("the member got %q, want the masked notice and no chat", got)
+	}
+	if strings.Contains(shown, "Hunt tonight") || !strings.Contains(shown, "shown to 1 member(s)") {
+		t.Errorf("the leader got %q, want the confirmation without the announcement", shown)
+	}
+}
@@ -0,0 +1,96 @@
+package channelserver
+
+import (
+	"errors"
+
+	"go.uber.org/zap"
+)
+
+// errSettingsChanged is the write of guild or alliance settings based on a version someone else's write
+// replaced since, turned away so it doesn't silently undo theirs.
+var errSettingsChanged = errors.New("settings changed, please retry")
+
+// sawGuildSettings records the settings version of a guild the session was sent, the one its
+// character's next edits of them are based on.
+func (s *Session) sawGuildSettings(guildID uint32, version uint32) {
+	s.Lock()
+	defer s.Unlock()
+	if s.guildSettingsSeen == nil {
+		s.guildSettingsSeen = make(map[uint32]uint32)
+	}
+	s.guildSettingsSeen[guildID] = version
+}
+
+// guildSettingsBase returns the settings version an edit of the guild by the session is based on,
+// the one it was last sent, or the current one if it was never sent the guild.
+func (s *Session) guildSettingsBase(guildID uint32, current uint32) uint32 {
+	s.Lock()
+	defer s.Unlock()
+	if version, ok := s.guildSettingsSeen[guildID]; ok {
+		return version
+	}
+	return current
+}
+
+// failSettingsWrite fails the ack of an edit of guild or alliance settings, telling the player to look
+// again when the edit was stale.
+func failSettingsWrite(s *Session, ackHandle uint32, err error) {
+	if errors.Is(err, errSettingsChanged) {
+		s.logger.Info("Refused stale settings edit")
+		sendServerChatMessage(s, "The settings were changed by someone else in the meantime, please check them and retry.")
+	}
+	doAckSimpleFail(s, ackHandle, make([]byte, 4))
+}
+
+// Save writes the guild's settings if the version they were based on is still the current one, and
+// moves it to the next.
+func (guild *Guild) Save(s *Session) error {
+	result, err := s.server.db.Exec(`
+		UPDATE guilds SET main_motto=$2, sub_motto=$3, comment=$4, pugi_name_1=$5, pugi_name_2=$6, pugi_name_3=$7, festival_colour=$8, icon=$9,
+			settings_version=settings_version+1
+		WHERE id=$1 AND settings_version=$10
+	`, guild.ID, guild.MainMotto, guild.SubMotto, guild.Comment, guild.PugiName1, guild.PugiName2, guild.PugiName3, guild.FestivalColour, guild.Icon, guild.Version)
+	if err == nil {
+		err = checkSettingsWritten(result.RowsAffected())
+	}
+	if err != nil {
+		if !errors.Is(err, errSettingsChanged) {
+			s.logger.Error("failed to update guild data", zap.Error(err), zap.Uint32("guildID", guild.ID))
+		}
+		return err
+	}
+	guild.Version++
+	s.sawGuildSettings(guild.ID, guild.Version)
+	return nil
+}
+
+// Save writes the alliance's name and sub guilds if the version they were based on is still the current
+// one, and moves it to the next.
+func (alliance *GuildAlliance) Save(s *Session) error {
+	result, err := s.server.db.Exec(`
+		UPDATE guild_alliances SET name=$2, sub1_id=NULLIF($3, 0), sub2_id=NULLIF($4, 0), settings_version=settings_version+1
+		WHERE id=$1 AND settings_version=$5
+	`, alliance.ID, alliance.Name, alliance.SubGuild1ID, alliance.SubGuild2ID, alliance.Version)
+	if err == nil {
+		err = checkSettingsWritten(result.RowsAffected())
+	}
+	if err != nil {
+		if !errors.Is(err, errSettingsChanged) {
+			s.logger.Error("Failed to update alliance", zap.Error(err), zap.Uint32("allianceID", alliance.ID))
+		}
+		return err
+	}
+	alliance.Version++
+	return nil
+}
+
+// checkSettingsWritten turns a versioned settings write that matched no row into errSettingsChanged.
+func checkSettingsWritten(rows int64, err error) error {
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return errSettingsChanged
+	}
+	return nil
+}
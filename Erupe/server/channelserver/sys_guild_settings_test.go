@@ -0,0 +1,167 @@
+package channelserver
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/Solenataris/Erupe/common/testdb"
+	"github.com/Solenataris/Erupe/config"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+func TestGuildSettingsBase(t *testing.T) {
+	s := newTestServer()
+	session := newQueuedSession(t, s, 1, "Alpha")
+	if got := session.guildSettingsBase(10, 4); got != 4 {
+		t.Errorf("never sent the guild, got base %d, want the current 4", got)
+	}
+	session.sawGuildSettings(10, 2)
+	if got := session.guildSettingsBase(10, 4); got != 2 {
+		t.Errorf("got base %d, want the version sent, 2", got)
+	}
+	if got := session.guildSettingsBase(11, 7); got != 7 {
+		t.Errorf("got base %d for another guild, want its current 7", got)
+	}
+}
+
+func TestRemoveSubGuild(t *testing.T) {
+	alliance := &GuildAlliance{ParentGuildID: 1, SubGuild1ID: 2, SubGuild2ID: 3, SubGuild2: Guild{ID: 3, Name: "Third"}}
+	if alliance.removeSubGuild(1) {
+		t.Error("removed the parent guild")
+	}
+	if !alliance.removeSubGuild(2) || alliance.SubGuild1ID != 3 || alliance.SubGuild1.Name != "Third" || alliance.SubGuild2ID != 0 {
+		t.Errorf("first sub guild leaving left %+v, want the second moved up", alliance)
+	}
+	if !alliance.removeSubGuild(3) || alliance.SubGuild1ID != 0 {
+		t.Errorf("last sub guild leaving left %+v", alliance)
+	}
+	if alliance.removeSubGuild(3) {
+		t.Error("removed a guild that already left")
+	}
+}
+
+// TestConcurrentSettingsEdits runs against the database in ERUPE_TEST_DB, e.g.
+// "host=localhost user=postgres password=admin dbname=erupe_test sslmode=disable".
+// Officers edit the guild's comment at once from what they were shown and an alliance's sub guilds
+// leave at once, only one write per version goes through and the others are told to retry.
+func TestConcurrentSettingsEdits(t *testing.T) {
+	db := testdb.Open(t)
+
+	var userID uint32
+	if err := db.QueryRow("INSERT INTO users (username, password) VALUES ('settings_test', '') RETURNING id").Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+	chars := make([]uint32, 3)
+	guilds := make([]uint32, 3)
+	for i := range chars {
+		if err := db.QueryRow("INSERT INTO characters (user_id, is_new_character, name) VALUES ($1, false, 'settings_test') RETURNING id", userID).Scan(&chars[i]); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.QueryRow("INSERT INTO guilds (name, leader_id) VALUES ('SettingsTest', $1) RETURNING id", chars[i]).Scan(&guilds[i]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec("INSERT INTO guild_characters (guild_id, character_id) VALUES ($1, $2)", guilds[i], chars[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer db.Exec("DELETE FROM characters WHERE user_id = $1", userID)
+	defer db.Exec("DELETE FROM guilds WHERE id = ANY($1)", pq.Array(guilds))
+	defer db.Exec("DELETE FROM guild_characters WHERE guild_id = ANY($1)", pq.Array(guilds))
+	var allianceID uint32
+	if err := db.QueryRow("INSERT INTO guild_alliances (name, parent_id, sub1_id, sub2_id) VALUES ('SettingsTest', $1, $2, $3) RETURNING id", guilds[0], guilds[1], guilds[2]).Scan(&allianceID); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM guild_alliances WHERE id = $1", allianceID)
+
+	s := NewServer(&Config{Logger: zap.NewNop(), DB: db, ErupeConfig: &config.Config{}})
+	sessions := make([]*Session, len(chars))
+	for i, charID := range chars {
+		sessions[i] = newQueuedSession(t, s, charID, "Officer")
+	}
+
+	// Every officer is shown the guild, then they all save a comment at once.
+	edits := make([]*Guild, len(sessions))
+	for i, session := range sessions {
+		guild, err := GetGuildInfoByID(session, guilds[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		session.sawGuildSettings(guild.ID, guild.Version)
+		guild.Comment = "Comment " + string(rune('A'+i))
+		edits[i] = guild
+	}
+	saved := concurrently(len(sessions), func(i int) error { return edits[i].Save(sessions[i]) })
+	if len(saved) != 1 {
+		t.Fatalf("%d of the edits based on the same version were saved, want 1", len(saved))
+	}
+	winner := saved[0]
+	guild, _ := GetGuildInfoByID(sessions[0], guilds[0])
+	if guild.Comment != edits[winner].Comment || guild.Version != 1 {
+		t.Errorf("got comment %q at version %d, want %q at 1", guild.Comment, guild.Version, edits[winner].Comment)
+	}
+
+	// The officer whose edit went through edits again from the version they saved, the others have to be
+	// shown the guild again first.
+	loser := sessions[(winner+1)%len(sessions)]
+	stale, _ := GetGuildInfoByID(loser, guilds[0])
+	stale.Version = loser.guildSettingsBase(stale.ID, stale.Version)
+	if err := stale.Save(loser); err != errSettingsChanged {
+		t.Errorf("stale edit got %v, want %v", err, errSettingsChanged)
+	}
+	again, _ := GetGuildInfoByID(sessions[winner], guilds[0])
+	again.Version = sessions[winner].guildSettingsBase(again.ID, again.Version)
+	again.MainMotto = 3
+	if err := again.Save(sessions[winner]); err != nil {
+		t.Errorf("edit from the saved version got %v", err)
+	}
+
+	// Both sub guilds leave the alliance at once, from the same version of it.
+	leaving := make([]*GuildAlliance, 2)
+	for i := range leaving {
+		var err error
+		if leaving[i], err = GetAllianceData(sessions[i+1], allianceID); err != nil || leaving[i] == nil {
+			t.Fatalf("got alliance %+v, %v", leaving[i], err)
+		}
+		leaving[i].removeSubGuild(guilds[i+1])
+	}
+	saved = concurrently(len(leaving), func(i int) error { return leaving[i].Save(sessions[i+1]) })
+	if len(saved) != 1 {
+		t.Fatalf("%d of the sub guilds left from the same version, want 1", len(saved))
+	}
+	retry, _ := GetAllianceData(sessions[2-saved[0]], allianceID)
+	if !retry.removeSubGuild(guilds[2-saved[0]]) {
+		t.Fatalf("the guild whose leave was refused isn't in %+v anymore", retry)
+	}
+	if err := retry.Save(sessions[2-saved[0]]); err != nil {
+		t.Fatal(err)
+	}
+	alliance, _ := GetAllianceData(sessions[0], allianceID)
+	if alliance.SubGuild1ID != 0 || alliance.SubGuild2ID != 0 || alliance.Version != 2 {
+		t.Errorf("got alliance %+v, want both sub guilds gone at version 2", alliance)
+	}
+}
+
+// concurrently runs write n times at once and returns the indexes of the runs that succeeded.
+func concurrently(n int, write func(i int) error) []int {
+	var mu sync.Mutex
+	var succeeded []int
+	var start, done sync.WaitGroup
+	start.Add(1)
+	for i := 0; i < n; i++ {
+		done.Add(1)
+		go func(i int) {
+			defer done.Done()
+			start.Wait()
+			if write(i) == nil {
+				mu.Lock()
+				succeeded = append(succeeded, i)
+				mu.Unlock()
+			}
+		}(i)
+	}
+	start.Done()
+	done.Wait()
+	return succeeded
+}
@@ -0,0 +1,45 @@
+package channelserver
+
+import (
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/common/bfutil"
+	"github.com/Solenataris/Erupe/common/stringsupport"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+)
+
+// Most bytes of a guild comment once encoded, the length byte of MsgMhfInfoGuild can't describe more.
+const guildCommentMaxLength = 255
+
+// Most bytes of a name in the guild search list once encoded, its length byte counts the null terminator too.
+const guildListTextMaxLength = 254
+
+// sanitizeGuildComment keeps what the client can render of a guild comment. Control bytes and
+// characters without a Shift-JIS encoding go, and the rest is cut to guildCommentMaxLength bytes.
+func sanitizeGuildComment(comment string) string {
+	return stringsupport.SanitizeSJIS(comment, guildCommentMaxLength)
+}
+
+// decodeGuildComment decodes a comment uploaded by the client up to its null terminator, masks the
+// filtered words in it and sanitizes it.
+func decodeGuildComment(s *Session, data []byte) (string, error) {
+	comment, err := s.clientContext.StrConv.Decode(bfutil.UpToNull(data))
+	if err != nil {
+		return "", err
+	}
+	return sanitizeGuildComment(s.server.words().Mask(comment)), nil
+}
+
+// readGuildComment reads the comment of an OPERATE_GUILD_UPDATE_COMMENT, its length byte and 4 unknown
+// bytes come before the text.
+func readGuildComment(s *Session, pkt *mhfpacket.MsgMhfOperateGuild) (string, error) {
+	pbf := byteframe.NewByteFrameFromBytes(pkt.UnkData)
+	commentLength := pbf.ReadUint8()
+	_ = pbf.ReadUint32()
+	return decodeGuildComment(s, pbf.ReadBytes(uint(commentLength)))
+}
+
+// encodeGuildListText encodes a name for the guild search list, sanitized so a bad row
+// can't break the list for everyone searching.
+func encodeGuildListText(s *Session, text string) []byte {
+	return s.clientContext.StrConv.MustEncode(stringsupport.SanitizeSJIS(text, guildListTextMaxLength))
+}
@@ -0,0 +1,163 @@
+package channelserver
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/common/bfutil"
+	"github.com/Solenataris/Erupe/common/fixtures"
+	"github.com/Solenataris/Erupe/common/stringsupport"
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+)
+
+// capturedGuildCommentDir holds MSG_MHF_OPERATE_GUILD packets of clients updating their guild's comment,
+// one fixture each from its opcode on, cut from the session captures of PacketCapture.
+const capturedGuildCommentDir = "channelserver/guild_comment"
+
+// The recruitment comment that broke the guild list: an escape sequence, a bell and a
+// dangling Shift-JIS lead byte, which decodes to a character that can't be encoded again.
+var corruptingGuildComment = []byte{'J', 'o', 'i', 'n', 0x1B, '[', '2', 'J', 0x07, ' ', 0x82, 0xA0, 0x81}
+
+// parseOperateGuild parses a client's MSG_MHF_OPERATE_GUILD packet from its opcode on, as the session's
+// packet loop does.
+func parseOperateGuild(t *testing.T, session *Session, data []byte) *mhfpacket.MsgMhfOperateGuild {
+	t.Helper()
+	bf := byteframe.NewByteFrameFromBytes(data)
+	if opcode := network.PacketID(bf.ReadUint16()); opcode != network.MSG_MHF_OPERATE_GUILD {
+		t.Fatalf("packet has opcode %s", opcode)
+	}
+	pkt := mhfpacket.FromOpcode(network.MSG_MHF_OPERATE_GUILD)
+	if err := pkt.Parse(bf, session.clientContext); err != nil {
+		t.Fatal(err)
+	}
+	return pkt.(*mhfpacket.MsgMhfOperateGuild)
+}
+
+// commentUpload lays the comment out in a MSG_MHF_OPERATE_GUILD as the client sends it, with the
+// trailing bytes that end every packet of a frame.
+func commentUpload(comment []byte) []byte {
+	bf := byteframe.NewByteFrame()
+	bf.WriteUint16(uint16(network.MSG_MHF_OPERATE_GUILD))
+	bf.WriteUint32(0x11223344)
+	bf.WriteUint32(1)
+	bf.WriteUint8(uint8(mhfpacket.OPERATE_GUILD_UPDATE_COMMENT))
+	bf.WriteUint8(uint8(len(comment) + 1))
+	bf.WriteUint32(0)
+	bf.WriteBytes(comment)
+	bf.WriteUint8(0x00)
+	bf.WriteBytes([]byte{0x00, 0x10})
+	return bf.Data()
+}
+
+func TestDecodeGuildComment(t *testing.T) {
+	s := newTestServer()
+	session, client := newTestSession(s)
+	t.Cleanup(func() { client.Close() })
+
+	comment, err := readGuildComment(session, parseOperateGuild(t, session, commentUpload(corruptingGuildComment)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comment != "Join[2J あ" {
+		t.Errorf("got comment %q", comment)
+	}
+	if _, err := stringsupport.ConvertUTF8ToShiftJIS(comment); err != nil {
+		t.Errorf("sanitized comment doesn't encode: %v", err)
+	}
+
+	s.erupeConfig.WordFilter = []string{"join"}
+	s.loadWordFilter()
+	if comment, _ := decodeGuildComment(session, []byte("JOIN us\x00")); comment != "**** us" {
+		t.Errorf("got comment %q, want the filtered word masked", comment)
+	}
+}
+
+func TestCapturedGuildComments(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join(fixtures.Dir(), capturedGuildCommentDir, "*"+fixtures.Extension))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Skipf("no captured guild comments in fixtures/%s", capturedGuildCommentDir)
+	}
+	s := newTestServer()
+	session, client := newTestSession(s)
+	t.Cleanup(func() { client.Close() })
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), fixtures.Extension)
+		data, err := fixtures.Load(capturedGuildCommentDir + "/" + name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		pkt := parseOperateGuild(t, session, data)
+		if pkt.Action != mhfpacket.OPERATE_GUILD_UPDATE_COMMENT {
+			t.Fatalf("%s: packet is operate guild action %d", name, pkt.Action)
+		}
+		comment, err := readGuildComment(session, pkt)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		encoded, err := stringsupport.ConvertUTF8ToShiftJIS(comment)
+		if err != nil || len(encoded) > guildCommentMaxLength {
+			t.Errorf("%s: comment %q encodes to %d bytes, %v", name, comment, len(encoded), err)
+		}
+		if sanitizeGuildComment(comment) != comment {
+			t.Errorf("%s: comment %q isn't sanitized", name, comment)
+		}
+	}
+}
+
+func TestSanitizeGuildComment(t *testing.T) {
+	// A comment saved before uploads were sanitized, too long for the length byte once encoded.
+	stored := "line one\nline two\x00\x7F" + strings.Repeat("あ", 200) + "\uFFFD"
+	comment := sanitizeGuildComment(stored)
+	encoded, err := stringsupport.ConvertUTF8ToShiftJIS(comment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encoded) > guildCommentMaxLength {
+		t.Errorf("got a comment of %d bytes, want at most %d", len(encoded), guildCommentMaxLength)
+	}
+	if !strings.HasPrefix(comment, "line one\nline twoあ") || strings.ContainsAny(comment, "\x00\x7F\uFFFD") {
+		t.Errorf("got comment %q", comment)
+	}
+	if sanitizeGuildComment(comment) != comment {
+		t.Error("sanitizing a clean comment changed it")
+	}
+}
+
+func TestGuildSearchListRendersBadRows(t *testing.T) {
+	s := newTestServer()
+	session, client := newTestSession(s)
+	t.Cleanup(func() { client.Close() })
+
+	guilds := []*Guild{
+		{ID: 1, Name: "Bad\x1B\uFFFD😀" + string(corruptingGuildComment), GuildLeader: GuildLeader{LeaderName: strings.Repeat("あ", 200)}},
+		{ID: 2, Name: "Fine", GuildLeader: GuildLeader{LeaderName: "Leader"}},
+	}
+	data, shown := buildGuildSearchResults(session, guilds)
+	if shown != 2 {
+		t.Fatalf("got %d guilds shown, want 2", shown)
+	}
+
+	bf := byteframe.NewByteFrameFromBytes(data)
+	bf.ReadUint16()
+	for _, want := range guilds {
+		bf.ReadBytes(1)
+		if id := bf.ReadUint32(); id != want.ID {
+			t.Fatalf("got guild %d, want %d, the list is misaligned", id, want.ID)
+		}
+		bf.ReadBytes(4 + 2 + 1 + 1 + 2 + 4)
+		for i := 0; i < 2; i++ {
+			text := bf.ReadBytes(uint(bf.ReadUint8()))
+			if len(text) == 0 || text[len(text)-1] != 0x00 || len(bfutil.UpToNull(text)) != len(text)-1 {
+				t.Fatalf("guild %d has a malformed name %x", want.ID, text)
+			}
+		}
+		bf.ReadUint8()
+	}
+}
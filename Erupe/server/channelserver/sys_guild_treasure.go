@@ -0,0 +1,361 @@
+package channelserver
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// errTreasureUnclaimable is returned for a run the character didn't go on, already claimed or that's left
+// from a week that ended.
+var errTreasureUnclaimable = errors.New("treasure run can't be claimed")
+
+// errTreasureNoGuild is returned for a treasure run hosted by a character outside a guild.
+var errTreasureNoGuild = errors.New("character isn't in a guild")
+
+// treasureRun is the quest instance a session departed on for a treasure quest and its party then,
+// the host first.
+type treasureRun struct {
+	instance string
+	party    []uint32
+}
+
+// TreasureWeekAt returns the treasure hunt week running at the given time and when it ends.
+// Weeks are numbered from 1, week 0 means treasure hunts are off or the first week hasn't started.
+func TreasureWeekAt(cfg config.TreasureHunt, now time.Time) (week int, ends time.Time, err error) {
+	if !cfg.Enabled {
+		return 0, time.Time{}, nil
+	}
+	return periodAt(cfg.Start, 7, now)
+}
+
+// treasureReward returns the payout of the highest tier the score reaches, nil below all of them.
+func treasureReward(rewards []config.TreasureReward, score uint32) []config.StarterItem {
+	var best *config.TreasureReward
+	for i, reward := range rewards {
+		if score >= reward.MinScore && (best == nil || reward.MinScore > best.MinScore) {
+			best = &rewards[i]
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return best.Items
+}
+
+// splitTreasure returns the part of the quantity the hunter in the slot gets out of a run with the
+// given number of hunters. The quantity is split by the shares of the slots the party filled, so a
+// smaller party splits the same payout, and a party slot without a share gets nothing. Each part is
+// rounded down, what the rounding leaves over goes to the quest host in slot 0.
+func splitTreasure(quantity uint16, shares []int, hunters int, slot int) uint16 {
+	share := func(i int) int {
+		if i < len(shares) {
+			return shares[i]
+		}
+		return 0
+	}
+	total := 0
+	for i := 0; i < hunters; i++ {
+		total += share(i)
+	}
+	if total == 0 {
+		return 0
+	}
+	part := int(quantity) * share(slot) / total
+	if slot == 0 {
+		given := 0
+		for i := 0; i < hunters; i++ {
+			given += int(quantity) * share(i) / total
+		}
+		part += int(quantity) - given
+	}
+	return uint16(part)
+}
+
+// currentTreasureWeek returns the running treasure hunt week, logging an invalid schedule.
+func (s *Server) currentTreasureWeek() int {
+	week, _, err := TreasureWeekAt(s.erupeConfig.TreasureHunt, time.Now())
+	if err != nil {
+		s.logger.Error("Invalid treasure hunt schedule", zap.Error(err))
+	}
+	return week
+}
+
+// treasureQuestScore returns the score of a run of the quest file, 0 if it isn't a treasure quest.
+func (s *Server) treasureQuestScore(filename string) uint32 {
+	cfg := s.erupeConfig.TreasureHunt
+	if !cfg.Enabled || filename == "" {
+		return 0
+	}
+	for _, quest := range cfg.Quests {
+		if quest.Quest == filename {
+			return quest.Score
+		}
+	}
+	return 0
+}
+
+// noteTreasureDeparture keeps the quest instance and party of the treasure quest the session departs on,
+// for the run recorded when it returns.
+func noteTreasureDeparture(s *Session) {
+	s.Lock()
+	stage, filename := s.stage, s.questFile
+	s.Unlock()
+	if stage == nil || s.server.treasureQuestScore(filename) == 0 {
+		return
+	}
+	stage.RLock()
+	host, created := stage.host, stage.created
+	party := make([]uint32, 0, len(stage.reservedClientSlots))
+	for charID := range stage.reservedClientSlots {
+		party = append(party, charID)
+	}
+	stage.RUnlock()
+	var hostID uint32
+	if host != nil {
+		hostID = host.charID
+	}
+	sort.Slice(party, func(i, j int) bool {
+		if party[i] == hostID || party[j] == hostID {
+			return party[i] == hostID
+		}
+		return party[i] < party[j]
+	})
+
+	s.Lock()
+	s.treasureRun = &treasureRun{fmt.Sprintf("%s/%s/%d", s.server.instanceID, stage.id, created.UnixNano()), party}
+	s.Unlock()
+}
+
+// earnTreasureShare records the treasure run the session returned from, if one of its party hasn't already,
+// and sends the session's share of its payout to the distribution counter. The server doesn't see whether
+// the quest was cleared, so any return from it counts, like for festa souls.
+func earnTreasureShare(s *Session, filename string, run *treasureRun) {
+	score := s.server.treasureQuestScore(filename)
+	week := s.server.currentTreasureWeek()
+	if run == nil || score == 0 || week == 0 {
+		return
+	}
+	runID, err := recordTreasureRun(s.server.db, run.instance, week, score, run.party)
+	if err == errTreasureNoGuild {
+		return
+	} else if err != nil {
+		s.logger.Error("Failed to record treasure run", zap.String("quest", filename), zap.Error(err))
+		return
+	}
+	items, err := claimTreasureRun(s.server.db, s.server.erupeConfig.TreasureHunt, s.charID, runID, week)
+	if err == errTreasureUnclaimable {
+		return
+	} else if err != nil {
+		s.logger.Error("Failed to claim treasure run", zap.Uint32("runID", runID), zap.Error(err))
+		return
+	}
+	if len(items) > 0 {
+		sendServerChatMessage(s, "Your share of the guild's treasure is waiting at the distribution counter.")
+	}
+}
+
+// recordTreasureRun records the treasure quest run of the quest instance for the guild of the party's host,
+// with the members of the party in that guild, the host in slot 0, and raises the guild's best score of the
+// week. A run already recorded for the instance is left as it is. It returns the run's ID.
+func recordTreasureRun(db *sqlx.DB, instance string, week int, score uint32, party []uint32) (uint32, error) {
+	if len(party) == 0 {
+		return 0, errTreasureNoGuild
+	}
+	var guildID uint32
+	err := db.QueryRow("SELECT guild_id FROM guild_characters WHERE character_id = $1", party[0]).Scan(&guildID)
+	if err == sql.ErrNoRows {
+		return 0, errTreasureNoGuild
+	} else if err != nil {
+		return 0, err
+	}
+	var members []uint32
+	err = db.Select(&members, "SELECT character_id FROM guild_characters WHERE guild_id = $1 AND character_id = ANY($2)", guildID, pq.Array(party))
+	if err != nil {
+		return 0, err
+	}
+	isMember := make(map[uint32]bool, len(members))
+	for _, member := range members {
+		isMember[member] = true
+	}
+	hunters := []uint32{party[0]}
+	for _, hunter := range party[1:] {
+		if isMember[hunter] {
+			hunters = append(hunters, hunter)
+			isMember[hunter] = false
+		}
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var runID uint32
+	err = tx.QueryRow(`
+		INSERT INTO guild_treasure_runs (instance, guild_id, week, score) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (instance) DO NOTHING RETURNING id
+	`, instance, guildID, week, score).Scan(&runID)
+	if err == sql.ErrNoRows {
+		err = tx.QueryRow("SELECT id FROM guild_treasure_runs WHERE instance = $1", instance).Scan(&runID)
+		return runID, err
+	} else if err != nil {
+		return 0, err
+	}
+	for slot, hunter := range hunters {
+		_, err = tx.Exec("INSERT INTO guild_treasure_hunters (run_id, character_id, slot) VALUES ($1, $2, $3)", runID, hunter, slot)
+		if err != nil {
+			return 0, err
+		}
+	}
+	_, err = tx.Exec(`
+		INSERT INTO guild_treasure_standings (guild_id, week, best_score) VALUES ($1, $2, $3)
+		ON CONFLICT (guild_id, week) DO UPDATE SET best_score = GREATEST(guild_treasure_standings.best_score, $3)
+	`, guildID, week, score)
+	if err != nil {
+		return 0, err
+	}
+	return runID, tx.Commit()
+}
+
+// claimTreasureRun sends the character's share of the run's payout to its distribution counter and
+// returns it. Each hunter claims once, only while the run's week is running.
+func claimTreasureRun(db *sqlx.DB, cfg config.TreasureHunt, charID uint32, runID uint32, week int) ([]DistItemEntry, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var score uint32
+	var slot int
+	err = tx.QueryRow(`
+		UPDATE guild_treasure_hunters h SET claimed = true FROM guild_treasure_runs r
+		WHERE h.run_id = $1 AND h.character_id = $2 AND NOT h.claimed AND r.id = h.run_id AND r.week = $3
+		RETURNING r.score, h.slot
+	`, runID, charID, week).Scan(&score, &slot)
+	if err == sql.ErrNoRows {
+		return nil, errTreasureUnclaimable
+	} else if err != nil {
+		return nil, err
+	}
+	var hunters int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM guild_treasure_hunters WHERE run_id = $1", runID).Scan(&hunters); err != nil {
+		return nil, err
+	}
+
+	var items []DistItemEntry
+	for _, item := range treasureReward(cfg.Rewards, score) {
+		if quantity := splitTreasure(item.Quantity, cfg.Shares, hunters, slot); quantity > 0 {
+			items = append(items, DistItemEntry{item.ItemType, item.ItemID, quantity})
+		}
+	}
+	if len(items) > 0 {
+		err = createCharacterDistribution(tx, charID, "Guild Treasure Reward", "~C05Your share of the guild's treasure", items)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return items, tx.Commit()
+}
+
+// treasureStanding is a guild's place in the week's treasure ranking.
+type treasureStanding struct {
+	Rank      uint16 `db:"rank"`
+	GuildID   uint32 `db:"guild_id"`
+	Name      string `db:"name"`
+	BestScore uint32 `db:"best_score"`
+}
+
+// treasureRanking returns the best guilds of the week by their best score, at most limit of them.
+func treasureRanking(db *sqlx.DB, week int, limit int) ([]treasureStanding, error) {
+	standings := []treasureStanding{}
+	err := db.Select(&standings, `
+		SELECT rank() OVER (ORDER BY t.best_score DESC) AS rank, t.guild_id, g.name, t.best_score
+		FROM guild_treasure_standings t JOIN guilds g ON g.id = t.guild_id
+		WHERE t.week = $1
+		ORDER BY t.best_score DESC, t.guild_id
+		LIMIT $2
+	`, week, limit)
+	return standings, err
+}
+
+// guildTreasureStanding returns the place of the guild in the week's ranking, false if it has no score yet.
+func guildTreasureStanding(db *sqlx.DB, guildID uint32, week int) (treasureStanding, bool, error) {
+	var standing treasureStanding
+	err := db.Get(&standing, `
+		SELECT rank, guild_id, '' AS name, best_score FROM (
+			SELECT rank() OVER (ORDER BY best_score DESC) AS rank, guild_id, best_score
+			FROM guild_treasure_standings WHERE week = $2
+		) ranked WHERE guild_id = $1
+	`, guildID, week)
+	if err == sql.ErrNoRows {
+		return standing, false, nil
+	}
+	return standing, err == nil, err
+}
+
+// archiveTreasureWeeks moves the standings of the weeks before the given one to the archive with their
+// final ranks and drops their runs, along with the shares nobody claimed. It's safe to run from every channel.
+func archiveTreasureWeeks(db *sqlx.DB, before int) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`
+		WITH ended AS (
+			DELETE FROM guild_treasure_standings WHERE week < $1 RETURNING week, guild_id, best_score
+		)
+		INSERT INTO guild_treasure_archive (week, guild_id, rank, best_score)
+		SELECT week, guild_id, rank() OVER (PARTITION BY week ORDER BY best_score DESC), best_score FROM ended
+		ON CONFLICT DO NOTHING
+	`, before)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM guild_treasure_runs WHERE week < $1", before); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// scheduleTreasureWeekEnd archives each treasure hunt week as it ends, along with any that ended before
+// the channel started.
+func (s *Server) scheduleTreasureWeekEnd() {
+	week, ends, err := TreasureWeekAt(s.erupeConfig.TreasureHunt, time.Now())
+	if err != nil {
+		s.logger.Error("Invalid treasure hunt schedule", zap.Error(err))
+		return
+	}
+	if ends.IsZero() {
+		return
+	}
+	if week > 0 {
+		if err := archiveTreasureWeeks(s.db, week); err != nil {
+			s.logger.Error("Failed to archive treasure hunt standings", zap.Error(err))
+		}
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.isShuttingDown {
+		return
+	}
+	s.treasureWeekTimer = time.AfterFunc(time.Until(ends), s.scheduleTreasureWeekEnd)
+}
+
+// stopTreasureWeekEnd cancels the pending week end.
+func (s *Server) stopTreasureWeekEnd() {
+	s.Lock()
+	defer s.Unlock()
+	if s.treasureWeekTimer != nil {
+		s.treasureWeekTimer.Stop()
+	}
+}
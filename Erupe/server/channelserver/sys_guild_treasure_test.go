@@ -0,0 +1,188 @@
+package channelserver
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/common/testdb"
+	"github.com/Solenataris/Erupe/config"
+)
+
+func TestSplitTreasure(t *testing.T) {
+	shares := []int{40, 20, 20, 20}
+	tests := []struct {
+		quantity uint16
+		hunters  int
+		want     []uint16
+	}{
+		{10, 4, []uint16{4, 2, 2, 2}},
+		{10, 2, []uint16{7, 3}}, // 40:20 of 10 is 6.66 and 3.33, the host gets what rounding left.
+		{10, 1, []uint16{10}},
+		{1, 4, []uint16{1, 0, 0, 0}},
+		{10, 5, []uint16{4, 2, 2, 2, 0}}, // A fifth slot has no share.
+	}
+	for _, tt := range tests {
+		got := make([]uint16, tt.hunters)
+		total := 0
+		for slot := range got {
+			got[slot] = splitTreasure(tt.quantity, shares, tt.hunters, slot)
+			total += int(got[slot])
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d split between %d: got %v, want %v", tt.quantity, tt.hunters, got, tt.want)
+		}
+		if total != int(tt.quantity) {
+			t.Errorf("%d split between %d paid out %d", tt.quantity, tt.hunters, total)
+		}
+	}
+}
+
+func TestTreasureReward(t *testing.T) {
+	rewards := []config.TreasureReward{
+		{MinScore: 500, Items: []config.StarterItem{{ItemType: 7, ItemID: 2, Quantity: 1}}},
+		{MinScore: 100, Items: []config.StarterItem{{ItemType: 7, ItemID: 1, Quantity: 1}}},
+	}
+	if items := treasureReward(rewards, 99); items != nil {
+		t.Errorf("got %v below every tier", items)
+	}
+	if items := treasureReward(rewards, 100); items[0].ItemID != 1 {
+		t.Errorf("got %v at the lower tier", items)
+	}
+	if items := treasureReward(rewards, 900); items[0].ItemID != 2 {
+		t.Errorf("got %v past the upper tier", items)
+	}
+}
+
+// TestTreasureWeek runs against the database in ERUPE_TEST_DB, e.g.
+// "host=localhost user=postgres password=admin dbname=erupe_test sslmode=disable".
+// A guild records two runs, is ranked by the best one, its hunters claim their shares of it and the week ends.
+func TestTreasureWeek(t *testing.T) {
+	db := testdb.Open(t)
+
+	var userID uint32
+	if err := db.QueryRow("INSERT INTO users (username, password) VALUES ('treasure_test', '') RETURNING id").Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+	chars := make([]uint32, 3) // Two members and an outsider.
+	for i := range chars {
+		if err := db.QueryRow("INSERT INTO characters (user_id, is_new_character, name) VALUES ($1, false, 'treasure_test') RETURNING id", userID).Scan(&chars[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer db.Exec("DELETE FROM characters WHERE user_id = $1", userID)
+	defer db.Exec("DELETE FROM distribution WHERE character_id = ANY(SELECT id FROM characters WHERE user_id = $1)", userID)
+	var guildID uint32
+	if err := db.QueryRow("INSERT INTO guilds (name, leader_id) VALUES ('TreasureTest', $1) RETURNING id", chars[0]).Scan(&guildID); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM guilds WHERE id = $1", guildID)
+	defer db.Exec("DELETE FROM guild_characters WHERE guild_id = $1", guildID)
+	defer db.Exec("DELETE FROM guild_treasure_archive WHERE guild_id = $1", guildID)
+	for _, charID := range chars[:2] {
+		if _, err := db.Exec("INSERT INTO guild_characters (guild_id, character_id) VALUES ($1, $2)", guildID, charID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const week = 1_000_000 // Far from any week a real schedule is in.
+	cfg := config.TreasureHunt{
+		Shares:  []int{40, 20, 20, 20},
+		Rewards: []config.TreasureReward{{MinScore: 100, Items: []config.StarterItem{{ItemType: 7, ItemID: 100, Quantity: 9}}}},
+	}
+	defer db.Exec("DELETE FROM guild_treasure_runs WHERE instance LIKE 'treasure_test/%'")
+	if _, err := recordTreasureRun(db, "treasure_test/1", week, 80, []uint32{chars[0]}); err != nil {
+		t.Fatal(err)
+	}
+	// The outsider is left out of the run, the host takes the first slot.
+	runID, err := recordTreasureRun(db, "treasure_test/2", week, 300, []uint32{chars[1], chars[2], chars[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each hunter returning from the instance records it, only the first one counts.
+	if again, err := recordTreasureRun(db, "treasure_test/2", week, 300, []uint32{chars[1], chars[2], chars[0]}); err != nil || again != runID {
+		t.Fatalf("recording the instance again got run %d, %v, want %d", again, err, runID)
+	}
+
+	standing, ok, err := guildTreasureStanding(db, guildID, week)
+	if err != nil || !ok || standing.BestScore != 300 || standing.Rank != 1 {
+		t.Fatalf("got standing %+v, %v, %v, want the best run ranked first", standing, ok, err)
+	}
+	ranking, err := treasureRanking(db, week, 10)
+	if err != nil || len(ranking) != 1 || ranking[0].Name != "TreasureTest" {
+		t.Fatalf("got ranking %+v, %v", ranking, err)
+	}
+
+	// 40:20 of 9 items.
+	for charID, want := range map[uint32]uint16{chars[1]: 6, chars[0]: 3} {
+		items, err := claimTreasureRun(db, cfg, charID, runID, week)
+		if err != nil || len(items) != 1 || items[0].Quantity != want {
+			t.Errorf("claim of %d got %+v, %v, want %d items", charID, items, err, want)
+		}
+	}
+	if _, err := claimTreasureRun(db, cfg, chars[0], runID, week); err != errTreasureUnclaimable {
+		t.Errorf("second claim got %v", err)
+	}
+	if _, err := claimTreasureRun(db, cfg, chars[2], runID, week); err != errTreasureUnclaimable {
+		t.Errorf("outsider's claim got %v", err)
+	}
+
+	// A run left unclaimed when the week ends can't be claimed afterwards.
+	unclaimed, err := recordTreasureRun(db, "treasure_test/3", week, 100, []uint32{chars[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := archiveTreasureWeeks(db, week+1); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := guildTreasureStanding(db, guildID, week); ok {
+		t.Error("the week's standing is still live after it ended")
+	}
+	var archived struct {
+		Rank      int `db:"rank"`
+		BestScore int `db:"best_score"`
+	}
+	if err := db.Get(&archived, "SELECT rank, best_score FROM guild_treasure_archive WHERE week = $1 AND guild_id = $2", week, guildID); err != nil || archived.BestScore != 300 {
+		t.Errorf("archived %+v, %v, want the best score", archived, err)
+	}
+	if _, err := claimTreasureRun(db, cfg, chars[0], unclaimed, week); err != errTreasureUnclaimable {
+		t.Errorf("claim after the week ended got %v", err)
+	}
+}
+
+func TestTreasureWeekAt(t *testing.T) {
+	start := time.Date(2022, 6, 6, 0, 0, 0, 0, time.UTC)
+	cfg := config.TreasureHunt{Enabled: true, Start: start.Format(time.RFC3339)}
+	week, ends, err := TreasureWeekAt(cfg, start.Add(8*24*time.Hour))
+	if err != nil || week != 2 || !ends.Equal(start.Add(14*24*time.Hour)) {
+		t.Errorf("got week %d ending %v, %v", week, ends, err)
+	}
+}
+
+func TestNoteTreasureDeparture(t *testing.T) {
+	s := newTestServer()
+	s.erupeConfig.TreasureHunt = config.TreasureHunt{Enabled: true, Quests: []config.TreasureQuest{{Quest: "23045d0", Score: 300}}}
+	stage, _ := s.GetOrCreateStage("sl1Qs210p0a2u0")
+	host := newQueuedSession(t, s, 3, "Host")
+	stage.host = host
+	for _, charID := range []uint32{5, 3, 1} {
+		stage.reservedClientSlots[charID] = nil
+	}
+	if s.treasureQuestScore("23045d1") != 0 || s.treasureQuestScore("23045d0") != 300 {
+		t.Fatal("treasure quest scores don't come from the config")
+	}
+
+	member := newQueuedSession(t, s, 5, "Member")
+	member.stage = stage
+	member.questFile = "23045d1"
+	noteTreasureDeparture(member)
+	if member.treasureRun != nil {
+		t.Fatalf("departing on another quest kept run %+v", member.treasureRun)
+	}
+	member.questFile = "23045d0"
+	noteTreasureDeparture(member)
+	if run := member.treasureRun; run == nil || !reflect.DeepEqual(run.party, []uint32{3, 1, 5}) {
+		t.Fatalf("got run %+v, want the host's party with the host first", run)
+	}
+}
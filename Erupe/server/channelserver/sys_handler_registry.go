@@ -0,0 +1,192 @@
+package channelserver
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"go.uber.org/zap"
+)
+
+// handlerMiddleware wraps a handler with cross-cutting behaviour such as metrics or rate limiting.
+// A middleware that doesn't call next stops the packet from reaching the handler.
+type handlerMiddleware func(opcode network.PacketID, next handlerFunc) handlerFunc
+
+type handlerEntry struct {
+	handler    handlerFunc
+	requires   sessionState
+	middleware []handlerMiddleware
+}
+
+// handlerRegistry maps opcodes to their handlers and the middleware chain run before them.
+type handlerRegistry struct {
+	sync.RWMutex
+	entries    map[network.PacketID]*handlerEntry
+	middleware []handlerMiddleware
+	logger     *zap.Logger
+	outOfOrder uint64 // Packets rejected for arriving before their session got far enough. Accessed atomically.
+}
+
+// Opcodes that can only be handled once the session has sent MSG_SYS_LOGIN.
+var loginRequiredOpcodes = []network.PacketID{
+	network.MSG_SYS_ENTER_STAGE,
+	network.MSG_SYS_MOVE_STAGE,
+	network.MSG_SYS_BACK_STAGE,
+	network.MSG_SYS_RESERVE_STAGE,
+	network.MSG_SYS_CREATE_OBJECT,
+	network.MSG_SYS_CAST_BINARY,
+	network.MSG_MHF_SAVEDATA,
+	network.MSG_MHF_LOADDATA,
+}
+
+// Opcodes acting on the session's stage, which can only be handled once it has entered one. A client
+// reconnecting sends them for the stage it was in before the drop, ahead of entering any.
+var stageRequiredOpcodes = []network.PacketID{
+	network.MSG_SYS_BACK_STAGE,
+	network.MSG_SYS_CAST_BINARY,
+	network.MSG_SYS_CREATE_OBJECT,
+	network.MSG_SYS_DUPLICATE_OBJECT,
+	network.MSG_SYS_POSITION_OBJECT,
+	network.MSG_SYS_ROTATE_OBJECT,
+	network.MSG_SYS_SET_OBJECT_BINARY,
+}
+
+func newHandlerRegistry(logger *zap.Logger) *handlerRegistry {
+	return &handlerRegistry{
+		entries: make(map[network.PacketID]*handlerEntry),
+		logger:  logger,
+	}
+}
+
+// newDefaultHandlerRegistry builds a registry populated with every handler from handlerTable.
+func newDefaultHandlerRegistry(logger *zap.Logger) *handlerRegistry {
+	r := newHandlerRegistry(logger)
+	for opcode, handler := range handlerTable {
+		r.register(opcode, handler)
+	}
+	for _, opcode := range loginRequiredOpcodes {
+		r.requireLogin(opcode)
+	}
+	for _, opcode := range stageRequiredOpcodes {
+		r.requireStage(opcode)
+	}
+	r.use(stateMiddleware(r))
+	return r
+}
+
+// register sets the handler for an opcode, replacing any previous one.
+func (r *handlerRegistry) register(opcode network.PacketID, handler handlerFunc) {
+	r.Lock()
+	defer r.Unlock()
+	if entry, exists := r.entries[opcode]; exists {
+		entry.handler = handler
+		return
+	}
+	r.entries[opcode] = &handlerEntry{handler: handler}
+}
+
+// require marks an opcode as only being valid once the session has reached the state.
+func (r *handlerRegistry) require(opcode network.PacketID, state sessionState) {
+	r.Lock()
+	defer r.Unlock()
+	if entry, exists := r.entries[opcode]; exists && entry.requires < state {
+		entry.requires = state
+	}
+}
+
+// requireLogin marks an opcode as only being valid after MSG_SYS_LOGIN.
+func (r *handlerRegistry) requireLogin(opcode network.PacketID) {
+	r.require(opcode, sessionLoggedIn)
+}
+
+// requireStage marks an opcode as only being valid once the session has entered a stage.
+func (r *handlerRegistry) requireStage(opcode network.PacketID) {
+	r.require(opcode, sessionInStage)
+}
+
+// use appends middleware that runs for every opcode.
+func (r *handlerRegistry) use(middleware ...handlerMiddleware) {
+	r.Lock()
+	defer r.Unlock()
+	r.middleware = append(r.middleware, middleware...)
+}
+
+// useFor appends middleware that only runs for the given opcode, after the global middleware.
+func (r *handlerRegistry) useFor(opcode network.PacketID, middleware ...handlerMiddleware) {
+	r.Lock()
+	defer r.Unlock()
+	if entry, exists := r.entries[opcode]; exists {
+		entry.middleware = append(entry.middleware, middleware...)
+	}
+}
+
+// dispatch runs the middleware chain and handler registered for the opcode.
+func (r *handlerRegistry) dispatch(s *Session, opcode network.PacketID, pkt mhfpacket.MHFPacket) {
+	r.RLock()
+	entry, exists := r.entries[opcode]
+	if !exists {
+		r.RUnlock()
+		r.unhandled(s, opcode)
+		return
+	}
+	chain := make([]handlerMiddleware, 0, len(r.middleware)+len(entry.middleware))
+	chain = append(chain, r.middleware...)
+	chain = append(chain, entry.middleware...)
+	handler := entry.handler
+	r.RUnlock()
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		handler = chain[i](opcode, handler)
+	}
+	handler(s, pkt)
+}
+
+func (r *handlerRegistry) unhandled(s *Session, opcode network.PacketID) {
+	r.logger.Warn("Unhandled opcode", zap.String("opcode", opcode.String()), zap.Uint32("charID", s.charID))
+}
+
+// requiredState returns the state a session has to reach before the opcode is handled.
+func (r *handlerRegistry) requiredState(opcode network.PacketID) sessionState {
+	r.RLock()
+	defer r.RUnlock()
+	if entry, exists := r.entries[opcode]; exists {
+		return entry.requires
+	}
+	return sessionConnected
+}
+
+// stateMiddleware rejects opcodes arriving before the session reached the state they require, failing
+// their ack so the client gives up on the request rather than waiting on it. The handlers past it can
+// rely on the session's character, and on its stage for the stage scoped opcodes.
+func stateMiddleware(r *handlerRegistry) handlerMiddleware {
+	return func(opcode network.PacketID, next handlerFunc) handlerFunc {
+		return func(s *Session, p mhfpacket.MHFPacket) {
+			required := r.requiredState(opcode)
+			if state := s.state(); state < required {
+				s.logger.Warn("Rejected packet out of order",
+					zap.String("opcode", opcode.String()),
+					zap.Stringer("state", state),
+					zap.Stringer("required", required),
+					zap.Uint64("outOfOrder", atomic.AddUint64(&r.outOfOrder, 1)),
+				)
+				if ackHandle, ok := packetAckHandle(p); ok {
+					doAckSimpleFail(s, ackHandle, make([]byte, 4))
+				}
+				return
+			}
+			next(s, p)
+		}
+	}
+}
+
+// saveTrackingMiddleware marks the save as in flight so shutdown waits for it to commit.
+func saveTrackingMiddleware(server *Server) handlerMiddleware {
+	return func(opcode network.PacketID, next handlerFunc) handlerFunc {
+		return func(s *Session, p mhfpacket.MHFPacket) {
+			server.beginSave()
+			defer server.endSave()
+			next(s, p)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package channelserver
+
+import (
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/binpacket"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"go.uber.org/zap"
+)
+
+const fakeOpcode = network.PacketID(0xFFF0)
+
+func recordingMiddleware(name string, calls *[]string) handlerMiddleware {
+	return func(opcode network.PacketID, next handlerFunc) handlerFunc {
+		return func(s *Session, p mhfpacket.MHFPacket) {
+			*calls = append(*calls, name)
+			next(s, p)
+		}
+	}
+}
+
+func TestHandlerRegistryMiddlewareOrder(t *testing.T) {
+	var calls []string
+	r := newHandlerRegistry(zap.NewNop())
+	r.register(fakeOpcode, func(s *Session, p mhfpacket.MHFPacket) {
+		calls = append(calls, "handler")
+	})
+	r.use(recordingMiddleware("global1", &calls), recordingMiddleware("global2", &calls))
+	r.useFor(fakeOpcode, recordingMiddleware("opcode", &calls))
+
+	r.dispatch(&Session{}, fakeOpcode, nil)
+
+	want := []string{"global1", "global2", "opcode", "handler"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("got call order %v, want %v", calls, want)
+	}
+}
+
+func TestHandlerRegistryRequiresLogin(t *testing.T) {
+	s := newTestServer()
+	session, client := newTestSession(s)
+	defer client.Close()
+	session.logger = zap.NewNop()
+
+	called := false
+	s.handlers.register(fakeOpcode, func(s *Session, p mhfpacket.MHFPacket) {
+		called = true
+	})
+	s.handlers.requireLogin(fakeOpcode)
+
+	s.handlers.dispatch(session, fakeOpcode, nil)
+	if called {
+		t.Fatal("handler ran before login")
+	}
+
+	session.charID = 1
+	s.handlers.dispatch(session, fakeOpcode, nil)
+	if !called {
+		t.Fatal("handler did not run after login")
+	}
+}
+
+func TestHandlerRegistryRequiresStage(t *testing.T) {
+	s := newTestServer()
+	session := newQueuedSession(t, s, 100, "Hunter")
+
+	// A client that reconnected sends stage packets of the stage it was in before entering any.
+	for _, pkt := range []mhfpacket.MHFPacket{
+		&mhfpacket.MsgSysPositionObject{ObjID: 1, X: 10},
+		&mhfpacket.MsgSysRotateObject{},
+		&mhfpacket.MsgSysSetObjectBinary{ObjID: 1},
+		chatPacket(binpacket.ChatTypeLocal, BroadcastTypeStage, "Hello"),
+		&mhfpacket.MsgSysCreateObject{AckHandle: 0x10},
+		&mhfpacket.MsgSysBackStage{AckHandle: 0x11},
+	} {
+		s.handlers.dispatch(session, pkt.Opcode(), pkt)
+	}
+	if got := atomic.LoadUint64(&s.handlers.outOfOrder); got != 6 {
+		t.Errorf("counted %d packets out of order, want 6", got)
+	}
+	bf := byteframe.NewByteFrameFromBytes(sentPackets(session))
+	for _, want := range []uint32{0x10, 0x11} {
+		if opcode := network.PacketID(bf.ReadUint16()); opcode != network.MSG_SYS_ACK {
+			t.Fatalf("sent %s, want MSG_SYS_ACK", opcode)
+		}
+		ackHandle := bf.ReadUint32()
+		bf.ReadUint8() // Is buffer response.
+		if errorCode := bf.ReadUint8(); ackHandle != want || errorCode == 0 {
+			t.Errorf("ack %#x with error code %d, want a failed ack of %#x", ackHandle, errorCode, want)
+		}
+		bf.ReadBytes(6) // Ack data and its length.
+		bf.ReadUint16() // MSG_SYS_END.
+	}
+
+	stage := moveToStage(t, session, "sl1Ns200p0a0u0")
+	s.handlers.dispatch(session, network.MSG_SYS_CREATE_OBJECT, &mhfpacket.MsgSysCreateObject{AckHandle: 0x12})
+	stage.RLock()
+	objects := len(stage.objects)
+	stage.RUnlock()
+	if objects != 1 || atomic.LoadUint64(&s.handlers.outOfOrder) != 6 {
+		t.Errorf("a session in a stage has %d objects after creating one", objects)
+	}
+}
+
+func TestHandlerRegistryUnhandledOpcode(t *testing.T) {
+	r := newHandlerRegistry(zap.NewNop())
+	// Must not panic on an opcode with no handler.
+	r.dispatch(&Session{}, fakeOpcode, nil)
+}
@@ -0,0 +1,169 @@
+package channelserver
+
+import (
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/common/distpayload"
+	"github.com/Solenataris/Erupe/common/itemtable"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+)
+
+// itemBoxCache is the session's copy of the account's shared item box and the enumeration reply built
+// from it. Only the session changes the box while the account is online, the admin API refuses to, so
+// the copy stays current until MSG_MHF_UPDATE_UNION_ITEM replaces it.
+type itemBoxCache struct {
+	items []Item
+	reply []byte // MSG_MHF_ENUMERATE_UNION_ITEM reply, built on the first enumeration of the items.
+}
+
+// itemBox returns the account's shared item box, loading it on the session's first use.
+func (s *Session) itemBox() ([]Item, error) {
+	s.itemBoxLock.Lock()
+	defer s.itemBoxLock.Unlock()
+	if s.itemBoxCache == nil {
+		var data []byte
+		err := s.server.db.QueryRow("SELECT item_box FROM users, characters WHERE characters.id = $1 AND users.id = characters.user_id", int(s.charID)).Scan(&data)
+		if err != nil {
+			return nil, err
+		}
+		s.itemBoxCache = &itemBoxCache{items: DecodeItemBox(data)}
+	}
+	return s.itemBoxCache.items, nil
+}
+
+// itemBoxReply returns the enumeration reply of the item box, building it once per change of the box.
+func (s *Session) itemBoxReply() ([]byte, error) {
+	if _, err := s.itemBox(); err != nil {
+		return nil, err
+	}
+	s.itemBoxLock.Lock()
+	defer s.itemBoxLock.Unlock()
+	if s.itemBoxCache.reply == nil {
+		s.itemBoxCache.reply = buildUnionItemReply(s.itemBoxCache.items)
+	}
+	return s.itemBoxCache.reply, nil
+}
+
+// setItemBox replaces the session's copy of the item box once the new one is stored.
+func (s *Session) setItemBox(items []Item) {
+	s.itemBoxLock.Lock()
+	defer s.itemBoxLock.Unlock()
+	s.itemBoxCache = &itemBoxCache{items: items}
+}
+
+// buildUnionItemReply builds the MSG_MHF_ENUMERATE_UNION_ITEM reply listing the items. The client asks
+// for the whole box at once, the request has no page or offset to answer with a part of it.
+func buildUnionItemReply(items []Item) []byte {
+	if len(items) == 0 {
+		return make([]byte, 4)
+	}
+	bf := byteframe.NewByteFrame()
+	bf.WriteUint16(uint16(len(items)))
+	bf.WriteUint32(0x00)
+	bf.WriteUint16(0x00)
+	for i, item := range items {
+		bf.WriteUint16(item.ItemId)
+		bf.WriteUint16(item.Amount)
+		if i+1 != len(items) {
+			bf.WriteUint64(0x00)
+		}
+	}
+	return bf.Data()
+}
+
+// itemBoxViolation is an update of the item box an unmodified client wouldn't send.
+type itemBoxViolation struct {
+	itemID uint16
+	amount uint16 // Amount the update set the stack to.
+	reason string
+}
+
+// plausibleItem reports whether the item ID could be an item, whether or not the item table lists it.
+func plausibleItem(id uint16) bool {
+	return distpayload.Validate([]distpayload.Item{{Type: distpayload.TypeItem, ID: id, Quantity: 1}}) == nil
+}
+
+// checkUnionItems returns the updates of the item box the client can make, the violations among them
+// and the kept updates of items the item table doesn't list. An update of an item that can't exist, of
+// an item already updated by the packet or of a rare item whose stack grows by more than a slot of the
+// pouch holds is dropped, one past the item's max stack is cut back to it. An item missing from the
+// table is let through unchecked, the table may not list every item yet, and returned to be logged
+// so the table can be completed.
+func checkUnionItems(items *itemtable.Table, oldItems []Item, updates []mhfpacket.Item) ([]mhfpacket.Item, []itemBoxViolation, []mhfpacket.Item) {
+	held := make(map[uint16]uint16, len(oldItems))
+	for _, item := range oldItems {
+		held[item.ItemId] = item.Amount
+	}
+	var kept []mhfpacket.Item
+	var violations []itemBoxViolation
+	var unlisted []mhfpacket.Item
+	updated := make(map[uint16]bool, len(updates))
+	for _, update := range updates {
+		violation := itemBoxViolation{itemID: update.ItemId, amount: update.Amount}
+		if !plausibleItem(update.ItemId) {
+			violation.reason = "invalid item"
+			violations = append(violations, violation)
+			continue
+		}
+		if updated[update.ItemId] {
+			violation.reason = "item updated twice"
+			violations = append(violations, violation)
+			continue
+		}
+		updated[update.ItemId] = true
+		if items == nil {
+			kept = append(kept, update)
+			continue
+		}
+		if !items.Has(update.ItemId) {
+			unlisted = append(unlisted, update)
+			kept = append(kept, update)
+			continue
+		}
+		if items.Rare(update.ItemId) {
+			// A deposit brings at most one slot of the pouch, taken as a single item if its size isn't known.
+			deposit := uint32(items.PouchStack(update.ItemId))
+			if deposit == 0 {
+				deposit = 1
+			}
+			if uint32(update.Amount) > uint32(held[update.ItemId])+deposit {
+				violation.reason = "rare item added without a source"
+				violations = append(violations, violation)
+				continue
+			}
+		}
+		if maxStack := items.MaxStack(update.ItemId); maxStack > 0 && update.Amount > maxStack {
+			violation.reason = "over the max stack"
+			violations = append(violations, violation)
+			update.Amount = maxStack
+		}
+		kept = append(kept, update)
+	}
+	return kept, violations, unlisted
+}
+
+// updateUnionItems returns the box with the updated stacks: each sets the amount of its item's stack,
+// starting one if the box has none, and the stacks left empty are dropped. The old box is left as is.
+func updateUnionItems(oldItems []Item, updates []mhfpacket.Item) []Item {
+	newItems := make([]Item, len(oldItems))
+	copy(newItems, oldItems)
+	for _, update := range updates {
+		for j := 0; j <= len(oldItems); j++ {
+			if j == len(oldItems) {
+				newItems = append(newItems, Item{ItemId: update.ItemId, Amount: update.Amount})
+				break
+			}
+			if update.ItemId == oldItems[j].ItemId {
+				newItems[j].Amount = update.Amount
+				break
+			}
+		}
+	}
+
+	kept := newItems[:0]
+	for _, item := range newItems {
+		if item.Amount != 0 {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,157 @@
+package channelserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Solenataris/Erupe/common/itemtable"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+)
+
+// itemBoxOf returns a box of n stacks of distinct items.
+func itemBoxOf(n int) []Item {
+	items := make([]Item, n)
+	for i := range items {
+		items[i] = Item{ItemId: uint16(i + 1), Amount: uint16(i%99 + 1)}
+	}
+	return items
+}
+
+func TestBuildUnionItemReply(t *testing.T) {
+	if got := buildUnionItemReply(nil); !bytes.Equal(got, make([]byte, 4)) {
+		t.Errorf("empty box got reply %x", got)
+	}
+	items := itemBoxOf(3)
+	reply := buildUnionItemReply(items)
+	if len(reply) != 8+len(items)*12-8 {
+		t.Fatalf("got a reply of %d bytes for %d stacks", len(reply), len(items))
+	}
+	if count := binary.BigEndian.Uint16(reply); int(count) != len(items) {
+		t.Errorf("reply counts %d stacks, want %d", count, len(items))
+	}
+	for i, item := range items {
+		offset := 8 + i*12
+		if id, amount := binary.BigEndian.Uint16(reply[offset:]), binary.BigEndian.Uint16(reply[offset+2:]); id != item.ItemId || amount != item.Amount {
+			t.Errorf("stack %d is %d x%d, want %d x%d", i, id, amount, item.ItemId, item.Amount)
+		}
+	}
+}
+
+func TestUpdateUnionItems(t *testing.T) {
+	old := []Item{{ItemId: 1, Amount: 5}, {ItemId: 2, Amount: 3}, {ItemId: 3, Amount: 1}}
+	got := updateUnionItems(old, []mhfpacket.Item{
+		{ItemId: 2, Amount: 0},
+		{ItemId: 3, Amount: 10},
+		{ItemId: 4, Amount: 7},
+	})
+	want := []Item{{ItemId: 1, Amount: 5}, {ItemId: 3, Amount: 10}, {ItemId: 4, Amount: 7}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got box %v, want %v", got, want)
+	}
+	if old[1].Amount != 3 {
+		t.Error("the update changed the old box")
+	}
+}
+
+// itemBoxFixture is a small item table: a potion stacking to 10, a herb of any stack and a rare gem
+// stacking to 99 in the box and 3 in the pouch.
+const itemBoxFixture = "id,name,maxStack,pouchStack,rare\n1,Potion,10,10\n2,Herb\n3,Gem,99,3,rare\n"
+
+func TestCheckUnionItems(t *testing.T) {
+	items, err := itemtable.Parse(strings.NewReader(itemBoxFixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := []Item{{ItemId: 1, Amount: 5}, {ItemId: 3, Amount: 2}}
+	tests := []struct {
+		name    string
+		updates []mhfpacket.Item
+		kept    []mhfpacket.Item
+		reason  string
+	}{
+		{"valid", []mhfpacket.Item{{ItemId: 1, Amount: 8}, {ItemId: 2, Amount: 500}}, []mhfpacket.Item{{ItemId: 1, Amount: 8}, {ItemId: 2, Amount: 500}}, ""},
+		{"invalid item", []mhfpacket.Item{{ItemId: 0, Amount: 1}, {ItemId: 1, Amount: 6}}, []mhfpacket.Item{{ItemId: 1, Amount: 6}}, "invalid item"},
+		{"over the max stack", []mhfpacket.Item{{ItemId: 1, Amount: 99}}, []mhfpacket.Item{{ItemId: 1, Amount: 10}}, "over the max stack"},
+		{"rare deposit", []mhfpacket.Item{{ItemId: 3, Amount: 5}}, []mhfpacket.Item{{ItemId: 3, Amount: 5}}, ""},
+		{"rare without a source", []mhfpacket.Item{{ItemId: 3, Amount: 6}, {ItemId: 2, Amount: 1}}, []mhfpacket.Item{{ItemId: 2, Amount: 1}}, "rare item added without a source"},
+		{"rare withdrawal", []mhfpacket.Item{{ItemId: 3, Amount: 0}}, []mhfpacket.Item{{ItemId: 3, Amount: 0}}, ""},
+		{"updated twice", []mhfpacket.Item{{ItemId: 2, Amount: 4}, {ItemId: 2, Amount: 4}}, []mhfpacket.Item{{ItemId: 2, Amount: 4}}, "item updated twice"},
+	}
+	for _, tt := range tests {
+		kept, violations, _ := checkUnionItems(items, old, tt.updates)
+		if !reflect.DeepEqual(kept, tt.kept) {
+			t.Errorf("%s: kept %v, want %v", tt.name, kept, tt.kept)
+		}
+		if tt.reason == "" && len(violations) != 0 {
+			t.Errorf("%s: got violations %v", tt.name, violations)
+		} else if tt.reason != "" && (len(violations) != 1 || violations[0].reason != tt.reason) {
+			t.Errorf("%s: got violations %v, want %q", tt.name, violations, tt.reason)
+		}
+	}
+
+	// An item the table doesn't list is kept as it is and returned to be logged.
+	update := []mhfpacket.Item{{ItemId: 4, Amount: 500}}
+	kept, violations, unlisted := checkUnionItems(items, old, update)
+	if !reflect.DeepEqual(kept, update) || len(violations) != 0 || !reflect.DeepEqual(unlisted, update) {
+		t.Errorf("an item missing from the table kept %v with violations %v, unlisted %v", kept, violations, unlisted)
+	}
+
+	// Without an item table any plausible item ID goes.
+	kept, violations, unlisted = checkUnionItems(nil, old, []mhfpacket.Item{{ItemId: 0, Amount: 1}, {ItemId: 9999, Amount: 9999}})
+	if len(kept) != 1 || kept[0].ItemId != 9999 || len(violations) != 1 || len(unlisted) != 0 {
+		t.Errorf("without an item table kept %v with violations %v", kept, violations)
+	}
+}
+
+func TestItemBoxReplyFollowsUpdates(t *testing.T) {
+	s := newTestServer()
+	session := newQueuedSession(t, s, 100, "Hunter")
+	// The box is loaded from the DB on first use, the test server has none.
+	session.setItemBox(itemBoxOf(2))
+
+	first, err := session.itemBoxReply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := session.itemBoxReply()
+	if &first[0] != &again[0] {
+		t.Error("the reply was built again for an unchanged box")
+	}
+	session.setItemBox(itemBoxOf(3))
+	updated, _ := session.itemBoxReply()
+	if !bytes.Equal(updated, buildUnionItemReply(itemBoxOf(3))) {
+		t.Error("the reply wasn't rebuilt after the box changed")
+	}
+}
+
+// BenchmarkEnumerateUnionItem reports the bytes serialized per opening of a box of 5,000 stacks, built
+// every time or once per change of the box.
+func BenchmarkEnumerateUnionItem(b *testing.B) {
+	items := itemBoxOf(5000)
+	b.Run("rebuilt", func(b *testing.B) {
+		var serialized int
+		for i := 0; i < b.N; i++ {
+			serialized += len(buildUnionItemReply(items))
+		}
+		b.ReportMetric(float64(serialized)/float64(b.N), "bytes/open")
+	})
+	b.Run("cached", func(b *testing.B) {
+		session := &Session{}
+		session.setItemBox(items)
+		var serialized int
+		for i := 0; i < b.N; i++ {
+			reply, err := session.itemBoxReply()
+			if err != nil {
+				b.Fatal(err)
+			}
+			// Only the first opening since the change builds the reply.
+			if i == 0 {
+				serialized += len(reply)
+			}
+		}
+		b.ReportMetric(float64(serialized)/float64(b.N), "bytes/open")
+	})
+}
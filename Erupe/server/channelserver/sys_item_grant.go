@@ -0,0 +1,171 @@
+package channelserver
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/Solenataris/Erupe/common/distpayload"
+	"github.com/Solenataris/Erupe/common/itemtable"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// ErrCharacterNotFound is returned when a grant is for a character that doesn't exist or was deleted.
+var ErrCharacterNotFound = errors.New("character not found")
+
+// ItemGrant is an item an admin gives a character, collected from the distribution counter.
+type ItemGrant struct {
+	CharID   uint32
+	ItemID   uint16
+	Quantity uint16
+	IssuedBy string
+}
+
+// ValidateItemGrant checks the item is in the item table, or has a plausible ID without one,
+// and that the quantity is within the configured limit.
+func ValidateItemGrant(items *itemtable.Table, maxQuantity int, itemID int, quantity int) error {
+	if itemID <= 0 || itemID > math.MaxUint16 {
+		return fmt.Errorf("item %d doesn't exist", itemID)
+	}
+	if items != nil {
+		if !items.Has(uint16(itemID)) {
+			return fmt.Errorf("item %d isn't in the item table", itemID)
+		}
+	} else if err := distpayload.Validate([]distpayload.Item{{Type: distpayload.TypeItem, ID: uint16(itemID), Quantity: 1}}); err != nil {
+		return fmt.Errorf("item %d doesn't exist", itemID)
+	}
+	if maxQuantity > math.MaxUint16 {
+		maxQuantity = math.MaxUint16
+	}
+	if quantity < 1 || quantity > maxQuantity {
+		return fmt.Errorf("quantity must be 1 to %d", maxQuantity)
+	}
+	return nil
+}
+
+// GrantItem queues a distribution of the item that only the character can claim and records it in
+// admin_audit_log, in one transaction.
+func GrantItem(db *sqlx.DB, grant ItemGrant) error {
+	granted, err := GrantItems(db, []ItemGrant{grant})
+	if err == nil && granted == 0 {
+		return ErrCharacterNotFound
+	}
+	return err
+}
+
+// GrantItems queues and records every grant as GrantItem does, all in one transaction so a failure sends
+// none of them. Grants to characters that don't exist or were deleted are skipped, it returns how many
+// were sent.
+func GrantItems(db *sqlx.DB, grants []ItemGrant) (int, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	granted := 0
+	for _, grant := range grants {
+		var exists bool
+		err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM characters WHERE id = $1 AND deleted_at IS NULL)", grant.CharID).Scan(&exists)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			continue
+		}
+		items := []DistItemEntry{{distpayload.TypeItem, grant.ItemID, grant.Quantity}}
+		if err = createCharacterDistribution(tx, grant.CharID, "Compensation", "~C05Sent by the server staff.", items); err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec("INSERT INTO admin_audit_log (character_id, action, item_id, quantity, issued_by) VALUES ($1, $2, $3, $4, $5)",
+			grant.CharID, "give item", grant.ItemID, grant.Quantity, grant.IssuedBy)
+		if err != nil {
+			return 0, err
+		}
+		granted++
+	}
+	return granted, tx.Commit()
+}
+
+// findCharacterIDByName returns the ID of the character with the name, ignoring case. A character online on
+// any channel is picked first, an offline one only if no other character has its name.
+func (s *Server) findCharacterIDByName(name string) (uint32, error) {
+	if session := s.findSessionByNameOnAnyChannel(name); session != nil {
+		return session.charID, nil
+	}
+	var charIDs []uint32
+	err := s.db.Select(&charIDs, "SELECT id FROM characters WHERE lower(name) = lower($1) AND deleted_at IS NULL LIMIT 2", name)
+	if err != nil {
+		return 0, err
+	}
+	switch len(charIDs) {
+	case 0:
+		return 0, sql.ErrNoRows
+	case 1:
+		return charIDs[0], nil
+	default:
+		return 0, fmt.Errorf("several characters are named %s", name)
+	}
+}
+
+// handleGiveCommand runs "!give <player> <itemID> <qty>", which sends the player the item through the
+// distribution counter. Only admins can run it, everyone else gets no answer as for unknown commands.
+func handleGiveCommand(s *Session, message string) {
+	s.Lock()
+	admin, adminName := s.admin, s.Name
+	s.Unlock()
+	args := strings.Fields(message)
+	if !admin || args[0] != "!give" {
+		return
+	}
+	if len(args) < 4 {
+		sendServerChatMessage(s, "Usage: \"!give <player> <itemID> <qty>\"")
+		return
+	}
+	name := strings.Join(args[1:len(args)-2], " ")
+	itemID, errID := strconv.Atoi(args[len(args)-2])
+	quantity, errQuantity := strconv.Atoi(args[len(args)-1])
+	if errID != nil || errQuantity != nil {
+		sendServerChatMessage(s, "Usage: \"!give <player> <itemID> <qty>\"")
+		return
+	}
+	items := s.server.items
+	if err := ValidateItemGrant(items, s.server.erupeConfig.ItemGrants.MaxQuantity, itemID, quantity); err != nil {
+		sendServerChatMessage(s, fmt.Sprintf("Can't give that: %s.", err))
+		return
+	}
+
+	charID, err := s.server.findCharacterIDByName(name)
+	if err == sql.ErrNoRows {
+		sendServerChatMessage(s, fmt.Sprintf("No character is named %s.", name))
+		return
+	} else if err != nil {
+		sendServerChatMessage(s, fmt.Sprintf("Can't give that: %s.", err))
+		return
+	}
+	grant := ItemGrant{charID, uint16(itemID), uint16(quantity), fmt.Sprintf("%s (%d)", adminName, s.charID)}
+	if err := GrantItem(s.server.db, grant); err != nil {
+		s.logger.Error("Failed to give item", zap.Error(err), zap.Uint32("charID", charID))
+		sendServerChatMessage(s, "Failed to give the item.")
+		return
+	}
+
+	itemName := fmt.Sprintf("item %d", itemID)
+	if items != nil {
+		itemName = items.Name(uint16(itemID))
+	}
+	s.logger.Info("Admin gave an item",
+		zap.Uint32("admin", s.charID),
+		zap.Uint32("charID", charID),
+		zap.Int("itemID", itemID),
+		zap.Int("quantity", quantity),
+	)
+	sendServerChatMessage(s, fmt.Sprintf("Sent %dx %s to %s.", quantity, itemName, name))
+	if target := s.server.findSessionByNameOnAnyChannel(name); target != nil && target != s {
+		sendServerChatMessage(target, fmt.Sprintf("You were sent %dx %s, collect it from the distribution counter.", quantity, itemName))
+	}
+}
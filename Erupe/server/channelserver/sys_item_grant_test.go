@@ -0,0 +1,101 @@
+package channelserver
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/Solenataris/Erupe/common/itemtable"
+	"github.com/Solenataris/Erupe/common/testdb"
+)
+
+func TestValidateItemGrant(t *testing.T) {
+	items, err := itemtable.Parse(strings.NewReader("1,Potion\n1234,Armor Sphere\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		items    *itemtable.Table
+		itemID   int
+		quantity int
+		ok       bool
+	}{
+		{"listed item", items, 1234, 5, true},
+		{"unlisted item", items, 2, 1, false},
+		{"no quantity", items, 1, 0, false},
+		{"over the limit", items, 1, 100, false},
+		{"negative ID", items, -1, 1, false},
+		{"no table, plausible ID", nil, 2, 1, true},
+		{"no table, ID out of range", nil, 0xFFFF, 1, false},
+		{"no table, ID 0", nil, 0, 1, false},
+	}
+	for _, tt := range tests {
+		err := ValidateItemGrant(tt.items, 99, tt.itemID, tt.quantity)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: got error %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestGiveCommandNeedsAdmin(t *testing.T) {
+	s := newTestServer()
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	session := NewSession(s, serverConn)
+	session.moderator = true
+	handleGiveCommand(session, "!give Hunter 1 1")
+	if n := len(session.sendPackets); n != 0 {
+		t.Fatalf("a moderator got %d replies to \"!give\", want none", n)
+	}
+
+	// Invalid grants are answered before the database is reached.
+	session.admin = true
+	for _, message := range []string{"!give Hunter 1", "!give Hunter one 1", "!give Hunter 0 1", "!give Hunter 1 0"} {
+		handleGiveCommand(session, message)
+	}
+	if n := len(session.sendPackets); n != 4 {
+		t.Errorf("got %d replies to invalid grants, want 4", n)
+	}
+}
+
+// TestGrantItem runs against the database in ERUPE_TEST_DB, like TestClaimEventObject.
+func TestGrantItem(t *testing.T) {
+	db := testdb.Open(t)
+
+	var userID, charID uint32
+	if err := db.QueryRow("INSERT INTO users (username, password) VALUES ('item_grant_test', '') RETURNING id").Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+	err := db.QueryRow("INSERT INTO characters (user_id, is_new_character, name) VALUES ($1, false, 'itemgrant') RETURNING id", userID).Scan(&charID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM characters WHERE id = $1", charID)
+	defer db.Exec("DELETE FROM distribution WHERE character_id = $1", charID)
+	defer db.Exec("DELETE FROM admin_audit_log WHERE character_id = $1", charID)
+
+	if err := GrantItem(db, ItemGrant{CharID: charID, ItemID: 1234, Quantity: 5, IssuedBy: "tester"}); err != nil {
+		t.Fatal(err)
+	}
+	var auditCharID, itemID, quantity uint32
+	var issuedBy string
+	err = db.QueryRow("SELECT character_id, item_id, quantity, issued_by FROM admin_audit_log WHERE character_id = $1 AND action = 'give item'", charID).Scan(&auditCharID, &itemID, &quantity, &issuedBy)
+	if err != nil || auditCharID != charID || itemID != 1234 || quantity != 5 || issuedBy != "tester" {
+		t.Errorf("audit row has character %d, item %d, quantity %d, by %q (%v)", auditCharID, itemID, quantity, issuedBy, err)
+	}
+	var dists int
+	db.QueryRow("SELECT count(*) FROM distribution WHERE character_id = $1", charID).Scan(&dists)
+	if dists != 1 {
+		t.Errorf("the grant queued %d distributions", dists)
+	}
+
+	if err := GrantItem(db, ItemGrant{CharID: 0xFFFFFFF, ItemID: 1, Quantity: 1}); err != ErrCharacterNotFound {
+		t.Errorf("granting to no one got %v, want %v", err, ErrCharacterNotFound)
+	}
+	batch := []ItemGrant{{CharID: charID, ItemID: 1, Quantity: 1}, {CharID: 0xFFFFFFF, ItemID: 1, Quantity: 1}}
+	if granted, err := GrantItems(db, batch); err != nil || granted != 1 {
+		t.Errorf("a batch with a missing character sent %d grants, %v, want 1", granted, err)
+	}
+}
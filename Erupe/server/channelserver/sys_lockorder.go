@@ -0,0 +1,10 @@
+//go:build !lockorder
+// +build !lockorder
+
+package channelserver
+
+import "sync"
+
+// stagesRWMutex is the type of Server.stagesLock. Building with the lockorder tag swaps it
+// for one that checks the lock order described on Stage, see sys_lockorder_debug.go.
+type stagesRWMutex = sync.RWMutex
@@ -0,0 +1,134 @@
+//go:build lockorder
+// +build lockorder
+
+package channelserver
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// Lock order checking, enabled with `go test -tags lockorder`.
+// Each goroutine records the levels of the locks it holds, and taking a lock
+// at or above a level it already holds panics instead of deadlocking later.
+
+type lockLevel int
+
+const (
+	lockLevelStages lockLevel = iota + 1
+	lockLevelStage
+	lockLevelSession
+)
+
+func (l lockLevel) String() string {
+	switch l {
+	case lockLevelStages:
+		return "Server.stagesLock"
+	case lockLevelStage:
+		return "Stage"
+	case lockLevelSession:
+		return "Session"
+	default:
+		return "unknown"
+	}
+}
+
+var (
+	heldLocksLock sync.Mutex
+	heldLocks     = make(map[uint64][]lockLevel)
+)
+
+// goroutineID parses the current goroutine's ID out of its stack header, "goroutine N [...".
+func goroutineID() uint64 {
+	buf := make([]byte, 64)
+	buf = bytes.TrimPrefix(buf[:runtime.Stack(buf, false)], []byte("goroutine "))
+	id, _ := strconv.ParseUint(string(buf[:bytes.IndexByte(buf, ' ')]), 10, 64)
+	return id
+}
+
+func acquireLockLevel(level lockLevel) {
+	id := goroutineID()
+	heldLocksLock.Lock()
+	defer heldLocksLock.Unlock()
+	for _, held := range heldLocks[id] {
+		if held >= level {
+			panic(fmt.Sprintf("lock order violation: taking %s lock while holding %s lock", level, held))
+		}
+	}
+	heldLocks[id] = append(heldLocks[id], level)
+}
+
+func releaseLockLevel(level lockLevel) {
+	id := goroutineID()
+	heldLocksLock.Lock()
+	defer heldLocksLock.Unlock()
+	held := heldLocks[id]
+	for i := len(held) - 1; i >= 0; i-- {
+		if held[i] == level {
+			held = append(held[:i], held[i+1:]...)
+			break
+		}
+	}
+	if len(held) == 0 {
+		delete(heldLocks, id)
+	} else {
+		heldLocks[id] = held
+	}
+}
+
+type stagesRWMutex struct {
+	sync.RWMutex
+}
+
+func (m *stagesRWMutex) Lock() {
+	acquireLockLevel(lockLevelStages)
+	m.RWMutex.Lock()
+}
+
+func (m *stagesRWMutex) Unlock() {
+	releaseLockLevel(lockLevelStages)
+	m.RWMutex.Unlock()
+}
+
+func (m *stagesRWMutex) RLock() {
+	acquireLockLevel(lockLevelStages)
+	m.RWMutex.RLock()
+}
+
+func (m *stagesRWMutex) RUnlock() {
+	releaseLockLevel(lockLevelStages)
+	m.RWMutex.RUnlock()
+}
+
+func (s *Stage) Lock() {
+	acquireLockLevel(lockLevelStage)
+	s.RWMutex.Lock()
+}
+
+func (s *Stage) Unlock() {
+	releaseLockLevel(lockLevelStage)
+	s.RWMutex.Unlock()
+}
+
+func (s *Stage) RLock() {
+	acquireLockLevel(lockLevelStage)
+	s.RWMutex.RLock()
+}
+
+func (s *Stage) RUnlock() {
+	releaseLockLevel(lockLevelStage)
+	s.RWMutex.RUnlock()
+}
+
+func (s *Session) Lock() {
+	acquireLockLevel(lockLevelSession)
+	s.Mutex.Lock()
+}
+
+func (s *Session) Unlock() {
+	releaseLockLevel(lockLevelSession)
+	s.Mutex.Unlock()
+}
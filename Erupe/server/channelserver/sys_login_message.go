@@ -0,0 +1,98 @@
+package channelserver
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+)
+
+// Layout of the times filled into login messages.
+const loginMessageTimeLayout = "2006-01-02 15:04"
+
+// loginMessageFor picks the first login message for the world that the account's rights get.
+func loginMessageFor(messages []config.LoginMessage, world string, rights uint32, moderator bool) (config.LoginMessage, bool) {
+	for _, message := range messages {
+		if !targetsWorld(message.Worlds, world) || (message.Moderator && !moderator) {
+			continue
+		}
+		held := len(message.Courses) == 0
+		for _, course := range message.Courses {
+			if course >= 0 && course < 32 && rights&(1<<course) != 0 {
+				held = true
+			}
+		}
+		if held {
+			return message, true
+		}
+	}
+	return config.LoginMessage{}, false
+}
+
+// loginMessageTime is a time filled into a login message, in event clock time.
+func loginMessageTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.In(Time_Current().Location()).Format(loginMessageTimeLayout)
+}
+
+// renderLoginMessage fills the placeholders of a login message template in. It's gone through once, so
+// placeholders in what's filled in, such as a character named after one, are left as they are. A % not
+// starting a placeholder is kept.
+func renderLoginMessage(template string, name string, lastLogin time.Time, courseExpiry time.Time) string {
+	values := map[string]string{
+		"name":         name,
+		"lastlogin":    loginMessageTime(lastLogin),
+		"courseexpiry": loginMessageTime(courseExpiry),
+	}
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(template, '%')
+		if i < 0 {
+			b.WriteString(template)
+			return b.String()
+		}
+		b.WriteString(template[:i])
+		template = template[i+1:]
+		if strings.HasPrefix(template, "%") {
+			b.WriteByte('%')
+			template = template[1:]
+			continue
+		}
+		if end := strings.IndexByte(template, '%'); end >= 0 {
+			if value, ok := values[template[:end]]; ok {
+				b.WriteString(value)
+				template = template[end+1:]
+				continue
+			}
+		}
+		b.WriteByte('%')
+	}
+}
+
+// sendLoginMessage sends the session its login message, if one is configured for it. The config is read
+// as it's sent, so a reloaded one is used from the next login on.
+func sendLoginMessage(s *Session) {
+	s.Lock()
+	name, lastLogin, firstLogin := s.Name, s.lastLogin, s.firstLogin
+	rights, moderator := s.rights, s.moderator
+	var courseExpiry time.Time
+	if time.Now().Before(s.newcomerUntil) {
+		courseExpiry = s.newcomerUntil
+	}
+	s.Unlock()
+
+	message, ok := loginMessageFor(s.server.erupeConfig.Current().LoginMessages, s.server.world, rights, moderator)
+	if !ok {
+		return
+	}
+	template := message.Message
+	if firstLogin && message.FirstTime != "" {
+		template = message.FirstTime
+	}
+	if template == "" {
+		return
+	}
+	sendServerChatMessage(s, renderLoginMessage(template, name, lastLogin, courseExpiry))
+}
@@ -0,0 +1,86 @@
+package channelserver
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+)
+
+func TestRenderLoginMessage(t *testing.T) {
+	jst := time.FixedZone("JST", 9*60*60)
+	lastLogin := time.Date(2022, 6, 1, 20, 30, 0, 0, jst)
+	expiry := time.Date(2022, 6, 15, 0, 0, 0, 0, jst)
+	tests := []struct {
+		template string
+		name     string
+		want     string
+	}{
+		{"Welcome back %name%!", "Alpha", "Welcome back Alpha!"},
+		{"Last seen %lastlogin%, boosts until %courseexpiry%.", "Alpha", "Last seen 2022-06-01 20:30, boosts until 2022-06-15 00:00."},
+		{"100%% drop rates for %name%", "Alpha", "100% drop rates for Alpha"},
+		{"%%name%% is literal", "Alpha", "%name% is literal"},
+		{"50% off, %unknown% kept", "Alpha", "50% off, %unknown% kept"},
+		{"Trailing %", "Alpha", "Trailing %"},
+		{"%%%name%", "Alpha", "%Alpha"},
+		// What's filled in isn't gone through again.
+		{"Hi %name%", "%lastlogin%", "Hi %lastlogin%"},
+		{"Hi %name%", "%%", "Hi %%"},
+	}
+	for _, tt := range tests {
+		if got := renderLoginMessage(tt.template, tt.name, lastLogin, expiry); got != tt.want {
+			t.Errorf("%q with %q: got %q, want %q", tt.template, tt.name, got, tt.want)
+		}
+	}
+
+	if got := renderLoginMessage("%lastlogin%, %courseexpiry%", "Alpha", time.Time{}, time.Time{}); got != "never, never" {
+		t.Errorf("without a last login or courses got %q", got)
+	}
+}
+
+func TestLoginMessageFor(t *testing.T) {
+	messages := []config.LoginMessage{
+		{Moderator: true, Message: "staff"},
+		{Worlds: []string{"Newbie"}, Message: "newbie"},
+		{Courses: []int{6, 10}, Message: "premium"},
+		{Message: "everyone"},
+	}
+	tests := []struct {
+		world     string
+		rights    uint32
+		moderator bool
+		want      string
+	}{
+		{"Normal", 0x0E, true, "staff"},
+		{"newbie", 1 << 6, false, "newbie"},
+		{"Normal", 1 << 10, false, "premium"},
+		{"Normal", 0x0E, false, "everyone"},
+	}
+	for _, tt := range tests {
+		message, ok := loginMessageFor(messages, tt.world, tt.rights, tt.moderator)
+		if !ok || message.Message != tt.want {
+			t.Errorf("%s with rights %x: got %q, want %q", tt.world, tt.rights, message.Message, tt.want)
+		}
+	}
+	if _, ok := loginMessageFor(messages[:2], "Normal", 0, false); ok {
+		t.Error("got a message none of the configured ones match")
+	}
+}
+
+func TestSendLoginMessage(t *testing.T) {
+	s := newTestServer()
+	s.erupeConfig.LoginMessages = []config.LoginMessage{{Message: "Welcome back %name%.", FirstTime: "Welcome %name%, 100%% new!"}}
+	returning := newQueuedSession(t, s, 1, "Alpha")
+	newcomer := newQueuedSession(t, s, 2, "Beta")
+	newcomer.firstLogin = true
+
+	sendLoginMessage(returning)
+	sendLoginMessage(newcomer)
+	if sent := string(sentPackets(returning)); !strings.Contains(sent, "Welcome back Alpha.") {
+		t.Errorf("returning character got %q", sent)
+	}
+	if sent := string(sentPackets(newcomer)); !strings.Contains(sent, "Welcome Beta, 100% new!") {
+		t.Errorf("first time character got %q", sent)
+	}
+}
@@ -0,0 +1,547 @@
+package channelserver
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"go.uber.org/zap"
+)
+
+// How often the matcher groups the queue and expires unanswered matches. A var so tests can shorten it.
+var matchmakingInterval = time.Second
+
+var (
+	errMatchmakingDisabled = errors.New("matchmaking is disabled")
+	errInvalidFloorRange   = errors.New("invalid floor range")
+	errAlreadyMatched      = errors.New("already in a match")
+	errNotMatched          = errors.New("not in a match")
+)
+
+// matchRequest is a player waiting in the road matchmaking queue.
+type matchRequest struct {
+	session   *Session
+	charID    uint32
+	name      string
+	minFloor  uint16
+	maxFloor  uint16
+	language  uint8 // 0 matches any language.
+	queuedAt  time.Time
+	viaPacket bool // Enqueued through MsgSysEnqueueMatch rather than "!queue", and told about matches the same way.
+}
+
+// pendingMatch is a group formed from the queue with its stage reserved, waiting on every member to accept.
+type pendingMatch struct {
+	stageID  string
+	members  []*matchRequest
+	minFloor uint16
+	maxFloor uint16
+	accepted map[uint32]bool
+	deadline time.Time
+}
+
+// matchmaker holds the channel's matchmaking queue and the matches waiting to be accepted.
+type matchmaker struct {
+	sync.Mutex
+	queue   []*matchRequest // Oldest first.
+	matches map[uint32]*pendingMatch
+	seq     int
+	stop    chan struct{}
+}
+
+func newMatchmaker() *matchmaker {
+	return &matchmaker{matches: make(map[uint32]*pendingMatch)}
+}
+
+// matchPartySizes returns the full and smallest party size to match, 0 when matchmaking is disabled.
+func matchPartySizes(cfg config.Matchmaking) (int, int) {
+	if cfg.PartySize <= 0 {
+		return 0, 0
+	}
+	if cfg.MinPartySize <= 0 || cfg.MinPartySize > cfg.PartySize {
+		return cfg.PartySize, cfg.PartySize
+	}
+	return cfg.PartySize, cfg.MinPartySize
+}
+
+// groupMatches groups compatible requests of the queue, oldest first. A request joins a group if its
+// language matches and its floor range overlaps what the group has in common so far. A group is
+// formed once it's full, or once it has the smallest party size and its oldest member has waited long enough.
+// It returns the groups formed and the requests left waiting, both in queue order.
+func groupMatches(queue []*matchRequest, cfg config.Matchmaking, now time.Time) ([][]*matchRequest, []*matchRequest) {
+	partySize, minPartySize := matchPartySizes(cfg)
+	if partySize == 0 {
+		return nil, queue
+	}
+	maxWait := time.Duration(cfg.MaxWait) * time.Second
+
+	var groups [][]*matchRequest
+	grouped := make([]bool, len(queue))
+	for i, oldest := range queue {
+		if grouped[i] {
+			continue
+		}
+		group := []int{i}
+		minFloor, maxFloor, language := oldest.minFloor, oldest.maxFloor, oldest.language
+		for j := i + 1; j < len(queue) && len(group) < partySize; j++ {
+			req := queue[j]
+			if grouped[j] || req.maxFloor < minFloor || req.minFloor > maxFloor {
+				continue
+			}
+			if language != 0 && req.language != 0 && req.language != language {
+				continue
+			}
+			group = append(group, j)
+			if req.minFloor > minFloor {
+				minFloor = req.minFloor
+			}
+			if req.maxFloor < maxFloor {
+				maxFloor = req.maxFloor
+			}
+			if language == 0 {
+				language = req.language
+			}
+		}
+		if len(group) < partySize && (len(group) < minPartySize || now.Sub(oldest.queuedAt) < maxWait) {
+			continue
+		}
+		members := make([]*matchRequest, len(group))
+		for n, index := range group {
+			grouped[index] = true
+			members[n] = queue[index]
+		}
+		groups = append(groups, members)
+	}
+
+	rest := make([]*matchRequest, 0, len(queue))
+	for i, req := range queue {
+		if !grouped[i] {
+			rest = append(rest, req)
+		}
+	}
+	return groups, rest
+}
+
+// matchFloors returns the floor range every member of the group can play.
+func matchFloors(members []*matchRequest) (uint16, uint16) {
+	minFloor, maxFloor := members[0].minFloor, members[0].maxFloor
+	for _, member := range members[1:] {
+		if member.minFloor > minFloor {
+			minFloor = member.minFloor
+		}
+		if member.maxFloor < maxFloor {
+			maxFloor = member.maxFloor
+		}
+	}
+	return minFloor, maxFloor
+}
+
+// requeueLocked puts the requests back in the queue at the place their original wait earns them.
+// The caller holds the matchmaker lock.
+func (m *matchmaker) requeueLocked(reqs ...*matchRequest) {
+	m.queue = append(m.queue, reqs...)
+	sort.SliceStable(m.queue, func(i, j int) bool { return m.queue[i].queuedAt.Before(m.queue[j].queuedAt) })
+}
+
+// queuedLocked returns the index of the session's request in the queue, or -1.
+// The caller holds the matchmaker lock.
+func (m *matchmaker) queuedLocked(s *Session) int {
+	for i, req := range m.queue {
+		if req.session == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// dropMatchLocked forgets the match of every member. The caller holds the matchmaker lock.
+func (m *matchmaker) dropMatchLocked(match *pendingMatch) {
+	for _, member := range match.members {
+		delete(m.matches, member.charID)
+	}
+}
+
+// matchNotice is a match status to tell a member once the matchmaker lock is released.
+type matchNotice struct {
+	req    *matchRequest
+	match  *pendingMatch
+	status uint8
+	chat   string
+}
+
+// notifyMatch tells the member about its match, by packet if it enqueued by packet and in chat otherwise.
+func (s *Server) notifyMatch(n matchNotice) {
+	if !n.req.viaPacket {
+		sendServerChatMessage(n.req.session, n.chat)
+		return
+	}
+	pkt := &mhfpacket.MsgSysMatchFound{
+		Status:        n.status,
+		StageID:       n.match.stageID,
+		MinFloor:      n.match.minFloor,
+		MaxFloor:      n.match.maxFloor,
+		AcceptSeconds: uint16(s.erupeConfig.Matchmaking.AcceptTimeout),
+	}
+	for _, member := range n.match.members {
+		pkt.CharIDs = append(pkt.CharIDs, member.charID)
+	}
+	n.req.session.QueueSendMHF(pkt)
+}
+
+// reserveMatchStage creates the match's stage and reserves a slot in it for every member,
+// giving up any slot a member held elsewhere. The caller holds the matchmaker lock.
+func (s *Server) reserveMatchStage(match *pendingMatch) {
+	stage, created := s.CreateStage(match.stageID, uint16(len(match.members)))
+	if !created {
+		s.logger.Warn("Matchmaking stage already exists", zap.String("stageID", match.stageID))
+		stage, _ = s.GetStage(match.stageID)
+	}
+	for _, member := range match.members {
+		cancelStageReservation(member.session)
+		stage.Lock()
+		stage.reservedClientSlots[member.charID] = nil
+		stage.Unlock()
+		member.session.Lock()
+		member.session.reservationStage = stage
+		member.session.Unlock()
+	}
+}
+
+// releaseMatchStage gives up the members' slots in the stage of a called off match and removes it.
+// The caller holds the matchmaker lock.
+func (s *Server) releaseMatchStage(match *pendingMatch) {
+	stage, ok := s.GetStage(match.stageID)
+	if !ok {
+		return
+	}
+	for _, member := range match.members {
+		if reservedStageOf(member.session) == stage {
+			cancelStageReservation(member.session)
+		}
+	}
+	s.RemoveStage(match.stageID)
+}
+
+// matchOnce calls off the matches that weren't accepted in time, then forms new ones from the queue.
+// Members who had accepted a called off match are queued again, the others leave the queue.
+func (s *Server) matchOnce(now time.Time) {
+	cfg := s.erupeConfig.Matchmaking
+	m := s.matchmaking
+	var expired, formed []*pendingMatch
+	var notices []matchNotice
+
+	m.Lock()
+	seen := make(map[*pendingMatch]bool)
+	for _, match := range m.matches {
+		if seen[match] || now.Before(match.deadline) {
+			continue
+		}
+		seen[match] = true
+		expired = append(expired, match)
+		m.dropMatchLocked(match)
+		for _, member := range match.members {
+			if match.accepted[member.charID] {
+				m.requeueLocked(member)
+				notices = append(notices, matchNotice{member, match, mhfpacket.MatchStatusCancelled, "Not everyone accepted the match in time, you're back in the queue."})
+			} else {
+				notices = append(notices, matchNotice{member, match, mhfpacket.MatchStatusCancelled, "You didn't accept the match in time and left the queue."})
+			}
+		}
+	}
+
+	groups, rest := groupMatches(m.queue, cfg, now)
+	m.queue = rest
+	for _, members := range groups {
+		m.seq++
+		match := &pendingMatch{
+			stageID:  cfg.StagePrefix + strconv.Itoa(m.seq),
+			members:  members,
+			accepted: make(map[uint32]bool),
+			deadline: now.Add(time.Duration(cfg.AcceptTimeout) * time.Second),
+		}
+		match.minFloor, match.maxFloor = matchFloors(members)
+		for _, member := range members {
+			m.matches[member.charID] = match
+		}
+		formed = append(formed, match)
+	}
+	// The stages are reserved and released under the lock, so a match answered straight away
+	// never finds its stage missing or leaves it behind.
+	for _, match := range expired {
+		s.releaseMatchStage(match)
+	}
+	for _, match := range formed {
+		s.reserveMatchStage(match)
+	}
+	m.Unlock()
+
+	for _, match := range formed {
+		s.logger.Info("Formed match", zap.String("stageID", match.stageID), zap.Int("members", len(match.members)))
+		chat := fmt.Sprintf("Match found for floors %d-%d with %d players. Answer \"!queue accept\" or \"!queue decline\" within %d seconds.",
+			match.minFloor, match.maxFloor, len(match.members), cfg.AcceptTimeout)
+		for _, member := range match.members {
+			notices = append(notices, matchNotice{member, match, mhfpacket.MatchStatusFound, chat})
+		}
+	}
+	for _, notice := range notices {
+		s.notifyMatch(notice)
+	}
+}
+
+// enqueueMatch queues the session with its preferences. A session already queued keeps its place
+// with the new preferences.
+func (s *Server) enqueueMatch(session *Session, minFloor, maxFloor uint16, language uint8, viaPacket bool) error {
+	if partySize, _ := matchPartySizes(s.erupeConfig.Matchmaking); partySize == 0 {
+		return errMatchmakingDisabled
+	}
+	if minFloor == 0 || minFloor > maxFloor {
+		return errInvalidFloorRange
+	}
+	session.Lock()
+	charID, name := session.charID, session.Name
+	session.Unlock()
+
+	m := s.matchmaking
+	m.Lock()
+	defer m.Unlock()
+	if _, matched := m.matches[charID]; matched {
+		return errAlreadyMatched
+	}
+	req := &matchRequest{session, charID, name, minFloor, maxFloor, language, time.Now(), viaPacket}
+	if i := m.queuedLocked(session); i >= 0 {
+		req.queuedAt = m.queue[i].queuedAt
+		m.queue[i] = req
+		return nil
+	}
+	m.queue = append(m.queue, req)
+	return nil
+}
+
+// answerMatch accepts or declines the session's match. Once every member accepted they're told
+// the stage is theirs. A decline calls the match off, the declining member leaves the queue
+// and everyone else goes back in it.
+func (s *Server) answerMatch(session *Session, accept bool) error {
+	session.Lock()
+	charID := session.charID
+	session.Unlock()
+
+	m := s.matchmaking
+	m.Lock()
+	match, ok := m.matches[charID]
+	if !ok {
+		m.Unlock()
+		return errNotMatched
+	}
+	var notices []matchNotice
+	if accept {
+		match.accepted[charID] = true
+		if len(match.accepted) == len(match.members) {
+			m.dropMatchLocked(match)
+			for _, member := range match.members {
+				notices = append(notices, matchNotice{member, match, mhfpacket.MatchStatusReady, "Everyone accepted the match, your party's stage is reserved."})
+			}
+		}
+	} else {
+		m.dropMatchLocked(match)
+		s.releaseMatchStage(match)
+		for _, member := range match.members {
+			if member.charID == charID {
+				continue
+			}
+			m.requeueLocked(member)
+			notices = append(notices, matchNotice{member, match, mhfpacket.MatchStatusCancelled, "A player declined the match, you're back in the queue."})
+		}
+	}
+	m.Unlock()
+
+	for _, notice := range notices {
+		s.notifyMatch(notice)
+	}
+	return nil
+}
+
+// leaveMatchmaking takes the session out of the queue, or declines its match, reporting whether it was in either.
+// Used when the player leaves by choice and when its session dies.
+func (s *Server) leaveMatchmaking(session *Session) bool {
+	m := s.matchmaking
+	m.Lock()
+	if i := m.queuedLocked(session); i >= 0 {
+		m.queue = append(m.queue[:i], m.queue[i+1:]...)
+		m.Unlock()
+		return true
+	}
+	m.Unlock()
+	return s.answerMatch(session, false) == nil
+}
+
+// startMatchmaking runs the matcher until stopMatchmaking.
+func (s *Server) startMatchmaking() {
+	stop := make(chan struct{})
+	s.matchmaking.Lock()
+	s.matchmaking.stop = stop
+	s.matchmaking.Unlock()
+	go func() {
+		ticker := time.NewTicker(matchmakingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				s.matchOnce(now)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (s *Server) stopMatchmaking() {
+	s.matchmaking.Lock()
+	defer s.matchmaking.Unlock()
+	if s.matchmaking.stop != nil {
+		close(s.matchmaking.stop)
+		s.matchmaking.stop = nil
+	}
+}
+
+func handleMsgSysEnqueueMatch(s *Session, p mhfpacket.MHFPacket) {
+	pkt := p.(*mhfpacket.MsgSysEnqueueMatch)
+	var err error
+	switch pkt.Action {
+	case mhfpacket.MatchActionJoin:
+		err = s.server.enqueueMatch(s, pkt.MinFloor, pkt.MaxFloor, pkt.Language, true)
+	case mhfpacket.MatchActionLeave:
+		if !s.server.leaveMatchmaking(s) {
+			err = errNotMatched
+		}
+	case mhfpacket.MatchActionAccept:
+		err = s.server.answerMatch(s, true)
+	case mhfpacket.MatchActionDecline:
+		err = s.server.answerMatch(s, false)
+	default:
+		err = fmt.Errorf("unknown matchmaking action %d", pkt.Action)
+	}
+	if err != nil {
+		s.logger.Debug("Matchmaking request failed", zap.Uint8("action", pkt.Action), zap.Error(err))
+		doAckSimpleFail(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
+		return
+	}
+	doAckSimpleSucceed(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
+}
+
+// handleMsgSysMatchFound ignores the packet, it's only ever sent to clients.
+func handleMsgSysMatchFound(s *Session, p mhfpacket.MHFPacket) {}
+
+// handleQueueCommand runs "!queue", the matchmaking queue for clients without MsgSysEnqueueMatch:
+// "!queue [min-max] [language]" joins it, "!queue leave", "!queue accept" and "!queue decline" answer it.
+func handleQueueCommand(s *Session, message string) {
+	args := strings.Fields(message)[1:]
+	usage := "Usage: \"!queue [min-max] [language]\", \"!queue leave\", \"!queue accept\" or \"!queue decline\""
+	if len(args) == 1 {
+		switch args[0] {
+		case "leave":
+			if s.server.leaveMatchmaking(s) {
+				sendServerChatMessage(s, "You left the matchmaking queue.")
+			} else {
+				sendServerChatMessage(s, "You aren't in the matchmaking queue.")
+			}
+			return
+		case "accept", "decline":
+			if err := s.server.answerMatch(s, args[0] == "accept"); err != nil {
+				sendServerChatMessage(s, "You don't have a match to answer.")
+			} else if args[0] == "accept" {
+				sendServerChatMessage(s, "You accepted the match.")
+			} else {
+				sendServerChatMessage(s, "You declined the match and left the matchmaking queue.")
+			}
+			return
+		}
+	}
+	if len(args) > 2 {
+		sendServerChatMessage(s, usage)
+		return
+	}
+
+	var minFloor, maxFloor uint16 = 1, 0xFFFF
+	var language uint8
+	if len(args) > 0 {
+		if n, err := fmt.Sscanf(args[0], "%d-%d", &minFloor, &maxFloor); err != nil || n != 2 {
+			sendServerChatMessage(s, usage)
+			return
+		}
+	}
+	if len(args) > 1 {
+		if n, err := fmt.Sscanf(args[1], "%d", &language); err != nil || n != 1 {
+			sendServerChatMessage(s, usage)
+			return
+		}
+	}
+	switch err := s.server.enqueueMatch(s, minFloor, maxFloor, language, false); err {
+	case nil:
+		sendServerChatMessage(s, fmt.Sprintf("You're in the matchmaking queue for floors %d-%d. Leave it with \"!queue leave\".", minFloor, maxFloor))
+	case errMatchmakingDisabled:
+		sendServerChatMessage(s, "Matchmaking is disabled on this server.")
+	case errInvalidFloorRange:
+		sendServerChatMessage(s, "The floor range must start at 1 or above and not end before it starts.")
+	case errAlreadyMatched:
+		sendServerChatMessage(s, "You already have a match, answer it with \"!queue accept\" or \"!queue decline\".")
+	}
+}
+
+// MatchmakingEntry is a player waiting in the matchmaking queue as listed by the admin API.
+type MatchmakingEntry struct {
+	CharID   uint32    `json:"charID"`
+	Name     string    `json:"name"`
+	MinFloor uint16    `json:"minFloor"`
+	MaxFloor uint16    `json:"maxFloor"`
+	Language uint8     `json:"language"`
+	QueuedAt time.Time `json:"queuedAt"`
+}
+
+// MatchmakingMatch is a match waiting on its members to accept as listed by the admin API.
+type MatchmakingMatch struct {
+	StageID  string    `json:"stageID"`
+	CharIDs  []uint32  `json:"charIDs"`
+	Accepted []uint32  `json:"accepted"`
+	Deadline time.Time `json:"deadline"`
+}
+
+// MatchmakingStatus is a channel's matchmaking queue and pending matches as listed by the admin API.
+type MatchmakingStatus struct {
+	Channel string             `json:"channel"`
+	Queue   []MatchmakingEntry `json:"queue"`
+	Matches []MatchmakingMatch `json:"matches"`
+}
+
+// MatchmakingQueue lists the players queued on the channel and the matches waiting to be accepted.
+func (s *Server) MatchmakingQueue() MatchmakingStatus {
+	m := s.matchmaking
+	m.Lock()
+	defer m.Unlock()
+	status := MatchmakingStatus{Channel: s.name, Queue: []MatchmakingEntry{}, Matches: []MatchmakingMatch{}}
+	for _, req := range m.queue {
+		status.Queue = append(status.Queue, MatchmakingEntry{req.charID, req.name, req.minFloor, req.maxFloor, req.language, req.queuedAt})
+	}
+	seen := make(map[*pendingMatch]bool)
+	for _, match := range m.matches {
+		if seen[match] {
+			continue
+		}
+		seen[match] = true
+		listed := MatchmakingMatch{StageID: match.stageID, CharIDs: []uint32{}, Accepted: []uint32{}, Deadline: match.deadline}
+		for _, member := range match.members {
+			listed.CharIDs = append(listed.CharIDs, member.charID)
+			if match.accepted[member.charID] {
+				listed.Accepted = append(listed.Accepted, member.charID)
+			}
+		}
+		status.Matches = append(status.Matches, listed)
+	}
+	sort.Slice(status.Matches, func(i, j int) bool { return status.Matches[i].StageID < status.Matches[j].StageID })
+	return status
+}
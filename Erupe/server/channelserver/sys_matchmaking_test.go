@@ -0,0 +1,192 @@
+package channelserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+)
+
+func queuedAt(charID uint32, minFloor, maxFloor uint16, language uint8, at time.Time) *matchRequest {
+	return &matchRequest{charID: charID, minFloor: minFloor, maxFloor: maxFloor, language: language, queuedAt: at}
+}
+
+func groupCharIDs(group []*matchRequest) []uint32 {
+	charIDs := make([]uint32, len(group))
+	for i, req := range group {
+		charIDs[i] = req.charID
+	}
+	return charIDs
+}
+
+func TestGroupMatches(t *testing.T) {
+	cfg := config.Matchmaking{PartySize: 3, MinPartySize: 2, MaxWait: 60}
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	queue := []*matchRequest{
+		queuedAt(1, 1, 20, 0, now.Add(-10*time.Second)),
+		queuedAt(2, 30, 50, 1, now.Add(-9*time.Second)), // No floor in common with 1.
+		queuedAt(3, 10, 40, 2, now.Add(-8*time.Second)), // Sets the language of 1's group.
+		queuedAt(4, 15, 25, 1, now.Add(-7*time.Second)), // Wrong language for 1's group.
+		queuedAt(5, 18, 60, 0, now.Add(-6*time.Second)), // Completes 1's group.
+		queuedAt(6, 40, 45, 1, now.Add(-5*time.Second)),
+	}
+
+	groups, rest := groupMatches(queue, cfg, now)
+	if len(groups) != 1 {
+		t.Fatalf("got %d groups, want 1", len(groups))
+	}
+	if got := groupCharIDs(groups[0]); len(got) != 3 || got[0] != 1 || got[1] != 3 || got[2] != 5 {
+		t.Errorf("got group %v, want [1 3 5]", got)
+	}
+	if got := groupCharIDs(rest); len(got) != 3 || got[0] != 2 || got[1] != 4 || got[2] != 6 {
+		t.Errorf("got %v left waiting, want [2 4 6]", got)
+	}
+	if minFloor, maxFloor := matchFloors(groups[0]); minFloor != 18 || maxFloor != 20 {
+		t.Errorf("got floors %d-%d, want 18-20", minFloor, maxFloor)
+	}
+
+	// 2 and 6 only make a smaller party once 2 has waited long enough.
+	groups, _ = groupMatches(rest, cfg, now.Add(50*time.Second))
+	if len(groups) != 0 {
+		t.Errorf("got %d groups before the wait ran out, want 0", len(groups))
+	}
+	groups, rest = groupMatches(rest, cfg, now.Add(51*time.Second))
+	if len(groups) != 1 || len(groups[0]) != 2 || groups[0][0].charID != 2 || groups[0][1].charID != 6 {
+		t.Errorf("got groups %v after the wait ran out, want [[2 6]]", groups)
+	}
+	if len(rest) != 1 || rest[0].charID != 4 {
+		t.Errorf("got %v left waiting, want [4]", groupCharIDs(rest))
+	}
+}
+
+func TestGroupMatchesDisabled(t *testing.T) {
+	now := time.Now()
+	queue := []*matchRequest{queuedAt(1, 1, 10, 0, now), queuedAt(2, 1, 10, 0, now)}
+	if groups, rest := groupMatches(queue, config.Matchmaking{}, now); len(groups) != 0 || len(rest) != 2 {
+		t.Errorf("got %d groups and %d waiting with matchmaking disabled", len(groups), len(rest))
+	}
+}
+
+// newMatchmakingParty queues three characters for the same floors on a server matching parties of three.
+func newMatchmakingParty(t *testing.T) (*Server, []*Session) {
+	s := newTestServer()
+	s.erupeConfig.Matchmaking = config.Matchmaking{PartySize: 3, MinPartySize: 3, MaxWait: 60, AcceptTimeout: 30, StagePrefix: "sl1Qs900p0a0u"}
+	members := make([]*Session, 0, 3)
+	for charID := uint32(1); charID <= 3; charID++ {
+		member := newReservingSession(t, s, charID)
+		if err := s.enqueueMatch(member, 1, 50, 0, false); err != nil {
+			t.Fatal(err)
+		}
+		members = append(members, member)
+	}
+	return s, members
+}
+
+func TestMatchReservesStage(t *testing.T) {
+	s, members := newMatchmakingParty(t)
+	s.matchOnce(time.Now())
+
+	status := s.MatchmakingQueue()
+	if len(status.Queue) != 0 || len(status.Matches) != 1 {
+		t.Fatalf("got %d queued and %d matches, want 0 and 1", len(status.Queue), len(status.Matches))
+	}
+	stage, ok := s.GetStage(status.Matches[0].StageID)
+	if !ok {
+		t.Fatalf("stage %s wasn't created", status.Matches[0].StageID)
+	}
+	for _, member := range members {
+		if reservedStageOf(member) != stage {
+			t.Errorf("character %d holds no slot in the match's stage", member.charID)
+		}
+	}
+
+	for _, member := range members {
+		if err := s.answerMatch(member, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if status := s.MatchmakingQueue(); len(status.Matches) != 0 {
+		t.Errorf("got %d matches once everyone accepted, want 0", len(status.Matches))
+	}
+	stage.RLock()
+	reserved := len(stage.reservedClientSlots)
+	stage.RUnlock()
+	if reserved != 3 {
+		t.Errorf("got %d reserved slots once everyone accepted, want 3", reserved)
+	}
+}
+
+func TestMatchDeclineRequeuesRest(t *testing.T) {
+	s, members := newMatchmakingParty(t)
+	s.matchOnce(time.Now())
+	stageID := s.MatchmakingQueue().Matches[0].StageID
+
+	s.answerMatch(members[1], true)
+	if err := s.answerMatch(members[0], false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.GetStage(stageID); ok {
+		t.Error("the stage of a declined match wasn't removed")
+	}
+	status := s.MatchmakingQueue()
+	if len(status.Matches) != 0 || len(status.Queue) != 2 || status.Queue[0].CharID != 2 || status.Queue[1].CharID != 3 {
+		t.Errorf("got queue %+v and %d matches, want 2 and 3 queued again", status.Queue, len(status.Matches))
+	}
+	for _, member := range members {
+		if reservedStageOf(member) != nil {
+			t.Errorf("character %d kept a slot in the declined match's stage", member.charID)
+		}
+	}
+}
+
+func TestMatchTimeoutRequeuesAccepted(t *testing.T) {
+	s, members := newMatchmakingParty(t)
+	now := time.Now()
+	s.matchOnce(now)
+	stageID := s.MatchmakingQueue().Matches[0].StageID
+	s.answerMatch(members[2], true)
+
+	s.matchOnce(now.Add(29 * time.Second))
+	if status := s.MatchmakingQueue(); len(status.Matches) != 1 {
+		t.Fatalf("match was called off before it timed out")
+	}
+	s.matchOnce(now.Add(30 * time.Second))
+	status := s.MatchmakingQueue()
+	if len(status.Matches) != 0 || len(status.Queue) != 1 || status.Queue[0].CharID != 3 {
+		t.Errorf("got queue %+v and %d matches, want only 3 queued again", status.Queue, len(status.Matches))
+	}
+	if _, ok := s.GetStage(stageID); ok {
+		t.Error("the stage of a timed out match wasn't removed")
+	}
+}
+
+func TestLeaveMatchmaking(t *testing.T) {
+	s, members := newMatchmakingParty(t)
+	if !s.leaveMatchmaking(members[0]) {
+		t.Fatal("queued character couldn't leave")
+	}
+	if s.leaveMatchmaking(members[0]) {
+		t.Error("character left the queue twice")
+	}
+
+	// A session dying in a match declines it for the rest.
+	s.enqueueMatch(members[0], 1, 50, 0, false)
+	s.matchOnce(time.Now())
+	logoutPlayer(members[1])
+	status := s.MatchmakingQueue()
+	if len(status.Matches) != 0 || len(status.Queue) != 2 {
+		t.Errorf("got %d queued and %d matches after a member's session died, want 2 and 0", len(status.Queue), len(status.Matches))
+	}
+}
+
+func TestEnqueueMatchRejections(t *testing.T) {
+	s := newTestServer()
+	session := newReservingSession(t, s, 1)
+	if err := s.enqueueMatch(session, 1, 50, 0, false); err != errMatchmakingDisabled {
+		t.Errorf("got %v with matchmaking disabled, want %v", err, errMatchmakingDisabled)
+	}
+	s.erupeConfig.Matchmaking.PartySize = 4
+	if err := s.enqueueMatch(session, 30, 10, 0, false); err != errInvalidFloorRange {
+		t.Errorf("got %v for a backwards floor range, want %v", err, errInvalidFloorRange)
+	}
+}
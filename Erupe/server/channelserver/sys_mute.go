@@ -0,0 +1,92 @@
+package channelserver
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Solenataris/Erupe/common/revocation"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// MutedForever is the end of a permanent mute.
+var MutedForever = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// characterMutedUntil returns the end of the mute in force on the character's account, zero if there's none.
+func characterMutedUntil(db *sqlx.DB, charID uint32) (time.Time, error) {
+	var permanent bool
+	var until sql.NullTime
+	err := db.QueryRow(`
+		SELECT COALESCE(bool_or(m.expires_at IS NULL), false), MAX(m.expires_at) FROM mutes m JOIN characters c ON c.user_id = m.user_id
+		WHERE c.id = $1 AND (m.expires_at IS NULL OR m.expires_at > now())
+	`, charID).Scan(&permanent, &until)
+	if permanent {
+		return MutedForever, err
+	}
+	return until.Time, err
+}
+
+// loadMute notes the mute in force on the logging in character's account.
+func loadMute(s *Session) {
+	until, err := characterMutedUntil(s.server.db, s.charID)
+	if err != nil {
+		s.logger.Error("Failed to get chat mute", zap.Error(err))
+		return
+	}
+	s.Lock()
+	s.mutedUntil = until
+	s.Unlock()
+}
+
+// muted reports whether the player's chat is muted at now, telling the player until when.
+func (s *Session) muted(now time.Time) bool {
+	s.Lock()
+	until := s.mutedUntil
+	s.Unlock()
+	if !now.Before(until) {
+		return false
+	}
+	if until.Equal(MutedForever) {
+		sendServerChatMessage(s, "Your chat is muted, your messages aren't sent.")
+	} else {
+		sendServerChatMessage(s, fmt.Sprintf("Your chat is muted until %s, your messages aren't sent.", until.Format("2006-01-02 15:04 MST")))
+	}
+	return true
+}
+
+// NotifyMute tells every channel sharing the DB to mute the account's sessions, see revocation.Mute.
+func NotifyMute(tx execer, userID uint32, until time.Time) error {
+	return revocation.Mute(tx, userID, until)
+}
+
+// MuteSessions mutes the chat of the account's sessions on the channel until the given time, telling the
+// players, and returns how many it muted. A session already muted as long isn't told again, so the same
+// mute reaching it from the notification of NotifyMute too is only told once.
+func (s *Server) MuteSessions(userID uint32, until time.Time) int {
+	var muted []*Session
+	s.Lock()
+	for _, session := range s.sessions {
+		if session.userID == userID && session.charID != 0 {
+			muted = append(muted, session)
+		}
+	}
+	s.Unlock()
+
+	count := 0
+	for _, session := range muted {
+		session.Lock()
+		extended := until.After(session.mutedUntil)
+		if extended {
+			session.mutedUntil = until
+		}
+		session.Unlock()
+		if !extended {
+			continue
+		}
+		count++
+		s.logger.Info("Muted session", zap.Uint32("userID", userID), zap.Uint32("charID", session.charID), zap.Time("until", until))
+		sendServerChatMessage(session, "Your chat was muted by a moderator.")
+	}
+	return count
+}
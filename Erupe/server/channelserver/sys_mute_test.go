@@ -0,0 +1,43 @@
+package channelserver
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMutedChatIsDropped(t *testing.T) {
+	s := newTestServer()
+	sender := newQueuedSession(t, s, 1, "Sender")
+	listener := newQueuedSession(t, s, 2, "Listener")
+	sender.userID, listener.userID = 10, 20
+	moveToStage(t, sender, "sl1Ns200p0a0u0")
+	moveToStage(t, listener, "sl1Ns200p0a0u0")
+
+	until := time.Now().Add(time.Hour)
+	if n := s.MuteSessions(10, until); n != 1 {
+		t.Fatalf("muted %d sessions, want 1", n)
+	}
+	// The same mute arriving again, from the notification of another process, isn't told twice.
+	if n := s.MuteSessions(10, until); n != 0 {
+		t.Errorf("the same mute muted %d sessions again", n)
+	}
+	handleMsgSysCastBinary(sender, chatPacket(0, BroadcastTypeStage, "hello"))
+	if bytes.Contains(sentPackets(listener), []byte("hello")) {
+		t.Error("a muted player's message was sent")
+	}
+	if !bytes.Contains(sentPackets(sender), []byte("muted until")) {
+		t.Error("the muted player wasn't told")
+	}
+
+	// A shorter mute doesn't cut a longer one short, an expired one lets chat through again.
+	s.MuteSessions(10, time.Now().Add(-time.Minute))
+	if !sender.muted(time.Now()) {
+		t.Error("a shorter mute lifted the longer one")
+	}
+	sender.mutedUntil = time.Now().Add(-time.Minute)
+	handleMsgSysCastBinary(sender, chatPacket(0, BroadcastTypeStage, "again"))
+	if !bytes.Contains(sentPackets(listener), []byte("again")) {
+		t.Error("chat stayed dropped after the mute expired")
+	}
+}
@@ -0,0 +1,81 @@
+package channelserver
+
+import (
+	"fmt"
+
+	"github.com/Solenataris/Erupe/common/names"
+	"go.uber.org/zap"
+)
+
+// checkNewName checks a name given to the session's character, or to a guild it creates if guild is
+// set, against the server's name rules. The ID is the character or guild's own, its name isn't taken by
+// itself.
+func checkNewName(s *Session, guild bool, name string, id uint32) error {
+	cfg := s.server.erupeConfig.Current().Names
+	rules := cfg.Character
+	if guild {
+		rules = cfg.Guild
+	}
+	if err := names.Check(name, rules, cfg.Reserved); err != nil {
+		return err
+	}
+	if s.server.words().ContainsWord(name) {
+		return names.ErrFiltered
+	}
+	if !rules.Unique {
+		return nil
+	}
+	taken, err := names.Taken(s.server.db, guild, name, id)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return names.ErrTaken
+	}
+	return nil
+}
+
+// nameRuleBroken reports whether checkNewName refused the name for a rule it breaks, rather than failing to check it.
+func nameRuleBroken(err error) bool {
+	switch err {
+	case names.ErrTooShort, names.ErrTooLong, names.ErrCharacter, names.ErrReserved, names.ErrFiltered, names.ErrTaken:
+		return true
+	}
+	return false
+}
+
+// sendRenameNotice reminds the player on entering the world that the character's name has to change.
+func sendRenameNotice(s *Session) {
+	s.Lock()
+	forceRename, name := s.forceRename, s.Name
+	s.Unlock()
+	if forceRename {
+		sendServerChatMessage(s, fmt.Sprintf("The character name %q isn't allowed on this server and has to be changed.", name))
+	}
+}
+
+// refuseName tells the player why the name was refused.
+func refuseName(s *Session, guild bool, name string, err error) {
+	kind := "character"
+	cfg := s.server.erupeConfig.Current().Names
+	rules := cfg.Character
+	if guild {
+		kind = "guild"
+		rules = cfg.Guild
+	}
+	s.logger.Info("Refused name", zap.String("kind", kind), zap.String("name", name), zap.Error(err))
+	switch err {
+	case names.ErrTooShort, names.ErrTooLong:
+		sendServerChatMessage(s, fmt.Sprintf("A %s name must be %d to %d bytes long, a Japanese character takes two.", kind, rules.MinLength, rules.MaxLength))
+	case names.ErrCharacter:
+		sendServerChatMessage(s, fmt.Sprintf("The %s name has characters that aren't allowed.", kind))
+	case names.ErrReserved:
+		sendServerChatMessage(s, fmt.Sprintf("The %s name contains a reserved word.", kind))
+	case names.ErrFiltered:
+		sendServerChatMessage(s, fmt.Sprintf("The %s name contains a filtered word.", kind))
+	case names.ErrTaken:
+		sendServerChatMessage(s, fmt.Sprintf("The %s name is already taken.", kind))
+	default:
+		sendServerChatMessage(s, fmt.Sprintf("Failed to check the %s name.", kind))
+	}
+}
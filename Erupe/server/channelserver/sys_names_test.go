@@ -0,0 +1,59 @@
+package channelserver
+
+import (
+	"testing"
+
+	"github.com/Solenataris/Erupe/common/testdb"
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"github.com/Solenataris/Erupe/server/channelserver/compression/nullcomp"
+)
+
+// TestSaveKeepsRefusedName runs against the database in ERUPE_TEST_DB, like TestChargeFestaSouls.
+func TestSaveKeepsRefusedName(t *testing.T) {
+	db := testdb.Open(t)
+
+	var userID, charID uint32
+	if err := db.QueryRow("INSERT INTO users (username, password) VALUES ('refused_name_test', '') RETURNING id").Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+	err := db.QueryRow("INSERT INTO characters (user_id, is_new_character, name) VALUES ($1, true, '') RETURNING id", userID).Scan(&charID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM characters WHERE id = $1", charID)
+	defer db.Exec("DELETE FROM savedata_backups WHERE char_id = $1", charID)
+
+	s := newTestServer()
+	s.db = db
+	s.erupeConfig.Names = config.Names{Character: config.NameRules{MinLength: 4, MaxLength: 12}}
+	session := newQueuedSession(t, s, charID, "")
+	save := func(name string) (string, bool) {
+		data := make([]byte, 0x20000)
+		copy(data[88:], name)
+		payload, err := nullcomp.Compress(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handleMsgMhfSavedata(session, &mhfpacket.MsgMhfSavedata{SaveType: 0, AckHandle: 1, RawDataPayload: payload})
+		sentPackets(session)
+		var stored string
+		var forceRename bool
+		if err := db.QueryRow("SELECT name, force_rename FROM characters WHERE id = $1", charID).Scan(&stored, &forceRename); err != nil {
+			t.Fatal(err)
+		}
+		return stored, forceRename
+	}
+
+	// A name too short is stored with the save, the character is flagged to be renamed.
+	if stored, forceRename := save("Al"); stored != "Al" || !forceRename || !session.forceRename {
+		t.Errorf("a refused name was stored as %q, flagged %v", stored, forceRename)
+	}
+	if stored, forceRename := save("Al"); stored != "Al" || !forceRename {
+		t.Errorf("a save keeping the refused name stored %q, flagged %v", stored, forceRename)
+	}
+	if stored, forceRename := save("Alpha"); stored != "Alpha" || forceRename || session.forceRename {
+		t.Errorf("an allowed name was stored as %q, flagged %v", stored, forceRename)
+	}
+}
@@ -0,0 +1,122 @@
+package channelserver
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/Solenataris/Erupe/common/accountgrant"
+	"github.com/Solenataris/Erupe/config"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// isNewcomerEligible checks the account age and character HR thresholds.
+func isNewcomerEligible(db *sqlx.DB, cfg config.Newcomer, userID uint32, charID uint32) (bool, error) {
+	if cfg.MaxAccountAgeDays > 0 {
+		var createdAt sql.NullTime
+		err := db.QueryRow("SELECT created_at FROM users WHERE id = $1", userID).Scan(&createdAt)
+		if err != nil {
+			return false, err
+		}
+		if createdAt.Valid && time.Since(createdAt.Time) < time.Duration(cfg.MaxAccountAgeDays)*24*time.Hour {
+			return true, nil
+		}
+	}
+	if cfg.MaxHRP > 0 {
+		var hrp uint16
+		err := db.QueryRow("SELECT COALESCE(hrp, 0) FROM characters WHERE id = $1", charID).Scan(&hrp)
+		if err != nil {
+			return false, err
+		}
+		if hrp < cfg.MaxHRP {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// applyNewcomerBoosts grants the newcomer boosts once per account and
+// returns the rights with any boost courses added while they're active.
+func applyNewcomerBoosts(s *Session, userID uint32, charID uint32, rights uint32) uint32 {
+	cfg := s.server.erupeConfig.Current().Newcomer
+	if !cfg.Enabled {
+		return rights
+	}
+
+	expiry, err := accountgrant.NewcomerBoostExpiry(s.server.db, cfg, userID)
+	if err != nil {
+		s.logger.Error("Failed to get newcomer boost status", zap.Error(err))
+		return rights
+	}
+
+	if expiry.IsZero() {
+		eligible, err := isNewcomerEligible(s.server.db, cfg, userID, charID)
+		if err != nil {
+			s.logger.Error("Failed to check newcomer eligibility", zap.Error(err))
+			return rights
+		}
+		if !eligible {
+			return rights
+		}
+		if !grantNewcomerBoosts(s, cfg, userID, charID) {
+			return rights
+		}
+		expiry = time.Now().Add(time.Duration(cfg.BoostDays) * 24 * time.Hour)
+	}
+
+	if time.Now().After(expiry) {
+		return rights
+	}
+
+	s.Lock()
+	s.newcomerUntil = expiry
+	s.Unlock()
+	for _, course := range cfg.Courses {
+		rights |= 1 << course
+	}
+	return rights
+}
+
+// grantNewcomerBoosts claims the newcomer grant and queues the starter distribution in one transaction.
+func grantNewcomerBoosts(s *Session, cfg config.Newcomer, userID uint32, charID uint32) bool {
+	tx, err := s.server.db.Beginx()
+	if err != nil {
+		s.logger.Error("Failed to begin newcomer grant", zap.Error(err))
+		return false
+	}
+	claimed, err := accountgrant.Claim(tx, userID, accountgrant.NewcomerKey)
+	if err != nil || !claimed {
+		tx.Rollback()
+		if err != nil {
+			s.logger.Error("Failed to claim newcomer grant", zap.Error(err))
+		}
+		return false
+	}
+
+	if len(cfg.StarterItems) > 0 {
+		items := make([]DistItemEntry, len(cfg.StarterItems))
+		for i, item := range cfg.StarterItems {
+			items[i] = DistItemEntry{item.ItemType, item.ItemID, item.Quantity}
+		}
+		err = createCharacterDistribution(tx, charID, "Starter Pack", "~C05Welcome to the hunt!", items)
+		if err != nil {
+			tx.Rollback()
+			s.logger.Error("Failed to create newcomer starter distribution", zap.Error(err))
+			return false
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		s.logger.Error("Failed to commit newcomer grant", zap.Error(err))
+		return false
+	}
+	s.logger.Info("Granted newcomer boosts", zap.Uint32("userID", userID), zap.Uint32("charID", charID))
+	return true
+}
+
+// isNewcomerBoosted reports whether the session's newcomer boosts are currently active.
+func (s *Session) isNewcomerBoosted() bool {
+	s.Lock()
+	defer s.Unlock()
+	return time.Now().Before(s.newcomerUntil)
+}
@@ -0,0 +1,66 @@
+package channelserver
+
+import (
+	"testing"
+
+	"github.com/Solenataris/Erupe/common/accountgrant"
+	"github.com/Solenataris/Erupe/common/testdb"
+	"github.com/Solenataris/Erupe/config"
+)
+
+// TestApplyNewcomerBoosts runs against the database in ERUPE_TEST_DB, like TestRedeemCampaignCode.
+func TestApplyNewcomerBoosts(t *testing.T) {
+	db := testdb.Open(t)
+	s := newTestServer()
+	s.db = db
+	s.erupeConfig.Newcomer = config.Newcomer{
+		Enabled:      true,
+		MaxHRP:       100,
+		BoostDays:    7,
+		Courses:      []uint8{3},
+		StarterItems: []config.StarterItem{{ItemType: 7, ItemID: 1, Quantity: 10}},
+	}
+
+	var chars []uint32
+	var users []uint32
+	for _, username := range []string{"newcomer_test", "newcomer_test2"} {
+		var userID, charID uint32
+		if err := db.QueryRow("INSERT INTO users (username, password) VALUES ($1, '') RETURNING id", username).Scan(&userID); err != nil {
+			t.Fatal(err)
+		}
+		defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+		defer db.Exec("DELETE FROM account_grants WHERE user_id = $1", userID)
+		err := db.QueryRow("INSERT INTO characters (user_id, is_new_character, name, hrp) VALUES ($1, false, 'newcomer', 1) RETURNING id", userID).Scan(&charID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Exec("DELETE FROM characters WHERE id = $1", charID)
+		defer db.Exec("DELETE FROM distribution WHERE character_id = $1", charID)
+		users = append(users, userID)
+		chars = append(chars, charID)
+	}
+	distributions := func(charID uint32) (n int) {
+		db.QueryRow("SELECT count(*) FROM distribution WHERE character_id = $1", charID).Scan(&n)
+		return
+	}
+
+	// The boosts are granted on the first login and stay while they last, the starter pack isn't given again.
+	for i := 0; i < 2; i++ {
+		session := newQueuedSession(t, s, chars[0], "newcomer")
+		if rights := applyNewcomerBoosts(session, users[0], chars[0], 0); rights != 1<<3 || !session.isNewcomerBoosted() {
+			t.Errorf("login %d got rights %b, boosted %v", i+1, rights, session.isNewcomerBoosted())
+		}
+	}
+	if n := distributions(chars[0]); n != 1 {
+		t.Errorf("two logins queued %d starter packs", n)
+	}
+
+	// An account an admin already gave the starter pack to isn't given another.
+	if _, err := accountgrant.Claim(db, users[1], accountgrant.NewcomerKey); err != nil {
+		t.Fatal(err)
+	}
+	applyNewcomerBoosts(newQueuedSession(t, s, chars[1], "newcomer"), users[1], chars[1], 0)
+	if n := distributions(chars[1]); n != 0 {
+		t.Errorf("an account granted by an admin got %d starter packs", n)
+	}
+}
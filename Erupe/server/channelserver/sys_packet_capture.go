@@ -0,0 +1,75 @@
+package channelserver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"github.com/Solenataris/Erupe/network/packetcapture"
+	"go.uber.org/zap"
+)
+
+// openPacketCapture starts writing every packet of the session to a capture file when
+// DevModeOptions.PacketCapture is on, deleting the captures past their age first.
+// It runs before the session's loops start, packets aren't captured at all without a capture.
+func (s *Session) openPacketCapture() {
+	cfg := s.server.erupeConfig.DevModeOptions.PacketCapture
+	if !s.server.erupeConfig.DevMode || !cfg.Enabled {
+		return
+	}
+	if err := os.MkdirAll(cfg.OutputDir, 0755); err != nil {
+		s.logger.Error("Failed to create the packet capture directory", zap.Error(err))
+		return
+	}
+	if cfg.MaxAge > 0 {
+		if err := packetcapture.RemoveOlder(cfg.OutputDir, time.Now().Add(-time.Duration(cfg.MaxAge)*time.Hour)); err != nil {
+			s.logger.Warn("Failed to clean up old packet captures", zap.Error(err))
+		}
+	}
+	name := fmt.Sprintf("%s_%s_%s%s", traceFileName(s.server.name), time.Now().Format("20060102-150405.000"), traceFileName(s.rawConn.RemoteAddr().String()), packetcapture.Extension)
+	path := filepath.Join(cfg.OutputDir, name)
+	capture, err := packetcapture.Create(path, int64(cfg.MaxSize)<<10)
+	if err != nil {
+		s.logger.Error("Failed to create packet capture", zap.Error(err))
+		return
+	}
+	s.capture = capture
+	s.logger.Info("Capturing packets", zap.String("path", path))
+}
+
+// capturePacket writes a single decrypted packet, starting at its opcode, to the session's capture.
+// Secrets are scrubbed from it as they are from traces, read from the parsed packet if there is one.
+func (s *Session) capturePacket(direction packetcapture.Direction, data []byte, pkt mhfpacket.MHFPacket) {
+	if s.capture == nil {
+		return
+	}
+	if len(data) >= 2 {
+		data = scrubPacket(network.PacketID(uint16(data[0])<<8|uint16(data[1])), data, pkt)
+	}
+	// A capture that fails or fills up mustn't get in the way of the session, its packets are dropped.
+	s.capture.Write(direction, time.Now(), data)
+}
+
+// capturePackets writes the packets sent together in one frame one by one, each starting at one of the offsets.
+func (s *Session) capturePackets(direction packetcapture.Direction, data []byte, starts []int) {
+	if s.capture == nil {
+		return
+	}
+	for i, start := range starts {
+		end := len(data)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		s.capturePacket(direction, data[start:end], nil)
+	}
+}
+
+// closePacketCapture closes the session's capture, if it has one.
+func (s *Session) closePacketCapture() {
+	if s.capture != nil {
+		s.capture.Close()
+	}
+}
@@ -0,0 +1,96 @@
+package channelserver
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"github.com/Solenataris/Erupe/network/packetcapture"
+	"go.uber.org/zap"
+)
+
+func TestPacketCapture(t *testing.T) {
+	dir := t.TempDir()
+	erupeConfig := &config.Config{DevMode: true, DevModeOptions: config.DevModeOptions{
+		PacketCapture: config.PacketCaptureOptions{Enabled: true, OutputDir: dir, MaxSize: 64},
+	}}
+	s := NewServer(&Config{Logger: zap.NewNop(), ErupeConfig: erupeConfig, Name: "World 1"})
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	session := NewSession(s, serverConn)
+	session.openPacketCapture()
+	if session.capture == nil {
+		t.Fatal("no capture was opened")
+	}
+
+	// A login whose sign in token must not end up in the capture, scrubbed by what the packet parsed to.
+	login := &mhfpacket.MsgSysLogin{CharID0: 0x2233, LoginTokenNumber: 0x11111111, LoginTokenString: strings.Repeat("\x11", 16)}
+	bf := byteframe.NewByteFrame()
+	bf.WriteUint16(uint16(network.MSG_SYS_LOGIN))
+	login.Build(bf, session.clientContext)
+	session.capturePacket(packetcapture.Inbound, bf.Data(), login)
+	// A frame of two NOPs, captured one packet at a time as they're handled.
+	session.handlePacketGroup([]byte{0x00, byte(network.MSG_SYS_NOP), 0x00, byte(network.MSG_SYS_NOP), 0x00, 0x10})
+	go session.sendLoop()
+	group := newPacketGroup()
+	group.add(&mhfpacket.MsgSysPing{}, session.clientContext)
+	group.add(&mhfpacket.MsgSysPing{AckHandle: 1}, session.clientContext)
+	session.QueueSendGroup(group)
+	if _, err := network.NewCryptConn(clientConn).ReadPacket(); err != nil {
+		t.Fatal(err)
+	}
+	session.sendPackets <- nil
+	session.closePacketCapture()
+
+	paths, _ := filepath.Glob(filepath.Join(dir, "World_1_*"+packetcapture.Extension))
+	if len(paths) != 1 {
+		t.Fatalf("got captures %v, want one for the session", paths)
+	}
+	file, err := os.Open(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	r, err := packetcapture.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, err := r.Next()
+	if err != nil || packet.Direction != packetcapture.Inbound || packet.Opcode != network.MSG_SYS_LOGIN {
+		t.Fatalf("got first packet %+v, %v, want the login", packet, err)
+	}
+	if bytes.Contains(packet.Data, []byte{0x11, 0x11}) {
+		t.Error("the sign in token was captured")
+	}
+	if !bytes.Contains(packet.Data, []byte{0x00, 0x00, 0x22, 0x33}) {
+		t.Error("the login was blanked past its secrets")
+	}
+	for _, want := range []struct {
+		direction packetcapture.Direction
+		opcode    network.PacketID
+		length    int
+	}{
+		{packetcapture.Inbound, network.MSG_SYS_NOP, 2},
+		{packetcapture.Inbound, network.MSG_SYS_NOP, 2},
+		{packetcapture.Inbound, network.MSG_SYS_END, 2},
+		{packetcapture.Outbound, network.MSG_SYS_PING, 6},
+		{packetcapture.Outbound, network.MSG_SYS_PING, 6},
+		{packetcapture.Outbound, network.MSG_SYS_END, 2},
+	} {
+		packet, err := r.Next()
+		if err != nil || packet.Direction != want.direction || packet.Opcode != want.opcode || len(packet.Data) != want.length {
+			t.Fatalf("got packet %+v, %v, want %s %d bytes", packet, err, want.opcode, want.length)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("got %v after the last packet", err)
+	}
+}
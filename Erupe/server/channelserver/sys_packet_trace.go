@@ -0,0 +1,241 @@
+package channelserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"go.uber.org/zap"
+)
+
+// packetSecrets return the secrets a packet of their type carries, read from the parsed packet. Wherever
+// they appear in the packet, they're blanked out before it's written to a trace.
+var packetSecrets = map[network.PacketID]func(pkt mhfpacket.MHFPacket) [][]byte{
+	network.MSG_SYS_LOGIN: func(pkt mhfpacket.MHFPacket) [][]byte {
+		login := pkt.(*mhfpacket.MsgSysLogin)
+		number := make([]byte, 4)
+		binary.BigEndian.PutUint32(number, login.LoginTokenNumber)
+		return [][]byte{number, []byte(strings.TrimRight(login.LoginTokenString, "\x00"))}
+	},
+}
+
+// scrubPacket returns a copy of the packet with its secrets blanked out. A packet of a type carrying
+// secrets that didn't parse is blanked past its opcode, there's no telling where they are in it.
+func scrubPacket(opcode network.PacketID, data []byte, pkt mhfpacket.MHFPacket) []byte {
+	secrets, ok := packetSecrets[opcode]
+	if !ok {
+		return data
+	}
+	data = append([]byte(nil), data...)
+	if pkt == nil || pkt.Opcode() != opcode {
+		redact(data[2:])
+		return data
+	}
+	for _, secret := range secrets(pkt) {
+		if len(bytes.Trim(secret, "\x00")) == 0 {
+			continue
+		}
+		for i := bytes.Index(data, secret); i >= 0; i = bytes.Index(data, secret) {
+			redact(data[i : i+len(secret)])
+		}
+	}
+	return data
+}
+
+func redact(data []byte) {
+	for i := range data {
+		data[i] = '*'
+	}
+}
+
+// packetTrace writes every packet a character sends and receives to its own file until it expires.
+type packetTrace struct {
+	sync.Mutex
+	charID  uint32
+	path    string
+	file    *os.File
+	expires time.Time
+	timer   *time.Timer
+}
+
+// close stops the expiry and closes the file, packets traced after it are dropped.
+func (t *packetTrace) close() {
+	t.Lock()
+	defer t.Unlock()
+	t.timer.Stop()
+	t.file.Close()
+	t.file = nil
+}
+
+// PacketTraceInfo is a running packet trace as listed by the admin API.
+type PacketTraceInfo struct {
+	CharID  uint32    `json:"charID"`
+	Path    string    `json:"path"`
+	Expires time.Time `json:"expires"`
+}
+
+// packetTraceSet holds the traces running on a channel, one per character.
+type packetTraceSet struct {
+	sync.RWMutex
+	traces map[uint32]*packetTrace
+	count  int32 // Read without the lock, so sessions skip the map while nothing is traced.
+}
+
+func newPacketTraceSet() *packetTraceSet {
+	return &packetTraceSet{traces: make(map[uint32]*packetTrace)}
+}
+
+func (t *packetTraceSet) get(charID uint32) *packetTrace {
+	t.RLock()
+	defer t.RUnlock()
+	return t.traces[charID]
+}
+
+// remove drops the character's trace if it's still the given one and closes its file.
+func (t *packetTraceSet) remove(charID uint32, trace *packetTrace) bool {
+	t.Lock()
+	if t.traces[charID] != trace || trace == nil {
+		t.Unlock()
+		return false
+	}
+	delete(t.traces, charID)
+	atomic.StoreInt32(&t.count, int32(len(t.traces)))
+	t.Unlock()
+	trace.close()
+	return true
+}
+
+// TracePackets starts writing the character's packets, both ways, to a new file for the given duration.
+// A trace already running for the character is replaced.
+func (s *Server) TracePackets(charID uint32, duration time.Duration) (PacketTraceInfo, error) {
+	if duration <= 0 {
+		return PacketTraceInfo{}, errors.New("a packet trace needs a duration")
+	}
+	dir := s.erupeConfig.Admin.PacketTraceDir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return PacketTraceInfo{}, err
+	}
+	now := time.Now()
+	name := fmt.Sprintf("%s_%d_%s.log", traceFileName(s.name), charID, now.Format("20060102-150405"))
+	path := filepath.Join(dir, name)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return PacketTraceInfo{}, err
+	}
+
+	trace := &packetTrace{charID: charID, path: path, file: file, expires: now.Add(duration)}
+	fmt.Fprintf(file, "Packet trace of character %d on %s, started %s until %s\n\n", charID, s.name, now.Format(time.RFC3339), trace.expires.Format(time.RFC3339))
+	trace.timer = time.AfterFunc(duration, func() {
+		if s.packetTraces.remove(charID, trace) {
+			s.logger.Info("Packet trace expired", zap.Uint32("charID", charID), zap.String("path", path))
+		}
+	})
+
+	s.packetTraces.Lock()
+	previous := s.packetTraces.traces[charID]
+	s.packetTraces.traces[charID] = trace
+	atomic.StoreInt32(&s.packetTraces.count, int32(len(s.packetTraces.traces)))
+	s.packetTraces.Unlock()
+	if previous != nil {
+		previous.close()
+	}
+
+	s.logger.Info("Started packet trace", zap.Uint32("charID", charID), zap.String("path", path), zap.Duration("duration", duration))
+	return PacketTraceInfo{charID, path, trace.expires}, nil
+}
+
+// StopTracingPackets stops the character's trace, reporting whether one was running.
+func (s *Server) StopTracingPackets(charID uint32) bool {
+	s.packetTraces.RLock()
+	trace := s.packetTraces.traces[charID]
+	s.packetTraces.RUnlock()
+	return s.packetTraces.remove(charID, trace)
+}
+
+// PacketTraces lists the traces running on the channel.
+func (s *Server) PacketTraces() []PacketTraceInfo {
+	s.packetTraces.RLock()
+	defer s.packetTraces.RUnlock()
+	list := make([]PacketTraceInfo, 0, len(s.packetTraces.traces))
+	for _, trace := range s.packetTraces.traces {
+		list = append(list, PacketTraceInfo{trace.charID, trace.path, trace.expires})
+	}
+	return list
+}
+
+// stopPacketTraces closes every running trace.
+func (s *Server) stopPacketTraces() {
+	for _, info := range s.PacketTraces() {
+		s.StopTracingPackets(info.CharID)
+	}
+}
+
+// traceFileName keeps the letters and digits of a channel name so it can go in a file name.
+func traceFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// tracePacket writes a single packet, starting at its opcode, to the trace of the session's character if it
+// has one. pkt is the packet parsed, nil for packets sent or that didn't parse. Until the login is handled,
+// the character is the one the login names, so the login and its answer are traced.
+func (s *Session) tracePacket(direction string, data []byte, pkt mhfpacket.MHFPacket) {
+	if atomic.LoadInt32(&s.server.packetTraces.count) == 0 {
+		return
+	}
+	s.Lock()
+	charID := s.charID
+	if charID == 0 {
+		charID = s.loginCharID
+	}
+	s.Unlock()
+	s.server.traceCharPacket(charID, direction, data, pkt)
+}
+
+// tracePackets writes the packets sent together in one frame one by one, each starting at one of the offsets.
+func (s *Session) tracePackets(direction string, data []byte, starts []int) {
+	if atomic.LoadInt32(&s.server.packetTraces.count) == 0 {
+		return
+	}
+	for i, start := range starts {
+		end := len(data)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		s.tracePacket(direction, data[start:end], nil)
+	}
+}
+
+// traceCharPacket writes a single packet to the character's trace if it has one.
+func (s *Server) traceCharPacket(charID uint32, direction string, data []byte, pkt mhfpacket.MHFPacket) {
+	if atomic.LoadInt32(&s.packetTraces.count) == 0 || len(data) < 2 {
+		return
+	}
+	trace := s.packetTraces.get(charID)
+	if trace == nil {
+		return
+	}
+
+	opcode := network.PacketID(uint16(data[0])<<8 | uint16(data[1]))
+	data = scrubPacket(opcode, data, pkt)
+	trace.Lock()
+	defer trace.Unlock()
+	if trace.file == nil {
+		return
+	}
+	fmt.Fprintf(trace.file, "%s %s %s [%d bytes]\n%s\n", time.Now().Format("15:04:05.000"), direction, opcode, len(data), hex.Dump(data))
+}
@@ -0,0 +1,98 @@
+package channelserver
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+)
+
+func TestPacketTrace(t *testing.T) {
+	s := newTestServer()
+	s.name = "Test channel"
+	s.erupeConfig.Admin.PacketTraceDir = t.TempDir()
+
+	alpha, err := s.TracePackets(1, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	beta, err := s.TracePackets(2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alpha.Path == beta.Path {
+		t.Fatalf("both characters trace to %s", alpha.Path)
+	}
+
+	bf := byteframe.NewByteFrame()
+	bf.WriteUint16(uint16(network.MSG_SYS_LOGIN))
+	bf.WriteUint32(0x10)       // AckHandle
+	bf.WriteUint32(1)          // CharID0
+	bf.WriteUint32(0x01020304) // LoginTokenNumber
+	bf.WriteUint16(0)
+	bf.WriteUint16(0)
+	bf.WriteUint32(1)
+	bf.WriteUint16(0)
+	bf.WriteUint16(0x11)
+	bf.WriteBytes([]byte("secrettokensecre\x00"))
+	login := &mhfpacket.MsgSysLogin{}
+	if err := login.Parse(byteframe.NewByteFrameFromBytes(bf.Data()[2:]), nil); err != nil || login.LoginTokenString != "secrettokensecre\x00" {
+		t.Fatalf("test packet parsed to %+v, %v", login, err)
+	}
+
+	// Until the login is handled, the session traces for the character it names.
+	session := newQueuedSession(t, s, 0, "alpha")
+	session.loginCharID = 1
+	session.tracePacket("recv", bf.Data(), login)
+	// A login that didn't parse is blanked whole.
+	session.tracePacket("recv", bf.Data()[:20], nil)
+	session.charID = 1
+	group := newPacketGroup()
+	group.add(&mhfpacket.MsgSysInsertUser{CharID: 5}, nil)
+	group.add(&mhfpacket.MsgSysDeleteUser{CharID: 6}, nil)
+	session.QueueSendGroup(group)
+	s.traceCharPacket(3, "recv", []byte{0x00, 0x12, 0xCD}, nil)
+
+	if len(s.PacketTraces()) != 2 {
+		t.Errorf("got %d traces, want 2", len(s.PacketTraces()))
+	}
+	s.stopPacketTraces()
+	if len(s.PacketTraces()) != 0 || s.StopTracingPackets(1) {
+		t.Error("traces kept running after being stopped")
+	}
+
+	data, err := ioutil.ReadFile(alpha.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trace := string(data)
+	if strings.Count(trace, "recv MSG_SYS_LOGIN") != 2 || !strings.Contains(trace, "send MSG_SYS_INSERT_USER") ||
+		!strings.Contains(trace, "send MSG_SYS_DELETE_USER") || !strings.Contains(trace, "send MSG_SYS_END") {
+		t.Errorf("trace is missing packets:\n%s", trace)
+	}
+	if strings.Contains(trace, "ecre") || strings.Contains(trace, "token") || strings.Contains(trace, "01 02 03 04") {
+		t.Errorf("trace leaks the sign in token:\n%s", trace)
+	}
+	if data, _ := ioutil.ReadFile(beta.Path); strings.Contains(string(data), "MSG_SYS") {
+		t.Errorf("untouched trace got packets:\n%s", data)
+	}
+}
+
+func TestPacketTraceExpires(t *testing.T) {
+	s := newTestServer()
+	s.erupeConfig.Admin.PacketTraceDir = t.TempDir()
+	if _, err := s.TracePackets(1, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.PacketTraces()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("trace didn't expire")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,90 @@
+package channelserver
+
+import (
+	"github.com/Solenataris/Erupe/network/binpacket"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+)
+
+// currentStage returns the stage the session is in now, read under its lock so a stage move
+// halfway through doesn't hand back the one it's leaving. The session lock is released before
+// the stage is used, the stage lock must be taken first.
+func (s *Session) currentStage() *Stage {
+	s.Lock()
+	defer s.Unlock()
+	return s.stage
+}
+
+// partyStage returns the quest stage of the session's party: the quest it's in, or the one it holds a
+// slot in while the party gets ready or is still on its way. Nil when it isn't in a quest party.
+func partyStage(s *Session) *Stage {
+	s.Lock()
+	current, reserved := s.stage, s.reservationStage
+	charID := s.charID
+	s.Unlock()
+
+	if current != nil && isQuestStageID(current.id) {
+		return current
+	}
+	if reserved != nil {
+		reserved.RLock()
+		_, ok := reserved.reservedClientSlots[charID]
+		destroyed := reserved.destroyed
+		reserved.RUnlock()
+		if ok && !destroyed {
+			return reserved
+		}
+	}
+	return nil
+}
+
+// partyMembers returns the sessions in the quest stage or holding a slot in it, wherever they stand.
+func (s *Server) partyMembers(stage *Stage) []*Session {
+	stage.RLock()
+	members := make(map[*Session]bool, len(stage.clients))
+	for session := range stage.clients {
+		members[session] = true
+	}
+	reserved := make([]uint32, 0, len(stage.reservedClientSlots))
+	for charID := range stage.reservedClientSlots {
+		reserved = append(reserved, charID)
+	}
+	stage.RUnlock()
+
+	for _, charID := range reserved {
+		if session := s.FindSessionByCharID(charID); session != nil {
+			members[session] = true
+		}
+	}
+	sessions := make([]*Session, 0, len(members))
+	for session := range members {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// sendPartyChat sends party chat to the members of the sender's quest party, following the quest
+// rather than the stage each member stands in, so it never reaches the hub the party left from.
+// Outside a quest party it goes to the sender's current stage, as the client scopes it.
+func sendPartyChat(s *Session, resp *mhfpacket.MsgSysCastedBinary) {
+	stage := partyStage(s)
+	if stage == nil {
+		if current := s.currentStage(); current != nil {
+			current.BroadcastMHF(resp, s)
+		}
+		return
+	}
+	for _, member := range s.server.partyMembers(stage) {
+		if member != s {
+			member.QueueSendMHF(resp)
+		}
+	}
+}
+
+// isPartyChat reports whether the casted binary is party chat, which follows the quest party,
+// rather than chat scoped to its broadcast type.
+func isPartyChat(pkt *mhfpacket.MsgSysCastBinary, payload []byte) bool {
+	if pkt.MessageType != BinaryMessageTypeChat || chatTypeOf(payload) != binpacket.ChatTypeParty {
+		return false
+	}
+	return pkt.BroadcastType != BroadcastTypeTargeted && pkt.BroadcastType != BroadcastTypeWorld
+}
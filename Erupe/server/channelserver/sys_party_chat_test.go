@@ -0,0 +1,137 @@
+package channelserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/network/binpacket"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+)
+
+func chatPacket(chatType binpacket.ChatType, broadcastType uint8, message string) *mhfpacket.MsgSysCastBinary {
+	bf := byteframe.NewByteFrame()
+	bf.SetLE()
+	(&binpacket.MsgBinChat{Type: chatType, Message: message, SenderName: "Sender"}).Build(bf)
+	return &mhfpacket.MsgSysCastBinary{
+		BroadcastType:  broadcastType,
+		MessageType:    BinaryMessageTypeChat,
+		RawDataPayload: bf.Data(),
+	}
+}
+
+// moveToStage puts the session in the stage and out of every other, as a stage transfer leaves it.
+func moveToStage(t *testing.T, session *Session, stageID string) *Stage {
+	t.Helper()
+	session.server.stagesLock.RLock()
+	for _, stage := range session.server.stages {
+		stage.Lock()
+		delete(stage.clients, session)
+		stage.Unlock()
+	}
+	session.server.stagesLock.RUnlock()
+	stage, _ := session.server.GetOrCreateStage(stageID)
+	stage.Lock()
+	stage.clients[session] = session.charID
+	stage.Unlock()
+	session.Lock()
+	session.stage = stage
+	session.Unlock()
+	return stage
+}
+
+// reserveSlot gives the session a slot in the quest stage, as MsgSysReserveStage does.
+func reserveSlot(session *Session, stage *Stage) {
+	stage.Lock()
+	stage.reservedClientSlots[session.charID] = nil
+	stage.Unlock()
+	session.Lock()
+	session.reservationStage = stage
+	session.Unlock()
+}
+
+func TestPartyChatStaysOutOfTheHub(t *testing.T) {
+	s := newTestServer()
+	host := newChatMember(t, s, 1)
+	member := newChatMember(t, s, 2)
+	loading := newChatMember(t, s, 3)
+	bystander := newChatMember(t, s, 4)
+	for _, session := range []*Session{host, member, loading, bystander} {
+		moveToStage(t, session, "sl1Ns200p0a0u0")
+	}
+
+	// The party departs, one member is still on the way.
+	quest, _ := s.GetOrCreateStage("sl1Qs123p0a0u0")
+	for _, session := range []*Session{host, member, loading} {
+		reserveSlot(session, quest)
+	}
+	moveToStage(t, host, quest.id)
+	moveToStage(t, member, quest.id)
+
+	for _, broadcastType := range []uint8{BroadcastTypeStage, BroadcastTypeSemaphore} {
+		handleMsgSysCastBinary(host, chatPacket(binpacket.ChatTypeParty, broadcastType, "gather at camp"))
+		if got := receivedChat(bystander, 0); len(got) != 0 {
+			t.Errorf("broadcast type %d: the hub got party chat from %v", broadcastType, got)
+		}
+		if got := receivedChat(member, 0); len(got) != 1 {
+			t.Errorf("broadcast type %d: the member in the quest got party chat %d times, want once", broadcastType, len(got))
+		}
+		if got := receivedChat(loading, 0); len(got) != 1 {
+			t.Errorf("broadcast type %d: the member still in the hub got party chat %d times, want once", broadcastType, len(got))
+		}
+		if got := receivedChat(host, 0); len(got) != 0 {
+			t.Errorf("broadcast type %d: the sender got its party chat back", broadcastType)
+		}
+	}
+
+	// Local chat stays in the stage the sender stands in.
+	handleMsgSysCastBinary(host, chatPacket(binpacket.ChatTypeLocal, BroadcastTypeStage, "nice"))
+	if got := receivedChat(member, 0); len(got) != 1 {
+		t.Errorf("local chat reached the quest %d times, want once", len(got))
+	}
+	if got := receivedChat(loading, 0); len(got) != 0 {
+		t.Error("local chat in the quest reached the hub")
+	}
+}
+
+func TestWhisperUnaffectedByParty(t *testing.T) {
+	s := newTestServer()
+	sender := newChatMember(t, s, 1)
+	target := newChatMember(t, s, 2)
+	quest, _ := s.GetOrCreateStage("sl1Qs123p0a0u0")
+	reserveSlot(sender, quest)
+	moveToStage(t, sender, quest.id)
+	moveToStage(t, target, "sl1Ns200p0a0u0")
+
+	chat := byteframe.NewByteFrame()
+	chat.SetLE()
+	(&binpacket.MsgBinChat{Type: binpacket.ChatTypeWhisper, Message: "psst", SenderName: "Sender"}).Build(chat)
+	bf := byteframe.NewByteFrame()
+	(&binpacket.MsgBinTargeted{TargetCount: 1, TargetCharIDs: []uint32{2}, RawDataPayload: chat.Data()}).Build(bf)
+	handleMsgSysCastBinary(sender, &mhfpacket.MsgSysCastBinary{
+		BroadcastType:  BroadcastTypeTargeted,
+		MessageType:    BinaryMessageTypeChat,
+		RawDataPayload: bf.Data(),
+	})
+	if got := receivedChat(target, 100*time.Millisecond); len(got) != 1 || got[0] != 1 {
+		t.Errorf("whisper from the quest got %v in the hub, want the sender's once", got)
+	}
+}
+
+func TestPartyChatOutsideAParty(t *testing.T) {
+	s := newTestServer()
+	sender := newChatMember(t, s, 1)
+	near := newChatMember(t, s, 2)
+	far := newChatMember(t, s, 3)
+	moveToStage(t, sender, "sl1Ns200p0a0u0")
+	moveToStage(t, near, "sl1Ns200p0a0u0")
+	moveToStage(t, far, "sl1Ns211p0a0u0")
+
+	handleMsgSysCastBinary(sender, chatPacket(binpacket.ChatTypeParty, BroadcastTypeStage, "anyone?"))
+	if got := receivedChat(near, 0); len(got) != 1 {
+		t.Errorf("the sender's stage got party chat %d times, want once", len(got))
+	}
+	if got := receivedChat(far, 0); len(got) != 0 {
+		t.Error("party chat outside a party left the sender's stage")
+	}
+}
@@ -0,0 +1,224 @@
+package channelserver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// partyInvite is a quest party member's invite of a character standing anywhere else, holding a slot
+// of the party's stage until the character answers, logs off or the invite times out.
+type partyInvite struct {
+	stage  *Stage
+	host   *Session
+	target *Session
+	timer  *time.Timer
+}
+
+// slotsTakenLocked counts the stage's reserved slots along with those held for invited characters.
+// The caller holds the stage lock.
+func (stage *Stage) slotsTakenLocked() int {
+	return len(stage.reservedClientSlots) + len(stage.invites)
+}
+
+// dropInviteLocked frees the slot held for the invite, reporting whether it was still pending.
+// The caller holds the stage lock.
+func (stage *Stage) dropInviteLocked(invite *partyInvite) bool {
+	if stage.invites[invite.target.charID] != invite {
+		return false
+	}
+	delete(stage.invites, invite.target.charID)
+	invite.timer.Stop()
+	return true
+}
+
+// dropInvitesLocked frees every slot held for invites, used when the stage is removed.
+// The caller holds the stage lock.
+func (stage *Stage) dropInvitesLocked() {
+	for _, invite := range stage.invites {
+		stage.dropInviteLocked(invite)
+	}
+}
+
+// takeInvite clears the session's pending invite and returns it, nil if it had none.
+func takeInvite(s *Session) *partyInvite {
+	s.Lock()
+	defer s.Unlock()
+	invite := s.partyInvite
+	s.partyInvite = nil
+	return invite
+}
+
+// endInvite drops the invite, if it's still pending, and tells the host why.
+func endInvite(invite *partyInvite, message string) {
+	invite.stage.Lock()
+	pending := invite.stage.dropInviteLocked(invite)
+	var acks []reservationAck
+	if pending {
+		acks = invite.stage.grantQueuedReservationsLocked()
+	}
+	invite.stage.Unlock()
+	sendReservationAcks(acks)
+	invite.target.Lock()
+	if invite.target.partyInvite == invite {
+		invite.target.partyInvite = nil
+	}
+	invite.target.Unlock()
+	if pending {
+		sendServerChatMessage(invite.host, message)
+	}
+}
+
+// dropPartyInvite drops the invite the session was waiting to answer, as its character logs off.
+func dropPartyInvite(s *Session) {
+	if invite := takeInvite(s); invite != nil {
+		endInvite(invite, fmt.Sprintf("%s logged off, your invite was dropped.", s.Name))
+	}
+}
+
+// invitePartyMember invites the target into the session's quest party, holding a slot for them.
+// It returns why the invite wasn't sent, or "" once it was.
+func invitePartyMember(s *Session, target *Session) string {
+	stage := partyStage(s)
+	switch {
+	case stage == nil:
+		return "Only members of a quest party can invite."
+	case target == s:
+		return "You can't invite yourself."
+	case s.isBlocking(target.charID):
+		return fmt.Sprintf("You have blacklisted %s.", target.Name)
+	case partyStage(target) != nil:
+		return fmt.Sprintf("%s is already in a quest party.", target.Name)
+	}
+	// Being blacklisted looks the same as not taking invites, so the player isn't told.
+	unavailable := fmt.Sprintf("%s isn't taking invites right now.", target.Name)
+	if target.isBlocking(s.charID) || s.server.blockedFromStage(stage, target.charID) {
+		return unavailable
+	}
+
+	// The target is claimed first, so two invites at once don't both hold a slot for it.
+	timeout := time.Duration(s.server.erupeConfig.Channel.PartyInviteTimeout) * time.Second
+	invite := &partyInvite{stage: stage, host: s, target: target}
+	target.Lock()
+	if target.doNotDisturb || target.partyInvite != nil {
+		target.Unlock()
+		return unavailable
+	}
+	target.partyInvite = invite
+	target.Unlock()
+
+	stage.Lock()
+	reason := ""
+	switch {
+	case stage.destroyed:
+		reason = "Your party is gone."
+	case stage.hasDeparted:
+		reason = "Your party already departed."
+	case uint16(stage.slotsTakenLocked()) >= stage.maxPlayers:
+		reason = "Your party is full."
+	}
+	if reason != "" {
+		stage.Unlock()
+		takeInvite(target)
+		return reason
+	}
+	if stage.invites == nil {
+		stage.invites = make(map[uint32]*partyInvite)
+	}
+	stage.invites[target.charID] = invite
+	invite.timer = time.AfterFunc(timeout, func() {
+		endInvite(invite, fmt.Sprintf("%s didn't answer your invite in time.", target.Name))
+	})
+	stage.Unlock()
+
+	sendServerChatMessage(target, fmt.Sprintf("%s invites you to their quest party on %s, say \"!accept\" or \"!decline\" within %d seconds.",
+		s.Name, strings.TrimSpace(s.server.name), int(timeout.Seconds())))
+	s.logger.Info("Sent party invite", zap.Uint32("targetID", target.charID), zap.String("stageID", stage.id))
+	return ""
+}
+
+// acceptPartyInvite turns the slot held for the session into a reservation, kept for the timeout while the
+// player joins the party from the client, switching to the host's channel first if need be. The server has
+// no packet that makes a client change stages.
+func acceptPartyInvite(s *Session, invite *partyInvite) {
+	stage := invite.stage
+	stage.Lock()
+	if !stage.dropInviteLocked(invite) || stage.destroyed {
+		stage.Unlock()
+		sendServerChatMessage(s, "The party you were invited to is gone.")
+		return
+	}
+	stage.reservedClientSlots[s.charID] = nil
+	stage.Unlock()
+	sendServerChatMessage(invite.host, fmt.Sprintf("%s accepted your invite.", s.Name))
+
+	// The session that reserves on the host's channel takes the slot up.
+	timeout := time.Duration(s.server.erupeConfig.Channel.PartyInviteTimeout) * time.Second
+	host := invite.host.server
+	if host == s.server {
+		sendServerChatMessage(s, fmt.Sprintf("Join %s's quest within %d seconds, your slot is held until then.",
+			invite.host.Name, int(timeout.Seconds())))
+	} else {
+		sendServerChatMessage(s, fmt.Sprintf("Switch to %s and join %s's quest within %d seconds, your slot is held until then.",
+			strings.TrimSpace(host.name), invite.host.Name, int(timeout.Seconds())))
+	}
+	time.AfterFunc(timeout, func() {
+		if session := host.FindSessionByCharID(s.charID); session != nil && partyStage(session) == stage {
+			return
+		}
+		stage.Lock()
+		delete(stage.reservedClientSlots, s.charID)
+		acks := stage.grantQueuedReservationsLocked()
+		stage.Unlock()
+		sendReservationAcks(acks)
+	})
+}
+
+// handlePartyInviteCommand runs "!invite <player>", inviting a character on any channel into the
+// sender's quest party, "!accept" and "!decline", the answers of an invited character, and "!dnd",
+// which turns the invites a character gets off or back on.
+func handlePartyInviteCommand(s *Session, message string) {
+	args := strings.Fields(message)
+	switch args[0] {
+	case "!invite":
+		if len(args) < 2 {
+			sendServerChatMessage(s, "Usage: \"!invite <player>\"")
+			return
+		}
+		name := strings.Join(args[1:], " ")
+		target := s.server.findSessionByNameOnAnyChannel(name)
+		if target == nil {
+			sendServerChatMessage(s, fmt.Sprintf("No one named %s is online.", name))
+			return
+		}
+		if reason := invitePartyMember(s, target); reason != "" {
+			sendServerChatMessage(s, reason)
+			return
+		}
+		sendServerChatMessage(s, fmt.Sprintf("Invite sent to %s.", target.Name))
+	case "!accept", "!decline":
+		invite := takeInvite(s)
+		if invite == nil {
+			sendServerChatMessage(s, "You have no party invite to answer.")
+			return
+		}
+		if args[0] == "!accept" {
+			acceptPartyInvite(s, invite)
+			return
+		}
+		endInvite(invite, fmt.Sprintf("%s declined your invite.", s.Name))
+		sendServerChatMessage(s, "Invite declined.")
+	case "!dnd":
+		s.Lock()
+		s.doNotDisturb = !s.doNotDisturb
+		dnd := s.doNotDisturb
+		s.Unlock()
+		if dnd {
+			sendServerChatMessage(s, "You won't get party invites, say \"!dnd\" again to get them.")
+		} else {
+			sendServerChatMessage(s, "You get party invites again.")
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package channelserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+	"go.uber.org/zap"
+)
+
+// newStagedSession logs a character in and puts it in the stage, as if its client had entered it.
+func newStagedSession(t *testing.T, s *Server, charID uint32, name string, stageID string) *Session {
+	session := newReservingSession(t, s, charID)
+	session.Name = name
+	s.Lock()
+	s.sessions[session.rawConn] = session
+	s.Unlock()
+	transferToStage(session, stageID, nil)
+	return session
+}
+
+func inStage(s *Server, stageID string, session *Session) bool {
+	stage, ok := s.GetStage(stageID)
+	if !ok {
+		return false
+	}
+	stage.RLock()
+	defer stage.RUnlock()
+	_, in := stage.clients[session]
+	return in && session.stage == stage
+}
+
+// The party reserves a room rather than a quest, joining a quest would record its departure in the database.
+const inviteStageID = "sl1Ms000p0a0u0"
+
+// newInviteParty returns a host holding a slot in a quest of two and a friend standing in another stage.
+func newInviteParty(t *testing.T) (*Server, *Stage, *Session, *Session) {
+	s := newTestServer()
+	s.erupeConfig.Channel.PartyInviteTimeout = 60
+	quest, _ := s.CreateStage(inviteStageID, 2)
+	host := newStagedSession(t, s, 1, "Host", "sl1Ns200p0a0u0")
+	reserve(host, inviteStageID)
+	friend := newStagedSession(t, s, 2, "Friend", "sl1Ns211p0a0u0")
+	return s, quest, host, friend
+}
+
+func TestPartyInviteAccepted(t *testing.T) {
+	s, quest, host, friend := newInviteParty(t)
+	if reason := invitePartyMember(host, friend); reason != "" {
+		t.Fatalf("invite refused: %s", reason)
+	}
+
+	// The slot is held for the friend, a third player finds the quest full.
+	other := newReservingSession(t, s, 3)
+	reserve(other, inviteStageID)
+	if reservedStageOf(other) != nil {
+		t.Error("a player took the slot held for the invited friend")
+	}
+	cancelStageReservation(other)
+
+	handlePartyInviteCommand(friend, "!accept")
+	if !inStage(s, "sl1Ns211p0a0u0", friend) {
+		t.Error("the server moved the friend without their client")
+	}
+	if len(quest.invites) != 0 || len(quest.reservedClientSlots) != 2 {
+		t.Errorf("%d invites and %d reserved slots left, want none and 2", len(quest.invites), len(quest.reservedClientSlots))
+	}
+
+	// The friend's client joins the party and is granted the held slot.
+	reserve(friend, inviteStageID)
+	if reservedStageOf(friend) != quest {
+		t.Error("the friend couldn't take up the held slot")
+	}
+}
+
+func TestPartyInviteDeclinedOrDropped(t *testing.T) {
+	_, quest, host, friend := newInviteParty(t)
+	invitePartyMember(host, friend)
+	handlePartyInviteCommand(friend, "!decline")
+	if len(quest.invites) != 0 || friend.partyInvite != nil {
+		t.Error("the declined invite still holds a slot")
+	}
+	if reservedStageOf(friend) != nil {
+		t.Error("the friend joined the party after declining")
+	}
+
+	invitePartyMember(host, friend)
+	dropPartyInvite(friend)
+	if len(quest.invites) != 0 {
+		t.Error("the invite of a character who logged off still holds a slot")
+	}
+}
+
+func TestPartyInviteTimesOut(t *testing.T) {
+	s, quest, host, friend := newInviteParty(t)
+	s.erupeConfig.Channel.PartyInviteTimeout = 0
+	invitePartyMember(host, friend)
+	deadline := time.Now().Add(time.Second)
+	for {
+		quest.RLock()
+		pending := len(quest.invites)
+		quest.RUnlock()
+		if pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the invite didn't time out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	handlePartyInviteCommand(friend, "!accept")
+	if reservedStageOf(friend) != nil {
+		t.Error("a timed out invite was accepted")
+	}
+}
+
+func TestPartyInviteRespectsSettings(t *testing.T) {
+	_, quest, host, friend := newInviteParty(t)
+	handlePartyInviteCommand(friend, "!dnd")
+	if reason := invitePartyMember(host, friend); reason == "" {
+		t.Error("invited a character with invites turned off")
+	}
+	handlePartyInviteCommand(friend, "!dnd")
+
+	friend.blocked = map[uint32]bool{host.charID: true}
+	if reason := invitePartyMember(host, friend); reason == "" {
+		t.Error("invited a character who blacklisted the host")
+	}
+	friend.blocked = nil
+
+	host.blocked = map[uint32]bool{friend.charID: true}
+	if reason := invitePartyMember(host, friend); reason == "" {
+		t.Error("the host invited a character they blacklisted")
+	}
+	host.blocked = nil
+
+	if reason := invitePartyMember(friend, host); reason == "" {
+		t.Error("a character outside any quest party sent an invite")
+	}
+	if len(quest.invites) != 0 {
+		t.Errorf("%d refused invites hold slots", len(quest.invites))
+	}
+}
+
+func TestPartyInviteAcrossChannels(t *testing.T) {
+	registry := NewChannelRegistry()
+	var channels []*Server
+	for _, name := range []string{"World #1", "World #1 (2)"} {
+		channels = append(channels, NewServer(&Config{
+			Logger:      zap.NewNop(),
+			ErupeConfig: &config.Config{Channel: config.Channel{PartyInviteTimeout: 60}},
+			Name:        name,
+			Registry:    registry,
+		}))
+	}
+	quest, _ := channels[0].CreateStage(inviteStageID, 4)
+	host := newStagedSession(t, channels[0], 1, "Host", "sl1Ns200p0a0u0")
+	reserve(host, inviteStageID)
+	friend := newStagedSession(t, channels[1], 2, "Friend", "sl1Ns200p0a0u0")
+
+	handlePartyInviteCommand(host, "!invite friend")
+	if friend.partyInvite == nil {
+		t.Fatal("the invite didn't reach the friend on the other channel")
+	}
+	handlePartyInviteCommand(friend, "!accept")
+	if _, held := quest.reservedClientSlots[friend.charID]; !held {
+		t.Fatal("no slot is held for the friend to switch channels into")
+	}
+	if !inStage(channels[1], "sl1Ns200p0a0u0", friend) {
+		t.Error("the friend was moved on the channel they're leaving")
+	}
+
+	// Once on the host's channel, the friend's reservation is granted by the held slot.
+	switched := newStagedSession(t, channels[0], 2, "Friend", "sl1Ns200p0a0u0")
+	reserve(switched, inviteStageID)
+	if reservedStageOf(switched) != quest {
+		t.Error("the friend couldn't take up the held slot")
+	}
+}
@@ -0,0 +1,239 @@
+package channelserver
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/config"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// Errors buying from the point shop.
+var (
+	ErrPointShopNotInStock = errors.New("the item isn't in this rotation's stock")
+	ErrPointShopLimit      = errors.New("the purchase limit for this rotation is reached")
+	ErrNotEnoughPoints     = errors.New("not enough points")
+)
+
+// pointShopCurrencies maps the currency of a point shop item to the balance it's paid from.
+var pointShopCurrencies = map[string]Currency{
+	"festa":    CurrencyFesta,
+	"frontier": CurrencyFrontier,
+}
+
+// Item hashes of the point shop are its catalog indexes above this base, so a purchase can be told
+// apart from the exchange shops listed in normal_shop_items.
+const pointShopHashBase = 0x7E500000
+
+// PointShopRotationAt returns the point shop rotation running at the given time and when it ends.
+// Rotations are numbered from 1, rotation 0 means the shop is off or the first rotation hasn't started.
+func PointShopRotationAt(cfg config.PointShop, now time.Time) (rotation int, ends time.Time, err error) {
+	if !cfg.Enabled {
+		return 0, time.Time{}, nil
+	}
+	if cfg.RotationDays <= 0 {
+		return 0, time.Time{}, errors.New("point shop rotation must be at least a day")
+	}
+	return periodAt(cfg.Start, cfg.RotationDays, now)
+}
+
+// pointShopStock returns the catalog indexes on sale in the rotation, in catalog order.
+// The stock is drawn from the seed and the rotation alone, so every channel and restart agrees on it.
+func pointShopStock(cfg config.PointShop, rotation int) []int {
+	indexes := make([]int, len(cfg.Items))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	size := cfg.StockSize
+	if size <= 0 || size > len(indexes) {
+		size = len(indexes)
+	}
+	seed := cfg.Seed ^ rngValueAt(uint64(rotation), 0)
+	for i := 0; i < size; i++ {
+		j := i + int(rngValueAt(seed, uint64(i+1))%uint64(len(indexes)-i))
+		indexes[i], indexes[j] = indexes[j], indexes[i]
+	}
+	stock := indexes[:size]
+	sort.Ints(stock)
+	return stock
+}
+
+func inPointShopStock(stock []int, index int) bool {
+	for _, i := range stock {
+		if i == index {
+			return true
+		}
+	}
+	return false
+}
+
+// pointShopIndex returns the catalog index an item hash of the point shop stands for.
+func pointShopIndex(cfg config.PointShop, hash uint32) (int, bool) {
+	if hash < pointShopHashBase || hash-pointShopHashBase >= uint32(len(cfg.Items)) {
+		return 0, false
+	}
+	return int(hash - pointShopHashBase), true
+}
+
+// checkPointShopPurchase checks a purchase of count against the item's limit, given how many the character
+// already bought this rotation.
+func checkPointShopPurchase(item config.PointShopItem, bought uint32, count uint32) error {
+	if _, ok := pointShopCurrencies[item.Currency]; !ok {
+		return fmt.Errorf("unknown point shop currency %q", item.Currency)
+	}
+	if count == 0 {
+		return errors.New("nothing to buy")
+	}
+	if item.Limit > 0 && bought+count > uint32(item.Limit) {
+		return ErrPointShopLimit
+	}
+	return nil
+}
+
+// buildPointShopList writes the stock as a shop enumeration. Limited items carry the limit and what the
+// character bought this rotation, the client shows the difference as the purchases left.
+func buildPointShopList(cfg config.PointShop, stock []int, bought map[int]uint16) []byte {
+	bf := byteframe.NewByteFrame()
+	bf.WriteUint16(uint16(len(stock)))
+	bf.WriteUint16(uint16(len(stock)))
+	for _, index := range stock {
+		item := cfg.Items[index]
+		bf.WriteUint32(pointShopHashBase + uint32(index))
+		bf.WriteUint16(0)
+		bf.WriteUint16(item.ItemID)
+		bf.WriteUint16(0)
+		bf.WriteUint16(item.Price)
+		bf.WriteUint16(item.Quantity)
+		bf.WriteUint16(0) // Rank and store level requirements.
+		bf.WriteUint16(0)
+		bf.WriteUint16(0)
+		bf.WriteUint16(0)
+		bf.WriteUint16(item.Limit)
+		if item.Limit > 0 {
+			bf.WriteUint16(bought[index])
+		} else {
+			bf.WriteUint16(0)
+		}
+		bf.WriteUint16(0) // Road floors and weekly Fatalis kills required.
+		bf.WriteUint16(0)
+	}
+	return bf.Data()
+}
+
+// pointShopPurchases loads how many of each item the character bought in the rotation.
+func pointShopPurchases(db *sqlx.DB, charID uint32, rotation int) (map[int]uint16, error) {
+	rows, err := db.Query("SELECT item, bought FROM point_shop_purchases WHERE character_id = $1 AND rotation = $2", charID, rotation)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	bought := make(map[int]uint16)
+	for rows.Next() {
+		var item int
+		var count uint16
+		if err := rows.Scan(&item, &count); err != nil {
+			return nil, err
+		}
+		bought[item] = count
+	}
+	return bought, rows.Err()
+}
+
+// BuyPointShopItem counts the purchase against the rotation's limit and takes its price in the item's
+// currency from the character, both or neither.
+func BuyPointShopItem(db *sqlx.DB, charID uint32, rotation int, index int, item config.PointShopItem, count uint32) error {
+	if err := checkPointShopPurchase(item, 0, count); err != nil {
+		return err
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		INSERT INTO point_shop_purchases (character_id, rotation, item, bought) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (character_id, rotation, item) DO UPDATE SET bought = point_shop_purchases.bought + $4
+		WHERE $5 = 0 OR point_shop_purchases.bought + $4 <= $5
+	`, charID, rotation, index, count, item.Limit)
+	if err != nil {
+		return err
+	}
+	if rows, err := res.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return ErrPointShopLimit
+	}
+
+	cost := int64(item.Price) * int64(count)
+	if _, err := AddPoints(tx, charID, pointShopCurrencies[item.Currency], -cost, "point shop"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// currentPointShopRotation returns the running rotation by the event clock, logging an invalid schedule.
+func (s *Server) currentPointShopRotation() int {
+	rotation, _, err := PointShopRotationAt(s.erupeConfig.PointShop, Time_Current())
+	if err != nil {
+		s.logger.Error("Invalid point shop schedule", zap.Error(err))
+	}
+	return rotation
+}
+
+// isPointShop reports whether the client is enumerating the point shop.
+func (s *Server) isPointShop(shopType uint8, shopID uint32) bool {
+	cfg := s.erupeConfig.PointShop
+	return cfg.Enabled && cfg.ShopType == shopType && cfg.ShopID == shopID && !s.featureGated("pointshop")
+}
+
+// enumeratePointShop sends the running rotation's stock with the character's purchases.
+func enumeratePointShop(s *Session, ackHandle uint32) {
+	rotation := s.server.currentPointShopRotation()
+	if rotation == 0 {
+		doAckBufSucceed(s, ackHandle, make([]byte, 4))
+		return
+	}
+	bought, err := pointShopPurchases(s.server.db, s.charID, rotation)
+	if err != nil {
+		s.logger.Error("Failed to load point shop purchases", zap.Error(err))
+		doAckBufFail(s, ackHandle, make([]byte, 4))
+		return
+	}
+	cfg := s.server.erupeConfig.PointShop
+	doAckBufSucceed(s, ackHandle, buildPointShopList(cfg, pointShopStock(cfg, rotation), bought))
+}
+
+// buyPointShopItem answers a purchase from the point shop. The client adds the items itself once it's acknowledged.
+func buyPointShopItem(s *Session, ackHandle uint32, index int, count uint32) {
+	cfg := s.server.erupeConfig.PointShop
+	rotation := s.server.currentPointShopRotation()
+	err := ErrPointShopNotInStock
+	if rotation > 0 && inPointShopStock(pointShopStock(cfg, rotation), index) {
+		err = BuyPointShopItem(s.server.db, s.charID, rotation, index, cfg.Items[index], count)
+	}
+	if err != nil {
+		s.logger.Info("Refused point shop purchase", zap.Int("item", index), zap.Uint32("count", count), zap.Error(err))
+		doAckSimpleFail(s, ackHandle, make([]byte, 4))
+		return
+	}
+	doAckSimpleSucceed(s, ackHandle, make([]byte, 4))
+}
+
+// addFestaPoints credits the festa points for the souls the session's character charged, while the point
+// shop they're spent in is open.
+func addFestaPoints(s *Session, souls int) {
+	cfg := s.server.erupeConfig.PointShop
+	if !cfg.Enabled || s.server.featureGated("pointshop") {
+		return
+	}
+	points := souls * cfg.FestaSoulPoints
+	if points <= 0 {
+		return
+	}
+	addPoints(s, CurrencyFesta, int64(points), "festa souls")
+}
@@ -0,0 +1,174 @@
+package channelserver
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/config"
+)
+
+func testPointShop(items int) config.PointShop {
+	cfg := config.PointShop{Enabled: true, Start: "2022-06-06T00:00:00+09:00", RotationDays: 7, Seed: 42, StockSize: 3}
+	for i := 0; i < items; i++ {
+		cfg.Items = append(cfg.Items, config.PointShopItem{ItemID: uint16(100 + i), Quantity: 1, Price: 10, Currency: "festa", Limit: 2})
+	}
+	return cfg
+}
+
+func TestPointShopRotationAt(t *testing.T) {
+	cfg := testPointShop(0)
+	jst := time.FixedZone("UTC+9", 9*60*60)
+	start := time.Date(2022, 6, 6, 0, 0, 0, 0, jst)
+	tests := []struct {
+		name         string
+		now          time.Time
+		wantRotation int
+		wantEnds     time.Time
+	}{
+		{"before the first rotation", start.Add(-time.Second), 0, start},
+		{"first rotation", start, 1, start.AddDate(0, 0, 7)},
+		{"last moment before the rollover", start.AddDate(0, 0, 7).Add(-time.Nanosecond), 1, start.AddDate(0, 0, 7)},
+		{"rollover", start.AddDate(0, 0, 7), 2, start.AddDate(0, 0, 14)},
+		{"rollover seen from UTC", start.AddDate(0, 0, 7).UTC(), 2, start.AddDate(0, 0, 14)},
+	}
+	for _, tt := range tests {
+		rotation, ends, err := PointShopRotationAt(cfg, tt.now)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if rotation != tt.wantRotation || !ends.Equal(tt.wantEnds) {
+			t.Errorf("%s: got rotation %d ending %v, want %d ending %v", tt.name, rotation, ends, tt.wantRotation, tt.wantEnds)
+		}
+	}
+
+	cfg.Enabled = false
+	if rotation, _, err := PointShopRotationAt(cfg, start); err != nil || rotation != 0 {
+		t.Errorf("disabled point shop got rotation %d, %v", rotation, err)
+	}
+	if _, _, err := PointShopRotationAt(config.PointShop{Enabled: true, Start: cfg.Start}, start); err == nil {
+		t.Error("zero rotation length didn't fail")
+	}
+}
+
+func TestPointShopStock(t *testing.T) {
+	cfg := testPointShop(10)
+	first := pointShopStock(cfg, 1)
+	if len(first) != 3 {
+		t.Fatalf("got stock %v, want 3 items", first)
+	}
+	for i := 1; i < len(first); i++ {
+		if first[i-1] >= first[i] {
+			t.Fatalf("stock %v isn't distinct items in catalog order", first)
+		}
+	}
+	if again := pointShopStock(cfg, 1); !reflect.DeepEqual(again, first) {
+		t.Errorf("same rotation got stock %v, then %v", first, again)
+	}
+
+	changed := false
+	for rotation := 2; rotation <= 10; rotation++ {
+		if !reflect.DeepEqual(pointShopStock(cfg, rotation), first) {
+			changed = true
+		}
+	}
+	if !changed {
+		t.Error("stock never changed over nine rollovers")
+	}
+
+	other := cfg
+	other.Seed = 7
+	if reflect.DeepEqual(pointShopStock(other, 1), first) && reflect.DeepEqual(pointShopStock(other, 2), pointShopStock(cfg, 2)) {
+		t.Error("another seed got the same stock")
+	}
+
+	cfg.StockSize = 0
+	if got := pointShopStock(cfg, 1); len(got) != 10 {
+		t.Errorf("zero stock size got %v, want the whole catalog", got)
+	}
+}
+
+func TestPointShopIndex(t *testing.T) {
+	cfg := testPointShop(3)
+	if index, ok := pointShopIndex(cfg, pointShopHashBase+2); !ok || index != 2 {
+		t.Errorf("got index %d, %v, want 2", index, ok)
+	}
+	for _, hash := range []uint32{pointShopHashBase + 3, pointShopHashBase - 1, 0x3a9186fb} {
+		if _, ok := pointShopIndex(cfg, hash); ok {
+			t.Errorf("hash %#x taken for a point shop item", hash)
+		}
+	}
+}
+
+func TestCheckPointShopPurchase(t *testing.T) {
+	item := config.PointShopItem{ItemID: 100, Price: 10, Currency: "frontier", Limit: 3}
+	if err := checkPointShopPurchase(item, 1, 2); err != nil {
+		t.Errorf("purchase up to the limit: %v", err)
+	}
+	if err := checkPointShopPurchase(item, 2, 2); err != ErrPointShopLimit {
+		t.Errorf("purchase past the limit got %v", err)
+	}
+	if err := checkPointShopPurchase(item, 0, 0); err == nil {
+		t.Error("empty purchase didn't fail")
+	}
+	item.Limit = 0
+	if err := checkPointShopPurchase(item, 500, 99); err != nil {
+		t.Errorf("unlimited item: %v", err)
+	}
+	item.Currency = "zenny"
+	if err := checkPointShopPurchase(item, 0, 1); err == nil {
+		t.Error("unknown currency didn't fail")
+	}
+}
+
+func TestBuildPointShopList(t *testing.T) {
+	cfg := testPointShop(3)
+	cfg.Items[2].Limit = 0
+	data := buildPointShopList(cfg, []int{0, 2}, map[int]uint16{0: 1, 2: 5})
+
+	bf := byteframe.NewByteFrameFromBytes(data)
+	if count := bf.ReadUint16(); count != 2 || bf.ReadUint16() != 2 {
+		t.Fatalf("got %d entries, want 2", count)
+	}
+	type entry struct {
+		hash                  uint32
+		itemID, price, limit  uint16
+		bought, tradeQuantity uint16
+	}
+	var got []entry
+	for i := 0; i < 2; i++ {
+		var e entry
+		e.hash = bf.ReadUint32()
+		bf.ReadUint16()
+		e.itemID = bf.ReadUint16()
+		bf.ReadUint16()
+		e.price = bf.ReadUint16()
+		e.tradeQuantity = bf.ReadUint16()
+		bf.ReadBytes(8)
+		e.limit = bf.ReadUint16()
+		e.bought = bf.ReadUint16()
+		bf.ReadBytes(4)
+		got = append(got, e)
+	}
+	want := []entry{
+		{pointShopHashBase, 100, 10, 2, 1, 1},
+		{pointShopHashBase + 2, 102, 10, 0, 0, 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got entries %+v, want %+v", got, want)
+	}
+	if len(data) != 4+2*30 {
+		t.Errorf("got %d bytes, want %d", len(data), 4+2*30)
+	}
+}
+
+func TestFestaPointsNeedThePointShop(t *testing.T) {
+	s := newTestServer()
+	session, client := newTestSession(s)
+	t.Cleanup(func() { client.Close() })
+
+	// The server has no database, crediting the points would panic.
+	s.erupeConfig.PointShop = config.PointShop{FestaSoulPoints: 10}
+	addFestaPoints(session, 5)
+}
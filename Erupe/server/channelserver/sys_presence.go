@@ -0,0 +1,124 @@
+package channelserver
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// The characters logged in are recorded in online_characters, so the admin API and the other processes can
+// tell whether a session holds a character's data before editing it.
+const (
+	presenceHeartbeat = 30 * time.Second
+	// A row not refreshed for this long was left behind by a process that died, its character is offline.
+	presenceTimeout = 90 * time.Second
+)
+
+// ErrAccountOnline is returned by CheckAccountOffline when a character of the account is logged in.
+var ErrAccountOnline = errors.New("a character of the account is online")
+
+// markOnline records the session's character as online, before any of its data is loaded. The row of the
+// character's previous session is taken over, that session's logout leaves it be. Locking the character row
+// first makes the login wait for an edit checking the account offline to commit.
+func markOnline(s *Session) error {
+	key := fmt.Sprintf("%s-%d", s.server.instanceID, atomic.AddUint64(&s.server.presenceSeq, 1))
+	tx, err := s.server.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("SELECT id FROM characters WHERE id = $1 FOR KEY SHARE", s.charID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO online_characters (character_id, instance, session) VALUES ($1, $2, $3)
+		ON CONFLICT (character_id) DO UPDATE SET instance = $2, session = $3, seen_at = now()
+	`, s.charID, s.server.instanceID, key)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.Lock()
+	s.presenceKey = key
+	s.Unlock()
+	return nil
+}
+
+// markOffline drops the session's row once its logout save is written.
+func markOffline(s *Session) {
+	s.Lock()
+	key := s.presenceKey
+	s.Unlock()
+	if key == "" {
+		return
+	}
+	if _, err := s.server.db.Exec("DELETE FROM online_characters WHERE character_id = $1 AND session = $2", s.charID, key); err != nil {
+		s.logger.Error("Failed to mark the character offline", zap.Error(err))
+	}
+}
+
+// schedulePresenceHeartbeat refreshes the rows of the channel's sessions every presenceHeartbeat.
+func (s *Server) schedulePresenceHeartbeat() {
+	s.Lock()
+	defer s.Unlock()
+	if s.isShuttingDown {
+		return
+	}
+	s.presenceTimer = time.AfterFunc(presenceHeartbeat, func() {
+		if _, err := s.db.Exec("UPDATE online_characters SET seen_at = now() WHERE instance = $1", s.instanceID); err != nil {
+			s.logger.Error("Failed to refresh the online characters", zap.Error(err))
+		}
+		s.schedulePresenceHeartbeat()
+	})
+}
+
+// stopPresenceHeartbeat cancels the pending refresh. The sessions disconnected on shutdown drop their rows as
+// they log out, those that don't make it expire.
+func (s *Server) stopPresenceHeartbeat() {
+	s.Lock()
+	defer s.Unlock()
+	if s.presenceTimer != nil {
+		s.presenceTimer.Stop()
+	}
+}
+
+// CheckAccountOffline locks the characters of the account owning the character and returns ErrAccountOnline
+// when one of them is logged in to a channel of any process. The locks are held until the transaction ends,
+// so a login of the account waits for the caller's writes before loading anything.
+func CheckAccountOffline(tx *sql.Tx, charID uint32) error {
+	rows, err := tx.Query("SELECT id FROM characters WHERE user_id = (SELECT user_id FROM characters WHERE id = $1) FOR UPDATE", charID)
+	if err != nil {
+		return err
+	}
+	found := false
+	for rows.Next() {
+		found = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if !found {
+		return ErrCharacterNotFound
+	}
+	var online bool
+	err = tx.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM online_characters o JOIN characters c ON c.id = o.character_id
+			WHERE c.user_id = (SELECT user_id FROM characters WHERE id = $1) AND o.seen_at > now() - $2 * interval '1 second'
+		)
+	`, charID, presenceTimeout.Seconds()).Scan(&online)
+	if err != nil {
+		return err
+	}
+	if online {
+		return ErrAccountOnline
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package channelserver
+
+import (
+	"testing"
+
+	"github.com/Solenataris/Erupe/common/testdb"
+)
+
+// TestCheckAccountOffline runs against the database in ERUPE_TEST_DB, like TestClaimEventObject.
+func TestCheckAccountOffline(t *testing.T) {
+	db := testdb.Open(t)
+
+	var userID uint32
+	if err := db.QueryRow("INSERT INTO users (username, password) VALUES ('presence_test', '') RETURNING id").Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+	var chars [2]uint32
+	for i := range chars {
+		err := db.QueryRow("INSERT INTO characters (user_id, is_new_character, name) VALUES ($1, false, 'presence') RETURNING id", userID).Scan(&chars[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Exec("DELETE FROM characters WHERE id = $1", chars[i])
+	}
+	check := func(charID uint32) error {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+		return CheckAccountOffline(tx, charID)
+	}
+
+	s := newTestServer()
+	s.db = db
+	s.instanceID = "presence-test"
+	session := newQueuedSession(t, s, chars[0], "presence")
+	if err := check(chars[1]); err != nil {
+		t.Errorf("an offline account got %v", err)
+	}
+	if err := markOnline(session); err != nil {
+		t.Fatal(err)
+	}
+	if err := check(chars[1]); err != ErrAccountOnline {
+		t.Errorf("an account with a character online got %v, want %v", err, ErrAccountOnline)
+	}
+
+	// A row past the timeout was left by a process that died.
+	db.Exec("UPDATE online_characters SET seen_at = now() - interval '1 hour' WHERE character_id = $1", chars[0])
+	if err := check(chars[1]); err != nil {
+		t.Errorf("a stale row got %v", err)
+	}
+
+	// A newer session keeps its row when the one it replaced logs out.
+	newer := newQueuedSession(t, s, chars[0], "presence")
+	if err := markOnline(newer); err != nil {
+		t.Fatal(err)
+	}
+	markOffline(session)
+	if err := check(chars[0]); err != ErrAccountOnline {
+		t.Errorf("the older session's logout got %v, want %v", err, ErrAccountOnline)
+	}
+	markOffline(newer)
+	if err := check(chars[0]); err != nil {
+		t.Errorf("after the logout got %v", err)
+	}
+	if err := check(0); err != ErrCharacterNotFound {
+		t.Errorf("an unknown character got %v, want %v", err, ErrCharacterNotFound)
+	}
+}
@@ -0,0 +1,171 @@
+package channelserver
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// How often quest instances past the max age are looked for.
+const questReapInterval = time.Minute
+
+// Quest ID the instances whose members haven't loaded a quest file yet are counted under.
+const unknownQuestID = "unknown"
+
+// questInstanceState is a copy of what the metrics and the reaper look at of a quest instance.
+type questInstanceState struct {
+	stage   *Stage
+	questID string
+	age     time.Duration
+}
+
+// noteQuestInstance records the quest file loaded by the session on the quest instance it holds a
+// slot in, the first file loaded naming the instance's quest.
+func noteQuestInstance(s *Session, filename string) {
+	s.Lock()
+	stage := s.reservationStage
+	s.Unlock()
+	if stage == nil {
+		return
+	}
+	stage.Lock()
+	if stage.questID == "" {
+		stage.questID = filename
+	}
+	stage.Unlock()
+}
+
+// questInstances returns the quest stages that someone is in or holds a slot in.
+func (s *Server) questInstances(now time.Time) []questInstanceState {
+	var instances []questInstanceState
+	for _, stage := range s.stageList() {
+		if !isQuestStageID(stage.id) {
+			continue
+		}
+		stage.RLock()
+		open := len(stage.clients) > 0 || len(stage.reservedClientSlots) > 0
+		questID := stage.questID
+		created := stage.created
+		stage.RUnlock()
+		if !open {
+			continue
+		}
+		if questID == "" {
+			questID = unknownQuestID
+		}
+		instances = append(instances, questInstanceState{stage, questID, now.Sub(created)})
+	}
+	return instances
+}
+
+// writeQuestInstanceMetrics writes the open quest instances by quest and how many of them are older
+// than the stuck age, in the Prometheus text exposition format.
+func (s *Server) writeQuestInstanceMetrics(w io.Writer, channel string, now time.Time) {
+	stuckAge := time.Duration(s.erupeConfig.Channel.QuestStuckAge) * time.Second
+	byQuest := make(map[string]int)
+	var stuck int
+	for _, instance := range s.questInstances(now) {
+		byQuest[instance.questID]++
+		if stuckAge > 0 && instance.age >= stuckAge {
+			stuck++
+		}
+	}
+	quests := make([]string, 0, len(byQuest))
+	for questID := range byQuest {
+		quests = append(quests, questID)
+	}
+	sort.Strings(quests)
+	for _, questID := range quests {
+		fmt.Fprintf(w, "erupe_channel_quest_instances{channel=%s,quest=%s} %d\n", channel, strconv.Quote(questID), byQuest[questID])
+	}
+	fmt.Fprintf(w, "erupe_channel_quest_instances_stuck{channel=%s} %d\n", channel, stuck)
+}
+
+// reapQuestInstances ends the quest instances older than the max age, such as those whose host crashed
+// on the result screen, and returns how many it ended.
+func (s *Server) reapQuestInstances(now time.Time) int {
+	maxAge := time.Duration(s.erupeConfig.Channel.QuestMaxAge) * time.Second
+	if maxAge <= 0 {
+		return 0
+	}
+	var reaped int
+	for _, instance := range s.questInstances(now) {
+		if instance.age >= maxAge {
+			s.reapQuestInstance(instance)
+			reaped++
+		}
+	}
+	return reaped
+}
+
+// reapQuestInstance ends the quest instance without its rewards. The members inside are disconnected, no
+// packet making a client leave its quest stage for town is known, and signing in again puts them there.
+// Those still waiting to depart lose their slots. The stage is removed.
+func (s *Server) reapQuestInstance(instance questInstanceState) {
+	stage := instance.stage
+
+	s.Lock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.Unlock()
+
+	var affected []uint32
+	for _, session := range sessions {
+		session.Lock()
+		inside := session.stage == stage
+		member := inside || session.reservationStage == stage
+		if member {
+			// The quest isn't counted as done, so returning to town earns nothing for it.
+			session.questFile = ""
+		}
+		session.Unlock()
+		if !member {
+			continue
+		}
+		affected = append(affected, session.charID)
+		if inside {
+			session.closeWith(DisconnectQuestTimeout)
+		} else {
+			sendServerChatMessage(session, "Your quest ran for too long and was ended, no rewards were given.")
+			cancelStageReservation(session)
+		}
+	}
+	s.RemoveStage(stage.id)
+	s.logger.Warn("Ended quest instance past its max age",
+		zap.String("stageID", stage.id),
+		zap.String("quest", instance.questID),
+		zap.Duration("age", instance.age),
+		zap.Uint32s("charIDs", affected),
+	)
+}
+
+// scheduleQuestReap looks for quest instances past the max age every questReapInterval.
+func (s *Server) scheduleQuestReap() {
+	if s.erupeConfig.Channel.QuestMaxAge <= 0 {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.isShuttingDown {
+		return
+	}
+	s.questReapTimer = time.AfterFunc(questReapInterval, func() {
+		s.reapQuestInstances(time.Now())
+		s.scheduleQuestReap()
+	})
+}
+
+// stopQuestReap cancels the pending quest instance reap.
+func (s *Server) stopQuestReap() {
+	s.Lock()
+	defer s.Unlock()
+	if s.questReapTimer != nil {
+		s.questReapTimer.Stop()
+	}
+}
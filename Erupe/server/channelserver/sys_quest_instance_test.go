@@ -0,0 +1,89 @@
+package channelserver
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// placeInQuest puts the session in the quest stage with a slot, as if it had departed with the party.
+func placeInQuest(s *Server, quest *Stage, charID uint32, name string, t *testing.T) *Session {
+	session := newReservingSession(t, s, charID)
+	session.Name = name
+	s.Lock()
+	s.sessions[session.rawConn] = session
+	s.Unlock()
+	quest.Lock()
+	quest.addClient(session)
+	quest.reservedClientSlots[charID] = nil
+	quest.Unlock()
+	session.stage = quest
+	session.stageID = quest.id
+	session.reservationStage = quest
+	return session
+}
+
+func TestQuestInstanceMetrics(t *testing.T) {
+	s := newTestServer()
+	s.erupeConfig.Channel.QuestStuckAge = 3600
+	now := time.Now()
+	old, _ := s.CreateStage("sl1Qs000p0a0u0", 4)
+	fresh, _ := s.CreateStage("sl1Qs001p0a0u0", 4)
+	s.CreateStage("sl1Qs002p0a0u0", 4) // Empty, not counted.
+	old.created = now.Add(-2 * time.Hour)
+	placeInQuest(s, old, 1, "Host", t)
+	noteQuestInstance(placeInQuest(s, fresh, 2, "Other", t), "23045d0")
+
+	var metrics strings.Builder
+	s.writeQuestInstanceMetrics(&metrics, `"1"`, now)
+	for _, want := range []string{
+		`erupe_channel_quest_instances{channel="1",quest="23045d0"} 1`,
+		`erupe_channel_quest_instances{channel="1",quest="unknown"} 1`,
+		`erupe_channel_quest_instances_stuck{channel="1"} 1`,
+	} {
+		if !strings.Contains(metrics.String(), want) {
+			t.Errorf("metrics %q don't contain %q", metrics.String(), want)
+		}
+	}
+}
+
+func TestReapQuestInstances(t *testing.T) {
+	s := newTestServer()
+	s.erupeConfig.Channel.QuestMaxAge = 3600
+	now := time.Now()
+	stuck, _ := s.CreateStage("sl1Qs000p0a0u0", 4)
+	stuck.created = now.Add(-2 * time.Hour)
+	host := placeInQuest(s, stuck, 1, "Host", t)
+	host.questFile = "23045d0"
+	// A member still in town with a slot only loses the slot.
+	waiting := newStagedSession(t, s, 2, "Waiting", "sl1Ns200p0a0u0")
+	stuck.reservedClientSlots[waiting.charID] = nil
+	waiting.reservationStage = stuck
+	fresh, _ := s.CreateStage("sl1Qs001p0a0u0", 4)
+	placeInQuest(s, fresh, 3, "Fresh", t)
+
+	if n := s.reapQuestInstances(now); n != 1 {
+		t.Fatalf("reaped %d instances, want 1", n)
+	}
+	if _, ok := s.GetStage(stuck.id); ok {
+		t.Error("the stuck instance is still there")
+	}
+	if _, ok := s.GetStage(fresh.id); !ok {
+		t.Error("the fresh instance was reaped")
+	}
+	if sent, closed := revokedWith(host); !closed || !strings.Contains(sent, "Sign in again to return to town") {
+		t.Errorf("the host inside was told %q, disconnected %v", sent, closed)
+	}
+	if host.questFile != "" || waiting.reservationStage != nil {
+		t.Error("the members still count as on the quest")
+	}
+	if !inStage(s, MezeportaStageId, waiting) {
+		t.Error("the waiting member was moved")
+	}
+
+	s.erupeConfig.Channel.QuestMaxAge = 0
+	fresh.created = now.Add(-48 * time.Hour)
+	if n := s.reapQuestInstances(now); n != 0 {
+		t.Errorf("reaped %d instances with reaping disabled", n)
+	}
+}
@@ -0,0 +1,113 @@
+package channelserver
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/Solenataris/Erupe/config"
+	"go.uber.org/zap"
+)
+
+// The quest binary starts with a table of pointers to its sections, unused sections are zero.
+const (
+	questHeaderPointers = 8
+	questHeaderSize     = questHeaderPointers * 4
+)
+
+// validateQuestBinary checks the quest header is intact and every section pointer lands inside the file.
+func validateQuestBinary(data []byte) error {
+	if len(data) < questHeaderSize {
+		return fmt.Errorf("quest binary is %d bytes, shorter than its header", len(data))
+	}
+	for i := 0; i < questHeaderPointers; i++ {
+		ptr := binary.LittleEndian.Uint32(data[i*4:])
+		if ptr != 0 && (ptr < questHeaderSize || ptr >= uint32(len(data))) {
+			return fmt.Errorf("quest header pointer %d (0x%X) is outside the file", i, ptr)
+		}
+	}
+	return nil
+}
+
+// scaleQuestBinary returns a copy of the quest binary with the scaled fields set for the party size.
+func scaleQuestBinary(data []byte, scaling config.QuestScaling, partySize int) ([]byte, error) {
+	if partySize < 1 {
+		partySize = 1
+	} else if partySize > 4 {
+		partySize = 4
+	}
+
+	scaled := make([]byte, len(data))
+	copy(scaled, data)
+	for _, field := range scaling.Fields {
+		if field.Offset < questHeaderSize || field.Offset+field.Size > len(scaled) {
+			return nil, fmt.Errorf("scaled field at 0x%X is outside the quest body", field.Offset)
+		}
+		percent := uint64(field.Percent[partySize-1])
+		b := scaled[field.Offset:]
+		switch field.Size {
+		case 1:
+			b[0] = uint8(clampScaled(uint64(b[0])*percent/100, 0xFF))
+		case 2:
+			binary.LittleEndian.PutUint16(b, uint16(clampScaled(uint64(binary.LittleEndian.Uint16(b))*percent/100, 0xFFFF)))
+		case 4:
+			binary.LittleEndian.PutUint32(b, uint32(clampScaled(uint64(binary.LittleEndian.Uint32(b))*percent/100, 0xFFFFFFFF)))
+		default:
+			return nil, fmt.Errorf("scaled field at 0x%X has unsupported size %d", field.Offset, field.Size)
+		}
+	}
+	return scaled, validateQuestBinary(scaled)
+}
+
+func clampScaled(value uint64, max uint64) uint64 {
+	if value > max {
+		return max
+	}
+	return value
+}
+
+// questScalingFor returns the scaling config of the quest file, if it has one.
+func (s *Server) questScalingFor(filename string) (config.QuestScaling, bool) {
+	for _, scaling := range s.erupeConfig.Current().QuestScaling {
+		if scaling.Quest == filename {
+			return scaling, true
+		}
+	}
+	return config.QuestScaling{}, false
+}
+
+// scaledQuestFile scales the quest binary by the number of members confirmed in the session's
+// quest instance. The patched copy is kept on the instance so every member gets the same one.
+// If the patched binary doesn't validate, the original is sent instead.
+func scaledQuestFile(s *Session, filename string, data []byte) []byte {
+	scaling, ok := s.server.questScalingFor(filename)
+	if !ok {
+		return data
+	}
+
+	s.Lock()
+	stage := s.reservationStage
+	s.Unlock()
+
+	partySize := 1
+	if stage != nil {
+		stage.Lock()
+		defer stage.Unlock()
+		if cached, ok := stage.questFiles[filename]; ok {
+			return cached
+		}
+		partySize = len(stage.reservedClientSlots)
+	}
+
+	scaled, err := scaleQuestBinary(data, scaling, partySize)
+	if err != nil {
+		s.logger.Error("Failed to scale quest, sending it unscaled", zap.Error(err), zap.String("quest", filename))
+		scaled = data
+	}
+	if stage != nil {
+		if stage.questFiles == nil {
+			stage.questFiles = make(map[string][]byte)
+		}
+		stage.questFiles[filename] = scaled
+	}
+	return scaled
+}
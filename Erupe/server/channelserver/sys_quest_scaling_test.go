@@ -0,0 +1,95 @@
+package channelserver
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/Solenataris/Erupe/config"
+)
+
+// newTestQuest builds a quest binary with one section and a 16 and 32 bit monster HP in its body.
+func newTestQuest() []byte {
+	data := make([]byte, questHeaderSize+0x10)
+	binary.LittleEndian.PutUint32(data[0:], questHeaderSize)
+	binary.LittleEndian.PutUint16(data[questHeaderSize:], 4000)
+	binary.LittleEndian.PutUint32(data[questHeaderSize+4:], 100000)
+	return data
+}
+
+var testQuestScaling = config.QuestScaling{
+	Quest: "23045d0",
+	Fields: []config.QuestScalingField{
+		{Offset: questHeaderSize, Size: 2, Percent: [4]int{35, 60, 80, 100}},
+		{Offset: questHeaderSize + 4, Size: 4, Percent: [4]int{40, 65, 85, 100}},
+	},
+}
+
+func TestScaleQuestBinaryByPartySize(t *testing.T) {
+	tests := []struct {
+		partySize int
+		want16    uint16
+		want32    uint32
+	}{
+		{1, 1400, 40000},
+		{2, 2400, 65000},
+		{3, 3200, 85000},
+		{4, 4000, 100000},
+	}
+	data := newTestQuest()
+	for _, tt := range tests {
+		scaled, err := scaleQuestBinary(data, testQuestScaling, tt.partySize)
+		if err != nil {
+			t.Fatalf("party of %d: %v", tt.partySize, err)
+		}
+		if err = validateQuestBinary(scaled); err != nil {
+			t.Errorf("party of %d: scaled quest doesn't validate: %v", tt.partySize, err)
+		}
+		got16 := binary.LittleEndian.Uint16(scaled[questHeaderSize:])
+		got32 := binary.LittleEndian.Uint32(scaled[questHeaderSize+4:])
+		if got16 != tt.want16 || got32 != tt.want32 {
+			t.Errorf("party of %d: got HP %d and %d, want %d and %d", tt.partySize, got16, got32, tt.want16, tt.want32)
+		}
+	}
+	if binary.LittleEndian.Uint16(data[questHeaderSize:]) != 4000 {
+		t.Error("scaling modified the original quest binary")
+	}
+}
+
+func TestScaleQuestBinaryRejectsHeaderFields(t *testing.T) {
+	scaling := config.QuestScaling{Fields: []config.QuestScalingField{{Offset: 0, Size: 4, Percent: [4]int{50, 50, 50, 50}}}}
+	if _, err := scaleQuestBinary(newTestQuest(), scaling, 1); err == nil {
+		t.Error("scaling a header pointer succeeded")
+	}
+}
+
+func TestScaledQuestFileSharedByInstance(t *testing.T) {
+	s := newTestServer()
+	s.erupeConfig.QuestScaling = []config.QuestScaling{testQuestScaling}
+	stage, _ := s.CreateStage("sl1Qs000p0a0u0", 4)
+	stage.reservedClientSlots[1] = nil
+	stage.reservedClientSlots[2] = nil
+
+	host, client := newTestSession(s)
+	go io.Copy(ioutil.Discard, client)
+	t.Cleanup(func() { client.Close() })
+	host.reservationStage = stage
+
+	scaled := scaledQuestFile(host, "23045d0", newTestQuest())
+	if got := binary.LittleEndian.Uint16(scaled[questHeaderSize:]); got != 2400 {
+		t.Errorf("got HP %d for a party of 2, want 2400", got)
+	}
+
+	// A member joining late still gets the copy the instance started with.
+	stage.reservedClientSlots[3] = nil
+	again := scaledQuestFile(host, "23045d0", newTestQuest())
+	if got := binary.LittleEndian.Uint16(again[questHeaderSize:]); got != 2400 {
+		t.Errorf("got HP %d on the second request, want the instance's 2400", got)
+	}
+
+	unscaled := newTestQuest()
+	if got := scaledQuestFile(host, "21731d0", unscaled); &got[0] != &unscaled[0] {
+		t.Error("quest without scaling config was copied")
+	}
+}
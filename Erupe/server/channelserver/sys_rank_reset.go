@@ -0,0 +1,137 @@
+package channelserver
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/server/channelserver/compression/nullcomp"
+	"go.uber.org/zap"
+)
+
+// Where the HRP sits in the decompressed savedata.
+const saveDataHRPOffset = 130550 // 0x1FDF6
+
+// Where the G rank points sit in the decompressed savedata, 0 before G rank.
+const saveDataGRPOffset = 130556 // 0x1FDFC
+
+var errSaveDataRanks = errors.New("savedata too short to hold the ranks")
+
+// SaveDataRanks reads the HRP and G rank points out of the compressed savedata.
+func SaveDataRanks(compressed []byte) (uint16, uint32, error) {
+	data, err := nullcomp.Decompress(compressed)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(data) < saveDataGRPOffset+4 {
+		return 0, 0, errSaveDataRanks
+	}
+	hrp, grp := savedataRank(data)
+	return hrp, grp, nil
+}
+
+// SetSaveDataRanks returns the compressed savedata with its HRP and G rank points replaced, as a rank reset
+// writes them, and the G rank for the gr column. The hrp and gr columns alone aren't enough, they're read back
+// from the savedata on every save.
+func SetSaveDataRanks(compressed []byte, hrp uint16, grp uint32) ([]byte, uint16, error) {
+	data, err := nullcomp.Decompress(compressed)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(data) < saveDataGRPOffset+4 {
+		return nil, 0, errSaveDataRanks
+	}
+	binary.LittleEndian.PutUint16(data[saveDataHRPOffset:], hrp)
+	binary.LittleEndian.PutUint32(data[saveDataGRPOffset:], grp)
+	var gr uint16
+	if grp > 0 {
+		gr = grpToGR(grp)
+	}
+	patched, err := nullcomp.Compress(data)
+	return patched, gr, err
+}
+
+// protectResetRank keeps a client that was logged in while a rank reset ran, or was reverted,
+// from saving the ranks it still holds over the ones the reset wrote. HRP and G rank points earned since are kept.
+func protectResetRank(s *Session, save *CharacterSaveData) {
+	s.Lock()
+	loginTime := s.loginTime
+	s.Unlock()
+	if loginTime.IsZero() || len(save.baseSaveData) < saveDataGRPOffset+4 {
+		return
+	}
+
+	var hrp, newHRP uint16
+	var grp, newGRP uint32
+	var appliedAt time.Time
+	var revertedAt sql.NullTime
+	err := s.server.db.QueryRow(`
+		SELECT snap.hrp, snap.new_hrp, snap.grp, snap.new_grp, r.applied_at, r.reverted_at FROM rank_reset_snapshots snap
+		JOIN rank_resets r ON r.name = snap.reset
+		WHERE snap.character_id = $1 AND (r.applied_at > $2 OR r.reverted_at > $2)
+		ORDER BY GREATEST(r.applied_at, r.reverted_at) DESC LIMIT 1
+	`, s.charID, loginTime).Scan(&hrp, &newHRP, &grp, &newGRP, &appliedAt, &revertedAt)
+	if err == sql.ErrNoRows {
+		return
+	} else if err != nil {
+		s.logger.Error("Failed to check for rank resets", zap.Error(err))
+		return
+	}
+
+	// The client holds the rank from before whichever of the reset and the revert happened while it was logged in.
+	heldHRP, wantHRP, heldGRP, wantGRP := hrp, newHRP, grp, newGRP
+	if appliedAt.Before(loginTime) {
+		heldHRP, wantHRP, heldGRP, wantGRP = newHRP, hrp, newGRP, grp
+	} else if revertedAt.Valid {
+		return
+	}
+	if saved := binary.LittleEndian.Uint16(save.baseSaveData[saveDataHRPOffset:]); saved >= heldHRP {
+		binary.LittleEndian.PutUint16(save.baseSaveData[saveDataHRPOffset:], wantHRP+(saved-heldHRP))
+	}
+	if saved := binary.LittleEndian.Uint32(save.baseSaveData[saveDataGRPOffset:]); grp != newGRP && saved >= heldGRP {
+		binary.LittleEndian.PutUint32(save.baseSaveData[saveDataGRPOffset:], wantGRP+(saved-heldGRP))
+	}
+}
+
+// applyCatchUpBoosts adds the courses of the rank resets still in their catch-up window for the character.
+func applyCatchUpBoosts(s *Session, charID uint32, rights uint32) uint32 {
+	resets := s.server.erupeConfig.Maintenance.RankResets
+	if len(resets) == 0 {
+		return rights
+	}
+	rows, err := s.server.db.Query(`
+		SELECT r.name, r.applied_at FROM rank_reset_snapshots snap JOIN rank_resets r ON r.name = snap.reset
+		WHERE snap.character_id = $1 AND snap.catch_up AND NOT snap.reverted AND r.reverted_at IS NULL
+	`, charID)
+	if err != nil {
+		s.logger.Error("Failed to get catch-up boosts", zap.Error(err))
+		return rights
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var appliedAt time.Time
+		if err := rows.Scan(&name, &appliedAt); err != nil {
+			s.logger.Error("Failed to read catch-up boost", zap.Error(err))
+			return rights
+		}
+		rights |= catchUpRights(resets, name, appliedAt, time.Now())
+	}
+	return rights
+}
+
+// catchUpRights returns the courses the named reset grants while its catch-up lasts.
+func catchUpRights(resets []config.RankReset, name string, appliedAt time.Time, now time.Time) uint32 {
+	var rights uint32
+	for _, reset := range resets {
+		if reset.Name != name || !now.Before(appliedAt.Add(time.Duration(reset.CatchUpDays)*24*time.Hour)) {
+			continue
+		}
+		for _, course := range reset.CatchUpCourses {
+			rights |= 1 << course
+		}
+	}
+	return rights
+}
@@ -0,0 +1,60 @@
+package channelserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/server/channelserver/compression/nullcomp"
+)
+
+func TestSetSaveDataRanks(t *testing.T) {
+	raw := make([]byte, saveDataHRPOffset+64)
+	raw[0] = 0xAA
+	compressed, err := nullcomp.Compress(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched, gr, err := SetSaveDataRanks(compressed, 321, 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gr != grpToGR(5000) {
+		t.Errorf("got G rank %d, want %d", gr, grpToGR(5000))
+	}
+	if hrp, grp, err := SaveDataRanks(patched); err != nil || hrp != 321 || grp != 5000 {
+		t.Errorf("savedata holds HRP %d and GRP %d (%v), want 321 and 5000", hrp, grp, err)
+	}
+	data, err := nullcomp.Decompress(patched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != 0xAA || len(data) != len(raw) {
+		t.Error("patching the ranks changed the rest of the savedata")
+	}
+	if _, gr, _ := SetSaveDataRanks(compressed, 321, 0); gr != 0 {
+		t.Errorf("no G rank points got G rank %d", gr)
+	}
+
+	short, _ := nullcomp.Compress(make([]byte, 100))
+	if _, _, err := SetSaveDataRanks(short, 321, 0); err == nil {
+		t.Error("patched savedata too short to hold the ranks")
+	}
+}
+
+func TestCatchUpRights(t *testing.T) {
+	appliedAt := time.Date(2022, 6, 1, 4, 0, 0, 0, time.UTC)
+	resets := []config.RankReset{
+		{Name: "s1", CatchUpDays: 14, CatchUpCourses: []uint8{6, 10}},
+		{Name: "s2", CatchUpDays: 14, CatchUpCourses: []uint8{11}},
+	}
+	if got := catchUpRights(resets, "s1", appliedAt, appliedAt.AddDate(0, 0, 13)); got != 1<<6|1<<10 {
+		t.Errorf("got rights %#x during the catch-up", got)
+	}
+	if got := catchUpRights(resets, "s1", appliedAt, appliedAt.AddDate(0, 0, 14)); got != 0 {
+		t.Errorf("got rights %#x after the catch-up ended", got)
+	}
+	if got := catchUpRights(resets, "removed", appliedAt, appliedAt); got != 0 {
+		t.Errorf("got rights %#x from a reset no longer configured", got)
+	}
+}
@@ -0,0 +1,90 @@
+package channelserver
+
+import (
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// tokenBucket is a rate limit filling at a steady rate up to a burst, one token spent per event.
+// It holds no allocations, so checking it on every packet costs nothing but the arithmetic.
+type tokenBucket struct {
+	tokens float64
+	last   int64 // Unix nanoseconds of the last take, 0 for a bucket not used yet, which starts full.
+}
+
+// take refills the bucket at rate tokens a second up to burst and spends a token, reporting false when
+// there was none left. A burst lowered since the last take caps what the bucket holds.
+func (b *tokenBucket) take(now int64, rate float64, burst float64) bool {
+	if b.last == 0 {
+		b.tokens = burst
+	} else if elapsed := now - b.last; elapsed > 0 {
+		b.tokens += float64(elapsed) / float64(time.Second) * rate
+	}
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// allowPacket spends a token of the session's packet limit for a packet the client sent, reporting
+// false once the client sent more than its burst past the rate. The login burst applies until the
+// character's first stage entry, while the client is logging in and downloading its savedata.
+// The limit is the session's: once past it, nothing more the client sends is let through, whatever
+// the bucket refilled to. Only the recv loop calls it.
+func (s *Session) allowPacket(now time.Time) bool {
+	if s.flooded() {
+		return false
+	}
+	cfg := s.server.erupeConfig.Current().Channel
+	if cfg.PacketRate <= 0 {
+		return true
+	}
+	burst := cfg.LoginPacketBurst
+	if atomic.LoadInt32(&s.inWorld) != 0 {
+		burst = cfg.PacketBurst
+	}
+	return s.packetBucket.take(now.UnixNano(), float64(cfg.PacketRate), float64(burst))
+}
+
+// enteredWorld ends the login burst of the session's packet limit.
+func (s *Session) enteredWorld() {
+	atomic.StoreInt32(&s.inWorld, 1)
+}
+
+// allowChat spends a token of the session's chat limit for a message the player sent, reporting false
+// when it's to be dropped. The player is warned once a flood starts, and again after a message went
+// through since.
+func (s *Session) allowChat(now time.Time) bool {
+	cfg := s.server.erupeConfig.Current().Channel
+	if cfg.ChatRate <= 0 {
+		return true
+	}
+	s.Lock()
+	allowed := s.chatBucket.take(now.UnixNano(), float64(cfg.ChatRate)/60, float64(cfg.ChatBurst))
+	warn := !allowed && !s.chatFloodWarned
+	s.chatFloodWarned = !allowed
+	s.Unlock()
+	if warn {
+		s.logger.Info("Dropping chat flood")
+		sendServerChatMessage(s, "You're sending messages too fast, they won't be sent for a moment.")
+	}
+	return allowed
+}
+
+// flooded reports whether the session was dropped for sending packets past its limit.
+func (s *Session) flooded() bool {
+	return DisconnectReason(atomic.LoadInt32(&s.disconnectReason)) == DisconnectPacketFlood
+}
+
+// dropFlood disconnects a client that sent packets past its limit.
+func (s *Session) dropFlood() {
+	s.logger.Warn("Disconnecting session flooding packets", zap.Int("rate", s.server.erupeConfig.Current().Channel.PacketRate))
+	s.closeWith(DisconnectPacketFlood)
+}
@@ -0,0 +1,89 @@
+package channelserver
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/network"
+)
+
+func TestTokenBucket(t *testing.T) {
+	var b tokenBucket
+	now := time.Now().UnixNano()
+	for i := 0; i < 3; i++ {
+		if !b.take(now, 2, 3) {
+			t.Fatalf("take %d of a full bucket of 3 failed", i+1)
+		}
+	}
+	if b.take(now, 2, 3) {
+		t.Fatal("took from an empty bucket")
+	}
+	if !b.take(now+int64(500*time.Millisecond), 2, 3) {
+		t.Error("half a second at 2 a second didn't refill a token")
+	}
+	if !b.take(now+int64(time.Hour), 2, 3) || !b.take(now+int64(time.Hour), 2, 3) || !b.take(now+int64(time.Hour), 2, 3) {
+		t.Error("an idle bucket didn't refill to its burst")
+	}
+	if b.take(now+int64(time.Hour), 2, 3) {
+		t.Error("the bucket refilled past its burst")
+	}
+}
+
+func TestPacketFloodDisconnects(t *testing.T) {
+	s := newTestServer()
+	s.erupeConfig.Channel.PacketRate = 1
+	s.erupeConfig.Channel.PacketBurst = 5
+	s.erupeConfig.Channel.LoginPacketBurst = 20
+	session := newQueuedSession(t, s, 1, "Flooder")
+	nop := []byte{0x00, byte(network.MSG_SYS_NOP)}
+
+	if allocs := testing.AllocsPerRun(100, func() { session.allowPacket(time.Now()) }); allocs != 0 {
+		t.Errorf("checking the packet limit allocated %v times", allocs)
+	}
+	session.packetBucket = tokenBucket{}
+
+	// The login burst covers what a client sends while it loads in.
+	for i := 0; i < 10; i++ {
+		session.handlePacketGroup(nop)
+	}
+	if reason := atomic.LoadInt32(&session.disconnectReason); reason != 0 {
+		t.Fatalf("disconnected with %v during the login burst", DisconnectReason(reason))
+	}
+
+	session.enteredWorld()
+	for i := 0; i < 10; i++ {
+		session.handlePacketGroup(nop)
+	}
+	if reason := DisconnectReason(atomic.LoadInt32(&session.disconnectReason)); reason != DisconnectPacketFlood {
+		t.Errorf("flooding session closed with %v, want %v", reason, DisconnectPacketFlood)
+	}
+	// The session stays over its limit once the bucket refilled, and in later frames.
+	if session.allowPacket(time.Now().Add(time.Hour)) {
+		t.Error("a flooding session was let through after the bucket refilled")
+	}
+}
+
+func TestChatFloodDrops(t *testing.T) {
+	s := newTestServer()
+	s.erupeConfig.Channel.ChatRate = 6
+	s.erupeConfig.Channel.ChatBurst = 2
+	sender := newQueuedSession(t, s, 1, "Sender")
+	listener := newQueuedSession(t, s, 2, "Listener")
+	moveToStage(t, sender, "sl1Ns200p0a0u0")
+	moveToStage(t, listener, "sl1Ns200p0a0u0")
+
+	for i := 0; i < 5; i++ {
+		handleMsgSysCastBinary(sender, chatPacket(0, BroadcastTypeStage, "spam"))
+	}
+	if n := bytes.Count(sentPackets(listener), []byte("spam")); n != 2 {
+		t.Errorf("%d messages got through, want the burst of 2", n)
+	}
+	if n := bytes.Count(sentPackets(sender), []byte("too fast")); n != 1 {
+		t.Errorf("the sender was warned %d times, want once", n)
+	}
+	if reason := atomic.LoadInt32(&sender.disconnectReason); reason != 0 {
+		t.Errorf("chat flood disconnected the sender with %v", DisconnectReason(reason))
+	}
+}
@@ -0,0 +1,307 @@
+package channelserver
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/config"
+	"go.uber.org/zap"
+)
+
+// RavienteCycleAt returns the Raviente cycle running at the given time and when it ends.
+// Cycles are numbered from 1, cycle 0 means carrying progress over is off or the first cycle hasn't started.
+func RavienteCycleAt(cfg config.RavienteCycle, now time.Time) (cycle int, ends time.Time, err error) {
+	if !cfg.Enabled {
+		return 0, time.Time{}, nil
+	}
+	if cfg.CycleDays <= 0 {
+		return 0, time.Time{}, errors.New("raviente cycle length must be at least a day")
+	}
+	return periodAt(cfg.Start, cfg.CycleDays, now)
+}
+
+// encodeRavienteRegister packs the register values in the order handleMsgSysLoadRegister sends them.
+func encodeRavienteRegister(r *RavienteRegister) []byte {
+	bf := byteframe.NewByteFrame()
+	for _, v := range []uint32{r.nextTime, r.startTime, r.killedTime, r.postTime, r.ravienteType, r.maxPlayers, r.carveQuest} {
+		bf.WriteUint32(v)
+	}
+	for _, v := range r.register {
+		bf.WriteUint32(v)
+	}
+	return bf.Data()
+}
+
+func encodeUint32s(values []uint32) []byte {
+	bf := byteframe.NewByteFrame()
+	for _, v := range values {
+		bf.WriteUint32(v)
+	}
+	return bf.Data()
+}
+
+func decodeUint32s(data []byte, count int) ([]uint32, error) {
+	if len(data) != count*4 {
+		return nil, fmt.Errorf("got %d bytes, want %d values", len(data), count)
+	}
+	bf := byteframe.NewByteFrameFromBytes(data)
+	values := make([]uint32, count)
+	for i := range values {
+		values[i] = bf.ReadUint32()
+	}
+	return values, nil
+}
+
+// restoreRaviente puts saved progress back into the Raviente. The caller holds the Raviente lock.
+func restoreRaviente(r *Raviente, register []byte, state []byte, support []byte) error {
+	registerValues, err := decodeUint32s(register, 7+len(r.register.register))
+	if err != nil {
+		return err
+	}
+	stateValues, err := decodeUint32s(state, 1+len(r.state.stateData))
+	if err != nil {
+		return err
+	}
+	supportValues, err := decodeUint32s(support, len(r.support.supportData))
+	if err != nil {
+		return err
+	}
+	reg := r.register
+	reg.nextTime, reg.startTime, reg.killedTime, reg.postTime = registerValues[0], registerValues[1], registerValues[2], registerValues[3]
+	reg.ravienteType, reg.maxPlayers, reg.carveQuest = registerValues[4], registerValues[5], registerValues[6]
+	copy(reg.register, registerValues[7:])
+	r.state.damageMultiplier = stateValues[0]
+	copy(r.state.stateData, stateValues[1:])
+	copy(r.support.supportData, supportValues)
+	return nil
+}
+
+// ravienteChannel keys the channel's rows of Raviente progress. A world's first channel uses the world's name,
+// as the rows always have, the others add their number so each channel keeps its own Raviente.
+func (s *Server) ravienteChannel() string {
+	if s.index == 0 {
+		return s.name
+	}
+	return fmt.Sprintf("%s#%d", s.name, s.index+1)
+}
+
+// loadRavienteCycleLocked loads the running cycle's saved progress the first time the Raviente is used
+// in a window. The caller holds the Raviente lock.
+func (s *Server) loadRavienteCycleLocked() {
+	cycle, _, err := RavienteCycleAt(s.erupeConfig.RavienteCycle, time.Now())
+	if err != nil || cycle == 0 || s.raviente.cycle == cycle {
+		return
+	}
+	s.raviente.cycle = cycle
+
+	var register, state, support []byte
+	err = s.db.QueryRow("SELECT register, state, support FROM raviente_cycles WHERE channel = $1 AND cycle = $2", s.ravienteChannel(), cycle).Scan(&register, &state, &support)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err == nil {
+		err = restoreRaviente(s.raviente, register, state, support)
+	}
+	if err != nil {
+		s.logger.Error("Failed to load Raviente progress, starting it over", zap.Int("cycle", cycle), zap.Error(err))
+		resetRavi(s)
+		s.raviente.cycle = cycle
+		return
+	}
+
+	rows, err := s.db.Query("SELECT character_id, damage FROM raviente_contributions WHERE channel = $1 AND cycle = $2", s.ravienteChannel(), cycle)
+	if err != nil {
+		s.logger.Error("Failed to load Raviente contributions", zap.Int("cycle", cycle), zap.Error(err))
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var charID, damage uint32
+		if err := rows.Scan(&charID, &damage); err != nil {
+			s.logger.Error("Failed to read Raviente contribution", zap.Error(err))
+			continue
+		}
+		s.raviente.contributions[charID] = damage
+	}
+	s.logger.Info("Carried the Raviente over", zap.Int("cycle", cycle), zap.Int("contributors", len(s.raviente.contributions)))
+}
+
+// saveRavienteCycleLocked saves the Raviente's progress and contributions for the cycle they belong to.
+// The caller holds the Raviente lock.
+func (s *Server) saveRavienteCycleLocked() error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO raviente_cycles (channel, cycle, register, state, support) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (channel, cycle) DO UPDATE SET register = $3, state = $4, support = $5, saved_at = now()
+	`, s.ravienteChannel(), s.raviente.cycle, encodeRavienteRegister(s.raviente.register),
+		encodeUint32s(append([]uint32{s.raviente.state.damageMultiplier}, s.raviente.state.stateData...)),
+		encodeUint32s(s.raviente.support.supportData))
+	if err != nil {
+		return err
+	}
+	for charID, damage := range s.raviente.contributions {
+		_, err = tx.Exec(`
+			INSERT INTO raviente_contributions (channel, cycle, character_id, damage) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (channel, cycle, character_id) DO UPDATE SET damage = $4
+		`, s.ravienteChannel(), s.raviente.cycle, charID, damage)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// endRavienteWindow resets the Raviente once its window closes. Within a cycle its progress is saved
+// to carry on in the next window, unless it died, in which case the cycle's rewards are sent out.
+// The caller holds the Raviente lock.
+func endRavienteWindow(s *Server) {
+	if cycle := s.raviente.cycle; cycle != 0 {
+		killed := s.raviente.register.killedTime != 0
+		if err := s.saveRavienteCycleLocked(); err != nil {
+			s.logger.Error("Failed to save Raviente progress", zap.Int("cycle", cycle), zap.Error(err))
+		} else if killed {
+			if err := s.finalizeRavienteCycle(cycle); err != nil {
+				s.logger.Error("Failed to finalize Raviente cycle", zap.Int("cycle", cycle), zap.Error(err))
+			}
+		}
+	}
+	resetRavi(s)
+}
+
+// finalizeRavienteCycle sends the rewards for the cycle to everyone who dealt at least MinContribution
+// and clears its progress. It's safe to run more than once, by then there's no progress left to reward.
+func (s *Server) finalizeRavienteCycle(cycle int) error {
+	cfg := s.erupeConfig.RavienteCycle
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM raviente_cycles WHERE channel = $1 AND cycle = $2", s.ravienteChannel(), cycle)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+
+	var contributors []uint32
+	err = tx.Select(&contributors, "DELETE FROM raviente_contributions WHERE channel = $1 AND cycle = $2 AND damage >= $3 RETURNING character_id", s.ravienteChannel(), cycle, cfg.MinContribution)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM raviente_contributions WHERE channel = $1 AND cycle = $2", s.ravienteChannel(), cycle); err != nil {
+		return err
+	}
+	if len(cfg.Rewards) > 0 {
+		items := make([]DistItemEntry, len(cfg.Rewards))
+		for i, item := range cfg.Rewards {
+			items[i] = DistItemEntry{item.ItemType, item.ItemID, item.Quantity}
+		}
+		for _, charID := range contributors {
+			err = createCharacterDistribution(tx, charID, fmt.Sprintf("Raviente Cycle %d Reward", cycle), "~C05Thanks for fighting the Raviente", items)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.logger.Info("Finalized Raviente cycle", zap.Int("cycle", cycle), zap.Int("rewarded", len(contributors)))
+	return nil
+}
+
+// finalizeEndedRavienteCycles finalizes the cycles before the given one, such as those that ended while the channel was down.
+func (s *Server) finalizeEndedRavienteCycles(current int) {
+	var cycles []int
+	err := s.db.Select(&cycles, "SELECT cycle FROM raviente_cycles WHERE channel = $1 AND cycle < $2", s.ravienteChannel(), current)
+	if err != nil {
+		s.logger.Error("Failed to list ended Raviente cycles", zap.Error(err))
+		return
+	}
+	for _, cycle := range cycles {
+		if err := s.finalizeRavienteCycle(cycle); err != nil {
+			s.logger.Error("Failed to finalize Raviente cycle", zap.Int("cycle", cycle), zap.Error(err))
+		}
+	}
+}
+
+// scheduleRavienteCycleEnd finalizes each cycle as it ends, along with any that ended before the channel started.
+func (s *Server) scheduleRavienteCycleEnd() {
+	cycle, ends, err := RavienteCycleAt(s.erupeConfig.RavienteCycle, time.Now())
+	if err != nil {
+		s.logger.Error("Invalid Raviente cycle schedule", zap.Error(err))
+		return
+	}
+	if ends.IsZero() {
+		return
+	}
+	if cycle > 0 {
+		s.finalizeEndedRavienteCycles(cycle)
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.isShuttingDown {
+		return
+	}
+	s.ravienteCycleTimer = time.AfterFunc(time.Until(ends), func() {
+		s.endRavienteCycle(cycle)
+		s.scheduleRavienteCycleEnd()
+	})
+}
+
+// stopRavienteCycleEnd cancels the pending cycle end.
+func (s *Server) stopRavienteCycleEnd() {
+	s.Lock()
+	defer s.Unlock()
+	if s.ravienteCycleTimer != nil {
+		s.ravienteCycleTimer.Stop()
+	}
+}
+
+// endRavienteCycle saves a fight still running in the ended cycle so its contributors are rewarded,
+// and starts the Raviente over for the next cycle.
+func (s *Server) endRavienteCycle(cycle int) {
+	s.raviente.Lock()
+	if s.raviente.cycle == cycle {
+		if err := s.saveRavienteCycleLocked(); err != nil {
+			s.logger.Error("Failed to save Raviente progress", zap.Int("cycle", cycle), zap.Error(err))
+		}
+		resetRavi(s)
+	}
+	s.raviente.Unlock()
+	if cycle > 0 {
+		if err := s.finalizeRavienteCycle(cycle); err != nil {
+			s.logger.Error("Failed to finalize Raviente cycle", zap.Int("cycle", cycle), zap.Error(err))
+		}
+	}
+}
+
+// ravienteCycleProgress returns how many characters have dealt how much damage to the Raviente in the cycle.
+func (s *Server) ravienteCycleProgress(cycle int) (int, uint64, error) {
+	s.raviente.Lock()
+	if s.raviente.cycle == cycle {
+		defer s.raviente.Unlock()
+		var damage uint64
+		for _, dealt := range s.raviente.contributions {
+			damage += uint64(dealt)
+		}
+		return len(s.raviente.contributions), damage, nil
+	}
+	s.raviente.Unlock()
+
+	var contributors int
+	var damage uint64
+	err := s.db.QueryRow("SELECT COUNT(*), COALESCE(SUM(damage), 0) FROM raviente_contributions WHERE channel = $1 AND cycle = $2", s.ravienteChannel(), cycle).Scan(&contributors, &damage)
+	return contributors, damage, err
+}
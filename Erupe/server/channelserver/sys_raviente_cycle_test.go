@@ -0,0 +1,125 @@
+package channelserver
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/common/testdb"
+	"github.com/Solenataris/Erupe/config"
+	"go.uber.org/zap"
+)
+
+func TestRavienteCycleAt(t *testing.T) {
+	cfg := config.RavienteCycle{Enabled: true, Start: "2022-06-06T00:00:00Z", CycleDays: 7}
+	start := time.Date(2022, 6, 6, 0, 0, 0, 0, time.UTC)
+	if cycle, ends, err := RavienteCycleAt(cfg, start.AddDate(0, 0, 10)); err != nil || cycle != 2 || !ends.Equal(start.AddDate(0, 0, 14)) {
+		t.Errorf("got cycle %d ending %v (%v), want cycle 2 ending %v", cycle, ends, err, start.AddDate(0, 0, 14))
+	}
+	if cycle, _, err := RavienteCycleAt(config.RavienteCycle{Start: cfg.Start, CycleDays: 7}, start); err != nil || cycle != 0 {
+		t.Errorf("disabled carry-over got cycle %d, %v", cycle, err)
+	}
+	if _, _, err := RavienteCycleAt(config.RavienteCycle{Enabled: true, Start: cfg.Start}, start); err == nil {
+		t.Error("zero cycle length didn't fail")
+	}
+}
+
+func TestRestoreRaviente(t *testing.T) {
+	saved := NewRaviente()
+	saved.register.startTime = 1000
+	saved.register.killedTime = 2000
+	saved.register.carveQuest = 7
+	saved.register.register[4] = 44
+	saved.state.damageMultiplier = 3
+	saved.state.stateData[28] = 1
+	saved.state.stateData[0] = 123456
+	saved.support.supportData[24] = 9
+
+	restored := NewRaviente()
+	err := restoreRaviente(restored, encodeRavienteRegister(saved.register),
+		encodeUint32s(append([]uint32{saved.state.damageMultiplier}, saved.state.stateData...)),
+		encodeUint32s(saved.support.supportData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored.register, saved.register) || !reflect.DeepEqual(restored.state, saved.state) || !reflect.DeepEqual(restored.support, saved.support) {
+		t.Errorf("got register %+v and state %+v, want %+v and %+v", restored.register, restored.state, saved.register, saved.state)
+	}
+
+	if err := restoreRaviente(NewRaviente(), []byte{1, 2, 3}, nil, nil); err == nil {
+		t.Error("truncated register didn't fail")
+	}
+}
+
+// TestRavienteCarryOver runs against the database in ERUPE_TEST_DB, e.g.
+// "host=localhost user=postgres password=admin dbname=erupe_test sslmode=disable".
+func TestRavienteCarryOver(t *testing.T) {
+	db := testdb.Open(t)
+
+	var userID, charID uint32
+	err := db.QueryRow("INSERT INTO users (username, password) VALUES ('ravi_test', '') RETURNING id").Scan(&userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+	err = db.QueryRow("INSERT INTO characters (user_id, is_new_character, name) VALUES ($1, false, 'ravi_test') RETURNING id", userID).Scan(&charID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM characters WHERE id = $1", charID)
+	defer db.Exec("DELETE FROM distribution WHERE character_id = $1", charID)
+	defer db.Exec("DELETE FROM raviente_contributions WHERE channel = 'ravi_test'")
+	defer db.Exec("DELETE FROM raviente_cycles WHERE channel = 'ravi_test'")
+
+	cfg := &config.Config{RavienteCycle: config.RavienteCycle{
+		Enabled:         true,
+		Start:           time.Now().Add(-time.Hour).Format(time.RFC3339),
+		CycleDays:       7,
+		MinContribution: 100,
+		Rewards:         []config.StarterItem{{ItemType: 7, ItemID: 100, Quantity: 1}},
+	}}
+	s := NewServer(&Config{Logger: zap.NewNop(), DB: db, ErupeConfig: cfg, Name: "ravi_test"})
+
+	// First window: damage is dealt, then everyone leaves.
+	s.raviente.Lock()
+	s.loadRavienteCycleLocked()
+	s.raviente.state.stateData[0] = 5000
+	s.raviente.contributions[charID] = 5000
+	endRavienteWindow(s)
+	if s.raviente.state.stateData[0] != 0 {
+		t.Fatal("Raviente wasn't reset in memory when its window closed")
+	}
+
+	// The next window picks up where the last left off.
+	s.loadRavienteCycleLocked()
+	if s.raviente.state.stateData[0] != 5000 || s.raviente.contributions[charID] != 5000 {
+		t.Fatalf("got state %d and contribution %d, want both carried over", s.raviente.state.stateData[0], s.raviente.contributions[charID])
+	}
+	s.raviente.Unlock()
+	if contributors, damage, err := s.ravienteCycleProgress(1); err != nil || contributors != 1 || damage != 5000 {
+		t.Errorf("got progress (%d, %d, %v), want (1, 5000, nil)", contributors, damage, err)
+	}
+
+	// The Raviente dies and the rewards go out, finalizing the cycle again doesn't send them twice.
+	s.raviente.Lock()
+	s.raviente.register.killedTime = 1
+	endRavienteWindow(s)
+	s.raviente.Unlock()
+	if err := s.finalizeRavienteCycle(1); err != nil {
+		t.Fatal(err)
+	}
+	var rewards int
+	if err := db.QueryRow("SELECT COUNT(*) FROM distribution WHERE character_id = $1", charID).Scan(&rewards); err != nil {
+		t.Fatal(err)
+	}
+	if rewards != 1 {
+		t.Errorf("got %d reward distributions, want exactly 1", rewards)
+	}
+	s.raviente.Lock()
+	s.loadRavienteCycleLocked()
+	fresh := s.raviente.state.stateData[0] == 0 && len(s.raviente.contributions) == 0
+	s.raviente.Unlock()
+	if !fresh {
+		t.Error("progress of a finalized cycle was carried over")
+	}
+}
@@ -0,0 +1,168 @@
+package channelserver
+
+import (
+	"time"
+
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+)
+
+// readyCheck holds back the host's departure, the ack of its MsgSysLockStage, until every member
+// with a reserved slot has confirmed they're ready.
+type readyCheck struct {
+	host      *Session
+	ackHandle uint32
+	pending   map[uint32]bool // Members yet to confirm.
+	timer     *time.Timer
+}
+
+// readyReservation is the value of a reserved slot whose member sent MsgSysReserveStage again, which the client
+// does when the member readies up. A check started afterwards doesn't wait for them. Any path reserving a slot
+// anew stores nil, so a member who left and came back is waited for again.
+type readyReservation struct{}
+
+// finishReadyCheckLocked stops the check and answers the host, departing or not.
+// The caller holds the stage lock.
+func (stage *Stage) finishReadyCheckLocked(departed bool) {
+	check := stage.readyCheck
+	stage.readyCheck = nil
+	check.timer.Stop()
+	if departed {
+		doAckSimpleSucceed(check.host, check.ackHandle, []byte{0x00, 0x00, 0x00, 0x00})
+	} else {
+		doAckSimpleFail(check.host, check.ackHandle, []byte{0x00, 0x00, 0x00, 0x00})
+	}
+}
+
+// startReadyCheck starts a check of the stage's reserved members for the host's departure and
+// returns the members to prompt. Members who readied up before the departure aren't waited for, with no one
+// to wait for it returns nothing and the host departs straight away.
+// A check the host started earlier is replaced, its departure answered as failed.
+func (stage *Stage) startReadyCheck(host *Session, ackHandle uint32, timeout time.Duration, kick bool) []uint32 {
+	stage.Lock()
+	defer stage.Unlock()
+	if stage.readyCheck != nil {
+		stage.finishReadyCheckLocked(false)
+	}
+	if stage.destroyed {
+		return nil
+	}
+
+	check := &readyCheck{host: host, ackHandle: ackHandle, pending: make(map[uint32]bool)}
+	members := make([]uint32, 0, len(stage.reservedClientSlots))
+	for charID, slot := range stage.reservedClientSlots {
+		if _, ready := slot.(readyReservation); charID != host.charID && !ready {
+			check.pending[charID] = true
+			members = append(members, charID)
+		}
+	}
+	if len(members) == 0 {
+		return nil
+	}
+	check.timer = time.AfterFunc(timeout, func() { expireReadyCheck(host.server, stage, check, kick) })
+	stage.readyCheck = check
+	return members
+}
+
+// confirmReady marks the member as ready, the host departs once the last one is.
+func (stage *Stage) confirmReady(charID uint32) bool {
+	stage.Lock()
+	defer stage.Unlock()
+	return stage.dropFromReadyCheckLocked(charID)
+}
+
+// dropFromReadyCheckLocked stops waiting for the member, reporting whether the check was waiting for them.
+// The caller holds the stage lock.
+func (stage *Stage) dropFromReadyCheckLocked(charID uint32) bool {
+	check := stage.readyCheck
+	if check == nil || !check.pending[charID] {
+		return false
+	}
+	delete(check.pending, charID)
+	if len(check.pending) == 0 {
+		stage.finishReadyCheckLocked(true)
+	}
+	return true
+}
+
+// cancelReadyCheck calls off the departure because the member isn't ready and returns the host to tell,
+// or nil if no check was waiting for the member.
+func (stage *Stage) cancelReadyCheck(charID uint32) *Session {
+	stage.Lock()
+	defer stage.Unlock()
+	check := stage.readyCheck
+	if check == nil || !check.pending[charID] {
+		return nil
+	}
+	stage.finishReadyCheckLocked(false)
+	return check.host
+}
+
+// endReadyCheckOfHostLocked drops the host's check without answering it, used when the host disconnects.
+// The caller holds the stage lock.
+func (stage *Stage) endReadyCheckOfHostLocked(host *Session) {
+	if stage.readyCheck != nil && stage.readyCheck.host == host {
+		stage.readyCheck.timer.Stop()
+		stage.readyCheck = nil
+	}
+}
+
+// expireReadyCheck lets the host depart once the timeout passes. With kick, the members who didn't
+// confirm lose their reserved slots rather than loading into the quest half way through a menu.
+func expireReadyCheck(server *Server, stage *Stage, check *readyCheck, kick bool) {
+	stage.Lock()
+	if stage.readyCheck != check {
+		stage.Unlock()
+		return
+	}
+	kicked := make([]uint32, 0, len(check.pending))
+	if kick {
+		for charID := range check.pending {
+			delete(stage.reservedClientSlots, charID)
+			kicked = append(kicked, charID)
+		}
+	}
+	stage.finishReadyCheckLocked(true)
+	stage.Unlock()
+
+	for _, charID := range kicked {
+		session := server.FindSessionByCharID(charID)
+		if session == nil {
+			continue
+		}
+		session.Lock()
+		if session.reservationStage == stage {
+			session.reservationStage = nil
+		}
+		session.Unlock()
+		session.QueueSendMHF(&mhfpacket.MsgSysStageDestruct{})
+		sendServerChatMessage(session, "Your party departed without you, you didn't ready up in time.")
+	}
+}
+
+// reservedStageOf returns the stage the session holds a reserved slot in.
+func reservedStageOf(s *Session) *Stage {
+	s.Lock()
+	defer s.Unlock()
+	return s.reservationStage
+}
+
+// handleReadyCommand runs "!ready" and "!notready", a member's answer to the host's ready check.
+func handleReadyCommand(s *Session, ready bool) {
+	stage := reservedStageOf(s)
+	if stage == nil {
+		sendServerChatMessage(s, "You aren't in a party waiting to depart.")
+		return
+	}
+	if ready {
+		if !stage.confirmReady(s.charID) {
+			sendServerChatMessage(s, "Your party isn't waiting for you to ready up.")
+		}
+		return
+	}
+	host := stage.cancelReadyCheck(s.charID)
+	if host == nil {
+		sendServerChatMessage(s, "Your party isn't waiting for you to ready up.")
+		return
+	}
+	sendServerChatMessage(host, "A party member isn't ready, the departure was called off.")
+}
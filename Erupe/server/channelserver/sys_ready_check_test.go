@@ -0,0 +1,186 @@
+package channelserver
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+)
+
+const readyCheckStageID = "sl1Qs000p0a0u0"
+
+// newReadyCheckHost returns a host without a send loop, so its departure ack can be read from the queue.
+func newReadyCheckHost(t *testing.T, s *Server) *Session {
+	serverConn, clientConn := net.Pipe()
+	t.Cleanup(func() { clientConn.Close() })
+	host := NewSession(s, serverConn)
+	host.charID = 1
+	return host
+}
+
+// newReadyCheckParty reserves the quest stage for the host and members, with the members standing in the lobby.
+func newReadyCheckParty(t *testing.T, timeout int, kick bool, memberIDs ...uint32) (*Server, *Stage, *Session, []*Session) {
+	s := newTestServer()
+	s.erupeConfig.Channel.ReadyCheckTimeout = timeout
+	s.erupeConfig.Channel.ReadyCheckKick = kick
+	stage, _ := s.CreateStage(readyCheckStageID, 4)
+	lobby, _ := s.GetOrCreateStage("sl1Ns200p0a0u0")
+
+	host := newReadyCheckHost(t, s)
+	reserve(host, readyCheckStageID)
+	members := make([]*Session, 0, len(memberIDs))
+	for _, charID := range memberIDs {
+		member := newReservingSession(t, s, charID)
+		lobby.Lock()
+		lobby.addClient(member)
+		lobby.Unlock()
+		reserve(member, readyCheckStageID)
+		members = append(members, member)
+	}
+	drainAcks(host)
+	return s, stage, host, members
+}
+
+func drainAcks(host *Session) {
+	for {
+		select {
+		case <-host.sendPackets:
+		default:
+			return
+		}
+	}
+}
+
+// departureAck waits for the host's ack of the given handle and reports whether it succeeded.
+func departureAck(t *testing.T, host *Session, ackHandle uint32, wait time.Duration) (succeeded bool, answered bool) {
+	t.Helper()
+	deadline := time.After(wait)
+	for {
+		select {
+		case data := <-host.sendPackets:
+			if len(data) < 8 || network.PacketID(binary.BigEndian.Uint16(data)) != network.MSG_SYS_ACK {
+				continue
+			}
+			if binary.BigEndian.Uint32(data[2:]) == ackHandle {
+				return data[7] == 0, true
+			}
+		case <-deadline:
+			return false, false
+		}
+	}
+}
+
+func depart(host *Session, ackHandle uint32) {
+	handleMsgSysLockStage(host, &mhfpacket.MsgSysLockStage{AckHandle: ackHandle, StageID: readyCheckStageID})
+}
+
+func TestReadyCheckDepartsOnceAllConfirm(t *testing.T) {
+	_, stage, host, members := newReadyCheckParty(t, 30, false, 2, 3)
+
+	depart(host, 10)
+	if _, answered := departureAck(t, host, 10, 50*time.Millisecond); answered {
+		t.Fatal("host departed before anyone readied up")
+	}
+
+	handleReadyCommand(members[0], true)
+	if _, answered := departureAck(t, host, 10, 50*time.Millisecond); answered {
+		t.Fatal("host departed with a member still in a menu")
+	}
+	// Readying up again through the reservation counts as well.
+	reserve(members[1], readyCheckStageID)
+	if succeeded, answered := departureAck(t, host, 10, time.Second); !answered || !succeeded {
+		t.Fatalf("got departure answered %v, succeeded %v, want a departure", answered, succeeded)
+	}
+	if stage.readyCheck != nil {
+		t.Error("ready check still running after the departure")
+	}
+}
+
+func TestReadyCheckSkipsReadyMembers(t *testing.T) {
+	_, _, host, members := newReadyCheckParty(t, 30, false, 2, 3)
+
+	// Both members readied up in the lobby before the host departed.
+	for _, member := range members {
+		reserve(member, readyCheckStageID)
+	}
+	drainAcks(host)
+	depart(host, 10)
+	if succeeded, answered := departureAck(t, host, 10, time.Second); !answered || !succeeded {
+		t.Fatalf("got departure answered %v, succeeded %v, want an immediate departure", answered, succeeded)
+	}
+}
+
+func TestReadyCheckCancelledByNotReady(t *testing.T) {
+	_, stage, host, members := newReadyCheckParty(t, 30, false, 2)
+
+	depart(host, 10)
+	handleReadyCommand(members[0], false)
+	if succeeded, answered := departureAck(t, host, 10, time.Second); !answered || succeeded {
+		t.Fatalf("got departure answered %v, succeeded %v, want it called off", answered, succeeded)
+	}
+	stage.RLock()
+	_, reserved := stage.reservedClientSlots[members[0].charID]
+	stage.RUnlock()
+	if !reserved {
+		t.Error("member who wasn't ready lost their slot")
+	}
+}
+
+func TestReadyCheckTimeoutKicksNonResponders(t *testing.T) {
+	_, stage, host, members := newReadyCheckParty(t, 1, true, 2, 3)
+
+	depart(host, 10)
+	handleReadyCommand(members[0], true)
+	if succeeded, answered := departureAck(t, host, 10, 3*time.Second); !answered || !succeeded {
+		t.Fatalf("got departure answered %v, succeeded %v, want a departure at the timeout", answered, succeeded)
+	}
+
+	stage.RLock()
+	_, readyKept := stage.reservedClientSlots[members[0].charID]
+	_, slowKept := stage.reservedClientSlots[members[1].charID]
+	stage.RUnlock()
+	if !readyKept || slowKept {
+		t.Errorf("got ready member reserved %v, slow member reserved %v, want only the slow one kicked", readyKept, slowKept)
+	}
+	if reservedStageOf(members[1]) != nil {
+		t.Error("kicked member still tracks the reservation")
+	}
+}
+
+func TestReadyCheckMemberDisconnects(t *testing.T) {
+	_, _, host, members := newReadyCheckParty(t, 30, false, 2, 3)
+
+	depart(host, 10)
+	handleReadyCommand(members[0], true)
+	logoutPlayer(members[1])
+	if succeeded, answered := departureAck(t, host, 10, time.Second); !answered || !succeeded {
+		t.Fatalf("got departure answered %v, succeeded %v, want the host to depart without the disconnected member", answered, succeeded)
+	}
+}
+
+func TestReadyCheckHostDisconnects(t *testing.T) {
+	_, stage, host, members := newReadyCheckParty(t, 30, false, 2)
+
+	depart(host, 10)
+	cancelStageReservation(host)
+	stage.RLock()
+	running := stage.readyCheck != nil
+	stage.RUnlock()
+	if running {
+		t.Fatal("ready check kept running for a host who left")
+	}
+	// The member's answer finds nothing to confirm rather than acking a gone host.
+	handleReadyCommand(members[0], true)
+}
+
+func TestReadyCheckDisabled(t *testing.T) {
+	_, _, host, _ := newReadyCheckParty(t, 0, false, 2)
+
+	depart(host, 10)
+	if succeeded, answered := departureAck(t, host, 10, time.Second); !answered || !succeeded {
+		t.Fatalf("got departure answered %v, succeeded %v, want an immediate departure", answered, succeeded)
+	}
+}
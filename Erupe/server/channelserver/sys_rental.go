@@ -0,0 +1,180 @@
+package channelserver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// Rental is temporary equipment lent to a character, such as a trial weapon handed out during an event.
+// Rentals are tracked here rather than in the savedata so they can be taken back when they expire.
+type Rental struct {
+	ID        int       `db:"id"`
+	CharID    uint32    `db:"character_id"`
+	ItemType  uint8     `db:"item_type"`
+	ItemID    uint16    `db:"item_id"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+// usableAt reports whether the rental can still be used. A rental that was valid when the
+// character departed on a quest stays usable until the quest is over, even if it expires while equipped.
+func (r Rental) usableAt(now time.Time, departedAt time.Time) bool {
+	if now.Before(r.ExpiresAt) {
+		return true
+	}
+	return !departedAt.IsZero() && departedAt.Before(r.ExpiresAt)
+}
+
+// GrantRental lends an item to the character until expiresAt.
+func GrantRental(db sqlx.Execer, charID uint32, itemType uint8, itemID uint16, expiresAt time.Time) error {
+	_, err := db.Exec("INSERT INTO rental_items (character_id, item_type, item_id, expires_at) VALUES ($1, $2, $3, $4)", charID, itemType, itemID, expiresAt)
+	return err
+}
+
+func getRentals(db *sqlx.DB, charID uint32) ([]Rental, error) {
+	var rentals []Rental
+	err := db.Select(&rentals, "SELECT id, character_id, item_type, item_id, expires_at FROM rental_items WHERE character_id = $1", charID)
+	return rentals, err
+}
+
+// trackQuestDeparture records when the session leaves for a quest, which decides the rentals it can use
+// until it's back, and takes back any rentals that expired while it was out.
+func trackQuestDeparture(s *Session, stageID string) {
+	s.Lock()
+	departed := !s.departedAt.IsZero()
+	if isQuestStageID(stageID) {
+		if !departed {
+			s.departedAt = time.Now()
+		}
+		s.Unlock()
+		if !departed {
+			recordGuildQuest(s)
+			noteTreasureDeparture(s)
+		}
+		return
+	}
+	s.departedAt = time.Time{}
+	s.Unlock()
+
+	if departed {
+		expireRentals(s)
+		recordQuestReturn(s)
+	}
+}
+
+// isRentalItem reports whether the item is a rental the session holds, which can't be traded or sold.
+func (s *Session) isRentalItem(itemID uint16) bool {
+	s.Lock()
+	defer s.Unlock()
+	for _, rental := range s.rentals {
+		if rental.ItemID == itemID {
+			return true
+		}
+	}
+	return false
+}
+
+// expireRentals takes back the session's expired rentals and tells the player about them.
+// Rentals expiring while the character is out on a quest are only taken back once it returns.
+func expireRentals(s *Session) {
+	rentals, err := getRentals(s.server.db, s.charID)
+	if err != nil {
+		s.logger.Error("Failed to get rentals", zap.Error(err))
+		return
+	}
+
+	s.Lock()
+	now := time.Now()
+	var kept []Rental
+	var expiredIDs []int64
+	for _, rental := range rentals {
+		if rental.usableAt(now, s.departedAt) {
+			kept = append(kept, rental)
+		} else {
+			expiredIDs = append(expiredIDs, int64(rental.ID))
+		}
+	}
+	s.rentals = kept
+	s.Unlock()
+	if len(expiredIDs) == 0 {
+		return
+	}
+
+	_, err = s.server.db.Exec("DELETE FROM rental_items WHERE id = ANY($1)", pq.Array(expiredIDs))
+	if err != nil {
+		s.logger.Error("Failed to remove expired rentals", zap.Error(err))
+		return
+	}
+
+	notifyReturnedRentals(s, len(expiredIDs))
+}
+
+// notifyReturnedRentals tells the player the rentals were taken back. A client that hasn't entered a
+// stage yet doesn't show chat, it's told on its first stage entry instead, see sendReturnedRentals.
+func notifyReturnedRentals(s *Session, returned int) {
+	if s.state() < sessionInStage {
+		s.Lock()
+		s.returnedRentals += returned
+		s.Unlock()
+		return
+	}
+	sendServerChatMessage(s, returnedRentalsMessage(returned))
+}
+
+// sendReturnedRentals tells the player of the rentals taken back before it entered a stage.
+func sendReturnedRentals(s *Session) {
+	s.Lock()
+	returned := s.returnedRentals
+	s.returnedRentals = 0
+	s.Unlock()
+	if returned > 0 {
+		sendServerChatMessage(s, returnedRentalsMessage(returned))
+	}
+}
+
+func returnedRentalsMessage(returned int) string {
+	return fmt.Sprintf("The rental period of %d lent item(s) has ended, they were taken back.", returned)
+}
+
+// scheduleDailyReset runs the daily reset for every connected session at each midnight, server time.
+func (s *Server) scheduleDailyReset() {
+	now := Time_Current()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).Add(24 * time.Hour)
+	s.Lock()
+	defer s.Unlock()
+	if s.isShuttingDown {
+		return
+	}
+	s.dailyResetTimer = time.AfterFunc(midnight.Sub(now), func() {
+		s.dailyReset()
+		s.scheduleDailyReset()
+	})
+}
+
+// stopDailyReset cancels the pending daily reset.
+func (s *Server) stopDailyReset() {
+	s.Lock()
+	defer s.Unlock()
+	if s.dailyResetTimer != nil {
+		s.dailyResetTimer.Stop()
+	}
+}
+
+func (s *Server) dailyReset() {
+	s.Lock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		if session.charID != 0 {
+			sessions = append(sessions, session)
+		}
+	}
+	s.Unlock()
+
+	for _, session := range sessions {
+		expireRentals(session)
+		awardDailySeasonPoints(session)
+	}
+}
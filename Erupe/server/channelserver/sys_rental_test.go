@@ -0,0 +1,71 @@
+package channelserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRentalExpiresWhileEquipped(t *testing.T) {
+	expiry := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	rental := Rental{ItemID: 100, ExpiresAt: expiry}
+
+	// Departed with the rental equipped and it ran out mid-quest: still usable until the quest ends.
+	if !rental.usableAt(expiry.Add(10*time.Minute), expiry.Add(-10*time.Minute)) {
+		t.Error("rental that expired mid-quest should stay usable until the quest ends")
+	}
+	// Back in town after the quest, the expired rental is gone.
+	if rental.usableAt(expiry.Add(20*time.Minute), time.Time{}) {
+		t.Error("expired rental is usable after returning from the quest")
+	}
+	// Departing after the rental expired doesn't bring it back.
+	if rental.usableAt(expiry.Add(20*time.Minute), expiry.Add(time.Minute)) {
+		t.Error("rental expired before departure is usable")
+	}
+	if !rental.usableAt(expiry.Add(-time.Minute), time.Time{}) {
+		t.Error("unexpired rental is not usable")
+	}
+}
+
+func TestQuestDepartureKeepsDepartureTime(t *testing.T) {
+	s := newTestServer()
+	session, client := newTestSession(s)
+	defer client.Close()
+
+	trackQuestDeparture(session, "sl1Qs001p0a0u001")
+	departedAt := session.departedAt
+	if departedAt.IsZero() {
+		t.Fatal("departure time not recorded when entering a quest stage")
+	}
+	// Moving between areas of the same quest isn't a new departure.
+	trackQuestDeparture(session, "sl1Qs002p0a0u001")
+	if !session.departedAt.Equal(departedAt) {
+		t.Error("departure time changed while still on the quest")
+	}
+}
+
+func TestReturnedRentalsNotice(t *testing.T) {
+	s := newTestServer()
+	session := newQueuedSession(t, s, 1, "Alpha")
+
+	// Taken back at login, before the client shows chat: told on the first stage entry.
+	notifyReturnedRentals(session, 2)
+	if len(session.sendPackets) != 0 {
+		t.Fatal("a session outside any stage was told of its returned rentals")
+	}
+	moveToStage(t, session, "sl1Ns200p0a0u0")
+	sendReturnedRentals(session)
+	if len(session.sendPackets) != 1 {
+		t.Fatalf("the first stage entry sent %d notices, want 1", len(session.sendPackets))
+	}
+	<-session.sendPackets
+	sendReturnedRentals(session)
+	if len(session.sendPackets) != 0 {
+		t.Error("the notice was sent twice")
+	}
+
+	// Taken back at the daily reset, in town: told right away.
+	notifyReturnedRentals(session, 1)
+	if len(session.sendPackets) != 1 {
+		t.Error("a session in a stage wasn't told of its returned rental")
+	}
+}
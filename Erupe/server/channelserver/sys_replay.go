@@ -0,0 +1,127 @@
+package channelserver
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"github.com/Solenataris/Erupe/network/packetcapture"
+)
+
+// Room in a replayed session's send queue, enough that no handler fills it between two packets.
+const replaySendQueueSize = 1024
+
+// replayer feeds the inbound packets of a capture through a session the way its recv loop would and
+// collects what the session sends back, so captures, such as of a crash, can become regression tests.
+//
+// Replays run on a server with no listener and usually no database. Handlers reaching the database
+// have to be replaced, see stub, or packets reaching them fail the replay. Handlers reading the clock
+// through the Time_ functions see the time each packet was captured at, those calling time.Now don't.
+type replayer struct {
+	server  *Server
+	session *Session
+}
+
+// newReplayer adds a session to replay captures through to the server. The login is stubbed to take the
+// character of the captured MSG_SYS_LOGIN without a sign in token. The server is switched to crash on
+// handler panics, which the replay turns into errors.
+func newReplayer(server *Server) *replayer {
+	server.erupeConfig.DevMode = true
+	server.erupeConfig.DevModeOptions.CrashOnPanic = true
+	serverConn, _ := net.Pipe()
+	session := NewSession(server, serverConn)
+	session.sendPackets = make(chan []byte, replaySendQueueSize)
+	server.Lock()
+	server.sessions[serverConn] = session
+	server.Unlock()
+
+	r := &replayer{server: server, session: session}
+	r.stub(network.MSG_SYS_LOGIN, replayLogin)
+	return r
+}
+
+// stub replaces the server's handler of an opcode, such as one reaching the database, for the replay.
+func (r *replayer) stub(opcode network.PacketID, handler handlerFunc) {
+	r.server.handlers.register(opcode, handler)
+}
+
+// replay feeds the capture's inbound packets through the session and returns what it sent, each packet
+// followed by MSG_SYS_END as the send loop writes it. The captured responses are skipped, the replay
+// makes its own. It stops at the end of the capture or once the session is told to close.
+func (r *replayer) replay(capture io.Reader) ([]byte, error) {
+	reader, err := packetcapture.NewReader(capture)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { timeNow = time.Now }()
+
+	var sent []byte
+	for n := 0; ; n++ {
+		packet, err := reader.Next()
+		if err == io.EOF {
+			return sent, nil
+		}
+		if err != nil {
+			return sent, err
+		}
+		if packet.Direction != packetcapture.Inbound {
+			continue
+		}
+		at := packet.Time
+		timeNow = func() time.Time { return at }
+		if err := r.handle(packet.Data); err != nil {
+			return sent, fmt.Errorf("packet %d, %s: %w", n, packet.Opcode, err)
+		}
+		more, closed := r.sent()
+		sent = append(sent, more...)
+		if closed {
+			return sent, nil
+		}
+	}
+}
+
+// handle runs a packet group through the session, returning the panic of a handler as an error.
+func (r *replayer) handle(data []byte) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("handler panicked: %v", p)
+		}
+	}()
+	r.session.handlePacketGroup(data)
+	return nil
+}
+
+// sent takes what the session queued so far and whether it was told to close.
+func (r *replayer) sent() ([]byte, bool) {
+	var data []byte
+	for {
+		select {
+		case packet := <-r.session.sendPackets:
+			if packet == nil {
+				return data, true
+			}
+			data = append(data, packet...)
+			data = append(data, 0x00, 0x10)
+		default:
+			return data, false
+		}
+	}
+}
+
+// replayLogin logs the session in as the captured character, answering as the login does.
+func replayLogin(s *Session, p mhfpacket.MHFPacket) {
+	pkt := p.(*mhfpacket.MsgSysLogin)
+	s.Lock()
+	s.charID = pkt.CharID0
+	s.rights = 0x0E
+	s.loginTime = timeNow()
+	s.updateLogFields()
+	s.Unlock()
+	bf := byteframe.NewByteFrame()
+	bf.WriteUint32(uint32(Time_Current_Adjusted().Unix()))
+	doAckSimpleSucceed(s, pkt.AckHandle, bf.Data())
+}
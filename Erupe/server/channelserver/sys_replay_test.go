@@ -0,0 +1,144 @@
+package channelserver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/common/fixtures"
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"github.com/Solenataris/Erupe/network/packetcapture"
+)
+
+// When the first packet of a synthetic capture was sent, the others follow a second apart.
+var replayEpoch = time.Date(2022, 5, 2, 18, 0, 0, 0, time.UTC)
+
+// openCapture writes a synthetic capture of the client sending the packets, each in a group of its own,
+// and opens it.
+func openCapture(t *testing.T, packets ...[]byte) *os.File {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "synthetic"+packetcapture.Extension)
+	w, err := packetcapture.Create(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, packet := range packets {
+		if err := w.Write(packetcapture.Inbound, replayEpoch.Add(time.Duration(i)*time.Second), append(packet, 0x00, 0x10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return file
+}
+
+func clientLogin(ackHandle uint32, charID uint32) []byte {
+	bf := byteframe.NewByteFrame()
+	bf.WriteUint16(uint16(network.MSG_SYS_LOGIN))
+	bf.WriteUint32(ackHandle)
+	bf.WriteUint32(charID)
+	bf.WriteUint32(1) // Sign in token number.
+	bf.WriteUint16(0)
+	bf.WriteUint16(0)
+	bf.WriteUint32(charID)
+	bf.WriteUint16(0)
+	bf.WriteUint16(17)
+	bf.WriteBytes([]byte("0123456789abcdef\x00"))
+	return bf.Data()
+}
+
+// clientStagePacket is a stage request made of the opcode, the ack handle, the bytes before the stage ID
+// and the stage ID, length prefixed.
+func clientStagePacket(opcode network.PacketID, ackHandle uint32, stageID string, fields ...uint8) []byte {
+	bf := byteframe.NewByteFrame()
+	bf.WriteUint16(uint16(opcode))
+	bf.WriteUint32(ackHandle)
+	for _, field := range fields {
+		bf.WriteUint8(field)
+	}
+	bf.WriteUint8(uint8(len(stageID)))
+	bf.WriteBytes([]byte(stageID))
+	return bf.Data()
+}
+
+func clientUnreserveStage() []byte {
+	return []byte{byte(network.MSG_SYS_UNRESERVE_STAGE >> 8), byte(network.MSG_SYS_UNRESERVE_STAGE)}
+}
+
+// TestReplayQuestUnpost replays a party leader posting a quest and taking it down, then the client
+// sending the unreserve a second time, which used to crash the channel.
+func TestReplayQuestUnpost(t *testing.T) {
+	r := newReplayer(newTestServer())
+	capture := openCapture(t,
+		clientLogin(0x01, 100),
+		clientStagePacket(network.MSG_SYS_CREATE_STAGE, 0x02, "sl1Qs210p0a0u0\x00", 1, 4),
+		clientStagePacket(network.MSG_SYS_RESERVE_STAGE, 0x03, "sl1Qs210p0a0u0\x00", 1),
+		clientStagePacket(network.MSG_SYS_ENUMERATE_STAGE, 0x04, "sl1Qs", 1),
+		clientUnreserveStage(),
+		clientUnreserveStage(),
+		clientStagePacket(network.MSG_SYS_ENUMERATE_STAGE, 0x05, "sl1Qs", 1),
+	)
+	sent, err := r.replay(capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixtures.Compare(t, "channelserver/replay_quest_unpost", sent,
+		"Packets sent to a party leader posting and taking down a quest, a snapshot written by TestReplayQuestUnpost rather than a client capture")
+}
+
+// TestReplayEnumerateStage replays a client listing the quests posted on a channel with players in them,
+// a quest left empty and players queued for a full one, which used to crash the channel.
+func TestReplayEnumerateStage(t *testing.T) {
+	s := newTestServer()
+	full, _ := s.CreateStage("sl1Qs220p0a0u0", 2)
+	for _, charID := range []uint32{200, 201} {
+		full.reservedClientSlots[charID] = nil
+	}
+	queued := newQueuedSession(t, s, 202, "Queued")
+	full.Lock()
+	full.queueReservationLocked(queued, 0x99)
+	full.Unlock()
+	departed, _ := s.CreateStage("sl1Qs210p0a0u0", 4)
+	departed.reservedClientSlots[300] = nil
+	departed.hasDeparted = true
+	departed.password = "1234"
+	s.CreateStage("sl1Qs230p0a0u0", 4)
+
+	r := newReplayer(s)
+	capture := openCapture(t,
+		clientLogin(0x01, 100),
+		clientStagePacket(network.MSG_SYS_ENUMERATE_STAGE, 0x02, "sl1Qs", 1),
+	)
+	sent, err := r.replay(capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixtures.Compare(t, "channelserver/replay_enumerate_stage", sent,
+		"Packets sent to a client listing the posted quests, a snapshot written by TestReplayEnumerateStage rather than a client capture")
+}
+
+func TestReplayHandlerPanic(t *testing.T) {
+	r := newReplayer(newTestServer())
+	r.stub(network.MSG_SYS_ENUMERATE_STAGE, func(s *Session, p mhfpacket.MHFPacket) {
+		panic("stage map corrupted")
+	})
+	capture := openCapture(t,
+		clientLogin(0x01, 100),
+		clientStagePacket(network.MSG_SYS_ENUMERATE_STAGE, 0x02, "sl1Qs", 1),
+	)
+	sent, err := r.replay(capture)
+	if err == nil || !strings.Contains(err.Error(), "packet 1, MSG_SYS_ENUMERATE_STAGE") || !strings.Contains(err.Error(), "stage map corrupted") {
+		t.Errorf("got error %v, want the panic of the second packet", err)
+	}
+	if len(sent) == 0 {
+		t.Error("lost the login response sent before the panic")
+	}
+}
@@ -0,0 +1,144 @@
+package channelserver
+
+import "time"
+
+// How long a reservation request waits for a slot in a full stage before it fails.
+// A var so tests can shorten it.
+var reservationQueueTimeout = 3 * time.Second
+
+// Most reservation requests that can wait on a single stage, any more fail straight away.
+const reservationQueueLength = 4
+
+// reservationRequest is a MsgSysReserveStage waiting for a slot in a full stage.
+type reservationRequest struct {
+	session   *Session
+	ackHandle uint32
+	timer     *time.Timer
+}
+
+// reservationAck is the answer to a reservation request, collected under the stage lock and sent after it's released.
+type reservationAck struct {
+	session   *Session
+	ackHandle uint32
+	granted   bool
+}
+
+// sendReservationAcks sends the answers. The caller mustn't hold the stage lock.
+func sendReservationAcks(acks []reservationAck) {
+	for _, ack := range acks {
+		if ack.granted {
+			doAckSimpleSucceed(ack.session, ack.ackHandle, []byte{0x00, 0x00, 0x00, 0x00})
+		} else {
+			doAckSimpleFail(ack.session, ack.ackHandle, []byte{0x00, 0x00, 0x00, 0x00})
+		}
+	}
+}
+
+// queueReservationLocked queues the session for the next free slot in the stage, failing the
+// request once reservationQueueTimeout passes. It reports false if the queue is full, and returns
+// the answer to the session's earlier request, if it was still queued.
+// The caller holds the stage lock.
+func (stage *Stage) queueReservationLocked(s *Session, ackHandle uint32) (bool, []reservationAck) {
+	// A client that asks again only waits once, its earlier request is answered as full.
+	var acks []reservationAck
+	if previous := stage.dequeueReservationLocked(s); previous != nil {
+		acks = append(acks, reservationAck{s, previous.ackHandle, false})
+	}
+	if len(stage.reservationQueue) >= reservationQueueLength {
+		return false, acks
+	}
+
+	req := &reservationRequest{session: s, ackHandle: ackHandle}
+	req.timer = time.AfterFunc(reservationQueueTimeout, func() {
+		stage.Lock()
+		expired := stage.dequeueReservationLocked(s) == req
+		stage.Unlock()
+		if expired {
+			doAckSimpleFail(s, ackHandle, []byte{0x00, 0x00, 0x00, 0x00})
+		}
+	})
+	stage.reservationQueue = append(stage.reservationQueue, req)
+
+	s.Lock()
+	s.reservationQueue = stage
+	s.Unlock()
+	return true, acks
+}
+
+// dequeueReservationLocked removes and returns the session's queued request, if it has one.
+// The caller holds the stage lock.
+func (stage *Stage) dequeueReservationLocked(s *Session) *reservationRequest {
+	for i, req := range stage.reservationQueue {
+		if req.session != s {
+			continue
+		}
+		req.timer.Stop()
+		stage.reservationQueue = append(stage.reservationQueue[:i], stage.reservationQueue[i+1:]...)
+		s.Lock()
+		if s.reservationQueue == stage {
+			s.reservationQueue = nil
+		}
+		s.Unlock()
+		return req
+	}
+	return nil
+}
+
+// grantQueuedReservationsLocked hands free slots to the queued requests, oldest first, returning the
+// answers to send once the caller releases the stage lock.
+func (stage *Stage) grantQueuedReservationsLocked() []reservationAck {
+	var acks []reservationAck
+	for len(stage.reservationQueue) > 0 && uint16(stage.slotsTakenLocked()) < stage.maxPlayers {
+		req := stage.reservationQueue[0]
+		stage.reservationQueue = stage.reservationQueue[1:]
+		// If the timer already fired its callback won't find the request, so it's granted here instead.
+		req.timer.Stop()
+
+		stage.reservedClientSlots[req.session.charID] = nil
+		req.session.Lock()
+		req.session.reservationStage = stage
+		if req.session.reservationQueue == stage {
+			req.session.reservationQueue = nil
+		}
+		req.session.Unlock()
+		acks = append(acks, reservationAck{req.session, req.ackHandle, true})
+	}
+	return acks
+}
+
+// failQueuedReservationsLocked answers every queued request as full, used when the stage is removed.
+// The answers are returned to send once the caller releases the stage lock.
+func (stage *Stage) failQueuedReservationsLocked() []reservationAck {
+	var acks []reservationAck
+	for len(stage.reservationQueue) > 0 {
+		req := stage.dequeueReservationLocked(stage.reservationQueue[0].session)
+		acks = append(acks, reservationAck{req.session, req.ackHandle, false})
+	}
+	return acks
+}
+
+// cancelStageReservation gives up the session's reserved or queued slot. A freed slot goes to
+// the next request queued for the stage.
+func cancelStageReservation(s *Session) {
+	s.Lock()
+	stage := s.reservationStage
+	s.reservationStage = nil
+	queued := s.reservationQueue
+	s.Unlock()
+
+	if queued != nil {
+		queued.Lock()
+		queued.dequeueReservationLocked(s)
+		queued.Unlock()
+	}
+	if stage != nil {
+		stage.Lock()
+		delete(stage.reservedClientSlots, s.charID)
+		// A member leaving mustn't leave the host waiting on them, a host leaving has no one to answer.
+		stage.dropFromReadyCheckLocked(s.charID)
+		stage.endReadyCheckOfHostLocked(s)
+		acks := stage.grantQueuedReservationsLocked()
+		stage.Unlock()
+		sendReservationAcks(acks)
+	}
+}
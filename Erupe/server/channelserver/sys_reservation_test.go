@@ -0,0 +1,121 @@
+package channelserver
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+)
+
+func newReservingSession(t *testing.T, s *Server, charID uint32) *Session {
+	session, client := newTestSession(s)
+	go io.Copy(ioutil.Discard, client)
+	t.Cleanup(func() { client.Close() })
+	session.charID = charID
+	return session
+}
+
+func reserve(session *Session, stageID string) {
+	handleMsgSysReserveStage(session, &mhfpacket.MsgSysReserveStage{StageID: stageID})
+}
+
+func TestReservationGrantedAfterCancel(t *testing.T) {
+	s := newTestServer()
+	stageID := "sl1Qs000p0a0u0"
+	stage, _ := s.CreateStage(stageID, 1)
+	first := newReservingSession(t, s, 1)
+	second := newReservingSession(t, s, 2)
+
+	reserve(first, stageID)
+	reserve(second, stageID)
+	if len(stage.reservationQueue) != 1 || second.reservationStage != nil {
+		t.Fatalf("second request wasn't queued behind the full stage")
+	}
+
+	handleMsgSysUnreserveStage(first, nil)
+
+	stage.RLock()
+	_, granted := stage.reservedClientSlots[second.charID]
+	_, stillFirst := stage.reservedClientSlots[first.charID]
+	queued := len(stage.reservationQueue)
+	stage.RUnlock()
+	if !granted || stillFirst || queued != 0 {
+		t.Errorf("got second granted %v, first reserved %v, %d queued, want the slot handed to the second", granted, stillFirst, queued)
+	}
+	if second.reservationStage != stage || second.reservationQueue != nil {
+		t.Error("granted session doesn't track its reservation")
+	}
+}
+
+func TestReservationQueueTimesOut(t *testing.T) {
+	defer func(timeout time.Duration) { reservationQueueTimeout = timeout }(reservationQueueTimeout)
+	reservationQueueTimeout = 20 * time.Millisecond
+
+	s := newTestServer()
+	stageID := "sl1Qs000p0a0u0"
+	stage, _ := s.CreateStage(stageID, 1)
+	first := newReservingSession(t, s, 1)
+	second := newReservingSession(t, s, 2)
+
+	reserve(first, stageID)
+	reserve(second, stageID)
+	time.Sleep(100 * time.Millisecond)
+
+	stage.RLock()
+	queued := len(stage.reservationQueue)
+	_, granted := stage.reservedClientSlots[second.charID]
+	stage.RUnlock()
+	if queued != 0 || granted {
+		t.Fatalf("got %d queued and granted %v after the timeout, want the request dropped", queued, granted)
+	}
+	second.Lock()
+	waiting := second.reservationQueue
+	second.Unlock()
+	if waiting != nil {
+		t.Error("timed out session still thinks it's queued")
+	}
+
+	// A slot freed after the timeout isn't handed to the expired request.
+	handleMsgSysUnreserveStage(first, nil)
+	if _, granted := stage.reservedClientSlots[second.charID]; granted {
+		t.Error("expired request was granted a slot")
+	}
+}
+
+func TestReservationQueueLimit(t *testing.T) {
+	s := newTestServer()
+	stageID := "sl1Qs000p0a0u0"
+	stage, _ := s.CreateStage(stageID, 1)
+	reserve(newReservingSession(t, s, 1), stageID)
+	for charID := uint32(2); charID < 2+reservationQueueLength+2; charID++ {
+		reserve(newReservingSession(t, s, charID), stageID)
+	}
+	if len(stage.reservationQueue) != reservationQueueLength {
+		t.Errorf("got %d queued, want %d", len(stage.reservationQueue), reservationQueueLength)
+	}
+	s.RemoveStage(stageID)
+	if len(stage.reservationQueue) != 0 {
+		t.Error("removing the stage left requests queued")
+	}
+}
+
+func TestReservationAcksSentOutsideStageLock(t *testing.T) {
+	s := newTestServer()
+	stage, _ := s.CreateStage("sl1Qs000p0a0u0", 1)
+	queued := newQueuedSession(t, s, 2, "Queued")
+
+	stage.Lock()
+	queueOK, _ := stage.queueReservationLocked(queued, 0x44)
+	acks := stage.grantQueuedReservationsLocked()
+	sent := len(queued.sendPackets)
+	stage.Unlock()
+	if !queueOK || len(acks) != 1 || !acks[0].granted || sent != 0 {
+		t.Fatalf("got %d acks with %d packets sent under the stage lock, want 1 ack and none sent", len(acks), sent)
+	}
+	sendReservationAcks(acks)
+	if len(queued.sendPackets) != 1 {
+		t.Errorf("sending the acks queued %d packets, want 1", len(queued.sendPackets))
+	}
+}
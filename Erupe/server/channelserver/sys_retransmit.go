@@ -0,0 +1,123 @@
+package channelserver
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"go.uber.org/zap"
+)
+
+// Most requests a session remembers for the retransmission check, the oldest ones are forgotten first.
+const maxRecentRequests = 32
+
+// recentRequest is a request the session handled lately, with the ack it was answered with.
+type recentRequest struct {
+	key       uint64
+	ackHandle uint32
+	at        time.Time
+	ack       []byte // The ack as queued, nil while the handler hasn't answered yet.
+}
+
+// recentRequests are the requests of the retransmission checked opcodes a session handled within the window.
+type recentRequests struct {
+	sync.Mutex
+	requests []*recentRequest // Oldest first.
+}
+
+// requestKey hashes a request's opcode and bytes. A retransmission is the same bytes, ack handle included,
+// so a request repeated on purpose is told apart by the new ack handle the client gives it.
+func requestKey(opcode network.PacketID, data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte{byte(opcode >> 8), byte(opcode)})
+	h.Write(data)
+	return h.Sum64()
+}
+
+// seen returns the request with the key handled since the cutoff, or records it as a new one.
+func (r *recentRequests) seen(key uint64, ackHandle uint32, now time.Time, cutoff time.Time) (*recentRequest, bool) {
+	r.Lock()
+	defer r.Unlock()
+	kept := r.requests[:0]
+	for _, req := range r.requests {
+		if req.at.After(cutoff) {
+			kept = append(kept, req)
+		}
+	}
+	r.requests = kept
+	for _, req := range r.requests {
+		if req.key == key {
+			return req, true
+		}
+	}
+	if len(r.requests) == maxRecentRequests {
+		r.requests = r.requests[1:]
+	}
+	r.requests = append(r.requests, &recentRequest{key: key, ackHandle: ackHandle, at: now})
+	return nil, false
+}
+
+// answered keeps the ack of the recent request it answers, for replaying it to a retransmission.
+func (r *recentRequests) answered(ackHandle uint32, data []byte) {
+	r.Lock()
+	defer r.Unlock()
+	for _, req := range r.requests {
+		if req.ackHandle == ackHandle && req.ack == nil {
+			req.ack = append([]byte(nil), data...)
+		}
+	}
+}
+
+// replay returns the ack the request was answered with, nil while it's still being handled.
+func (r *recentRequests) replay(req *recentRequest) []byte {
+	r.Lock()
+	defer r.Unlock()
+	return req.ack
+}
+
+// retransmitChecked reports whether requests of the opcode are checked for retransmissions.
+func (s *Server) retransmitChecked(opcode network.PacketID) bool {
+	cfg := s.erupeConfig.Current().Channel
+	if cfg.RetransmitWindow <= 0 {
+		return false
+	}
+	name := opcode.String()
+	for _, checked := range cfg.RetransmitOpcodes {
+		if checked == name {
+			return true
+		}
+	}
+	return false
+}
+
+// retransmitMiddleware answers a request the client sent again unchanged within the window with the
+// ack of the first one instead of handling it twice, for the client builds resending requests answered
+// slowly. A retransmission of a request still being handled is dropped, the first one's ack answers both.
+func retransmitMiddleware(server *Server) handlerMiddleware {
+	return func(opcode network.PacketID, next handlerFunc) handlerFunc {
+		return func(s *Session, p mhfpacket.MHFPacket) {
+			ackHandle, ok := packetAckHandle(p)
+			if !ok || len(s.handlingPacket) == 0 || !server.retransmitChecked(opcode) {
+				next(s, p)
+				return
+			}
+			now := time.Now()
+			window := time.Duration(server.erupeConfig.Current().Channel.RetransmitWindow) * time.Second
+			req, seen := s.recentRequests.seen(requestKey(opcode, s.handlingPacket), ackHandle, now, now.Add(-window))
+			if !seen {
+				next(s, p)
+				return
+			}
+			atomic.AddUint64(&server.retransmits, 1)
+			if ack := s.recentRequests.replay(req); ack != nil {
+				s.logger.Info("Replayed the response to a retransmitted request", zap.String("opcode", opcode.String()), zap.Uint32("ackHandle", ackHandle))
+				s.QueueSend(ack)
+				return
+			}
+			s.logger.Info("Dropped a retransmission of a request still being handled", zap.String("opcode", opcode.String()), zap.Uint32("ackHandle", ackHandle))
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package channelserver
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"go.uber.org/zap"
+)
+
+func makePositionPacket(objID uint32, x float32) []byte {
+	bf := byteframe.NewByteFrame()
+	bf.WriteUint16(uint16(network.MSG_SYS_POSITION_OBJECT))
+	bf.WriteUint32(objID)
+	bf.WriteFloat32(x)
+	bf.WriteFloat32(0)
+	bf.WriteFloat32(0)
+	return bf.Data()
+}
+
+func newRetransmitSession(t *testing.T, opcodes ...string) (*Server, *Session) {
+	s := newTestServer()
+	s.erupeConfig.Channel.RetransmitWindow = 10
+	s.erupeConfig.Channel.RetransmitOpcodes = opcodes
+	session := newQueuedSession(t, s, 100, "Hunter")
+	session.logger = zap.NewNop()
+	return s, session
+}
+
+func TestRetransmitReplaysResponse(t *testing.T) {
+	s, session := newRetransmitSession(t, "MSG_SYS_PING")
+	calls := 0
+	s.handlers.register(network.MSG_SYS_PING, func(s *Session, p mhfpacket.MHFPacket) {
+		calls++
+		doAckSimpleSucceed(s, p.(*mhfpacket.MsgSysPing).AckHandle, []byte{0, 0, 0, byte(calls)})
+	})
+
+	session.handlePacketGroup(makePingPacket(1))
+	first := sentPackets(session)
+	session.handlePacketGroup(makePingPacket(1))
+	if calls != 1 {
+		t.Fatalf("a retransmission ran the handler again, %d calls", calls)
+	}
+	if replayed := sentPackets(session); !bytes.Equal(replayed, first) {
+		t.Errorf("a retransmission got %x, want the first response %x", replayed, first)
+	}
+
+	// The same request with a new ack handle is the client asking again, not resending.
+	session.handlePacketGroup(makePingPacket(2))
+	if calls != 2 {
+		t.Errorf("a request repeated with a new ack handle was suppressed, %d calls", calls)
+	}
+
+	s.erupeConfig.Channel.RetransmitWindow = 0
+	session.handlePacketGroup(makePingPacket(1))
+	if calls != 3 {
+		t.Errorf("a retransmission was suppressed with the check off, %d calls", calls)
+	}
+}
+
+func TestRetransmitDropsWhileHandling(t *testing.T) {
+	s, session := newRetransmitSession(t, "MSG_SYS_PING")
+	calls := 0
+	var pending []uint32
+	s.handlers.register(network.MSG_SYS_PING, func(s *Session, p mhfpacket.MHFPacket) {
+		calls++
+		pending = append(pending, p.(*mhfpacket.MsgSysPing).AckHandle)
+	})
+
+	session.handlePacketGroup(makePingPacket(1))
+	session.handlePacketGroup(makePingPacket(1))
+	if calls != 1 || len(sentPackets(session)) != 0 {
+		t.Fatalf("a retransmission of a request still being handled ran %d calls or got an answer", calls)
+	}
+	// The handler answers later, as a deferred task does, and the next retransmission gets that answer.
+	doAckSimpleSucceed(session, pending[0], make([]byte, 4))
+	first := sentPackets(session)
+	session.handlePacketGroup(makePingPacket(1))
+	if replayed := sentPackets(session); calls != 1 || !bytes.Equal(replayed, first) {
+		t.Errorf("got %x after %d calls, want the late answer %x replayed", replayed, calls, first)
+	}
+}
+
+func TestRetransmitLetsRepeatedMovementThrough(t *testing.T) {
+	s, session := newRetransmitSession(t, "MSG_SYS_PING", "MSG_SYS_POSITION_OBJECT")
+	session.stage = NewStage("sl1Ns200p0a0u0")
+	moves := 0
+	s.handlers.register(network.MSG_SYS_POSITION_OBJECT, func(s *Session, p mhfpacket.MHFPacket) {
+		moves++
+	})
+
+	// Position updates carry no ack handle, so identical ones sent in a burst are all handled.
+	for i := 0; i < 5; i++ {
+		session.handlePacketGroup(makePositionPacket(1, 10))
+	}
+	if moves != 5 {
+		t.Errorf("handled %d of 5 identical position updates", moves)
+	}
+}
@@ -0,0 +1,127 @@
+package channelserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"github.com/Solenataris/Erupe/common/revocation"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// RevokeReason is why an account was signed out everywhere, see revocation.Reason.
+type RevokeReason = revocation.Reason
+
+// Revoke reasons.
+const (
+	RevokeBanned      = revocation.Banned
+	RevokeCredentials = revocation.Credentials
+	RevokeKicked      = revocation.Kicked
+)
+
+// revokeDisconnects are the reasons sessions are disconnected with, and the message players are told, by reason.
+var revokeDisconnects = map[RevokeReason]DisconnectReason{
+	RevokeBanned:      DisconnectBanned,
+	RevokeCredentials: DisconnectCredentials,
+	RevokeKicked:      DisconnectKicked,
+}
+
+// execer is a DB connection or transaction statements run on.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// RevokeAccount deletes the account's sign in tokens and tells every channel sharing the DB to disconnect
+// its sessions, see revocation.Account.
+func RevokeAccount(tx execer, userID uint32, reason RevokeReason) error {
+	return revocation.Account(tx, userID, reason)
+}
+
+// RevokeSessions disconnects the sessions of the account on the channel, telling the players why first,
+// and returns how many there were. Their progress is saved by the usual logout. A session is only
+// revoked once, however many times the account is.
+func (s *Server) RevokeSessions(userID uint32, reason RevokeReason) int {
+	var revoked []*Session
+	s.Lock()
+	for _, session := range s.sessions {
+		if session.userID == userID && session.charID != 0 && atomic.CompareAndSwapInt32(&session.revoked, 0, 1) {
+			revoked = append(revoked, session)
+		}
+	}
+	s.Unlock()
+
+	for _, session := range revoked {
+		s.logger.Info("Disconnecting revoked session", zap.Uint32("userID", userID), zap.Uint32("charID", session.charID), zap.String("reason", string(reason)))
+		disconnect, ok := revokeDisconnects[reason]
+		if !ok {
+			disconnect = DisconnectKicked
+		}
+		session.closeWith(disconnect)
+	}
+	return len(revoked)
+}
+
+// RevocationListener disconnects the sessions of accounts revoked by any process sharing the DB, and
+// mutes those of accounts muted, on every channel of the registry. A single listener serves all the
+// channels of a process.
+type RevocationListener struct {
+	logger   *zap.Logger
+	registry *ChannelRegistry
+	listener *pq.Listener
+}
+
+// NewRevocationListener listens for revoked and muted accounts on a connection of its own, opened with
+// connectString.
+func NewRevocationListener(logger *zap.Logger, connectString string, registry *ChannelRegistry) (*RevocationListener, error) {
+	l := &RevocationListener{logger: logger, registry: registry}
+	l.listener = pq.NewListener(connectString, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.Warn("Revocation listener error", zap.Error(err))
+		}
+	})
+	for _, channel := range []string{revocation.NotifyChannel, revocation.MuteChannel} {
+		if err := l.listener.Listen(channel); err != nil {
+			l.listener.Close()
+			return nil, err
+		}
+	}
+	go l.listen()
+	return l, nil
+}
+
+func (l *RevocationListener) listen() {
+	for notification := range l.listener.Notify {
+		// A nil notification means the connection was re-established. Bans sent meanwhile are still
+		// enforced by the ban poll, a session whose password changed meanwhile keeps playing, and one
+		// muted meanwhile picks the mute up at its next login.
+		if notification == nil {
+			continue
+		}
+		if notification.Channel == revocation.MuteChannel {
+			var muted revocation.MuteNotification
+			if err := json.Unmarshal([]byte(notification.Extra), &muted); err != nil {
+				l.logger.Warn("Dropped malformed mute", zap.Error(err))
+				continue
+			}
+			for _, server := range l.registry.Servers() {
+				server.MuteSessions(muted.UserID, muted.Until)
+			}
+			continue
+		}
+		var revoked revocation.Notification
+		if err := json.Unmarshal([]byte(notification.Extra), &revoked); err != nil {
+			l.logger.Warn("Dropped malformed revocation", zap.Error(err))
+			continue
+		}
+		for _, server := range l.registry.Servers() {
+			server.RevokeSessions(revoked.UserID, revoked.Reason)
+		}
+	}
+}
+
+// Close stops listening.
+func (l *RevocationListener) Close() error {
+	return l.listener.Close()
+}
@@ -0,0 +1,133 @@
+package channelserver
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/common/testdb"
+	"github.com/Solenataris/Erupe/config"
+	"go.uber.org/zap"
+)
+
+// revokedWith returns the text of what the session was sent before being told to close, and whether it was.
+func revokedWith(session *Session) (string, bool) {
+	var sent []byte
+	for {
+		select {
+		case packet := <-session.sendPackets:
+			if packet == nil {
+				return string(sent), true
+			}
+			sent = append(sent, packet...)
+		default:
+			return string(sent), false
+		}
+	}
+}
+
+func TestRevokeSessions(t *testing.T) {
+	s := newTestServer()
+	banned := newQueuedSession(t, s, 1, "Alpha")
+	banned.userID = 10
+	other := newQueuedSession(t, s, 2, "Beta")
+	other.userID = 20
+
+	if n := s.RevokeSessions(10, RevokeBanned); n != 1 {
+		t.Fatalf("revoked %d sessions, want the account's one", n)
+	}
+	sent, closed := revokedWith(banned)
+	if !closed || !strings.Contains(sent, disconnectMessages[DisconnectBanned]) {
+		t.Errorf("banned session got %q and closed %v, want the ban message then a close", sent, closed)
+	}
+	if _, closed := revokedWith(other); closed {
+		t.Error("another account's session was closed")
+	}
+
+	// The admin API and the notification can both reach the channel, the session is told once.
+	if n := s.RevokeSessions(10, RevokeBanned); n != 0 {
+		t.Errorf("revoked the session again")
+	}
+
+	s.RevokeSessions(20, RevokeCredentials)
+	if sent, closed := revokedWith(other); !closed || !strings.Contains(sent, disconnectMessages[DisconnectCredentials]) {
+		t.Errorf("session got %q and closed %v, want the password message then a close", sent, closed)
+	}
+}
+
+// TestRevokeAccountOrdering runs against the database in ERUPE_TEST_DB, e.g.
+// "host=localhost user=postgres password=admin dbname=erupe_test sslmode=disable".
+// It goes through the whole revocation: the account's revoke transaction, the notification and the kick,
+// then the client trying the old sign in token again.
+func TestRevokeAccountOrdering(t *testing.T) {
+	db := testdb.Open(t)
+
+	var userID, tokenID uint32
+	err := db.QueryRow("INSERT INTO users (username, password) VALUES ('revoke_test', '') RETURNING id").Scan(&userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+	defer db.Exec("DELETE FROM sign_sessions WHERE user_id = $1", userID)
+	err = db.QueryRow("INSERT INTO sign_sessions (user_id, auth_token_str) VALUES ($1, 'revokerevokerevo') RETURNING id", userID).Scan(&tokenID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry := NewChannelRegistry()
+	s := NewServer(&Config{Logger: zap.NewNop(), DB: db, ErupeConfig: &config.Config{}, Registry: registry})
+	session := newQueuedSession(t, s, 1, "Alpha")
+	session.userID = userID
+	listener, err := NewRevocationListener(zap.NewNop(), os.Getenv(testdb.Env), registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := RevokeAccount(tx, userID, RevokeBanned); err != nil {
+		t.Fatal(err)
+	}
+
+	// Until the transaction commits the token is still good and nobody is kicked.
+	time.Sleep(200 * time.Millisecond)
+	if _, closed := revokedWith(session); closed {
+		t.Fatal("session was kicked before the revocation committed")
+	}
+	var tokens int
+	db.QueryRow("SELECT COUNT(*) FROM sign_sessions WHERE user_id = $1", userID).Scan(&tokens)
+	if tokens != 1 {
+		t.Fatalf("got %d tokens outside the transaction before it committed, want 1", tokens)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	var sent string
+	for {
+		more, closed := revokedWith(session)
+		sent += more
+		if closed {
+			if !strings.Contains(sent, disconnectMessages[DisconnectBanned]) {
+				t.Errorf("session got %q before closing, want the ban message", sent)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("session wasn't kicked within 5 seconds of the revocation")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The client signs in again with the token it had, which is gone by the time it was kicked.
+	_, err = checkSignToken(db, userID, tokenID, "revokerevokerevo", 0, time.Now())
+	if err != errSignTokenInvalid {
+		t.Errorf("old token after the kick got %v, want %v", err, errSignTokenInvalid)
+	}
+}
@@ -0,0 +1,200 @@
+package channelserver
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sachaos/lottery"
+	"go.uber.org/zap"
+)
+
+// Names of the RNG streams. Each feature draws from its own stream so rolls in one can't shift the results of another.
+const (
+	rngStreamGacha       = "gacha"
+	rngStreamLottery     = "lottery"
+	rngStreamQuestSeed   = "quest-seed"
+	rngStreamExploration = "exploration"
+	rngStreamEvent       = "event"
+)
+
+// How many draws a stream reserves in the DB at a time. A stream writes its draw count plus this many back
+// before it hands out the first of them, so a crash skips at most that many draws instead of replaying them.
+const rngReserveSize = 64
+
+// Increment of the splitmix64 state between two draws.
+const splitMixGamma = 0x9e3779b97f4a7c15
+
+// rngValueAt returns the draw with the given sequence number of a stream seeded with seed.
+func rngValueAt(seed uint64, seq uint64) uint64 {
+	z := seed + seq*splitMixGamma
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// RNGStream is an independently seeded stream of random numbers. Its whole state is the seed and
+// the number of draws so far, so any draw can be replayed from the two values stored in the DB.
+type RNGStream struct {
+	sync.Mutex
+	name  string
+	seed  uint64
+	draws uint64
+	// Draw count stored in the DB. Draws up to it can be handed out without another write.
+	saved uint64
+	// Writes the seed and a draw count to the DB, nil for a stream that isn't persisted.
+	persist func(seed, draws uint64) error
+
+	// Set when every draw should be logged.
+	logger *zap.Logger
+}
+
+// Uint64 draws the next number from the stream.
+func (r *RNGStream) Uint64() uint64 {
+	r.Lock()
+	defer r.Unlock()
+	r.draws++
+	if r.persist != nil && r.draws > r.saved {
+		reserved := r.draws + rngReserveSize - 1
+		if err := r.persist(r.seed, reserved); err != nil {
+			// The draw is still handed out, the next one tries the write again.
+			if r.logger != nil {
+				r.logger.Error("Failed to save RNG stream", zap.Error(err), zap.String("stream", r.name))
+			}
+		} else {
+			r.saved = reserved
+		}
+	}
+	value := rngValueAt(r.seed, r.draws)
+	if r.logger != nil {
+		r.logger.Info("RNG draw", zap.String("stream", r.name), zap.Uint64("seq", r.draws), zap.Uint64("value", value))
+	}
+	return value
+}
+
+// Intn draws a number in [0, n). It panics if n <= 0.
+func (r *RNGStream) Intn(n int) int {
+	if n <= 0 {
+		panic("invalid argument to Intn")
+	}
+	return int(r.Uint64() % uint64(n))
+}
+
+// Draw picks one of the weighters with a chance proportional to its weight, or returns -1 if there are none.
+// It satisfies lottery.Lottery.
+func (r *RNGStream) Draw(weighters []lottery.Weighter) int {
+	totalWeight := 0
+	for _, weighter := range weighters {
+		totalWeight += weighter.Weight()
+	}
+	if totalWeight <= 0 {
+		return -1
+	}
+
+	lot := r.Intn(totalWeight)
+	for i, weighter := range weighters {
+		lot -= weighter.Weight()
+		if lot < 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// RNG hands out the named RNG streams of a server and persists their state,
+// so restarting the server doesn't start the draws over.
+type RNG struct {
+	sync.Mutex
+	db         *sqlx.DB
+	serverName string
+	logger     *zap.Logger
+	logDraws   bool
+	streams    map[string]*RNGStream
+}
+
+// NewRNG creates the RNG service for the named server. With logDraws set every draw is logged.
+func NewRNG(db *sqlx.DB, serverName string, logger *zap.Logger, logDraws bool) *RNG {
+	return &RNG{
+		db:         db,
+		serverName: serverName,
+		logger:     logger,
+		logDraws:   logDraws,
+		streams:    make(map[string]*RNGStream),
+	}
+}
+
+func newRNGSeed() uint64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return uint64(time.Now().UnixNano())
+	}
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+// Stream returns the named stream, restoring it from the DB or seeding it on first use.
+func (r *RNG) Stream(name string) *RNGStream {
+	r.Lock()
+	defer r.Unlock()
+	if stream, ok := r.streams[name]; ok {
+		return stream
+	}
+
+	stream := &RNGStream{name: name}
+	if r.logDraws {
+		stream.logger = r.logger
+	}
+	if r.db != nil {
+		stream.persist = func(seed, draws uint64) error {
+			return r.saveStream(name, seed, draws)
+		}
+	}
+	var seed, draws int64
+	var err error
+	if r.db != nil {
+		err = r.db.QueryRow("SELECT seed, draws FROM rng_streams WHERE server_name = $1 AND stream = $2", r.serverName, name).Scan(&seed, &draws)
+	}
+	if r.db != nil && err == nil {
+		// Everything up to the stored count may have been handed out before a crash, carry on after it.
+		stream.seed, stream.draws, stream.saved = uint64(seed), uint64(draws), uint64(draws)
+	} else {
+		if err != nil && err != sql.ErrNoRows {
+			r.logger.Error("Failed to load RNG stream, reseeding", zap.Error(err), zap.String("stream", name))
+		}
+		stream.seed = newRNGSeed()
+	}
+	r.logger.Info("Opened RNG stream", zap.String("stream", name), zap.Uint64("seed", stream.seed), zap.Uint64("draws", stream.draws))
+	r.streams[name] = stream
+	return stream
+}
+
+func (r *RNG) saveStream(name string, seed, draws uint64) error {
+	_, err := r.db.Exec(`
+		INSERT INTO rng_streams (server_name, stream, seed, draws) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (server_name, stream) DO UPDATE SET seed = $3, draws = $4
+	`, r.serverName, name, int64(seed), int64(draws))
+	return err
+}
+
+// save writes the exact draw counts of the streams on shutdown, so a clean restart doesn't skip the draws
+// the streams reserved but never handed out.
+func (r *RNG) save() {
+	if r.db == nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	for _, stream := range r.streams {
+		stream.Lock()
+		if stream.saved != stream.draws {
+			if err := r.saveStream(stream.name, stream.seed, stream.draws); err != nil {
+				r.logger.Error("Failed to save RNG stream", zap.Error(err), zap.String("stream", stream.name))
+			} else {
+				stream.saved = stream.draws
+			}
+		}
+		stream.Unlock()
+	}
+}
@@ -0,0 +1,93 @@
+package channelserver
+
+import (
+	"testing"
+
+	"github.com/sachaos/lottery"
+	"go.uber.org/zap"
+)
+
+func TestRNGStreamReplay(t *testing.T) {
+	stream := &RNGStream{name: rngStreamGacha, seed: 12345}
+	var draws []uint64
+	for i := 0; i < 10; i++ {
+		draws = append(draws, stream.Uint64())
+	}
+
+	// A stream restored from its seed and draw count carries on where the original left off.
+	restored := &RNGStream{name: rngStreamGacha, seed: 12345, draws: 5}
+	for i := 5; i < 10; i++ {
+		if got := restored.Uint64(); got != draws[i] {
+			t.Errorf("draw %d after restore = %d, want %d", i+1, got, draws[i])
+		}
+	}
+	for i, want := range draws {
+		if got := rngValueAt(12345, uint64(i+1)); got != want {
+			t.Errorf("rngValueAt(seq %d) = %d, want %d", i+1, got, want)
+		}
+	}
+}
+
+func TestRNGStreamsAreIndependent(t *testing.T) {
+	rng := NewRNG(nil, "test", zap.NewNop(), false)
+	gacha := rng.Stream(rngStreamGacha)
+	lotteryStream := rng.Stream(rngStreamLottery)
+	if rng.Stream(rngStreamGacha) != gacha {
+		t.Fatal("Stream should return the same stream for the same name")
+	}
+
+	want := rngValueAt(gacha.seed, 1)
+	for i := 0; i < 100; i++ {
+		lotteryStream.Uint64()
+	}
+	if got := gacha.Uint64(); got != want {
+		t.Errorf("gacha draw after lottery draws = %d, want %d", got, want)
+	}
+}
+
+type testWeighter int
+
+func (w testWeighter) Weight() int {
+	return int(w)
+}
+
+func TestRNGStreamDrawSkipsZeroWeights(t *testing.T) {
+	stream := &RNGStream{seed: 1}
+	items := []lottery.Weighter{testWeighter(0), testWeighter(3), testWeighter(0), testWeighter(1)}
+	for i := 0; i < 1000; i++ {
+		if ind := stream.Draw(items); ind != 1 && ind != 3 {
+			t.Fatalf("drew index %d, which has no weight", ind)
+		}
+	}
+	if ind := stream.Draw([]lottery.Weighter{testWeighter(0)}); ind != -1 {
+		t.Errorf("drew index %d from items without weight, want -1", ind)
+	}
+}
+
+func TestRNGStreamSavesBeforeDrawing(t *testing.T) {
+	var saved uint64
+	stream := &RNGStream{name: rngStreamGacha, seed: 12345}
+	stream.persist = func(seed, draws uint64) error {
+		saved = draws
+		return nil
+	}
+	var draws []uint64
+	for i := 0; i < rngReserveSize+1; i++ {
+		draws = append(draws, stream.Uint64())
+		if saved < stream.draws {
+			t.Fatalf("draw %d was handed out with %d draws saved", stream.draws, saved)
+		}
+	}
+	if saved != 2*rngReserveSize {
+		t.Errorf("%d draws reserved 2 blocks as %d draws, want %d", len(draws), saved, 2*rngReserveSize)
+	}
+
+	// After a crash the stream carries on past everything it could have handed out.
+	restored := &RNGStream{name: rngStreamGacha, seed: 12345, draws: saved, saved: saved}
+	next := restored.Uint64()
+	for i, draw := range draws {
+		if draw == next {
+			t.Errorf("the first draw after a crash replays draw %d", i+1)
+		}
+	}
+}
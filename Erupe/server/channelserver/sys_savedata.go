@@ -0,0 +1,247 @@
+package channelserver
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/Solenataris/Erupe/common/names"
+	"github.com/Solenataris/Erupe/common/stringsupport"
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/server/channelserver/compression/blockdelta"
+	"github.com/Solenataris/Erupe/server/channelserver/compression/deltacomp"
+	"github.com/Solenataris/Erupe/server/channelserver/compression/nullcomp"
+)
+
+// MinSaveDataSize is the smallest savedata holding every field read out of it, up to the RP at
+// CharacterSaveRPPointer.
+const MinSaveDataSize = CharacterSaveRPPointer + 2
+
+// MaxSaveDataSize is well past the savedata of any client, a larger one is a corrupt upload or diff. It
+// bounds the uploads when Channel.SavedataQuota sets no max size.
+const MaxSaveDataSize = 0x40000
+
+// savedataSizeLimits returns the smallest and largest savedata the quota accepts.
+func savedataSizeLimits(quota config.SavedataQuota) (int, int) {
+	min, max := quota.MinSize, quota.MaxSize
+	if min < MinSaveDataSize {
+		min = MinSaveDataSize
+	}
+	if max <= 0 {
+		max = MaxSaveDataSize
+	}
+	return min, max
+}
+
+// newSavedata returns the savedata an upload replaces the base with, the whole of it for a blob or the
+// base with a diff applied. The savedata carries no magic or checksum the server knows of, so an upload
+// truncated or garbled on the way is caught by its size.
+func newSavedata(saveType uint8, payload []byte, base []byte, quota config.SavedataQuota) ([]byte, error) {
+	data, err := nullcomp.Decompress(payload)
+	if err != nil {
+		return nil, err
+	}
+	if saveType == 1 {
+		data = deltacomp.ApplyDataDiff(data, base)
+	}
+	if min, max := savedataSizeLimits(quota); len(data) < min || len(data) > max {
+		return nil, fmt.Errorf("savedata of %d bytes isn't between %d and %d", len(data), min, max)
+	}
+	return data, nil
+}
+
+// savedataAnomaly is a save whose savedata changed in size past Channel.SavedataQuota.ChangePercent
+// from the character's previous one, as recorded in save_anomalies.
+type savedataAnomaly struct {
+	previousSize int
+	size         int
+	keptPrevious bool // The save was refused.
+}
+
+// checkSavedataSize returns the anomaly of a savedata replacing previous, nil when its size is in line
+// with it or the character had no savedata before.
+func checkSavedataSize(previous []byte, data []byte, quota config.SavedataQuota) *savedataAnomaly {
+	if quota.ChangePercent <= 0 || len(previous) == 0 {
+		return nil
+	}
+	change := math.Abs(float64(len(data)-len(previous))) / float64(len(previous)) * 100
+	if change <= quota.ChangePercent {
+		return nil
+	}
+	return &savedataAnomaly{len(previous), len(data), quota.KeepPrevious}
+}
+
+// recordSavedataAnomaly flags the character in save_anomalies.
+func recordSavedataAnomaly(db execer, charID uint32, anomaly *savedataAnomaly) error {
+	_, err := db.Exec("INSERT INTO save_anomalies (char_id, previous_size, size, kept_previous) VALUES ($1, $2, $3, $4)",
+		charID, anomaly.previousSize, anomaly.size, anomaly.keptPrevious)
+	return err
+}
+
+// savedataFields are the columns of the characters table kept in step with the savedata, for the
+// launcher and the lookups that don't decompress it.
+type savedataFields struct {
+	name       string
+	weaponType uint16
+	weaponID   uint16
+	isFemale   bool
+	hrp        uint16
+	gr         uint16
+
+	// Whether the name the save gave is refused by the name rules, nil when it kept the name.
+	forceRename *bool
+}
+
+// readSavedataFields reads the fields out of a savedata of at least MinSaveDataSize.
+func readSavedataFields(data []byte) savedataFields {
+	fields := savedataFields{
+		name:       stringsupport.DecodeShiftJIS(data[88:100]),
+		weaponType: uint16(data[128789]),
+		weaponID:   binary.LittleEndian.Uint16(data[128522:128524]), // 0x1F60A
+		isFemale:   data[80] == 1,                                   // 0x50
+		hrp:        binary.LittleEndian.Uint16(data[saveDataHRPOffset : saveDataHRPOffset+2]),
+	}
+	if grp := binary.LittleEndian.Uint32(data[saveDataGRPOffset : saveDataGRPOffset+4]); grp > 0 {
+		fields.gr = grpToGR(grp)
+	}
+	return fields
+}
+
+// saveStatement is a statement of a save.
+type saveStatement struct {
+	query string
+	args  []interface{}
+}
+
+// saveTx is the transaction a save is written in.
+type saveTx interface {
+	execer
+	Commit() error
+	Rollback() error
+}
+
+// savedataWrite is how a save stores its savedata: whole, compressed, replacing the stored one and its
+// diffs, or as a diff onto the savedata those give.
+type savedataWrite struct {
+	whole []byte
+	diff  []byte
+}
+
+// writeSavedata writes a save in the transaction, the savedata then the fields read out of it. A whole
+// savedata first moves the one it replaces to savedata_backups, which keeps the backups most recent of the
+// character's, and drops its diffs. A diff is appended, the backups only see the savedata written whole.
+// It commits only once every statement went through and rolls back otherwise, so the stored savedata and
+// its fields are never those of two saves.
+func writeSavedata(tx saveTx, charID uint32, write savedataWrite, fields savedataFields, backups int) error {
+	var statements []saveStatement
+	if write.diff != nil {
+		statements = append(statements,
+			saveStatement{"INSERT INTO savedata_diffs (char_id, diff) VALUES ($1, $2)", []interface{}{charID, write.diff}},
+			saveStatement{"UPDATE characters SET is_new_character=false WHERE id=$1", []interface{}{charID}},
+		)
+	} else {
+		if backups > 0 {
+			statements = append(statements,
+				saveStatement{"INSERT INTO savedata_backups (char_id, savedata) SELECT id, savedata FROM characters WHERE id=$1 AND savedata IS NOT NULL", []interface{}{charID}},
+				saveStatement{"DELETE FROM savedata_backups WHERE id IN (SELECT id FROM savedata_backups WHERE char_id=$1 AND kind='save' ORDER BY id DESC OFFSET $2)", []interface{}{charID, backups}},
+			)
+		}
+		statements = append(statements,
+			saveStatement{"UPDATE characters SET savedata=$1, is_new_character=false WHERE id=$2", []interface{}{write.whole, charID}},
+			saveStatement{"DELETE FROM savedata_diffs WHERE char_id=$1", []interface{}{charID}},
+		)
+	}
+	statements = append(statements,
+		saveStatement{"UPDATE characters SET name=$1, name_key=$2, weapon_type=$3, weapon_id=$4, is_female=$5, hrp=$6, gr=$7 WHERE id=$8",
+			[]interface{}{fields.name, names.Key(fields.name), fields.weaponType, fields.weaponID, fields.isFemale, fields.hrp, fields.gr, charID}},
+	)
+	if fields.forceRename != nil {
+		statements = append(statements, saveStatement{"UPDATE characters SET force_rename=$1 WHERE id=$2", []interface{}{*fields.forceRename, charID}})
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement.query, statement.args...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// querier is a DB connection or transaction queries run on.
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// savedataDiff is a row of savedata_diffs.
+type savedataDiff struct {
+	id   int64
+	diff []byte
+}
+
+// loadSavedataDiffs returns the character's savedata diffs in the order they apply.
+func loadSavedataDiffs(q querier, charID uint32) ([]savedataDiff, error) {
+	rows, err := q.Query("SELECT id, diff FROM savedata_diffs WHERE char_id = $1 ORDER BY id", charID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var diffs []savedataDiff
+	for rows.Next() {
+		var diff savedataDiff
+		if err := rows.Scan(&diff.id, &diff.diff); err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs, rows.Err()
+}
+
+// applySavedataDiffs returns the savedata the diffs give applied in order onto the stored one. Each diff
+// only holds onto the savedata before it, so once one doesn't apply, damaged or taken against another
+// savedata, none after it do either: the stored savedata is returned with the error, falling back to the
+// last one written whole.
+func applySavedataDiffs(base []byte, diffs []savedataDiff) ([]byte, error) {
+	data := base
+	for _, diff := range diffs {
+		next, err := blockdelta.Apply(data, diff.diff)
+		if err != nil {
+			return base, fmt.Errorf("savedata diff %d: %w", diff.id, err)
+		}
+		data = next
+	}
+	return data, nil
+}
+
+// ConsolidateSavedata writes the character's savedata whole, with its diffs applied, and drops the diffs,
+// for the jobs that read or replace characters.savedata outside of a save. It returns whether the diffs
+// didn't apply, leaving the savedata last written whole. A save racing it stays intact: its diff was
+// taken against the savedata written here, or is newer than the diffs dropped.
+func ConsolidateSavedata(tx *sql.Tx, charID uint32) (bool, error) {
+	var compressed []byte
+	if err := tx.QueryRow("SELECT savedata FROM characters WHERE id = $1 FOR UPDATE", charID).Scan(&compressed); err != nil {
+		return false, err
+	}
+	diffs, err := loadSavedataDiffs(tx, charID)
+	if err != nil || len(diffs) == 0 {
+		return false, err
+	}
+	var applyErr error
+	if compressed != nil {
+		base, err := nullcomp.Decompress(compressed)
+		if err != nil {
+			return false, err
+		}
+		var data []byte
+		if data, applyErr = applySavedataDiffs(base, diffs); applyErr == nil {
+			if compressed, err = nullcomp.Compress(data); err != nil {
+				return false, err
+			}
+			if _, err := tx.Exec("UPDATE characters SET savedata = $1 WHERE id = $2", compressed, charID); err != nil {
+				return false, err
+			}
+		}
+	}
+	_, err = tx.Exec("DELETE FROM savedata_diffs WHERE char_id = $1 AND id <= $2", charID, diffs[len(diffs)-1].id)
+	return applyErr != nil, err
+}
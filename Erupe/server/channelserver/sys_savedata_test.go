@@ -0,0 +1,364 @@
+package channelserver
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/Solenataris/Erupe/common/testdb"
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/server/channelserver/compression/blockdelta"
+	"github.com/Solenataris/Erupe/server/channelserver/compression/nullcomp"
+)
+
+func TestNewSavedataRefusesTruncated(t *testing.T) {
+	save := bytes.Repeat([]byte{1, 0, 0, 2}, MinSaveDataSize/4+1)
+	compressed, err := nullcomp.Compress(save)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := newSavedata(2, compressed, nil, config.SavedataQuota{}); err != nil || !bytes.Equal(got, save) {
+		t.Fatalf("a whole savedata was refused with %v", err)
+	}
+
+	for _, payload := range [][]byte{compressed[:len(compressed)/2], compressed[:10], nil} {
+		if _, err := newSavedata(2, payload, nil, config.SavedataQuota{}); err == nil {
+			t.Errorf("an upload of %d bytes cut from %d was accepted", len(payload), len(compressed))
+		}
+	}
+	if _, err := newSavedata(2, make([]byte, MaxSaveDataSize+1), nil, config.SavedataQuota{}); err == nil {
+		t.Error("an oversized savedata was accepted")
+	}
+	// A diff can't leave a character with no savedata to one that's too short.
+	if _, err := newSavedata(1, []byte{0x01, 0x02, 0xAA}, nil, config.SavedataQuota{}); err == nil {
+		t.Error("a diff onto no savedata was accepted")
+	}
+}
+
+func TestNewSavedataQuota(t *testing.T) {
+	save := bytes.Repeat([]byte{1, 0, 0, 2}, MinSaveDataSize/4+1)
+	compressed, err := nullcomp.Compress(save)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newSavedata(2, compressed, nil, config.SavedataQuota{MinSize: len(save) + 1, MaxSize: MaxSaveDataSize}); err == nil {
+		t.Error("a savedata under the quota's min size was accepted")
+	}
+	if _, err := newSavedata(2, compressed, nil, config.SavedataQuota{MaxSize: len(save) - 1}); err == nil {
+		t.Error("a savedata over the quota's max size was accepted")
+	}
+	if _, err := newSavedata(2, compressed, nil, config.SavedataQuota{MinSize: 1, MaxSize: len(save)}); err != nil {
+		t.Errorf("a savedata within the quota was refused with %v", err)
+	}
+}
+
+func TestCheckSavedataSize(t *testing.T) {
+	previous := make([]byte, 1000)
+	quota := config.SavedataQuota{ChangePercent: 25}
+	tests := []struct {
+		size    int
+		anomaly bool
+	}{
+		{1000, false},
+		{1250, false},
+		{1251, true},
+		{750, false},
+		{749, true},
+		{100000, true},
+	}
+	for _, tt := range tests {
+		if got := checkSavedataSize(previous, make([]byte, tt.size), quota); (got != nil) != tt.anomaly {
+			t.Errorf("a savedata of %d bytes after %d flagged %v, want %v", tt.size, len(previous), got != nil, tt.anomaly)
+		}
+	}
+	if got := checkSavedataSize(nil, make([]byte, 100000), quota); got != nil {
+		t.Error("a character's first savedata was flagged")
+	}
+	if got := checkSavedataSize(previous, make([]byte, 5000), config.SavedataQuota{}); got != nil {
+		t.Error("a savedata was flagged without a change percent")
+	}
+}
+
+func TestSavedataAnomalyKeepsPrevious(t *testing.T) {
+	previous := make([]byte, 1000)
+	anomaly := checkSavedataSize(previous, make([]byte, 10), config.SavedataQuota{ChangePercent: 25})
+	if anomaly == nil || anomaly.keptPrevious || anomaly.previousSize != 1000 || anomaly.size != 10 {
+		t.Fatalf("got anomaly %+v, want one storing the shrunken savedata", anomaly)
+	}
+	anomaly = checkSavedataSize(previous, make([]byte, 10), config.SavedataQuota{ChangePercent: 25, KeepPrevious: true})
+	if anomaly == nil || !anomaly.keptPrevious {
+		t.Fatalf("got anomaly %+v, want one keeping the previous savedata", anomaly)
+	}
+	tx := &fakeSaveTx{}
+	if err := recordSavedataAnomaly(tx, 7, anomaly); err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.queries) != 1 || !strings.HasPrefix(tx.queries[0], "INSERT INTO save_anomalies") || tx.args[0][0] != uint32(7) || tx.args[0][3] != true {
+		t.Errorf("the anomaly was recorded with %q %v", tx.queries, tx.args)
+	}
+}
+
+// fakeSaveTx fails the statement at failAt, counting from 1, and records how it ended.
+type fakeSaveTx struct {
+	queries    []string
+	args       [][]interface{}
+	failAt     int
+	committed  bool
+	rolledBack bool
+}
+
+func (f *fakeSaveTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	f.queries = append(f.queries, query)
+	f.args = append(f.args, args)
+	if len(f.queries) == f.failAt {
+		return nil, errors.New("statement failed")
+	}
+	return nil, nil
+}
+
+func (f *fakeSaveTx) Commit() error {
+	f.committed = true
+	return nil
+}
+
+func (f *fakeSaveTx) Rollback() error {
+	f.rolledBack = true
+	return nil
+}
+
+func TestWriteSavedataRollsBack(t *testing.T) {
+	fields := savedataFields{name: "Hunter", hrp: 7}
+	tx := &fakeSaveTx{}
+	if err := writeSavedata(tx, 1, savedataWrite{whole: []byte{1}}, fields, 3); err != nil {
+		t.Fatal(err)
+	}
+	if !tx.committed || tx.rolledBack || len(tx.queries) != 5 {
+		t.Fatalf("a save ran %d statements, committed %v, rolled back %v", len(tx.queries), tx.committed, tx.rolledBack)
+	}
+	if !strings.HasPrefix(tx.queries[0], "INSERT INTO savedata_backups") || tx.args[1][1] != 3 {
+		t.Errorf("the previous savedata wasn't backed up keeping 3 first: %q %v", tx.queries[0], tx.args[1])
+	}
+
+	// The fields written after the savedata fail, the savedata mustn't be committed without them.
+	tx = &fakeSaveTx{failAt: 5}
+	if err := writeSavedata(tx, 1, savedataWrite{whole: []byte{1}}, fields, 3); err == nil {
+		t.Fatal("a failed field update wasn't reported")
+	}
+	if tx.committed || !tx.rolledBack {
+		t.Errorf("a failed field update committed %v, rolled back %v", tx.committed, tx.rolledBack)
+	}
+
+	// A save giving a new name sets whether the character has to be renamed.
+	refused := true
+	tx = &fakeSaveTx{}
+	if err := writeSavedata(tx, 1, savedataWrite{whole: []byte{1}}, savedataFields{name: "Al", forceRename: &refused}, 3); err != nil {
+		t.Fatal(err)
+	}
+	if last := len(tx.queries) - 1; len(tx.queries) != 6 || !strings.Contains(tx.queries[last], "force_rename") || tx.args[last][0] != true {
+		t.Errorf("a refused name ran %q", tx.queries)
+	}
+
+	tx = &fakeSaveTx{}
+	if err := writeSavedata(tx, 1, savedataWrite{whole: []byte{1}}, fields, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range tx.queries {
+		if strings.Contains(query, "savedata_backups") {
+			t.Errorf("a save keeping no backups ran %q", query)
+		}
+	}
+
+	// A diff leaves the stored savedata, its diffs and the backups be.
+	tx = &fakeSaveTx{}
+	if err := writeSavedata(tx, 1, savedataWrite{diff: []byte{1}}, fields, 3); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(tx.queries[0], "INSERT INTO savedata_diffs") {
+		t.Errorf("a diff save started with %q", tx.queries[0])
+	}
+	for _, query := range tx.queries {
+		if strings.Contains(query, "savedata_backups") || strings.Contains(query, "savedata=") || strings.HasPrefix(query, "DELETE") {
+			t.Errorf("a diff save ran %q", query)
+		}
+	}
+}
+
+// savedataChain returns a savedata and the saves after it, each changing a few bytes like a hunt does,
+// and their diffs.
+func savedataChain(saves int) ([]byte, [][]byte, []savedataDiff) {
+	r := rand.New(rand.NewSource(1))
+	base := make([]byte, 0x20000)
+	r.Read(base[:len(base)/4])
+	data := base
+	var states [][]byte
+	var diffs []savedataDiff
+	for i := 0; i < saves; i++ {
+		next := append([]byte(nil), data...)
+		for j := 0; j < 8; j++ {
+			next[r.Intn(len(next))] = byte(r.Intn(256))
+		}
+		states = append(states, next)
+		diffs = append(diffs, savedataDiff{int64(i + 1), blockdelta.Diff(data, next)})
+		data = next
+	}
+	return base, states, diffs
+}
+
+func TestApplySavedataDiffsFallsBack(t *testing.T) {
+	base, states, diffs := savedataChain(5)
+	got, err := applySavedataDiffs(base, diffs)
+	if err != nil || !bytes.Equal(got, states[4]) {
+		t.Fatalf("the diffs gave another savedata than the last save's, %v", err)
+	}
+
+	// A diff damaged in storage is caught, and none of the chain is applied past the base.
+	diffs[2].diff = append([]byte(nil), diffs[2].diff...)
+	diffs[2].diff[len(diffs[2].diff)-1] ^= 0x01
+	got, err = applySavedataDiffs(base, diffs)
+	if !errors.Is(err, blockdelta.ErrCorrupt) {
+		t.Errorf("a damaged diff got %v, want %v", err, blockdelta.ErrCorrupt)
+	}
+	if !bytes.Equal(got, base) {
+		t.Error("a damaged chain didn't fall back to the savedata last written whole")
+	}
+
+	// A lost diff leaves the rest taken against another savedata.
+	_, _, diffs = savedataChain(5)
+	if _, err := applySavedataDiffs(base, append(diffs[:1:1], diffs[2:]...)); !errors.Is(err, blockdelta.ErrWrongBase) {
+		t.Errorf("a chain missing a diff got %v, want %v", err, blockdelta.ErrWrongBase)
+	}
+}
+
+// BenchmarkSavedataWrite reports the bytes a save cycle of 10 saves writes for the savedata, written
+// whole every time or as diffs consolidated after each 10.
+func BenchmarkSavedataWrite(b *testing.B) {
+	base, states, _ := savedataChain(10)
+	b.Run("whole", func(b *testing.B) {
+		var written int
+		for i := 0; i < b.N; i++ {
+			for _, state := range states {
+				compressed, _ := nullcomp.Compress(state)
+				written += len(compressed)
+			}
+		}
+		b.ReportMetric(float64(written)/float64(b.N), "bytes/cycle")
+	})
+	b.Run("diffs", func(b *testing.B) {
+		var written int
+		for i := 0; i < b.N; i++ {
+			previous := base
+			for _, state := range states[:len(states)-1] {
+				written += len(blockdelta.Diff(previous, state))
+				previous = state
+			}
+			compressed, _ := nullcomp.Compress(states[len(states)-1])
+			written += len(compressed)
+		}
+		b.ReportMetric(float64(written)/float64(b.N), "bytes/cycle")
+	})
+}
+
+// TestSavedataBackupRotation runs against the database in ERUPE_TEST_DB, like TestShutdownCommitsPendingSave.
+func TestSavedataBackupRotation(t *testing.T) {
+	db := testdb.Open(t)
+
+	var userID, charID uint32
+	err := db.QueryRow("INSERT INTO users (username, password) VALUES ('backup_test', '') RETURNING id").Scan(&userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+	err = db.QueryRow("INSERT INTO characters (user_id, is_new_character, name) VALUES ($1, false, 'backup') RETURNING id", userID).Scan(&charID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM characters WHERE id = $1", charID)
+	defer db.Exec("DELETE FROM savedata_backups WHERE char_id = $1", charID)
+
+	for i := byte(1); i <= 4; i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writeSavedata(tx, charID, savedataWrite{whole: []byte{i}}, savedataFields{name: "backup"}, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var backups [][]byte
+	if err := db.Select(&backups, "SELECT savedata FROM savedata_backups WHERE char_id = $1 ORDER BY id", charID); err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 || !bytes.Equal(backups[0], []byte{2}) || !bytes.Equal(backups[1], []byte{3}) {
+		t.Errorf("got backups %x, want the savedatas of the 2nd and 3rd saves", backups)
+	}
+}
+
+// TestConsolidateSavedata runs against the database in ERUPE_TEST_DB, like TestShutdownCommitsPendingSave.
+func TestConsolidateSavedata(t *testing.T) {
+	db := testdb.Open(t)
+
+	base, states, diffs := savedataChain(3)
+	compressed, err := nullcomp.Compress(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var userID, charID uint32
+	err = db.QueryRow("INSERT INTO users (username, password) VALUES ('diff_test', '') RETURNING id").Scan(&userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+	err = db.QueryRow("INSERT INTO characters (user_id, is_new_character, name, savedata) VALUES ($1, false, 'diff', $2) RETURNING id", userID, compressed).Scan(&charID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM characters WHERE id = $1", charID)
+	defer db.Exec("DELETE FROM savedata_diffs WHERE char_id = $1", charID)
+
+	consolidate := func(diffs []savedataDiff) ([]byte, bool) {
+		for _, diff := range diffs {
+			if _, err := db.Exec("INSERT INTO savedata_diffs (char_id, diff) VALUES ($1, $2)", charID, diff.diff); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		broken, err := ConsolidateSavedata(tx, charID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		var left int
+		if err := db.QueryRow("SELECT COUNT(*) FROM savedata_diffs WHERE char_id = $1", charID).Scan(&left); err != nil || left != 0 {
+			t.Errorf("%d diffs left after consolidating, %v", left, err)
+		}
+		var stored []byte
+		if err := db.QueryRow("SELECT savedata FROM characters WHERE id = $1", charID).Scan(&stored); err != nil {
+			t.Fatal(err)
+		}
+		data, err := nullcomp.Decompress(stored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data, broken
+	}
+
+	// A damaged diff leaves the savedata last written whole.
+	damaged := append([]savedataDiff(nil), diffs...)
+	damaged[1].diff = append([]byte(nil), damaged[1].diff...)
+	damaged[1].diff[30] ^= 0xFF
+	if data, broken := consolidate(damaged); !broken || !bytes.Equal(data, base) {
+		t.Errorf("consolidating a damaged chain reported %v and didn't keep the base", broken)
+	}
+	if data, broken := consolidate(diffs); broken || !bytes.Equal(data, states[2]) {
+		t.Errorf("consolidating reported %v and didn't store the last save", broken)
+	}
+}
@@ -0,0 +1,315 @@
+package channelserver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// SeasonAt returns the season running at the given time and when it ends.
+// Seasons are numbered from 1, season 0 means the season pass is off or hasn't started yet.
+func SeasonAt(cfg config.SeasonPass, now time.Time) (season int, ends time.Time, err error) {
+	if !cfg.Enabled {
+		return 0, time.Time{}, nil
+	}
+	if cfg.SeasonDays <= 0 {
+		return 0, time.Time{}, errors.New("season length must be at least a day")
+	}
+	return periodAt(cfg.Start, cfg.SeasonDays, now)
+}
+
+// periodAt returns which of the back to back periods of the given days starting at start is running,
+// numbered from 1, and when it ends. Before start it's 0, ending at start.
+func periodAt(start string, days int, now time.Time) (int, time.Time, error) {
+	first, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if now.Before(first) {
+		return 0, first, nil
+	}
+	length := time.Duration(days) * 24 * time.Hour
+	elapsed := int(now.Sub(first) / length)
+	return elapsed + 1, first.Add(time.Duration(elapsed+1) * length), nil
+}
+
+// seasonClaim is one lane of a tier on the reward track.
+type seasonClaim struct {
+	Tier    int  `db:"tier"`
+	Premium bool `db:"premium"`
+}
+
+// claimableTiers returns the rewards the points reach that haven't been claimed yet.
+// Premium rewards are only included with the premium course, and lanes without items are skipped.
+func claimableTiers(tiers []config.SeasonPassTier, points int, premium bool, claimed []seasonClaim) []seasonClaim {
+	done := make(map[seasonClaim]bool, len(claimed))
+	for _, claim := range claimed {
+		done[claim] = true
+	}
+	var claims []seasonClaim
+	for i, tier := range tiers {
+		if tier.Points > points {
+			continue
+		}
+		if len(tier.Free) > 0 && !done[seasonClaim{i, false}] {
+			claims = append(claims, seasonClaim{i, false})
+		}
+		if premium && len(tier.Premium) > 0 && !done[seasonClaim{i, true}] {
+			claims = append(claims, seasonClaim{i, true})
+		}
+	}
+	return claims
+}
+
+// nextSeasonTier returns the points needed for the lowest tier the points don't reach yet, or 0 past the last tier.
+func nextSeasonTier(tiers []config.SeasonPassTier, points int) int {
+	next := 0
+	for _, tier := range tiers {
+		if tier.Points > points && (next == 0 || tier.Points < next) {
+			next = tier.Points
+		}
+	}
+	return next
+}
+
+// ClaimSeasonReward records a claimed reward for the character.
+// It returns false if the reward had already been claimed this season.
+func ClaimSeasonReward(db sqlx.Execer, charID uint32, season int, tier int, premium bool) (bool, error) {
+	res, err := db.Exec(`
+		INSERT INTO season_pass_claims (character_id, season, tier, premium)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING
+	`, charID, season, tier, premium)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// currentSeason returns the running season, logging an invalid schedule.
+func (s *Server) currentSeason() int {
+	season, _, err := SeasonAt(s.erupeConfig.SeasonPass, time.Now())
+	if err != nil {
+		s.logger.Error("Invalid season pass schedule", zap.Error(err))
+	}
+	return season
+}
+
+// addSeasonPoints adds event points to the session's character for the running season.
+func addSeasonPoints(s *Session, points int) {
+	season := s.server.currentSeason()
+	if season == 0 || points <= 0 {
+		return
+	}
+	_, err := s.server.db.Exec(`
+		INSERT INTO season_pass_points (character_id, season, points) VALUES ($1, $2, $3)
+		ON CONFLICT (character_id, season) DO UPDATE SET points = season_pass_points.points + $3
+	`, s.charID, season, points)
+	if err != nil {
+		s.logger.Error("Failed to add season points", zap.Error(err))
+	}
+}
+
+// awardDailySeasonPoints adds the daily points the first time it's called for the character each day.
+func awardDailySeasonPoints(s *Session) {
+	season := s.server.currentSeason()
+	points := s.server.erupeConfig.SeasonPass.DailyPoints
+	if season == 0 || points <= 0 {
+		return
+	}
+	_, err := s.server.db.Exec(`
+		INSERT INTO season_pass_points (character_id, season, points, daily_claimed) VALUES ($1, $2, $3, current_date)
+		ON CONFLICT (character_id, season) DO UPDATE SET points = season_pass_points.points + $3, daily_claimed = current_date
+		WHERE season_pass_points.daily_claimed IS DISTINCT FROM current_date
+	`, s.charID, season, points)
+	if err != nil {
+		s.logger.Error("Failed to add daily season points", zap.Error(err))
+	}
+}
+
+// isEventQuest reports whether the quest file earns event quest points.
+func (s *Server) isEventQuest(filename string) bool {
+	for _, quest := range s.erupeConfig.SeasonPass.EventQuests {
+		if quest == filename {
+			return true
+		}
+	}
+	return false
+}
+
+// recordQuestReturn credits what the quest the session gets back from earns, the event quest points, the
+// festa souls and the share of a guild treasure run. The server doesn't see whether the quest was cleared,
+// so any return from it counts.
+func recordQuestReturn(s *Session) {
+	s.Lock()
+	filename, run := s.questFile, s.treasureRun
+	s.questFile = ""
+	s.treasureRun = nil
+	s.Unlock()
+	if filename == "" {
+		return
+	}
+	if s.server.isEventQuest(filename) {
+		addSeasonPoints(s, s.server.erupeConfig.SeasonPass.EventQuestPoints)
+	}
+	earnFestaSouls(s, filename)
+	earnTreasureShare(s, filename, run)
+}
+
+// hasPremiumSeasonPass reports whether the session holds the course that unlocks the premium lane.
+func (s *Session) hasPremiumSeasonPass() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.rights&(1<<s.server.erupeConfig.SeasonPass.PremiumCourse) != 0
+}
+
+// seasonProgress loads the character's points and claimed rewards for the season.
+func seasonProgress(db *sqlx.DB, charID uint32, season int) (int, []seasonClaim, error) {
+	var points int
+	err := db.QueryRow("SELECT COALESCE(SUM(points), 0) FROM season_pass_points WHERE character_id = $1 AND season = $2", charID, season).Scan(&points)
+	if err != nil {
+		return 0, nil, err
+	}
+	var claimed []seasonClaim
+	err = db.Select(&claimed, "SELECT tier, premium FROM season_pass_claims WHERE character_id = $1 AND season = $2", charID, season)
+	return points, claimed, err
+}
+
+// handleSeasonCommand runs the season pass chat commands. "!season" shows the character's progress
+// and "!season claim" sends every reward it has reached to the distribution counter.
+func handleSeasonCommand(s *Session, message string) {
+	message = strings.TrimSpace(message)
+	if message != "!season" && message != "!season claim" {
+		sendServerChatMessage(s, "Usage: \"!season\" or \"!season claim\"")
+		return
+	}
+	cfg := s.server.erupeConfig.SeasonPass
+	season, ends, err := SeasonAt(cfg, time.Now())
+	if err != nil || season == 0 {
+		sendServerChatMessage(s, "No season is running.")
+		return
+	}
+
+	points, claimed, err := seasonProgress(s.server.db, s.charID, season)
+	if err != nil {
+		s.logger.Error("Failed to get season progress", zap.Error(err))
+		sendServerChatMessage(s, "Failed to get your season progress.")
+		return
+	}
+	premium := s.hasPremiumSeasonPass()
+	claims := claimableTiers(cfg.Tiers, points, premium, claimed)
+
+	if message == "!season" {
+		sendServerChatMessage(s, fmt.Sprintf("Season %d: %d points, ends %s.", season, points, ends.Format("2006-01-02 15:04 MST")))
+		if next := nextSeasonTier(cfg.Tiers, points); next > 0 {
+			sendServerChatMessage(s, fmt.Sprintf("Next reward at %d points.", next))
+		}
+		if !premium {
+			sendServerChatMessage(s, "Premium rewards need the premium course.")
+		}
+		if len(claims) > 0 {
+			sendServerChatMessage(s, fmt.Sprintf("%d reward(s) to claim, type \"!season claim\".", len(claims)))
+		}
+		return
+	}
+
+	sent := 0
+	for _, claim := range claims {
+		if claimSeasonTier(s, season, claim) {
+			sent++
+		}
+	}
+	if sent == 0 {
+		sendServerChatMessage(s, "No rewards to claim.")
+		return
+	}
+	sendServerChatMessage(s, fmt.Sprintf("Sent %d reward(s), collect them from the distribution counter.", sent))
+}
+
+// claimSeasonTier claims the reward and queues its distribution in one transaction.
+func claimSeasonTier(s *Session, season int, claim seasonClaim) bool {
+	tier := s.server.erupeConfig.SeasonPass.Tiers[claim.Tier]
+	rewards, lane := tier.Free, "free"
+	if claim.Premium {
+		rewards, lane = tier.Premium, "premium"
+	}
+
+	tx, err := s.server.db.Beginx()
+	if err != nil {
+		s.logger.Error("Failed to begin season reward claim", zap.Error(err))
+		return false
+	}
+	claimed, err := ClaimSeasonReward(tx, s.charID, season, claim.Tier, claim.Premium)
+	if err != nil || !claimed {
+		tx.Rollback()
+		if err != nil {
+			s.logger.Error("Failed to claim season reward", zap.Error(err))
+		}
+		return false
+	}
+
+	items := make([]DistItemEntry, len(rewards))
+	for i, item := range rewards {
+		items[i] = DistItemEntry{item.ItemType, item.ItemID, item.Quantity}
+	}
+	err = createCharacterDistribution(tx, s.charID, fmt.Sprintf("Season %d Reward", season), fmt.Sprintf("~C05Tier %d %s reward", claim.Tier+1, lane), items)
+	if err != nil {
+		tx.Rollback()
+		s.logger.Error("Failed to create season reward distribution", zap.Error(err))
+		return false
+	}
+	if err = tx.Commit(); err != nil {
+		s.logger.Error("Failed to commit season reward claim", zap.Error(err))
+		return false
+	}
+	return true
+}
+
+// scheduleSeasonReset starts the points and claims over when the running season ends.
+func (s *Server) scheduleSeasonReset() {
+	season, ends, err := SeasonAt(s.erupeConfig.SeasonPass, time.Now())
+	if err != nil {
+		s.logger.Error("Invalid season pass schedule", zap.Error(err))
+		return
+	}
+	if ends.IsZero() {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.isShuttingDown {
+		return
+	}
+	s.seasonResetTimer = time.AfterFunc(time.Until(ends), func() {
+		s.resetSeason(season + 1)
+		s.scheduleSeasonReset()
+	})
+}
+
+// stopSeasonReset cancels the pending season reset.
+func (s *Server) stopSeasonReset() {
+	s.Lock()
+	defer s.Unlock()
+	if s.seasonResetTimer != nil {
+		s.seasonResetTimer.Stop()
+	}
+}
+
+// resetSeason drops the points and claims of earlier seasons and announces the new one.
+func (s *Server) resetSeason(season int) {
+	for _, table := range []string{"season_pass_points", "season_pass_claims"} {
+		if _, err := s.db.Exec("DELETE FROM "+table+" WHERE season < $1", season); err != nil {
+			s.logger.Error("Failed to reset season pass", zap.Error(err), zap.String("table", table))
+		}
+	}
+	s.BroadcastChatMessage(fmt.Sprintf("Season %d has begun, event points start over.", season))
+}
@@ -0,0 +1,68 @@
+package channelserver
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+)
+
+func TestSeasonAt(t *testing.T) {
+	cfg := config.SeasonPass{Enabled: true, Start: "2022-06-01T00:00:00Z", SeasonDays: 28}
+	start := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		now        time.Time
+		wantSeason int
+		wantEnds   time.Time
+	}{
+		{"before the first season", start.Add(-time.Hour), 0, start},
+		{"first season", start, 1, start.AddDate(0, 0, 28)},
+		{"last moment of the first season", start.AddDate(0, 0, 28).Add(-time.Second), 1, start.AddDate(0, 0, 28)},
+		{"third season", start.AddDate(0, 0, 60), 3, start.AddDate(0, 0, 84)},
+	}
+	for _, tt := range tests {
+		season, ends, err := SeasonAt(cfg, tt.now)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if season != tt.wantSeason || !ends.Equal(tt.wantEnds) {
+			t.Errorf("%s: got season %d ending %v, want %d ending %v", tt.name, season, ends, tt.wantSeason, tt.wantEnds)
+		}
+	}
+
+	if season, _, err := SeasonAt(config.SeasonPass{Start: cfg.Start, SeasonDays: 28}, start); err != nil || season != 0 {
+		t.Errorf("disabled season pass got season %d, %v", season, err)
+	}
+	if _, _, err := SeasonAt(config.SeasonPass{Enabled: true, Start: cfg.Start}, start); err == nil {
+		t.Error("zero season length didn't fail")
+	}
+}
+
+func TestClaimableTiers(t *testing.T) {
+	item := []config.StarterItem{{ItemType: 7, ItemID: 100, Quantity: 1}}
+	tiers := []config.SeasonPassTier{
+		{Points: 0, Free: item, Premium: item},
+		{Points: 100, Premium: item},
+		{Points: 200, Free: item, Premium: item},
+		{Points: 300, Free: item},
+	}
+	claimed := []seasonClaim{{0, false}}
+
+	got := claimableTiers(tiers, 250, false, claimed)
+	if want := []seasonClaim{{2, false}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("free lane got %v, want %v", got, want)
+	}
+	got = claimableTiers(tiers, 250, true, claimed)
+	if want := []seasonClaim{{0, true}, {1, true}, {2, false}, {2, true}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("premium lane got %v, want %v", got, want)
+	}
+
+	if next := nextSeasonTier(tiers, 250); next != 300 {
+		t.Errorf("got next tier at %d, want 300", next)
+	}
+	if next := nextSeasonTier(tiers, 300); next != 0 {
+		t.Errorf("got next tier at %d past the last tier, want 0", next)
+	}
+}
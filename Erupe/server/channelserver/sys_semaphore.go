@@ -1,10 +1,14 @@
 package channelserver
 
 import (
+	"strings"
+
 	"github.com/Andoryuuta/byteframe"
 	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"go.uber.org/zap"
 
 	"sync"
+	"time"
 )
 
 // Stage holds stage-specific information
@@ -23,6 +27,16 @@ type Semaphore struct {
 
 	// Max Players for Semaphore
 	maxPlayers uint16
+
+	// Semaphore handle acked to the clients, also the ID of its register.
+	handle uint32
+
+	// When the semaphore was first found without a connected holder, zero while it has one.
+	unownedSince time.Time
+
+	// Set once the semaphore has been removed from the server semaphore map.
+	// Sessions holding a stale pointer must not acquire it.
+	removed bool
 }
 
 // NewStage creates a new stage with intialized values.
@@ -37,6 +51,8 @@ func NewSemaphore(ID string, MaxPlayers uint16) *Semaphore {
 }
 
 func (s *Semaphore) BroadcastRavi(pkt mhfpacket.MHFPacket) {
+	s.RLock()
+	defer s.RUnlock()
 	// Broadcast the data.
 	for session := range s.clients {
 
@@ -53,8 +69,15 @@ func (s *Semaphore) BroadcastRavi(pkt mhfpacket.MHFPacket) {
 	}
 }
 
-// BroadcastMHF queues a MHFPacket to be sent to all sessions in the stage.
+// BroadcastMHF queues a MHFPacket to be sent to all sessions in the semaphore.
 func (s *Semaphore) BroadcastMHF(pkt mhfpacket.MHFPacket, ignoredSession *Session) {
+	s.RLock()
+	defer s.RUnlock()
+	s.broadcastMHFLocked(pkt, ignoredSession)
+}
+
+// broadcastMHFLocked is BroadcastMHF for callers that already hold the semaphore lock.
+func (s *Semaphore) broadcastMHFLocked(pkt mhfpacket.MHFPacket, ignoredSession *Session) {
 	// Broadcast the data.
 	for session := range s.clients {
 		if session == ignoredSession {
@@ -71,4 +94,179 @@ func (s *Semaphore) BroadcastMHF(pkt mhfpacket.MHFPacket, ignoredSession *Sessio
 		// Enqueue in a non-blocking way that drops the packet if the connections send buffer channel is full.
 		session.QueueSendNonBlocking(bf.Data())
 	}
-}
\ No newline at end of file
+}
+
+// release frees the session's slot and tells the remaining holders to refresh the register.
+// It reports whether the semaphore has no holders left.
+func (s *Semaphore) release(session *Session) bool {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.clients, session)
+	delete(s.reservedClientSlots, session.charID)
+	if len(s.reservedClientSlots) > 0 {
+		s.broadcastMHFLocked(&mhfpacket.MsgSysNotifyRegister{RegisterID: s.handle}, nil)
+		return false
+	}
+	return true
+}
+
+// raviSemaphorePrefixes start the semaphore IDs of the three Raviente instances. The digit that
+// follows picks the semaphore: 3 is the main one, 4 and 5 are the support ones.
+var raviSemaphorePrefixes = []string{"hs_l0u3B51J9k", "hs_l0u3B5129k", "hs_l0u3B512Ak"}
+
+// raviSemaphorePart returns the digit picking the Raviente semaphore, or 0 if the ID isn't one of them.
+func raviSemaphorePart(id string) byte {
+	for _, prefix := range raviSemaphorePrefixes {
+		if len(id) == len(prefix)+1 && strings.HasPrefix(id, prefix) {
+			if part := id[len(prefix)]; part >= '3' && part <= '5' {
+				return part
+			}
+		}
+	}
+	return 0
+}
+
+// isRaviSemaphoreID reports whether the semaphore is the main one of a Raviente instance.
+func isRaviSemaphoreID(id string) bool {
+	return raviSemaphorePart(id) == '3'
+}
+
+// getSemaphore returns the semaphore with the given ID, if it exists.
+func (s *Server) getSemaphore(id string) (*Semaphore, bool) {
+	s.semaphoreLock.RLock()
+	defer s.semaphoreLock.RUnlock()
+	semaphore, ok := s.semaphore[id]
+	return semaphore, ok
+}
+
+// getOrCreateSemaphore returns the semaphore with the given ID, creating it if it doesn't exist yet.
+// Raviente semaphores are shared by up to 32 holders, any other has a single slot.
+func (s *Server) getOrCreateSemaphore(id string) *Semaphore {
+	s.semaphoreLock.Lock()
+	defer s.semaphoreLock.Unlock()
+	if semaphore, ok := s.semaphore[id]; ok {
+		return semaphore
+	}
+	maxPlayers := uint16(1)
+	if strings.HasPrefix(id, "hs_l0u3B51") {
+		maxPlayers = 32
+	}
+	semaphore := NewSemaphore(id, maxPlayers)
+	s.semaphore[id] = semaphore
+	return semaphore
+}
+
+// semaphoreList returns the semaphores, to walk without holding the semaphore map lock.
+func (s *Server) semaphoreList() []*Semaphore {
+	s.semaphoreLock.RLock()
+	defer s.semaphoreLock.RUnlock()
+	semaphores := make([]*Semaphore, 0, len(s.semaphore))
+	for _, semaphore := range s.semaphore {
+		semaphores = append(semaphores, semaphore)
+	}
+	return semaphores
+}
+
+// removeSemaphoreIfEmpty removes the semaphore if it's still the one registered under the ID
+// and nobody acquired it in the meantime.
+func (s *Server) removeSemaphoreIfEmpty(id string, semaphore *Semaphore) {
+	s.semaphoreLock.Lock()
+	defer s.semaphoreLock.Unlock()
+	if s.semaphore[id] != semaphore {
+		return
+	}
+	semaphore.Lock()
+	defer semaphore.Unlock()
+	if len(semaphore.reservedClientSlots) == 0 {
+		semaphore.removed = true
+		delete(s.semaphore, id)
+	}
+}
+
+// raviSemaphore returns the main semaphore of the Raviente instance in progress, or nil if there's none.
+func (s *Server) raviSemaphore() *Semaphore {
+	s.semaphoreLock.RLock()
+	defer s.semaphoreLock.RUnlock()
+	for _, prefix := range raviSemaphorePrefixes {
+		if semaphore, ok := s.semaphore[prefix+"3"]; ok {
+			return semaphore
+		}
+	}
+	return nil
+}
+
+// reclaimSemaphores removes semaphores that have had no connected holder for longer than the
+// configured TTL, in case a holder's disconnect wasn't cleaned up.
+func (s *Server) reclaimSemaphores(now time.Time) {
+	ttl := time.Duration(s.erupeConfig.Channel.SemaphoreTTL) * time.Second
+	if ttl <= 0 {
+		return
+	}
+
+	online := make(map[uint32]bool)
+	s.Lock()
+	for _, session := range s.sessions {
+		online[session.charID] = true
+	}
+	s.Unlock()
+
+	resetRaviente := false
+	s.semaphoreLock.Lock()
+	for id, semaphore := range s.semaphore {
+		semaphore.Lock()
+		owned := false
+		for charID := range semaphore.reservedClientSlots {
+			if online[charID] {
+				owned = true
+				break
+			}
+		}
+		if owned {
+			semaphore.unownedSince = time.Time{}
+		} else if semaphore.unownedSince.IsZero() {
+			semaphore.unownedSince = now
+		} else if now.Sub(semaphore.unownedSince) >= ttl {
+			semaphore.removed = true
+			delete(s.semaphore, id)
+			s.logger.Info("Reclaimed semaphore without holders", zap.String("semaphoreID", id))
+			if isRaviSemaphoreID(id) {
+				resetRaviente = true
+			}
+		}
+		semaphore.Unlock()
+	}
+	s.semaphoreLock.Unlock()
+
+	// The Raviente lock comes before the semaphore locks, so it's only taken once they're released.
+	if resetRaviente {
+		s.raviente.Lock()
+		endRavienteWindow(s)
+		s.raviente.Unlock()
+	}
+}
+
+// scheduleSemaphoreReclaim checks for semaphores to reclaim every half TTL.
+func (s *Server) scheduleSemaphoreReclaim() {
+	ttl := time.Duration(s.erupeConfig.Channel.SemaphoreTTL) * time.Second
+	if ttl <= 0 {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.isShuttingDown {
+		return
+	}
+	s.semaphoreReclaimTimer = time.AfterFunc(ttl/2, func() {
+		s.reclaimSemaphores(time.Now())
+		s.scheduleSemaphoreReclaim()
+	})
+}
+
+// stopSemaphoreReclaim cancels the pending semaphore reclaim.
+func (s *Server) stopSemaphoreReclaim() {
+	s.Lock()
+	defer s.Unlock()
+	if s.semaphoreReclaimTimer != nil {
+		s.semaphoreReclaimTimer.Stop()
+	}
+}
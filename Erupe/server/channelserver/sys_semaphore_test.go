@@ -0,0 +1,139 @@
+package channelserver
+
+import (
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+)
+
+func newSemaphoreTestSession(t *testing.T, s *Server, charID uint32) *Session {
+	session, client := newTestSession(s)
+	session.charID = charID
+	s.Lock()
+	s.sessions[session.rawConn] = session
+	s.Unlock()
+	go io.Copy(ioutil.Discard, client)
+	t.Cleanup(func() { client.Close() })
+	return session
+}
+
+func acquireSemaphore(session *Session, semaphoreID string) {
+	handleMsgSysCreateAcquireSemaphore(session, &mhfpacket.MsgSysCreateAcquireSemaphore{SemaphoreID: semaphoreID})
+}
+
+func TestSemaphoreReleasedOnDisconnect(t *testing.T) {
+	s := newTestServer()
+	host := newSemaphoreTestSession(t, s, 1)
+	member := newSemaphoreTestSession(t, s, 2)
+
+	// A shared semaphore stays up for the holders that are still connected.
+	acquireSemaphore(host, "hs_l0u3B51J9k3")
+	acquireSemaphore(member, "hs_l0u3B51J9k3")
+	// A single slot semaphore is freed for the next group.
+	acquireSemaphore(host, "hs_hostonly")
+
+	logoutPlayer(host)
+
+	shared, ok := s.semaphore["hs_l0u3B51J9k3"]
+	if !ok {
+		t.Fatal("semaphore with a remaining holder was removed")
+	}
+	if _, held := shared.reservedClientSlots[host.charID]; held {
+		t.Error("disconnected session still holds a slot in the shared semaphore")
+	}
+	if _, held := shared.reservedClientSlots[member.charID]; !held {
+		t.Error("remaining session lost its slot in the shared semaphore")
+	}
+	if _, ok := s.semaphore["hs_hostonly"]; ok {
+		t.Fatal("semaphore without holders was not removed")
+	}
+
+	acquireSemaphore(member, "hs_hostonly")
+	if _, held := s.semaphore["hs_hostonly"].reservedClientSlots[member.charID]; !held {
+		t.Error("released semaphore could not be acquired again")
+	}
+}
+
+func TestSemaphoreReclaimedAfterTTL(t *testing.T) {
+	s := newTestServer()
+	s.erupeConfig.Channel.SemaphoreTTL = 60
+	holder := newSemaphoreTestSession(t, s, 1)
+	acquireSemaphore(holder, "hs_live")
+
+	// A holder that went away without its disconnect being cleaned up.
+	stale := NewSemaphore("hs_stale", 1)
+	stale.reservedClientSlots[99] = nil
+	s.semaphore["hs_stale"] = stale
+
+	now := time.Now()
+	s.reclaimSemaphores(now)
+	s.reclaimSemaphores(now.Add(30 * time.Second))
+	if _, ok := s.semaphore["hs_stale"]; !ok {
+		t.Fatal("semaphore was reclaimed before its TTL")
+	}
+	s.reclaimSemaphores(now.Add(61 * time.Second))
+	if _, ok := s.semaphore["hs_stale"]; ok {
+		t.Error("semaphore without a connected holder was not reclaimed after its TTL")
+	}
+	if _, ok := s.semaphore["hs_live"]; !ok {
+		t.Error("semaphore with a connected holder was reclaimed")
+	}
+}
+
+func TestSemaphoreConcurrentAccess(t *testing.T) {
+	s := newTestServer()
+	s.erupeConfig.Channel.SemaphoreTTL = 60
+	var sessions []*Session
+	for charID := uint32(1); charID <= 8; charID++ {
+		sessions = append(sessions, newSemaphoreTestSession(t, s, charID))
+	}
+
+	// Sessions keep acquiring, deleting and releasing semaphores and broadcasting to the Raviente
+	// while the reclaim walks them all.
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	go func() {
+		now := time.Now()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				now = now.Add(time.Minute)
+				s.reclaimSemaphores(now)
+			}
+		}
+	}()
+	for i, session := range sessions {
+		wg.Add(1)
+		go func(session *Session, rng *rand.Rand) {
+			defer wg.Done()
+			for n := 0; n < 200; n++ {
+				switch rng.Intn(5) {
+				case 0:
+					acquireSemaphore(session, "hs_l0u3B51J9k3")
+				case 1:
+					acquireSemaphore(session, "hs_solo")
+					handleMsgSysCheckSemaphore(session, &mhfpacket.MsgSysCheckSemaphore{StageID: "hs_solo"})
+				case 2:
+					handleMsgSysDeleteSemaphore(session, &mhfpacket.MsgSysDeleteSemaphore{AckHandle: 917533})
+					handleMsgSysDeleteSemaphore(session, &mhfpacket.MsgSysDeleteSemaphore{})
+				case 3:
+					if semaphore := s.raviSemaphore(); semaphore != nil {
+						semaphore.BroadcastMHF(&mhfpacket.MsgSysNotifyRegister{RegisterID: 1}, session)
+					}
+					session.notifyall()
+				case 4:
+					removeSessionFromSemaphore(session)
+				}
+			}
+		}(session, rand.New(rand.NewSource(int64(i))))
+	}
+	wg.Wait()
+	close(stop)
+}
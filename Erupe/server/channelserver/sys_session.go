@@ -2,25 +2,46 @@ package channelserver
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"io"
 	"net"
+	"reflect"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/common/bfutil"
 	"github.com/Solenataris/Erupe/common/stringstack"
 	"github.com/Solenataris/Erupe/common/stringsupport"
+	"github.com/Solenataris/Erupe/common/tracing"
 	"github.com/Solenataris/Erupe/network"
 	"github.com/Solenataris/Erupe/network/clientctx"
 	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"github.com/Solenataris/Erupe/network/packetcapture"
 	"go.uber.org/zap"
 	"golang.org/x/text/encoding/japanese"
 )
 
+// Send queue defaults used when the config leaves them unset.
+const (
+	defaultSendQueueSize    = 256
+	defaultSendStallTimeout = 5 * time.Second
+)
+
+func sendQueueSize(configured int) int {
+	if configured > 0 {
+		return configured
+	}
+	return defaultSendQueueSize
+}
+
 // Session holds state for the channel server connection.
 type Session struct {
 	sync.Mutex
-	logger        *zap.Logger
+	logger        *zap.Logger // Tags entries with the session's character and stage, see updateLogFields.
+	packetLogger  *zap.Logger // Logs the packet dumps of DevModeOptions, at debug.
+	logFields     atomic.Value
 	server        *Server
 	rawConn       net.Conn
 	cryptConn     *network.CryptConn
@@ -30,12 +51,55 @@ type Session struct {
 	stageID          string
 	stage            *Stage
 	reservationStage *Stage // Required for the stateful MsgSysUnreserveStage packet.
+	reservationQueue *Stage // Full stage the session is queued to reserve a slot in.
 	charID           uint32
+	userID           uint32 // Account of the character, set with it.
+	revoked          int32  // Set once the account was revoked and the session is being disconnected, see RevokeSessions.
+	disconnectReason int32  // DisconnectReason the session was closed with, 0 while it's connected. Accessed atomically.
 	logKey           []byte
 	sessionStart     int64
 	rights           uint32
+	moderator        bool      // The account may run moderator commands such as "!tele <player>", and gets the login messages meant for moderators.
+	admin            bool      // The account may run admin commands such as "!give", and the moderator ones.
+	newcomerUntil    time.Time // When the newcomer boosts of the account run out.
+	minidata         []byte    // Enhanced minidata the client last saved, nil until it saves it.
+	festaTeam        FestaTeam // Festa team shown above the character, valid while festaTeamCached is set.
+	festaTeamCached  bool
+	loginTime        time.Time // When the character logged in, to spot rank resets that ran since.
+	presenceKey      string    // Session's row in online_characters, see sys_presence.go.
+	signToken        uint32    // Sign in token the session holds, see sys_sign_token.go.
+	lastLogin        time.Time // When the character had logged in before, zero for never.
+	firstLogin       bool      // The character is logging in for the first time.
+	forceRename      bool      // The character's name was refused by the name rules, see sys_names.go.
+	logoutOnce       sync.Once // Guards logoutPlayer, which can be reached from the recv loop and shutdown.
+	loginMessage     sync.Once // Guards sendLoginMessage, sent on the first stage entry.
+	rentals          []Rental  // Lent equipment, see sys_rental.go.
+	returnedRentals  int       // Rentals taken back before the first stage entry, told once it's made.
+	departedAt       time.Time // When the session left for the quest it's on, zero in town.
+	questFile        string    // Last quest file the session loaded, for event quest points.
+
+	// The treasure quest the session departed on, see sys_guild_treasure.go.
+	treasureRun *treasureRun
+	// Closed once logoutPlayer is done and the character's data is saved, see disconnectDuplicates.
+	loggedOut chan struct{}
+
+	semaphore  *Semaphore            // Required for the stateful MsgSysUnreserveStage packet.
+	semaphores map[string]*Semaphore // Every semaphore the session holds a slot in, released when it disconnects.
 
-	semaphore *Semaphore // Required for the stateful MsgSysUnreserveStage packet.
+	// Characters on the blacklist, see sys_blocklist.go.
+	blocked map[uint32]bool
+
+	// Invite into a quest party waiting for the character's answer, and whether it turned invites off,
+	// see sys_party_invite.go.
+	partyInvite  *partyInvite
+	doNotDisturb bool
+
+	// Settings version of each guild the session was last sent, see sawGuildSettings.
+	guildSettingsSeen map[uint32]uint32
+
+	// The account's shared item box, see sys_item_box.go.
+	itemBoxLock  sync.Mutex
+	itemBoxCache *itemBoxCache
 
 	// A stack containing the stage movement history (push on enter/move, pop on back)
 	stageMoveStack *stringstack.StringStack
@@ -47,6 +111,39 @@ type Session struct {
 	// Contains the mail list that maps accumulated indexes to mail IDs
 	mailList []int
 
+	// Bytes sent and received by this session.
+	bandwidth *BandwidthStats
+
+	// How long the client may stay silent before it's pinged, and then disconnected.
+	idleTimeout time.Duration
+	pingTimeout time.Duration
+
+	// Send queue backpressure, see queueFull.
+	sendStallTimeout time.Duration
+	queueFullSince   int64 // Unix nanoseconds the send queue was first found full, 0 while it has room. Accessed atomically.
+	sendStalled      int32 // Set once the session was dropped for a stalled send queue. Accessed atomically.
+
+	// Rate limits of the packets and chat messages the client sends, see sys_ratelimit.go.
+	packetBucket    tokenBucket
+	chatBucket      tokenBucket
+	chatFloodWarned bool
+	mutedUntil      time.Time // End of the account's chat mute, zero if it isn't muted, see sys_mute.go.
+	inWorld         int32     // Set on the character's first stage entry, ending the login burst. Accessed atomically.
+
+	handling uint32 // Opcode of the request being handled, see handlingOpcode. Accessed atomically.
+
+	// Bytes of the request being handled and the requests handled lately, see sys_retransmit.go.
+	handlingPacket []byte
+	recentRequests recentRequests
+
+	loginSpan *tracing.Span // Span of the login until the character enters the world, see sys_tracing.go.
+
+	deferredTasks sync.WaitGroup // Tasks of the session's requests still running, see deferTask.
+
+	capture *packetcapture.Writer // Every packet of the session when packet capture is on, see openPacketCapture.
+
+	loginCharID uint32 // Character the login names, packets are traced for it until the login is handled.
+
 	// For Debuging
 	Name string
 }
@@ -54,11 +151,10 @@ type Session struct {
 // NewSession creates a new Session type.
 func NewSession(server *Server, conn net.Conn) *Session {
 	s := &Session{
-		logger:      server.logger.Named(conn.RemoteAddr().String()),
 		server:      server,
 		rawConn:     conn,
 		cryptConn:   network.NewCryptConn(conn),
-		sendPackets: make(chan []byte, 20),
+		sendPackets: make(chan []byte, sendQueueSize(server.erupeConfig.Channel.SendQueueSize)),
 		clientContext: &clientctx.ClientContext{
 			StrConv: &stringsupport.StringConverter{
 				Encoding: japanese.ShiftJIS,
@@ -66,6 +162,18 @@ func NewSession(server *Server, conn net.Conn) *Session {
 		},
 		sessionStart: Time_Current_Adjusted().Unix(),
 		stageMoveStack: stringstack.New(),
+		semaphores:     make(map[string]*Semaphore),
+		loggedOut:      make(chan struct{}),
+		bandwidth:      &BandwidthStats{},
+		idleTimeout:    time.Duration(server.erupeConfig.Channel.IdleTimeout) * time.Second,
+		pingTimeout:    time.Duration(server.erupeConfig.Channel.PingTimeout) * time.Second,
+	}
+	s.logger = s.sessionLogger(server.logger)
+	s.packetLogger = s.sessionLogger(server.packetLogger)
+	s.updateLogFields()
+	s.sendStallTimeout = defaultSendStallTimeout
+	if server.erupeConfig.Channel.SendStallTimeout > 0 {
+		s.sendStallTimeout = time.Duration(server.erupeConfig.Channel.SendStallTimeout) * time.Second
 	}
 	return s
 }
@@ -74,6 +182,7 @@ func NewSession(server *Server, conn net.Conn) *Session {
 func (s *Session) Start() {
 	go func() {
 		s.logger.Info("Channel server got connection!", zap.String("remoteaddr", s.rawConn.RemoteAddr().String()))
+		s.openPacketCapture()
 		// Unlike the sign and entrance server,
 		// the client DOES NOT initalize the channel connection with 8 NULL bytes.
 		go s.sendLoop()
@@ -81,26 +190,108 @@ func (s *Session) Start() {
 	}()
 }
 
-// QueueSend queues a packet (raw []byte) to be sent.
+// QueueSend queues a packet (raw []byte) to be sent. On a full queue it waits up to the stall timeout for room,
+// then disconnects the session, as the client can't carry on past a packet it was never sent, such as an ack.
 func (s *Session) QueueSend(data []byte) {
+	s.queueSend(data, []int{0})
+}
+
+// queueSend queues the packets starting at each of the offsets of data, sent together in one frame.
+func (s *Session) queueSend(data []byte, starts []int) {
 	if s.server.erupeConfig.DevMode && s.server.erupeConfig.DevModeOptions.LogOutboundMessages {
-		fmt.Printf("Server send to [%s]\n", s.Name)
-		fmt.Printf("Sent Data:\n%s\n", hex.Dump(data))
+		s.packetLogger.Debug("Sent packet", zap.Int("bytes", len(data)), zap.String("data", hex.Dump(data)))
 	}
-	s.sendPackets <- data
+	if atomic.LoadInt32(&s.sendStalled) != 0 {
+		return
+	}
+	select {
+	case s.sendPackets <- data:
+	default:
+		// A client catching up on a burst frees room soon, one that stopped reading never does.
+		timer := time.NewTimer(s.sendStallTimeout)
+		defer timer.Stop()
+		select {
+		case s.sendPackets <- data:
+		case <-timer.C:
+			s.dropStalledSession()
+			return
+		}
+	}
+	s.queueHasRoom()
+	s.tracePackets("send", data, starts)
+	s.capturePackets(packetcapture.Outbound, data, starts)
+}
+
+// packetGroup builds the packets sent together in one frame, keeping where each starts so they're traced
+// one by one.
+type packetGroup struct {
+	bf     *byteframe.ByteFrame
+	starts []int
+}
+
+func newPacketGroup() *packetGroup {
+	return &packetGroup{bf: byteframe.NewByteFrame()}
+}
+
+func (g *packetGroup) add(pkt mhfpacket.MHFPacket, ctx *clientctx.ClientContext) {
+	g.starts = append(g.starts, len(g.bf.Data()))
+	g.bf.WriteUint16(uint16(pkt.Opcode()))
+	pkt.Build(g.bf, ctx)
+}
+
+// QueueSendGroup queues the group's packets to be sent together in one frame, ended by MSG_SYS_END.
+func (s *Session) QueueSendGroup(g *packetGroup) {
+	g.starts = append(g.starts, len(g.bf.Data()))
+	g.bf.WriteUint16(uint16(network.MSG_SYS_END))
+	s.queueSend(g.bf.Data(), g.starts)
 }
 
 // QueueSendNonBlocking queues a packet (raw []byte) to be sent, dropping the packet entirely if the queue is full.
+// Sessions whose queue stays full for longer than the stall timeout are disconnected.
 func (s *Session) QueueSendNonBlocking(data []byte) {
+	if atomic.LoadInt32(&s.sendStalled) != 0 {
+		return
+	}
 	select {
 	case s.sendPackets <- data:
 		// Enqueued properly.
+		s.queueHasRoom()
+		s.tracePacket("send", data, nil)
+		s.capturePacket(packetcapture.Outbound, data, nil)
 	default:
 		// Couldn't enqueue, likely something wrong with the connection.
 		s.logger.Warn("Dropped packet for session because of full send buffer, something is probably wrong")
+		s.queueFull()
+	}
+}
+
+func (s *Session) queueHasRoom() {
+	if atomic.LoadInt64(&s.queueFullSince) != 0 {
+		atomic.StoreInt64(&s.queueFullSince, 0)
+	}
+}
+
+// queueFull notes the send queue is full and drops the session once it has stayed full for the stall timeout.
+func (s *Session) queueFull() {
+	now := time.Now().UnixNano()
+	if atomic.CompareAndSwapInt64(&s.queueFullSince, 0, now) {
+		return
+	}
+	if time.Duration(now-atomic.LoadInt64(&s.queueFullSince)) > s.sendStallTimeout {
+		s.dropStalledSession()
 	}
 }
 
+// dropStalledSession disconnects a client that isn't reading what it's sent.
+// Closing the connection unblocks the send loop and ends the recv loop, which runs the usual logout.
+func (s *Session) dropStalledSession() {
+	if !atomic.CompareAndSwapInt32(&s.sendStalled, 0, 1) {
+		return
+	}
+	s.logger.Warn("Disconnecting session with stalled send queue", zap.Int("queued", len(s.sendPackets)))
+	s.closeWith(DisconnectQueueOverflow)
+}
+
 // QueueSendMHF queues a MHFPacket to be sent.
 func (s *Session) QueueSendMHF(pkt mhfpacket.MHFPacket) {
 	// Make the header
@@ -109,6 +300,12 @@ func (s *Session) QueueSendMHF(pkt mhfpacket.MHFPacket) {
 
 	// Build the packet onto the byteframe.
 	pkt.Build(bf, s.clientContext)
+	if ack, ok := pkt.(*mhfpacket.MsgSysAck); ok {
+		if len(bf.Data())+2 > s.server.frameLimit {
+			s.recordOversizedAck(ack)
+		}
+		s.recentRequests.answered(ack.AckHandle, bf.Data())
+	}
 
 	// Queue it.
 	s.QueueSend(bf.Data())
@@ -120,6 +317,7 @@ func (s *Session) QueueAck(ackHandle uint32, data []byte) {
 	bf.WriteUint16(uint16(network.MSG_SYS_ACK))
 	bf.WriteUint32(ackHandle)
 	bf.WriteBytes(data)
+	s.recentRequests.answered(ackHandle, bf.Data())
 	s.QueueSend(bf.Data())
 }
 
@@ -130,6 +328,7 @@ func (s *Session) sendLoop() {
 
 		if rawPacket == nil {
 			s.logger.Debug("Got nil from s.SendPackets, exiting send loop")
+			s.rawConn.Close()
 			return
 		}
 
@@ -139,40 +338,90 @@ func (s *Session) sendLoop() {
 
 		// Append the MSG_SYS_END tailing opcode.
 		terminatedPacket = append(terminatedPacket, []byte{0x00, 0x10}...)
-
+		s.recordFrameOut(terminatedPacket)
 		s.cryptConn.SendPacket(terminatedPacket)
 	}
 }
 
 func (s *Session) recvLoop() {
+	pinged := false
 	for {
+		if s.idleTimeout > 0 {
+			timeout := s.idleTimeout
+			if pinged {
+				timeout = s.pingTimeout
+			}
+			s.rawConn.SetReadDeadline(time.Now().Add(timeout))
+		}
 		pkt, err := s.cryptConn.ReadPacket()
 
-		if err == io.EOF {
-			s.logger.Info(fmt.Sprintf("[%s] Disconnected", s.Name))
-			logoutPlayer(s)
-			return
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			if !pinged {
+				// Any packet the client sends back, not just the ping reply, resets the timer.
+				pinged = true
+				bf := byteframe.NewByteFrame()
+				bf.WriteUint16(uint16(network.MSG_SYS_PING))
+				(&mhfpacket.MsgSysPing{}).Build(bf, s.clientContext)
+				s.QueueSendNonBlocking(bf.Data())
+				continue
+			}
 		}
 		if err != nil {
-			s.logger.Warn("Error on ReadPacket, exiting recv loop", zap.Error(err))
-			logoutPlayer(s)
+			s.endSession(readErrorReason(err), err)
 			return
 		}
+		pinged = false
+		s.recordFrameIn(len(pkt))
 		s.handlePacketGroup(pkt)
+		// The frames a flooding client sent before its connection was closed aren't handled either.
+		if s.flooded() {
+			s.endSession(DisconnectPacketFlood, nil)
+			return
+		}
+	}
+}
+
+// disconnect runs the logout cleanup once the connection is gone.
+// A failing save mustn't take the channel down with it, so panics are logged unless CrashOnPanic is set.
+func (s *Session) disconnect() {
+	defer s.rawConn.Close()
+	defer s.closePacketCapture()
+	defer s.endLoginTrace()
+	if !(s.server.erupeConfig.DevMode && s.server.erupeConfig.DevModeOptions.CrashOnPanic) {
+		defer func() {
+			if r := recover(); r != nil {
+				s.logger.Error("Recovered from panic during logout", zap.Any("panic", r), zap.Stack("stack"))
+			}
+		}()
 	}
+	logoutPlayer(s)
 }
 
 func (s *Session) handlePacketGroup(pktGroup []byte) {
 	bf := byteframe.NewByteFrameFromBytes(pktGroup)
-	opcode := network.PacketID(bf.ReadUint16())
+	opcodeID, err := bfutil.TryReadUint16(bf)
+	if err != nil {
+		s.logger.Warn("Dropped packet group too short for an opcode", zap.Int("bytes", len(pktGroup)))
+		return
+	}
+	opcode := network.PacketID(opcodeID)
+	if s.flooded() {
+		return
+	}
+	if opcode != network.MSG_SYS_END && !s.allowPacket(time.Now()) {
+		s.dropFlood()
+		return
+	}
 
 	// This shouldn't be needed, but it's better to recover and let the connection die than to panic the server.
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Printf("[%s]", s.Name)
-			fmt.Println("Recovered from panic", r)
-		}
-	}()
+	var mhfPkt mhfpacket.MHFPacket
+	if !(s.server.erupeConfig.DevMode && s.server.erupeConfig.DevModeOptions.CrashOnPanic) {
+		defer func() {
+			if r := recover(); r != nil {
+				s.recoverHandlerPanic(r, opcode, mhfPkt, pktGroup)
+			}
+		}()
+	}
 
 	// Print any (non-common spam) packet opcodes and data.
 	if s.server.erupeConfig.DevMode && s.server.erupeConfig.DevModeOptions.OpcodeMessages {
@@ -182,31 +431,137 @@ func (s *Session) handlePacketGroup(pktGroup []byte) {
 			opcode != network.MSG_SYS_TIME &&
 			opcode != network.MSG_SYS_POSITION_OBJECT &&
 			opcode != network.MSG_SYS_EXTEND_THRESHOLD {
-			fmt.Printf("[%s] send to Server\n", s.Name)
-			fmt.Printf("Opcode: %s\n", opcode)
-			fmt.Printf("Data [%d bytes]:\n%s\n", len(pktGroup), hex.Dump(pktGroup))
+			s.packetLogger.Debug("Received packet", zap.String("opcode", opcode.String()), zap.Int("bytes", len(pktGroup)), zap.String("data", hex.Dump(pktGroup)))
 		}
 	}
+	if orderedOpcodes[opcode] {
+		s.waitDeferred()
+	}
 	if opcode == network.MSG_SYS_LOGOUT {
-		s.rawConn.Close()
+		s.closeWith(DisconnectLogout)
 	}
 	// Get the packet parser and handler for this opcode.
-	mhfPkt := mhfpacket.FromOpcode(opcode)
+	mhfPkt = mhfpacket.FromOpcode(opcode)
 	if mhfPkt == nil {
-		fmt.Println("Got opcode which we don't know how to parse, can't parse anymore for this group")
+		s.tracePacket("recv", pktGroup, nil)
+		s.capturePacket(packetcapture.Inbound, pktGroup, nil)
+		s.logger.Warn("Got opcode which we don't know how to parse, can't parse anymore for this group", zap.String("opcode", opcode.String()))
 		return
 	}
 	// Parse the packet.
-	err := mhfPkt.Parse(bf, s.clientContext)
+	err = mhfPkt.Parse(bf, s.clientContext)
+	if errors.Is(err, bfutil.ErrShortRead) || errors.Is(err, bfutil.ErrStringTooLong) {
+		// The rest of the group can't be found past a packet of unknown length.
+		s.tracePacket("recv", pktGroup, nil)
+		s.capturePacket(packetcapture.Inbound, pktGroup, nil)
+		s.logger.Warn("Dropped malformed packet", zap.String("opcode", opcode.String()), zap.Int("bytes", len(pktGroup)))
+		if ackHandle, ok := packetAckHandle(mhfPkt); ok && ackHandle != 0 {
+			doAckSimpleFail(s, ackHandle, make([]byte, 4))
+		}
+		return
+	}
 	if err != nil {
-		fmt.Printf("\n!!! [%s] %s NOT IMPLEMENTED !!! \n\n\n", s.Name, opcode)
+		s.tracePacket("recv", pktGroup, nil)
+		s.capturePacket(packetcapture.Inbound, pktGroup, nil)
+		s.logger.Warn("Packet not implemented", zap.String("opcode", opcode.String()), zap.Error(err))
 		return
 	}
-	// Handle the packet.
-	handlerTable[opcode](s, mhfPkt)
 	// If there is more data on the stream that the .Parse method didn't read, then read another packet off it.
 	remainingData := bf.DataFromCurrent()
+	s.recordPacketIn(opcode, len(pktGroup)-len(remainingData))
+	if login, ok := mhfPkt.(*mhfpacket.MsgSysLogin); ok {
+		s.Lock()
+		s.loginCharID = login.CharID0
+		s.Unlock()
+	}
+	s.tracePacket("recv", pktGroup[:len(pktGroup)-len(remainingData)], mhfPkt)
+	s.capturePacket(packetcapture.Inbound, pktGroup[:len(pktGroup)-len(remainingData)], mhfPkt)
+	// Handle the packet.
+	atomic.StoreUint32(&s.handling, uint32(opcode))
+	s.handlingPacket = pktGroup[:len(pktGroup)-len(remainingData)]
+	s.server.handlers.dispatch(s, opcode, mhfPkt)
 	if len(remainingData) >= 2 {
 		s.handlePacketGroup(remainingData)
 	}
 }
+
+// isLoggedIn reports whether the session has completed MSG_SYS_LOGIN.
+func (s *Session) isLoggedIn() bool {
+	return s.state() >= sessionLoggedIn
+}
+
+// sessionState is how far a session got towards the world, each state letting more opcodes through,
+// see handlerRegistry.require.
+type sessionState int
+
+const (
+	sessionConnected sessionState = iota // MSG_SYS_LOGIN hasn't been handled yet.
+	sessionLoggedIn                      // Logged in, not in any stage yet.
+	sessionInStage                       // In a stage, see transferToStage.
+)
+
+func (state sessionState) String() string {
+	switch state {
+	case sessionConnected:
+		return "connected"
+	case sessionLoggedIn:
+		return "logged in"
+	case sessionInStage:
+		return "in stage"
+	}
+	return fmt.Sprintf("sessionState(%d)", int(state))
+}
+
+// state returns the session's state, which only moves forward: the login sets the character and the
+// first stage entry the stage, neither is cleared before the session closes.
+func (s *Session) state() sessionState {
+	s.Lock()
+	defer s.Unlock()
+	switch {
+	case s.charID == 0:
+		return sessionConnected
+	case s.stage == nil:
+		return sessionLoggedIn
+	}
+	return sessionInStage
+}
+
+// recoverHandlerPanic logs a recovered handler panic, fails the pending ack if the
+// packet has one and then drops this session only.
+func (s *Session) recoverHandlerPanic(r interface{}, opcode network.PacketID, pkt mhfpacket.MHFPacket, data []byte) {
+	s.logger.Error("Recovered from panic in packet handler",
+		zap.String("opcode", opcode.String()),
+		zap.Any("panic", r),
+		zap.String("data", hex.Dump(data)),
+		zap.Stack("stack"),
+	)
+
+	if ackHandle, ok := packetAckHandle(pkt); ok {
+		bf := byteframe.NewByteFrame()
+		bf.WriteUint16(uint16(network.MSG_SYS_ACK))
+		(&mhfpacket.MsgSysAck{
+			AckHandle: ackHandle,
+			ErrorCode: 1,
+			AckData:   make([]byte, 4),
+		}).Build(bf, s.clientContext)
+		s.QueueSendNonBlocking(bf.Data())
+	}
+
+	s.closeWith(DisconnectProtocolError)
+}
+
+// packetAckHandle returns the AckHandle field of a parsed packet, if it has one.
+func packetAckHandle(pkt mhfpacket.MHFPacket) (uint32, bool) {
+	if pkt == nil {
+		return 0, false
+	}
+	v := reflect.ValueOf(pkt)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return 0, false
+	}
+	field := v.Elem().FieldByName("AckHandle")
+	if !field.IsValid() || field.Kind() != reflect.Uint32 {
+		return 0, false
+	}
+	return uint32(field.Uint()), true
+}
@@ -0,0 +1,54 @@
+package channelserver
+
+import (
+	"net"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// sessionCore adds the session's fields to every entry logged through it. The fields change as the
+// character logs in and moves between stages, so they're read as each entry is written, not bound with With.
+type sessionCore struct {
+	zapcore.Core
+	fields *atomic.Value // []zapcore.Field, see Session.updateLogFields.
+}
+
+func (c sessionCore) With(fields []zapcore.Field) zapcore.Core {
+	return sessionCore{c.Core.With(fields), c.fields}
+}
+
+func (c sessionCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c sessionCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	session := c.fields.Load().([]zapcore.Field)
+	return c.Core.Write(entry, append(session[:len(session):len(session)], fields...))
+}
+
+// sessionLogger returns a logger adding the session's fields to what it logs.
+func (s *Session) sessionLogger(logger *zap.Logger) *zap.Logger {
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return sessionCore{core, &s.logFields}
+	}))
+}
+
+// updateLogFields publishes the character and stage the session's log entries are tagged with.
+// The session lock must be held.
+func (s *Session) updateLogFields() {
+	ip := s.rawConn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	s.logFields.Store([]zapcore.Field{
+		zap.Uint32("charID", s.charID),
+		zap.String("name", s.Name),
+		zap.String("ip", ip),
+		zap.String("stageID", s.stageID),
+	})
+}
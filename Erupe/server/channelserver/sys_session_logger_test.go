@@ -0,0 +1,73 @@
+package channelserver
+
+import (
+	"net"
+	"testing"
+
+	"github.com/Solenataris/Erupe/config"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// remoteConn is a pipe reporting a TCP peer address, as client connections do.
+type remoteConn struct {
+	net.Conn
+}
+
+func (remoteConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(203, 0, 113, 5), Port: 50123}
+}
+
+func TestSessionLogFields(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	packetCore, packetLogs := observer.New(zapcore.DebugLevel)
+	erupeConfig := &config.Config{DevMode: true, DevModeOptions: config.DevModeOptions{LogOutboundMessages: true}}
+	s := NewServer(&Config{Logger: zap.New(core), PacketLogger: zap.New(packetCore), ErupeConfig: erupeConfig})
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	session := NewSession(s, remoteConn{serverConn})
+
+	session.Lock()
+	session.charID = 7
+	session.Name = "Alpha"
+	session.updateLogFields()
+	session.Unlock()
+	doStageTransfer(session, 0, "sl1Ns200p0a0u0")
+
+	logs.TakeAll()
+	session.logger.With(zap.String("guild", "Hunters")).Warn("Something happened", zap.Int("count", 2))
+	entries := logs.TakeAll()
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	want := map[string]interface{}{
+		"charID":  uint32(7),
+		"name":    "Alpha",
+		"ip":      "203.0.113.5",
+		"stageID": "sl1Ns200p0a0u0",
+		"guild":   "Hunters",
+		"count":   int64(2),
+	}
+	fields := entries[0].ContextMap()
+	for key, value := range want {
+		if fields[key] != value {
+			t.Errorf("field %s is %v, want %v", key, fields[key], value)
+		}
+	}
+
+	// The stage follows the session as it moves.
+	doStageTransfer(session, 0, "sl1Ns202p0a0u0")
+	logs.TakeAll()
+	session.logger.Info("Moved")
+	if stageID := logs.TakeAll()[0].ContextMap()["stageID"]; stageID != "sl1Ns202p0a0u0" {
+		t.Errorf("logged stage %v after moving, want sl1Ns202p0a0u0", stageID)
+	}
+
+	packetLogs.TakeAll()
+	session.QueueSend([]byte{0x00, 0x01})
+	dumps := packetLogs.TakeAll()
+	if len(dumps) != 1 || dumps[0].ContextMap()["charID"] != uint32(7) {
+		t.Errorf("got packet dumps %+v, want one tagged with the character", dumps)
+	}
+}
@@ -0,0 +1,232 @@
+package channelserver
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+)
+
+// newTestSession creates a session on one end of an in-memory pipe and returns the client end.
+func newTestSession(s *Server) (*Session, net.Conn) {
+	serverConn, clientConn := net.Pipe()
+	session := NewSession(s, serverConn)
+	go session.sendLoop()
+	return session, clientConn
+}
+
+func makePingPacket(ackHandle uint32) []byte {
+	bf := byteframe.NewByteFrame()
+	bf.WriteUint16(uint16(network.MSG_SYS_PING))
+	bf.WriteUint32(ackHandle)
+	return bf.Data()
+}
+
+func TestHandlerPanicOnlyDropsSession(t *testing.T) {
+	s := newTestServer()
+
+	bad, badClient := newTestSession(s)
+	good, goodClient := newTestSession(s)
+	defer goodClient.Close()
+
+	originalHandler := handlerTable[network.MSG_SYS_PING]
+	s.handlers.register(network.MSG_SYS_PING, func(s *Session, p mhfpacket.MHFPacket) {
+		if s == bad {
+			var stage *Stage
+			_ = stage.id // nil dereference
+		}
+		originalHandler(s, p)
+	})
+
+	// The panicking session gets a failure ack and is then disconnected.
+	closed := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(ioutil.Discard, badClient)
+		closed <- err
+	}()
+	bad.handlePacketGroup(makePingPacket(1))
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("panicking session was not disconnected")
+	}
+
+	// The other session keeps working.
+	replied := make(chan error, 1)
+	go func() {
+		_, err := network.NewCryptConn(goodClient).ReadPacket()
+		replied <- err
+	}()
+	good.handlePacketGroup(makePingPacket(2))
+
+	select {
+	case err := <-replied:
+		if err != nil {
+			t.Fatalf("healthy session failed to reply: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("healthy session did not reply after another session panicked")
+	}
+}
+
+func TestShortPacketGroupIsDropped(t *testing.T) {
+	s := newTestServer()
+	session := newQueuedSession(t, s, 1, "Alpha")
+	for _, group := range [][]byte{{}, {0x00}} {
+		session.handlePacketGroup(group)
+	}
+	if reason := atomic.LoadInt32(&session.disconnectReason); reason != 0 {
+		t.Errorf("a group too short for an opcode closed the session with %v", DisconnectReason(reason))
+	}
+	if sent := sentPackets(session); len(sent) != 0 {
+		t.Errorf("a group too short for an opcode was answered with %x", sent)
+	}
+}
+
+func TestPacketAckHandle(t *testing.T) {
+	if handle, ok := packetAckHandle(&mhfpacket.MsgSysPing{AckHandle: 0x1234}); !ok || handle != 0x1234 {
+		t.Errorf("got (%#x, %v), want (0x1234, true)", handle, ok)
+	}
+	if _, ok := packetAckHandle(&mhfpacket.MsgSysEnd{}); ok {
+		t.Error("MsgSysEnd has no ack handle")
+	}
+	if _, ok := packetAckHandle(nil); ok {
+		t.Error("nil packet has no ack handle")
+	}
+}
+
+func TestIdleTimeoutReleasesStageSlot(t *testing.T) {
+	s := newTestServer()
+	session, client := newTestSession(s)
+	defer client.Close()
+	session.idleTimeout = 50 * time.Millisecond
+	session.pingTimeout = 50 * time.Millisecond
+
+	stage, _ := s.CreateStage("sl1Qs999p0a0u999", 4)
+	session.charID = 1
+	session.stage = stage
+	stage.clients[session] = session.charID
+
+	// The client goes silent but keeps reading, so the ping is delivered and never answered.
+	pinged := make(chan error, 1)
+	go func() {
+		cc := network.NewCryptConn(client)
+		_, err := cc.ReadPacket()
+		pinged <- err
+		io.Copy(ioutil.Discard, client)
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		session.recvLoop()
+		close(done)
+	}()
+
+	select {
+	case err := <-pinged:
+		if err != nil {
+			t.Fatalf("failed to read ping: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle client was not pinged")
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle client was not disconnected")
+	}
+
+	stage.RLock()
+	_, stillInStage := stage.clients[session]
+	stage.RUnlock()
+	if stillInStage {
+		t.Error("idle client still holds its stage slot")
+	}
+}
+
+func TestFullSendQueueWaitsForRoom(t *testing.T) {
+	s := newTestServer()
+	session := newQueuedSession(t, s, 1, "Alpha")
+	session.sendStallTimeout = 200 * time.Millisecond
+	for i := 0; i < cap(session.sendPackets); i++ {
+		session.QueueSendNonBlocking([]byte{0x00, byte(network.MSG_SYS_NOP)})
+	}
+
+	// A broadcast that doesn't fit is dropped, the session stays until the queue has been full a while.
+	session.QueueSendNonBlocking([]byte{0x00, byte(network.MSG_SYS_NOP)})
+	if reason := atomic.LoadInt32(&session.disconnectReason); reason != 0 {
+		t.Fatalf("a dropped broadcast disconnected the session with %v", DisconnectReason(reason))
+	}
+
+	// A packet the client needs waits for the send loop to make room.
+	sent := make(chan struct{})
+	go func() {
+		session.QueueSend([]byte{0x00, byte(network.MSG_SYS_ACK)})
+		close(sent)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	<-session.sendPackets
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("QueueSend didn't take the room freed in the queue")
+	}
+	if reason := atomic.LoadInt32(&session.disconnectReason); reason != 0 {
+		t.Fatalf("a send that got room disconnected the session with %v", DisconnectReason(reason))
+	}
+
+	// Without room for the stall timeout, the session is disconnected and QueueSend returns.
+	dropped := make(chan struct{})
+	go func() {
+		session.QueueSend([]byte{0x00, byte(network.MSG_SYS_ACK)})
+		close(dropped)
+	}()
+	select {
+	case <-dropped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("QueueSend blocked past the stall timeout")
+	}
+	if reason := DisconnectReason(atomic.LoadInt32(&session.disconnectReason)); reason != DisconnectQueueOverflow {
+		t.Errorf("the session was closed with %v, want %v", reason, DisconnectQueueOverflow)
+	}
+}
+
+func TestSendBurstDoesNotDisconnect(t *testing.T) {
+	s := newTestServer()
+	s.erupeConfig.Channel.SendQueueSize = 20
+	session := newQueuedSession(t, s, 1, "Alpha")
+
+	// A client reading a little slower than the server sends, as on a slow link.
+	const burst = 100
+	received := make(chan int)
+	go func() {
+		n := 0
+		for n < burst {
+			<-session.sendPackets
+			n++
+			time.Sleep(time.Millisecond)
+		}
+		received <- n
+	}()
+	for i := 0; i < burst; i++ {
+		session.QueueSend([]byte{0x00, byte(network.MSG_SYS_ACK)})
+	}
+	select {
+	case n := <-received:
+		if n != burst {
+			t.Errorf("the client got %d packets, want %d", n, burst)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the burst wasn't delivered")
+	}
+	if reason := atomic.LoadInt32(&session.disconnectReason); reason != 0 {
+		t.Errorf("a burst of %d packets disconnected the session with %v", burst, DisconnectReason(reason))
+	}
+}
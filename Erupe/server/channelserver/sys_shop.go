@@ -0,0 +1,302 @@
+package channelserver
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// Errors buying from the exchange shops.
+var (
+	ErrShopItemUnknown  = errors.New("the item isn't in any shop")
+	ErrShopItemInactive = errors.New("the item isn't on sale now")
+	ErrShopLimit        = errors.New("the purchase limit for this period is reached")
+)
+
+// shopItem is an item of the exchange shops in normal_shop_items, with the rotation it's held to.
+type shopItem struct {
+	Hash               uint32         `db:"itemhash"`
+	ItemID             uint16         `db:"itemid"`
+	Points             uint16         `db:"points"`
+	TradeQuantity      uint16         `db:"tradequantity"`
+	RankReqLow         uint16         `db:"rankreqlow"`
+	RankReqHigh        uint16         `db:"rankreqhigh"`
+	RankReqG           uint16         `db:"rankreqg"`
+	StoreLevelReq      uint16         `db:"storelevelreq"`
+	MaximumQuantity    uint16         `db:"maximumquantity"`
+	BoughtQuantity     uint16         `db:"boughtquantity"`
+	RoadFloorsRequired uint16         `db:"roadfloorsrequired"`
+	WeeklyFatalisKills uint16         `db:"weeklyfataliskills"`
+	EnableWeeks        string         `db:"enable_weeks"`
+	LimitPeriod        string         `db:"limit_period"`
+	RotationGroup      sql.NullString `db:"rotation_group"`
+	RotationSlot       int            `db:"rotation_slot"`
+	ActiveFrom         sql.NullTime   `db:"active_from"`
+	ActiveUntil        sql.NullTime   `db:"active_until"`
+	RotationStart      sql.NullTime   `db:"starts_at"`
+	RotationDays       int            `db:"period_days"`
+	RotationSlots      int            `db:"slots"`
+}
+
+const shopItemQuery = `
+	SELECT itemhash, itemid, points, tradequantity, rankreqlow, rankreqhigh, rankreqg, storelevelreq,
+		maximumquantity, boughtquantity, roadfloorsrequired, weeklyfataliskills, COALESCE(enable_weeks, '') AS enable_weeks,
+		limit_period, i.rotation_group, rotation_slot, active_from, active_until,
+		r.starts_at, COALESCE(r.period_days, 0) AS period_days, COALESCE(r.slots, 0) AS slots
+	FROM normal_shop_items i LEFT JOIN shop_rotations r ON r.rotation_group = i.rotation_group
+`
+
+// rotation returns the rotation running at now, numbered from 1, or 0 before it starts or without one.
+func (i shopItem) rotation(now time.Time) int {
+	if !i.RotationStart.Valid || i.RotationDays <= 0 {
+		return 0
+	}
+	rotation, _, _ := periodAt(i.RotationStart.Time.Format(time.RFC3339), i.RotationDays, now)
+	return rotation
+}
+
+// activeAt reports whether the item is on sale at now: within its window, in a season of enable_weeks and
+// in the slot of its rotation. An item of a rotation group missing from shop_rotations is never on sale.
+func (i shopItem) activeAt(now time.Time) bool {
+	if i.ActiveFrom.Valid && now.Before(i.ActiveFrom.Time) {
+		return false
+	}
+	if i.ActiveUntil.Valid && !now.Before(i.ActiveUntil.Time) {
+		return false
+	}
+	if i.EnableWeeks != "" {
+		_, week := now.ISOWeek()
+		if !contains(strings.Split(i.EnableWeeks, ","), fmt.Sprintf("%d", week%4)) {
+			return false
+		}
+	}
+	if i.RotationGroup.Valid {
+		rotation := i.rotation(now)
+		if rotation == 0 || i.RotationSlots <= 0 || (rotation-1)%i.RotationSlots != i.RotationSlot {
+			return false
+		}
+	}
+	return true
+}
+
+// period returns the period the item's purchase limit counts in at now, 0 for a limit that never resets.
+func (i shopItem) period(now time.Time) (int, error) {
+	switch i.LimitPeriod {
+	case "":
+		return 0, nil
+	case "daily":
+		year, month, day := now.Date()
+		return int(time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Unix() / 86400), nil
+	case "weekly":
+		year, week := now.ISOWeek()
+		return year*100 + week, nil
+	case "rotation":
+		if !i.RotationGroup.Valid {
+			return 0, fmt.Errorf("item %d resets by rotation without a rotation group", i.Hash)
+		}
+		return i.rotation(now), nil
+	}
+	return 0, fmt.Errorf("item %d has unknown limit period %q", i.Hash, i.LimitPeriod)
+}
+
+// checkShopPurchase checks a purchase of count against the item's limit, given how many the character
+// already bought this period.
+func checkShopPurchase(item shopItem, used uint32, count uint32) error {
+	if count == 0 {
+		return errors.New("nothing to buy")
+	}
+	if item.MaximumQuantity > 0 && used+count > uint32(item.MaximumQuantity) {
+		return ErrShopLimit
+	}
+	return nil
+}
+
+// shopUse is how many of an item a character bought in a period, from shop_item_state.
+type shopUse struct {
+	Used   uint16
+	Period sql.NullInt64
+}
+
+// buildShopList writes the items on sale at now as a shop enumeration. Limited items carry the limit and
+// what the character bought this period, the client shows the difference as the purchases left.
+func buildShopList(items []shopItem, used map[uint32]shopUse, now time.Time) []byte {
+	bf := byteframe.NewByteFrame()
+	bf.WriteUint32(0) // Item counts, written once known.
+	count := 0
+	for _, item := range items {
+		if !item.activeAt(now) {
+			continue
+		}
+		bf.WriteUint32(item.Hash)
+		bf.WriteUint16(0) // unk, always 0 in existing packets
+		bf.WriteUint16(item.ItemID)
+		bf.WriteUint16(0)                  // unk, always 0 in existing packets
+		bf.WriteUint16(item.Points)        // it's either item ID or quantity for gacha coins
+		bf.WriteUint16(item.TradeQuantity) // only for item ID
+		bf.WriteUint16(item.RankReqLow)
+		bf.WriteUint16(item.RankReqHigh)
+		bf.WriteUint16(item.RankReqG)
+		bf.WriteUint16(item.StoreLevelReq)
+		bf.WriteUint16(item.MaximumQuantity)
+		if item.MaximumQuantity > 0 {
+			use := used[item.Hash]
+			period, err := item.period(now)
+			if err == nil && use.Period.Valid && int(use.Period.Int64) == period {
+				bf.WriteUint16(use.Used)
+			} else {
+				bf.WriteUint16(0)
+			}
+		} else {
+			bf.WriteUint16(item.BoughtQuantity)
+		}
+		bf.WriteUint16(item.RoadFloorsRequired)
+		bf.WriteUint16(item.WeeklyFatalisKills)
+		count++
+	}
+	if count == 0 {
+		return make([]byte, 4)
+	}
+	bf.Seek(0, 0)
+	bf.WriteUint16(uint16(count))
+	bf.WriteUint16(uint16(count))
+	return bf.Data()
+}
+
+// shopKey is a shop of normal_shop_items.
+type shopKey struct {
+	shopType uint8
+	shopID   uint32
+}
+
+// How long a shop's items are cached before they're read again, so edits to the tables show up
+// without a restart.
+const shopCacheTTL = time.Minute
+
+type cachedShop struct {
+	items  []shopItem
+	loaded time.Time
+}
+
+// shopCache holds the items of the shops enumerated lately, by shop.
+type shopCache struct {
+	sync.Mutex
+	shops map[shopKey]cachedShop
+}
+
+// shopItems returns the items of the shop, from the cache while it's fresh.
+func (s *Server) shopItems(key shopKey) ([]shopItem, error) {
+	s.shops.Lock()
+	defer s.shops.Unlock()
+	if cached, ok := s.shops.shops[key]; ok && time.Since(cached.loaded) < shopCacheTTL {
+		return cached.items, nil
+	}
+	items := []shopItem{}
+	if err := s.db.Select(&items, shopItemQuery+"WHERE shoptype = $1 AND shopid = $2", key.shopType, key.shopID); err != nil {
+		return nil, err
+	}
+	if s.shops.shops == nil {
+		s.shops.shops = make(map[shopKey]cachedShop)
+	}
+	s.shops.shops[key] = cachedShop{items: items, loaded: time.Now()}
+	return items, nil
+}
+
+// shopUses loads how many of the limited items the character bought, by item hash.
+func shopUses(db *sqlx.DB, charID uint32) (map[uint32]shopUse, error) {
+	rows, err := db.Query("SELECT itemhash, COALESCE(usedquantity, 0), period FROM shop_item_state WHERE char_id = $1", charID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	used := make(map[uint32]shopUse)
+	for rows.Next() {
+		var hash uint32
+		var use shopUse
+		if err := rows.Scan(&hash, &use.Used, &use.Period); err != nil {
+			return nil, err
+		}
+		used[hash] = use
+	}
+	return used, rows.Err()
+}
+
+// enumerateShop sends the items of the shop on sale now with the character's purchases.
+func enumerateShop(s *Session, ackHandle uint32, key shopKey) {
+	items, err := s.server.shopItems(key)
+	if err != nil {
+		s.logger.Error("Failed to load shop items", zap.Uint8("shopType", key.shopType), zap.Uint32("shopID", key.shopID), zap.Error(err))
+		doAckBufFail(s, ackHandle, make([]byte, 4))
+		return
+	}
+	used, err := shopUses(s.server.db, s.charID)
+	if err != nil {
+		s.logger.Error("Failed to load shop purchases", zap.Error(err))
+		doAckBufFail(s, ackHandle, make([]byte, 4))
+		return
+	}
+	doAckBufSucceed(s, ackHandle, buildShopList(items, used, Time_Current()))
+}
+
+// BuyShopItem counts a purchase of count of the item against its limit for the period running at now,
+// a count from an earlier period starting over. The check and the count are one statement, so concurrent
+// purchases can't go past the limit together.
+func BuyShopItem(db *sqlx.DB, charID uint32, hash uint32, count uint32, now time.Time) error {
+	var item shopItem
+	err := db.Get(&item, shopItemQuery+"WHERE itemhash = $1", hash)
+	if err == sql.ErrNoRows {
+		return ErrShopItemUnknown
+	} else if err != nil {
+		return err
+	}
+	if !item.activeAt(now) {
+		return ErrShopItemInactive
+	}
+	period, err := item.period(now)
+	if err != nil {
+		return err
+	}
+	if err := checkShopPurchase(item, 0, count); err != nil {
+		return err
+	}
+	res, err := db.Exec(`
+		INSERT INTO shop_item_state (char_id, itemhash, usedquantity, period) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (char_id, itemhash) DO UPDATE SET
+			usedquantity = CASE WHEN shop_item_state.period IS DISTINCT FROM $4 THEN 0
+				ELSE COALESCE(shop_item_state.usedquantity, 0) END + $3,
+			period = $4
+		WHERE $5 = 0 OR CASE WHEN shop_item_state.period IS DISTINCT FROM $4 THEN 0
+			ELSE COALESCE(shop_item_state.usedquantity, 0) END + $3 <= $5
+	`, charID, hash, count, period, item.MaximumQuantity)
+	if err != nil {
+		return err
+	}
+	if rows, err := res.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return ErrShopLimit
+	}
+	return nil
+}
+
+// buyShopItem answers a purchase from an exchange shop. The client trades the items itself once it's acknowledged.
+// An item the shop tables don't list has no limit to check, its exchange goes through as it always has.
+func buyShopItem(s *Session, ackHandle uint32, hash uint32, count uint32) {
+	err := BuyShopItem(s.server.db, s.charID, hash, count, Time_Current())
+	if err == ErrShopItemUnknown {
+		s.logger.Debug("Exchange of an item outside the shop tables", zap.Uint32("itemHash", hash), zap.Uint32("count", count))
+		err = nil
+	}
+	if err != nil {
+		s.logger.Info("Refused shop purchase", zap.Uint32("itemHash", hash), zap.Uint32("count", count), zap.Error(err))
+		doAckSimpleFail(s, ackHandle, make([]byte, 4))
+		return
+	}
+	doAckSimpleSucceed(s, ackHandle, make([]byte, 4))
+}
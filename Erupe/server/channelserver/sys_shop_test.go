@@ -0,0 +1,211 @@
+package channelserver
+
+import (
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/common/testdb"
+)
+
+func rotatingShopItem(start time.Time, slot int) shopItem {
+	return shopItem{
+		RotationGroup: sql.NullString{String: "weekly", Valid: true},
+		RotationSlot:  slot,
+		RotationStart: sql.NullTime{Time: start, Valid: true},
+		RotationDays:  7,
+		RotationSlots: 3,
+	}
+}
+
+func TestShopItemActiveAt(t *testing.T) {
+	jst := time.FixedZone("UTC+9", 9*60*60)
+	from := time.Date(2022, 6, 6, 0, 0, 0, 0, jst)
+	until := from.AddDate(0, 0, 14)
+	windowed := shopItem{ActiveFrom: sql.NullTime{Time: from, Valid: true}, ActiveUntil: sql.NullTime{Time: until, Valid: true}}
+	second := rotatingShopItem(from, 1)
+	tests := []struct {
+		name   string
+		item   shopItem
+		now    time.Time
+		active bool
+	}{
+		{"no window", shopItem{}, from, true},
+		{"before the window", windowed, from.Add(-time.Nanosecond), false},
+		{"window opens", windowed, from, true},
+		{"window opens seen from UTC", windowed, from.UTC(), true},
+		{"last moment of the window", windowed, until.Add(-time.Nanosecond), true},
+		{"window closes", windowed, until, false},
+		{"before the rotation starts", second, from.Add(-time.Second), false},
+		{"first slot", second, from, false},
+		{"second slot starts", second, from.AddDate(0, 0, 7), true},
+		{"last moment of the second slot", second, from.AddDate(0, 0, 14).Add(-time.Nanosecond), true},
+		{"third slot starts", second, from.AddDate(0, 0, 14), false},
+		{"second slot after wrapping", second, from.AddDate(0, 0, 28), true},
+		{"rotation missing from shop_rotations", shopItem{RotationGroup: sql.NullString{String: "gone", Valid: true}}, from, false},
+		{"week in a season of enable_weeks", shopItem{EnableWeeks: "1,3"}, time.Date(2022, 6, 6, 0, 0, 0, 0, jst), true},
+		{"week outside the seasons of enable_weeks", shopItem{EnableWeeks: "1,3"}, time.Date(2022, 6, 13, 0, 0, 0, 0, jst), false},
+	}
+	for _, tt := range tests {
+		if got := tt.item.activeAt(tt.now); got != tt.active {
+			t.Errorf("%s: got active %v, want %v", tt.name, got, tt.active)
+		}
+	}
+}
+
+func TestShopItemPeriod(t *testing.T) {
+	jst := time.FixedZone("UTC+9", 9*60*60)
+	midnight := time.Date(2022, 6, 7, 0, 0, 0, 0, jst)
+	daily := shopItem{LimitPeriod: "daily"}
+	before, _ := daily.period(midnight.Add(-time.Nanosecond))
+	after, _ := daily.period(midnight)
+	if after != before+1 {
+		t.Errorf("daily period went from %d to %d at midnight", before, after)
+	}
+	if p, err := (shopItem{LimitPeriod: "weekly"}).period(midnight); err != nil || p != 202223 {
+		t.Errorf("got weekly period %d, %v, want 202223", p, err)
+	}
+	rotating := rotatingShopItem(midnight, 0)
+	rotating.LimitPeriod = "rotation"
+	if p, err := rotating.period(midnight.AddDate(0, 0, 7)); err != nil || p != 2 {
+		t.Errorf("got rotation period %d, %v, want 2", p, err)
+	}
+	if p, err := (shopItem{}).period(midnight); err != nil || p != 0 {
+		t.Errorf("a limit that never resets got period %d, %v", p, err)
+	}
+	if _, err := (shopItem{LimitPeriod: "rotation"}).period(midnight); err == nil {
+		t.Error("a rotation period without a rotation group didn't fail")
+	}
+	if _, err := (shopItem{LimitPeriod: "monthly"}).period(midnight); err == nil {
+		t.Error("an unknown period didn't fail")
+	}
+}
+
+func TestCheckShopPurchase(t *testing.T) {
+	item := shopItem{MaximumQuantity: 3}
+	if err := checkShopPurchase(item, 1, 2); err != nil {
+		t.Errorf("purchase up to the limit: %v", err)
+	}
+	if err := checkShopPurchase(item, 2, 2); err != ErrShopLimit {
+		t.Errorf("purchase past the limit got %v", err)
+	}
+	if err := checkShopPurchase(item, 0, 0); err == nil {
+		t.Error("empty purchase didn't fail")
+	}
+	item.MaximumQuantity = 0
+	if err := checkShopPurchase(item, 500, 99); err != nil {
+		t.Errorf("unlimited item: %v", err)
+	}
+}
+
+func TestBuildShopList(t *testing.T) {
+	now := time.Date(2022, 6, 7, 12, 0, 0, 0, time.UTC)
+	items := []shopItem{
+		{Hash: 1, ItemID: 100, Points: 10, MaximumQuantity: 5, LimitPeriod: "weekly"},
+		{Hash: 2, ItemID: 200, ActiveUntil: sql.NullTime{Time: now, Valid: true}},
+		{Hash: 3, ItemID: 300, MaximumQuantity: 5, LimitPeriod: "weekly"},
+		{Hash: 4, ItemID: 400, BoughtQuantity: 7},
+	}
+	used := map[uint32]shopUse{
+		1: {Used: 2, Period: sql.NullInt64{Int64: 202223, Valid: true}},
+		3: {Used: 4, Period: sql.NullInt64{Int64: 202222, Valid: true}},
+	}
+	bf := byteframe.NewByteFrameFromBytes(buildShopList(items, used, now))
+	if count := bf.ReadUint16(); count != 3 || bf.ReadUint16() != 3 {
+		t.Fatalf("got %d entries, want the 3 on sale", count)
+	}
+	want := []struct {
+		hash   uint32
+		bought uint16
+	}{{1, 2}, {3, 0}, {4, 7}}
+	for _, w := range want {
+		hash := bf.ReadUint32()
+		bf.ReadBytes(20)
+		bought := bf.ReadUint16()
+		bf.ReadBytes(4)
+		if hash != w.hash || bought != w.bought {
+			t.Errorf("got item %d bought %d, want %d bought %d", hash, bought, w.hash, w.bought)
+		}
+	}
+	if data := buildShopList(nil, nil, now); len(data) != 4 {
+		t.Errorf("an empty shop got %d bytes, want 4", len(data))
+	}
+}
+
+// TestBuyShopItem runs against the database in ERUPE_TEST_DB, like TestAddPoints.
+func TestBuyShopItem(t *testing.T) {
+	db := testdb.Open(t)
+
+	var userID, charID uint32
+	err := db.QueryRow("INSERT INTO users (username, password) VALUES ('shop_test', '') RETURNING id").Scan(&userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+	err = db.QueryRow("INSERT INTO characters (user_id, is_new_character, name) VALUES ($1, false, 'shop') RETURNING id", userID).Scan(&charID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM characters WHERE id = $1", charID)
+	defer db.Exec("DELETE FROM shop_item_state WHERE char_id = $1", charID)
+
+	const hash = 0x7E4FFFF0
+	now := time.Date(2022, 6, 7, 12, 0, 0, 0, time.UTC)
+	_, err = db.Exec(`
+		INSERT INTO normal_shop_items (shoptype, shopid, itemhash, itemid, points, tradequantity, rankreqlow, rankreqhigh,
+			rankreqg, storelevelreq, maximumquantity, boughtquantity, roadfloorsrequired, weeklyfataliskills, limit_period, active_until)
+		VALUES (10, 99, $1, 1, 10, 1, 0, 0, 0, 0, 5, 0, 0, 0, 'weekly', $2)
+	`, hash, now.AddDate(0, 0, 14))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM normal_shop_items WHERE itemhash = $1", hash)
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	bought := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := BuyShopItem(db, charID, hash, 1, now)
+			if err != nil && err != ErrShopLimit {
+				t.Error(err)
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if err == nil {
+				bought++
+			}
+		}()
+	}
+	wg.Wait()
+	if bought != 5 {
+		t.Errorf("%d of 10 concurrent purchases went through a limit of 5", bought)
+	}
+
+	if err := BuyShopItem(db, charID, hash, 5, now.AddDate(0, 0, 7)); err != nil {
+		t.Errorf("purchase in the next week: %v", err)
+	}
+	if err := BuyShopItem(db, charID, hash, 1, now.AddDate(0, 0, 14)); err != ErrShopItemInactive {
+		t.Errorf("purchase after the window got %v, want %v", err, ErrShopItemInactive)
+	}
+	if err := BuyShopItem(db, charID, hash+1, 1, now); err != ErrShopItemUnknown {
+		t.Errorf("purchase of an unknown item got %v, want %v", err, ErrShopItemUnknown)
+	}
+
+	// The client's exchange of an item outside the tables still goes through.
+	s := newTestServer()
+	s.db = db
+	session := newQueuedSession(t, s, charID, "shop")
+	buyShopItem(session, 0x10, hash+1, 1)
+	bf := byteframe.NewByteFrameFromBytes(sentPackets(session))
+	bf.ReadUint16() // MSG_SYS_ACK.
+	bf.ReadUint32() // Ack handle.
+	bf.ReadUint8()  // Is buffer response.
+	if errorCode := bf.ReadUint8(); errorCode != 0 {
+		t.Errorf("the exchange of an unknown item got error code %d", errorCode)
+	}
+}
@@ -0,0 +1,74 @@
+package channelserver
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+var (
+	errSignTokenInvalid = errors.New("sign in token was never issued or its session logged out")
+	errSignTokenExpired = errors.New("sign in token expired")
+)
+
+// signTokenExpired reports whether a token issued at the given time can no longer be used.
+// A TTL of 0 keeps tokens valid until their session logs out.
+func signTokenExpired(issuedAt time.Time, now time.Time, ttl time.Duration) bool {
+	return ttl > 0 && !now.Before(issuedAt.Add(ttl))
+}
+
+// checkSignToken checks the sign in token the client presents at login. The token isn't used up: the client
+// presents it again to change channels, so it stays valid until it expires or its session logs out. It returns
+// the traceparent of the sign in the token was issued by, "" if it wasn't traced.
+func checkSignToken(db *sqlx.DB, userID uint32, tokenID uint32, token string, ttl time.Duration, now time.Time) (string, error) {
+	token = strings.TrimRight(token, "\x00")
+	var issuedAt time.Time
+	var traceparent string
+	err := db.QueryRow(
+		"SELECT issued_at, COALESCE(trace_parent, '') FROM sign_sessions WHERE id = $1 AND user_id = $2 AND auth_token_str = $3",
+		tokenID, userID, token,
+	).Scan(&issuedAt, &traceparent)
+	if err == sql.ErrNoRows {
+		return "", errSignTokenInvalid
+	}
+	if err != nil {
+		return "", err
+	}
+	if signTokenExpired(issuedAt, now, ttl) {
+		return traceparent, errSignTokenExpired
+	}
+	return traceparent, nil
+}
+
+// holdSignToken hands the token the session logged in with over to it, from the session of another channel
+// that held it before a channel change.
+func holdSignToken(s *Session, tokenID uint32) error {
+	s.Lock()
+	key := s.presenceKey
+	s.Unlock()
+	if _, err := s.server.db.Exec("UPDATE sign_sessions SET session = $1 WHERE id = $2", key, tokenID); err != nil {
+		return err
+	}
+	s.Lock()
+	s.signToken = tokenID
+	s.Unlock()
+	return nil
+}
+
+// releaseSignToken deletes the token the session logged in with as it logs out, unless a session of another
+// channel took it over since.
+func releaseSignToken(s *Session) {
+	s.Lock()
+	tokenID, key := s.signToken, s.presenceKey
+	s.Unlock()
+	if tokenID == 0 {
+		return
+	}
+	if _, err := s.server.db.Exec("DELETE FROM sign_sessions WHERE id = $1 AND session = $2", tokenID, key); err != nil {
+		s.logger.Error("Failed to release the sign in token", zap.Error(err))
+	}
+}
@@ -0,0 +1,99 @@
+package channelserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/common/testdb"
+)
+
+func TestSignTokenExpired(t *testing.T) {
+	issued := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	ttl := 5 * time.Minute
+	tests := []struct {
+		name string
+		now  time.Time
+		ttl  time.Duration
+		want bool
+	}{
+		{"just issued", issued, ttl, false},
+		{"last moment of the TTL", issued.Add(ttl - time.Nanosecond), ttl, false},
+		{"end of the TTL", issued.Add(ttl), ttl, true},
+		{"long expired", issued.Add(time.Hour), ttl, true},
+		{"no TTL", issued.Add(24 * time.Hour), 0, false},
+	}
+	for _, tt := range tests {
+		if got := signTokenExpired(issued, tt.now, tt.ttl); got != tt.want {
+			t.Errorf("%s: got expired %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestConsumeSignToken runs against the database in ERUPE_TEST_DB, e.g.
+// "host=localhost user=postgres password=admin dbname=erupe_test sslmode=disable".
+func TestCheckSignToken(t *testing.T) {
+	db := testdb.Open(t)
+
+	var userID uint32
+	err := db.QueryRow("INSERT INTO users (username, password) VALUES ('sign_token_test', '') RETURNING id").Scan(&userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+	defer db.Exec("DELETE FROM sign_sessions WHERE user_id = $1", userID)
+
+	issue := func(token string) uint32 {
+		var id uint32
+		err := db.QueryRow("INSERT INTO sign_sessions (user_id, auth_token_str) VALUES ($1, $2) RETURNING id", userID, token).Scan(&id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	ttl := 5 * time.Minute
+
+	// The client sends the token padded with nulls.
+	id := issue("abcdefghijklmnop")
+	if _, err := checkSignToken(db, userID, id, "abcdefghijklmnop\x00", ttl, time.Now()); err != nil {
+		t.Fatalf("token within its TTL got %v", err)
+	}
+	// A channel change presents the token again, it holds until its session logs out.
+	if _, err := checkSignToken(db, userID, id, "abcdefghijklmnop", ttl, time.Now()); err != nil {
+		t.Fatalf("token presented again got %v", err)
+	}
+	s := newTestServer()
+	s.db = db
+	first, second := newQueuedSession(t, s, 1, "first"), newQueuedSession(t, s, 1, "second")
+	first.presenceKey, second.presenceKey = "first", "second"
+	for _, session := range []*Session{first, second} {
+		if err := holdSignToken(session, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	releaseSignToken(first)
+	if _, err := checkSignToken(db, userID, id, "abcdefghijklmnop", ttl, time.Now()); err != nil {
+		t.Errorf("token taken over by another session got %v after the first logged out", err)
+	}
+	releaseSignToken(second)
+	if _, err := checkSignToken(db, userID, id, "abcdefghijklmnop", ttl, time.Now()); err != errSignTokenInvalid {
+		t.Errorf("token of a session that logged out got %v, want %v", err, errSignTokenInvalid)
+	}
+
+	id = issue("qrstuvwxyzABCDEF")
+	if _, err := checkSignToken(db, userID, id, "wrongtokenwrongt", ttl, time.Now()); err != errSignTokenInvalid {
+		t.Errorf("wrong token got %v, want %v", err, errSignTokenInvalid)
+	}
+	if _, err := checkSignToken(db, userID, id, "qrstuvwxyzABCDEF", ttl, time.Now().Add(ttl+time.Second)); err != errSignTokenExpired {
+		t.Errorf("expired token got %v, want %v", err, errSignTokenExpired)
+	}
+
+	// The sign in's traceparent comes back so the login continues its trace.
+	const traceparent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	id = issue("tracedtokentrace")
+	if _, err := db.Exec("UPDATE sign_sessions SET trace_parent = $1 WHERE id = $2", traceparent, id); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := checkSignToken(db, userID, id, "tracedtokentrace", ttl, time.Now()); err != nil || got != traceparent {
+		t.Errorf("traced token got %q, %v, want %q", got, err, traceparent)
+	}
+}
@@ -1,6 +1,7 @@
 package channelserver
 
 import (
+	"sort"
 	"sync"
 
 	"time"
@@ -15,6 +16,16 @@ type StageObject struct {
 	id          uint32
 	ownerCharID uint32
 	x, y, z     float32
+
+	// Set for the owner's own character, which is removed when it leaves rather than handed over.
+	avatar bool
+}
+
+// stageObjectState is a copy of a stage object, safe to read once the stage lock is released.
+type stageObjectState struct {
+	id          uint32
+	ownerCharID uint32
+	x, y, z     float32
 }
 
 type ObjectMap struct {
@@ -30,6 +41,11 @@ type stageBinaryKey struct {
 }
 
 // Stage holds stage-specific information
+//
+// Locks must be taken in the order Server.stagesLock -> Stage -> Session, and only one
+// stage may be locked at a time. Never look up stages while holding a stage lock, and never
+// lock a stage while holding a session lock: read what's needed from the session, unlock it,
+// then lock the stage.
 type Stage struct {
 	sync.RWMutex
 
@@ -43,14 +59,30 @@ type Stage struct {
 	objects map[uint32]*StageObject
 
 	objectList map[uint8]*ObjectMap
+
+	// Temporary admin spawned objects, keyed by object ID.
+	eventObjects map[uint32]*EventObject
 	// Map of session -> charID.
 	// These are clients that are CURRENTLY in the stage
 	clients map[*Session]uint32
 
+	// Order the clients joined in, used to pick who inherits the objects of a client that leaves.
+	clientJoinOrder map[*Session]uint64
+	joinCount       uint64
+
 	// Map of charID -> interface{}, only the key is used, value is always nil.
 	// These are clients that aren't in the stage, but have reserved a slot (for quests, etc).
 	reservedClientSlots map[uint32]interface{}
 
+	// Reservation requests waiting for a slot to free up, oldest first, see sys_reservation.go.
+	reservationQueue []*reservationRequest
+
+	// The host's departure waiting on the reserved members, see sys_ready_check.go.
+	readyCheck *readyCheck
+
+	// Slots held for invited characters by their ID, see sys_party_invite.go.
+	invites map[uint32]*partyInvite
+
 	// These are raw binary blobs that the stage owner sets,
 	// other clients expect the server to echo them back in the exact same format.
 	rawBinaryData map[stageBinaryKey][]byte
@@ -59,6 +91,20 @@ type Stage struct {
 	hasDeparted bool
 	password    string
 	createdAt   string
+
+	// Session that created the stage, whose blocklist applies to it.
+	host *Session
+
+	// Quest binaries patched for this instance's party size, see sys_quest_scaling.go.
+	questFiles map[string][]byte
+
+	// Quest file the instance's members loaded and when the stage was created, see sys_quest_instance.go.
+	questID string
+	created time.Time
+
+	// Set once the stage has been removed from the server stage map.
+	// Sessions holding a stale pointer must not join or reserve it.
+	destroyed bool
 }
 
 // NewStage creates a new stage with intialized values.
@@ -66,20 +112,114 @@ func NewStage(ID string) *Stage {
 	s := &Stage{
 		id:                  ID,
 		clients:             make(map[*Session]uint32),
+		clientJoinOrder:     make(map[*Session]uint64),
 		reservedClientSlots: make(map[uint32]interface{}),
 		objects:             make(map[uint32]*StageObject),
 		rawBinaryData:       make(map[stageBinaryKey][]byte),
 		maxPlayers:          4,
 		gameObjectCount:     1,
 		objectList:			 make(map[uint8]*ObjectMap),
+		eventObjects:        make(map[uint32]*EventObject),
 		createdAt:           time.Now().Format("01-02-2006 15:04:05"),
+		created:             time.Now(),
 	}
 	s.InitObjectList()
 	return s
 }
 
+// stageList returns the stages, to walk without holding the stages lock.
+func (s *Server) stageList() []*Stage {
+	s.stagesLock.RLock()
+	defer s.stagesLock.RUnlock()
+	stages := make([]*Stage, 0, len(s.stages))
+	for _, stage := range s.stages {
+		stages = append(stages, stage)
+	}
+	return stages
+}
+
+// GetStage returns the stage with the given ID, if it exists.
+func (s *Server) GetStage(stageID string) (*Stage, bool) {
+	s.stagesLock.RLock()
+	defer s.stagesLock.RUnlock()
+	stage, ok := s.stages[stageID]
+	return stage, ok
+}
+
+// GetOrCreateStage returns the stage with the given ID, creating it if it doesn't exist yet.
+// The boolean result reports whether a new stage was created.
+func (s *Server) GetOrCreateStage(stageID string) (*Stage, bool) {
+	s.stagesLock.Lock()
+	defer s.stagesLock.Unlock()
+	if stage, exists := s.stages[stageID]; exists {
+		return stage, false
+	}
+	stage := NewStage(stageID)
+	s.stages[stageID] = stage
+	return stage, true
+}
+
+// CreateStage creates and registers a new stage, failing if the stage ID is already in use.
+func (s *Server) CreateStage(stageID string, maxPlayers uint16) (*Stage, bool) {
+	s.stagesLock.Lock()
+	defer s.stagesLock.Unlock()
+	if _, exists := s.stages[stageID]; exists {
+		return nil, false
+	}
+	stage := NewStage(stageID)
+	stage.maxPlayers = maxPlayers
+	s.stages[stageID] = stage
+	return stage, true
+}
+
+// RemoveStageIfEmpty removes the stage if it has no clients and no reserved slots.
+// The stages lock is held across the whole check so a concurrent create/join can't
+// observe a half-removed stage.
+func (s *Server) RemoveStageIfEmpty(stageID string) bool {
+	s.stagesLock.Lock()
+	defer s.stagesLock.Unlock()
+	stage, exists := s.stages[stageID]
+	if !exists {
+		return false
+	}
+	stage.Lock()
+	defer stage.Unlock()
+	if len(stage.clients) > 0 || len(stage.reservedClientSlots) > 0 {
+		return false
+	}
+	stage.destroyed = true
+	stage.dropInvitesLocked()
+	delete(s.stages, stageID)
+	return true
+}
+
+// RemoveStage unconditionally removes the stage with the given ID.
+func (s *Server) RemoveStage(stageID string) bool {
+	s.stagesLock.Lock()
+	defer s.stagesLock.Unlock()
+	stage, exists := s.stages[stageID]
+	if !exists {
+		return false
+	}
+	stage.Lock()
+	stage.destroyed = true
+	acks := stage.failQueuedReservationsLocked()
+	stage.dropInvitesLocked()
+	stage.Unlock()
+	delete(s.stages, stageID)
+	sendReservationAcks(acks)
+	return true
+}
+
 // BroadcastMHF queues a MHFPacket to be sent to all sessions in the stage.
 func (s *Stage) BroadcastMHF(pkt mhfpacket.MHFPacket, ignoredSession *Session) {
+	s.RLock()
+	defer s.RUnlock()
+	s.broadcastMHFLocked(pkt, ignoredSession)
+}
+
+// broadcastMHFLocked is BroadcastMHF for callers that already hold the stage lock.
+func (s *Stage) broadcastMHFLocked(pkt mhfpacket.MHFPacket, ignoredSession *Session) {
 	// Broadcast the data.
 	for session := range s.clients {
 		if session == ignoredSession {
@@ -98,6 +238,131 @@ func (s *Stage) BroadcastMHF(pkt mhfpacket.MHFPacket, ignoredSession *Session) {
 	}
 }
 
+// addClient puts the session in the stage. The caller must hold the stage lock.
+func (s *Stage) addClient(session *Session) {
+	s.clients[session] = session.charID
+	s.joinCount++
+	s.clientJoinOrder[session] = s.joinCount
+}
+
+// longestPresentClient returns the client that has been in the stage the longest, or nil if it's empty.
+// The caller must hold the stage lock.
+func (s *Stage) longestPresentClient() *Session {
+	var oldest *Session
+	for session := range s.clients {
+		if oldest == nil || s.clientJoinOrder[session] < s.clientJoinOrder[oldest] ||
+			(s.clientJoinOrder[session] == s.clientJoinOrder[oldest] && session.charID < oldest.charID) {
+			oldest = session
+		}
+	}
+	return oldest
+}
+
+// clientsByJoinOrder lists the clients, longest present first, so what's sent about them doesn't
+// change from one entry to the next. The caller holds the stage lock.
+func (s *Stage) clientsByJoinOrder() []*Session {
+	clients := make([]*Session, 0, len(s.clients))
+	for session := range s.clients {
+		clients = append(clients, session)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		a, b := s.clientJoinOrder[clients[i]], s.clientJoinOrder[clients[j]]
+		return a < b || a == b && clients[i].charID < clients[j].charID
+	})
+	return clients
+}
+
+// createObject adds an object owned by the character and returns its ID.
+// The first object a client creates in a stage is its own character.
+func (s *Stage) createObject(ownerCharID uint32, x, y, z float32) uint32 {
+	s.Lock()
+	defer s.Unlock()
+	avatar := true
+	for _, obj := range s.objects {
+		if obj.ownerCharID == ownerCharID && obj.avatar {
+			avatar = false
+			break
+		}
+	}
+	objID := s.GetNewObjectID(ownerCharID)
+	s.objects[objID] = &StageObject{
+		id:          objID,
+		ownerCharID: ownerCharID,
+		x:           x,
+		y:           y,
+		z:           z,
+		avatar:      avatar,
+	}
+	return objID
+}
+
+// moveObject moves an object, if the character owns it.
+func (s *Stage) moveObject(objID uint32, ownerCharID uint32, x, y, z float32) {
+	s.Lock()
+	defer s.Unlock()
+	if obj, ok := s.objects[objID]; ok && obj.ownerCharID == ownerCharID {
+		obj.x, obj.y, obj.z = x, y, z
+	}
+}
+
+// objectStates returns a copy of every object in the stage.
+func (s *Stage) objectStates() []stageObjectState {
+	s.RLock()
+	defer s.RUnlock()
+	states := make([]stageObjectState, 0, len(s.objects))
+	for _, obj := range s.objects {
+		states = append(states, stageObjectState{obj.id, obj.ownerCharID, obj.x, obj.y, obj.z})
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].id < states[j].id })
+	return states
+}
+
+// avatarState returns a copy of the character's own object in the stage.
+func (s *Stage) avatarState(charID uint32) (stageObjectState, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	for _, obj := range s.objects {
+		if obj.ownerCharID == charID && obj.avatar {
+			return stageObjectState{obj.id, obj.ownerCharID, obj.x, obj.y, obj.z}, true
+		}
+	}
+	return stageObjectState{}, false
+}
+
+// stageSummary is a copy of what the Discord listings show about a stage.
+type stageSummary struct {
+	clients     []*Session
+	reserved    []uint32
+	objects     []stageObjectState
+	maxPlayers  uint16
+	hasDeparted bool
+	createdAt   string
+}
+
+// summary copies what the Discord listings show about the stage, so they don't hold its lock.
+func (s *Stage) summary() stageSummary {
+	s.RLock()
+	defer s.RUnlock()
+	summary := stageSummary{
+		clients:     make([]*Session, 0, len(s.clients)),
+		reserved:    make([]uint32, 0, len(s.reservedClientSlots)),
+		objects:     make([]stageObjectState, 0, len(s.objects)),
+		maxPlayers:  s.maxPlayers,
+		hasDeparted: s.hasDeparted,
+		createdAt:   s.createdAt,
+	}
+	for session := range s.clients {
+		summary.clients = append(summary.clients, session)
+	}
+	for charID := range s.reservedClientSlots {
+		summary.reserved = append(summary.reserved, charID)
+	}
+	for _, obj := range s.objects {
+		summary.objects = append(summary.objects, stageObjectState{obj.id, obj.ownerCharID, obj.x, obj.y, obj.z})
+	}
+	return summary
+}
+
 func (s *Stage) InitObjectList() {
 	for seq := uint8(0x7f); seq > uint8(0); seq-- {
 		newObj := &ObjectMap{
@@ -0,0 +1,367 @@
+package channelserver
+
+import (
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"go.uber.org/zap"
+)
+
+func newTestServer() *Server {
+	return NewServer(&Config{
+		Logger:      zap.NewNop(),
+		ErupeConfig: &config.Config{},
+	})
+}
+
+func TestStageCreateRemoveRace(t *testing.T) {
+	s := newTestServer()
+	stageID := "sl1Qs000p0a0u0"
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(charID uint32) {
+			defer wg.Done()
+			for n := 0; n < 200; n++ {
+				stage, _ := s.GetOrCreateStage(stageID)
+
+				stage.Lock()
+				if stage.destroyed {
+					stage.Unlock()
+					continue
+				}
+				stage.reservedClientSlots[charID] = nil
+				stage.Unlock()
+
+				// A stage with a reserved slot must never be removed from under us.
+				if current, ok := s.GetStage(stageID); !ok || current != stage {
+					t.Errorf("char %d reserved a slot in a stage that is no longer registered", charID)
+					return
+				}
+
+				stage.Lock()
+				delete(stage.reservedClientSlots, charID)
+				stage.Unlock()
+
+				s.RemoveStageIfEmpty(stageID)
+			}
+		}(uint32(i + 1))
+	}
+	wg.Wait()
+
+	if stage, ok := s.GetStage(stageID); ok {
+		stage.RLock()
+		defer stage.RUnlock()
+		if len(stage.reservedClientSlots) != 0 {
+			t.Errorf("got %d leftover reservations, want 0", len(stage.reservedClientSlots))
+		}
+	}
+}
+
+func TestCreateStageRejectsDuplicate(t *testing.T) {
+	s := newTestServer()
+	stageID := "sl1Qs000p0a0u0"
+
+	if _, created := s.CreateStage(stageID, 4); !created {
+		t.Fatal("first CreateStage should succeed")
+	}
+	if _, created := s.CreateStage(stageID, 4); created {
+		t.Fatal("second CreateStage with the same ID should fail")
+	}
+	if !s.RemoveStageIfEmpty(stageID) {
+		t.Fatal("empty stage should be removed")
+	}
+	if _, created := s.CreateStage(stageID, 4); !created {
+		t.Fatal("CreateStage should succeed after removal")
+	}
+}
+
+func TestBackStageRecreatesRemovedStage(t *testing.T) {
+	s := NewServer(&Config{
+		Logger:       zap.NewNop(),
+		ErupeConfig:  &config.Config{},
+		DefaultStage: RastaBarStageId,
+	})
+	session, client := newTestSession(s)
+	go io.Copy(ioutil.Discard, client)
+	defer client.Close()
+	const town, away = "sl1Ns999p0a0u0", "sl1Ns998p0a0u0"
+
+	handleMsgSysEnterStage(session, &mhfpacket.MsgSysEnterStage{StageID: town})
+	handleMsgSysMoveStage(session, &mhfpacket.MsgSysMoveStage{StageID: away})
+	// Everyone else left town while the session was away.
+	if !s.RemoveStageIfEmpty(town) {
+		t.Fatal("the empty town wasn't removed")
+	}
+	handleMsgSysBackStage(session, &mhfpacket.MsgSysBackStage{})
+	stage, exists := s.GetStage(town)
+	if !exists || session.stage != stage || session.stageID != town {
+		t.Fatalf("back to the removed town the session is in %q", session.stageID)
+	}
+
+	// The way back from the first stage of the login is the world's default stage.
+	handleMsgSysBackStage(session, &mhfpacket.MsgSysBackStage{})
+	if session.stageID != RastaBarStageId {
+		t.Errorf("back from the first stage the session is in %q, want %q", session.stageID, RastaBarStageId)
+	}
+	if newTestServer().defaultStage != MezeportaStageId {
+		t.Errorf("without a default stage the default is %q", newTestServer().defaultStage)
+	}
+}
+
+// newBroadcastStage fills a stage with fast clients and one client that reads a packet
+// every 10ms, as if it were on a saturated connection.
+func newBroadcastStage(members int) (*Stage, func()) {
+	s := newTestServer()
+	stage := NewStage("sl1Ns200p0a0u0")
+	var clients []net.Conn
+	for i := 0; i < members; i++ {
+		session, client := newTestSession(s)
+		session.charID = uint32(i + 1)
+		stage.clients[session] = session.charID
+		clients = append(clients, client)
+		if i == 0 {
+			go func() {
+				buf := make([]byte, 64)
+				for {
+					if _, err := client.Read(buf); err != nil {
+						return
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+			}()
+		} else {
+			go io.Copy(ioutil.Discard, client)
+		}
+	}
+	return stage, func() {
+		for _, client := range clients {
+			client.Close()
+		}
+	}
+}
+
+func BenchmarkStageBroadcastSlowClient(b *testing.B) {
+	pkt := &mhfpacket.MsgSysDeleteObject{ObjID: 1}
+
+	// Writing to every member's socket in turn, which stalls behind the slow client.
+	b.Run("direct", func(b *testing.B) {
+		stage, closeClients := newBroadcastStage(4)
+		defer closeClients()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for session := range stage.clients {
+				bf := byteframe.NewByteFrame()
+				bf.WriteUint16(uint16(pkt.Opcode()))
+				pkt.Build(bf, session.clientContext)
+				session.cryptConn.SendPacket(append(bf.Data(), 0x00, 0x10))
+			}
+		}
+	})
+
+	// Going through each member's send queue.
+	b.Run("queued", func(b *testing.B) {
+		stage, closeClients := newBroadcastStage(4)
+		defer closeClients()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			stage.BroadcastMHF(pkt, nil)
+		}
+	})
+}
+
+// Run with -race, and with -tags lockorder to panic on the first lock taken out of order.
+func TestStageHandlersConcurrentLockOrder(t *testing.T) {
+	if testing.Short() {
+		t.Skip("stress test")
+	}
+	s := newTestServer()
+	stageIDs := []string{"sl1Ns200p0a0u0", "sl1Ns211p0a0u0", "sl1Ns260p0a0u0"}
+	deadline := time.Now().Add(3 * time.Second)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		session, client := newTestSession(s)
+		session.charID = uint32(i + 1)
+		s.Lock()
+		s.sessions[session.rawConn] = session
+		s.Unlock()
+		go io.Copy(ioutil.Discard, client)
+		defer client.Close()
+
+		wg.Add(1)
+		go func(session *Session, rng *rand.Rand) {
+			defer wg.Done()
+			handleMsgSysEnterStage(session, &mhfpacket.MsgSysEnterStage{StageID: stageIDs[0]})
+			for time.Now().Before(deadline) {
+				stageID := stageIDs[rng.Intn(len(stageIDs))]
+				switch rng.Intn(6) {
+				case 0:
+					handleMsgSysEnterStage(session, &mhfpacket.MsgSysEnterStage{StageID: stageID})
+					handleMsgSysBackStage(session, &mhfpacket.MsgSysBackStage{})
+				case 1:
+					handleMsgSysMoveStage(session, &mhfpacket.MsgSysMoveStage{StageID: stageID})
+				case 2:
+					handleMsgSysEnumerateStage(session, &mhfpacket.MsgSysEnumerateStage{})
+				case 3:
+					handleMsgSysEnumerateClient(session, &mhfpacket.MsgSysEnumerateClient{StageID: stageID})
+				case 4:
+					handleMsgSysSetStageBinary(session, &mhfpacket.MsgSysSetStageBinary{StageID: stageID, BinaryType0: 1, BinaryType1: 1, RawDataPayload: []byte{1}})
+					handleMsgSysGetStageBinary(session, &mhfpacket.MsgSysGetStageBinary{StageID: stageID, BinaryType0: 1, BinaryType1: 1})
+				case 5:
+					handleMsgSysReserveStage(session, &mhfpacket.MsgSysReserveStage{StageID: stageID})
+					handleMsgSysUnreserveStage(session, nil)
+					s.RemoveStageIfEmpty(stageID)
+				}
+			}
+		}(session, rand.New(rand.NewSource(int64(i))))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Until(deadline) + 10*time.Second):
+		buf := make([]byte, 1<<20)
+		t.Fatalf("stage handlers deadlocked:\n%s", buf[:runtime.Stack(buf, true)])
+	}
+}
+
+// newObjectOwnerStage puts sessions for the charIDs in a guild hall in order,
+// then has the first one create its character and one more object.
+func newObjectOwnerStage(t *testing.T, charIDs ...uint32) (*Stage, []*Session, *StageObject) {
+	s := newTestServer()
+	var sessions []*Session
+	for _, charID := range charIDs {
+		session, client := newTestSession(s)
+		session.charID = charID
+		go io.Copy(ioutil.Discard, client)
+		t.Cleanup(func() { client.Close() })
+		handleMsgSysEnterStage(session, &mhfpacket.MsgSysEnterStage{StageID: GuildHallLv1StageId})
+		sessions = append(sessions, session)
+	}
+	owner := sessions[0]
+	handleMsgSysCreateObject(owner, &mhfpacket.MsgSysCreateObject{})
+	handleMsgSysCreateObject(owner, &mhfpacket.MsgSysCreateObject{X: 1})
+
+	var object *StageObject
+	for _, obj := range owner.stage.objects {
+		if !obj.avatar {
+			object = obj
+		}
+	}
+	if object == nil || len(owner.stage.objects) != 2 {
+		t.Fatalf("got %d objects, want the owner's character and one object", len(owner.stage.objects))
+	}
+	return owner.stage, sessions, object
+}
+
+func stageObjectOwners(stage *Stage) map[uint32]uint32 {
+	stage.RLock()
+	defer stage.RUnlock()
+	owners := make(map[uint32]uint32)
+	for objID, obj := range stage.objects {
+		owners[objID] = obj.ownerCharID
+	}
+	return owners
+}
+
+func TestStageObjectsPassToLongestPresentClient(t *testing.T) {
+	stage, sessions, object := newObjectOwnerStage(t, 1, 2, 3)
+
+	handleMsgSysMoveStage(sessions[0], &mhfpacket.MsgSysMoveStage{StageID: MezeportaStageId})
+
+	owners := stageObjectOwners(stage)
+	if len(owners) != 1 {
+		t.Fatalf("got %d objects after the owner left, want only the handed over object", len(owners))
+	}
+	if owner := owners[object.id]; owner != 2 {
+		t.Errorf("object owned by char %d after the owner left, want char 2", owner)
+	}
+}
+
+func TestStageObjectsPassOnAbruptDisconnect(t *testing.T) {
+	stage, sessions, object := newObjectOwnerStage(t, 1, 2)
+
+	sessions[0].disconnect()
+
+	if owner, ok := stageObjectOwners(stage)[object.id]; !ok || owner != 2 {
+		t.Errorf("object owned by char %d after the owner disconnected, want char 2", owner)
+	}
+}
+
+func TestStageObjectsDeletedWhenStageEmpties(t *testing.T) {
+	stage, sessions, _ := newObjectOwnerStage(t, 1, 2)
+
+	handleMsgSysMoveStage(sessions[0], &mhfpacket.MsgSysMoveStage{StageID: MezeportaStageId})
+	handleMsgSysMoveStage(sessions[1], &mhfpacket.MsgSysMoveStage{StageID: MezeportaStageId})
+
+	if owners := stageObjectOwners(stage); len(owners) != 0 {
+		t.Errorf("got %d objects left in the empty stage, want 0", len(owners))
+	}
+	stage.RLock()
+	defer stage.RUnlock()
+	for _, slot := range stage.objectList {
+		if slot.status {
+			t.Errorf("object slot %d still in use in the empty stage", slot.id)
+		}
+	}
+}
+
+func TestStageObjectConcurrentAccess(t *testing.T) {
+	s := newTestServer()
+	var sessions []*Session
+	for charID := uint32(1); charID <= 6; charID++ {
+		session, client := newTestSession(s)
+		session.charID = charID
+		go io.Copy(ioutil.Discard, client)
+		t.Cleanup(func() { client.Close() })
+		sessions = append(sessions, session)
+	}
+
+	// Each session keeps creating, moving and enumerating objects, leaving and re-entering the stage
+	// while the Discord listings walk every stage.
+	var wg sync.WaitGroup
+	for i, session := range sessions {
+		wg.Add(1)
+		go func(session *Session, rng *rand.Rand) {
+			defer wg.Done()
+			handleMsgSysEnterStage(session, &mhfpacket.MsgSysEnterStage{StageID: GuildHallLv1StageId})
+			for n := 0; n < 200; n++ {
+				switch rng.Intn(5) {
+				case 0:
+					handleMsgSysCreateObject(session, &mhfpacket.MsgSysCreateObject{X: float32(n)})
+				case 1:
+					for _, obj := range session.stage.objectStates() {
+						handleMsgSysPositionObject(session, &mhfpacket.MsgSysPositionObject{ObjID: obj.id, X: float32(n)})
+					}
+				case 2:
+					handleMsgSysMoveStage(session, &mhfpacket.MsgSysMoveStage{StageID: MezeportaStageId})
+					handleMsgSysMoveStage(session, &mhfpacket.MsgSysMoveStage{StageID: GuildHallLv1StageId})
+				case 3:
+					// Without reservations, the listings don't need the DB.
+					debug(s)
+					CountChars(s)
+				case 4:
+					for _, stage := range s.stageList() {
+						stage.summary()
+					}
+				}
+			}
+		}(session, rand.New(rand.NewSource(int64(i))))
+	}
+	wg.Wait()
+}
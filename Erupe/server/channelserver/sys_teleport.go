@@ -0,0 +1,136 @@
+package channelserver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+var (
+	errTeleportSameStage = errors.New("already in the stage")
+	errTeleportStageGone = errors.New("stage was removed")
+	errTeleportOnQuest   = errors.New("stage is on a quest")
+	errTeleportStageFull = errors.New("stage is full")
+)
+
+// moveSessionToStage moves the session into the stage the way its client does: it leaves its stage,
+// then enters the new one and holds a slot in it if it's a quest stage. A quest stage with every slot
+// taken refuses, and so does one already out on its quest unless forced.
+func moveSessionToStage(s *Session, stage *Stage, force bool) error {
+	s.Lock()
+	current := s.stage
+	s.Unlock()
+	if current == stage {
+		return errTeleportSameStage
+	}
+
+	quest := isQuestStageID(stage.id)
+	stage.RLock()
+	_, reserved := stage.reservedClientSlots[s.charID]
+	full := !reserved && uint16(stage.slotsTakenLocked()) >= stage.maxPlayers
+	destroyed := stage.destroyed
+	stage.RUnlock()
+	switch {
+	case destroyed:
+		return errTeleportStageGone
+	case quest && !force:
+		return errTeleportOnQuest
+	case quest && full:
+		return errTeleportStageFull
+	}
+
+	cancelStageReservation(s)
+	transferToStage(s, stage.id, nil)
+	if quest {
+		stage.Lock()
+		stage.reservedClientSlots[s.charID] = nil
+		stage.Unlock()
+		s.Lock()
+		s.reservationStage = stage
+		s.Unlock()
+	}
+	return nil
+}
+
+// teleportFailure tells the moderator why the move didn't happen.
+func teleportFailure(err error, name string) string {
+	switch err {
+	case errTeleportSameStage:
+		return fmt.Sprintf("You're already with %s.", name)
+	case errTeleportOnQuest:
+		return fmt.Sprintf("%s's party is on a quest, add \"force\" to move anyway.", name)
+	case errTeleportStageFull:
+		return fmt.Sprintf("%s's party is full.", name)
+	default:
+		return fmt.Sprintf("%s's stage is gone.", name)
+	}
+}
+
+// handleModeratorMoveCommand runs "!tele <player> [force]", which moves the moderator to the player's stage,
+// and "!summon <player> [force]", which moves the player to the moderator's. Both have to be on this channel.
+// It reports false if the session isn't a moderator or an admin, so the command can fall back to its player form.
+func handleModeratorMoveCommand(s *Session, message string) bool {
+	args := strings.Fields(message)
+	s.Lock()
+	moderator := s.moderator || s.admin
+	s.Unlock()
+	if !moderator || args[0] != "!tele" && args[0] != "!summon" {
+		return false
+	}
+
+	summon := args[0] == "!summon"
+	force := len(args) > 2 && args[len(args)-1] == "force"
+	if force {
+		args = args[:len(args)-1]
+	}
+	if len(args) < 2 {
+		sendServerChatMessage(s, fmt.Sprintf("Usage: \"%s <player> [force]\"", args[0]))
+		return true
+	}
+	name := strings.Join(args[1:], " ")
+
+	target := s.server.findSessionByNameOnAnyChannel(name)
+	switch {
+	case target == nil:
+		sendServerChatMessage(s, fmt.Sprintf("No one named %s is online.", name))
+		return true
+	case target == s:
+		sendServerChatMessage(s, "You can't move to yourself.")
+		return true
+	case target.server != s.server:
+		sendServerChatMessage(s, fmt.Sprintf("%s is on %s, switch to it first.", name, target.server.name))
+		return true
+	}
+
+	mover, destination := s, target
+	if summon {
+		mover, destination = target, s
+	}
+	destination.Lock()
+	stage := destination.stage
+	destination.Unlock()
+	if stage == nil {
+		sendServerChatMessage(s, fmt.Sprintf("%s isn't in a stage.", destination.Name))
+		return true
+	}
+	if err := moveSessionToStage(mover, stage, force); err != nil {
+		sendServerChatMessage(s, teleportFailure(err, destination.Name))
+		return true
+	}
+
+	s.logger.Info("Moderator moved a character",
+		zap.Uint32("moderator", s.charID),
+		zap.Uint32("charID", mover.charID),
+		zap.String("stageID", stage.id),
+	)
+	if summon {
+		sendServerChatMessage(s, fmt.Sprintf("Summoned %s.", target.Name))
+		sendServerChatMessage(target, "A moderator summoned you to them.")
+	} else {
+		sendServerChatMessage(s, fmt.Sprintf("Teleported to %s.", target.Name))
+		sendServerChatMessage(target, "A moderator teleported to you.")
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+package channelserver
+
+import (
+	"testing"
+)
+
+func TestTeleportAndSummon(t *testing.T) {
+	s := newTestServer()
+	moderator := newStagedSession(t, s, 1, "Mod", "sl1Ns200p0a0u0")
+	moderator.moderator = true
+	hunter := newStagedSession(t, s, 2, "Hunter", "sl1Ns211p0a0u0")
+
+	if !handleModeratorMoveCommand(moderator, "!tele hunter") {
+		t.Fatal("moderator's command wasn't run")
+	}
+	if !inStage(s, "sl1Ns211p0a0u0", moderator) || inStage(s, "sl1Ns200p0a0u0", moderator) {
+		t.Fatal("moderator didn't move to the player's stage")
+	}
+
+	transferToStage(moderator, "sl1Ns200p0a0u0", nil)
+	handleModeratorMoveCommand(moderator, "!summon Hunter")
+	if !inStage(s, "sl1Ns200p0a0u0", hunter) || inStage(s, "sl1Ns211p0a0u0", hunter) {
+		t.Fatal("player wasn't summoned to the moderator's stage")
+	}
+
+	// Players get the coordinate form of "!tele" and nothing for "!summon".
+	if handleModeratorMoveCommand(hunter, "!summon Mod") || handleModeratorMoveCommand(hunter, "!tele Mod") {
+		t.Error("a player ran a moderator command")
+	}
+	if !inStage(s, "sl1Ns200p0a0u0", moderator) {
+		t.Error("a player's command moved the moderator")
+	}
+
+	// Admins can run the moderator commands too.
+	hunter.admin = true
+	if !handleModeratorMoveCommand(hunter, "!tele Mod") {
+		t.Error("an admin couldn't run a moderator command")
+	}
+}
+
+func TestTeleportRespectsQuests(t *testing.T) {
+	s := newTestServer()
+	questID := "sl1Qs000p0a0u0"
+	quest, _ := s.CreateStage(questID, 1)
+	moderator := newStagedSession(t, s, 1, "Mod", "sl1Ns200p0a0u0")
+	moderator.moderator = true
+	// The player is placed in the quest directly, walking in and out of one reaches the database.
+	hunter := newReservingSession(t, s, 2)
+	hunter.Name = "Hunter"
+	s.Lock()
+	s.sessions[hunter.rawConn] = hunter
+	s.Unlock()
+	quest.Lock()
+	quest.addClient(hunter)
+	quest.reservedClientSlots[hunter.charID] = nil
+	quest.Unlock()
+	hunter.stage = quest
+	hunter.stageID = questID
+
+	if err := moveSessionToStage(moderator, quest, false); err != errTeleportOnQuest {
+		t.Errorf("got %v teleporting into a quest, want %v", err, errTeleportOnQuest)
+	}
+	if err := moveSessionToStage(moderator, quest, true); err != errTeleportStageFull {
+		t.Errorf("got %v forcing into a full quest, want %v", err, errTeleportStageFull)
+	}
+	handleModeratorMoveCommand(moderator, "!tele Hunter force")
+	if !inStage(s, "sl1Ns200p0a0u0", moderator) || inStage(s, questID, moderator) {
+		t.Error("moderator was moved into a full quest")
+	}
+}
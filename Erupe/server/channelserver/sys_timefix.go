@@ -16,23 +16,26 @@ var (
 	TimeStatic = time.Time{}
 )
 
+// timeNow is the clock the Time_ functions read, a replay sets it to when each packet was captured.
+var timeNow = time.Now
+
 func Time_Current() time.Time {
-	baseTime := time.Now().In(time.FixedZone(fmt.Sprintf("UTC+%d", Offset), Offset*60*60))
+	baseTime := timeNow().In(time.FixedZone(fmt.Sprintf("UTC+%d", Offset), Offset*60*60))
 	return baseTime
 }
 
 func Time_Current_Adjusted() time.Time {
-	baseTime := time.Now().In(time.FixedZone(fmt.Sprintf("UTC+%d", Offset), Offset*60*60)).AddDate(YearAdjust, MonthAdjust, DayAdjust)
+	baseTime := timeNow().In(time.FixedZone(fmt.Sprintf("UTC+%d", Offset), Offset*60*60)).AddDate(YearAdjust, MonthAdjust, DayAdjust)
 	return time.Date(baseTime.Year(), baseTime.Month(), baseTime.Day(), baseTime.Hour(), baseTime.Minute(), baseTime.Second(), baseTime.Nanosecond(), baseTime.Location())
 }
 
 func Time_Current_Midnight() time.Time {
-	baseTime := time.Now().In(time.FixedZone(fmt.Sprintf("UTC+%d", Offset), Offset*60*60)).AddDate(YearAdjust, MonthAdjust, DayAdjust)
+	baseTime := timeNow().In(time.FixedZone(fmt.Sprintf("UTC+%d", Offset), Offset*60*60)).AddDate(YearAdjust, MonthAdjust, DayAdjust)
 	return time.Date(baseTime.Year(), baseTime.Month(), baseTime.Day(), 0, 0, 0, 0, baseTime.Location())
 }
 
 func Time_Current_Week_uint8() uint8 {
-	baseTime := time.Now().In(time.FixedZone(fmt.Sprintf("UTC+%d", Offset), Offset*60*60)).AddDate(YearAdjust, MonthAdjust, DayAdjust)
+	baseTime := timeNow().In(time.FixedZone(fmt.Sprintf("UTC+%d", Offset), Offset*60*60)).AddDate(YearAdjust, MonthAdjust, DayAdjust)
 
 	_, thisWeek := baseTime.ISOWeek()
 	_, beginningOfTheMonth := time.Date(baseTime.Year(), baseTime.Month(), 1, 0, 0, 0, 0, baseTime.Location()).ISOWeek()
@@ -0,0 +1,76 @@
+package channelserver
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/jmoiron/sqlx"
+)
+
+// Titles every character has, IDs 0 up to this one.
+const defaultTitleCount = 114
+
+// grantTitle records a title the server hands out. A title the character already has keeps its time.
+func grantTitle(db sqlx.Execer, charID uint32, titleID uint16) error {
+	_, err := db.Exec(`
+		INSERT INTO character_titles (character_id, title_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, charID, titleID)
+	return err
+}
+
+// loadTitles returns the titles granted to the character with when they were acquired.
+func loadTitles(db *sqlx.DB, charID uint32) (map[uint16]time.Time, error) {
+	if db == nil {
+		return nil, nil
+	}
+	rows, err := db.Query("SELECT title_id, acquired_at FROM character_titles WHERE character_id = $1", charID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	titles := make(map[uint16]time.Time)
+	for rows.Next() {
+		var titleID uint16
+		var acquiredAt time.Time
+		if err := rows.Scan(&titleID, &acquiredAt); err != nil {
+			return nil, err
+		}
+		titles[titleID] = acquiredAt
+	}
+	return titles, rows.Err()
+}
+
+// buildTitleList lists the default titles followed by the other granted ones in ID order. Granted
+// titles carry the time they were acquired.
+func buildTitleList(granted map[uint16]time.Time) []byte {
+	var extra []uint16
+	for id := range granted {
+		if id >= defaultTitleCount {
+			extra = append(extra, id)
+		}
+	}
+	sort.Slice(extra, func(i, j int) bool { return extra[i] < extra[j] })
+	ids := make([]uint16, 0, defaultTitleCount+len(extra))
+	for id := uint16(0); id < defaultTitleCount; id++ {
+		ids = append(ids, id)
+	}
+	ids = append(ids, extra...)
+
+	bf := byteframe.NewByteFrame()
+	bf.WriteUint16(uint16(len(ids))) // title count
+	bf.WriteUint16(0)                // unk
+	for _, id := range ids {
+		var acquired uint32
+		if at, ok := granted[id]; ok {
+			acquired = uint32(at.Unix())
+		}
+		bf.WriteUint16(id)
+		bf.WriteUint16(0)        // unk
+		bf.WriteUint32(acquired) // timestamp acquired
+		bf.WriteUint32(acquired) // timestamp updated
+	}
+	return bf.Data()
+}
@@ -0,0 +1,46 @@
+package channelserver
+
+import (
+	"sync/atomic"
+
+	"github.com/Solenataris/Erupe/common/tracing"
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+)
+
+// startLoginTrace keeps the span of the session's login, under which the requests it handles until the
+// character enters the world are traced.
+func (s *Session) startLoginTrace(span *tracing.Span) {
+	s.Lock()
+	defer s.Unlock()
+	s.loginSpan = span
+}
+
+// endLoginTrace ends the span of the session's login, if it's still open.
+func (s *Session) endLoginTrace() {
+	s.Lock()
+	span := s.loginSpan
+	s.loginSpan = nil
+	s.Unlock()
+	span.End()
+}
+
+// tracingMiddleware traces every request handled between the login and the character entering the world,
+// the stretch a slow login is spent in, as a span named after its opcode. It's only used with tracing on.
+func tracingMiddleware(opcode network.PacketID, next handlerFunc) handlerFunc {
+	return func(s *Session, p mhfpacket.MHFPacket) {
+		s.Lock()
+		login := s.loginSpan
+		s.Unlock()
+		if login == nil {
+			next(s, p)
+			return
+		}
+		span := login.Child(opcode.String())
+		next(s, p)
+		span.End()
+		if atomic.LoadInt32(&s.inWorld) == 1 {
+			s.endLoginTrace()
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package channelserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/common/tracing"
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/network/mhfpacket"
+	"go.uber.org/zap"
+)
+
+func TestTracingLoginRequests(t *testing.T) {
+	var names []string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []struct {
+						Name string `json:"name"`
+					} `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		for _, resource := range req.ResourceSpans {
+			for _, span := range resource.ScopeSpans[0].Spans {
+				names = append(names, span.Name)
+			}
+		}
+	}))
+	defer collector.Close()
+	exporter := tracing.NewExporter(collector.URL, time.Hour, zap.NewNop())
+	s := NewServer(&Config{
+		Logger:      zap.NewNop(),
+		ErupeConfig: &config.Config{},
+		Tracer:      exporter.Tracer("erupe-channel"),
+	})
+	session := newQueuedSession(t, s, 100, "Hunter")
+	s.handlers.register(network.MSG_SYS_PING, func(s *Session, p mhfpacket.MHFPacket) {
+		if p.(*mhfpacket.MsgSysPing).AckHandle == 2 {
+			s.enteredWorld()
+		}
+	})
+
+	session.handlePacketGroup(makePingPacket(1))
+	session.startLoginTrace(s.tracer.Start("channel login"))
+	session.handlePacketGroup(makePingPacket(2))
+	// Requests after entering the world aren't traced.
+	session.handlePacketGroup(makePingPacket(3))
+	if session.loginSpan != nil || atomic.LoadInt32(&session.inWorld) != 1 {
+		t.Error("the login span is still open after entering the world")
+	}
+	exporter.Shutdown()
+
+	if len(names) != 2 || names[0] != "MSG_SYS_PING" || names[1] != "channel login" {
+		t.Errorf("got spans %v, want the ping handled during the login under the login span", names)
+	}
+}
@@ -0,0 +1,58 @@
+package channelserver
+
+import (
+	"github.com/Andoryuuta/byteframe"
+	"go.uber.org/zap"
+)
+
+// Trend weapons listed for each weapon type, and the size of the MSG_MHF_GET_TREND_WEAPON response.
+const (
+	trendWeaponsPerType     = 3
+	trendWeaponResponseSize = 0xA9
+)
+
+// trendWeapon is a weapon among the most used of its type.
+type trendWeapon struct {
+	WeaponType uint8  `db:"weapon_type"`
+	WeaponID   uint16 `db:"weapon_id"`
+}
+
+// recordTrendWeaponUse counts a use of the weapon the client reported.
+func recordTrendWeaponUse(s *Session, weaponType uint8, weaponID uint16) {
+	_, err := s.server.db.Exec(`
+		INSERT INTO trend_weapons (weapon_type, weapon_id, uses) VALUES ($1, $2, 1)
+		ON CONFLICT (weapon_type, weapon_id) DO UPDATE SET uses = trend_weapons.uses + 1
+	`, weaponType, weaponID)
+	if err != nil {
+		s.logger.Error("Failed to record trend weapon use", zap.Error(err))
+	}
+}
+
+// getTrendWeapons returns the most used weapons of each type, by type and then most used first.
+func getTrendWeapons(s *Session) ([]trendWeapon, error) {
+	var weapons []trendWeapon
+	err := s.server.db.Select(&weapons, `
+		SELECT weapon_type, weapon_id FROM (
+			SELECT weapon_type, weapon_id, ROW_NUMBER() OVER (PARTITION BY weapon_type ORDER BY uses DESC, weapon_id) AS n
+			FROM trend_weapons
+		) ranked
+		WHERE n <= $1 ORDER BY weapon_type, n
+	`, trendWeaponsPerType)
+	return weapons, err
+}
+
+// buildTrendWeapons serializes the MSG_MHF_GET_TREND_WEAPON response, a count and the type and ID of
+// each weapon, padded to the size the client reads. What doesn't fit is left out.
+func buildTrendWeapons(weapons []trendWeapon) []byte {
+	if max := (trendWeaponResponseSize - 1) / 3; len(weapons) > max {
+		weapons = weapons[:max]
+	}
+	bf := byteframe.NewByteFrame()
+	bf.WriteUint8(uint8(len(weapons)))
+	for _, weapon := range weapons {
+		bf.WriteUint8(weapon.WeaponType)
+		bf.WriteUint16(weapon.WeaponID)
+	}
+	bf.WriteBytes(make([]byte, trendWeaponResponseSize-len(bf.Data())))
+	return bf.Data()
+}
@@ -0,0 +1,44 @@
+package channelserver
+
+import (
+	"github.com/Solenataris/Erupe/common/wordfilter"
+	"github.com/Solenataris/Erupe/network/binpacket"
+	"go.uber.org/zap"
+)
+
+// loadWordFilter compiles the words of the config and of its word list file into the filter chat,
+// names and guild texts are checked against. A word list that fails to load leaves only the config's.
+func (s *Server) loadWordFilter() {
+	cfg := s.erupeConfig.Current()
+	words := append([]string(nil), cfg.WordFilter...)
+	if path := cfg.WordFilterFile; path != "" {
+		listed, err := wordfilter.LoadWords(path)
+		if err != nil {
+			s.logger.Error("Failed to load the word filter list", zap.String("path", path), zap.Error(err))
+		}
+		words = append(words, listed...)
+	}
+	s.wordFilter.Store(wordfilter.New(words))
+}
+
+// words returns the server's word filter, nil matching nothing until it's loaded.
+func (s *Server) words() *wordfilter.Filter {
+	filter, _ := s.wordFilter.Load().(*wordfilter.Filter)
+	return filter
+}
+
+// filterChat applies the chat filter to a message the session sent, masking the filtered words in it
+// or blocking it. It reports whether the message goes out, and if so whether it was changed.
+func filterChat(s *Session, chat *binpacket.MsgBinChat) (send bool, masked bool) {
+	mode := s.server.erupeConfig.Current().ChatFilter
+	if mode == "" || !s.server.words().Contains(chat.Message) {
+		return true, false
+	}
+	if mode == "block" {
+		s.logger.Info("Blocked chat message holding a filtered word")
+		sendServerChatMessage(s, "Your message wasn't sent, it contains a filtered word.")
+		return false, false
+	}
+	chat.Message = s.server.words().Mask(chat.Message)
+	return true, true
+}
@@ -0,0 +1,57 @@
+package channelserver
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/Solenataris/Erupe/network/binpacket"
+)
+
+func TestChatFilter(t *testing.T) {
+	s := newTestServer()
+	s.erupeConfig.WordFilter = []string{"noob"}
+	s.loadWordFilter()
+	sender := newQueuedSession(t, s, 1, "Sender")
+
+	chat := &binpacket.MsgBinChat{Message: "ＮＯＯＢ team"}
+	if send, masked := filterChat(sender, chat); !send || masked || chat.Message != "ＮＯＯＢ team" {
+		t.Errorf("filter off: send %v, masked %v, %q", send, masked, chat.Message)
+	}
+
+	s.erupeConfig.ChatFilter = "mask"
+	if send, masked := filterChat(sender, chat); !send || !masked || chat.Message != "**** team" {
+		t.Errorf("mask: send %v, masked %v, %q", send, masked, chat.Message)
+	}
+
+	s.erupeConfig.ChatFilter = "block"
+	chat.Message = "ｎｏｏｂ"
+	if send, _ := filterChat(sender, chat); send {
+		t.Error("blocked message sent")
+	}
+	if !bytes.Contains(sentPackets(sender), []byte("filtered word")) {
+		t.Error("the sender wasn't told why the message was blocked")
+	}
+}
+
+func TestWordFilterReloadsList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	if err := ioutil.WriteFile(path, []byte("gank\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer()
+	s.erupeConfig.WordFilterFile = path
+	s.ConfigReloaded()
+	if !s.words().Contains("GANK") {
+		t.Fatal("the list file wasn't loaded")
+	}
+
+	if err := ioutil.WriteFile(path, []byte("grief\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s.ConfigReloaded()
+	if s.words().Contains("gank") || !s.words().Contains("griefer") {
+		t.Error("the reloaded list wasn't picked up")
+	}
+}
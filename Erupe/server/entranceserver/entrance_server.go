@@ -6,9 +6,13 @@ import (
 	"io"
 	"net"
 	"sync"
+	"time"
 
+	"github.com/Solenataris/Erupe/common/tracing"
 	"github.com/Solenataris/Erupe/config"
 	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/server/channelserver"
+	"github.com/Solenataris/Erupe/server/ipban"
 	"github.com/jmoiron/sqlx"
 	"go.uber.org/zap"
 )
@@ -19,8 +23,21 @@ type Server struct {
 	logger         *zap.Logger
 	erupeConfig    *config.Config
 	db             *sqlx.DB
+	ipBans         *ipban.List
+	tracer         *tracing.Tracer
 	listener       net.Listener
 	isShuttingDown bool
+	now            func() time.Time // Server time sent in the world list.
+
+	populationSources map[uint16]PopulationSource
+	worlds            worldStore
+	populationLock    sync.Mutex
+	population        population
+
+	// Channels registered in the DB, see registration.go.
+	channels    channelStore
+	listingLock sync.Mutex
+	listing     worldListing
 }
 
 // Config struct allows configuring the server.
@@ -28,6 +45,8 @@ type Config struct {
 	Logger      *zap.Logger
 	DB          *sqlx.DB
 	ErupeConfig *config.Config
+	IPBans      *ipban.List
+	Tracer      *tracing.Tracer
 }
 
 // NewServer creates a new Server type.
@@ -36,6 +55,13 @@ func NewServer(config *Config) *Server {
 		logger:      config.Logger,
 		erupeConfig: config.ErupeConfig,
 		db:          config.DB,
+		ipBans:      config.IPBans,
+		tracer:      config.Tracer,
+		now:         channelserver.Time_Current_Adjusted,
+
+		populationSources: make(map[uint16]PopulationSource),
+		worlds:            dbWorldStore{config.DB},
+		channels:          dbChannelStore{config.DB},
 	}
 	return s
 }
@@ -84,6 +110,12 @@ func (s *Server) acceptClients() {
 			}
 		}
 
+		if s.ipBans.Banned(conn.RemoteAddr()) {
+			s.logger.Info("Refused connection from banned IP", zap.String("remoteaddr", conn.RemoteAddr().String()))
+			conn.Close()
+			continue
+		}
+
 		// Start a new goroutine for the connection so that we don't block other incoming connections.
 		go s.handleEntranceServerConnection(conn)
 	}
@@ -111,11 +143,17 @@ func (s *Server) handleEntranceServerConnection(conn net.Conn) {
 
 	s.logger.Debug("Got entrance server command:\n", zap.String("raw", hex.Dump(pkt)))
 
-	data := makeSv2Resp(s.erupeConfig.Entrance.Entries, s)
+	// The request carries no sign in token to tie it to the player's trace, so it's traced on its own.
+	span := s.tracer.Start("server list")
+	defer span.End()
+	query := span.Child("world list")
+	worlds := s.worldList()
+	query.End()
+	data := makeSv2Resp(worlds, s)
 	if len(pkt) > 5 {
 		data = append(data, makeUsrResp(pkt)...)
 	}
-	cc.SendPacket(data)
+	span.SetError(cc.SendPacket(data))
 	// Close because we only need to send the response once.
 	// Any further requests from the client will come from a new connection.
 	conn.Close()
@@ -8,7 +8,6 @@ import (
 
 	"github.com/Andoryuuta/byteframe"
 	"github.com/Solenataris/Erupe/config"
-	"github.com/Solenataris/Erupe/server/channelserver"
 )
 
 func paddedString(x string, size uint) []byte {
@@ -20,35 +19,17 @@ func paddedString(x string, size uint) []byte {
 	return out
 }
 
-// Server Entries
-var name string
-var season uint8
-
-// Server Channels
-var currentplayers uint16
-
 func encodeServerInfo(serverInfos []config.EntranceServerInfo, s *Server) []byte {
 	bf := byteframe.NewByteFrame()
+	population := s.currentPopulation(serverInfos)
 
 	for serverIdx, si := range serverInfos {
-		err := s.db.QueryRow("SELECT server_name FROM servers WHERE server_name=$1", si.Name).Scan(&name)
-		if err != nil {
-			_, err := s.db.Exec("INSERT INTO servers (server_name, season, current_players, event_id, event_expiration) VALUES ($1, $2, 0, 0, 0)", si.Name, si.Season)
-			if err != nil {
-				panic(err)
-			}
-		} else {
-			err := s.db.QueryRow("SELECT season FROM servers WHERE server_name=$1", si.Name).Scan(&season)
-			if err != nil {
-				panic(err)
-			}
-		}
 		bf.WriteUint32(binary.LittleEndian.Uint32(net.ParseIP(si.IP).To4()))
 		bf.WriteUint16(16 + uint16(serverIdx))
 		bf.WriteUint16(si.Unk2)
 		bf.WriteUint16(uint16(len(si.Channels)))
 		bf.WriteUint8(si.Type)
-		bf.WriteUint8(season)
+		bf.WriteUint8(population.worldActivity(si, s.erupeConfig.Entrance.ActivityThresholds))
 		bf.WriteUint8(si.Unk6)
 		shiftjisName, err := stringsupport.ConvertUTF8ToShiftJIS(si.Name)
 		if err != nil {
@@ -61,11 +42,7 @@ func encodeServerInfo(serverInfos []config.EntranceServerInfo, s *Server) []byte
 			bf.WriteUint16(ci.Port)
 			bf.WriteUint16(16 + uint16(channelIdx))
 			bf.WriteUint16(ci.MaxPlayers)
-			err := s.db.QueryRow("SELECT current_players FROM servers WHERE server_name=$1", si.Name).Scan(&currentplayers)
-			if err != nil {
-				panic(err)
-			}
-			bf.WriteUint16(currentplayers)
+			bf.WriteUint16(population.channelPlayers(si, ci))
 			bf.WriteUint16(ci.Unk4)
 			bf.WriteUint16(ci.Unk5)
 			bf.WriteUint16(ci.Unk6)
@@ -78,7 +55,7 @@ func encodeServerInfo(serverInfos []config.EntranceServerInfo, s *Server) []byte
 			bf.WriteUint16(ci.Unk13)
 		}
 	}
-	bf.WriteUint32(uint32(channelserver.Time_Current_Adjusted().Unix()))
+	bf.WriteUint32(uint32(s.now().Unix()))
 	bf.WriteUint32(0x0000003C)
 	return bf.Data()
 }
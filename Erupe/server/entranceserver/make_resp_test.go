@@ -0,0 +1,142 @@
+package entranceserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/common/fixtures"
+	"github.com/Solenataris/Erupe/config"
+	"go.uber.org/zap"
+)
+
+type fakeChannel int
+
+func (c fakeChannel) PlayerCount() int { return int(c) }
+
+// fakeWorldStore stands in for the servers table, counting how often it's read.
+type fakeWorldStore struct {
+	rows  map[string]worldRow
+	reads int
+}
+
+func (f *fakeWorldStore) world(name string, season uint8) (worldRow, error) {
+	f.reads++
+	row, ok := f.rows[name]
+	if !ok {
+		row = worldRow{season: season}
+	}
+	return row, nil
+}
+
+type encodedWorld struct {
+	activity uint8
+	players  []uint16
+}
+
+// decodeServerInfo reads back the activity and channel populations of each world.
+func decodeServerInfo(data []byte, worlds int) []encodedWorld {
+	bf := byteframe.NewByteFrameFromBytes(data)
+	decoded := make([]encodedWorld, worlds)
+	for i := range decoded {
+		bf.ReadBytes(8) // IP, world index and Unk2
+		channels := bf.ReadUint16()
+		bf.ReadUint8() // Type
+		decoded[i].activity = bf.ReadUint8()
+		bf.ReadBytes(1 + 66 + 4) // Unk6, name and client flags
+		for c := 0; c < int(channels); c++ {
+			bf.ReadBytes(6) // Port, channel index and max players
+			decoded[i].players = append(decoded[i].players, bf.ReadUint16())
+			bf.ReadBytes(20)
+		}
+	}
+	return decoded
+}
+
+func newPopulationTestServer(thresholds []int) (*Server, *fakeWorldStore, []config.EntranceServerInfo) {
+	entries := []config.EntranceServerInfo{
+		{IP: "127.0.0.1", Name: "Busy", Season: 2, Channels: []config.EntranceChannelInfo{{Port: 54001, MaxPlayers: 100}, {Port: 54002, MaxPlayers: 100}}},
+		{IP: "127.0.0.1", Name: "Quiet", Season: 2, Channels: []config.EntranceChannelInfo{{Port: 54003, MaxPlayers: 50}}},
+		{IP: "127.0.0.1", Name: "Elsewhere", Channels: []config.EntranceChannelInfo{{Port: 54004, MaxPlayers: 100}}},
+	}
+	store := &fakeWorldStore{rows: map[string]worldRow{"Elsewhere": {season: 1, players: 42}}}
+	s := NewServer(&Config{
+		Logger:      zap.NewNop(),
+		ErupeConfig: &config.Config{Entrance: config.Entrance{Entries: entries, ActivityThresholds: thresholds}},
+	})
+	s.worlds = store
+	s.AddPopulationSource(54001, fakeChannel(70))
+	s.AddPopulationSource(54002, fakeChannel(95))
+	s.AddPopulationSource(54003, fakeChannel(3))
+	return s, store, entries
+}
+
+func TestServerInfoPopulation(t *testing.T) {
+	s, _, entries := newPopulationTestServer([]int{50, 80})
+	got := decodeServerInfo(encodeServerInfo(entries, s), len(entries))
+
+	want := []encodedWorld{
+		{activity: 2, players: []uint16{70, 95}}, // 165 of 200
+		{activity: 0, players: []uint16{3}},
+		{activity: 0, players: []uint16{42}}, // Published to the servers table by another process.
+	}
+	for i := range want {
+		if got[i].activity != want[i].activity {
+			t.Errorf("%s: got activity %d, want %d", entries[i].Name, got[i].activity, want[i].activity)
+		}
+		if len(got[i].players) != len(want[i].players) {
+			t.Fatalf("%s: got %d channels, want %d", entries[i].Name, len(got[i].players), len(want[i].players))
+		}
+		for c := range want[i].players {
+			if got[i].players[c] != want[i].players[c] {
+				t.Errorf("%s channel %d: got %d players, want %d", entries[i].Name, c, got[i].players[c], want[i].players[c])
+			}
+		}
+	}
+}
+
+func TestServerInfoActivityWithoutThresholds(t *testing.T) {
+	s, _, entries := newPopulationTestServer(nil)
+	got := decodeServerInfo(encodeServerInfo(entries, s), len(entries))
+	for i, want := range []uint8{2, 2, 1} {
+		if got[i].activity != want {
+			t.Errorf("%s: got activity %d, want the season %d", entries[i].Name, got[i].activity, want)
+		}
+	}
+}
+
+func TestPopulationCached(t *testing.T) {
+	s, store, entries := newPopulationTestServer(nil)
+	s.currentPopulation(entries)
+	s.AddPopulationSource(54001, fakeChannel(1))
+	if got := s.currentPopulation(entries).channels[54001]; got != 70 || store.reads != len(entries) {
+		t.Fatalf("got %d players after %d reads, want the cached 70 after %d", got, store.reads, len(entries))
+	}
+
+	s.population.generatedAt = time.Now().Add(-populationCacheDuration)
+	if got := s.currentPopulation(entries).channels[54001]; got != 1 {
+		t.Errorf("got %d players once the cache expired, want 1", got)
+	}
+}
+
+func TestSv2RespSnapshot(t *testing.T) {
+	tests := []struct {
+		name       string
+		thresholds []int
+	}{
+		{"season_activity", nil},
+		{"population_activity", []int{50, 80}},
+	}
+	for _, tt := range tests {
+		s, _, entries := newPopulationTestServer(tt.thresholds)
+		s.now = func() time.Time { return time.Date(2022, 3, 14, 12, 0, 0, 0, time.UTC) }
+		fixtures.Compare(t, "entranceserver/sv2_"+tt.name, makeSv2Resp(entries, s),
+			"SV2 world list, "+tt.name+", a snapshot written by TestSv2RespSnapshot rather than a client capture")
+	}
+}
+
+func TestUsrRespSnapshot(t *testing.T) {
+	// ALL+, a zero byte and the count of the character IDs that follow.
+	req := []byte{'A', 'L', 'L', '+', 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x64, 0x00, 0x00, 0x00, 0x65}
+	fixtures.Compare(t, "entranceserver/usr", makeUsrResp(req), "USR response for two characters, a snapshot written by TestUsrRespSnapshot rather than a client capture")
+}
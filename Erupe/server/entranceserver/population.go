@@ -0,0 +1,130 @@
+package entranceserver
+
+import (
+	"math"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// How long the world populations are reused before the channels and DB are asked again.
+const populationCacheDuration = 5 * time.Second
+
+// PopulationSource is anything that can report how many players it has connected, such as a channel server.
+type PopulationSource interface {
+	PlayerCount() int
+}
+
+// worldRow is a world's row in the servers table.
+type worldRow struct {
+	season  uint8
+	players uint16
+}
+
+// worldStore reads the servers table, where channels running in other processes publish their player counts.
+type worldStore interface {
+	// world returns the world's row, creating it with the given season if it's missing.
+	world(name string, season uint8) (worldRow, error)
+}
+
+type dbWorldStore struct {
+	db *sqlx.DB
+}
+
+func (d dbWorldStore) world(name string, season uint8) (worldRow, error) {
+	_, err := d.db.Exec(`
+		INSERT INTO servers (server_name, season, current_players, event_id, event_expiration)
+		SELECT $1, $2, 0, 0, 0 WHERE NOT EXISTS (SELECT 1 FROM servers WHERE server_name = $1)
+	`, name, season)
+	if err != nil {
+		return worldRow{}, err
+	}
+	var row worldRow
+	err = d.db.QueryRow("SELECT season, current_players FROM servers WHERE server_name = $1", name).Scan(&row.season, &row.players)
+	return row, err
+}
+
+// population is a snapshot of how many players each world and channel has.
+type population struct {
+	channels    map[uint16]int    // Players on the channels running in this process, by port.
+	registered  map[string]uint16 // Players on the channels registered in the DB, by address and port.
+	worlds      map[string]worldRow
+	generatedAt time.Time
+}
+
+// AddPopulationSource adds a channel running in this process, whose player count is read directly
+// rather than from the servers table.
+func (s *Server) AddPopulationSource(port uint16, source PopulationSource) {
+	s.Lock()
+	defer s.Unlock()
+	s.populationSources[port] = source
+}
+
+// currentPopulation returns the cached population, taking a new snapshot once it's older than populationCacheDuration.
+func (s *Server) currentPopulation(entries []config.EntranceServerInfo) population {
+	s.populationLock.Lock()
+	defer s.populationLock.Unlock()
+	if time.Since(s.population.generatedAt) < populationCacheDuration {
+		return s.population
+	}
+
+	p := population{channels: make(map[uint16]int), registered: s.registeredPlayers(), worlds: make(map[string]worldRow), generatedAt: time.Now()}
+	s.Lock()
+	for port, source := range s.populationSources {
+		p.channels[port] = source.PlayerCount()
+	}
+	s.Unlock()
+	for _, si := range entries {
+		row, err := s.worlds.world(si.Name, si.Season)
+		if err != nil {
+			s.logger.Error("Failed to read world from the servers table", zap.String("name", si.Name), zap.Error(err))
+			row = worldRow{season: si.Season}
+		}
+		p.worlds[si.Name] = row
+	}
+	s.population = p
+	return p
+}
+
+// channelPlayers returns the players on the channel. A registered channel publishes its own count,
+// another channel in another process is only known by the count its world last published to the
+// servers table.
+func (p population) channelPlayers(si config.EntranceServerInfo, ci config.EntranceChannelInfo) uint16 {
+	players, ok := p.channels[ci.Port]
+	if !ok {
+		if registered, ok := p.registered[channelKey(si.IP, ci.Port)]; ok {
+			return registered
+		}
+		return p.worlds[si.Name].players
+	}
+	if players > math.MaxUint16 {
+		return math.MaxUint16
+	}
+	return uint16(players)
+}
+
+// worldActivity returns the byte shown in the world's season slot. With thresholds it's an activity that steps
+// up once for each percentage of the world's capacity its players reach, replacing the season, otherwise it's
+// the season in the servers table.
+func (p population) worldActivity(si config.EntranceServerInfo, thresholds []int) uint8 {
+	if len(thresholds) == 0 {
+		return p.worlds[si.Name].season
+	}
+	var players, capacity int
+	for _, ci := range si.Channels {
+		players += int(p.channelPlayers(si, ci))
+		capacity += int(ci.MaxPlayers)
+	}
+	if capacity == 0 {
+		return 0
+	}
+	var activity uint8
+	for _, threshold := range thresholds {
+		if players*100 >= threshold*capacity {
+			activity++
+		}
+	}
+	return activity
+}
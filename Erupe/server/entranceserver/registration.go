@@ -0,0 +1,114 @@
+package entranceserver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/server/channelserver"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// channelStore reads the channels that registered themselves in the server_channels table.
+type channelStore interface {
+	registeredChannels(ttl time.Duration) ([]channelserver.RegisteredChannel, error)
+}
+
+type dbChannelStore struct {
+	db *sqlx.DB
+}
+
+func (d dbChannelStore) registeredChannels(ttl time.Duration) ([]channelserver.RegisteredChannel, error) {
+	return channelserver.RegisteredChannels(d.db, ttl)
+}
+
+// worldListing is the world list with the registered channels merged in.
+type worldListing struct {
+	entries     []config.EntranceServerInfo
+	players     map[string]uint16 // Players on the registered channels, by address and port.
+	generatedAt time.Time
+}
+
+// channelKey identifies a channel by the address and port clients connect to.
+func channelKey(address string, port uint16) string {
+	return fmt.Sprintf("%s:%d", address, port)
+}
+
+// worldList returns the configured worlds with the registered channels that are alive and not draining
+// merged in, cached for as long as the populations.
+func (s *Server) worldList() []config.EntranceServerInfo {
+	s.listingLock.Lock()
+	defer s.listingLock.Unlock()
+	if time.Since(s.listing.generatedAt) < populationCacheDuration {
+		return s.listing.entries
+	}
+
+	entries := s.erupeConfig.Entrance.Entries
+	players := make(map[string]uint16)
+	ttl := time.Duration(s.erupeConfig.Entrance.RegistrationTTL) * time.Second
+	channels, err := s.channels.registeredChannels(ttl)
+	if err != nil {
+		s.logger.Error("Failed to read the registered channels", zap.Error(err))
+	} else {
+		entries = mergeRegisteredChannels(entries, channels)
+		for _, channel := range channels {
+			players[channelKey(channel.Address, channel.Port)] = channel.Players
+		}
+	}
+	s.listing = worldListing{entries: entries, players: players, generatedAt: time.Now()}
+	return entries
+}
+
+// registeredPlayers returns the players on the registered channels, as of the last world list.
+func (s *Server) registeredPlayers() map[string]uint16 {
+	s.listingLock.Lock()
+	defer s.listingLock.Unlock()
+	return s.listing.players
+}
+
+// mergeRegisteredChannels adds the registered channels to the worlds they name. A channel at another
+// address than its world gets an entry of its own under the world's name, as the client only knows one
+// address per world. Channels already configured and draining ones are left out.
+func mergeRegisteredChannels(entries []config.EntranceServerInfo, channels []channelserver.RegisteredChannel) []config.EntranceServerInfo {
+	merged := make([]config.EntranceServerInfo, len(entries))
+	for i, entry := range entries {
+		merged[i] = entry
+		merged[i].Channels = append([]config.EntranceChannelInfo(nil), entry.Channels...)
+	}
+	for _, channel := range channels {
+		if channel.Draining {
+			continue
+		}
+		index := -1
+		for i, entry := range merged {
+			if entry.Name == channel.World && entry.IP == channel.Address {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			entry := config.EntranceServerInfo{IP: channel.Address, Name: channel.World, Type: 1}
+			for _, template := range entries {
+				if template.Name == channel.World {
+					entry = template
+					entry.IP = channel.Address
+					entry.Channels = nil
+					break
+				}
+			}
+			merged = append(merged, entry)
+			index = len(merged) - 1
+		}
+		listed := false
+		for _, ci := range merged[index].Channels {
+			if ci.Port == channel.Port {
+				listed = true
+			}
+		}
+		if !listed {
+			merged[index].Channels = append(merged[index].Channels, config.EntranceChannelInfo{Port: channel.Port, MaxPlayers: channel.MaxPlayers})
+		}
+	}
+	return merged
+}
@@ -0,0 +1,102 @@
+package entranceserver
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/common/testdb"
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/server/channelserver"
+	"go.uber.org/zap"
+)
+
+type fakeChannelStore struct {
+	channels []channelserver.RegisteredChannel
+}
+
+func (f *fakeChannelStore) registeredChannels(ttl time.Duration) ([]channelserver.RegisteredChannel, error) {
+	return f.channels, nil
+}
+
+func TestMergeRegisteredChannels(t *testing.T) {
+	entries := []config.EntranceServerInfo{
+		{IP: "10.0.0.1", Name: "Newbie", Type: 3, Channels: []config.EntranceChannelInfo{{Port: 54001, MaxPlayers: 100}}},
+	}
+	channels := []channelserver.RegisteredChannel{
+		{World: "Newbie", Address: "10.0.0.1", Port: 54001, MaxPlayers: 100}, // Configured already.
+		{World: "Newbie", Address: "10.0.0.1", Port: 54002, MaxPlayers: 50},
+		{World: "Newbie", Address: "10.0.0.2", Port: 54001, MaxPlayers: 80},
+		{World: "Newbie", Address: "10.0.0.2", Port: 54003, MaxPlayers: 80, Draining: true},
+		{World: "Extra", Address: "10.0.0.3", Port: 54010, MaxPlayers: 20},
+	}
+	got := mergeRegisteredChannels(entries, channels)
+	want := []config.EntranceServerInfo{
+		{IP: "10.0.0.1", Name: "Newbie", Type: 3, Channels: []config.EntranceChannelInfo{{Port: 54001, MaxPlayers: 100}, {Port: 54002, MaxPlayers: 50}}},
+		{IP: "10.0.0.2", Name: "Newbie", Type: 3, Channels: []config.EntranceChannelInfo{{Port: 54001, MaxPlayers: 80}}},
+		{IP: "10.0.0.3", Name: "Extra", Type: 1, Channels: []config.EntranceChannelInfo{{Port: 54010, MaxPlayers: 20}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if len(entries[0].Channels) != 1 {
+		t.Error("merging changed the configured entries")
+	}
+}
+
+func TestRegisteredChannelPopulation(t *testing.T) {
+	entries := []config.EntranceServerInfo{
+		{IP: "127.0.0.1", Name: "Newbie", Channels: []config.EntranceChannelInfo{{Port: 54001, MaxPlayers: 100}}},
+	}
+	s := NewServer(&Config{Logger: zap.NewNop(), ErupeConfig: &config.Config{Entrance: config.Entrance{Entries: entries}}})
+	s.worlds = &fakeWorldStore{rows: map[string]worldRow{"Newbie": {players: 9}}}
+	s.channels = &fakeChannelStore{channels: []channelserver.RegisteredChannel{
+		{World: "Newbie", Address: "127.0.0.1", Port: 54002, MaxPlayers: 100, Players: 33},
+	}}
+	s.AddPopulationSource(54001, fakeChannel(5))
+
+	list := s.worldList()
+	got := decodeServerInfo(encodeServerInfo(list, s), len(list))
+	if len(got) != 1 || !reflect.DeepEqual(got[0].players, []uint16{5, 33}) {
+		t.Errorf("got %+v, want the local channel's 5 players and the registered one's 33", got)
+	}
+}
+
+// TestRegisteredChannelListed runs against the database in ERUPE_TEST_DB, e.g.
+// "host=localhost user=postgres password=admin dbname=erupe_test sslmode=disable".
+// A second channel registers and shows up in the world list, then leaves it as soon as it's asked to drain.
+func TestRegisteredChannelListed(t *testing.T) {
+	db := testdb.Open(t)
+	defer db.Exec("DELETE FROM server_channels WHERE address = '198.51.100.8'")
+
+	erupeConfig := &config.Config{
+		Channel: config.Channel{HeartbeatInterval: 3600},
+		Entrance: config.Entrance{RegistrationTTL: 30, Entries: []config.EntranceServerInfo{
+			{IP: "198.51.100.8", Name: "Autoscaled", Channels: []config.EntranceChannelInfo{{Port: 54201, MaxPlayers: 100}}},
+		}},
+	}
+	channel := channelserver.NewServer(&channelserver.Config{Logger: zap.NewNop(), DB: db, ErupeConfig: erupeConfig, World: "Autoscaled"})
+	if err := channel.Register("198.51.100.8", 54202, 50); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(&Config{Logger: zap.NewNop(), DB: db, ErupeConfig: erupeConfig})
+
+	list := s.worldList()
+	if len(list) != 1 || len(list[0].Channels) != 2 || list[0].Channels[1].Port != 54202 {
+		t.Fatalf("got %+v, want the registered channel listed in its world", list)
+	}
+
+	var id int
+	if err := db.QueryRow("SELECT id FROM server_channels WHERE address = '198.51.100.8' AND port = 54202").Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := channelserver.DrainChannel(db, id); err != nil {
+		t.Fatal(err)
+	}
+	s.listingLock.Lock()
+	s.listing.generatedAt = time.Time{}
+	s.listingLock.Unlock()
+	if list := s.worldList(); len(list[0].Channels) != 1 {
+		t.Errorf("got %+v, want the draining channel left out", list)
+	}
+}
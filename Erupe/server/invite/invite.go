@@ -0,0 +1,73 @@
+// Package invite keeps the invite codes the sign server asks for when it registers an account.
+package invite
+
+import (
+	"crypto/rand"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrInvalid is returned for a code that doesn't exist, has expired or has no uses left.
+var ErrInvalid = errors.New("invalid invite code")
+
+// Letters that can't be mistaken for one another when a code is read out or typed.
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// Generate stores a new code that can be redeemed maxUses times until expiresAt, nil never expires.
+func Generate(db *sqlx.DB, maxUses int, expiresAt *time.Time) (string, error) {
+	if maxUses < 1 {
+		return "", errors.New("an invite code needs at least one use")
+	}
+	code, err := NewCode(10)
+	if err != nil {
+		return "", err
+	}
+	_, err = db.Exec("INSERT INTO invite_codes (code, max_uses, expires_at) VALUES ($1, $2, $3)", code, maxUses, expiresAt)
+	return code, err
+}
+
+// NewCode returns a random code of length letters from the ones that can't be mistaken for one another,
+// for codes players are handed such as campaign codes.
+func NewCode(length int) (string, error) {
+	buf := make([]byte, length)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, b := range buf {
+		buf[i] = codeAlphabet[int(b)%len(codeAlphabet)]
+	}
+	return string(buf), nil
+}
+
+// Redeem uses up one use of the code. The check and the use are a single statement,
+// so a code is never redeemed more often than it allows however many sign ins race for it.
+func Redeem(db sqlx.Execer, code string) error {
+	res, err := db.Exec(`
+		UPDATE invite_codes SET uses = uses + 1
+		WHERE code = $1 AND uses < max_uses AND (expires_at IS NULL OR expires_at > now())
+	`, strings.ToUpper(code))
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows != 1 {
+		return ErrInvalid
+	}
+	return nil
+}
+
+// SplitPassword takes the code off the end of a password typed as password, delimiter, code.
+// The client has no field for the code, so it rides along in the password field.
+func SplitPassword(password string, delimiter string) (string, string) {
+	i := strings.LastIndex(password, delimiter)
+	if delimiter == "" || i < 0 {
+		return password, ""
+	}
+	return password[:i], password[i+len(delimiter):]
+}
@@ -0,0 +1,77 @@
+package invite
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/common/testdb"
+	_ "github.com/lib/pq"
+)
+
+func TestSplitPassword(t *testing.T) {
+	tests := []struct {
+		password, delimiter, wantPassword, wantCode string
+	}{
+		{"hunter2#ABCD", "#", "hunter2", "ABCD"},
+		{"hunter#2#ABCD", "#", "hunter#2", "ABCD"},
+		{"hunter2", "#", "hunter2", ""},
+		{"hunter2::ABCD", "::", "hunter2", "ABCD"},
+		{"hunter2#ABCD", "", "hunter2#ABCD", ""},
+	}
+	for _, tt := range tests {
+		password, code := SplitPassword(tt.password, tt.delimiter)
+		if password != tt.wantPassword || code != tt.wantCode {
+			t.Errorf("SplitPassword(%q, %q) = %q, %q, want %q, %q", tt.password, tt.delimiter, password, code, tt.wantPassword, tt.wantCode)
+		}
+	}
+}
+
+// TestConcurrentRedeem runs against the database in ERUPE_TEST_DB, e.g.
+// "host=localhost user=postgres password=admin dbname=erupe_test sslmode=disable".
+func TestConcurrentRedeem(t *testing.T) {
+	db := testdb.Open(t)
+
+	code, err := Generate(db, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM invite_codes WHERE code = $1", code)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	redeemed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := Redeem(db, code)
+			if err != nil && err != ErrInvalid {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			if err == nil {
+				redeemed++
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if redeemed != 1 {
+		t.Errorf("single use code was redeemed %d times", redeemed)
+	}
+
+	expired := time.Now().Add(-time.Hour)
+	code, err = Generate(db, 5, &expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM invite_codes WHERE code = $1", code)
+	if err := Redeem(db, code); err != ErrInvalid {
+		t.Errorf("expired code got %v, want %v", err, ErrInvalid)
+	}
+	if err := Redeem(db, "NOSUCHCODE"); err != ErrInvalid {
+		t.Errorf("unknown code got %v, want %v", err, ErrInvalid)
+	}
+}
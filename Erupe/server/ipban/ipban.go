@@ -0,0 +1,152 @@
+// Package ipban keeps the IP ban list the entrance and sign servers check new connections against.
+package ipban
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// List is the ip_bans table cached in memory, so connections are checked without a query each.
+type List struct {
+	sync.RWMutex
+	logger   *zap.Logger
+	db       *sqlx.DB
+	prefixes []netip.Prefix
+	stop     chan struct{}
+}
+
+// NewList creates a new List type.
+func NewList(logger *zap.Logger, db *sqlx.DB) *List {
+	return &List{
+		logger: logger,
+		db:     db,
+		stop:   make(chan struct{}),
+	}
+}
+
+// ParsePrefix parses a CIDR range, a lone address is taken as a range of just that address.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked(), nil
+}
+
+// Start loads the list and reloads it every interval until Shutdown, a zero interval loads it once.
+func (l *List) Start(interval time.Duration) error {
+	if err := l.Refresh(); err != nil {
+		return err
+	}
+	if interval <= 0 {
+		return nil
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-l.stop:
+				return
+			case <-ticker.C:
+				if err := l.Refresh(); err != nil {
+					l.logger.Error("Failed to refresh the IP ban list", zap.Error(err))
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Shutdown stops reloading the list.
+func (l *List) Shutdown() {
+	close(l.stop)
+}
+
+// Refresh reloads the list from the ip_bans table.
+func (l *List) Refresh() error {
+	var ranges []string
+	if err := l.db.Select(&ranges, "SELECT range::text FROM ip_bans"); err != nil {
+		return err
+	}
+	prefixes := make([]netip.Prefix, 0, len(ranges))
+	for _, r := range ranges {
+		prefix, err := ParsePrefix(r)
+		if err != nil {
+			l.logger.Warn("Skipping unparsable IP ban", zap.String("range", r), zap.Error(err))
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	l.set(prefixes)
+	return nil
+}
+
+func (l *List) set(prefixes []netip.Prefix) {
+	l.Lock()
+	l.prefixes = prefixes
+	l.Unlock()
+}
+
+// Banned reports whether the address is in a banned range. A nil List bans nothing.
+func (l *List) Banned(addr net.Addr) bool {
+	if l == nil {
+		return false
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip, ok := netip.AddrFromSlice(tcpAddr.IP)
+	if !ok {
+		return false
+	}
+	return l.contains(ip.Unmap())
+}
+
+func (l *List) contains(ip netip.Addr) bool {
+	l.RLock()
+	defer l.RUnlock()
+	for _, prefix := range l.prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add bans the range and reloads the list so it applies straight away.
+func (l *List) Add(prefix netip.Prefix, reason string, issuedBy string) error {
+	_, err := l.db.Exec(`
+		INSERT INTO ip_bans (range, reason, issued_by) VALUES ($1, $2, $3)
+		ON CONFLICT (range) DO UPDATE SET reason = $2, issued_by = $3
+	`, prefix.String(), reason, issuedBy)
+	if err != nil {
+		return err
+	}
+	return l.Refresh()
+}
+
+// Remove lifts the ban on the range, reporting whether it was banned.
+func (l *List) Remove(prefix netip.Prefix) (bool, error) {
+	result, err := l.db.Exec("DELETE FROM ip_bans WHERE range = $1", prefix.String())
+	if err != nil {
+		return false, err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return false, nil
+	}
+	return true, l.Refresh()
+}
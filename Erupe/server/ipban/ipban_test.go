@@ -0,0 +1,80 @@
+package ipban
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func newTestList(t *testing.T, ranges ...string) *List {
+	var prefixes []netip.Prefix
+	for _, r := range ranges {
+		prefix, err := ParsePrefix(r)
+		if err != nil {
+			t.Fatalf("ParsePrefix(%q): %v", r, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	l := &List{}
+	l.set(prefixes)
+	return l
+}
+
+func tcpAddr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 53312}
+}
+
+func TestBanned(t *testing.T) {
+	l := newTestList(t, "203.0.113.7", "198.51.100.0/24", "2001:db8::1", "2001:db8:abcd::/48")
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"203.0.113.7", true},
+		{"203.0.113.8", false},
+		{"198.51.100.0", true},
+		{"198.51.100.255", true},
+		{"198.51.101.0", false},
+		// IPv4 clients accepted on a dual-stack listener arrive mapped into IPv6.
+		{"::ffff:198.51.100.20", true},
+		{"2001:db8::1", true},
+		{"2001:db8::2", false},
+		{"2001:db8:abcd:12::1", true},
+		{"2001:db8:abce::1", false},
+	}
+	for _, tt := range tests {
+		if got := l.Banned(tcpAddr(tt.ip)); got != tt.want {
+			t.Errorf("Banned(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestNilListBansNothing(t *testing.T) {
+	var l *List
+	if l.Banned(tcpAddr("203.0.113.7")) {
+		t.Error("nil list banned an address")
+	}
+}
+
+func TestParsePrefix(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"203.0.113.7", "203.0.113.7/32"},
+		{"198.51.100.77/24", "198.51.100.0/24"},
+		{"2001:db8::1", "2001:db8::1/128"},
+		{"2001:db8:abcd:1::/48", "2001:db8:abcd::/48"},
+		{"::ffff:198.51.100.0/120", "198.51.100.0/24"},
+	}
+	for _, tt := range tests {
+		got, err := ParsePrefix(tt.in)
+		if err != nil || got.String() != tt.want {
+			t.Errorf("ParsePrefix(%q) = %v, %v, want %s", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := ParsePrefix("not an address"); err == nil {
+		t.Error("ParsePrefix accepted an invalid range")
+	}
+}
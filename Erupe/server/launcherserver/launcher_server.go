@@ -3,6 +3,7 @@ package launcherserver
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"sync"
@@ -32,6 +33,15 @@ type Server struct {
 	httpServer               *http.Server
 	useOriginalLauncherFiles bool
 	isShuttingDown           bool
+	startedAt                time.Time
+
+	// Public stats page.
+	populationSources []PopulationSource
+	statsLock         sync.Mutex
+	stats             publicStats
+
+	// Patch files, nil unless Launcher.PatchServer is set.
+	patch *patchServer
 }
 
 // NewServer creates a new Server type.
@@ -42,27 +52,18 @@ func NewServer(config *Config) *Server {
 		db:                       config.DB,
 		useOriginalLauncherFiles: config.UseOriginalLauncherFiles,
 		httpServer:               &http.Server{},
+		startedAt:                time.Now(),
+	}
+	if config.ErupeConfig.Launcher.PatchServer {
+		s.patch = newPatchServer(config.Logger, config.ErupeConfig.Launcher.PatchDir)
 	}
 	return s
 }
 
 // Start starts the server in a new goroutine.
 func (s *Server) Start() error {
-	// Set up the routes responsible for serving the launcher HTML, serverlist, unique name check, and JP auth.
-	r := mux.NewRouter()
-
-	// Universal serverlist.xml route
-	s.setupServerlistRoutes(r)
-
-	// Change the launcher HTML routes if we are using the custom launcher instead of the original.
-	if s.useOriginalLauncherFiles {
-		s.setupOriginalLauncherRotues(r)
-	} else {
-		s.setupCustomLauncherRotues(r)
-	}
-
 	s.httpServer.Addr = fmt.Sprintf(":%d", s.erupeConfig.Launcher.Port)
-	s.httpServer.Handler = handlers.LoggingHandler(os.Stdout, r)
+	s.httpServer.Handler = handlers.LoggingHandler(os.Stdout, s.routes())
 
 	serveError := make(chan error, 1)
 	go func() {
@@ -81,6 +82,37 @@ func (s *Server) Start() error {
 	}
 }
 
+// routes sets up the routes responsible for serving the launcher HTML, serverlist, unique name check, and JP auth.
+func (s *Server) routes() *mux.Router {
+	r := mux.NewRouter()
+
+	// Universal serverlist.xml route
+	s.setupServerlistRoutes(r)
+
+	if s.erupeConfig.Launcher.PublicStats {
+		s.setupStatsRoutes(r)
+	}
+
+	if s.patch != nil {
+		s.setupPatchRoutes(r)
+	}
+
+	// Change the launcher HTML routes if we are using the custom launcher instead of the original.
+	if s.useOriginalLauncherFiles {
+		s.setupOriginalLauncherRotues(r)
+	} else {
+		s.setupCustomLauncherRotues(r)
+	}
+	return r
+}
+
+// WriteMetrics writes the patch download counters, so the launcher can be a metrics source.
+func (s *Server) WriteMetrics(w io.Writer) {
+	if s.patch != nil {
+		s.patch.WriteMetrics(w)
+	}
+}
+
 // Shutdown exits the server gracefully.
 func (s *Server) Shutdown() {
 	s.logger.Debug("Shutting down")
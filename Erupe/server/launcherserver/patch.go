@@ -0,0 +1,260 @@
+package launcherserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// The client learns its patch servers from the sign response, whose patch server list Erupe leaves empty
+// (the "file/patch server count" in signserver/dsgn_resp.go), and no capture of the client's own patch check
+// is on hand. The endpoints below are therefore Erupe's own format, not the client's: /patch/version is the
+// manifest's version as plain text and /patch/manifest.json the patchManifest below, for launchers and
+// updaters that read them, with the files under /patch/files/ as a static file server would serve them.
+
+// How long a patch manifest is served before the patch directory is walked again.
+const patchManifestCacheDuration = 30 * time.Second
+
+// patchFile is a file the client can download from the patch server.
+type patchFile struct {
+	Path string `json:"path"` // Slash separated, relative to the patch directory.
+	Size int64  `json:"size"`
+	Hash string `json:"sha256"`
+}
+
+// patchManifest lists every file in the patch directory. Version changes whenever any file does.
+type patchManifest struct {
+	Version     string      `json:"version"`
+	Files       []patchFile `json:"files"`
+	GeneratedAt time.Time   `json:"generatedAt"`
+}
+
+// cachedHash is a file's hash, valid while its size and modification time are unchanged.
+type cachedHash struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+// patchDownloads counts the requests for and bytes sent of one file.
+type patchDownloads struct {
+	requests uint64
+	bytes    uint64
+}
+
+// patchServer serves the patch directory with a manifest of its files.
+type patchServer struct {
+	sync.Mutex  // Guards manifest and downloads.
+	rebuildLock sync.Mutex
+	logger      *zap.Logger
+	dir         string
+	hashes      map[string]cachedHash
+	manifest    patchManifest
+	downloads   map[string]*patchDownloads
+}
+
+func newPatchServer(logger *zap.Logger, dir string) *patchServer {
+	return &patchServer{
+		logger:    logger,
+		dir:       dir,
+		hashes:    make(map[string]cachedHash),
+		downloads: make(map[string]*patchDownloads),
+	}
+}
+
+func (s *Server) setupPatchRoutes(r *mux.Router) {
+	r.HandleFunc("/patch/version", s.patch.serveVersion)
+	r.HandleFunc("/patch/manifest.json", s.patch.serveManifest)
+	r.PathPrefix("/patch/files/").Handler(http.StripPrefix("/patch/files/", http.HandlerFunc(s.patch.serveFile)))
+}
+
+// currentManifest returns the cached manifest, rebuilding it once it's older than patchManifestCacheDuration.
+// Rebuilds take turns on rebuildLock, so the files are hashed without holding the lock the downloads and the
+// metrics take, and only files that changed since they were last hashed are read again.
+func (p *patchServer) currentManifest() (patchManifest, error) {
+	if manifest, fresh := p.cachedManifest(); fresh {
+		return manifest, nil
+	}
+	p.rebuildLock.Lock()
+	defer p.rebuildLock.Unlock()
+	// Another request may have rebuilt it while this one waited its turn.
+	if manifest, fresh := p.cachedManifest(); fresh {
+		return manifest, nil
+	}
+	manifest, err := p.buildManifest()
+	if err != nil {
+		return patchManifest{}, err
+	}
+	p.Lock()
+	p.manifest = manifest
+	p.Unlock()
+	return manifest, nil
+}
+
+func (p *patchServer) cachedManifest() (patchManifest, bool) {
+	p.Lock()
+	defer p.Unlock()
+	return p.manifest, time.Since(p.manifest.GeneratedAt) < patchManifestCacheDuration
+}
+
+// buildManifest walks the patch directory. The caller holds rebuildLock, which guards hashes.
+func (p *patchServer) buildManifest() (patchManifest, error) {
+	manifest := patchManifest{Files: []patchFile{}, GeneratedAt: time.Now()}
+	seen := make(map[string]bool)
+	err := filepath.WalkDir(p.dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(p.dir, name)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		seen[rel] = true
+
+		cached, ok := p.hashes[rel]
+		if !ok || cached.size != info.Size() || !cached.modTime.Equal(info.ModTime()) {
+			hash, err := hashFile(name)
+			if err != nil {
+				return err
+			}
+			cached = cachedHash{info.Size(), info.ModTime(), hash}
+			p.hashes[rel] = cached
+		}
+		manifest.Files = append(manifest.Files, patchFile{rel, cached.size, cached.hash})
+		return nil
+	})
+	if err != nil {
+		return patchManifest{}, err
+	}
+	for rel := range p.hashes {
+		if !seen[rel] {
+			delete(p.hashes, rel)
+		}
+	}
+
+	sort.Slice(manifest.Files, func(i, j int) bool { return manifest.Files[i].Path < manifest.Files[j].Path })
+	version := sha256.New()
+	for _, file := range manifest.Files {
+		fmt.Fprintf(version, "%s %s\n", file.Path, file.Hash)
+	}
+	manifest.Version = hex.EncodeToString(version.Sum(nil))[:16]
+	return manifest, nil
+}
+
+func hashFile(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (p *patchServer) serveVersion(w http.ResponseWriter, r *http.Request) {
+	manifest, err := p.currentManifest()
+	if err != nil {
+		p.logger.Error("Failed to build patch manifest", zap.Error(err))
+		http.Error(w, "patch manifest unavailable", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, manifest.Version)
+}
+
+func (p *patchServer) serveManifest(w http.ResponseWriter, r *http.Request) {
+	manifest, err := p.currentManifest()
+	if err != nil {
+		p.logger.Error("Failed to build patch manifest", zap.Error(err))
+		http.Error(w, "patch manifest unavailable", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(manifest); err != nil {
+		p.logger.Warn("Failed to write patch manifest", zap.Error(err))
+	}
+}
+
+// serveFile serves a file from the patch directory. http.ServeContent answers range requests,
+// so an interrupted download of a large file can carry on where it stopped.
+func (p *patchServer) serveFile(w http.ResponseWriter, r *http.Request) {
+	rel := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	f, err := os.Open(filepath.Join(p.dir, filepath.FromSlash(rel)))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	counter := &countingWriter{ResponseWriter: w}
+	http.ServeContent(counter, r, info.Name(), info.ModTime(), f)
+	p.recordDownload(rel, counter.written)
+}
+
+func (p *patchServer) recordDownload(rel string, written uint64) {
+	p.Lock()
+	defer p.Unlock()
+	downloads, ok := p.downloads[rel]
+	if !ok {
+		downloads = &patchDownloads{}
+		p.downloads[rel] = downloads
+	}
+	downloads.requests++
+	downloads.bytes += written
+}
+
+// WriteMetrics writes the per-file download counters in the Prometheus text exposition format.
+func (p *patchServer) WriteMetrics(w io.Writer) {
+	p.Lock()
+	defer p.Unlock()
+	files := make([]string, 0, len(p.downloads))
+	for rel := range p.downloads {
+		files = append(files, rel)
+	}
+	sort.Strings(files)
+	for _, rel := range files {
+		file := strconv.Quote(rel)
+		fmt.Fprintf(w, "erupe_patch_downloads_total{file=%s} %d\n", file, p.downloads[rel].requests)
+		fmt.Fprintf(w, "erupe_patch_sent_bytes_total{file=%s} %d\n", file, p.downloads[rel].bytes)
+	}
+}
+
+// countingWriter counts the body bytes written through it.
+type countingWriter struct {
+	http.ResponseWriter
+	written uint64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(b)
+	c.written += uint64(n)
+	return n, err
+}
@@ -0,0 +1,136 @@
+package launcherserver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+func newTestPatchServer(t *testing.T) (*patchServer, http.Handler) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "dat"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "dat", "mhfdat.bin"), []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{patch: newPatchServer(zap.NewNop(), dir)}
+	r := mux.NewRouter()
+	s.setupPatchRoutes(r)
+	return s.patch, r
+}
+
+func get(h http.Handler, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestPatchManifest(t *testing.T) {
+	p, h := newTestPatchServer(t)
+
+	w := get(h, "/patch/manifest.json", nil)
+	var manifest patchManifest
+	if err := json.NewDecoder(w.Body).Decode(&manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Files) != 1 || manifest.Files[0].Path != "dat/mhfdat.bin" || manifest.Files[0].Size != 10 {
+		t.Fatalf("got files %+v", manifest.Files)
+	}
+	// sha256 of "0123456789".
+	if want := "84d89877f0d4041efb6bf91a16f0248f2fd573e6af05c19f96bedb9f882f7882"; manifest.Files[0].Hash != want {
+		t.Errorf("got hash %s, want %s", manifest.Files[0].Hash, want)
+	}
+	if version := get(h, "/patch/version", nil).Body.String(); version != manifest.Version {
+		t.Errorf("got version %q, manifest has %q", version, manifest.Version)
+	}
+
+	// A changed file gets a new hash and version once the cached manifest is stale.
+	name := filepath.Join(p.dir, "dat", "mhfdat.bin")
+	ioutil.WriteFile(name, []byte("9876543210"), 0644)
+	os.Chtimes(name, time.Now(), time.Now().Add(time.Minute))
+	if cached, _ := p.currentManifest(); cached.Version != manifest.Version {
+		t.Error("manifest was rebuilt before the cache expired")
+	}
+	p.manifest.GeneratedAt = time.Time{}
+	rebuilt, err := p.currentManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rebuilt.Version == manifest.Version || rebuilt.Files[0].Hash == manifest.Files[0].Hash {
+		t.Error("changed file kept its old hash")
+	}
+}
+
+func TestPatchRebuildDoesNotBlockDownloads(t *testing.T) {
+	p, h := newTestPatchServer(t)
+
+	// While a rebuild is hashing, files are still served and counted.
+	p.rebuildLock.Lock()
+	defer p.rebuildLock.Unlock()
+	done := make(chan string)
+	go func() {
+		get(h, "/patch/files/dat/mhfdat.bin", nil)
+		var metrics strings.Builder
+		p.WriteMetrics(&metrics)
+		done <- metrics.String()
+	}()
+	select {
+	case metrics := <-done:
+		if !strings.Contains(metrics, `erupe_patch_downloads_total{file="dat/mhfdat.bin"} 1`) {
+			t.Errorf("got metrics %q", metrics)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("a download waited for the manifest rebuild")
+	}
+}
+
+func TestPatchFileRanges(t *testing.T) {
+	p, h := newTestPatchServer(t)
+
+	if w := get(h, "/patch/files/dat/mhfdat.bin", nil); w.Code != http.StatusOK || w.Body.String() != "0123456789" {
+		t.Fatalf("got status %d, body %q", w.Code, w.Body.String())
+	}
+	w := get(h, "/patch/files/dat/mhfdat.bin", http.Header{"Range": {"bytes=4-"}})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "456789" {
+		t.Errorf("range request got status %d, body %q", w.Code, w.Body.String())
+	}
+	for _, path := range []string{"/patch/files/missing.bin", "/patch/files/dat"} {
+		if w := get(h, path, nil); w.Code != http.StatusNotFound {
+			t.Errorf("%s got status %d, want %d", path, w.Code, http.StatusNotFound)
+		}
+	}
+	// The router cleans paths itself, so ask the file handler directly for one outside the directory.
+	ioutil.WriteFile(filepath.Join(filepath.Dir(p.dir), "secret.txt"), []byte("secret"), 0644)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.URL.Path = "../secret.txt"
+	w = httptest.NewRecorder()
+	p.serveFile(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("path outside the patch directory got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	var metrics strings.Builder
+	p.WriteMetrics(&metrics)
+	for _, want := range []string{
+		`erupe_patch_downloads_total{file="dat/mhfdat.bin"} 2`,
+		`erupe_patch_sent_bytes_total{file="dat/mhfdat.bin"} 16`,
+	} {
+		if !strings.Contains(metrics.String(), want) {
+			t.Errorf("metrics %q don't contain %q", metrics.String(), want)
+		}
+	}
+}
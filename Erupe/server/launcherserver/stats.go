@@ -0,0 +1,152 @@
+package launcherserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/Solenataris/Erupe/server/channelserver"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// How long a stats snapshot is served before the DB is queried again.
+const statsCacheDuration = 30 * time.Second
+
+// PopulationSource is anything that can report how many players it has connected, such as a channel server.
+type PopulationSource interface {
+	PlayerCount() int
+}
+
+// publicStats is the public status snapshot. It must never contain account or character details.
+type publicStats struct {
+	Online        int           `json:"online"`
+	UptimeSeconds int64         `json:"uptimeSeconds"`
+	Events        []publicEvent `json:"events"`
+	SeasonTrack   []publicTier  `json:"seasonTrack,omitempty"`
+	GeneratedAt   time.Time     `json:"generatedAt"`
+}
+
+type publicEvent struct {
+	Name string    `json:"name"`
+	ID   int       `json:"id,omitempty"`
+	Ends time.Time `json:"ends"`
+}
+
+// publicTier is a step on the season pass reward track, for players whose client can't show it.
+type publicTier struct {
+	Points       int `json:"points"`
+	FreeItems    int `json:"freeItems"`
+	PremiumItems int `json:"premiumItems"`
+}
+
+// AddPopulationSource adds a server whose players count towards the public online count.
+func (s *Server) AddPopulationSource(source PopulationSource) {
+	s.Lock()
+	defer s.Unlock()
+	s.populationSources = append(s.populationSources, source)
+}
+
+func (s *Server) setupStatsRoutes(r *mux.Router) {
+	r.Handle("/stats.json", ServerHandlerFunc{s, serveStatsJSON})
+	r.Handle("/stats", ServerHandlerFunc{s, serveStatsPage})
+}
+
+// currentStats returns the cached stats snapshot, rebuilding it once it's older than statsCacheDuration.
+func (s *Server) currentStats() publicStats {
+	s.statsLock.Lock()
+	defer s.statsLock.Unlock()
+	if time.Since(s.stats.GeneratedAt) < statsCacheDuration {
+		return s.stats
+	}
+
+	stats := publicStats{
+		UptimeSeconds: int64(time.Since(s.startedAt).Seconds()),
+		Events:        []publicEvent{},
+		GeneratedAt:   time.Now(),
+	}
+
+	s.Lock()
+	for _, source := range s.populationSources {
+		stats.Online += source.PlayerCount()
+	}
+	s.Unlock()
+
+	var eventID int
+	var expiration int64
+	err := s.db.QueryRow("SELECT event_id, date_expiration FROM event_week WHERE id=1").Scan(&eventID, &expiration)
+	if err == nil {
+		stats.Events = append(stats.Events, publicEvent{Name: "Weekly event", ID: eventID, Ends: time.Unix(expiration, 0).UTC()})
+	} else {
+		s.logger.Debug("No weekly event for public stats", zap.Error(err))
+	}
+
+	if festa := s.erupeConfig.Festa; festa.Enabled {
+		end, err := time.Parse(time.RFC3339, festa.End)
+		if err == nil && time.Now().Before(end) {
+			stats.Events = append(stats.Events, publicEvent{Name: "Hunter Festival", Ends: end})
+		}
+	}
+
+	season, ends, err := channelserver.SeasonAt(s.erupeConfig.SeasonPass, time.Now())
+	if err == nil && season > 0 {
+		stats.Events = append(stats.Events, publicEvent{Name: "Season pass", ID: season, Ends: ends})
+		for _, tier := range s.erupeConfig.SeasonPass.Tiers {
+			stats.SeasonTrack = append(stats.SeasonTrack, publicTier{tier.Points, len(tier.Free), len(tier.Premium)})
+		}
+	}
+
+	s.stats = stats
+	return stats
+}
+
+func setStatsCacheHeaders(w http.ResponseWriter, stats publicStats) {
+	age := time.Since(stats.GeneratedAt)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int((statsCacheDuration-age).Seconds())))
+	w.Header().Set("Last-Modified", stats.GeneratedAt.UTC().Format(http.TimeFormat))
+}
+
+func serveStatsJSON(s *Server, w http.ResponseWriter, r *http.Request) {
+	stats := s.currentStats()
+	setStatsCacheHeaders(w, stats)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		s.logger.Warn("Failed to write public stats", zap.Error(err))
+	}
+}
+
+var statsPageTemplate = template.Must(template.New("stats").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Server status</title></head>
+<body>
+<h1>Server status</h1>
+<p>Players online: {{.Online}}</p>
+<p>Uptime: {{.Uptime}}</p>
+<h2>Current events</h2>
+<ul>
+{{range .Events}}<li>{{.Name}}{{if .ID}} #{{.ID}}{{end}}, ends {{.Ends.Format "2006-01-02 15:04 MST"}}</li>
+{{else}}<li>None</li>
+{{end}}</ul>
+{{if .SeasonTrack}}<h2>Season pass rewards</h2>
+<ul>
+{{range .SeasonTrack}}<li>{{.Points}} points: {{.FreeItems}} free item(s){{if .PremiumItems}}, {{.PremiumItems}} premium item(s){{end}}</li>
+{{end}}</ul>
+{{end}}</body>
+</html>
+`))
+
+func serveStatsPage(s *Server, w http.ResponseWriter, r *http.Request) {
+	stats := s.currentStats()
+	setStatsCacheHeaders(w, stats)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := statsPageTemplate.Execute(w, struct {
+		publicStats
+		Uptime time.Duration
+	}{stats, time.Duration(stats.UptimeSeconds) * time.Second})
+	if err != nil {
+		s.logger.Warn("Failed to write public stats page", zap.Error(err))
+	}
+}
@@ -0,0 +1,86 @@
+package launcherserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/common/testdb"
+	"github.com/Solenataris/Erupe/config"
+	"go.uber.org/zap"
+)
+
+type fakePopulation int
+
+func (f fakePopulation) PlayerCount() int {
+	return int(f)
+}
+
+func newTestStatsServer(publicStats bool) *Server {
+	return NewServer(&Config{
+		Logger:      zap.NewNop(),
+		ErupeConfig: &config.Config{Launcher: config.Launcher{PublicStats: publicStats}},
+	})
+}
+
+func TestStatsRoutesNeedPublicStats(t *testing.T) {
+	for _, path := range []string{"/stats", "/stats.json"} {
+		if w := get(newTestStatsServer(false).routes(), path, nil); w.Code != http.StatusNotFound {
+			t.Errorf("%s got status %d with the public stats off", path, w.Code)
+		}
+	}
+}
+
+func TestServeStatsFromCache(t *testing.T) {
+	s := newTestStatsServer(true)
+	// A fresh snapshot is served as is, without the database the test server doesn't have.
+	s.stats = publicStats{Online: 3, UptimeSeconds: 90, Events: []publicEvent{{Name: "Hunter Festival", Ends: time.Now().Add(time.Hour)}}, GeneratedAt: time.Now()}
+	s.AddPopulationSource(fakePopulation(50))
+	h := s.routes()
+
+	w := get(h, "/stats.json", nil)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Cache-Control"), "public, max-age=") || w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("got status %d and headers %v", w.Code, w.Header())
+	}
+	var fields map[string]json.RawMessage
+	if err := json.NewDecoder(w.Body).Decode(&fields); err != nil {
+		t.Fatal(err)
+	}
+	if string(fields["online"]) != "3" {
+		t.Errorf("the cached snapshot wasn't served, online is %s", fields["online"])
+	}
+	for field := range fields {
+		switch field {
+		case "online", "uptimeSeconds", "events", "generatedAt":
+		default:
+			t.Errorf("the public stats have the field %q", field)
+		}
+	}
+
+	page := get(h, "/stats", nil).Body.String()
+	for _, want := range []string{"Players online: 3", "Uptime: 1m30s", "Hunter Festival"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("the stats page is missing %q:\n%s", want, page)
+		}
+	}
+}
+
+// TestCurrentStatsCountsPlayers runs against the database in ERUPE_TEST_DB.
+func TestCurrentStatsCountsPlayers(t *testing.T) {
+	s := newTestStatsServer(true)
+	s.db = testdb.Open(t)
+	s.AddPopulationSource(fakePopulation(2))
+	s.AddPopulationSource(fakePopulation(5))
+
+	stats := s.currentStats()
+	if stats.Online != 7 || time.Since(stats.GeneratedAt) > time.Minute {
+		t.Fatalf("got stats %+v", stats)
+	}
+	// Players connecting meanwhile show once the snapshot expires.
+	s.AddPopulationSource(fakePopulation(1))
+	if stats := s.currentStats(); stats.Online != 7 {
+		t.Errorf("the cached snapshot changed to %d online", stats.Online)
+	}
+}
@@ -0,0 +1,305 @@
+// Package lockdownserver runs maintenance mode: sign in is closed to new logins, players are counted
+// down in chat and, if asked, disconnected with their progress saved once the countdown ends.
+//
+// The state is kept in the lockdown table, so a sign server sharing the DB sees it at once and a
+// process started again during maintenance stays in it.
+package lockdownserver
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// How often the countdown checks for warnings that are due.
+const checkInterval = time.Second
+
+// How long disconnecting everyone waits for their saves to finish.
+const kickTimeout = time.Minute
+
+// ErrNotActive is ending maintenance mode when it's off.
+var ErrNotActive = errors.New("maintenance mode isn't active")
+
+// Channel is anything players can be warned through and disconnected from, such as a channel server.
+type Channel interface {
+	BroadcastChatMessage(message string)
+	DisconnectAll(ctx context.Context) int
+}
+
+// Config struct allows configuring the server.
+type Config struct {
+	Logger      *zap.Logger
+	DB          *sqlx.DB
+	ErupeConfig *config.Config
+	Channels    []Channel
+}
+
+// Status is the maintenance mode state as listed by the admin API and kept in the DB.
+type Status struct {
+	Active    bool      `json:"active"`
+	EndsAt    time.Time `json:"endsAt,omitempty"` // End of the countdown, zero for none.
+	Kick      bool      `json:"kick"`             // Players are disconnected once the countdown ends.
+	Kicked    bool      `json:"kicked"`
+	Reason    string    `json:"reason,omitempty"`
+	StartedBy string    `json:"startedBy,omitempty"`
+}
+
+// store keeps the state across restarts.
+type store interface {
+	Load() (Status, error)
+	Save(status Status) error
+}
+
+// dbStore keeps the state in the single row of the lockdown table.
+type dbStore struct {
+	db *sqlx.DB
+}
+
+func (d dbStore) Load() (Status, error) {
+	var status Status
+	var endsAt sql.NullTime
+	err := d.db.QueryRow(`SELECT active, ends_at, kick, kicked, reason, started_by FROM lockdown WHERE id = 1`).
+		Scan(&status.Active, &endsAt, &status.Kick, &status.Kicked, &status.Reason, &status.StartedBy)
+	if err == sql.ErrNoRows {
+		return Status{}, nil
+	}
+	status.EndsAt = endsAt.Time
+	return status, err
+}
+
+func (d dbStore) Save(status Status) error {
+	endsAt := sql.NullTime{Time: status.EndsAt, Valid: !status.EndsAt.IsZero()}
+	_, err := d.db.Exec(`
+		INSERT INTO lockdown (id, active, ends_at, kick, kicked, reason, started_by) VALUES (1, $1, $2, $3, $4, $5, $6)
+		ON CONFLICT (id) DO UPDATE SET active = $1, ends_at = $2, kick = $3, kicked = $4, reason = $5, started_by = $6
+	`, status.Active, endsAt, status.Kick, status.Kicked, status.Reason, status.StartedBy)
+	return err
+}
+
+// Active reports whether maintenance mode is on, read from the DB so it holds for every process sharing it.
+func Active(db *sqlx.DB) (bool, error) {
+	status, err := dbStore{db}.Load()
+	return status.Active, err
+}
+
+// Server counts players down to the end of maintenance mode's countdown and disconnects them if asked.
+type Server struct {
+	sync.Mutex
+	logger      *zap.Logger
+	erupeConfig *config.Config
+	store       store
+	channels    []Channel
+	now         func() time.Time
+	status      Status
+	warned      map[int]bool // Countdown warnings given, by seconds before the end.
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+// NewServer creates a new Server type.
+func NewServer(config *Config) *Server {
+	s := &Server{
+		logger:      config.Logger,
+		erupeConfig: config.ErupeConfig,
+		store:       dbStore{config.DB},
+		channels:    config.Channels,
+		now:         time.Now,
+		warned:      make(map[int]bool),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	return s
+}
+
+// Start picks up maintenance left on by a previous run and starts the countdown in a new goroutine.
+func (s *Server) Start() error {
+	status, err := s.store.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load maintenance mode: %w", err)
+	}
+	s.Lock()
+	s.status = status
+	s.Unlock()
+	if status.Active {
+		s.logger.Info("Resuming maintenance mode", zap.Time("endsAt", status.EndsAt), zap.String("reason", status.Reason))
+	}
+	go s.run()
+	return nil
+}
+
+// Shutdown stops the countdown. Maintenance mode stays on in the DB.
+func (s *Server) Shutdown() {
+	s.logger.Debug("Shutting down")
+	close(s.stop)
+	<-s.done
+}
+
+func (s *Server) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.check()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Begin turns maintenance mode on, closing sign in at once, with a countdown of the given length after which
+// players are disconnected if kick is set. A zero countdown ends at once. Beginning again replaces the countdown.
+func (s *Server) Begin(countdown time.Duration, kick bool, reason string, startedBy string) (Status, error) {
+	s.Lock()
+	status := Status{Active: true, Kick: kick, Reason: reason, StartedBy: startedBy}
+	if countdown > 0 {
+		status.EndsAt = s.now().Add(countdown).Truncate(time.Second)
+	}
+	if err := s.store.Save(status); err != nil {
+		s.Unlock()
+		return Status{}, err
+	}
+	s.status = status
+	s.warned = make(map[int]bool)
+	s.Unlock()
+
+	s.logger.Info("Began maintenance mode", zap.Time("endsAt", status.EndsAt), zap.Bool("kick", kick), zap.String("reason", reason), zap.String("startedBy", startedBy))
+	s.check()
+	return s.MaintenanceStatus(), nil
+}
+
+// End turns maintenance mode off and opens sign in again, telling players if they were counted down.
+func (s *Server) End() error {
+	s.Lock()
+	if !s.status.Active {
+		s.Unlock()
+		return ErrNotActive
+	}
+	if err := s.store.Save(Status{}); err != nil {
+		s.Unlock()
+		return err
+	}
+	counting := len(s.warned) > 0 && !s.status.Kicked
+	s.status = Status{}
+	s.warned = make(map[int]bool)
+	s.Unlock()
+
+	s.logger.Info("Ended maintenance mode")
+	if counting {
+		s.broadcast("Maintenance was called off.")
+	}
+	return nil
+}
+
+// MaintenanceStatus returns the state of maintenance mode.
+func (s *Server) MaintenanceStatus() Status {
+	s.Lock()
+	defer s.Unlock()
+	return s.status
+}
+
+// WriteMetrics writes whether maintenance mode is on, so the countdown can be a metrics source.
+func (s *Server) WriteMetrics(w io.Writer) {
+	active := 0
+	if s.MaintenanceStatus().Active {
+		active = 1
+	}
+	fmt.Fprintf(w, "erupe_maintenance_active %d\n", active)
+}
+
+// countdownText is how long is left for a warning, in the unit it's given in.
+func countdownText(left time.Duration) string {
+	if left > time.Minute {
+		minutes := int((left + time.Minute - 1) / time.Minute)
+		return fmt.Sprintf("%d minutes", minutes)
+	}
+	seconds := int((left + time.Second - 1) / time.Second)
+	if seconds >= 60 {
+		return "1 minute"
+	}
+	if seconds == 1 {
+		return "1 second"
+	}
+	return fmt.Sprintf("%d seconds", seconds)
+}
+
+// check gives the countdown warnings that are due and disconnects everyone once the countdown ends.
+// Warnings due at once, such as for a short countdown or one resumed after a restart, make a single
+// one with the time actually left.
+func (s *Server) check() {
+	s.Lock()
+	status := s.status
+	if !status.Active || status.Kicked {
+		s.Unlock()
+		return
+	}
+	left := status.EndsAt.Sub(s.now())
+	if left <= 0 {
+		if !status.Kick {
+			s.Unlock()
+			return
+		}
+		// Saved before disconnecting, so a restart halfway through doesn't do it again.
+		s.status.Kicked = true
+		if err := s.store.Save(s.status); err != nil {
+			s.logger.Error("Failed to save maintenance mode", zap.Error(err))
+		}
+		s.Unlock()
+		s.kickAll()
+		return
+	}
+
+	warn := false
+	countdown := append([]int(nil), s.erupeConfig.Current().Lockdown.Countdown...)
+	sort.Sort(sort.Reverse(sort.IntSlice(countdown)))
+	for _, seconds := range countdown {
+		if seconds > 0 && !s.warned[seconds] && left <= time.Duration(seconds)*time.Second {
+			s.warned[seconds] = true
+			warn = true
+		}
+	}
+	s.Unlock()
+
+	if !warn {
+		return
+	}
+	if status.Kick {
+		s.broadcast(fmt.Sprintf("The server goes down for maintenance in %s, your progress will be saved.", countdownText(left)))
+	} else {
+		s.broadcast(fmt.Sprintf("Maintenance begins in %s.", countdownText(left)))
+	}
+}
+
+// kickAll disconnects every player, saving their progress first.
+func (s *Server) kickAll() {
+	s.broadcast("The server is going down for maintenance.")
+	ctx, cancel := context.WithTimeout(context.Background(), kickTimeout)
+	defer cancel()
+	kicked := 0
+	for _, channel := range s.channels {
+		kicked += channel.DisconnectAll(ctx)
+	}
+	s.logger.Info("Disconnected players for maintenance", zap.Int("count", kicked))
+}
+
+func (s *Server) broadcast(message string) {
+	s.Lock()
+	reason := s.status.Reason
+	s.Unlock()
+	if reason != "" {
+		message += " " + reason
+	}
+	for _, channel := range s.channels {
+		channel.BroadcastChatMessage(message)
+	}
+}
@@ -0,0 +1,177 @@
+package lockdownserver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+	"go.uber.org/zap"
+)
+
+type fakeChannel struct {
+	messages     []string
+	disconnected int
+}
+
+func (f *fakeChannel) BroadcastChatMessage(message string) {
+	f.messages = append(f.messages, message)
+}
+
+func (f *fakeChannel) DisconnectAll(ctx context.Context) int {
+	f.disconnected++
+	return 3
+}
+
+type fakeStore struct {
+	status Status
+	saves  int
+}
+
+func (f *fakeStore) Load() (Status, error) { return f.status, nil }
+
+func (f *fakeStore) Save(status Status) error {
+	f.status = status
+	f.saves++
+	return nil
+}
+
+func newTestServer(now *time.Time) (*Server, *fakeChannel, *fakeStore) {
+	channel := &fakeChannel{}
+	store := &fakeStore{}
+	s := NewServer(&Config{
+		Logger:      zap.NewNop(),
+		ErupeConfig: &config.Config{Lockdown: config.Lockdown{Countdown: []int{600, 300, 60, 30, 10, 5, 4, 3, 2, 1}}},
+		Channels:    []Channel{channel},
+	})
+	s.store = store
+	s.now = func() time.Time { return *now }
+	return s, channel, store
+}
+
+func TestCountdownText(t *testing.T) {
+	tests := []struct {
+		left time.Duration
+		want string
+	}{
+		{10 * time.Minute, "10 minutes"},
+		{4*time.Minute + time.Second, "5 minutes"},
+		{time.Minute, "1 minute"},
+		{59*time.Second + time.Millisecond, "1 minute"},
+		{30 * time.Second, "30 seconds"},
+		{time.Second, "1 second"},
+		{100 * time.Millisecond, "1 second"},
+	}
+	for _, tt := range tests {
+		if got := countdownText(tt.left); got != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.left, got, tt.want)
+		}
+	}
+}
+
+func TestCountdownSchedule(t *testing.T) {
+	now := time.Date(2022, 6, 1, 3, 0, 0, 0, time.UTC)
+	s, channel, store := newTestServer(&now)
+
+	if _, err := s.Begin(10*time.Minute, true, "", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if !store.status.Active || !store.status.EndsAt.Equal(now.Add(10*time.Minute)) {
+		t.Fatalf("saved %+v, want maintenance ending in 10 minutes", store.status)
+	}
+	// Step through the countdown a second at a time, as the ticker does.
+	for i := 0; i < 600; i++ {
+		now = now.Add(time.Second)
+		s.check()
+	}
+	want := []string{
+		"The server goes down for maintenance in 10 minutes, your progress will be saved.",
+		"The server goes down for maintenance in 5 minutes, your progress will be saved.",
+		"The server goes down for maintenance in 1 minute, your progress will be saved.",
+		"The server goes down for maintenance in 30 seconds, your progress will be saved.",
+		"The server goes down for maintenance in 10 seconds, your progress will be saved.",
+		"The server goes down for maintenance in 5 seconds, your progress will be saved.",
+		"The server goes down for maintenance in 4 seconds, your progress will be saved.",
+		"The server goes down for maintenance in 3 seconds, your progress will be saved.",
+		"The server goes down for maintenance in 2 seconds, your progress will be saved.",
+		"The server goes down for maintenance in 1 second, your progress will be saved.",
+		"The server is going down for maintenance.",
+	}
+	if !reflect.DeepEqual(channel.messages, want) {
+		t.Errorf("got warnings %q, want %q", channel.messages, want)
+	}
+	if channel.disconnected != 1 || !store.status.Kicked {
+		t.Errorf("disconnected %d times with %+v saved, want once and kicked", channel.disconnected, store.status)
+	}
+
+	now = now.Add(time.Minute)
+	s.check()
+	if channel.disconnected != 1 {
+		t.Error("disconnected everyone again after the countdown")
+	}
+	if !s.MaintenanceStatus().Active {
+		t.Error("maintenance ended with the countdown")
+	}
+}
+
+func TestShortCountdownWarnsOnce(t *testing.T) {
+	now := time.Date(2022, 6, 1, 3, 0, 0, 0, time.UTC)
+	s, channel, _ := newTestServer(&now)
+
+	s.Begin(90*time.Second, false, "Patching quests.", "admin")
+	s.check()
+	want := []string{"Maintenance begins in 2 minutes. Patching quests."}
+	if !reflect.DeepEqual(channel.messages, want) {
+		t.Errorf("got warnings %q, want %q", channel.messages, want)
+	}
+	now = now.Add(90 * time.Second)
+	s.check()
+	if channel.disconnected != 0 {
+		t.Error("disconnected players without kick")
+	}
+}
+
+func TestResumeAfterRestart(t *testing.T) {
+	now := time.Date(2022, 6, 1, 3, 0, 0, 0, time.UTC)
+	s, channel, store := newTestServer(&now)
+	store.status = Status{Active: true, EndsAt: now.Add(45 * time.Second), Kick: true}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown()
+
+	if !s.MaintenanceStatus().Active {
+		t.Fatal("maintenance mode wasn't picked up from the store")
+	}
+	s.check()
+	want := []string{"The server goes down for maintenance in 45 seconds, your progress will be saved."}
+	if !reflect.DeepEqual(channel.messages, want) {
+		t.Errorf("got warnings %q, want the one for the time left %q", channel.messages, want)
+	}
+}
+
+func TestEnd(t *testing.T) {
+	now := time.Date(2022, 6, 1, 3, 0, 0, 0, time.UTC)
+	s, channel, store := newTestServer(&now)
+
+	if err := s.End(); err != ErrNotActive {
+		t.Errorf("ending inactive maintenance got %v", err)
+	}
+	s.Begin(time.Hour, true, "", "admin")
+	s.Begin(5*time.Minute, true, "", "admin")
+	if err := s.End(); err != nil {
+		t.Fatal(err)
+	}
+	if store.status.Active {
+		t.Error("maintenance mode still saved as active")
+	}
+	if last := channel.messages[len(channel.messages)-1]; last != "Maintenance was called off." {
+		t.Errorf("got %q after calling maintenance off", last)
+	}
+	now = now.Add(time.Hour)
+	s.check()
+	if channel.disconnected != 0 {
+		t.Error("disconnected players after maintenance ended")
+	}
+}
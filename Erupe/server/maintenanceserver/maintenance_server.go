@@ -0,0 +1,298 @@
+package maintenanceserver
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// How often the scheduler checks whether any task is due.
+const checkInterval = time.Minute
+
+// Key of the postgres advisory lock held while tasks run, so only one node runs them when several share the DB.
+const advisoryLockKey = 0x45525550
+
+// Task run outcomes.
+const (
+	statusCompleted   = "completed"
+	statusInterrupted = "interrupted"
+	statusFailed      = "failed"
+)
+
+// Config struct allows configuring the server.
+type Config struct {
+	Logger      *zap.Logger
+	DB          *sqlx.DB
+	ErupeConfig *config.Config
+}
+
+// TaskStatus is the outcome of the last run of a task.
+type TaskStatus struct {
+	Task         string    `json:"task"`
+	StartedAt    time.Time `json:"startedAt"`
+	CompletedAt  time.Time `json:"completedAt"` // Zero if the run didn't complete.
+	RowsAffected int64     `json:"rowsAffected"`
+	Status       string    `json:"status"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// execer is the part of a DB connection the tasks run on.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Server runs the configured maintenance tasks inside the maintenance window.
+type Server struct {
+	sync.Mutex
+	logger      *zap.Logger
+	db          *sqlx.DB
+	erupeConfig *config.Config
+	status      map[string]TaskStatus
+	now         func() time.Time
+	ctx         context.Context
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+// NewServer creates a new Server type.
+func NewServer(config *Config) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		logger:      config.Logger,
+		db:          config.DB,
+		erupeConfig: config.ErupeConfig,
+		status:      make(map[string]TaskStatus),
+		now:         time.Now,
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+	return s
+}
+
+// Start loads the last run of every task and starts the scheduler in a new goroutine.
+func (s *Server) Start() error {
+	rows, err := s.db.Query("SELECT task, started_at, completed_at, rows_affected, status FROM maintenance_runs")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var status TaskStatus
+		var completedAt sql.NullTime
+		if err = rows.Scan(&status.Task, &status.StartedAt, &completedAt, &status.RowsAffected, &status.Status); err != nil {
+			rows.Close()
+			return err
+		}
+		status.CompletedAt = completedAt.Time
+		s.status[status.Task] = status
+	}
+	rows.Close()
+
+	go s.run()
+	return nil
+}
+
+// Shutdown stops the scheduler, interrupting a running task at its next batch.
+func (s *Server) Shutdown() {
+	s.logger.Debug("Shutting down")
+	s.cancel()
+	<-s.done
+}
+
+// Status returns the last run of every task, in task name order.
+func (s *Server) Status() []TaskStatus {
+	s.Lock()
+	defer s.Unlock()
+	status := make([]TaskStatus, 0, len(s.status))
+	for _, taskStatus := range s.status {
+		status = append(status, taskStatus)
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Task < status[j].Task })
+	return status
+}
+
+// WriteMetrics writes the last run of every task, so the scheduler can be a metrics source.
+func (s *Server) WriteMetrics(w io.Writer) {
+	for _, status := range s.Status() {
+		task := strconv.Quote(status.Task)
+		fmt.Fprintf(w, "erupe_maintenance_last_run_timestamp_seconds{task=%s,status=\"%s\"} %d\n", task, status.Status, status.StartedAt.Unix())
+		fmt.Fprintf(w, "erupe_maintenance_last_run_rows_affected{task=%s} %d\n", task, status.RowsAffected)
+		if !status.CompletedAt.IsZero() {
+			fmt.Fprintf(w, "erupe_maintenance_last_completed_timestamp_seconds{task=%s} %d\n", task, status.CompletedAt.Unix())
+		}
+	}
+}
+
+// inWindow reports whether now falls between the start and end hours. The window spans midnight
+// when end is before start, and is always open when they are equal.
+func inWindow(now time.Time, start int, end int) bool {
+	hour := now.Hour()
+	switch {
+	case start == end:
+		return true
+	case start < end:
+		return hour >= start && hour < end
+	default:
+		return hour >= start || hour < end
+	}
+}
+
+func (s *Server) inWindow() bool {
+	cfg := s.erupeConfig.Maintenance
+	return inWindow(s.now(), cfg.WindowStart, cfg.WindowEnd)
+}
+
+func (s *Server) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			if s.inWindow() {
+				s.runDueTasks()
+			}
+		}
+	}
+}
+
+// runDueTasks runs every task that is due, on a single connection holding the advisory lock.
+func (s *Server) runDueTasks() {
+	conn, err := s.db.Conn(s.ctx)
+	if err != nil {
+		s.logger.Error("Failed to get a connection for maintenance", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+
+	var locked bool
+	err = conn.QueryRowContext(s.ctx, "SELECT pg_try_advisory_lock($1)", advisoryLockKey).Scan(&locked)
+	if err != nil {
+		s.logger.Error("Failed to take the maintenance lock", zap.Error(err))
+		return
+	}
+	if !locked {
+		s.logger.Debug("Maintenance is running on another node")
+		return
+	}
+	defer func() {
+		// Released on the same connection even if the scheduler is shutting down.
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", advisoryLockKey); err != nil {
+			s.logger.Warn("Failed to release the maintenance lock", zap.Error(err))
+		}
+	}()
+
+	for _, task := range s.erupeConfig.Maintenance.Tasks {
+		if !task.Enabled {
+			continue
+		}
+		due, err := s.due(conn, task)
+		if err != nil {
+			s.logger.Error("Failed to get last maintenance run", zap.Error(err), zap.String("task", task.Name))
+			continue
+		}
+		if !due {
+			continue
+		}
+		status := s.runTask(conn, task)
+		s.record(conn, status)
+		if status.Status == statusInterrupted {
+			return
+		}
+	}
+	s.runSavedataSnapshots(conn)
+	s.runRankResets(conn)
+}
+
+// due reports whether the task last completed over its interval ago, on any node.
+func (s *Server) due(conn *sql.Conn, task config.MaintenanceTask) (bool, error) {
+	var completedAt sql.NullTime
+	err := conn.QueryRowContext(s.ctx, "SELECT completed_at FROM maintenance_runs WHERE task = $1", task.Name).Scan(&completedAt)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !completedAt.Valid || s.now().Sub(completedAt.Time) >= time.Duration(task.EveryHours)*time.Hour, nil
+}
+
+// runTask runs the task's statement, repeating it in batches until it affects fewer rows than the
+// batch size. Between batches it stops if the window has closed or the scheduler is shutting down.
+func (s *Server) runTask(db execer, task config.MaintenanceTask) TaskStatus {
+	status := TaskStatus{Task: task.Name, StartedAt: s.now()}
+	s.logger.Info("Running maintenance task", zap.String("task", task.Name))
+
+	for {
+		var result sql.Result
+		var err error
+		if task.BatchSize > 0 {
+			result, err = db.ExecContext(s.ctx, task.SQL, task.BatchSize)
+		} else {
+			result, err = db.ExecContext(s.ctx, task.SQL)
+		}
+		if err != nil {
+			if s.ctx.Err() != nil {
+				status.Status = statusInterrupted
+			} else {
+				status.Status = statusFailed
+				status.Error = err.Error()
+			}
+			break
+		}
+		rows, _ := result.RowsAffected()
+		status.RowsAffected += rows
+		if task.BatchSize <= 0 || rows < int64(task.BatchSize) {
+			status.Status = statusCompleted
+			status.CompletedAt = s.now()
+			break
+		}
+		if s.ctx.Err() != nil || !s.inWindow() {
+			status.Status = statusInterrupted
+			break
+		}
+	}
+
+	s.logger.Info("Finished maintenance task",
+		zap.String("task", task.Name),
+		zap.String("status", status.Status),
+		zap.Int64("rows", status.RowsAffected),
+		zap.String("error", status.Error),
+	)
+	return status
+}
+
+// record stores the run, keeping the previous completion time if it didn't complete so the task stays due.
+func (s *Server) record(conn *sql.Conn, status TaskStatus) {
+	s.Lock()
+	if previous, ok := s.status[status.Task]; ok && status.CompletedAt.IsZero() {
+		status.CompletedAt = previous.CompletedAt
+	}
+	s.status[status.Task] = status
+	s.Unlock()
+
+	var completedAt sql.NullTime
+	if status.Status == statusCompleted {
+		completedAt = sql.NullTime{Time: status.CompletedAt, Valid: true}
+	}
+	_, err := conn.ExecContext(context.Background(), `
+		INSERT INTO maintenance_runs (task, started_at, completed_at, rows_affected, status) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (task) DO UPDATE SET started_at = EXCLUDED.started_at,
+			completed_at = COALESCE(EXCLUDED.completed_at, maintenance_runs.completed_at),
+			rows_affected = EXCLUDED.rows_affected, status = EXCLUDED.status
+	`, status.Task, status.StartedAt, completedAt, status.RowsAffected, status.Status)
+	if err != nil {
+		s.logger.Error("Failed to record maintenance run", zap.Error(err), zap.String("task", status.Task))
+	}
+}
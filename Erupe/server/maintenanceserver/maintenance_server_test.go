@@ -0,0 +1,125 @@
+package maintenanceserver
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+	"go.uber.org/zap"
+)
+
+func TestInWindow(t *testing.T) {
+	at := func(hour int) time.Time { return time.Date(2022, 6, 1, hour, 30, 0, 0, time.UTC) }
+	tests := []struct {
+		hour       int
+		start, end int
+		want       bool
+	}{
+		{4, 4, 6, true},
+		{5, 4, 6, true},
+		{6, 4, 6, false},
+		{3, 4, 6, false},
+		{23, 22, 2, true},
+		{1, 22, 2, true},
+		{2, 22, 2, false},
+		{12, 22, 2, false},
+		{12, 0, 0, true},
+	}
+	for _, tt := range tests {
+		if got := inWindow(at(tt.hour), tt.start, tt.end); got != tt.want {
+			t.Errorf("%02d:30 in window %d-%d got %v, want %v", tt.hour, tt.start, tt.end, got, tt.want)
+		}
+	}
+}
+
+// fakeExecer affects rows[i] rows on the i-th call, and calls onExec after each one.
+type fakeExecer struct {
+	rows   []int64
+	calls  int
+	onExec func(call int)
+}
+
+func (f *fakeExecer) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	rows := f.rows[f.calls]
+	f.calls++
+	if f.onExec != nil {
+		f.onExec(f.calls)
+	}
+	return driverResult(rows), nil
+}
+
+type driverResult int64
+
+func (r driverResult) LastInsertId() (int64, error) { return 0, nil }
+func (r driverResult) RowsAffected() (int64, error) { return int64(r), nil }
+
+func newTestServer(now *time.Time) *Server {
+	s := NewServer(&Config{
+		Logger:      zap.NewNop(),
+		ErupeConfig: &config.Config{Maintenance: config.Maintenance{WindowStart: 4, WindowEnd: 6}},
+	})
+	s.now = func() time.Time { return *now }
+	return s
+}
+
+func TestRunTaskBatchesUntilShort(t *testing.T) {
+	now := time.Date(2022, 6, 1, 4, 0, 0, 0, time.UTC)
+	s := newTestServer(&now)
+	db := &fakeExecer{rows: []int64{100, 100, 40}}
+
+	status := s.runTask(db, config.MaintenanceTask{Name: "purge", BatchSize: 100})
+	if status.Status != statusCompleted || status.RowsAffected != 240 || db.calls != 3 {
+		t.Errorf("got %s with %d rows after %d batches, want completed with 240 rows after 3", status.Status, status.RowsAffected, db.calls)
+	}
+	if status.CompletedAt.IsZero() {
+		t.Error("completed run has no completion time")
+	}
+}
+
+func TestRunTaskStopsWhenWindowCloses(t *testing.T) {
+	now := time.Date(2022, 6, 1, 5, 58, 0, 0, time.UTC)
+	s := newTestServer(&now)
+	db := &fakeExecer{
+		rows: []int64{100, 100, 100, 100},
+		onExec: func(call int) {
+			now = now.Add(time.Minute)
+		},
+	}
+
+	status := s.runTask(db, config.MaintenanceTask{Name: "purge", BatchSize: 100})
+	if status.Status != statusInterrupted || db.calls != 2 || status.RowsAffected != 200 {
+		t.Errorf("got %s with %d rows after %d batches, want interrupted with 200 rows after 2", status.Status, status.RowsAffected, db.calls)
+	}
+	if !status.CompletedAt.IsZero() {
+		t.Error("interrupted run has a completion time")
+	}
+}
+
+func TestRunTaskStopsOnShutdown(t *testing.T) {
+	now := time.Date(2022, 6, 1, 4, 0, 0, 0, time.UTC)
+	s := newTestServer(&now)
+	db := &fakeExecer{
+		rows: []int64{100, 100, 100},
+		onExec: func(call int) {
+			s.cancel()
+		},
+	}
+
+	status := s.runTask(db, config.MaintenanceTask{Name: "purge", BatchSize: 100})
+	if status.Status != statusInterrupted || db.calls != 1 {
+		t.Errorf("got %s after %d batches, want interrupted after 1", status.Status, db.calls)
+	}
+}
+
+func TestRunTaskWithoutBatchRunsOnce(t *testing.T) {
+	now := time.Date(2022, 6, 1, 4, 0, 0, 0, time.UTC)
+	s := newTestServer(&now)
+	db := &fakeExecer{rows: []int64{0}}
+
+	status := s.runTask(db, config.MaintenanceTask{Name: "vacuum"})
+	if status.Status != statusCompleted || db.calls != 1 {
+		t.Errorf("got %s after %d calls, want completed after 1", status.Status, db.calls)
+	}
+}
@@ -0,0 +1,384 @@
+package maintenanceserver
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/server/channelserver"
+	"go.uber.org/zap"
+)
+
+// Rank reset outcomes, besides the task ones.
+const statusDryRun = "dry_run"
+
+// rankResetBatchSize is how many characters a rank reset or revert goes through per transaction, so it
+// doesn't hold the lock of every character until the last one is written.
+const rankResetBatchSize = 500
+
+// rankChange is a character's HRP and G rank points before and after a rank reset.
+type rankChange struct {
+	charID  uint32
+	hrp     uint16
+	newHRP  uint16
+	grp     uint32
+	newGRP  uint32
+	catchUp bool
+}
+
+// keepFraction is the fraction of the ranks above the floor the reset keeps, held between 0 and 1.
+func keepFraction(reset config.RankReset) float64 {
+	if reset.Keep < 0 {
+		return 0
+	} else if reset.Keep > 1 {
+		return 1
+	}
+	return reset.Keep
+}
+
+// decayRank applies the reset's curve: HRP above the floor is cut down to the kept fraction of it.
+func decayRank(reset config.RankReset, hrp uint16) uint16 {
+	if hrp <= reset.Floor {
+		return hrp
+	}
+	return reset.Floor + uint16(float64(hrp-reset.Floor)*keepFraction(reset))
+}
+
+// decayGRP applies the reset's curve to the G rank points, left alone without a G rank floor.
+func decayGRP(reset config.RankReset, grp uint32) uint32 {
+	if reset.GRPFloor == 0 || grp <= reset.GRPFloor {
+		return grp
+	}
+	return reset.GRPFloor + uint32(float64(grp-reset.GRPFloor)*keepFraction(reset))
+}
+
+// returning reports whether a character last seen at lastLogin counts as returning for the reset's catch-up.
+func returning(reset config.RankReset, lastLogin time.Time, now time.Time) bool {
+	return reset.ReturningDays <= 0 || now.Sub(lastLogin) >= time.Duration(reset.ReturningDays)*24*time.Hour
+}
+
+// rankResetTask is the name the reset's run is recorded under, dry runs and reverts each get their own.
+func rankResetTask(reset config.RankReset) string {
+	switch {
+	case reset.Revert:
+		return "rank-reset/" + reset.Name + "/revert"
+	case reset.DryRun:
+		return "rank-reset/" + reset.Name + "/dry-run"
+	}
+	return "rank-reset/" + reset.Name
+}
+
+// runRankResets runs each configured reset, revert or dry run once, after its time has come.
+func (s *Server) runRankResets(conn *sql.Conn) {
+	for _, reset := range s.erupeConfig.Maintenance.RankResets {
+		task := rankResetTask(reset)
+		if s.ctx.Err() != nil {
+			return
+		}
+		var completedAt sql.NullTime
+		err := conn.QueryRowContext(s.ctx, "SELECT completed_at FROM maintenance_runs WHERE task = $1", task).Scan(&completedAt)
+		if err != nil && err != sql.ErrNoRows {
+			s.logger.Error("Failed to get last rank reset run", zap.Error(err), zap.String("task", task))
+			continue
+		}
+		if completedAt.Valid {
+			continue
+		}
+
+		var status TaskStatus
+		if reset.Revert {
+			status = s.revertRankReset(conn, reset, task)
+		} else {
+			at, err := time.Parse(time.RFC3339, reset.At)
+			if err != nil {
+				s.logger.Error("Invalid rank reset time", zap.Error(err), zap.String("task", task))
+				continue
+			}
+			if s.now().Before(at) {
+				continue
+			}
+			status = s.applyRankReset(conn, reset, task)
+		}
+		s.record(conn, status)
+	}
+}
+
+// finishRankReset fills in the outcome of a reset or revert and logs it.
+func (s *Server) finishRankReset(status TaskStatus, err error) TaskStatus {
+	if err != nil {
+		status.Status = statusFailed
+		if s.ctx.Err() != nil {
+			status.Status = statusInterrupted
+		}
+		status.Error = err.Error()
+		status.RowsAffected = 0
+	} else {
+		status.CompletedAt = s.now()
+		if status.Status == "" {
+			status.Status = statusCompleted
+		}
+	}
+	s.logger.Info("Finished rank reset",
+		zap.String("task", status.Task),
+		zap.String("status", status.Status),
+		zap.Int64("characters", status.RowsAffected),
+		zap.String("error", status.Error),
+	)
+	return status
+}
+
+// applyRankReset snapshots the ranks above the floor, decays them and mails each character its previous
+// rank, rankResetBatchSize characters per transaction. A run that stops partway is resumed by the next one,
+// past the characters it already snapshotted. A dry run logs the changes and rolls each batch back.
+func (s *Server) applyRankReset(conn *sql.Conn, reset config.RankReset, task string) TaskStatus {
+	status := TaskStatus{Task: task, StartedAt: s.now()}
+	s.logger.Info("Running rank reset", zap.String("task", task), zap.Bool("dryRun", reset.DryRun))
+
+	if !reset.DryRun {
+		if _, err := conn.ExecContext(s.ctx, "INSERT INTO rank_resets (name, applied_at) VALUES ($1, $2) ON CONFLICT (name) DO NOTHING", reset.Name, status.StartedAt); err != nil {
+			return s.finishRankReset(status, err)
+		}
+		var completedAt, revertedAt sql.NullTime
+		err := conn.QueryRowContext(s.ctx, "SELECT completed_at, reverted_at FROM rank_resets WHERE name = $1", reset.Name).Scan(&completedAt, &revertedAt)
+		if err != nil {
+			return s.finishRankReset(status, err)
+		}
+		if completedAt.Valid || revertedAt.Valid {
+			return s.finishRankReset(status, fmt.Errorf("rank reset %q was already applied", reset.Name))
+		}
+	}
+
+	var afterID uint32
+	for {
+		changed, lastID, err := s.applyRankResetBatch(conn, reset, status.StartedAt, afterID)
+		status.RowsAffected += changed
+		if err != nil {
+			return s.finishRankReset(status, err)
+		}
+		if lastID == 0 {
+			break
+		}
+		afterID = lastID
+	}
+
+	if reset.DryRun {
+		status.Status = statusDryRun
+		return s.finishRankReset(status, nil)
+	}
+	if _, err := conn.ExecContext(s.ctx, "UPDATE rank_resets SET completed_at = $1 WHERE name = $2", s.now(), reset.Name); err != nil {
+		return s.finishRankReset(status, err)
+	}
+	return s.finishRankReset(status, nil)
+}
+
+// applyRankResetBatch resets the next characters after afterID whose ranks may be above the floor and that
+// aren't snapshotted for the reset yet, in one transaction. It returns how many it changed and the last
+// character it went through, 0 once none are left.
+func (s *Server) applyRankResetBatch(conn *sql.Conn, reset config.RankReset, now time.Time, afterID uint32) (int64, uint32, error) {
+	tx, err := conn.BeginTx(s.ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(s.ctx, `
+		SELECT id, COALESCE(last_login, 0) FROM characters c
+		WHERE id > $1 AND deleted_at IS NULL AND savedata IS NOT NULL AND (hrp > $2 OR ($3 > 0 AND gr > 0))
+		AND NOT EXISTS (SELECT 1 FROM rank_reset_snapshots WHERE reset = $4 AND character_id = c.id)
+		ORDER BY id LIMIT $5 FOR UPDATE
+	`, afterID, reset.Floor, reset.GRPFloor, reset.Name, rankResetBatchSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	var batch []rankChange
+	for rows.Next() {
+		var change rankChange
+		var lastLogin int64
+		if err := rows.Scan(&change.charID, &lastLogin); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		change.catchUp = returning(reset, time.Unix(lastLogin, 0), now)
+		batch = append(batch, change)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	var changed int64
+	var lastID uint32
+	for _, change := range batch {
+		lastID = change.charID
+		savedata, err := s.consolidatedSavedata(tx, change.charID)
+		if err != nil {
+			return 0, 0, err
+		}
+		change.hrp, change.grp, err = channelserver.SaveDataRanks(savedata)
+		if err != nil {
+			s.logger.Warn("Skipped the rank reset of a character", zap.Uint32("charID", change.charID), zap.Error(err))
+			continue
+		}
+		change.newHRP, change.newGRP = decayRank(reset, change.hrp), decayGRP(reset, change.grp)
+		if change.newHRP == change.hrp && change.newGRP == change.grp {
+			continue
+		}
+		changed++
+		if reset.DryRun {
+			s.logger.Info("Rank reset would change",
+				zap.Uint32("charID", change.charID),
+				zap.Uint16("hrp", change.hrp),
+				zap.Uint16("newHRP", change.newHRP),
+				zap.Uint32("grp", change.grp),
+				zap.Uint32("newGRP", change.newGRP),
+				zap.Bool("catchUp", change.catchUp),
+			)
+			continue
+		}
+
+		savedata, gr, err := channelserver.SetSaveDataRanks(savedata, change.newHRP, change.newGRP)
+		if err != nil {
+			return 0, 0, fmt.Errorf("character %d: %w", change.charID, err)
+		}
+		if _, err := tx.ExecContext(s.ctx, `
+			INSERT INTO rank_reset_snapshots (reset, character_id, hrp, new_hrp, grp, new_grp, catch_up) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`, reset.Name, change.charID, change.hrp, change.newHRP, change.grp, change.newGRP, change.catchUp); err != nil {
+			return 0, 0, err
+		}
+		if _, err := tx.ExecContext(s.ctx, "UPDATE characters SET hrp = $1, gr = $2, savedata = $3 WHERE id = $4", change.newHRP, gr, savedata, change.charID); err != nil {
+			return 0, 0, err
+		}
+		body := fmt.Sprintf("The new season has begun. Your HRP was %d before the reset and is now %d.", change.hrp, change.newHRP)
+		if change.newGRP != change.grp {
+			body += fmt.Sprintf(" Your G rank points were %d and are now %d.", change.grp, change.newGRP)
+		}
+		if change.catchUp && len(reset.CatchUpCourses) > 0 && reset.CatchUpDays > 0 {
+			body += fmt.Sprintf(" Welcome back! Catch-up boosts are active on your account for %d days.", reset.CatchUpDays)
+		}
+		if err := channelserver.SendSystemMail(tx, change.charID, "Season reset", body); err != nil {
+			return 0, 0, err
+		}
+	}
+	if reset.DryRun {
+		return changed, lastID, nil
+	}
+	return changed, lastID, tx.Commit()
+}
+
+// consolidatedSavedata folds the character's savedata diffs into its savedata and returns it.
+func (s *Server) consolidatedSavedata(tx *sql.Tx, charID uint32) ([]byte, error) {
+	broken, err := channelserver.ConsolidateSavedata(tx, charID)
+	if err != nil {
+		return nil, err
+	}
+	if broken {
+		s.logger.Warn("Savedata diffs don't apply, kept the savedata last written whole", zap.Uint32("charID", charID))
+	}
+	var savedata []byte
+	err = tx.QueryRowContext(s.ctx, "SELECT savedata FROM characters WHERE id = $1", charID).Scan(&savedata)
+	return savedata, err
+}
+
+// revertRankReset restores the snapshotted ranks of a reset still within its grace window,
+// rankResetBatchSize characters per transaction.
+func (s *Server) revertRankReset(conn *sql.Conn, reset config.RankReset, task string) TaskStatus {
+	status := TaskStatus{Task: task, StartedAt: s.now()}
+	s.logger.Info("Reverting rank reset", zap.String("task", task))
+
+	var appliedAt time.Time
+	var revertedAt sql.NullTime
+	err := conn.QueryRowContext(s.ctx, "SELECT applied_at, reverted_at FROM rank_resets WHERE name = $1", reset.Name).Scan(&appliedAt, &revertedAt)
+	if err == sql.ErrNoRows {
+		return s.finishRankReset(status, fmt.Errorf("rank reset %q was never applied", reset.Name))
+	} else if err != nil {
+		return s.finishRankReset(status, err)
+	}
+	if revertedAt.Valid {
+		return s.finishRankReset(status, nil)
+	}
+	if !s.now().Before(appliedAt.Add(time.Duration(reset.GraceDays) * 24 * time.Hour)) {
+		return s.finishRankReset(status, errors.New("the grace window for reverting the rank reset is over"))
+	}
+
+	for {
+		reverted, err := s.revertRankResetBatch(conn, reset)
+		status.RowsAffected += reverted
+		if err != nil {
+			return s.finishRankReset(status, err)
+		}
+		if reverted < rankResetBatchSize {
+			break
+		}
+	}
+	if _, err := conn.ExecContext(s.ctx, "UPDATE rank_resets SET reverted_at = $1 WHERE name = $2", status.StartedAt, reset.Name); err != nil {
+		return s.finishRankReset(status, err)
+	}
+	return s.finishRankReset(status, nil)
+}
+
+// revertRankResetBatch restores the next snapshots of the reset not reverted yet, in one transaction. G rank
+// points are only restored for the characters the reset changed them for.
+func (s *Server) revertRankResetBatch(conn *sql.Conn, reset config.RankReset) (int64, error) {
+	tx, err := conn.BeginTx(s.ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(s.ctx, `
+		SELECT snap.character_id, snap.hrp, snap.new_hrp, snap.grp, snap.new_grp FROM rank_reset_snapshots snap
+		JOIN characters c ON c.id = snap.character_id
+		WHERE snap.reset = $1 AND NOT snap.reverted AND c.savedata IS NOT NULL
+		ORDER BY snap.character_id LIMIT $2 FOR UPDATE
+	`, reset.Name, rankResetBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	var batch []rankChange
+	for rows.Next() {
+		var change rankChange
+		if err := rows.Scan(&change.charID, &change.hrp, &change.newHRP, &change.grp, &change.newGRP); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, change)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, change := range batch {
+		savedata, err := s.consolidatedSavedata(tx, change.charID)
+		if err != nil {
+			return 0, err
+		}
+		_, grp, err := channelserver.SaveDataRanks(savedata)
+		if err != nil {
+			return 0, fmt.Errorf("character %d: %w", change.charID, err)
+		}
+		if change.grp != change.newGRP {
+			grp = change.grp
+		}
+		savedata, gr, err := channelserver.SetSaveDataRanks(savedata, change.hrp, grp)
+		if err != nil {
+			return 0, fmt.Errorf("character %d: %w", change.charID, err)
+		}
+		if _, err := tx.ExecContext(s.ctx, "UPDATE characters SET hrp = $1, gr = $2, savedata = $3 WHERE id = $4", change.hrp, gr, savedata, change.charID); err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(s.ctx, "UPDATE rank_reset_snapshots SET reverted = true WHERE reset = $1 AND character_id = $2", reset.Name, change.charID); err != nil {
+			return 0, err
+		}
+		body := fmt.Sprintf("The season reset was undone. Your HRP is back to %d.", change.hrp)
+		if change.grp != change.newGRP {
+			body += fmt.Sprintf(" Your G rank points are back to %d.", change.grp)
+		}
+		if err := channelserver.SendSystemMail(tx, change.charID, "Season reset undone", body); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(batch)), tx.Commit()
+}
@@ -0,0 +1,189 @@
+package maintenanceserver
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/common/testdb"
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/server/channelserver"
+	"github.com/Solenataris/Erupe/server/channelserver/compression/nullcomp"
+	_ "github.com/lib/pq"
+)
+
+func TestDecayRank(t *testing.T) {
+	reset := config.RankReset{Floor: 100, Keep: 0.5}
+	tests := []struct {
+		hrp, want uint16
+	}{
+		{50, 50},   // Below the floor.
+		{100, 100}, // At the floor.
+		{300, 200},
+		{999, 549},
+	}
+	for _, tt := range tests {
+		if got := decayRank(reset, tt.hrp); got != tt.want {
+			t.Errorf("HRP %d decayed to %d, want %d", tt.hrp, got, tt.want)
+		}
+	}
+	if got := decayRank(config.RankReset{Floor: 100}, 300); got != 100 {
+		t.Errorf("a full reset left HRP %d, want the floor", got)
+	}
+	if got := decayRank(config.RankReset{Keep: 2}, 300); got != 300 {
+		t.Errorf("keeping more than all of it gave HRP %d, want it unchanged", got)
+	}
+	if got := decayGRP(reset, 100000); got != 100000 {
+		t.Errorf("a reset without a G rank floor decayed GRP to %d", got)
+	}
+	reset.GRPFloor = 50000
+	if got := decayGRP(reset, 100000); got != 75000 {
+		t.Errorf("GRP 100000 decayed to %d, want 75000", got)
+	}
+	if got := decayGRP(reset, 40000); got != 40000 {
+		t.Errorf("GRP below the floor decayed to %d", got)
+	}
+}
+
+func TestReturning(t *testing.T) {
+	now := time.Date(2022, 6, 1, 4, 0, 0, 0, time.UTC)
+	reset := config.RankReset{ReturningDays: 30}
+	if returning(reset, now.AddDate(0, 0, -29), now) {
+		t.Error("a character seen 29 days ago counts as returning")
+	}
+	if !returning(reset, now.AddDate(0, 0, -30), now) {
+		t.Error("a character away 30 days doesn't count as returning")
+	}
+	if !returning(config.RankReset{}, now, now) {
+		t.Error("without a returning threshold every character should get the catch-up")
+	}
+}
+
+func TestRankResetTask(t *testing.T) {
+	if got := rankResetTask(config.RankReset{Name: "s2"}); got != "rank-reset/s2" {
+		t.Errorf("got task %q", got)
+	}
+	if got := rankResetTask(config.RankReset{Name: "s2", DryRun: true}); got != "rank-reset/s2/dry-run" {
+		t.Errorf("got dry run task %q", got)
+	}
+	if got := rankResetTask(config.RankReset{Name: "s2", Revert: true, DryRun: true}); got != "rank-reset/s2/revert" {
+		t.Errorf("got revert task %q", got)
+	}
+}
+
+
+// TestRankResetApplyAndRevert runs against the database in ERUPE_TEST_DB, e.g.
+// "host=localhost user=postgres password=admin dbname=erupe_test sslmode=disable".
+func TestRankResetApplyAndRevert(t *testing.T) {
+	db := testdb.Open(t)
+
+	var userID int
+	err := db.QueryRow("INSERT INTO users (username, password) VALUES ('rank_reset_test', '') RETURNING id").Scan(&userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+	raw := make([]byte, 130560)
+	binary.LittleEndian.PutUint16(raw[130550:], 500)
+	binary.LittleEndian.PutUint32(raw[130556:], 100000)
+	savedata, err := nullcomp.Compress(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var charID uint32
+	err = db.QueryRow("INSERT INTO characters (user_id, is_new_character, name, hrp, gr, savedata) VALUES ($1, false, 'rank_reset', 500, 50, $2) RETURNING id", userID, savedata).Scan(&charID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM characters WHERE id = $1", charID)
+	defer db.Exec("DELETE FROM mail WHERE recipient_id = $1", charID)
+	defer db.Exec("DELETE FROM maintenance_runs WHERE task LIKE 'rank-reset/test-season%'")
+	defer db.Exec("DELETE FROM rank_resets WHERE name = 'test-season'")
+
+	now := time.Date(2022, 6, 1, 4, 0, 0, 0, time.UTC)
+	s := newTestServer(&now)
+	s.db = db
+	conn, err := db.Conn(s.ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reset := config.RankReset{Name: "test-season", At: "2022-06-01T00:00:00Z", Floor: 100, Keep: 0.5, GRPFloor: 50000, GraceDays: 7}
+	hrp := func() (uint16, uint16) {
+		var column uint16
+		var data []byte
+		if err := db.QueryRow("SELECT hrp, savedata FROM characters WHERE id = $1", charID).Scan(&column, &data); err != nil {
+			t.Fatal(err)
+		}
+		saved, _, err := channelserver.SaveDataRanks(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return column, saved
+	}
+	grp := func() (uint16, uint32) {
+		var gr uint16
+		var data []byte
+		if err := db.QueryRow("SELECT gr, savedata FROM characters WHERE id = $1", charID).Scan(&gr, &data); err != nil {
+			t.Fatal(err)
+		}
+		_, saved, err := channelserver.SaveDataRanks(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return gr, saved
+	}
+
+	dryRun := reset
+	dryRun.DryRun = true
+	if status := s.applyRankReset(conn, dryRun, rankResetTask(dryRun)); status.Status != statusDryRun {
+		t.Fatalf("dry run %s: %s", status.Status, status.Error)
+	}
+	if column, saved := hrp(); column != 500 || saved != 500 {
+		t.Fatalf("dry run changed the HRP to %d, %d in the savedata", column, saved)
+	}
+
+	if status := s.applyRankReset(conn, reset, rankResetTask(reset)); status.Status != statusCompleted {
+		t.Fatalf("reset %s: %s", status.Status, status.Error)
+	}
+	if column, saved := hrp(); column != 300 || saved != 300 {
+		t.Errorf("reset left HRP %d, %d in the savedata, want 300", column, saved)
+	}
+	if gr, saved := grp(); saved != 75000 || gr == 50 || gr == 0 {
+		t.Errorf("reset left G rank %d, GRP %d in the savedata, want 75000", gr, saved)
+	}
+	var snapshot uint16
+	if err := db.QueryRow("SELECT hrp FROM rank_reset_snapshots WHERE reset = 'test-season' AND character_id = $1", charID).Scan(&snapshot); err != nil || snapshot != 500 {
+		t.Errorf("snapshot holds HRP %d (%v), want 500", snapshot, err)
+	}
+	var mails int
+	db.QueryRow("SELECT COUNT(*) FROM mail WHERE recipient_id = $1 AND sender_id IS NULL", charID).Scan(&mails)
+	if mails != 1 {
+		t.Errorf("character got %d mails from the server about the reset, want 1", mails)
+	}
+	if status := s.applyRankReset(conn, reset, rankResetTask(reset)); status.Status != statusFailed {
+		t.Errorf("applying the reset again got %s, want it refused", status.Status)
+	}
+
+	revert := reset
+	revert.Revert = true
+	late := now.AddDate(0, 0, 8)
+	s.now = func() time.Time { return late }
+	if status := s.revertRankReset(conn, revert, rankResetTask(revert)); status.Status != statusFailed {
+		t.Errorf("revert after the grace window got %s, want it refused", status.Status)
+	}
+	s.now = func() time.Time { return now.AddDate(0, 0, 1) }
+	if status := s.revertRankReset(conn, revert, rankResetTask(revert)); status.Status != statusCompleted || status.RowsAffected != 1 {
+		t.Fatalf("revert %s with %d characters: %s", status.Status, status.RowsAffected, status.Error)
+	}
+	if column, saved := hrp(); column != 500 || saved != 500 {
+		t.Errorf("revert left HRP %d, %d in the savedata, want 500", column, saved)
+	}
+	if _, saved := grp(); saved != 100000 {
+		t.Errorf("revert left GRP %d in the savedata, want 100000", saved)
+	}
+	var reverted bool
+	if err := db.QueryRow("SELECT reverted FROM rank_reset_snapshots WHERE reset = 'test-season' AND character_id = $1", charID).Scan(&reverted); err != nil || !reverted {
+		t.Errorf("snapshot reverted %v (%v), want it marked", reverted, err)
+	}
+}
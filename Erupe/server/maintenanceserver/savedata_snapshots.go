@@ -0,0 +1,196 @@
+package maintenanceserver
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/server/channelserver"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// The task the savedata snapshots are recorded under, taken once a day.
+var savedataSnapshotTask = config.MaintenanceTask{Name: "savedata-snapshots", EveryHours: 24}
+
+// savedataSnapshot is a snapshot in savedata_backups.
+type savedataSnapshot struct {
+	id      int64
+	charID  uint32
+	takenAt time.Time
+}
+
+// dayNumber numbers the calendar day of t in loc, counting from 1970-01-01, a Thursday.
+func dayNumber(t time.Time, loc *time.Location) int64 {
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / 86400
+}
+
+// expiredSnapshots returns the IDs of the snapshots past the retention: of each character, the newest
+// snapshot of each of the daily days up to now's is kept, the newest of each of the weekly weeks up to
+// now's, starting on Mondays, and the newest snapshot of all, whatever its age.
+func expiredSnapshots(snapshots []savedataSnapshot, now time.Time, daily int, weekly int) []int64 {
+	sorted := make([]savedataSnapshot, len(snapshots))
+	copy(sorted, snapshots)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].charID != sorted[j].charID {
+			return sorted[i].charID < sorted[j].charID
+		}
+		return sorted[i].takenAt.After(sorted[j].takenAt)
+	})
+
+	type bucket struct {
+		charID uint32
+		number int64
+	}
+	today := dayNumber(now, now.Location())
+	thisWeek := (today + 3) / 7
+	days := make(map[bucket]bool)
+	weeks := make(map[bucket]bool)
+	var expired []int64
+	for i, snapshot := range sorted {
+		newest := i == 0 || sorted[i-1].charID != snapshot.charID
+		day := dayNumber(snapshot.takenAt, now.Location())
+		week := (day + 3) / 7
+		keep := newest
+		if today-day < int64(daily) && !days[bucket{snapshot.charID, day}] {
+			days[bucket{snapshot.charID, day}] = true
+			keep = true
+		}
+		if thisWeek-week < int64(weekly) && !weeks[bucket{snapshot.charID, week}] {
+			weeks[bucket{snapshot.charID, week}] = true
+			keep = true
+		}
+		if !keep {
+			expired = append(expired, snapshot.id)
+		}
+	}
+	return expired
+}
+
+// consolidateSavedata writes whole the savedata of every character with saves stored as diffs, see
+// Channel.SavedataDiffs, for a job about to read or replace characters.savedata.
+func (s *Server) consolidateSavedata(tx *sql.Tx) error {
+	rows, err := tx.QueryContext(s.ctx, "SELECT DISTINCT char_id FROM savedata_diffs")
+	if err != nil {
+		return err
+	}
+	var charIDs []uint32
+	for rows.Next() {
+		var charID uint32
+		if err := rows.Scan(&charID); err != nil {
+			rows.Close()
+			return err
+		}
+		charIDs = append(charIDs, charID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, charID := range charIDs {
+		broken, err := channelserver.ConsolidateSavedata(tx, charID)
+		if err != nil {
+			return err
+		}
+		if broken {
+			s.logger.Warn("Savedata diffs don't apply, kept the savedata last written whole", zap.Uint32("charID", charID))
+		}
+	}
+	return nil
+}
+
+// runSavedataSnapshots takes the daily savedata snapshots once they're due.
+func (s *Server) runSavedataSnapshots(conn *sql.Conn) {
+	if !s.erupeConfig.Maintenance.SavedataSnapshots.Enabled || s.ctx.Err() != nil {
+		return
+	}
+	due, err := s.due(conn, savedataSnapshotTask)
+	if err != nil {
+		s.logger.Error("Failed to get last maintenance run", zap.Error(err), zap.String("task", savedataSnapshotTask.Name))
+		return
+	}
+	if due {
+		s.record(conn, s.takeSavedataSnapshots(conn))
+	}
+}
+
+// takeSavedataSnapshots snapshots every savedata whose hash differs from its character's last snapshot,
+// then prunes the snapshots past the retention.
+func (s *Server) takeSavedataSnapshots(conn *sql.Conn) TaskStatus {
+	status := TaskStatus{Task: savedataSnapshotTask.Name, StartedAt: s.now()}
+	s.logger.Info("Running maintenance task", zap.String("task", status.Task))
+	retention := s.erupeConfig.Maintenance.SavedataSnapshots
+
+	pruned, err := func() (int, error) {
+		tx, err := conn.BeginTx(s.ctx, nil)
+		if err != nil {
+			return 0, err
+		}
+		if err := s.consolidateSavedata(tx); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, err
+		}
+
+		result, err := conn.ExecContext(s.ctx, `
+			INSERT INTO savedata_backups (char_id, savedata, hash, kind, taken_at)
+			SELECT c.id, c.savedata, sha256(c.savedata), 'snapshot', $1 FROM characters c
+			WHERE c.deleted_at IS NULL AND c.savedata IS NOT NULL AND sha256(c.savedata) IS DISTINCT FROM (
+				SELECT b.hash FROM savedata_backups b WHERE b.char_id = c.id AND b.kind = 'snapshot' ORDER BY b.id DESC LIMIT 1
+			)
+		`, status.StartedAt)
+		if err != nil {
+			return 0, err
+		}
+		status.RowsAffected, _ = result.RowsAffected()
+
+		rows, err := conn.QueryContext(s.ctx, "SELECT id, char_id, taken_at FROM savedata_backups WHERE kind = 'snapshot'")
+		if err != nil {
+			return 0, err
+		}
+		var snapshots []savedataSnapshot
+		for rows.Next() {
+			var snapshot savedataSnapshot
+			if err := rows.Scan(&snapshot.id, &snapshot.charID, &snapshot.takenAt); err != nil {
+				rows.Close()
+				return 0, err
+			}
+			snapshots = append(snapshots, snapshot)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+		expired := expiredSnapshots(snapshots, status.StartedAt, retention.Daily, retention.Weekly)
+		if len(expired) > 0 {
+			if _, err := conn.ExecContext(s.ctx, "DELETE FROM savedata_backups WHERE id = ANY($1)", pq.Array(expired)); err != nil {
+				return 0, err
+			}
+		}
+		return len(expired), nil
+	}()
+
+	if err != nil {
+		if s.ctx.Err() != nil {
+			status.Status = statusInterrupted
+		} else {
+			status.Status = statusFailed
+			status.Error = err.Error()
+		}
+	} else {
+		status.Status = statusCompleted
+		status.CompletedAt = s.now()
+	}
+	s.logger.Info("Finished maintenance task",
+		zap.String("task", status.Task),
+		zap.String("status", status.Status),
+		zap.Int64("rows", status.RowsAffected),
+		zap.Int("pruned", pruned),
+		zap.String("error", status.Error),
+	)
+	return status
+}
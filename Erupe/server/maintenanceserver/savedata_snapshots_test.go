@@ -0,0 +1,63 @@
+package maintenanceserver
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestExpiredSnapshots(t *testing.T) {
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC) // A Wednesday.
+	var snapshots []savedataSnapshot
+	taken := make(map[int64]time.Time)
+	add := func(charID uint32, at time.Time) {
+		id := int64(len(snapshots) + 1)
+		snapshots = append(snapshots, savedataSnapshot{id, charID, at})
+		taken[id] = at
+	}
+	// Character 1 changed every day since the start of May.
+	for day := time.Date(2022, 5, 1, 3, 0, 0, 0, time.UTC); day.Before(now); day = day.AddDate(0, 0, 1) {
+		add(1, day)
+	}
+	// Character 2 hasn't changed in months, character 3 changed twice today.
+	add(2, now.AddDate(0, -3, 0))
+	add(2, now.AddDate(0, -4, 0))
+	add(3, now.Add(-2*time.Hour))
+	add(3, now.Add(-time.Hour))
+
+	expired := make(map[int64]bool)
+	for _, id := range expiredSnapshots(snapshots, now, 7, 4) {
+		expired[id] = true
+	}
+	kept := make(map[uint32][]string)
+	for _, snapshot := range snapshots {
+		if !expired[snapshot.id] {
+			kept[snapshot.charID] = append(kept[snapshot.charID], snapshot.takenAt.Format("01-02"))
+		}
+	}
+	for _, dates := range kept {
+		sort.Strings(dates)
+	}
+
+	// The 7 days up to today, and the newest of the weeks starting May 30 and May 23. The two weeks
+	// since June 6 have their newest among the daily ones.
+	want := []string{"05-29", "06-05", "06-09", "06-10", "06-11", "06-12", "06-13", "06-14", "06-15"}
+	if len(kept[1]) != len(want) {
+		t.Fatalf("character 1 kept %v, want %v", kept[1], want)
+	}
+	for i := range want {
+		if kept[1][i] != want[i] {
+			t.Fatalf("character 1 kept %v, want %v", kept[1], want)
+		}
+	}
+	if len(kept[2]) != 1 || kept[2][0] != now.AddDate(0, -3, 0).Format("01-02") {
+		t.Errorf("character 2 kept %v, want only its newest snapshot", kept[2])
+	}
+	if len(kept[3]) != 1 || !expired[int64(len(snapshots)-1)] {
+		t.Errorf("character 3 kept %d of two snapshots of today, want the newer one", len(kept[3]))
+	}
+
+	if expired := expiredSnapshots(snapshots, now, 0, 0); len(expired) != len(snapshots)-3 {
+		t.Errorf("without retention %d snapshots expired, want all but the newest of each character", len(expired))
+	}
+}
@@ -0,0 +1,115 @@
+package metricsserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+	"go.uber.org/zap"
+)
+
+// Source is anything that can write its counters in the Prometheus text exposition format.
+type Source interface {
+	WriteMetrics(w io.Writer)
+}
+
+// Config struct allows configuring the server.
+type Config struct {
+	Logger      *zap.Logger
+	ErupeConfig *config.Config
+	Sources     []Source
+	Ready       func() bool // Answers /ready, nil is always ready.
+}
+
+// Server is a plain HTTP server exposing the /metrics and /ready endpoints.
+type Server struct {
+	sync.Mutex
+	logger      *zap.Logger
+	erupeConfig *config.Config
+	sources     []Source
+	ready       func() bool
+	httpServer  *http.Server
+}
+
+// NewServer creates a new Server type.
+func NewServer(config *Config) *Server {
+	s := &Server{
+		logger:      config.Logger,
+		erupeConfig: config.ErupeConfig,
+		sources:     config.Sources,
+		ready:       config.Ready,
+		httpServer:  &http.Server{},
+	}
+	return s
+}
+
+// Start starts the server in a new goroutine.
+func (s *Server) Start() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.serveMetrics)
+	mux.HandleFunc("/ready", s.serveReady)
+
+	s.httpServer.Addr = fmt.Sprintf(":%d", s.erupeConfig.Metrics.Port)
+	s.httpServer.Handler = mux
+
+	serveError := make(chan error, 1)
+	go func() {
+		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			// Send error if any.
+			serveError <- err
+		}
+	}()
+
+	// Get the error from calling ListenAndServe, otherwise assume it's good after 250 milliseconds.
+	select {
+	case err := <-serveError:
+		return err
+	case <-time.After(250 * time.Millisecond):
+		return nil
+	}
+}
+
+// Shutdown exits the server gracefully.
+func (s *Server) Shutdown() {
+	s.logger.Debug("Shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		// Just warn because we are shutting down the server anyway.
+		s.logger.Warn("Got error on httpServer shutdown", zap.Error(err))
+	}
+}
+
+// AddSource registers another metrics source.
+func (s *Server) AddSource(source Source) {
+	s.Lock()
+	defer s.Unlock()
+	s.sources = append(s.sources, source)
+}
+
+func (s *Server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	s.Lock()
+	sources := s.sources
+	s.Unlock()
+
+	for _, source := range sources {
+		source.WriteMetrics(w)
+	}
+}
+
+// serveReady answers 503 while the process drains players for a restart, so a load balancer
+// or supervisor stops sending it new ones.
+func (s *Server) serveReady(w http.ResponseWriter, r *http.Request) {
+	if s.ready != nil && !s.ready() {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ready")
+}
@@ -0,0 +1,32 @@
+package metricsserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+type fakeSource string
+
+func (f fakeSource) WriteMetrics(w io.Writer) {
+	fmt.Fprintf(w, "erupe_channel_sessions{channel=%q} 1\n", string(f))
+}
+
+func TestServeMetrics(t *testing.T) {
+	s := NewServer(&Config{Logger: zap.NewNop(), Sources: []Source{fakeSource("one")}})
+	s.AddSource(fakeSource("two"))
+
+	w := httptest.NewRecorder()
+	s.serveMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	want := "erupe_channel_sessions{channel=\"one\"} 1\nerupe_channel_sessions{channel=\"two\"} 1\n"
+	if w.Code != http.StatusOK || w.Body.String() != want {
+		t.Errorf("got status %d and %q, want %q", w.Code, w.Body.String(), want)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "text/plain; version=0.0.4" {
+		t.Errorf("got content type %q", contentType)
+	}
+}
@@ -0,0 +1,267 @@
+package restartserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+	"go.uber.org/zap"
+)
+
+// How often the scheduler checks for warnings and restarts that are due.
+const checkInterval = time.Second
+
+// Errors scheduling or calling off a restart.
+var (
+	ErrNoRestart     = errors.New("no restart is scheduled")
+	ErrDraining      = errors.New("the restart is already draining players")
+	ErrRestartInPast = errors.New("the restart time has passed")
+)
+
+// Broadcaster is anything players can be warned through, such as a channel server.
+type Broadcaster interface {
+	BroadcastChatMessage(message string)
+}
+
+// Config struct allows configuring the server.
+type Config struct {
+	Logger      *zap.Logger
+	ErupeConfig *config.Config
+	Channels    []Broadcaster
+}
+
+// Status is the next restart as listed by the admin API.
+type Status struct {
+	Scheduled bool      `json:"scheduled"`
+	At        time.Time `json:"at,omitempty"`
+	Source    string    `json:"source,omitempty"` // "schedule" for the configured times, "admin" for the admin API.
+	Draining  bool      `json:"draining"`
+}
+
+// Restart sources.
+const (
+	sourceSchedule = "schedule"
+	sourceAdmin    = "admin"
+)
+
+// pendingRestart is the next restart and the warnings already given for it.
+type pendingRestart struct {
+	at     time.Time
+	source string
+	warned map[int]bool
+}
+
+// Server warns players of the next restart, then starts draining the process at its time.
+// Whoever started the server shuts everything down once Drain is closed and exits with the configured code.
+type Server struct {
+	sync.Mutex
+	logger      *zap.Logger
+	erupeConfig *config.Config
+	channels    []Broadcaster
+	now         func() time.Time
+	pending     *pendingRestart
+	skipped     time.Time // Scheduled restart called off, not to be picked again.
+	draining    bool
+	drain       chan struct{}
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+// NewServer creates a new Server type.
+func NewServer(config *Config) *Server {
+	s := &Server{
+		logger:      config.Logger,
+		erupeConfig: config.ErupeConfig,
+		channels:    config.Channels,
+		now:         time.Now,
+		drain:       make(chan struct{}),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	return s
+}
+
+// Start checks the configured times and starts the scheduler in a new goroutine.
+func (s *Server) Start() error {
+	for _, clock := range s.erupeConfig.Restarts.Times {
+		if _, err := time.Parse("15:04", clock); err != nil {
+			return fmt.Errorf("invalid restart time %q: %w", clock, err)
+		}
+	}
+	go s.run()
+	return nil
+}
+
+// Shutdown stops the scheduler.
+func (s *Server) Shutdown() {
+	s.logger.Debug("Shutting down")
+	close(s.stop)
+	<-s.done
+}
+
+func (s *Server) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.check()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Drain is closed when a restart is due. From then on it can't be called off.
+func (s *Server) Drain() <-chan struct{} {
+	return s.drain
+}
+
+// Ready reports whether the process takes players, false once a restart started draining it.
+func (s *Server) Ready() bool {
+	s.Lock()
+	defer s.Unlock()
+	return !s.draining
+}
+
+// Schedule replaces the next restart with one at the given time.
+func (s *Server) Schedule(at time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.draining {
+		return ErrDraining
+	}
+	if !at.After(s.now()) {
+		return ErrRestartInPast
+	}
+	s.pending = &pendingRestart{at: at, source: sourceAdmin, warned: make(map[int]bool)}
+	s.logger.Info("Scheduled restart", zap.Time("at", at))
+	return nil
+}
+
+// Cancel calls off the next restart, telling players if they were warned of it.
+// A configured restart is only skipped for the day, the next one is picked as usual.
+func (s *Server) Cancel() error {
+	s.Lock()
+	if s.draining {
+		s.Unlock()
+		return ErrDraining
+	}
+	pending := s.pending
+	if pending == nil {
+		s.Unlock()
+		return ErrNoRestart
+	}
+	s.pending = nil
+	if pending.source == sourceSchedule {
+		s.skipped = pending.at
+	}
+	s.Unlock()
+
+	s.logger.Info("Called off restart", zap.Time("at", pending.at))
+	if len(pending.warned) > 0 {
+		s.broadcast("The server restart was called off.")
+	}
+	return nil
+}
+
+// RestartStatus returns the next restart, if there is one.
+func (s *Server) RestartStatus() Status {
+	s.Lock()
+	defer s.Unlock()
+	s.pickScheduledLocked()
+	if s.pending == nil {
+		return Status{Draining: s.draining}
+	}
+	return Status{Scheduled: true, At: s.pending.at, Source: s.pending.source, Draining: s.draining}
+}
+
+// WriteMetrics writes the time of the next restart, so the scheduler can be a metrics source.
+func (s *Server) WriteMetrics(w io.Writer) {
+	status := s.RestartStatus()
+	var at int64
+	if status.Scheduled {
+		at = status.At.Unix()
+	}
+	fmt.Fprintf(w, "erupe_restart_scheduled_timestamp_seconds %d\n", at)
+}
+
+// nextScheduled returns the first configured restart after now, other than the skipped one.
+func nextScheduled(times []string, now time.Time, skipped time.Time) (time.Time, bool) {
+	var next time.Time
+	for _, clock := range times {
+		t, err := time.Parse("15:04", clock)
+		if err != nil {
+			continue
+		}
+		at := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+		for !at.After(now) || at.Equal(skipped) {
+			at = at.AddDate(0, 0, 1)
+		}
+		if next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// pickScheduledLocked makes the next configured restart the pending one if there's none.
+// The caller holds the lock.
+func (s *Server) pickScheduledLocked() {
+	if s.pending != nil || s.draining {
+		return
+	}
+	if at, ok := nextScheduled(s.erupeConfig.Restarts.Times, s.now(), s.skipped); ok {
+		s.pending = &pendingRestart{at: at, source: sourceSchedule, warned: make(map[int]bool)}
+	}
+}
+
+// check gives the warnings that are due and starts draining once the restart is.
+// Warnings due at once, such as for a restart scheduled at short notice, make a single
+// one with the minutes actually left.
+func (s *Server) check() {
+	s.Lock()
+	s.pickScheduledLocked()
+	pending := s.pending
+	if pending == nil || s.draining {
+		s.Unlock()
+		return
+	}
+	now := s.now()
+	left := pending.at.Sub(now)
+	if left <= 0 {
+		s.draining = true
+		close(s.drain)
+		s.Unlock()
+		s.logger.Info("Restart is due, draining players", zap.Time("at", pending.at))
+		return
+	}
+
+	warn := false
+	for _, minutes := range s.erupeConfig.Current().Restarts.Warnings {
+		if minutes > 0 && !pending.warned[minutes] && left <= time.Duration(minutes)*time.Minute {
+			pending.warned[minutes] = true
+			warn = true
+		}
+	}
+	s.Unlock()
+
+	if !warn {
+		return
+	}
+	if minutes := int((left + time.Minute - 1) / time.Minute); minutes > 1 {
+		s.broadcast(fmt.Sprintf("The server restarts in %d minutes, your progress will be saved.", minutes))
+	} else {
+		s.broadcast("The server restarts in 1 minute, your progress will be saved.")
+	}
+}
+
+func (s *Server) broadcast(message string) {
+	for _, channel := range s.channels {
+		channel.BroadcastChatMessage(message)
+	}
+}
@@ -0,0 +1,140 @@
+package restartserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/config"
+	"go.uber.org/zap"
+)
+
+type fakeChannel struct {
+	messages []string
+}
+
+func (f *fakeChannel) BroadcastChatMessage(message string) {
+	f.messages = append(f.messages, message)
+}
+
+func newTestServer(now *time.Time, times ...string) (*Server, *fakeChannel) {
+	channel := &fakeChannel{}
+	s := NewServer(&Config{
+		Logger:      zap.NewNop(),
+		ErupeConfig: &config.Config{Restarts: config.Restarts{Times: times, Warnings: []int{30, 15, 5, 1}}},
+		Channels:    []Broadcaster{channel},
+	})
+	s.now = func() time.Time { return *now }
+	return s, channel
+}
+
+func draining(s *Server) bool {
+	select {
+	case <-s.Drain():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestNextScheduled(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	at, ok := nextScheduled([]string{"04:00", "13:30"}, now, time.Time{})
+	if !ok || !at.Equal(time.Date(2022, 6, 1, 13, 30, 0, 0, time.UTC)) {
+		t.Errorf("got %v, want today at 13:30", at)
+	}
+	at, _ = nextScheduled([]string{"04:00", "13:30"}, now, time.Date(2022, 6, 1, 13, 30, 0, 0, time.UTC))
+	if !at.Equal(time.Date(2022, 6, 2, 4, 0, 0, 0, time.UTC)) {
+		t.Errorf("got %v with today's restart skipped, want tomorrow at 04:00", at)
+	}
+	if _, ok := nextScheduled(nil, now, time.Time{}); ok {
+		t.Error("got a restart without any times")
+	}
+}
+
+func TestScheduledRestartWarnsThenDrains(t *testing.T) {
+	now := time.Date(2022, 6, 1, 3, 0, 0, 0, time.UTC)
+	s, channel := newTestServer(&now, "04:00")
+
+	s.check()
+	if len(channel.messages) != 0 {
+		t.Fatalf("warned an hour ahead: %v", channel.messages)
+	}
+	now = now.Add(30 * time.Minute)
+	s.check()
+	s.check()
+	now = now.Add(25 * time.Minute)
+	s.check()
+	want := []string{
+		"The server restarts in 30 minutes, your progress will be saved.",
+		"The server restarts in 5 minutes, your progress will be saved.",
+	}
+	if len(channel.messages) != len(want) || channel.messages[0] != want[0] || channel.messages[1] != want[1] {
+		t.Errorf("got warnings %q, want %q", channel.messages, want)
+	}
+	if !s.Ready() || draining(s) {
+		t.Fatal("started draining before the restart")
+	}
+
+	now = now.Add(5 * time.Minute)
+	s.check()
+	if s.Ready() || !draining(s) {
+		t.Fatal("didn't start draining at the restart")
+	}
+	if err := s.Cancel(); err != ErrDraining {
+		t.Errorf("got %v calling off a draining restart, want %v", err, ErrDraining)
+	}
+	if err := s.Schedule(now.Add(time.Hour)); err != ErrDraining {
+		t.Errorf("got %v scheduling while draining, want %v", err, ErrDraining)
+	}
+}
+
+func TestCancelRestart(t *testing.T) {
+	now := time.Date(2022, 6, 1, 3, 50, 0, 0, time.UTC)
+	s, channel := newTestServer(&now, "04:00")
+
+	// At short notice the warnings due together make one with the minutes actually left.
+	s.check()
+	if len(channel.messages) != 1 || channel.messages[0] != "The server restarts in 10 minutes, your progress will be saved." {
+		t.Fatalf("got warnings %q", channel.messages)
+	}
+	if err := s.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	if len(channel.messages) != 2 || channel.messages[1] != "The server restart was called off." {
+		t.Errorf("got messages %q", channel.messages)
+	}
+
+	now = now.Add(11 * time.Minute)
+	s.check()
+	if draining(s) {
+		t.Fatal("called off restart drained players")
+	}
+	if status := s.RestartStatus(); !status.Scheduled || !status.At.Equal(time.Date(2022, 6, 2, 4, 0, 0, 0, time.UTC)) {
+		t.Errorf("got %+v, want tomorrow's restart next", status)
+	}
+}
+
+func TestScheduleRestart(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	s, channel := newTestServer(&now)
+	if err := s.Cancel(); err != ErrNoRestart {
+		t.Errorf("got %v with nothing scheduled, want %v", err, ErrNoRestart)
+	}
+	if err := s.Schedule(now); err != ErrRestartInPast {
+		t.Errorf("got %v scheduling now, want %v", err, ErrRestartInPast)
+	}
+	if err := s.Schedule(now.Add(2 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if status := s.RestartStatus(); status.Source != sourceAdmin {
+		t.Errorf("got %+v", status)
+	}
+
+	// Cancelled before any warning, players are told nothing.
+	if err := s.Cancel(); err != nil || len(channel.messages) != 0 {
+		t.Errorf("got %v and messages %q", err, channel.messages)
+	}
+	if status := s.RestartStatus(); status.Scheduled {
+		t.Errorf("got %+v after calling off the only restart", status)
+	}
+}
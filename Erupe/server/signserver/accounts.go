@@ -0,0 +1,66 @@
+package signserver
+
+import (
+	"github.com/Solenataris/Erupe/config"
+	"github.com/jmoiron/sqlx"
+)
+
+// CreateAccount adds an account without any character, hashing its password the configured way, and
+// returns its ID. The account's first sign in with a "+" after the username creates its character.
+func CreateAccount(db *sqlx.DB, erupeConfig *config.Config, username string, password string) (uint32, error) {
+	hasher := passwordHasher{erupeConfig.Sign.PasswordHash, erupeConfig.Sign.BcryptCost}
+	hash, version, err := hasher.hash(password)
+	if err != nil {
+		return 0, err
+	}
+	var id uint32
+	err = db.QueryRow("INSERT INTO users (username, password, password_version) VALUES ($1, $2, $3) RETURNING id", username, hash, version).Scan(&id)
+	return id, err
+}
+
+// DeleteAccount removes an account for good along with its characters, sign in tokens and login history.
+func DeleteAccount(db *sqlx.DB, userID uint32) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var charIDs []uint32
+	rows, err := tx.Query("SELECT id FROM characters WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var charID uint32
+		if err := rows.Scan(&charID); err != nil {
+			rows.Close()
+			return err
+		}
+		charIDs = append(charIDs, charID)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	for _, charID := range charIDs {
+		for _, query := range characterReferences {
+			if _, err := tx.Exec(query, charID); err != nil {
+				return err
+			}
+		}
+	}
+	for _, query := range []string{
+		"DELETE FROM characters WHERE user_id = $1",
+		"DELETE FROM sign_sessions WHERE user_id = $1",
+		"DELETE FROM login_history WHERE user_id = $1",
+		"DELETE FROM login_fingerprints WHERE user_id = $1",
+		"DELETE FROM login_addresses WHERE user_id = $1",
+		"DELETE FROM users WHERE id = $1",
+	} {
+		if _, err := tx.Exec(query, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
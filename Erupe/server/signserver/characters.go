@@ -0,0 +1,181 @@
+package signserver
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// Tables holding rows that reference a character, cleared before the character itself is purged.
+var characterReferences = []string{
+	"DELETE FROM guild_characters WHERE character_id = $1",
+	"DELETE FROM guild_applications WHERE character_id = $1 OR actor_id = $1",
+	"DELETE FROM mail WHERE sender_id = $1 OR recipient_id = $1",
+	"DELETE FROM lucky_box_state WHERE char_id = $1",
+	"DELETE FROM stepup_state WHERE char_id = $1",
+	"DELETE FROM shop_item_state WHERE char_id = $1",
+	"DELETE FROM login_boost_state WHERE char_id = $1",
+}
+
+// deleteCharacter deletes a character of the account the sign in token was issued to, if the token's number
+// and string match a session of that account, as at channel login, and the token hasn't expired. Characters that were never played are removed outright, others are only marked deleted so they can
+// be restored until they're purged. Either way the character leaves its guild and its unread mail is dropped.
+func (s *Server) deleteCharacter(charID uint32, tokenID uint32, token string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	var isNew bool
+	err = tx.QueryRow(`
+		SELECT is_new_character FROM characters WHERE id = $1 AND deleted_at IS NULL
+		AND EXISTS (
+			SELECT 1 FROM sign_sessions WHERE id = $2 AND user_id = characters.user_id AND auth_token_str = $3
+			AND ($4::float8 = 0 OR issued_at > now() - $4::float8 * interval '1 second')
+		)
+		FOR UPDATE
+	`, charID, tokenID, token, s.tokenTTL().Seconds()).Scan(&isNew)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := leaveGuild(tx, charID); err != nil {
+		return false, err
+	}
+	if isNew {
+		for _, query := range characterReferences {
+			if _, err := tx.Exec(query, charID); err != nil {
+				return false, err
+			}
+		}
+		_, err = tx.Exec("DELETE FROM characters WHERE id = $1", charID)
+	} else {
+		// Mail the character sent stays readable by its recipients until the character is purged.
+		if _, err := tx.Exec("DELETE FROM mail WHERE recipient_id = $1 AND NOT read", charID); err != nil {
+			return false, err
+		}
+		_, err = tx.Exec("UPDATE characters SET deleted_at = now() WHERE id = $1", charID)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// leaveGuild removes the character from its guild and its applications. A guild it leads is handed to the
+// member who joined first, preferring those who didn't ask to avoid leadership, and disbanded if it has no
+// other member.
+func leaveGuild(tx *sql.Tx, charID uint32) error {
+	var guildID uint32
+	err := tx.QueryRow("SELECT id FROM guilds WHERE leader_id = $1 FOR UPDATE", charID).Scan(&guildID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == nil {
+		var successor uint32
+		err = tx.QueryRow(`
+			SELECT character_id FROM guild_characters WHERE guild_id = $1 AND character_id != $2
+			ORDER BY avoid_leadership, joined_at, id LIMIT 1
+		`, guildID, charID).Scan(&successor)
+		if err == sql.ErrNoRows {
+			for _, query := range []string{
+				"DELETE FROM guild_characters WHERE guild_id = $1",
+				"DELETE FROM guild_applications WHERE guild_id = $1",
+				"DELETE FROM guilds WHERE id = $1",
+			} {
+				if _, err := tx.Exec(query, guildID); err != nil {
+					return err
+				}
+			}
+		} else if err != nil {
+			return err
+		} else if _, err := tx.Exec("UPDATE guilds SET leader_id = $1 WHERE id = $2", successor, guildID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM guild_characters WHERE character_id = $1", charID); err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM guild_applications WHERE character_id = $1 OR actor_id = $1", charID)
+	return err
+}
+
+// RestoreCharacter undeletes a character deleted less than retention ago.
+// It returns false if the character isn't deleted or is past the recovery window.
+func RestoreCharacter(db *sqlx.DB, charID uint32, retention time.Duration) (bool, error) {
+	res, err := db.Exec("UPDATE characters SET deleted_at = NULL WHERE id = $1 AND deleted_at > $2", charID, time.Now().Add(-retention))
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	return rows == 1, err
+}
+
+// characterRetention is how long a deleted character can be restored.
+func (s *Server) characterRetention() time.Duration {
+	return time.Duration(s.erupeConfig.Sign.DeletedCharacterRetention) * 24 * time.Hour
+}
+
+// scheduleCharacterPurge purges the characters past the recovery window every hour.
+func (s *Server) scheduleCharacterPurge() {
+	s.Lock()
+	defer s.Unlock()
+	if s.isShuttingDown {
+		return
+	}
+	s.characterPurgeTimer = time.AfterFunc(time.Hour, func() {
+		s.purgeDeletedCharacters()
+		s.scheduleCharacterPurge()
+	})
+}
+
+// stopCharacterPurge cancels the pending character purge.
+func (s *Server) stopCharacterPurge() {
+	s.Lock()
+	defer s.Unlock()
+	if s.characterPurgeTimer != nil {
+		s.characterPurgeTimer.Stop()
+	}
+}
+
+// purgeDeletedCharacters permanently removes the characters deleted longer than the retention ago.
+// Guild leaders deleted before leadership was handed over at deletion are kept until the guild has a new leader.
+func (s *Server) purgeDeletedCharacters() {
+	var charIDs []uint32
+	err := s.db.Select(&charIDs, `
+		SELECT id FROM characters WHERE deleted_at <= $1
+		AND NOT EXISTS (SELECT 1 FROM guilds WHERE leader_id = characters.id)
+	`, time.Now().Add(-s.characterRetention()))
+	if err != nil {
+		s.logger.Error("Failed to get characters to purge", zap.Error(err))
+		return
+	}
+	for _, charID := range charIDs {
+		if err := s.purgeCharacter(charID); err != nil {
+			s.logger.Error("Failed to purge deleted character", zap.Error(err), zap.Uint32("charID", charID))
+			continue
+		}
+		s.logger.Info("Purged deleted character", zap.Uint32("charID", charID))
+	}
+}
+
+func (s *Server) purgeCharacter(charID uint32) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, query := range characterReferences {
+		if _, err := tx.Exec(query, charID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM characters WHERE id = $1 AND deleted_at IS NOT NULL", charID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,152 @@
+package signserver
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/common/testdb"
+	"github.com/Solenataris/Erupe/config"
+	"go.uber.org/zap"
+)
+
+// TestSoftDeleteCharacter runs against the database in ERUPE_TEST_DB, e.g.
+// "host=localhost user=postgres password=admin dbname=erupe_test sslmode=disable".
+func TestSoftDeleteCharacter(t *testing.T) {
+	db := testdb.Open(t)
+
+	var userID int
+	var charID uint32
+	err := db.QueryRow("INSERT INTO users (username, password) VALUES ('delete_test', '') RETURNING id").Scan(&userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+	savedata := []byte{0xDE, 0xAD, 0xBE, 0xEF}
+	err = db.QueryRow("INSERT INTO characters (user_id, is_new_character, name, savedata) VALUES ($1, false, 'deleted', $2) RETURNING id", userID, savedata).Scan(&charID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM characters WHERE id = $1", charID)
+	defer db.Exec("DELETE FROM sign_sessions WHERE user_id = $1", userID)
+
+	s := NewServer(&Config{Logger: zap.NewNop(), DB: db, ErupeConfig: &config.Config{Sign: config.Sign{DeletedCharacterRetention: 30}}})
+	tokenID, err := s.registerSignToken(userID, "deletetokendelet", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted, err := s.deleteCharacter(charID, tokenID, "wrongtokenwrongt"); err != nil || deleted {
+		t.Fatalf("delete with another account's token got (%v, %v)", deleted, err)
+	}
+	if deleted, err := s.deleteCharacter(charID, tokenID+1, "deletetokendelet"); err != nil || deleted {
+		t.Fatalf("delete with the token under another number got (%v, %v)", deleted, err)
+	}
+	if deleted, err := s.deleteCharacter(charID, tokenID, "deletetokendelet"); err != nil || !deleted {
+		t.Fatalf("delete got (%v, %v), want (true, nil)", deleted, err)
+	}
+
+	chars, err := s.getCharactersForUser(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chars) != 0 {
+		t.Fatalf("deleted character is still listed at login: %+v", chars)
+	}
+
+	if restored, err := RestoreCharacter(db, charID, s.characterRetention()); err != nil || !restored {
+		t.Fatalf("restore got (%v, %v), want (true, nil)", restored, err)
+	}
+	if chars, _ := s.getCharactersForUser(userID); len(chars) != 1 || chars[0].ID != charID {
+		t.Fatalf("restored character isn't listed: %+v", chars)
+	}
+	var got []byte
+	if err := db.QueryRow("SELECT savedata FROM characters WHERE id = $1", charID).Scan(&got); err != nil || !bytes.Equal(got, savedata) {
+		t.Errorf("got savedata %x after restoring, want %x", got, savedata)
+	}
+
+	// Past the recovery window the character can't be restored and is purged.
+	db.Exec("UPDATE characters SET deleted_at = $1 WHERE id = $2", time.Now().AddDate(0, 0, -31), charID)
+	if restored, err := RestoreCharacter(db, charID, s.characterRetention()); err != nil || restored {
+		t.Errorf("restore past the window got (%v, %v), want (false, nil)", restored, err)
+	}
+	s.purgeDeletedCharacters()
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM characters WHERE id = $1", charID).Scan(&count)
+	if count != 0 {
+		t.Error("character past the window wasn't purged")
+	}
+}
+
+// TestDeleteGuildLeader runs against the database in ERUPE_TEST_DB, like TestSoftDeleteCharacter.
+func TestDeleteGuildLeader(t *testing.T) {
+	db := testdb.Open(t)
+
+	var userID int
+	err := db.QueryRow("INSERT INTO users (username, password) VALUES ('delete_leader_test', '') RETURNING id").Scan(&userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+	defer db.Exec("DELETE FROM sign_sessions WHERE user_id = $1", userID)
+	var chars [3]uint32
+	for i := range chars {
+		err = db.QueryRow("INSERT INTO characters (user_id, is_new_character, name) VALUES ($1, false, 'leader') RETURNING id", userID).Scan(&chars[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Exec("DELETE FROM characters WHERE id = $1", chars[i])
+	}
+	var guildID uint32
+	if err := db.QueryRow("INSERT INTO guilds (name, leader_id) VALUES ('DeleteTest', $1) RETURNING id", chars[0]).Scan(&guildID); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM guilds WHERE id = $1", guildID)
+	defer db.Exec("DELETE FROM guild_characters WHERE guild_id = $1", guildID)
+	// The second member asked to avoid leadership, the third was the first to join after the leader.
+	for i, avoid := range []bool{false, true, false} {
+		_, err := db.Exec("INSERT INTO guild_characters (guild_id, character_id, avoid_leadership, joined_at) VALUES ($1, $2, $3, now() - $4 * interval '1 day')", guildID, chars[i], avoid, 10-i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Exec("INSERT INTO mail (sender_id, recipient_id) VALUES ($1, $2)", chars[1], chars[0])
+	defer db.Exec("DELETE FROM mail WHERE sender_id = ANY(ARRAY[$1, $2, $3]::int[])", chars[0], chars[1], chars[2])
+
+	s := NewServer(&Config{Logger: zap.NewNop(), DB: db, ErupeConfig: &config.Config{Sign: config.Sign{DeletedCharacterRetention: 30, TokenTTL: 60}}})
+	tokenID, err := s.registerSignToken(userID, "leadertokenleade", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Exec("UPDATE sign_sessions SET issued_at = now() - interval '1 hour' WHERE user_id = $1", userID)
+	if deleted, err := s.deleteCharacter(chars[0], tokenID, "leadertokenleade"); err != nil || deleted {
+		t.Fatalf("delete with an expired token got (%v, %v)", deleted, err)
+	}
+	db.Exec("UPDATE sign_sessions SET issued_at = now() WHERE user_id = $1", userID)
+	if deleted, err := s.deleteCharacter(chars[0], tokenID, "leadertokenleade"); err != nil || !deleted {
+		t.Fatalf("delete got (%v, %v), want (true, nil)", deleted, err)
+	}
+
+	var leader uint32
+	db.QueryRow("SELECT leader_id FROM guilds WHERE id = $1", guildID).Scan(&leader)
+	if leader != chars[2] {
+		t.Errorf("leadership went to %d, want %d", leader, chars[2])
+	}
+	var members, mail int
+	db.QueryRow("SELECT COUNT(*) FROM guild_characters WHERE character_id = $1", chars[0]).Scan(&members)
+	db.QueryRow("SELECT COUNT(*) FROM mail WHERE recipient_id = $1", chars[0]).Scan(&mail)
+	if members != 0 || mail != 0 {
+		t.Errorf("the deleted character kept %d guild rows and %d unread mail", members, mail)
+	}
+
+	// The last members leaving disband the guild.
+	for _, charID := range chars[1:] {
+		if deleted, err := s.deleteCharacter(charID, tokenID, "leadertokenleade"); err != nil || !deleted {
+			t.Fatalf("delete got (%v, %v), want (true, nil)", deleted, err)
+		}
+	}
+	var guilds int
+	db.QueryRow("SELECT COUNT(*) FROM guilds WHERE id = $1", guildID).Scan(&guilds)
+	if guilds != 0 {
+		t.Error("the guild of deleted characters wasn't disbanded")
+	}
+}
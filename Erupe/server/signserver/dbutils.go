@@ -1,11 +1,32 @@
 package signserver
 
 import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/Solenataris/Erupe/server/invite"
+	"go.uber.org/zap"
 )
 
+var errNoCharacterSlot = errors.New("account has no free character slot")
+
+// characterSlots returns how many characters the account has and how many it can have.
+func (s *Server) characterSlots(uid int) (int, int, error) {
+	var used, extra int
+	err := s.db.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM characters WHERE user_id = $1 AND deleted_at IS NULL), extra_slots
+		FROM users WHERE id = $1
+	`, uid).Scan(&used, &extra)
+	if err != nil {
+		return 0, 0, err
+	}
+	return used, s.erupeConfig.CharacterSlots.Limit(extra), nil
+}
+
 func (s *Server) newUserChara(username string) error {
 	var id int
 	err := s.db.QueryRow("SELECT id FROM users WHERE username = $1", username).Scan(&id)
@@ -13,8 +34,16 @@ func (s *Server) newUserChara(username string) error {
 		return err
 	}
 
+	used, limit, err := s.characterSlots(id)
+	if err != nil {
+		return err
+	}
+	if used >= limit {
+		return errNoCharacterSlot
+	}
+
 	var numNewChars int
-	err = s.db.QueryRow("SELECT COUNT(*) FROM characters WHERE user_id = $1 AND is_new_character = true", id).Scan(&numNewChars)
+	err = s.db.QueryRow("SELECT COUNT(*) FROM characters WHERE user_id = $1 AND is_new_character = true AND deleted_at IS NULL", id).Scan(&numNewChars)
 	if err != nil {
 		return err
 	}
@@ -39,26 +68,35 @@ func (s *Server) newUserChara(username string) error {
 	return nil
 }
 
-func (s *Server) registerDBAccount(username string, password string) error {
+// registerDBAccount creates the account with a base new character. When invite codes are required
+// the code's use is taken in the same transaction, so a failed registration doesn't spend it.
+func (s *Server) registerDBAccount(username string, password string, inviteCode string) error {
 	// Create salted hash of user password
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	passwordHash, version, err := s.passwordHasher().hash(password)
 	if err != nil {
 		return err
 	}
 
-	_, err = s.db.Exec("INSERT INTO users (username, password) VALUES ($1, $2)", username, string(passwordHash))
+	tx, err := s.db.Beginx()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+
+	if s.erupeConfig.Sign.RequireInviteCode {
+		if err = invite.Redeem(tx, inviteCode); err != nil {
+			return err
+		}
+	}
 
 	var id int
-	err = s.db.QueryRow("SELECT id FROM users WHERE username = $1", username).Scan(&id)
+	err = tx.QueryRow("INSERT INTO users (username, password, password_version) VALUES ($1, $2, $3) RETURNING id", username, passwordHash, version).Scan(&id)
 	if err != nil {
 		return err
 	}
 
 	// Create a base new character.
-	_, err = s.db.Exec(`
+	_, err = tx.Exec(`
 		INSERT INTO characters (
 			user_id, is_female, is_new_character, name, unk_desc_string,
 			hrp, gr, weapon_type, last_login)
@@ -70,7 +108,7 @@ func (s *Server) registerDBAccount(username string, password string) error {
 		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
 type character struct {
@@ -87,9 +125,70 @@ type character struct {
 
 func (s *Server) getCharactersForUser(uid int) ([]character, error) {
 	characters := []character{}
-	err := s.db.Select(&characters, "SELECT id, is_female, is_new_character, name, unk_desc_string, hrp, gr, weapon_type, last_login FROM characters WHERE user_id = $1", uid)
+	err := s.db.Select(&characters, "SELECT id, is_female, is_new_character, name, unk_desc_string, hrp, gr, weapon_type, last_login FROM characters WHERE user_id = $1 AND deleted_at IS NULL", uid)
 	if err != nil {
 		return nil, err
 	}
 	return characters, nil
-}
\ No newline at end of file
+}
+
+// ban is a ban stored against an account, see the bans table.
+type ban struct {
+	Reason    string       `db:"reason"`
+	ExpiresAt sql.NullTime `db:"expires_at"`
+}
+
+// banResp returns the sign in response for the account's bans: SIGN_EELIMINATE if one never expires,
+// SIGN_ESUSPEND if one hasn't expired yet, SIGN_SUCCESS if they have all lapsed.
+func banResp(bans []ban, now time.Time) RespID {
+	resp := SIGN_SUCCESS
+	for _, b := range bans {
+		if !b.ExpiresAt.Valid {
+			return SIGN_EELIMINATE
+		}
+		if now.Before(b.ExpiresAt.Time) {
+			resp = SIGN_ESUSPEND
+		}
+	}
+	return resp
+}
+
+// getBan returns the sign in response for the account's bans. Bans on a single character are
+// enforced by the channel server instead, so other characters on the account can still be played.
+func (s *Server) getBan(uid int) (RespID, error) {
+	var bans []ban
+	err := s.db.Select(&bans, "SELECT reason, expires_at FROM bans WHERE user_id = $1 AND character_id IS NULL AND (expires_at IS NULL OR expires_at > now())", uid)
+	if err != nil {
+		return SIGN_EABORT, err
+	}
+	return banResp(bans, time.Now()), nil
+}
+
+// loginFingerprint hashes the sign in request type, which tells the launcher signing in with a key
+// from the plain client, together with the network of the address: its /24 for IPv4 and /64 for IPv6.
+// It links accounts signing in the same way from a network whose address keeps changing.
+func loginFingerprint(reqType string, ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return ""
+	}
+	network := addr.Mask(net.CIDRMask(64, 128))
+	if v4 := addr.To4(); v4 != nil {
+		network = v4.Mask(net.CIDRMask(24, 32))
+	}
+	sum := sha256.Sum256([]byte(reqType + "|" + network.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// recordLogin keeps the address and fingerprint of the sign in, which the admin API uses to flag
+// possible duplicate accounts. It's advisory only and never fails the login.
+func (s *Session) recordLogin(uid int, reqType string) {
+	ip, _, err := net.SplitHostPort((*s.rawConn).RemoteAddr().String())
+	if err != nil {
+		ip = (*s.rawConn).RemoteAddr().String()
+	}
+	_, err = s.server.db.Exec("INSERT INTO login_history (user_id, ip, fingerprint) VALUES ($1, $2, $3)", uid, ip, loginFingerprint(reqType, ip))
+	if err != nil {
+		s.logger.Warn("Failed to record login", zap.Error(err), zap.Int("uid", uid))
+	}
+}
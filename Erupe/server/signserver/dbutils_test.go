@@ -0,0 +1,115 @@
+package signserver
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/Solenataris/Erupe/common/testdb"
+	"github.com/Solenataris/Erupe/config"
+	"go.uber.org/zap"
+)
+
+func TestBanResp(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	expired := ban{Reason: "spam", ExpiresAt: sql.NullTime{Time: now.Add(-time.Hour), Valid: true}}
+	active := ban{Reason: "botting", ExpiresAt: sql.NullTime{Time: now.Add(time.Hour), Valid: true}}
+	permanent := ban{Reason: "cheating"}
+
+	tests := []struct {
+		name string
+		bans []ban
+		want RespID
+	}{
+		{"no bans", nil, SIGN_SUCCESS},
+		{"expired", []ban{expired}, SIGN_SUCCESS},
+		{"active", []ban{active}, SIGN_ESUSPEND},
+		{"expired and active", []ban{expired, active}, SIGN_ESUSPEND},
+		{"permanent", []ban{permanent}, SIGN_EELIMINATE},
+		{"active and permanent", []ban{active, permanent}, SIGN_EELIMINATE},
+	}
+	for _, tt := range tests {
+		if got := banResp(tt.bans, now); got != tt.want {
+			t.Errorf("%s: got response %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBanLapsesAtExpiry(t *testing.T) {
+	expiresAt := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	bans := []ban{{ExpiresAt: sql.NullTime{Time: expiresAt, Valid: true}}}
+	if got := banResp(bans, expiresAt.Add(-time.Second)); got != SIGN_ESUSPEND {
+		t.Errorf("got response %d just before expiry, want %d", got, SIGN_ESUSPEND)
+	}
+	if got := banResp(bans, expiresAt); got != SIGN_SUCCESS {
+		t.Errorf("got response %d at expiry, want %d", got, SIGN_SUCCESS)
+	}
+}
+
+func TestCharacterSlotLimit(t *testing.T) {
+	slots := config.CharacterSlots{Base: 8, Max: 10}
+	for extra, want := range map[int]int{0: 8, 1: 9, 2: 10, 5: 10} {
+		if got := slots.Limit(extra); got != want {
+			t.Errorf("%d extra slots: got limit %d, want %d", extra, got, want)
+		}
+	}
+	// A Max below Base doesn't cap the limit.
+	if got := (config.CharacterSlots{Base: 8}).Limit(1); got != 9 {
+		t.Errorf("no max: got limit %d, want 9", got)
+	}
+}
+
+// TestNewCharacterNeedsSlot runs against the database in ERUPE_TEST_DB, e.g.
+// "host=localhost user=postgres password=admin dbname=erupe_test sslmode=disable".
+func TestNewCharacterNeedsSlot(t *testing.T) {
+	db := testdb.Open(t)
+
+	var userID int
+	err := db.QueryRow("INSERT INTO users (username, password) VALUES ('slot_test', '') RETURNING id").Scan(&userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+	defer db.Exec("DELETE FROM characters WHERE user_id = $1", userID)
+	_, err = db.Exec("INSERT INTO characters (user_id, is_new_character, name) VALUES ($1, false, 'first')", userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(&Config{Logger: zap.NewNop(), DB: db, ErupeConfig: &config.Config{CharacterSlots: config.CharacterSlots{Base: 1, Max: 2}}})
+	if used, limit, err := s.characterSlots(userID); err != nil || used != 1 || limit != 1 {
+		t.Fatalf("got slots (%d, %d, %v), want (1, 1, nil)", used, limit, err)
+	}
+	if err := s.newUserChara("slot_test"); err != errNoCharacterSlot {
+		t.Fatalf("creating past the limit got %v, want errNoCharacterSlot", err)
+	}
+
+	if _, err := db.Exec("UPDATE users SET extra_slots = 1 WHERE id = $1", userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.newUserChara("slot_test"); err != nil {
+		t.Fatalf("creating with a bought slot got %v", err)
+	}
+	if used, limit, err := s.characterSlots(userID); err != nil || used != 2 || limit != 2 {
+		t.Fatalf("got slots (%d, %d, %v), want (2, 2, nil)", used, limit, err)
+	}
+}
+
+func TestLoginFingerprint(t *testing.T) {
+	home := loginFingerprint("DSGN:100", "203.0.113.7")
+	if home == "" || loginFingerprint("DSGN:100", "203.0.113.250") != home {
+		t.Error("addresses of the same /24 got different fingerprints")
+	}
+	if loginFingerprint("DSGN:100", "203.0.114.7") == home {
+		t.Error("another network got the same fingerprint")
+	}
+	if loginFingerprint("DLTSKEYSIGN:100", "203.0.113.7") == home {
+		t.Error("another sign in type got the same fingerprint")
+	}
+	if loginFingerprint("DSGN:100", "2001:db8:1:2::1") != loginFingerprint("DSGN:100", "2001:db8:1:2:ffff::9") {
+		t.Error("addresses of the same /64 got different fingerprints")
+	}
+	if loginFingerprint("DSGN:100", "not an address") != "" {
+		t.Error("an unparsable address got a fingerprint")
+	}
+}
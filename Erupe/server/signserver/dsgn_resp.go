@@ -1,11 +1,14 @@
 package signserver
 
 import (
+	"crypto/rand"
 	"fmt"
-	"math/rand"
+	"math/big"
 	"time"
 
 	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/common/accountgrant"
+	"github.com/Solenataris/Erupe/common/tracing"
 	"go.uber.org/zap"
 	"golang.org/x/text/encoding/japanese"
 	"golang.org/x/text/transform"
@@ -36,36 +39,108 @@ func makeSignInFailureResp(respID RespID) []byte {
 	return bf.Data()
 }
 
-func randSeq(n int) string {
-	var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
-	b := make([]rune, n)
+// randSeq returns n random letters from crypto/rand, as sign in tokens are what a channel login is
+// authenticated by.
+func randSeq(n int) (string, error) {
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	max := big.NewInt(int64(len(letters)))
+	b := make([]byte, n)
 	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
+		c, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = letters[c.Int64()]
+	}
+	return string(b), nil
+}
+
+// makeNotices builds the notices shown on the character select screen.
+func (s *Session) makeNotices(uid int) []string {
+	var notices []string
+
+	newcomer := s.server.erupeConfig.Current().Newcomer
+	if newcomer.Enabled {
+		expiry, err := accountgrant.NewcomerBoostExpiry(s.server.db, newcomer, uint32(uid))
+		if err != nil {
+			s.logger.Warn("Error getting newcomer boost status", zap.Error(err))
+		} else if time.Now().Before(expiry) {
+			notice := fmt.Sprintf("%s Boosts expire on %s.", newcomer.Notice, expiry.Format("2006-01-02"))
+			notices = append(notices, shiftJISNotice(notice))
+		}
+	}
+
+	return notices
+}
+
+// shiftJISNotice converts a notice to Shift-JIS, falling back to the raw string.
+func shiftJISNotice(notice string) string {
+	str, _, err := transform.String(japanese.ShiftJIS.NewEncoder(), notice)
+	if err != nil {
+		return notice
 	}
-	return string(b)
+	return str
 }
 
-func (s *Session) makeSignInResp(uid int) []byte {
+// makeSignInResp issues a sign in token for the account and builds the response listing its characters.
+// The token carries the sign in's span, so the channel login the client makes with it continues the trace.
+func (s *Session) makeSignInResp(uid int, span *tracing.Span) []byte {
 	// Get the characters from the DB.
+	query := span.Child("db: load characters")
 	chars, err := s.server.getCharactersForUser(uid)
 	if err != nil {
+		query.SetError(err)
 		s.logger.Warn("Error getting characters from DB", zap.Error(err))
 	}
+	query.End()
 
-	rand.Seed(time.Now().UnixNano())
-	token := randSeq(16)
-	// TODO: register token to db, users table
+	token, err := randSeq(16)
+	if err != nil {
+		s.logger.Error("Failed to generate sign in token", zap.Error(err))
+		return makeSignInFailureResp(SIGN_EABORT)
+	}
+	query = span.Child("db: register sign token")
+	tokenID, err := s.server.registerSignToken(uid, token, span.TraceParent())
+	query.SetError(err)
+	query.End()
+	if err != nil {
+		s.logger.Error("Failed to register sign in token", zap.Error(err))
+		return makeSignInFailureResp(SIGN_EABORT)
+	}
 
+	return buildSignInResp(signInResp{
+		tokenID:       tokenID,
+		token:         token,
+		entrance:      fmt.Sprintf("%s:%d", s.server.erupeConfig.HostIP, s.server.erupeConfig.Entrance.Port),
+		characters:    chars,
+		notices:       s.makeNotices(uid),
+		maxLauncherHR: s.server.erupeConfig.DevMode && s.server.erupeConfig.DevModeOptions.MaxLauncherHR,
+	})
+}
+
+// signInResp is what a successful sign in response is built from, gathered by makeSignInResp.
+type signInResp struct {
+	tokenID       uint32
+	token         string
+	entrance      string // Address of the entrance server, "host:port".
+	characters    []character
+	notices       []string // Already in Shift-JIS.
+	maxLauncherHR bool
+}
+
+// buildSignInResp serializes a successful sign in response.
+func buildSignInResp(resp signInResp) []byte {
+	chars := resp.characters
 	bf := byteframe.NewByteFrame()
 
-	bf.WriteUint8(1)                       // resp_code
-	bf.WriteUint8(0)                       // file/patch server count
-	bf.WriteUint8(4)                       // entrance server count
-	bf.WriteUint8(uint8(len(chars)))       // character count
-	bf.WriteUint32(0xFFFFFFFF)             // login_token_number
-	bf.WriteBytes(paddedString(token, 16)) // login_token (16 byte padded string)
+	bf.WriteUint8(1)                            // resp_code
+	bf.WriteUint8(0)                            // file/patch server count
+	bf.WriteUint8(4)                            // entrance server count
+	bf.WriteUint8(uint8(len(chars)))            // character count
+	bf.WriteUint32(resp.tokenID)                // login_token_number
+	bf.WriteBytes(paddedString(resp.token, 16)) // login_token (16 byte padded string)
 	bf.WriteUint32(1576761190)
-	uint8PascalString(bf, fmt.Sprintf("%s:%d", s.server.erupeConfig.HostIP, s.server.erupeConfig.Entrance.Port))
+	uint8PascalString(bf, resp.entrance)
 	uint8PascalString(bf, "")
 	uint8PascalString(bf, "")
 	uint8PascalString(bf, "mhf-n.capcom.com.tw")
@@ -74,7 +149,7 @@ func (s *Session) makeSignInResp(uid int) []byte {
 		bf.WriteUint32(char.ID) // character ID 469153291
 
 		// Exp, HR[x] is split by 0, 1, 30, 50, 99, 299, 998, 999
-		if s.server.erupeConfig.DevMode && s.server.erupeConfig.DevModeOptions.MaxLauncherHR {
+		if resp.maxLauncherHR {
 			bf.WriteUint16(999)
 		} else {
 			bf.WriteUint16(char.HRP)
@@ -98,9 +173,12 @@ func (s *Session) makeSignInResp(uid int) []byte {
 		bf.WriteUint16(0) // Unk
 	}
 
-	bf.WriteUint8(0)           // friends_list_count
-	bf.WriteUint8(0)           // guild_members_count
-	bf.WriteUint8(0)           // notice_count
+	bf.WriteUint8(0)                        // friends_list_count
+	bf.WriteUint8(0)                        // guild_members_count
+	bf.WriteUint8(uint8(len(resp.notices))) // notice_count
+	for _, notice := range resp.notices {
+		uint16PascalString(bf, notice)
+	}
 	bf.WriteUint32(0xDEADBEEF) // some_last_played_character_id
 	bf.WriteUint32(14)         // unk_flags
 	uint8PascalString(bf, "")  // unk_data_blob PascalString
@@ -0,0 +1,51 @@
+package signserver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Solenataris/Erupe/common/fixtures"
+)
+
+func TestSignInRespSnapshot(t *testing.T) {
+	characters := []character{
+		{ID: 100, IsFemale: true, Name: "Hunter", HRP: 51, GR: 0, WeaponType: 3, LastLogin: 1650000000},
+		{ID: 101, IsNewCharacter: true, Name: "ハンター", HRP: 999, GR: 120, WeaponType: 11, LastLogin: 1660000000},
+	}
+	tests := []struct {
+		name string
+		resp signInResp
+	}{
+		{"no_characters", signInResp{tokenID: 1, token: "abcdefghijklmnop", entrance: "127.0.0.1:53310"}},
+		{"characters", signInResp{tokenID: 2, token: "ABCDEFGHIJKLMNOP", entrance: "127.0.0.1:53310", characters: characters}},
+		{"notices_max_hr", signInResp{
+			tokenID:       3,
+			token:         "qrstuvwxyzABCDEF",
+			entrance:      "203.0.113.5:53310",
+			characters:    characters[:1],
+			notices:       []string{shiftJISNotice("Welcome! Boosts expire on 2022-05-01.")},
+			maxLauncherHR: true,
+		}},
+	}
+	for _, tt := range tests {
+		fixtures.Compare(t, "signserver/sign_in_"+tt.name, buildSignInResp(tt.resp),
+			"DSGN sign in response, "+tt.name+", a snapshot written by TestSignInRespSnapshot rather than a client capture")
+	}
+}
+
+func TestRandSeq(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		token, err := randSeq(16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(token) != 16 || strings.Trim(token, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+			t.Fatalf("got token %q, want 16 letters", token)
+		}
+		if seen[token] {
+			t.Fatalf("token %q was issued twice", token)
+		}
+		seen[token] = true
+	}
+}
@@ -0,0 +1,191 @@
+package signserver
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Solenataris/Erupe/common/revocation"
+	"github.com/Solenataris/Erupe/config"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hash versions, stored in users.password_version.
+const (
+	hashVersionBcrypt   = 1
+	hashVersionArgon2id = 2
+)
+
+// argon2id parameters for new hashes, the first recommended option of RFC 9106 with less memory.
+const (
+	argon2Time    = 1
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+var errWrongPassword = errors.New("wrong password")
+
+// passwordHasher hashes passwords with the configured scheme.
+type passwordHasher struct {
+	scheme     string // "argon2id" or "bcrypt".
+	bcryptCost int
+}
+
+func (s *Server) passwordHasher() passwordHasher {
+	return passwordHasher{s.erupeConfig.Sign.PasswordHash, s.erupeConfig.Sign.BcryptCost}
+}
+
+func (h passwordHasher) version() int {
+	if h.scheme == "bcrypt" {
+		return hashVersionBcrypt
+	}
+	return hashVersionArgon2id
+}
+
+func (h passwordHasher) cost() int {
+	if h.bcryptCost < bcrypt.MinCost {
+		return bcrypt.DefaultCost
+	}
+	return h.bcryptCost
+}
+
+// hash hashes the password, returning the hash and its version.
+func (h passwordHasher) hash(password string) (string, int, error) {
+	if h.version() == hashVersionBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost())
+		return string(hash), hashVersionBcrypt, err
+	}
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", 0, err
+	}
+	key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), hashVersionArgon2id, nil
+}
+
+// needsUpgrade reports whether a stored hash is weaker than what the hasher would make now.
+func (h passwordHasher) needsUpgrade(hash string, version int) bool {
+	if version != h.version() {
+		return true
+	}
+	if version == hashVersionBcrypt {
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost < h.cost()
+	}
+	return false
+}
+
+// verifyPassword checks the password against a stored hash of the given version.
+func verifyPassword(hash string, version int, password string) bool {
+	switch version {
+	case hashVersionBcrypt:
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case hashVersionArgon2id:
+		return verifyArgon2id(hash, password)
+	}
+	return false
+}
+
+func verifyArgon2id(hash string, password string) bool {
+	// $argon2id$v=19$m=65536,t=1,p=4$salt$key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false
+	}
+	var version int
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false
+	}
+	got := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(got, key) == 1
+}
+
+// authenticate checks the account's password, returning sql.ErrNoRows if there's no such account and
+// errWrongPassword if it doesn't match. A matching password stored with a weaker hash is rehashed in
+// the same transaction.
+func (s *Server) authenticate(username string, password string) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var (
+		id      int
+		hash    string
+		version int
+	)
+	err = tx.QueryRow("SELECT id, password, password_version FROM users WHERE username = $1 FOR UPDATE", username).Scan(&id, &hash, &version)
+	if err != nil {
+		return 0, err
+	}
+	if !verifyPassword(hash, version, password) {
+		return 0, errWrongPassword
+	}
+
+	hasher := s.passwordHasher()
+	if hasher.needsUpgrade(hash, version) {
+		newHash, newVersion, err := hasher.hash(password)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec("UPDATE users SET password = $1, password_version = $2 WHERE id = $3", newHash, newVersion, id)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return id, tx.Commit()
+}
+
+// SetPassword replaces the account's password, returning sql.ErrNoRows if there's no such account.
+// Its sign in tokens and sessions are revoked in the same transaction, so whoever was signed in has
+// to sign in again with the new one.
+func SetPassword(db *sqlx.DB, erupeConfig *config.Config, userID uint32, password string) error {
+	hasher := passwordHasher{erupeConfig.Sign.PasswordHash, erupeConfig.Sign.BcryptCost}
+	hash, version, err := hasher.hash(password)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("UPDATE users SET password = $1, password_version = $2 WHERE id = $3", hash, version, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if err = revocation.Account(tx, userID, revocation.Credentials); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
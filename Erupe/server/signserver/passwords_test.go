@@ -0,0 +1,117 @@
+package signserver
+
+import (
+	"testing"
+
+	"github.com/Solenataris/Erupe/common/testdb"
+	"github.com/Solenataris/Erupe/config"
+	_ "github.com/lib/pq"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestNewAccountHash(t *testing.T) {
+	tests := []struct {
+		hasher  passwordHasher
+		version int
+	}{
+		{passwordHasher{scheme: "argon2id"}, hashVersionArgon2id},
+		{passwordHasher{scheme: "bcrypt", bcryptCost: bcrypt.MinCost}, hashVersionBcrypt},
+	}
+	for _, tt := range tests {
+		hash, version, err := tt.hasher.hash("hunter2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != tt.version {
+			t.Errorf("%s: got version %d, want %d", tt.hasher.scheme, version, tt.version)
+		}
+		if !verifyPassword(hash, version, "hunter2") {
+			t.Errorf("%s: password doesn't verify against its own hash", tt.hasher.scheme)
+		}
+		if verifyPassword(hash, version, "hunter3") {
+			t.Errorf("%s: wrong password verified", tt.hasher.scheme)
+		}
+		if tt.hasher.needsUpgrade(hash, version) {
+			t.Errorf("%s: fresh hash wants an upgrade", tt.hasher.scheme)
+		}
+	}
+}
+
+func TestLegacyHashUpgrade(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verifyPassword(string(legacy), hashVersionBcrypt, "hunter2") {
+		t.Fatal("legacy password doesn't verify")
+	}
+	if verifyPassword(string(legacy), hashVersionBcrypt, "hunter3") {
+		t.Error("wrong password verified against the legacy hash")
+	}
+
+	argon2id := passwordHasher{scheme: "argon2id"}
+	if !argon2id.needsUpgrade(string(legacy), hashVersionBcrypt) {
+		t.Error("legacy bcrypt hash isn't upgraded to argon2id")
+	}
+	upgraded, version, err := argon2id.hash("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verifyPassword(upgraded, version, "hunter2") {
+		t.Error("password doesn't verify after the upgrade")
+	}
+
+	if !(passwordHasher{scheme: "bcrypt", bcryptCost: bcrypt.MinCost + 1}).needsUpgrade(string(legacy), hashVersionBcrypt) {
+		t.Error("bcrypt hash below the configured cost isn't upgraded")
+	}
+	if (passwordHasher{scheme: "bcrypt", bcryptCost: bcrypt.MinCost}).needsUpgrade(string(legacy), hashVersionBcrypt) {
+		t.Error("bcrypt hash at the configured cost is upgraded")
+	}
+}
+
+func TestVerifyRejectsMalformedArgon2id(t *testing.T) {
+	for _, hash := range []string{"", "$argon2id$v=19$m=65536,t=1,p=4$c2FsdA", "$argon2i$v=19$m=65536,t=1,p=4$c2FsdA$a2V5"} {
+		if verifyPassword(hash, hashVersionArgon2id, "hunter2") {
+			t.Errorf("malformed hash %q verified", hash)
+		}
+	}
+}
+
+// TestAuthenticateUpgradesLegacyHash runs against the database in ERUPE_TEST_DB, e.g.
+// "host=localhost user=postgres password=admin dbname=erupe_test sslmode=disable".
+func TestAuthenticateUpgradesLegacyHash(t *testing.T) {
+	db := testdb.Open(t)
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var userID int
+	err = db.QueryRow("INSERT INTO users (username, password) VALUES ('password_test', $1) RETURNING id", string(legacy)).Scan(&userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM users WHERE id = $1", userID)
+
+	s := NewServer(&Config{Logger: zap.NewNop(), DB: db, ErupeConfig: &config.Config{Sign: config.Sign{PasswordHash: "argon2id"}}})
+	if _, err := s.authenticate("password_test", "hunter3"); err != errWrongPassword {
+		t.Fatalf("wrong password got %v, want errWrongPassword", err)
+	}
+	var version int
+	db.QueryRow("SELECT password_version FROM users WHERE id = $1", userID).Scan(&version)
+	if version != hashVersionBcrypt {
+		t.Fatal("hash was upgraded on a wrong password")
+	}
+
+	if id, err := s.authenticate("password_test", "hunter2"); err != nil || id != userID {
+		t.Fatalf("got (%d, %v), want (%d, nil)", id, err, userID)
+	}
+	db.QueryRow("SELECT password_version FROM users WHERE id = $1", userID).Scan(&version)
+	if version != hashVersionArgon2id {
+		t.Errorf("got password version %d after login, want %d", version, hashVersionArgon2id)
+	}
+	if _, err := s.authenticate("password_test", "hunter2"); err != nil {
+		t.Errorf("upgraded password failed to log in: %v", err)
+	}
+}
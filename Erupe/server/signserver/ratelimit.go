@@ -0,0 +1,166 @@
+package signserver
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// failureStore holds the failed sign ins of each IP address and account.
+type failureStore interface {
+	AddFailure(key string, at time.Time) error
+	CountFailures(key string, since time.Time) (int, error)
+	ClearFailures(key string) error
+}
+
+// memoryFailureStore keeps the failures of a single sign server.
+type memoryFailureStore struct {
+	sync.Mutex
+	window    time.Duration
+	failures  map[string][]time.Time
+	lastSweep time.Time
+}
+
+func newMemoryFailureStore(window time.Duration) *memoryFailureStore {
+	return &memoryFailureStore{window: window, failures: make(map[string][]time.Time)}
+}
+
+// AddFailure records the failure and, at most once a minute, drops keys whose failures no longer count.
+func (m *memoryFailureStore) AddFailure(key string, at time.Time) error {
+	m.Lock()
+	defer m.Unlock()
+	m.failures[key] = append(m.failures[key], at)
+	if at.Sub(m.lastSweep) > time.Minute {
+		for k, times := range m.failures {
+			if at.Sub(times[len(times)-1]) >= m.window {
+				delete(m.failures, k)
+			}
+		}
+		m.lastSweep = at
+	}
+	return nil
+}
+
+// CountFailures counts the failures after the given time, forgetting older ones.
+func (m *memoryFailureStore) CountFailures(key string, since time.Time) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+	times := m.failures[key]
+	for len(times) > 0 && !times[0].After(since) {
+		times = times[1:]
+	}
+	if len(times) == 0 {
+		delete(m.failures, key)
+	} else {
+		m.failures[key] = times
+	}
+	return len(times), nil
+}
+
+func (m *memoryFailureStore) ClearFailures(key string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.failures, key)
+	return nil
+}
+
+// dbFailureStore keeps the failures in the login_failures table, shared by every sign server using the DB.
+type dbFailureStore struct {
+	db     *sqlx.DB
+	window time.Duration
+}
+
+// AddFailure records the failure and drops the key's failures that no longer count.
+func (d dbFailureStore) AddFailure(key string, at time.Time) error {
+	if _, err := d.db.Exec("INSERT INTO login_failures (key, failed_at) VALUES ($1, $2)", key, at); err != nil {
+		return err
+	}
+	_, err := d.db.Exec("DELETE FROM login_failures WHERE key = $1 AND failed_at <= $2", key, at.Add(-d.window))
+	return err
+}
+
+func (d dbFailureStore) CountFailures(key string, since time.Time) (int, error) {
+	var count int
+	err := d.db.QueryRow("SELECT COUNT(*) FROM login_failures WHERE key = $1 AND failed_at > $2", key, since).Scan(&count)
+	return count, err
+}
+
+func (d dbFailureStore) ClearFailures(key string) error {
+	_, err := d.db.Exec("DELETE FROM login_failures WHERE key = $1", key)
+	return err
+}
+
+// loginLimiter locks out an IP address or account once it has too many failed sign ins within a sliding window.
+type loginLimiter struct {
+	sync.Mutex
+	store       failureStore
+	maxFailures int // 0 disables the limit, changed by a config reload.
+	window      time.Duration
+	now         func() time.Time
+}
+
+// limit returns the failures that lock an IP address or account out.
+func (l *loginLimiter) limit() int {
+	l.Lock()
+	defer l.Unlock()
+	return l.maxFailures
+}
+
+// setLimit changes the failures that lock an IP address or account out, for failures counted so far too.
+func (l *loginLimiter) setLimit(maxFailures int) {
+	l.Lock()
+	l.maxFailures = maxFailures
+	l.Unlock()
+}
+
+func ipFailureKey(ip string) string { return "ip:" + ip }
+
+func accountFailureKey(username string) string { return "user:" + username }
+
+// locked reports whether the IP address has reached the failure limit, or the account has and the IP address
+// has failed within the window too. An account under attack stays open to IP addresses without failures, so
+// guessing at it from elsewhere doesn't lock its owner out.
+func (l *loginLimiter) locked(ip string, username string) (bool, error) {
+	maxFailures := l.limit()
+	if maxFailures <= 0 {
+		return false, nil
+	}
+	since := l.now().Add(-l.window)
+	ipFailures, err := l.store.CountFailures(ipFailureKey(ip), since)
+	if err != nil {
+		return false, err
+	}
+	if ipFailures >= maxFailures {
+		return true, nil
+	}
+	if ipFailures == 0 {
+		return false, nil
+	}
+	accountFailures, err := l.store.CountFailures(accountFailureKey(username), since)
+	if err != nil {
+		return false, err
+	}
+	return accountFailures >= maxFailures, nil
+}
+
+// fail counts a failed sign in against both the IP address and the account.
+func (l *loginLimiter) fail(ip string, username string) error {
+	if l.limit() <= 0 {
+		return nil
+	}
+	now := l.now()
+	if err := l.store.AddFailure(ipFailureKey(ip), now); err != nil {
+		return err
+	}
+	return l.store.AddFailure(accountFailureKey(username), now)
+}
+
+// succeed clears the account's failures. The IP address's are left to age out,
+// otherwise signing in to an account of its own would let a client keep guessing at others.
+func (l *loginLimiter) succeed(username string) error {
+	if l.limit() <= 0 {
+		return nil
+	}
+	return l.store.ClearFailures(accountFailureKey(username))
+}
@@ -0,0 +1,119 @@
+package signserver
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestLimiter(now *time.Time) *loginLimiter {
+	return &loginLimiter{
+		store:       newMemoryFailureStore(5 * time.Minute),
+		maxFailures: 10,
+		window:      5 * time.Minute,
+		now:         func() time.Time { return *now },
+	}
+}
+
+func assertLocked(t *testing.T, l *loginLimiter, ip string, username string, want bool) {
+	t.Helper()
+	locked, err := l.locked(ip, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if locked != want {
+		t.Errorf("%s/%s: got locked %v, want %v", ip, username, locked, want)
+	}
+}
+
+func TestLoginLimiterLocksOutBurst(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	l := newTestLimiter(&now)
+
+	for i := 0; i < 9; i++ {
+		l.fail("10.0.0.1", "hunter")
+		now = now.Add(time.Second)
+	}
+	assertLocked(t, l, "10.0.0.1", "hunter", false)
+
+	l.fail("10.0.0.1", "hunter")
+	assertLocked(t, l, "10.0.0.1", "hunter", true)
+	// The IP address is locked out of every account, but the account stays open to IP addresses without failures.
+	assertLocked(t, l, "10.0.0.1", "other", true)
+	assertLocked(t, l, "10.0.0.2", "hunter", false)
+	assertLocked(t, l, "10.0.0.2", "other", false)
+
+	// Once one of them fails too, it's locked out of the account.
+	l.fail("10.0.0.2", "other")
+	assertLocked(t, l, "10.0.0.2", "hunter", true)
+	assertLocked(t, l, "10.0.0.2", "other", false)
+}
+
+func TestLoginLimiterSpreadAttack(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	l := newTestLimiter(&now)
+
+	// A guess each from ten IP addresses locks every one of them out of the account, but not its owner.
+	ips := []string{"10.0.1.0", "10.0.1.1", "10.0.1.2", "10.0.1.3", "10.0.1.4", "10.0.1.5", "10.0.1.6", "10.0.1.7", "10.0.1.8", "10.0.1.9"}
+	for _, ip := range ips {
+		l.fail(ip, "hunter")
+	}
+	for _, ip := range ips {
+		assertLocked(t, l, ip, "hunter", true)
+		assertLocked(t, l, ip, "other", false)
+	}
+	assertLocked(t, l, "10.0.0.1", "hunter", false)
+}
+
+func TestLoginLimiterWindowSlides(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	l := newTestLimiter(&now)
+
+	// Five failures a minute apart, then five more in a burst as the first one ages out.
+	for i := 0; i < 5; i++ {
+		l.fail("10.0.0.1", "hunter")
+		now = now.Add(time.Minute)
+	}
+	for i := 0; i < 5; i++ {
+		l.fail("10.0.0.1", "hunter")
+	}
+	assertLocked(t, l, "10.0.0.1", "hunter", false)
+
+	l.fail("10.0.0.1", "hunter")
+	assertLocked(t, l, "10.0.0.1", "hunter", true)
+
+	// A minute later the second failure has aged out too and the lockout lifts on its own.
+	now = now.Add(time.Minute)
+	assertLocked(t, l, "10.0.0.1", "hunter", false)
+}
+
+func TestLoginLimiterRecovery(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	l := newTestLimiter(&now)
+
+	for i := 0; i < 10; i++ {
+		l.fail("10.0.0.1", "hunter")
+	}
+	assertLocked(t, l, "10.0.0.1", "hunter", true)
+	now = now.Add(5*time.Minute - time.Second)
+	assertLocked(t, l, "10.0.0.1", "hunter", true)
+	now = now.Add(time.Second)
+	assertLocked(t, l, "10.0.0.1", "hunter", false)
+
+	// A successful sign in clears the account's failures but not the IP address's.
+	for i := 0; i < 10; i++ {
+		l.fail("10.0.0.1", "hunter")
+	}
+	l.succeed("hunter")
+	assertLocked(t, l, "10.0.0.2", "hunter", false)
+	assertLocked(t, l, "10.0.0.1", "other", true)
+}
+
+func TestLoginLimiterDisabled(t *testing.T) {
+	now := time.Now()
+	l := newTestLimiter(&now)
+	l.maxFailures = 0
+	for i := 0; i < 100; i++ {
+		l.fail("10.0.0.1", "hunter")
+	}
+	assertLocked(t, l, "10.0.0.1", "hunter", false)
+}
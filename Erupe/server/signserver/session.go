@@ -6,10 +6,11 @@ import (
 	"net"
 	"sync"
 
+	"github.com/Solenataris/Erupe/common/bfutil"
 	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/server/invite"
 	"github.com/Andoryuuta/byteframe"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // Session holds state for the sign server connection.
@@ -54,17 +55,32 @@ func (s *Session) handlePacket(pkt []byte) error {
 	case "DLTSKEYSIGN:100":
 		fallthrough
 	case "DSGN:100":
-		err := s.handleDSGNRequest(bf)
+		err := s.handleDSGNRequest(bf, reqType)
 		if err != nil {
 			return nil
 		}
 	case "DELETE:100":
 		loginTokenString := string(bf.ReadNullTerminatedBytes())
-		_ = loginTokenString
 		characterID := bf.ReadUint32()
+		// The token's number follows; a request without one is refused like a wrong token.
+		tokenID, err := bfutil.TryReadUint32(bf)
 
 		sugar.Infof("Got delete request for character ID: %v\n", characterID)
-		sugar.Infof("remaining unknown data:\n%s\n", hex.Dump(bf.DataFromCurrent()))
+		deleted := false
+		if err == nil {
+			deleted, err = s.server.deleteCharacter(characterID, tokenID, loginTokenString)
+			if err != nil {
+				s.logger.Error("Failed to delete character", zap.Error(err), zap.Uint32("charID", characterID))
+			}
+		}
+		// The client waits for a one byte result, 1 when the character was deleted.
+		result := []byte{0x00}
+		if deleted {
+			result[0] = 0x01
+		}
+		if err := s.cryptConn.SendPacket(result); err != nil {
+			return err
+		}
 	default:
 		sugar.Infof("Got unknown request type %s, data:\n%s\n", reqType, hex.Dump(bf.DataFromCurrent()))
 	}
@@ -72,16 +88,19 @@ func (s *Session) handlePacket(pkt []byte) error {
 	return nil
 }
 
-func (s *Session) handleDSGNRequest(bf *byteframe.ByteFrame) error {
+func (s *Session) handleDSGNRequest(bf *byteframe.ByteFrame, reqType string) error {
+	span := s.server.tracer.Start("sign in")
+	defer span.End()
 
 	reqUsername := string(bf.ReadNullTerminatedBytes())
 	reqPassword := string(bf.ReadNullTerminatedBytes())
 	reqUnk := string(bf.ReadNullTerminatedBytes())
 
+	span.SetAttr("username", reqUsername)
 	s.server.logger.Info(
 		"Got sign in request",
+		zap.String("traceID", span.TraceID()),
 		zap.String("reqUsername", reqUsername),
-		zap.String("reqPassword", reqPassword),
 		zap.String("reqUnk", reqUnk),
 	)
 
@@ -92,12 +111,25 @@ func (s *Session) handleDSGNRequest(bf *byteframe.ByteFrame) error {
 		newCharaReq = true
 	}
 
-	// TODO(Andoryuuta): remove plaintext password storage if this ever becomes more than a toy project.
-	var (
-		id       int
-		password string
-	)
-	err := s.server.db.QueryRow("SELECT id, password FROM users WHERE username = $1", reqUsername).Scan(&id, &password)
+	if active, err := s.server.maintenanceActive(); active {
+		s.logger.Info("Refused sign in during maintenance", zap.String("reqUsername", reqUsername))
+		return s.cryptConn.SendPacket(makeSignInFailureResp(SIGN_EMAINTE))
+	} else if err != nil {
+		s.logger.Warn("Failed to check maintenance mode", zap.Error(err))
+	}
+
+	ip, _, _ := net.SplitHostPort((*s.rawConn).RemoteAddr().String())
+	if locked, err := s.server.loginLimiter.locked(ip, reqUsername); locked || err != nil {
+		s.logger.Info("Refused sign in after too many failures", zap.String("ip", ip), zap.String("reqUsername", reqUsername), zap.Error(err))
+		return s.cryptConn.SendPacket(makeSignInFailureResp(SIGN_ECLOSE_EX))
+	}
+
+	auth := span.Child("db: authenticate")
+	id, err := s.server.authenticate(reqUsername, reqPassword)
+	if err != sql.ErrNoRows && err != errWrongPassword {
+		auth.SetError(err)
+	}
+	auth.End()
 	var serverRespBytes []byte
 	switch {
 	case err == sql.ErrNoRows:
@@ -105,8 +137,17 @@ func (s *Session) handleDSGNRequest(bf *byteframe.ByteFrame) error {
 		serverRespBytes = makeSignInFailureResp(SIGN_EAUTH)
 
 		// HACK(Andoryuuta): Create a new account if it doesn't exit.
-		s.logger.Info("Creating account", zap.String("reqUsername", reqUsername), zap.String("reqPassword", reqPassword))
-		err = s.server.registerDBAccount(reqUsername, reqPassword)
+		s.logger.Info("Creating account", zap.String("reqUsername", reqUsername))
+		password, inviteCode := reqPassword, ""
+		if s.server.erupeConfig.Sign.RequireInviteCode {
+			password, inviteCode = invite.SplitPassword(reqPassword, s.server.erupeConfig.Sign.InviteCodeDelimiter)
+		}
+		err = s.server.registerDBAccount(reqUsername, password, inviteCode)
+		if err == invite.ErrInvalid {
+			s.logger.Info("Refused registration with an invalid invite code", zap.String("reqUsername", reqUsername))
+			serverRespBytes = makeSignInFailureResp(SIGN_EILLEGAL)
+			break
+		}
 		if err != nil {
 			s.logger.Info("Error on creating new account", zap.Error(err))
 			serverRespBytes = makeSignInFailureResp(SIGN_EABORT)
@@ -121,33 +162,52 @@ func (s *Session) handleDSGNRequest(bf *byteframe.ByteFrame) error {
 			break
 		}
 
-		serverRespBytes = s.makeSignInResp(id)
+		serverRespBytes = s.makeSignInResp(id, span)
+		s.recordLogin(id, reqType)
 		break
+	case err == errWrongPassword:
+		s.logger.Info("Passwords don't match!")
+		serverRespBytes = makeSignInFailureResp(SIGN_EPASS)
+		if err = s.server.loginLimiter.fail(ip, reqUsername); err != nil {
+			s.logger.Warn("Failed to count failed sign in", zap.Error(err))
+		}
 	case err != nil:
 		serverRespBytes = makeSignInFailureResp(SIGN_EABORT)
 		s.logger.Warn("Got error on SQL query", zap.Error(err))
 		break
 	default:
-		if bcrypt.CompareHashAndPassword([]byte(password), []byte(reqPassword)) == nil {
-			s.logger.Info("Passwords match!")
-			if newCharaReq {
-				err = s.server.newUserChara(reqUsername)
-				if err != nil {
-					s.logger.Info("Error on adding new character to account", zap.Error(err))
-					serverRespBytes = makeSignInFailureResp(SIGN_EABORT)
-					break
-				}
+		s.logger.Info("Passwords match!")
+		if ban, err := s.server.getBan(id); ban != SIGN_SUCCESS {
+			s.logger.Info("Account is banned", zap.Int("uid", id), zap.Error(err))
+			serverRespBytes = makeSignInFailureResp(ban)
+			break
+		}
+		if newCharaReq {
+			err = s.server.newUserChara(reqUsername)
+			if err == errNoCharacterSlot {
+				// The client has no code for a full account, no rights is the closest.
+				s.logger.Info("Refused new character past the slot limit", zap.Int("uid", id))
+				serverRespBytes = makeSignInFailureResp(SIGN_ERIGHT)
+				break
+			}
+			if err != nil {
+				s.logger.Info("Error on adding new character to account", zap.Error(err))
+				serverRespBytes = makeSignInFailureResp(SIGN_EABORT)
+				break
 			}
-			serverRespBytes = s.makeSignInResp(id)
-		} else {
-			s.logger.Info("Passwords don't match!")
-			serverRespBytes = makeSignInFailureResp(SIGN_EPASS)
 		}
-
+		if err = s.server.loginLimiter.succeed(reqUsername); err != nil {
+			s.logger.Warn("Failed to clear failed sign ins", zap.Error(err))
+		}
+		serverRespBytes = s.makeSignInResp(id, span)
+		s.recordLogin(id, reqType)
 	}
 
+	span.SetAttr("uid", id)
+	span.SetAttr("result", serverRespBytes[0])
 	err = s.cryptConn.SendPacket(serverRespBytes)
 	if err != nil {
+		span.SetError(err)
 		return err
 	}
 
@@ -0,0 +1,56 @@
+package signserver
+
+import (
+	"net"
+	"testing"
+
+	"github.com/Andoryuuta/byteframe"
+	"github.com/Solenataris/Erupe/config"
+	"github.com/Solenataris/Erupe/network"
+	"go.uber.org/zap"
+)
+
+// signIn sends a sign in request to a session of the server and returns the response.
+func signIn(t *testing.T, s *Server, username string, password string) []byte {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	session := &Session{
+		logger:    s.logger,
+		server:    s,
+		rawConn:   &serverConn,
+		cryptConn: network.NewCryptConn(serverConn),
+	}
+
+	bf := byteframe.NewByteFrame()
+	bf.WriteNullTerminatedBytes([]byte(username))
+	bf.WriteNullTerminatedBytes([]byte(password))
+	bf.WriteNullTerminatedBytes([]byte("unk"))
+	bf.Seek(0, 0)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- session.handleDSGNRequest(bf, "DSGN:100")
+		serverConn.Close()
+	}()
+
+	resp, err := network.NewCryptConn(clientConn).ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestSignInRefusedDuringMaintenance(t *testing.T) {
+	s := NewServer(&Config{Logger: zap.NewNop(), ErupeConfig: &config.Config{}})
+	s.maintenanceActive = func() (bool, error) { return true, nil }
+
+	for _, username := range []string{"hunter", "hunter+"} {
+		resp := signIn(t, s, username, "password")
+		if len(resp) != 1 || RespID(resp[0]) != SIGN_EMAINTE {
+			t.Errorf("%s: got response %v, want the maintenance code %d", username, resp, SIGN_EMAINTE)
+		}
+	}
+}
@@ -5,9 +5,13 @@ import (
 	"io"
 	"net"
 	"sync"
+	"time"
 
+	"github.com/Solenataris/Erupe/common/tracing"
 	"github.com/Solenataris/Erupe/config"
 	"github.com/Solenataris/Erupe/network"
+	"github.com/Solenataris/Erupe/server/ipban"
+	"github.com/Solenataris/Erupe/server/lockdownserver"
 	"github.com/jmoiron/sqlx"
 	"go.uber.org/zap"
 )
@@ -17,6 +21,8 @@ type Config struct {
 	Logger      *zap.Logger
 	DB          *sqlx.DB
 	ErupeConfig *config.Config
+	IPBans      *ipban.List
+	Tracer      *tracing.Tracer
 }
 
 // Server is a MHF sign server.
@@ -27,8 +33,20 @@ type Server struct {
 	sid            int
 	sessions       map[int]*Session
 	db             *sqlx.DB
+	ipBans         *ipban.List
+	tracer         *tracing.Tracer
+	loginLimiter   *loginLimiter
 	listener       net.Listener
 	isShuttingDown bool
+
+	// Reports whether maintenance mode closed sign in, read from the DB so any process can set it.
+	maintenanceActive func() (bool, error)
+
+	// Deletes expired sign in tokens, see scheduleTokenPurge.
+	tokenPurgeTimer *time.Timer
+
+	// Purges deleted characters past the recovery window, see scheduleCharacterPurge.
+	characterPurgeTimer *time.Timer
 }
 
 // NewServer creates a new Server type.
@@ -39,6 +57,20 @@ func NewServer(config *Config) *Server {
 		sid:         0,
 		sessions:    make(map[int]*Session),
 		db:          config.DB,
+		ipBans:      config.IPBans,
+		tracer:      config.Tracer,
+	}
+	s.maintenanceActive = func() (bool, error) { return lockdownserver.Active(config.DB) }
+	window := time.Duration(config.ErupeConfig.Sign.LoginFailureWindow) * time.Second
+	var store failureStore = newMemoryFailureStore(window)
+	if config.ErupeConfig.Sign.SharedLoginFailures {
+		store = dbFailureStore{config.DB, window}
+	}
+	s.loginLimiter = &loginLimiter{
+		store:       store,
+		maxFailures: config.ErupeConfig.Current().Sign.LoginFailures,
+		window:      window,
+		now:         time.Now,
 	}
 	return s
 }
@@ -52,10 +84,17 @@ func (s *Server) Start() error {
 	s.listener = l
 
 	go s.acceptClients()
+	s.scheduleTokenPurge()
+	s.scheduleCharacterPurge()
 
 	return nil
 }
 
+// ConfigReloaded picks up a reloaded config, currently the login failure limit.
+func (s *Server) ConfigReloaded() {
+	s.loginLimiter.setLimit(s.erupeConfig.Current().Sign.LoginFailures)
+}
+
 // Shutdown exits the server gracefully.
 func (s *Server) Shutdown() {
 	s.logger.Debug("Shutting down")
@@ -63,6 +102,8 @@ func (s *Server) Shutdown() {
 	s.Lock()
 	s.isShuttingDown = true
 	s.Unlock()
+	s.stopTokenPurge()
+	s.stopCharacterPurge()
 
 	// This will cause the acceptor goroutine to error and exit gracefully.
 	s.listener.Close()
@@ -84,11 +125,34 @@ func (s *Server) acceptClients() {
 			}
 		}
 
+		if s.ipBans.Banned(conn.RemoteAddr()) {
+			s.logger.Info("Refused connection from banned IP", zap.String("remoteaddr", conn.RemoteAddr().String()))
+			if s.erupeConfig.Sign.IPBanMaintenance {
+				go s.refuseConnection(conn)
+			} else {
+				conn.Close()
+			}
+			continue
+		}
+
 		go s.handleConnection(s.sid, conn)
 		s.sid++
 	}
 }
 
+// refuseConnection answers a banned client's sign in with the maintenance message and closes the connection.
+func (s *Server) refuseConnection(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// Client initalizes the connection with a one-time buffer of 8 NULL bytes.
+	nullInit := make([]byte, 8)
+	if _, err := io.ReadFull(conn, nullInit); err != nil {
+		return
+	}
+	network.NewCryptConn(conn).SendPacket(makeSignInFailureResp(SIGN_EMAINTE))
+}
+
 func (s *Server) handleConnection(sid int, conn net.Conn) {
 	s.logger.Info("Got connection to sign server", zap.String("remoteaddr", conn.RemoteAddr().String()))
 
@@ -0,0 +1,61 @@
+package signserver
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// registerSignToken stores the sign in token the client presents to the channel server and returns its number.
+// The traceparent of the sign in is stored with it, "" when tracing is off.
+func (s *Server) registerSignToken(uid int, token string, traceparent string) (uint32, error) {
+	var id uint32
+	err := s.db.QueryRow(
+		"INSERT INTO sign_sessions (user_id, auth_token_str, issued_at, trace_parent) VALUES ($1, $2, now(), NULLIF($3, '')) RETURNING id",
+		uid, token, traceparent,
+	).Scan(&id)
+	return id, err
+}
+
+// tokenTTL is how long a sign in token can be used, 0 when tokens don't expire.
+func (s *Server) tokenTTL() time.Duration {
+	return time.Duration(s.erupeConfig.Sign.TokenTTL) * time.Second
+}
+
+// scheduleTokenPurge deletes the expired sign in tokens every TTL. The channel server deletes a token once
+// its session logs out.
+func (s *Server) scheduleTokenPurge() {
+	ttl := s.tokenTTL()
+	if ttl <= 0 {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.isShuttingDown {
+		return
+	}
+	s.tokenPurgeTimer = time.AfterFunc(ttl, func() {
+		s.purgeExpiredTokens()
+		s.scheduleTokenPurge()
+	})
+}
+
+// stopTokenPurge cancels the pending token purge.
+func (s *Server) stopTokenPurge() {
+	s.Lock()
+	defer s.Unlock()
+	if s.tokenPurgeTimer != nil {
+		s.tokenPurgeTimer.Stop()
+	}
+}
+
+func (s *Server) purgeExpiredTokens() {
+	res, err := s.db.Exec("DELETE FROM sign_sessions WHERE issued_at <= $1", time.Now().Add(-s.tokenTTL()))
+	if err != nil {
+		s.logger.Error("Failed to purge expired sign in tokens", zap.Error(err))
+		return
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		s.logger.Debug("Purged expired sign in tokens", zap.Int64("count", n))
+	}
+}
@@ -0,0 +1,105 @@
+// Command bandwidthreport fetches the Erupe metrics endpoint and prints the
+// opcodes that account for the most traffic, summed across all channels.
+//
+// Usage: go run ./tools/bandwidthreport -url http://localhost:9090/metrics
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type opcodeTotal struct {
+	opcode  string
+	in, out uint64
+}
+
+// parseLine extracts the opcode label and value from a per-opcode metrics line.
+func parseLine(line string) (metric string, opcode string, value uint64, ok bool) {
+	brace := strings.IndexByte(line, '{')
+	end := strings.LastIndexByte(line, '}')
+	if brace < 0 || end < brace {
+		return "", "", 0, false
+	}
+	metric = line[:brace]
+
+	for _, label := range strings.Split(line[brace+1:end], ",") {
+		if strings.HasPrefix(label, "opcode=") {
+			opcode = strings.Trim(strings.TrimPrefix(label, "opcode="), `"`)
+		}
+	}
+	if opcode == "" {
+		return "", "", 0, false
+	}
+
+	value, err := strconv.ParseUint(strings.TrimSpace(line[end+1:]), 10, 64)
+	if err != nil {
+		return "", "", 0, false
+	}
+	return metric, opcode, value, true
+}
+
+func main() {
+	url := flag.String("url", "http://localhost:9090/metrics", "metrics endpoint to read")
+	top := flag.Int("top", 10, "number of opcodes to show")
+	flag.Parse()
+
+	resp, err := http.Get(*url)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to fetch metrics:", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+
+	totals := make(map[string]*opcodeTotal)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		metric, opcode, value, ok := parseLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		t, exists := totals[opcode]
+		if !exists {
+			t = &opcodeTotal{opcode: opcode}
+			totals[opcode] = t
+		}
+		switch metric {
+		case "erupe_channel_opcode_bytes_in_total":
+			t.in += value
+		case "erupe_channel_opcode_bytes_out_total":
+			t.out += value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to read metrics:", err)
+		os.Exit(1)
+	}
+
+	var list []*opcodeTotal
+	var grandTotal uint64
+	for _, t := range totals {
+		list = append(list, t)
+		grandTotal += t.in + t.out
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].in+list[i].out > list[j].in+list[j].out
+	})
+	if len(list) > *top {
+		list = list[:*top]
+	}
+
+	fmt.Printf("%-4s %-40s %14s %14s %7s\n", "#", "OPCODE", "IN", "OUT", "SHARE")
+	for i, t := range list {
+		share := 0.0
+		if grandTotal > 0 {
+			share = float64(t.in+t.out) * 100 / float64(grandTotal)
+		}
+		fmt.Printf("%-4d %-40s %14d %14d %6.2f%%\n", i+1, t.opcode, t.in, t.out, share)
+	}
+}